@@ -1,12 +1,26 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	trisarl "github.com/rotationalio/trisa/pkg"
 	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/directory"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	gds "github.com/trisacrypto/trisa/pkg/trisa/gds/api/v1beta1"
 	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 func main() {
@@ -21,6 +35,12 @@ func main() {
 	app.Version = trisarl.Version()
 	app.Action = serve
 	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Aliases: []string{"c"},
+			Usage:   "path to a yaml or toml config file (environment variables take precedence)",
+			EnvVars: []string{"TRISA_CONFIG"},
+		},
 		&cli.StringFlag{
 			Name:    "addr",
 			Aliases: []string{"a"},
@@ -29,17 +49,563 @@ func main() {
 			EnvVars: []string{"TRISA_BIND_ADDR"},
 		},
 	}
-	app.Commands = []*cli.Command{}
+	app.Commands = []*cli.Command{
+		{
+			Name:     "serve",
+			Usage:    "run the trisa server",
+			Category: "server",
+			Action:   serve,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "addr",
+					Aliases: []string{"a"},
+					Usage:   "the address and port to bind the server on",
+					Value:   ":2384",
+					EnvVars: []string{"TRISA_BIND_ADDR"},
+				},
+			},
+		},
+		{
+			Name:      "register",
+			Usage:     "register the VASP with the directory service and install issued certificates",
+			UsageText: "trisarl register -d registration.json\n   trisarl register -i 250000.zip",
+			Category:  "directory",
+			Action:    register,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "data",
+					Aliases: []string{"d"},
+					Usage:   "JSON file with the registration request to submit to the directory",
+				},
+				&cli.StringFlag{
+					Name:    "install",
+					Aliases: []string{"i"},
+					Usage:   "install the certificates archive emailed by the directory service",
+				},
+				&cli.StringFlag{
+					Name:    "registration",
+					Aliases: []string{"r"},
+					Usage:   "path to the local registration record",
+					Value:   "registration.json",
+					EnvVars: []string{"TRISA_REGISTRATION"},
+				},
+				&cli.StringFlag{
+					Name:    "password",
+					Aliases: []string{"p"},
+					Usage:   "PKCS12 password, if not using the password in the registration record",
+				},
+				&cli.StringFlag{
+					Name:    "directory",
+					Aliases: []string{"D"},
+					Usage:   "the address of the directory service to register with",
+					Value:   "api.trisatest.net:443",
+					EnvVars: []string{"TRISA_DIRECTORY_ADDR"},
+				},
+				&cli.StringFlag{
+					Name:    "certs",
+					Usage:   "path to write the server certificates to",
+					EnvVars: []string{"TRISA_SERVER_CERTS"},
+				},
+				&cli.StringFlag{
+					Name:    "certpool",
+					Usage:   "path to write the server certificate pool to",
+					EnvVars: []string{"TRISA_SERVER_CERTPOOL"},
+				},
+				&cli.BoolFlag{
+					Name:    "yes",
+					Aliases: []string{"y"},
+					Usage:   "do not prompt for confirmation",
+				},
+			},
+		},
+		{
+			Name:      "backup",
+			Usage:     "create an encrypted archive of the local server state",
+			UsageText: "trisarl backup -o trisarl.bak",
+			Category:  "admin",
+			Action:    backup,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "out",
+					Aliases: []string{"o"},
+					Usage:   "path to write the backup archive to",
+					Value:   "trisarl.bak",
+				},
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+				&cli.StringFlag{
+					Name:    "passphrase",
+					Aliases: []string{"p"},
+					Usage:   "passphrase to encrypt the backup with",
+					EnvVars: []string{"TRISA_BACKUP_PASSPHRASE"},
+				},
+			},
+		},
+		{
+			Name:      "restore",
+			Usage:     "rehydrate the local server state from an encrypted backup archive",
+			UsageText: "trisarl restore -i trisarl.bak --create",
+			Category:  "admin",
+			Action:    restore,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "in",
+					Aliases:  []string{"i"},
+					Usage:    "path to the backup archive to restore from",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (must be empty)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+				&cli.BoolFlag{
+					Name:  "create",
+					Usage: "create the local state database if it does not exist",
+				},
+				&cli.StringFlag{
+					Name:    "passphrase",
+					Aliases: []string{"p"},
+					Usage:   "passphrase to decrypt the backup with",
+					EnvVars: []string{"TRISA_BACKUP_PASSPHRASE"},
+				},
+			},
+		},
+		{
+			Name:     "rekey",
+			Usage:    "re-encrypt the local server state with the current storage encryption, payload, and field keys",
+			Category: "admin",
+			Action:   rekey,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+			},
+		},
+		{
+			Name:     "rekey-envelopes",
+			Usage:    "open archived envelopes with old keys and keep them readable with the current payload or exchange key",
+			Category: "admin",
+			Action:   rekeyEnvelopes,
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:    "key",
+					Aliases: []string{"k"},
+					Usage:   "location of an old private key that envelopes were sealed with (can be repeated)",
+				},
+				&cli.BoolFlag{
+					Name:  "reseal",
+					Usage: "seal the envelopes again for the exchange key rather than keeping their payloads",
+				},
+				&cli.StringFlag{
+					Name:    "exchange-key",
+					Usage:   "location of the exchange key to reseal envelopes for (default is the generated key in the store)",
+					EnvVars: []string{"TRISA_EXCHANGE_KEY"},
+				},
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+			},
+		},
+		{
+			Name:     "sequences",
+			Usage:    "print the message sequence reconciliation report for each counterparty",
+			Category: "admin",
+			Action:   sequences,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+			},
+		},
+		{
+			Name:     "handshakes",
+			Usage:    "print the audit log of mTLS handshakes with peers",
+			Category: "admin",
+			Action:   handshakes,
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:    "since",
+					Aliases: []string{"s"},
+					Usage:   "only print the handshakes within the duration, e.g. 24h",
+				},
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+			},
+		},
+		{
+			Name:     "security-events",
+			Usage:    "print the audit log of messages from peers that failed security checks",
+			Category: "admin",
+			Action:   securityEvents,
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:    "since",
+					Aliases: []string{"s"},
+					Usage:   "only print the security events within the duration, e.g. 24h",
+				},
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+			},
+		},
+		{
+			Name:     "key-usage",
+			Usage:    "print the audit log of envelope keys opened, sealed, and advertised",
+			Category: "admin",
+			Action:   keyUsage,
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:    "since",
+					Aliases: []string{"s"},
+					Usage:   "only print the key usage within the duration, e.g. 24h",
+				},
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+			},
+		},
+		{
+			Name:     "dead-letters",
+			Usage:    "print the webhook events that could not be delivered",
+			Category: "admin",
+			Action:   deadLetters,
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:    "since",
+					Aliases: []string{"s"},
+					Usage:   "only print the dead letters within the duration, e.g. 24h",
+				},
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+			},
+		},
+		{
+			Name:     "envelopes",
+			Usage:    "print the log of secure envelopes received from and sent to peers",
+			Category: "admin",
+			Action:   envelopes,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "id",
+					Usage: "only print the envelopes with the envelope id",
+				},
+				&cli.DurationFlag{
+					Name:    "since",
+					Aliases: []string{"s"},
+					Usage:   "only print the envelopes within the duration, e.g. 24h",
+				},
+				&cli.BoolFlag{
+					Name:  "open",
+					Usage: "decrypt the payloads of incoming envelopes with the exchange keys in the store",
+				},
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+			},
+		},
+		{
+			Name:     "transactions",
+			Usage:    "print the state of the travel rule exchanges with peers",
+			Category: "admin",
+			Action:   transactions,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "state",
+					Usage: "only print the transactions in the state, e.g. pending_review",
+				},
+				&cli.StringFlag{
+					Name:  "settlement",
+					Usage: "only print the transactions whose settlement has the status, e.g. mismatch",
+				},
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+			},
+		},
+		{
+			Name:     "reconciliation",
+			Usage:    "print the latest report reconciling the transactions with their settlements on chain",
+			Category: "admin",
+			Action:   reconciliation,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+			},
+		},
+		{
+			Name:     "reviews",
+			Usage:    "print the transfers answered with a pending message that are waiting for a decision",
+			Category: "admin",
+			Action:   reviews,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+			},
+		},
+		{
+			Name:     "retries",
+			Usage:    "print the outgoing transfers that are scheduled to be retried",
+			Category: "admin",
+			Action:   retries,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+			},
+		},
+		{
+			Name:     "sunrise",
+			Usage:    "print the transfers sent by email to VASPs that are not in the TRISA directory",
+			Category: "admin",
+			Action:   sunrise,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "db",
+					Usage:   "path to the local state database (the server must be stopped)",
+					EnvVars: []string{"TRISA_STORAGE_PATH"},
+				},
+			},
+		},
+		{
+			Name:     "addresses",
+			Usage:    "manage the registry of wallet addresses that can receive transfers",
+			Category: "admin",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "list",
+					Usage:  "list the registered wallet addresses",
+					Action: listAddresses,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "db",
+							Usage:   "path to the local state database (the server must be stopped)",
+							EnvVars: []string{"TRISA_STORAGE_PATH"},
+						},
+					},
+				},
+				{
+					Name:      "add",
+					Usage:     "register a wallet address controlled by a customer account",
+					UsageText: "trisarl addresses add -n bitcoin -a 1BoatSLRHtKNngkdXEeobR76b53LETtpyT -A acct-42",
+					Action:    addAddress,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "network",
+							Aliases:  []string{"n"},
+							Usage:    "the chain or network of the wallet address",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:     "address",
+							Aliases:  []string{"a"},
+							Usage:    "the wallet address",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:    "account",
+							Aliases: []string{"A"},
+							Usage:   "the customer account that controls the address",
+						},
+						&cli.StringFlag{
+							Name:    "db",
+							Usage:   "path to the local state database (the server must be stopped)",
+							EnvVars: []string{"TRISA_STORAGE_PATH"},
+						},
+					},
+				},
+				{
+					Name:   "remove",
+					Usage:  "remove a wallet address from the registry",
+					Action: removeAddress,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "network",
+							Aliases:  []string{"n"},
+							Usage:    "the chain or network of the wallet address",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:     "address",
+							Aliases:  []string{"a"},
+							Usage:    "the wallet address",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:    "db",
+							Usage:   "path to the local state database (the server must be stopped)",
+							EnvVars: []string{"TRISA_STORAGE_PATH"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:     "customers",
+			Usage:    "manage the customer records of the accounts in the address registry",
+			Category: "admin",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "list",
+					Usage:  "list the customer records",
+					Action: listCustomers,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "db",
+							Usage:   "path to the local state database (the server must be stopped)",
+							EnvVars: []string{"TRISA_STORAGE_PATH"},
+						},
+					},
+				},
+				{
+					Name:      "add",
+					Usage:     "create or update the customer record of an account",
+					UsageText: "trisarl customers add -A acct-42 --name \"Jane Smith\"",
+					Action:    addCustomer,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "account",
+							Aliases:  []string{"A"},
+							Usage:    "the customer account that controls the registered addresses",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:  "name",
+							Usage: "the legal name of the customer",
+						},
+						&cli.BoolFlag{
+							Name:  "legal",
+							Usage: "the customer is a legal person rather than a natural person",
+						},
+						&cli.StringFlag{
+							Name:  "person",
+							Usage: "path to a JSON file with the IVMS101 person of the customer",
+						},
+						&cli.StringFlag{
+							Name:    "db",
+							Usage:   "path to the local state database (the server must be stopped)",
+							EnvVars: []string{"TRISA_STORAGE_PATH"},
+						},
+					},
+				},
+				{
+					Name:   "remove",
+					Usage:  "remove the customer record of an account",
+					Action: removeCustomer,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "account",
+							Aliases:  []string{"A"},
+							Usage:    "the customer account",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:    "db",
+							Usage:   "path to the local state database (the server must be stopped)",
+							EnvVars: []string{"TRISA_STORAGE_PATH"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:      "inquire",
+			Usage:     "ask a peer if it can receive transfers to a beneficiary wallet address",
+			UsageText: "trisarl inquire -p trisa.example.com -n bitcoin -a 1BoatSLRHtKNngkdXEeobR76b53LETtpyT",
+			Category:  "client",
+			Action:    inquire,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "peer",
+					Aliases:  []string{"p"},
+					Usage:    "common name of the beneficiary VASP",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "network",
+					Aliases:  []string{"n"},
+					Usage:    "the chain or network of the beneficiary address",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "address",
+					Aliases:  []string{"a"},
+					Usage:    "the beneficiary wallet address",
+					Required: true,
+				},
+			},
+		},
+		{
+			Name:     "keys",
+			Usage:    "manage the signing keys exchanged with TRISA peers",
+			Category: "admin",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "broadcast",
+					Usage:  "push the current signing key to every peer in the address book",
+					Action: broadcast,
+					Flags: []cli.Flag{
+						&cli.IntFlag{
+							Name:    "concurrency",
+							Aliases: []string{"n"},
+							Usage:   "maximum number of simultaneous key exchanges",
+							Value:   8,
+						},
+						&cli.StringSliceFlag{
+							Name:    "peer",
+							Aliases: []string{"p"},
+							Usage:   "common name of an additional peer to push the key to",
+						},
+					},
+				},
+			},
+		},
+	}
 
 	app.Run(os.Args)
 }
 
 func serve(c *cli.Context) (err error) {
 	var conf config.Config
-	if conf, err = config.New(); err != nil {
+	if conf, err = config.Load(c.String("config")); err != nil {
 		return cli.Exit(err, 1)
 	}
-	conf.BindAddr = c.String("addr")
+
+	// Only override the bind address if it was specified on the command line
+	if c.IsSet("addr") {
+		conf.BindAddr = c.String("addr")
+	}
 
 	var srv *trisarl.Server
 	if srv, err = trisarl.New(conf); err != nil {
@@ -51,3 +617,668 @@ func serve(c *cli.Context) (err error) {
 	}
 	return nil
 }
+
+func broadcast(c *cli.Context) (err error) {
+	var conf config.Config
+	if conf, err = config.Load(c.String("config")); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var srv *trisarl.Server
+	if srv, err = trisarl.New(conf); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer srv.Close()
+
+	var results []*trisarl.BroadcastResult
+	if results, err = srv.BroadcastKeys(context.Background(), c.Int("concurrency"), c.StringSlice("peer")...); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var failed int
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+
+	if err = printJSON(results); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return cli.Exit(fmt.Errorf("could not push signing key to %d of %d peers", failed, len(results)), 1)
+	}
+	return nil
+}
+
+func inquire(c *cli.Context) (err error) {
+	var conf config.Config
+	if conf, err = config.Load(c.String("config")); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var srv *trisarl.Server
+	if srv, err = trisarl.New(conf); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), directory.Timeout)
+	defer cancel()
+
+	var receipt *generic.ConfirmationReceipt
+	if receipt, err = srv.Inquire(ctx, c.String("peer"), c.String("network"), c.String("address")); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(receipt)
+}
+
+func register(c *cli.Context) (err error) {
+	if c.String("install") != "" {
+		return installCerts(c)
+	}
+
+	if c.String("data") == "" {
+		return cli.Exit("specify registration data to submit or a certificate archive to install", 1)
+	}
+
+	var req *gds.RegisterRequest
+	if req, err = directory.LoadRequest(c.String("data")); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	// Walk the operator through any missing required registration details
+	if req.CommonName == "" {
+		if req.CommonName, err = prompt("common name of the TRISA endpoint (e.g. trisa.example.com)"); err != nil {
+			return cli.Exit(err, 1)
+		}
+	}
+
+	if req.TrisaEndpoint == "" {
+		if req.TrisaEndpoint, err = prompt(fmt.Sprintf("TRISA endpoint [%s:443]", req.CommonName)); err != nil {
+			return cli.Exit(err, 1)
+		}
+		if req.TrisaEndpoint == "" {
+			req.TrisaEndpoint = req.CommonName + ":443"
+		}
+	}
+
+	// Check if this is a re-registration of a previously registered VASP
+	path := c.String("registration")
+	if prev, err := directory.LoadRegistration(path); err == nil {
+		fmt.Printf("previously registered %s as %s with %s on %s\n", prev.CommonName, prev.ID, prev.RegisteredDirectory, prev.RegisteredAt)
+	}
+
+	fmt.Printf("registering %s (%s) with %s\n", req.CommonName, req.TrisaEndpoint, c.String("directory"))
+	if !c.Bool("yes") {
+		if ok, err := confirm("submit registration?"); err != nil || !ok {
+			return cli.Exit("registration canceled", 1)
+		}
+	}
+
+	var client *directory.Client
+	if client, err = directory.New(c.String("directory")); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), directory.Timeout)
+	defer cancel()
+
+	var rep *gds.RegisterReply
+	if rep, err = client.Register(ctx, req); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	reg := directory.NewRegistration(rep)
+	if err = reg.Save(path); err != nil {
+		return cli.Exit(fmt.Errorf("could not save registration record: %s", err), 1)
+	}
+
+	fmt.Printf("registered %s as %s (status: %s)\n", reg.CommonName, reg.ID, reg.Status)
+	if reg.Message != "" {
+		fmt.Println(reg.Message)
+	}
+	fmt.Printf("registration record and PKCS12 password saved to %s\n", path)
+	fmt.Println("once your certificates have been issued, install them with trisarl register -i <archive>")
+	return nil
+}
+
+func installCerts(c *cli.Context) (err error) {
+	certs, pool := c.String("certs"), c.String("certpool")
+	if certs == "" {
+		return cli.Exit("specify the path to write the server certificates to", 1)
+	}
+
+	password := c.String("password")
+	if password == "" {
+		var reg *directory.Registration
+		if reg, err = directory.LoadRegistration(c.String("registration")); err != nil {
+			return cli.Exit(fmt.Errorf("could not load PKCS12 password from registration: %s", err), 1)
+		}
+		password = reg.PKCS12Password
+	}
+
+	if err = directory.InstallCertificates(c.String("install"), password, certs, pool); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("certificates installed to %s\n", certs)
+	if pool != "" && pool != certs {
+		fmt.Printf("certificate pool installed to %s\n", pool)
+	}
+	return nil
+}
+
+func backup(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var passphrase string
+	if passphrase, err = getPassphrase(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var f *os.File
+	if f, err = os.OpenFile(c.String("out"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer f.Close()
+
+	var nrecords uint64
+	if nrecords, err = db.Backup(f, passphrase); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("backed up %d records from %s to %s\n", nrecords, db.Path(), c.String("out"))
+	return nil
+}
+
+func rekey(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	if db.KeyID() == "" && db.PayloadKeyID() == "" && db.FieldKeyID() == "" {
+		return cli.Exit("neither storage encryption nor a payload or field key is configured", 1)
+	}
+
+	var nrecords uint64
+	if db.PayloadKeyID() != "" {
+		if nrecords, err = db.ResealPayloads(); err != nil {
+			return cli.Exit(err, 1)
+		}
+		fmt.Printf("resealed %d payloads in %s with payload key %s\n", nrecords, db.Path(), db.PayloadKeyID())
+	}
+
+	if db.FieldKeyID() != "" {
+		if nrecords, err = db.ResealFields(); err != nil {
+			return cli.Exit(err, 1)
+		}
+		fmt.Printf("resealed the fields of %d reviews in %s with field key %s\n", nrecords, db.Path(), db.FieldKeyID())
+	}
+
+	if db.KeyID() != "" {
+		if nrecords, err = db.Rekey(); err != nil {
+			return cli.Exit(err, 1)
+		}
+		fmt.Printf("re-encrypted %d records in %s with storage key %s\n", nrecords, db.Path(), db.KeyID())
+	}
+	return nil
+}
+
+func rekeyEnvelopes(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	opts := trisarl.RekeyOptions{
+		OldKeys:     c.StringSlice("key"),
+		Reseal:      c.Bool("reseal"),
+		ExchangeKey: c.String("exchange-key"),
+	}
+
+	var nrecords uint64
+	if nrecords, err = trisarl.RekeyEnvelopes(context.Background(), db, opts); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if opts.Reseal {
+		fmt.Printf("resealed %d envelopes in %s for the exchange key\n", nrecords, db.Path())
+	} else {
+		fmt.Printf("kept the payloads of %d envelopes in %s with payload key %s\n", nrecords, db.Path(), db.PayloadKeyID())
+	}
+	return nil
+}
+
+func restore(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var passphrase string
+	if passphrase, err = getPassphrase(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var f *os.File
+	if f, err = os.Open(c.String("in")); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer f.Close()
+
+	var nrecords uint64
+	if nrecords, err = db.Restore(f, passphrase); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("restored %d records from %s to %s\n", nrecords, c.String("in"), db.Path())
+	return nil
+}
+
+func sequences(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var report []*store.Sequence
+	if report, err = db.Sequences(); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(report)
+}
+
+func handshakes(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var since time.Time
+	if window := c.Duration("since"); window > 0 {
+		since = time.Now().Add(-window)
+	}
+
+	var records []*store.Handshake
+	if records, err = db.Handshakes(since); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(records)
+}
+
+func securityEvents(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var since time.Time
+	if window := c.Duration("since"); window > 0 {
+		since = time.Now().Add(-window)
+	}
+
+	var records []*store.SecurityEvent
+	if records, err = db.SecurityEvents(since); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(records)
+}
+
+func keyUsage(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var since time.Time
+	if window := c.Duration("since"); window > 0 {
+		since = time.Now().Add(-window)
+	}
+
+	var records []*store.KeyUsage
+	if records, err = db.KeyUsage(since); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(records)
+}
+
+func deadLetters(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var since time.Time
+	if window := c.Duration("since"); window > 0 {
+		since = time.Now().Add(-window)
+	}
+
+	var records []*store.DeadLetter
+	if records, err = db.DeadLetters(since); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(records)
+}
+
+func envelopes(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var since time.Time
+	if window := c.Duration("since"); window > 0 {
+		since = time.Now().Add(-window)
+	}
+
+	var records []*store.Envelope
+	if records, err = db.Envelopes(c.String("id"), since); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	// Envelopes whose payload was not persisted are opened with the archived keys
+	if c.Bool("open") {
+		for _, record := range records {
+			if record.Direction != store.Incoming || len(record.Payload) > 0 {
+				continue
+			}
+
+			env, err := trisarl.OpenArchivedEnvelope(db, record)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not open envelope %s: %s\n", record.ID, err)
+				continue
+			}
+
+			if record.Payload, err = protojson.Marshal(env.Payload); err != nil {
+				return cli.Exit(err, 1)
+			}
+		}
+	}
+	return printJSON(records)
+}
+
+func transactions(c *cli.Context) (err error) {
+	state := store.State(c.String("state"))
+	if state != "" && !state.Valid() {
+		return cli.Exit(fmt.Errorf("unknown transaction state %q", state), 1)
+	}
+
+	settlement := store.SettlementStatus(c.String("settlement"))
+	if settlement != "" && !settlement.Valid() {
+		return cli.Exit(fmt.Errorf("unknown settlement status %q", settlement), 1)
+	}
+
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var records []*store.Transaction
+	if records, err = db.Transactions(state); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if settlement != "" {
+		filtered := make([]*store.Transaction, 0, len(records))
+		for _, tx := range records {
+			if tx.Settlement != nil && tx.Settlement.Status == settlement {
+				filtered = append(filtered, tx)
+			}
+		}
+		records = filtered
+	}
+	return printJSON(records)
+}
+
+func reconciliation(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var report *store.Reconciliation
+	if report, err = db.GetReconciliation(); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(report)
+}
+
+func reviews(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var records []*store.Review
+	if records, err = db.Reviews(); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(records)
+}
+
+func retries(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var records []*store.Retry
+	if records, err = db.Retries(time.Time{}); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(records)
+}
+
+func sunrise(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var msgs []*store.Sunrise
+	if msgs, err = db.SunriseMessages(); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(msgs)
+}
+
+func listAddresses(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var addrs []*store.Address
+	if addrs, err = db.Addresses(); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(addrs)
+}
+
+func addAddress(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	addr := &store.Address{
+		Network: c.String("network"),
+		Address: c.String("address"),
+		Account: c.String("account"),
+	}
+	if err = db.PutAddress(addr); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(addr)
+}
+
+func removeAddress(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	if err = db.DeleteAddress(c.String("network"), c.String("address")); err != nil {
+		return cli.Exit(err, 1)
+	}
+	fmt.Printf("removed %s\n", store.AddressKey(c.String("network"), c.String("address")))
+	return nil
+}
+
+func listCustomers(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	var customers []*store.Customer
+	if customers, err = db.Customers(); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(customers)
+}
+
+func addCustomer(c *cli.Context) (err error) {
+	customer := &store.Customer{
+		Account: c.String("account"),
+		Name:    c.String("name"),
+		Legal:   c.Bool("legal"),
+	}
+
+	if path := c.String("person"); path != "" {
+		if customer.Person, err = ioutil.ReadFile(path); err != nil {
+			return cli.Exit(err, 1)
+		}
+
+		// Validate the person before it is stored so that transfers are not answered
+		// with a beneficiary that cannot be decoded.
+		if err = protojson.Unmarshal(customer.Person, &ivms101.Person{}); err != nil {
+			return cli.Exit(fmt.Errorf("could not parse IVMS101 person: %s", err), 1)
+		}
+	}
+
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	if err = db.PutCustomer(customer); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printJSON(customer)
+}
+
+func removeCustomer(c *cli.Context) (err error) {
+	var db *store.Store
+	if db, err = openStore(c); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer db.Close()
+
+	if err = db.DeleteCustomer(c.String("account")); err != nil {
+		return cli.Exit(err, 1)
+	}
+	fmt.Printf("removed %s\n", c.String("account"))
+	return nil
+}
+
+func openStore(c *cli.Context) (*store.Store, error) {
+	if c.String("db") == "" {
+		return nil, errors.New("specify the path to the local state database")
+	}
+
+	// A mistyped path must not be created as an empty database unless requested
+	open := store.OpenExisting
+	if c.Bool("create") {
+		open = store.Open
+	}
+
+	// The storage encryption settings are read from the environment like the server
+	return open(config.StorageConfig{
+		Path:                c.String("db"),
+		EncryptionKey:       os.Getenv("TRISA_STORAGE_ENCRYPTION_KEY"),
+		Passphrase:          os.Getenv("TRISA_STORAGE_PASSPHRASE"),
+		PreviousKeys:        os.Getenv("TRISA_STORAGE_PREVIOUS_KEYS"),
+		PayloadKey:          os.Getenv("TRISA_STORAGE_PAYLOAD_KEY"),
+		PreviousPayloadKeys: os.Getenv("TRISA_STORAGE_PREVIOUS_PAYLOAD_KEYS"),
+		FieldKey:            os.Getenv("TRISA_STORAGE_FIELD_KEY"),
+		PreviousFieldKeys:   os.Getenv("TRISA_STORAGE_PREVIOUS_FIELD_KEYS"),
+		EncryptedFields:     os.Getenv("TRISA_STORAGE_ENCRYPTED_FIELDS"),
+	})
+}
+
+func getPassphrase(c *cli.Context) (passphrase string, err error) {
+	if passphrase = c.String("passphrase"); passphrase != "" {
+		return passphrase, nil
+	}
+
+	if passphrase, err = prompt("backup passphrase"); err != nil {
+		return "", err
+	}
+
+	if passphrase == "" {
+		return "", errors.New("a passphrase is required")
+	}
+	return passphrase, nil
+}
+
+func printJSON(v interface{}) (err error) {
+	var data []byte
+	if data, err = json.MarshalIndent(v, "", "  "); err != nil {
+		return cli.Exit(err, 1)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func prompt(question string) (_ string, err error) {
+	fmt.Printf("%s: ", question)
+	reader := bufio.NewReader(os.Stdin)
+
+	var answer string
+	if answer, err = reader.ReadString('\n'); err != nil {
+		return "", errors.New("could not read response")
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+func confirm(question string) (_ bool, err error) {
+	var answer string
+	if answer, err = prompt(question + " [y/N]"); err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
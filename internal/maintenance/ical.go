@@ -0,0 +1,142 @@
+package maintenance
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readCalendar loads the VEVENT entries of an iCalendar file as one-off maintenance
+// windows. Only DTSTART, DTEND, and DURATION are used; recurrence rules are not
+// expanded, so recurring maintenance should be specified with a cron expression.
+func readCalendar(path string) (_ fixed, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		events   fixed
+		event    *Window
+		duration time.Duration
+		lines    []string
+	)
+
+	// Unfold lines that are continued with leading whitespace (RFC 5545 3.1)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, line := range lines {
+		name, params, value := splitProperty(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event, duration = &Window{}, 0
+		case name == "END" && value == "VEVENT" && event != nil:
+			if event.End.IsZero() {
+				event.End = event.Start.Add(duration)
+			}
+			if event.Start.IsZero() || !event.End.After(event.Start) {
+				return nil, fmt.Errorf("line %d: event requires a start before its end", i+1)
+			}
+			events = append(events, *event)
+			event = nil
+		case event == nil:
+			continue
+		case name == "DTSTART":
+			if event.Start, err = parseTime(value, params); err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err)
+			}
+		case name == "DTEND":
+			if event.End, err = parseTime(value, params); err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err)
+			}
+		case name == "DURATION":
+			if duration, err = parseDuration(value); err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err)
+			}
+		}
+	}
+
+	events.sort()
+	return events, nil
+}
+
+// splitProperty splits a content line into its name, parameters, and value.
+func splitProperty(line string) (name string, params map[string]string, value string) {
+	idx := strings.Index(line, ":")
+	if idx < 0 {
+		return "", nil, ""
+	}
+
+	parts := strings.Split(line[:idx], ";")
+	name = strings.ToUpper(parts[0])
+	params = make(map[string]string, len(parts)-1)
+	for _, param := range parts[1:] {
+		if kv := strings.SplitN(param, "=", 2); len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return name, params, strings.TrimSpace(line[idx+1:])
+}
+
+// parseTime handles UTC, floating (local), TZID qualified, and all-day date values.
+func parseTime(value string, params map[string]string) (_ time.Time, err error) {
+	loc := time.Local
+	if tzid, ok := params["TZID"]; ok {
+		if loc, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	case params["VALUE"] == "DATE" || len(value) == 8:
+		return time.ParseInLocation("20060102", value, loc)
+	default:
+		return time.ParseInLocation("20060102T150405", value, loc)
+	}
+}
+
+var durationRE = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseDuration parses an RFC 5545 duration value such as PT2H or P1DT12H.
+func parseDuration(value string) (d time.Duration, err error) {
+	match := durationRE.FindStringSubmatch(value)
+	if match == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("could not parse duration %q", value)
+	}
+
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	for i, unit := range units {
+		if match[i+2] == "" {
+			continue
+		}
+
+		var n int64
+		if n, err = strconv.ParseInt(match[i+2], 10, 64); err != nil {
+			return 0, err
+		}
+		d += time.Duration(n) * unit
+	}
+
+	if match[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
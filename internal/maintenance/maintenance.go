@@ -0,0 +1,195 @@
+/*
+Package maintenance parses planned maintenance windows so that the server can advertise
+its maintenance status to TRISA peers ahead of time. Windows are specified either as a
+cron expression followed by the duration of the window, e.g. "0 2 * * SUN 2h" for two
+hours every Sunday at 2am, as a one-off window with RFC 3339 start and end times
+separated by a slash, e.g. "2021-06-01T02:00:00Z/2021-06-01T04:00:00Z", or as the path
+to an iCalendar (.ics) file whose events are the maintenance windows.
+*/
+package maintenance
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Window is a single period of planned maintenance.
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Contains returns true if the timestamp is within the maintenance window.
+func (w Window) Contains(ts time.Time) bool {
+	return !ts.Before(w.Start) && ts.Before(w.End)
+}
+
+// IsZero returns true if the window has not been set.
+func (w Window) IsZero() bool {
+	return w.Start.IsZero() && w.End.IsZero()
+}
+
+// Schedule is a set of maintenance windows that may be recurring or one-off.
+type Schedule struct {
+	sources []source
+}
+
+// source produces the first maintenance window that ends after the specified time.
+type source interface {
+	next(after time.Time) (Window, bool)
+}
+
+// Parse the maintenance window specifications into a schedule. An empty schedule is
+// returned if no specifications are passed in, which never has maintenance windows.
+func Parse(specs ...string) (_ *Schedule, err error) {
+	s := &Schedule{sources: make([]source, 0, len(specs))}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		var src source
+		if strings.HasSuffix(strings.ToLower(spec), ".ics") {
+			if src, err = readCalendar(spec); err != nil {
+				return nil, fmt.Errorf("could not read maintenance calendar %q: %s", spec, err)
+			}
+		} else if window, ok, perr := parseRange(spec); ok {
+			if perr != nil {
+				return nil, fmt.Errorf("could not parse maintenance window %q: %s", spec, perr)
+			}
+			src = fixed{window}
+		} else {
+			if src, err = parseCron(spec); err != nil {
+				return nil, fmt.Errorf("could not parse maintenance window %q: %s", spec, err)
+			}
+		}
+		s.sources = append(s.sources, src)
+	}
+	return s, nil
+}
+
+// Split a list of maintenance window specifications separated by semicolons. Commas
+// cannot be used to separate windows since they are used in cron expressions.
+func Split(specs string) []string {
+	parts := strings.Split(specs, ";")
+	out := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// Next returns the maintenance window that contains now, or if the server is not in
+// maintenance, the next upcoming maintenance window. If there are no current or future
+// maintenance windows, false is returned. Overlapping windows are not merged.
+func (s *Schedule) Next(now time.Time) (next Window, ok bool) {
+	if s == nil {
+		return Window{}, false
+	}
+
+	for _, src := range s.sources {
+		if w, found := src.next(now); found {
+			if !ok || w.Start.Before(next.Start) {
+				next, ok = w, true
+			}
+		}
+	}
+	return next, ok
+}
+
+// Active returns true if now is within a maintenance window.
+func (s *Schedule) Active(now time.Time) bool {
+	w, ok := s.Next(now)
+	return ok && w.Contains(now)
+}
+
+// Len returns the number of window specifications in the schedule.
+func (s *Schedule) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.sources)
+}
+
+// recurring windows start according to a cron schedule and last for a fixed duration.
+type recurring struct {
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+// The last field of the spec is the duration, the rest is a standard cron expression
+// (minute, hour, day of month, month, day of week) or a descriptor such as @weekly.
+func parseCron(spec string) (_ *recurring, err error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("a cron expression and duration are required")
+	}
+
+	r := &recurring{}
+	if r.duration, err = time.ParseDuration(fields[len(fields)-1]); err != nil {
+		return nil, err
+	}
+	if r.duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive")
+	}
+
+	if r.schedule, err = cron.ParseStandard(strings.Join(fields[:len(fields)-1], " ")); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *recurring) next(after time.Time) (Window, bool) {
+	// The window that contains after started at most duration ago
+	start := r.schedule.Next(after.Add(-r.duration))
+	if start.IsZero() {
+		return Window{}, false
+	}
+	return Window{Start: start, End: start.Add(r.duration)}, true
+}
+
+// parseRange parses a one-off window of RFC 3339 start and end times separated by a
+// slash. If the spec does not start with a timestamp it is not a range and false is
+// returned so that it can be parsed as a cron expression (which may contain slashes).
+func parseRange(spec string) (w Window, ok bool, err error) {
+	parts := strings.Split(spec, "/")
+	if len(parts) != 2 {
+		return w, false, nil
+	}
+
+	if w.Start, err = time.Parse(time.RFC3339, strings.TrimSpace(parts[0])); err != nil {
+		return w, false, nil
+	}
+
+	if w.End, err = time.Parse(time.RFC3339, strings.TrimSpace(parts[1])); err != nil {
+		return w, true, err
+	}
+
+	if !w.End.After(w.Start) {
+		return w, true, fmt.Errorf("window must end after it starts")
+	}
+	return w, true, nil
+}
+
+// fixed windows are one-off maintenance windows, sorted by start time.
+type fixed []Window
+
+func (f fixed) next(after time.Time) (Window, bool) {
+	for _, w := range f {
+		if w.End.After(after) {
+			return w, true
+		}
+	}
+	return Window{}, false
+}
+
+func (f fixed) sort() {
+	sort.Slice(f, func(i, j int) bool { return f[i].Start.Before(f[j].Start) })
+}
@@ -0,0 +1,58 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	// Sundays at 2am UTC for two hours, and a one-off window on Wednesday
+	schedule, err := Parse(Split("CRON_TZ=UTC 0 2 * * SUN 2h; 2021-06-02T10:00:00Z/2021-06-02T11:00:00Z")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedule.Len() != 2 {
+		t.Fatalf("expected 2 windows, got %d", schedule.Len())
+	}
+
+	tests := []struct {
+		now    string
+		start  string
+		active bool
+	}{
+		{"2021-06-01T00:00:00Z", "2021-06-02T10:00:00Z", false},
+		{"2021-06-02T10:30:00Z", "2021-06-02T10:00:00Z", true},
+		{"2021-06-02T11:00:00Z", "2021-06-06T02:00:00Z", false},
+		{"2021-06-06T03:59:59Z", "2021-06-06T02:00:00Z", true},
+		{"2021-06-06T04:00:00Z", "2021-06-13T02:00:00Z", false},
+	}
+
+	for _, tc := range tests {
+		now, _ := time.Parse(time.RFC3339, tc.now)
+		window, ok := schedule.Next(now)
+		if !ok || window.Start.Format(time.RFC3339) != tc.start {
+			t.Errorf("%s: expected the next window at %s, got %s", tc.now, tc.start, window.Start.Format(time.RFC3339))
+		}
+		if schedule.Active(now) != tc.active {
+			t.Errorf("%s: expected active to be %t", tc.now, tc.active)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"0 2 * * SUN",
+		"0 2 * * SUN -1h",
+		"2021-06-02T11:00:00Z/2021-06-02T10:00:00Z",
+		"2021-06-02T10:00:00Z/tomorrow",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("expected %q not to parse", spec)
+		}
+	}
+
+	var empty *Schedule
+	if _, ok := empty.Next(time.Now()); ok || empty.Len() != 0 {
+		t.Error("expected a nil schedule not to have windows")
+	}
+}
@@ -0,0 +1,293 @@
+/*
+Package otlp exports OpenTelemetry spans to a collector with the OTLP/HTTP protocol
+using the JSON encoding, e.g. to the OpenTelemetry collector of a service mesh. The
+JSON encoding is used so that exporting spans does not require the OTLP protobufs or a
+second gRPC client alongside the TRISA client.
+*/
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// TracesPath is appended to the collector endpoint to export spans.
+const TracesPath = "/v1/traces"
+
+// Timeout is the maximum amount of time to wait for the collector to accept spans.
+const Timeout = 10 * time.Second
+
+// Exporter posts batches of spans to the traces endpoint of an OTLP/HTTP collector.
+type Exporter struct {
+	sync.RWMutex
+	url    string
+	client *http.Client
+	closed bool
+}
+
+// Ensure the Exporter can be used by the tracer provider of the SDK
+var _ sdktrace.SpanExporter = &Exporter{}
+
+// New creates an exporter for the collector endpoint, e.g. http://localhost:4318.
+func New(endpoint string) *Exporter {
+	return &Exporter{
+		url:    strings.TrimSuffix(endpoint, "/") + TracesPath,
+		client: &http.Client{Timeout: Timeout},
+	}
+}
+
+// ExportSpans implements sdktrace.SpanExporter
+func (e *Exporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) (err error) {
+	e.RLock()
+	defer e.RUnlock()
+	if e.closed || len(spans) == 0 {
+		return nil
+	}
+
+	var body []byte
+	if body, err = json.Marshal(request(spans)); err != nil {
+		return err
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body)); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var rep *http.Response
+	if rep, err = e.client.Do(req); err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode < 200 || rep.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(rep.Body, 512))
+		return fmt.Errorf("collector responded with %s: %s", rep.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Shutdown implements sdktrace.SpanExporter
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	e.Lock()
+	defer e.Unlock()
+	e.closed = true
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// The JSON encoding of the OTLP ExportTraceServiceRequest; trace and span IDs are hex
+// encoded rather than base64 encoded and 64-bit integers are encoded as strings.
+type (
+	exportRequest struct {
+		ResourceSpans []*resourceSpans `json:"resourceSpans"`
+	}
+
+	resourceSpans struct {
+		Resource   resourceJSON  `json:"resource"`
+		ScopeSpans []*scopeSpans `json:"scopeSpans"`
+	}
+
+	resourceJSON struct {
+		Attributes []keyValue `json:"attributes,omitempty"`
+	}
+
+	scopeSpans struct {
+		Scope scope  `json:"scope"`
+		Spans []span `json:"spans"`
+	}
+
+	scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+
+	span struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Events            []event    `json:"events,omitempty"`
+		Status            status     `json:"status"`
+	}
+
+	event struct {
+		TimeUnixNano string     `json:"timeUnixNano"`
+		Name         string     `json:"name"`
+		Attributes   []keyValue `json:"attributes,omitempty"`
+	}
+
+	status struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+
+	keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+
+	anyValue struct {
+		StringValue *string     `json:"stringValue,omitempty"`
+		BoolValue   *bool       `json:"boolValue,omitempty"`
+		IntValue    *string     `json:"intValue,omitempty"`
+		DoubleValue *float64    `json:"doubleValue,omitempty"`
+		ArrayValue  *arrayValue `json:"arrayValue,omitempty"`
+	}
+
+	arrayValue struct {
+		Values []anyValue `json:"values"`
+	}
+)
+
+// request groups the spans by their resource and instrumentation library.
+func request(spans []sdktrace.ReadOnlySpan) *exportRequest {
+	req := &exportRequest{}
+	resources := make(map[attribute.Distinct]*resourceSpans)
+	scopes := make(map[attribute.Distinct]map[instrumentation.Library]*scopeSpans)
+
+	for _, s := range spans {
+		res := s.Resource()
+		if res == nil {
+			res = resource.Empty()
+		}
+
+		key := res.Equivalent()
+		rs, ok := resources[key]
+		if !ok {
+			rs = &resourceSpans{Resource: resourceJSON{Attributes: attributes(res.Attributes())}}
+			resources[key] = rs
+			scopes[key] = make(map[instrumentation.Library]*scopeSpans)
+			req.ResourceSpans = append(req.ResourceSpans, rs)
+		}
+
+		lib := s.InstrumentationLibrary()
+		ss, ok := scopes[key][lib]
+		if !ok {
+			ss = &scopeSpans{Scope: scope{Name: lib.Name, Version: lib.Version}}
+			scopes[key][lib] = ss
+			rs.ScopeSpans = append(rs.ScopeSpans, ss)
+		}
+		ss.Spans = append(ss.Spans, encode(s))
+	}
+	return req
+}
+
+func encode(s sdktrace.ReadOnlySpan) span {
+	sc := s.SpanContext()
+	traceID, spanID := sc.TraceID(), sc.SpanID()
+	out := span{
+		TraceID:           hex.EncodeToString(traceID[:]),
+		SpanID:            hex.EncodeToString(spanID[:]),
+		Name:              s.Name(),
+		Kind:              int(s.SpanKind()),
+		StartTimeUnixNano: unixNano(s.StartTime()),
+		EndTimeUnixNano:   unixNano(s.EndTime()),
+		Attributes:        attributes(s.Attributes()),
+		Status:            encodeStatus(s.Status()),
+	}
+
+	if parent := s.Parent(); parent.HasSpanID() {
+		parentID := parent.SpanID()
+		out.ParentSpanID = hex.EncodeToString(parentID[:])
+	}
+
+	for _, e := range s.Events() {
+		out.Events = append(out.Events, event{
+			TimeUnixNano: unixNano(e.Time),
+			Name:         e.Name,
+			Attributes:   attributes(e.Attributes),
+		})
+	}
+	return out
+}
+
+// The OTLP status codes are ordered differently than the codes of the API.
+func encodeStatus(s sdktrace.Status) status {
+	switch s.Code {
+	case codes.Ok:
+		return status{Code: 1}
+	case codes.Error:
+		return status{Code: 2, Message: s.Description}
+	default:
+		return status{}
+	}
+}
+
+func attributes(kvs []attribute.KeyValue) []keyValue {
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	out := make([]keyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		out = append(out, keyValue{Key: string(kv.Key), Value: value(kv.Value)})
+	}
+	return out
+}
+
+func value(v attribute.Value) (out anyValue) {
+	switch v.Type() {
+	case attribute.BOOL:
+		b := v.AsBool()
+		out.BoolValue = &b
+	case attribute.INT64:
+		i := strconv.FormatInt(v.AsInt64(), 10)
+		out.IntValue = &i
+	case attribute.FLOAT64:
+		f := v.AsFloat64()
+		out.DoubleValue = &f
+	case attribute.BOOLSLICE:
+		out.ArrayValue = &arrayValue{}
+		for _, b := range v.AsBoolSlice() {
+			out.ArrayValue.Values = append(out.ArrayValue.Values, value(attribute.BoolValue(b)))
+		}
+	case attribute.INT64SLICE:
+		out.ArrayValue = &arrayValue{}
+		for _, i := range v.AsInt64Slice() {
+			out.ArrayValue.Values = append(out.ArrayValue.Values, value(attribute.Int64Value(i)))
+		}
+	case attribute.FLOAT64SLICE:
+		out.ArrayValue = &arrayValue{}
+		for _, f := range v.AsFloat64Slice() {
+			out.ArrayValue.Values = append(out.ArrayValue.Values, value(attribute.Float64Value(f)))
+		}
+	case attribute.STRINGSLICE:
+		out.ArrayValue = &arrayValue{}
+		for _, s := range v.AsStringSlice() {
+			out.ArrayValue.Values = append(out.ArrayValue.Values, value(attribute.StringValue(s)))
+		}
+	default:
+		s := v.Emit()
+		out.StringValue = &s
+	}
+	return out
+}
+
+func unixNano(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
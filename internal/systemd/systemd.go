@@ -0,0 +1,76 @@
+/*
+Package systemd implements the sd_notify protocol so that the server can report its
+state to systemd when it is run as a Type=notify service, and send keepalives to the
+systemd watchdog. Outside of systemd (i.e. if $NOTIFY_SOCKET is not set) notifications
+are silently ignored.
+*/
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notification states, see sd_notify(3).
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Notify sends the state to the systemd notification socket. It returns false without
+// an error if the process is not run by systemd.
+func Notify(state string) (sent bool, err error) {
+	addr := &net.UnixAddr{Name: os.Getenv("NOTIFY_SOCKET"), Net: "unixgram"}
+	if addr.Name == "" {
+		return false, nil
+	}
+
+	// Abstract sockets are specified with a leading @
+	if addr.Name[0] == '@' {
+		addr.Name = "\x00" + addr.Name[1:]
+	}
+
+	var conn *net.UnixConn
+	if conn, err = net.DialUnix(addr.Net, nil, addr); err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Status returns the STATUS state, a free-form description of the service state that
+// is shown by systemctl status.
+func Status(status string) string {
+	return "STATUS=" + status
+}
+
+// WatchdogInterval returns the interval within which systemd expects keepalives from
+// the process, or zero if the watchdog is not enabled for the process.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+
+	// The watchdog may be intended for a different process, e.g. a parent shell
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" {
+		if pid != strconv.Itoa(os.Getpid()) {
+			return 0, nil
+		}
+	}
+
+	interval, err := strconv.ParseUint(usec, 10, 64)
+	if err != nil || interval == 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC %q", usec)
+	}
+	return time.Duration(interval) * time.Microsecond, nil
+}
@@ -0,0 +1,70 @@
+package trisarl
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// unaryAccess rejects unary RPCs from peers that are not allowed to connect. Access is
+// also checked when the mTLS handshake completes, but is checked again for every RPC so
+// that peers denied on reload are cut off even if they have an established connection.
+func (s *Server) unaryAccess(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.checkAccess(ctx, peerCertificate(ctx)); err != nil {
+		return nil, err.Err()
+	}
+	return handler(ctx, req)
+}
+
+// streamAccess rejects streaming RPCs from peers that are not allowed to connect.
+func (s *Server) streamAccess(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.checkAccess(stream.Context(), peerCertificate(stream.Context())); err != nil {
+		return err.Err()
+	}
+	return handler(srv, stream)
+}
+
+// checkAccess returns a forbidden error if the peer with the certificate is denied or
+// is not allowed. Connections without a peer certificate, i.e. on insecure listeners,
+// only serve the health service and are not checked.
+func (s *Server) checkAccess(ctx context.Context, cert *x509.Certificate) *protocol.Error {
+	access := s.config().Access
+	if cert == nil || access.IsZero() {
+		return nil
+	}
+
+	if !access.Allowed(cert.Subject.CommonName, fingerprint(cert)) {
+		log.Ctx(ctx).Warn().Str("peer", cert.Subject.CommonName).Str("fingerprint", fingerprint(cert)).Msg("peer access denied")
+		return protocol.Errorf(protocol.Forbidden, "peer %q is not allowed to connect", cert.Subject.CommonName)
+	}
+	return nil
+}
+
+// peerCertificate returns the verified mTLS certificate of the peer of the RPC.
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	if p, ok := peer.FromContext(ctx); ok {
+		return tlsCertificate(p.AuthInfo)
+	}
+	return nil
+}
+
+// tlsCertificate returns the leaf certificate presented by the peer in the handshake.
+func tlsCertificate(info credentials.AuthInfo) *x509.Certificate {
+	if tlsInfo, ok := info.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+		return tlsInfo.State.PeerCertificates[0]
+	}
+	return nil
+}
+
+// fingerprint returns the hex encoded SHA-256 fingerprint of the certificate.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
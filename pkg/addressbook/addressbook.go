@@ -0,0 +1,334 @@
+/*
+Package addressbook manages the customer accounts of the node: the IVMS101 details of
+the natural or legal person that owns each account and the wallet addresses that the
+account controls. The transfer handlers and the beneficiary inquiry service read from
+the address book to resolve the beneficiary of a transfer from its wallet address. The
+accounts are kept in the customer and address records of the store, so the address book
+is a consistent view of both that keeps the addresses of an account in sync with it.
+*/
+package addressbook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Address book errors
+var (
+	ErrNotFound     = store.ErrNotFound
+	ErrExists       = errors.New("account already exists")
+	ErrAddressInUse = errors.New("wallet address belongs to another account")
+	ErrNoAccount    = errors.New("wallet address has no customer account")
+	ErrInvalid      = errors.New("invalid account")
+)
+
+// Account is a customer account with the IVMS101 person of the customer and the wallet
+// addresses that it controls. Person is the protojson encoded IVMS101 person; if it is
+// empty a natural person (or a legal person if Legal is set) is created from the name.
+type Account struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name,omitempty"`
+	Legal     bool            `json:"legal,omitempty"`
+	Person    json.RawMessage `json:"person,omitempty"`
+	Addresses []Wallet        `json:"addresses"`
+	Created   time.Time       `json:"created"`
+	Modified  time.Time       `json:"modified"`
+}
+
+// Wallet is an address on a specific network.
+type Wallet struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+}
+
+// IVMS101 returns the IVMS101 person of the customer that owns the account.
+func (a *Account) IVMS101() (_ *ivms101.Person, err error) {
+	if len(a.Person) == 0 {
+		if a.Legal {
+			return LegalPerson(a.Name), nil
+		}
+		return NaturalPerson(a.Name), nil
+	}
+
+	person := &ivms101.Person{}
+	if err = protojson.Unmarshal(a.Person, person); err != nil {
+		return nil, fmt.Errorf("could not parse IVMS101 person of account %s: %s", a.ID, err)
+	}
+	return person, nil
+}
+
+// Validate the account before it is stored so that transfers are never answered with a
+// beneficiary that cannot be decoded.
+func (a *Account) Validate() (err error) {
+	a.ID = strings.TrimSpace(a.ID)
+	if a.ID == "" {
+		return fmt.Errorf("%w: id is required", ErrInvalid)
+	}
+	if a.Name == "" && len(a.Person) == 0 {
+		return fmt.Errorf("%w: a name or an IVMS101 person is required", ErrInvalid)
+	}
+	if len(a.Person) > 0 {
+		if _, err = a.IVMS101(); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalid, err)
+		}
+	}
+
+	seen := make(map[string]bool, len(a.Addresses))
+	for i, wallet := range a.Addresses {
+		if strings.TrimSpace(wallet.Network) == "" || strings.TrimSpace(wallet.Address) == "" {
+			return fmt.Errorf("%w: network and address are required for wallet %d", ErrInvalid, i)
+		}
+
+		key := store.AddressKey(wallet.Network, wallet.Address)
+		if seen[key] {
+			return fmt.Errorf("%w: duplicate wallet address %s", ErrInvalid, key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// Book is the address book of the customer accounts. Writes are serialized so that the
+// customer and address records of an account are updated together.
+type Book struct {
+	sync.Mutex
+	db *store.Store
+}
+
+// New creates an address book that keeps the accounts in the store.
+func New(db *store.Store) *Book {
+	return &Book{db: db}
+}
+
+// List returns all of the accounts in the address book.
+func (b *Book) List() (accounts []*Account, err error) {
+	var customers []*store.Customer
+	if customers, err = b.db.Customers(); err != nil {
+		return nil, err
+	}
+
+	var wallets map[string][]Wallet
+	if wallets, err = b.wallets(); err != nil {
+		return nil, err
+	}
+
+	accounts = make([]*Account, 0, len(customers))
+	for _, customer := range customers {
+		accounts = append(accounts, account(customer, wallets[customer.Account]))
+	}
+	return accounts, nil
+}
+
+// Get returns the account with the ID.
+func (b *Book) Get(id string) (_ *Account, err error) {
+	var customer *store.Customer
+	if customer, err = b.db.GetCustomer(id); err != nil {
+		return nil, err
+	}
+
+	var wallets map[string][]Wallet
+	if wallets, err = b.wallets(); err != nil {
+		return nil, err
+	}
+	return account(customer, wallets[customer.Account]), nil
+}
+
+// Lookup returns the account that controls the wallet address on the network. If the
+// address is registered but does not belong to an account, ErrNoAccount is returned.
+func (b *Book) Lookup(network, address string) (_ *Account, err error) {
+	var addr *store.Address
+	if addr, err = b.db.GetAddress(network, address); err != nil {
+		return nil, err
+	}
+
+	if addr.Account == "" {
+		return nil, ErrNoAccount
+	}
+
+	var acct *Account
+	if acct, err = b.Get(addr.Account); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrNoAccount
+		}
+		return nil, err
+	}
+	return acct, nil
+}
+
+// Create adds the account and its wallet addresses to the address book, returning
+// ErrExists if there is already an account with the ID.
+func (b *Book) Create(acct *Account) (err error) {
+	if err = acct.Validate(); err != nil {
+		return err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if _, err = b.db.GetCustomer(acct.ID); err == nil {
+		return ErrExists
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	return b.put(acct)
+}
+
+// Update replaces the details and the wallet addresses of the account, returning
+// ErrNotFound if the account does not exist. Wallet addresses that are not in the
+// updated account are removed from the address book.
+func (b *Book) Update(acct *Account) (err error) {
+	if err = acct.Validate(); err != nil {
+		return err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if _, err = b.db.GetCustomer(acct.ID); err != nil {
+		return err
+	}
+	return b.put(acct)
+}
+
+// Delete removes the account and its wallet addresses from the address book.
+func (b *Book) Delete(id string) (err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if _, err = b.db.GetCustomer(id); err != nil {
+		return err
+	}
+
+	if err = b.removeWallets(id, nil); err != nil {
+		return err
+	}
+	return b.db.DeleteCustomer(id)
+}
+
+// put stores the customer record and the wallet addresses of the account. The caller
+// must hold the lock.
+func (b *Book) put(acct *Account) (err error) {
+	keep := make(map[string]bool, len(acct.Addresses))
+	for _, wallet := range acct.Addresses {
+		var addr *store.Address
+		if addr, err = b.db.GetAddress(wallet.Network, wallet.Address); err == nil {
+			if addr.Account != "" && addr.Account != acct.ID {
+				return fmt.Errorf("%w: %s", ErrAddressInUse, store.AddressKey(wallet.Network, wallet.Address))
+			}
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		keep[store.AddressKey(wallet.Network, wallet.Address)] = true
+	}
+
+	customer := &store.Customer{Account: acct.ID, Name: acct.Name, Legal: acct.Legal, Person: acct.Person}
+	if err = b.db.PutCustomer(customer); err != nil {
+		return err
+	}
+	acct.Created, acct.Modified = customer.Created, customer.Modified
+
+	if err = b.removeWallets(acct.ID, keep); err != nil {
+		return err
+	}
+
+	for i, wallet := range acct.Addresses {
+		addr := &store.Address{Network: wallet.Network, Address: wallet.Address, Account: acct.ID}
+		if err = b.db.PutAddress(addr); err != nil {
+			return err
+		}
+		acct.Addresses[i] = Wallet{Network: addr.Network, Address: addr.Address}
+	}
+	return nil
+}
+
+// removeWallets deletes the wallet addresses of the account that are not kept.
+func (b *Book) removeWallets(id string, keep map[string]bool) (err error) {
+	var addrs []*store.Address
+	if addrs, err = b.db.Addresses(); err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		if addr.Account == id && !keep[store.AddressKey(addr.Network, addr.Address)] {
+			if err = b.db.DeleteAddress(addr.Network, addr.Address); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// wallets returns the registered wallet addresses grouped by account.
+func (b *Book) wallets() (_ map[string][]Wallet, err error) {
+	var addrs []*store.Address
+	if addrs, err = b.db.Addresses(); err != nil {
+		return nil, err
+	}
+
+	wallets := make(map[string][]Wallet)
+	for _, addr := range addrs {
+		if addr.Account != "" {
+			wallets[addr.Account] = append(wallets[addr.Account], Wallet{Network: addr.Network, Address: addr.Address})
+		}
+	}
+	return wallets, nil
+}
+
+func account(customer *store.Customer, wallets []Wallet) *Account {
+	if wallets == nil {
+		wallets = make([]Wallet, 0)
+	}
+	return &Account{
+		ID:        customer.Account,
+		Name:      customer.Name,
+		Legal:     customer.Legal,
+		Person:    customer.Person,
+		Addresses: wallets,
+		Created:   customer.Created,
+		Modified:  customer.Modified,
+	}
+}
+
+// NaturalPerson returns an IVMS101 natural person with the legal name.
+func NaturalPerson(name string) *ivms101.Person {
+	return &ivms101.Person{
+		Person: &ivms101.Person_NaturalPerson{
+			NaturalPerson: &ivms101.NaturalPerson{
+				Name: &ivms101.NaturalPersonName{
+					NameIdentifiers: []*ivms101.NaturalPersonNameId{
+						{
+							PrimaryIdentifier:  name,
+							NameIdentifierType: ivms101.NaturalPersonNameTypeCode_NATURAL_PERSON_NAME_TYPE_CODE_LEGL,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// LegalPerson returns an IVMS101 legal person with the legal name.
+func LegalPerson(name string) *ivms101.Person {
+	return &ivms101.Person{
+		Person: &ivms101.Person_LegalPerson{
+			LegalPerson: &ivms101.LegalPerson{
+				Name: &ivms101.LegalPersonName{
+					NameIdentifiers: []*ivms101.LegalPersonNameId{
+						{
+							LegalPersonName:               name,
+							LegalPersonNameIdentifierType: ivms101.LegalPersonNameTypeCode_LEGAL_PERSON_NAME_TYPE_CODE_LEGL,
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,503 @@
+package trisarl
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/addressbook"
+	"github.com/rotationalio/trisa/pkg/features"
+	"github.com/rotationalio/trisa/pkg/proposal"
+	"github.com/rotationalio/trisa/pkg/secrets"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Path prefix of the account endpoints of the admin API.
+const accountsPath = "/v1/accounts"
+
+// Path of the feature flags endpoint of the admin API.
+const featuresPath = "/v1/features"
+
+// Path prefix of the review queue endpoints of the admin API.
+const reviewsPath = "/v1/reviews"
+
+// Path of the reconciliation report endpoint of the admin API.
+const reconciliationPath = "/v1/reconciliation"
+
+// Path of the exchange key rotation endpoint of the admin API.
+const rotateExchangePath = "/v1/exchange/rotate"
+
+// maxAccountSize limits the size of account and review decision request bodies.
+const maxAccountSize = 1 << 20
+
+// AdminError is the response of the admin API if a request fails.
+type AdminError struct {
+	Error string `json:"error"`
+}
+
+// AddressBook returns the customer accounts that transfers and beneficiary inquiries are
+// answered from, so that applications that embed the server can manage them.
+func (s *Server) AddressBook() *addressbook.Book {
+	return s.book
+}
+
+// serveAdmin serves the admin API on a separate HTTP listener. The admin API manages
+// customer PII, so every request must present the configured bearer token and the
+// listener should only be bound to a loopback or otherwise private address.
+func (s *Server) serveAdmin(addr string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+	defer cancel()
+
+	var token string
+	if token, err = secrets.LoadString(ctx, s.conf.Admin.Token); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(accountsPath, s.accounts)
+	mux.HandleFunc(accountsPath+"/", s.account)
+	mux.HandleFunc(reviewsPath, s.reviews)
+	mux.HandleFunc(reviewsPath+"/", s.review)
+	mux.HandleFunc(reconciliationPath, s.reconciliation)
+	mux.HandleFunc(rotateExchangePath, s.rotateExchange)
+	mux.HandleFunc(featuresPath, s.featureFlags)
+
+	s.adminSrv = &http.Server{
+		Addr:              addr,
+		Handler:           adminAuth(token, mux),
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	go func() {
+		log.Info().Str("listen", addr).Msg("admin server started")
+		if err := s.adminSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("admin server stopped")
+		}
+	}()
+	return nil
+}
+
+// adminAuth rejects requests that do not present the bearer token.
+func adminAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			log.Warn().Str("remote", r.RemoteAddr).Str("path", r.URL.Path).Msg("unauthorized admin request")
+			writeAdmin(w, http.StatusUnauthorized, &AdminError{Error: "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// accounts lists the accounts of the address book (GET) or creates an account (POST).
+func (s *Server) accounts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		accounts, err := s.book.List()
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		writeAdmin(w, http.StatusOK, accounts)
+	case http.MethodPost:
+		acct := &addressbook.Account{}
+		if err := decodeAccount(r, acct); err != nil {
+			writeAdmin(w, http.StatusBadRequest, &AdminError{Error: err.Error()})
+			return
+		}
+		if err := s.book.Create(acct); err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		log.Info().Str("account", acct.ID).Msg("account created")
+		writeAdmin(w, http.StatusCreated, acct)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeAdmin(w, http.StatusMethodNotAllowed, &AdminError{Error: "method not allowed"})
+	}
+}
+
+// account returns (GET), replaces (PUT), or deletes (DELETE) the account with the ID in
+// the path.
+func (s *Server) account(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, accountsPath+"/")
+	if id == "" || strings.Contains(id, "/") {
+		writeAdmin(w, http.StatusNotFound, &AdminError{Error: "not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		acct, err := s.book.Get(id)
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		writeAdmin(w, http.StatusOK, acct)
+	case http.MethodPut:
+		acct := &addressbook.Account{}
+		if err := decodeAccount(r, acct); err != nil {
+			writeAdmin(w, http.StatusBadRequest, &AdminError{Error: err.Error()})
+			return
+		}
+		acct.ID = id
+		if err := s.book.Update(acct); err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		log.Info().Str("account", acct.ID).Msg("account updated")
+		writeAdmin(w, http.StatusOK, acct)
+	case http.MethodDelete:
+		if err := s.book.Delete(id); err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		log.Info().Str("account", id).Msg("account deleted")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeAdmin(w, http.StatusMethodNotAllowed, &AdminError{Error: "method not allowed"})
+	}
+}
+
+// featureFlags returns the state of the feature flags (GET) or toggles the flags in the
+// body, a JSON object of flag names and states, at runtime (PUT) and returns the new
+// state. Unknown flags are refused without toggling any flag.
+func (s *Server) featureFlags(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeAdmin(w, http.StatusOK, s.features.All())
+	case http.MethodPut:
+		toggles := make(map[string]bool)
+		decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxAccountSize))
+		if err := decoder.Decode(&toggles); err != nil {
+			writeAdmin(w, http.StatusBadRequest, &AdminError{Error: fmt.Sprintf("could not decode feature flags: %s", err)})
+			return
+		}
+
+		flags := make(map[features.Flag]bool, len(toggles))
+		for name, enabled := range toggles {
+			flag, err := features.Parse(name)
+			if err != nil {
+				writeAdmin(w, http.StatusBadRequest, &AdminError{Error: err.Error()})
+				return
+			}
+			flags[flag] = enabled
+		}
+
+		for flag, enabled := range flags {
+			if err := s.features.Toggle(flag, enabled); err != nil {
+				writeAdminError(w, err)
+				return
+			}
+			log.Info().Str("feature", string(flag)).Bool("enabled", enabled).Msg("feature flag toggled")
+		}
+		writeAdmin(w, http.StatusOK, s.features.All())
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeAdmin(w, http.StatusMethodNotAllowed, &AdminError{Error: "method not allowed"})
+	}
+}
+
+func decodeAccount(r *http.Request, acct *addressbook.Account) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxAccountSize))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(acct)
+}
+
+// ReviewDecision is the body of the requests that approve or reject a reviewed transfer
+// in the admin API. Transfers are approved with the identity and transaction, in
+// protojson, of the response; if they are omitted the identity and transaction of the
+// transfer are echoed back with ApproveReview. Transfers are rejected with the TRISA
+// error Code (REJECTED by default), the Message, and whether the originator may Retry;
+// rejections with Requirements, the identity fields of a counter-proposal such as
+// "originator.date_of_birth", ask the originator to send the transfer again with them.
+// The Reviewer is logged with the decision.
+type ReviewDecision struct {
+	Identity     json.RawMessage  `json:"identity,omitempty"`
+	Transaction  json.RawMessage  `json:"transaction,omitempty"`
+	Code         string           `json:"code,omitempty"`
+	Message      string           `json:"message,omitempty"`
+	Retry        bool             `json:"retry,omitempty"`
+	Requirements []proposal.Field `json:"requirements,omitempty"`
+	Reviewer     string           `json:"reviewer,omitempty"`
+}
+
+// reviews lists the transfers in the review queue, oldest first (GET).
+func (s *Server) reviews(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeAdmin(w, http.StatusMethodNotAllowed, &AdminError{Error: "method not allowed"})
+		return
+	}
+
+	reviews, err := s.Reviews()
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	sort.Slice(reviews, func(i, j int) bool { return reviews[i].ReceivedAt.Before(reviews[j].ReceivedAt) })
+	writeAdmin(w, http.StatusOK, reviews)
+}
+
+// review returns the transfer with the envelope ID in the path from the review queue
+// (GET), or sends the decision on the transfer to the originator (POST to the approve
+// or reject endpoint of the transfer).
+func (s *Server) review(w http.ResponseWriter, r *http.Request) {
+	id, action := path.Split(strings.TrimPrefix(r.URL.Path, reviewsPath+"/"))
+	if action != "approve" && action != "reject" {
+		id, action = id+action, ""
+	}
+	if id = strings.TrimSuffix(id, "/"); id == "" || strings.Contains(id, "/") {
+		writeAdmin(w, http.StatusNotFound, &AdminError{Error: "not found"})
+		return
+	}
+
+	if action == "" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeAdmin(w, http.StatusMethodNotAllowed, &AdminError{Error: "method not allowed"})
+			return
+		}
+
+		review, err := s.db.GetReview(id)
+		if err != nil {
+			writeReviewError(w, err)
+			return
+		}
+		writeAdmin(w, http.StatusOK, review)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeAdmin(w, http.StatusMethodNotAllowed, &AdminError{Error: "method not allowed"})
+		return
+	}
+
+	decision := &ReviewDecision{}
+	if err := decodeDecision(r, decision); err != nil {
+		writeAdmin(w, http.StatusBadRequest, &AdminError{Error: err.Error()})
+		return
+	}
+
+	var err error
+	if action == "approve" {
+		err = s.approveDecision(r.Context(), id, decision)
+	} else {
+		err = s.rejectDecision(r.Context(), id, decision)
+	}
+
+	if err != nil {
+		writeReviewError(w, err)
+		return
+	}
+
+	log.Info().Str("id", id).Str("decision", action).Str("reviewer", decision.Reviewer).Msg("review decided")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// approveDecision approves the transfer with the identity and transaction of the
+// decision, or with the identity and transaction of the transfer if they are omitted.
+func (s *Server) approveDecision(ctx context.Context, envelopeID string, decision *ReviewDecision) (err error) {
+	if len(decision.Identity) == 0 && len(decision.Transaction) == 0 {
+		return s.ApproveReview(ctx, envelopeID)
+	}
+
+	identity := &ivms101.IdentityPayload{}
+	if err = protojson.Unmarshal(decision.Identity, identity); err != nil {
+		return &decisionError{fmt.Errorf("could not decode identity: %s", err)}
+	}
+
+	transaction := &generic.Transaction{}
+	if err = protojson.Unmarshal(decision.Transaction, transaction); err != nil {
+		return &decisionError{fmt.Errorf("could not decode transaction: %s", err)}
+	}
+
+	payload := &protocol.Payload{}
+	if payload.Identity, err = anypb.New(identity); err != nil {
+		return err
+	}
+	if payload.Transaction, err = anypb.New(transaction); err != nil {
+		return err
+	}
+	return s.Approve(ctx, envelopeID, payload)
+}
+
+// rejectDecision rejects the transfer with the TRISA error of the decision.
+func (s *Server) rejectDecision(ctx context.Context, envelopeID string, decision *ReviewDecision) error {
+	code := protocol.Rejected
+	if decision.Code != "" {
+		value, ok := protocol.Error_Code_value[strings.ToUpper(decision.Code)]
+		if !ok || value == 0 {
+			return &decisionError{fmt.Errorf("unknown error code %q", decision.Code)}
+		}
+		code = protocol.Error_Code(value)
+	}
+
+	message := decision.Message
+	if message == "" {
+		message = "transfer rejected by compliance review"
+	}
+
+	if len(decision.Requirements) > 0 {
+		rejection, err := proposal.New(message, decision.Requirements...).Reject(code)
+		if err != nil {
+			return &decisionError{err}
+		}
+		return s.Reject(ctx, envelopeID, rejection)
+	}
+	return s.Reject(ctx, envelopeID, &protocol.Error{Code: code, Message: message, Retry: decision.Retry})
+}
+
+// reconciliation returns the latest reconciliation report (GET) or reconciles the
+// transactions created since the optional since query parameter, an RFC 3339
+// timestamp, and returns the report (POST).
+func (s *Server) reconciliation(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		report, err := s.db.GetReconciliation()
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				writeAdmin(w, http.StatusNotFound, &AdminError{Error: "transactions have not been reconciled yet"})
+				return
+			}
+			writeAdminError(w, err)
+			return
+		}
+		writeAdmin(w, http.StatusOK, report)
+	case http.MethodPost:
+		var since time.Time
+		if param := r.URL.Query().Get("since"); param != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, param); err != nil {
+				writeAdmin(w, http.StatusBadRequest, &AdminError{Error: fmt.Sprintf("invalid since timestamp: %s", err)})
+				return
+			}
+		}
+
+		report, err := s.Reconcile(r.Context(), since)
+		if err != nil {
+			if errors.Is(err, ErrNoChainProvider) {
+				writeAdmin(w, http.StatusConflict, &AdminError{Error: err.Error()})
+				return
+			}
+			writeAdminError(w, err)
+			return
+		}
+		log.Info().Int("flagged", len(report.Flagged)).Msg("transactions reconciled")
+		writeAdmin(w, http.StatusOK, report)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeAdmin(w, http.StatusMethodNotAllowed, &AdminError{Error: "method not allowed"})
+	}
+}
+
+// rotateExchange rotates the exchange key immediately and responds with the results of
+// pushing the new key to the peers.
+func (s *Server) rotateExchange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeAdmin(w, http.StatusMethodNotAllowed, &AdminError{Error: "method not allowed"})
+		return
+	}
+
+	results, err := s.RotateExchangeKey(r.Context())
+	if err != nil {
+		if errors.Is(err, ErrNotRotatable) {
+			writeAdmin(w, http.StatusConflict, &AdminError{Error: err.Error()})
+			return
+		}
+		writeAdminError(w, err)
+		return
+	}
+	writeAdmin(w, http.StatusOK, results)
+}
+
+// decisionError is an invalid review decision.
+type decisionError struct {
+	err error
+}
+
+func (e *decisionError) Error() string {
+	return e.err.Error()
+}
+
+func decodeDecision(r *http.Request, decision *ReviewDecision) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxAccountSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(decision); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// writeReviewError responds with the status code of the review queue error; decisions
+// that could not be delivered to the originator remain queued and can be sent again.
+func writeReviewError(w http.ResponseWriter, err error) {
+	var derr *decisionError
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeAdmin(w, http.StatusNotFound, &AdminError{Error: "review not found"})
+	case errors.Is(err, ErrReplyDeadline):
+		writeAdmin(w, http.StatusConflict, &AdminError{Error: err.Error()})
+	case errors.As(err, &derr):
+		writeAdmin(w, http.StatusBadRequest, &AdminError{Error: err.Error()})
+	default:
+		log.Warn().Err(err).Msg("could not send review decision")
+		writeAdmin(w, http.StatusBadGateway, &AdminError{Error: err.Error()})
+	}
+}
+
+// writeAdminError responds with the status code of the address book error.
+func writeAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, addressbook.ErrNotFound):
+		writeAdmin(w, http.StatusNotFound, &AdminError{Error: "account not found"})
+	case errors.Is(err, addressbook.ErrExists), errors.Is(err, addressbook.ErrAddressInUse):
+		writeAdmin(w, http.StatusConflict, &AdminError{Error: err.Error()})
+	case errors.Is(err, addressbook.ErrInvalid):
+		writeAdmin(w, http.StatusBadRequest, &AdminError{Error: err.Error()})
+	default:
+		log.Error().Err(err).Msg("admin request failed")
+		writeAdmin(w, http.StatusInternalServerError, &AdminError{Error: "internal error"})
+	}
+}
+
+func writeAdmin(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn().Err(err).Msg("could not write admin response")
+	}
+}
+
+// shutdownAdmin stops the admin HTTP listener if it was started.
+func (s *Server) shutdownAdmin() {
+	if s.adminSrv == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.adminSrv.Shutdown(ctx); err != nil {
+		log.Warn().Err(err).Msg("could not shutdown admin server")
+	}
+}
@@ -0,0 +1,46 @@
+package trisarl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/features"
+)
+
+func TestAdminFeatureFlags(t *testing.T) {
+	s := &Server{features: features.New(config.FeaturesConfig{})}
+
+	w := httptest.NewRecorder()
+	s.featureFlags(w, httptest.NewRequest(http.MethodPut, featuresPath, strings.NewReader(`{"concurrent_streams": true}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+
+	flags := make(map[string]bool)
+	if err := json.Unmarshal(w.Body.Bytes(), &flags); err != nil {
+		t.Fatal(err)
+	}
+	if !flags["concurrent_streams"] || !s.features.Enabled(features.ConcurrentStreams) {
+		t.Fatalf("concurrent streams were not enabled: %v", flags)
+	}
+
+	// Unknown flags are refused without toggling the known flags
+	w = httptest.NewRecorder()
+	s.featureFlags(w, httptest.NewRequest(http.MethodPut, featuresPath, strings.NewReader(`{"concurrent_streams": false, "warp_drive": true}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body)
+	}
+	if !s.features.Enabled(features.ConcurrentStreams) {
+		t.Error("flags were toggled by a refused request")
+	}
+
+	w = httptest.NewRecorder()
+	s.featureFlags(w, httptest.NewRequest(http.MethodDelete, featuresPath, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", w.Code)
+	}
+}
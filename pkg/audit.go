@@ -0,0 +1,168 @@
+package trisarl
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/envelope"
+	"github.com/rotationalio/trisa/pkg/keychain"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/credentials"
+)
+
+// keyUsagePruneInterval is how often records past their retention are removed from the
+// key usage log.
+const keyUsagePruneInterval = time.Hour
+
+// auditCreds wraps the TRISA mTLS credentials of the server to record every handshake,
+// including failed handshakes, for compliance audits, and to close the connections of
+// peers that are not allowed to connect.
+type auditCreds struct {
+	credentials.TransportCredentials
+	s *Server
+}
+
+// ServerHandshake implements credentials.TransportCredentials
+func (c *auditCreds) ServerHandshake(conn net.Conn) (out net.Conn, info credentials.AuthInfo, err error) {
+	out, info, err = c.TransportCredentials.ServerHandshake(conn)
+	if err == nil {
+		if perr := c.s.checkAccess(context.Background(), tlsCertificate(info)); perr != nil {
+			out.Close()
+			out, err = nil, perr
+		}
+	}
+
+	c.s.auditHandshake(conn.RemoteAddr(), info, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, info, nil
+}
+
+// Clone implements credentials.TransportCredentials
+func (c *auditCreds) Clone() credentials.TransportCredentials {
+	return &auditCreds{TransportCredentials: c.TransportCredentials.Clone(), s: c.s}
+}
+
+// auditHandshake logs the details of the handshake and the peer certificate, and
+// appends them to the handshake audit log in the store if persistence is enabled.
+func (s *Server) auditHandshake(addr net.Addr, info credentials.AuthInfo, err error) {
+	record := &store.Handshake{Time: time.Now()}
+	if addr != nil {
+		record.RemoteAddr = addr.String()
+	}
+
+	if tlsInfo, ok := info.(credentials.TLSInfo); ok {
+		record.CipherSuite = tls.CipherSuiteName(tlsInfo.State.CipherSuite)
+		record.Version = tlsVersionName(tlsInfo.State.Version)
+	}
+
+	if cert := tlsCertificate(info); cert != nil {
+		record.CommonName = cert.Subject.CommonName
+		record.Subject = cert.Subject.String()
+		record.Issuer = cert.Issuer.String()
+		record.Serial = fmt.Sprintf("%X", cert.SerialNumber)
+		record.Fingerprint = fingerprint(cert)
+	}
+
+	if err != nil {
+		record.Error = err.Error()
+		log.Warn().Err(err).Str("remote_addr", record.RemoteAddr).Str("peer", record.CommonName).Msg("mtls handshake failed")
+	} else {
+		log.Info().
+			Str("remote_addr", record.RemoteAddr).
+			Str("peer", record.CommonName).
+			Str("subject", record.Subject).
+			Str("issuer", record.Issuer).
+			Str("serial", record.Serial).
+			Str("fingerprint", record.Fingerprint).
+			Str("cipher_suite", record.CipherSuite).
+			Str("tls_version", record.Version).
+			Msg("mtls handshake")
+	}
+
+	if s.config().Audit.PersistHandshakes {
+		if err = s.db.PutHandshake(record); err != nil {
+			log.Error().Err(err).Str("remote_addr", record.RemoteAddr).Msg("could not persist handshake audit record")
+		}
+	}
+}
+
+// auditKeyUsage logs a use of an envelope key and appends it to the key usage audit
+// log in the store if persistence is enabled. The key may be a private key of the node
+// or the public key of a peer and is identified by the fingerprint of its public key.
+func (s *Server) auditKeyUsage(use, peer, envelopeID string, key interface{}, err error) {
+	record := &store.KeyUsage{Time: time.Now(), Use: use, Peer: peer, EnvelopeID: envelopeID}
+	if key != nil {
+		if pub := envelope.PublicKey(key); pub != nil {
+			key = pub
+		}
+		record.KeyID = keychain.KeyID(key)
+	}
+
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	log.Debug().
+		Str("use", record.Use).
+		Str("key_id", record.KeyID).
+		Str("peer", record.Peer).
+		Str("envelope_id", record.EnvelopeID).
+		Str("error", record.Error).
+		Msg("envelope key used")
+
+	if s.config().Audit.PersistKeyUsage {
+		if err = s.db.PutKeyUsage(record); err != nil {
+			log.Error().Err(err).Str("use", use).Str("envelope_id", envelopeID).Msg("could not persist key usage audit record")
+		}
+	}
+}
+
+// pruneKeyUsage periodically removes the records of the key usage log that are older
+// than the retention period until the server starts shutting down.
+func (s *Server) pruneKeyUsage() {
+	if s.config().Audit.KeyUsageRetention <= 0 {
+		return
+	}
+
+	s.background(func(ctx context.Context) {
+		ticker := time.NewTicker(keyUsagePruneInterval)
+		defer ticker.Stop()
+		for {
+			retention := s.config().Audit.KeyUsageRetention
+			if retention > 0 {
+				if n, err := s.db.DeleteKeyUsageBefore(time.Now().Add(-retention)); err != nil {
+					log.Error().Err(err).Msg("could not prune key usage log")
+				} else if n > 0 {
+					log.Info().Int("records", n).Dur("retention", retention).Msg("key usage log pruned")
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04X", version)
+	}
+}
@@ -0,0 +1,165 @@
+package trisarl
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/directory"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	gds "github.com/trisacrypto/trisa/pkg/trisa/gds/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+)
+
+// BroadcastResult reports the outcome of pushing our signing key to a single peer.
+type BroadcastResult struct {
+	Peer     string        `json:"peer"`
+	Endpoint string        `json:"endpoint,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// BroadcastKeys pushes the current signing key of the server to every peer in the
+// address book (and any additional peers specified by common name) by performing a key
+// exchange with each of them. At most concurrency key exchanges are run at a time. This
+// is useful after key rotation or certificate reissuance so that counterparties are not
+// surprised by a new key in the middle of a transfer.
+func (s *Server) BroadcastKeys(ctx context.Context, concurrency int, commonNames ...string) (results []*BroadcastResult, err error) {
+	var known []*store.Peer
+	if known, err = s.db.Peers(); err != nil {
+		return nil, err
+	}
+
+	// Deduplicate the peers in the address book and the additional peers
+	targets := make(map[string]struct{}, len(known)+len(commonNames))
+	for _, peer := range known {
+		targets[peer.CommonName] = struct{}{}
+	}
+	for _, name := range commonNames {
+		targets[name] = struct{}{}
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(targets) {
+		concurrency = len(targets)
+	}
+
+	names := make(chan string, len(targets))
+	for name := range targets {
+		names <- name
+	}
+	close(names)
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+
+	// The workers report the peers that were not reached before ctx was cancelled
+	results = make([]*BroadcastResult, 0, len(targets))
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				var result *BroadcastResult
+				if err := ctx.Err(); err != nil {
+					result = &BroadcastResult{Peer: name, Error: err.Error()}
+				} else {
+					result = s.pushKey(ctx, name)
+				}
+
+				mu.Lock()
+				results = append(results, result)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Peer < results[j].Peer })
+	return results, nil
+}
+
+// exchangeKeysOnStartup exchanges keys in the background with every peer in the address
+// book when the server starts, if enabled, so that peers have the current key of the
+// node and the keys of peers are fresh before the first transfer, rather than the first
+// transfer to a peer being rejected with a NO_SIGNING_KEY error and retried. Peers that
+// cannot be reached exchange keys with the next transfer instead. The key exchanges are
+// abandoned when the server starts shutting down.
+func (s *Server) exchangeKeysOnStartup() {
+	if !s.config().Exchange.OnStartup {
+		return
+	}
+
+	s.broadcastInBackground("exchanged keys with known peers on startup")
+}
+
+// pushKey looks up the peer endpoint in the directory service and forces a key exchange.
+func (s *Server) pushKey(ctx context.Context, commonName string) (result *BroadcastResult) {
+	start := time.Now()
+	result = &BroadcastResult{Peer: commonName}
+	defer func() {
+		result.Duration = time.Since(start)
+		if result.Error != "" {
+			log.Warn().Str("peer", commonName).Str("error", result.Error).Msg("could not push signing key to peer")
+		} else {
+			log.Info().Str("peer", commonName).Dur("duration", result.Duration).Msg("signing key pushed to peer")
+		}
+	}()
+
+	var (
+		err  error
+		peer *peers.Peer
+	)
+
+	if peer, err = s.lookup(ctx, commonName); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Endpoint = peer.Info().Endpoint
+
+	if _, err = s.exchangeKeys(ctx, peer, true); err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// lookup the peer in the directory service to populate its endpoint, then update the
+// peers cache and the address book with the directory information.
+func (s *Server) lookup(ctx context.Context, commonName string) (_ *peers.Peer, err error) {
+	ctx, cancel := context.WithTimeout(ctx, directory.Timeout)
+	defer cancel()
+
+	var rep *gds.LookupReply
+	if rep, err = s.directory.Lookup(ctx, commonName); err != nil {
+		return nil, err
+	}
+
+	info := &peers.PeerInfo{
+		ID:                  rep.Id,
+		RegisteredDirectory: rep.RegisteredDirectory,
+		CommonName:          commonName,
+		Endpoint:            rep.Endpoint,
+	}
+
+	if err = s.network().Add(info); err != nil {
+		return nil, err
+	}
+
+	if err = s.db.PutPeer(&store.Peer{CommonName: commonName, ID: info.ID, RegisteredDirectory: info.RegisteredDirectory, Endpoint: info.Endpoint}); err != nil {
+		log.Warn().Err(err).Str("peer", commonName).Msg("could not update peer in address book")
+	}
+	return s.network().Get(commonName)
+}
+
+// remember records the peer in the address book when it contacts the server.
+func (s *Server) remember(peer *peers.Peer) {
+	if err := s.db.SeenPeer(peer.String()); err != nil {
+		log.Warn().Err(err).Str("peer", peer.String()).Msg("could not record peer in address book")
+	}
+}
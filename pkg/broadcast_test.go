@@ -0,0 +1,35 @@
+package trisarl
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBroadcastKeysCancelled(t *testing.T) {
+	s := storeServer(t, "")
+	for _, name := range []string{"alice.example.com", "bob.example.com", "carol.example.com"} {
+		if err := s.db.SeenPeer(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Peers that are not reached before the context is cancelled are reported
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := s.BroadcastKeys(ctx, 2, "bob.example.com", "dave.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected a result for each of the 4 peers, got %d", len(results))
+	}
+	for i, result := range results {
+		if result.Error != context.Canceled.Error() {
+			t.Errorf("expected %s to be cancelled, got %q", result.Peer, result.Error)
+		}
+		if i > 0 && results[i-1].Peer >= result.Peer {
+			t.Error("results are not sorted by peer")
+		}
+	}
+}
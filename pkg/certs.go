@@ -0,0 +1,251 @@
+package trisarl
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/envelope"
+	"github.com/rotationalio/trisa/pkg/hsm"
+	"github.com/rotationalio/trisa/pkg/secrets"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/trisa/pkg/trust"
+)
+
+// loadCertificates loads the TRISA certificates issued by the directory service and
+// the trust pool of public CA keys, either from local files or from a remote secret
+// store if the configured location is a URI such as gcpsecret://.
+func loadCertificates(conf config.Config) (certs *trust.Provider, pool trust.ProviderPool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+	defer cancel()
+
+	var password string
+	if password, err = secrets.LoadString(ctx, conf.CertsPassword); err != nil {
+		return nil, nil, err
+	}
+
+	// Local files are read directly so that archives are detected by file extension.
+	if !secrets.IsURI(conf.ServerCerts) && !secrets.IsURI(conf.ServerCertPool) && password == "" {
+		var sz *trust.Serializer
+		if sz, err = trust.NewSerializer(false); err != nil {
+			return nil, nil, err
+		}
+
+		if certs, err = sz.ReadFile(conf.ServerCerts); err != nil {
+			return nil, nil, err
+		}
+
+		if pool, err = sz.ReadPoolFile(conf.ServerCertPool); err != nil {
+			return nil, nil, err
+		}
+		return certs, pool, nil
+	}
+
+	var data []byte
+	if data, err = secrets.Load(ctx, conf.ServerCerts); err != nil {
+		return nil, nil, err
+	}
+
+	if certs, err = extractProvider(data, password); err != nil {
+		return nil, nil, err
+	}
+
+	if data, err = secrets.Load(ctx, conf.ServerCertPool); err != nil {
+		return nil, nil, err
+	}
+
+	if pool, err = extractPool(data, password); err != nil {
+		return nil, nil, err
+	}
+	return certs, pool, nil
+}
+
+// loadSigningCertificates loads the signing certificate used for envelope encryption
+// if it is configured separately from the mTLS certificates, otherwise nil is returned.
+// The private key is either included with the certificate (e.g. PKCS12 or a PEM bundle)
+// or is loaded from the separate PEM encoded SigningKey location.
+func loadSigningCertificates(conf config.Config) (certs *trust.Provider, err error) {
+	if conf.SigningCerts == "" {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+	defer cancel()
+
+	var password string
+	if password, err = secrets.LoadString(ctx, conf.CertsPassword); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if data, err = secrets.Load(ctx, conf.SigningCerts); err != nil {
+		return nil, err
+	}
+
+	// A signing key in an HSM is opened separately and never loaded into the provider
+	hsmKey := hsm.IsURI(conf.SigningKey)
+	if conf.SigningKey != "" && !hsmKey {
+		var key []byte
+		if key, err = secrets.Load(ctx, conf.SigningKey); err != nil {
+			return nil, err
+		}
+
+		if !bytes.HasPrefix(bytes.TrimSpace(data), magicPEM) || !bytes.HasPrefix(bytes.TrimSpace(key), magicPEM) {
+			return nil, errors.New("signing certificate and key must be PEM encoded if the key is separate")
+		}
+		data = append(append(bytes.TrimSpace(data), '\n'), key...)
+	}
+
+	if certs, err = extractProvider(data, password); err != nil {
+		return nil, fmt.Errorf("could not load signing certificate: %s", err)
+	}
+
+	if !certs.IsPrivate() && !hsmKey {
+		return nil, errors.New("no private key found for the signing certificate")
+	}
+	return certs, nil
+}
+
+// loadSigningKey returns the private key of the signing certificates or, if the signing
+// key is a PKCS #11 URI or KMS key, the key in the HSM or KMS, which must match the
+// signing certificate.
+func loadSigningKey(conf config.Config, certs *trust.Provider) (key interface{}, err error) {
+	if !hsm.IsURI(conf.SigningKey) {
+		return envelope.PrivateKey(certs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+	defer cancel()
+
+	var hsmKey hsm.PrivateKey
+	if hsmKey, err = hsm.Open(ctx, conf.SigningKey); err != nil {
+		return nil, fmt.Errorf("could not open signing key: %s", err)
+	}
+
+	var cert *x509.Certificate
+	if cert, err = certs.GetLeafCertificate(); err != nil {
+		return nil, err
+	}
+
+	if pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(hsmKey.Public()) {
+		return nil, errors.New("signing key in the HSM does not match the signing certificate")
+	}
+	return hsmKey, nil
+}
+
+// checkCertificates ensures that the certificates are currently valid and are issued
+// by a CA in the trust pool. In development these problems are only logged so that
+// self-signed or expired certificates can be used for local testing.
+func checkCertificates(profile config.Profile, name string, certs *trust.Provider, pool trust.ProviderPool) (err error) {
+	if err = verifyCertificates(certs, pool); err != nil {
+		if profile.IsDevelopment() {
+			log.Warn().Err(err).Str("certs", name).Str("environment", profile.String()).Msg("invalid certificates")
+			return nil
+		}
+		return fmt.Errorf("invalid %s certificates: %s", name, err)
+	}
+	return nil
+}
+
+func verifyCertificates(certs *trust.Provider, pool trust.ProviderPool) (err error) {
+	var leaf *x509.Certificate
+	if leaf, err = certs.GetLeafCertificate(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate for %s is only valid from %s to %s", leaf.Subject.CommonName, leaf.NotBefore.Format(time.RFC3339), leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	opts := x509.VerifyOptions{KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	if opts.Roots, err = pool.GetCertPool(false); err != nil {
+		return err
+	}
+	if opts.Intermediates, err = certs.GetCertPool(); err != nil {
+		return err
+	}
+
+	if _, err = leaf.Verify(opts); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Format detection for certificate material loaded as raw bytes
+var (
+	magicPEM  = []byte("-----BEGIN")
+	magicGZIP = []byte{0x1f, 0x8b}
+	magicZIP  = []byte("PK\x03\x04")
+)
+
+// extractProvider detects the format of the certificate data and decodes it; data that
+// is not PEM encoded or compressed is assumed to be PKCS12 encrypted with the password.
+func extractProvider(data []byte, password string) (_ *trust.Provider, err error) {
+	var sz *trust.Serializer
+	data = bytes.TrimSpace(data)
+
+	switch {
+	case bytes.HasPrefix(data, magicPEM):
+		sz, err = trust.NewSerializer(false, "", trust.CompressionNone)
+	case bytes.HasPrefix(data, magicGZIP):
+		sz, err = trust.NewSerializer(false, "", trust.CompressionGZIP)
+	case bytes.HasPrefix(data, magicZIP):
+		if data, err = unzip(data); err != nil {
+			return nil, err
+		}
+		return extractProvider(data, password)
+	default:
+		sz, err = trust.NewSerializer(true, password, trust.CompressionNone)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return sz.Extract(data)
+}
+
+// extractPool decodes a trust pool; private PKCS12 material is reduced to its public
+// certificate chain since only the public CA certificates are required for the pool.
+func extractPool(data []byte, password string) (pool trust.ProviderPool, err error) {
+	var provider *trust.Provider
+	if provider, err = extractProvider(data, password); err != nil {
+		return nil, err
+	}
+	return trust.NewPool(provider.Public()), nil
+}
+
+// unzip returns the contents of a zip archive that contains a single file, which is
+// the format of the certificates issued by the directory service.
+func unzip(data []byte) (_ []byte, err error) {
+	var archive *zip.Reader
+	if archive, err = zip.NewReader(bytes.NewReader(data), int64(len(data))); err != nil {
+		return nil, err
+	}
+
+	switch len(archive.File) {
+	case 0:
+		return nil, trust.ErrZipEmpty
+	case 1:
+		var rc io.ReadCloser
+		if rc, err = archive.File[0].Open(); err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		var buf bytes.Buffer
+		if _, err = io.Copy(&buf, rc); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, trust.ErrZipTooMany
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Package chain verifies that the transactions described by Travel Rule exchanges actually
+settle on chain. A Provider is asked about the transaction with the network and ID of
+the generic transaction of the exchange, e.g. by a block explorer or node, and the
+Verifier compares what the provider found with the amount of the Travel Rule message
+to determine the status of the settlement for reconciliation. The package provides an
+adapter for HTTP chain-data providers; other providers are integrated by implementing
+Provider.
+*/
+package chain
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/store"
+)
+
+// ErrNotFound is returned by providers if the network does not know the transaction.
+var ErrNotFound = errors.New("transaction not found on chain")
+
+// Tx is a transaction on chain as reported by a provider. Confirmations is zero if the
+// transaction has been broadcast but not mined yet.
+type Tx struct {
+	Amount        float64 `json:"amount"`
+	Confirmations int     `json:"confirmations"`
+}
+
+// Provider looks up transactions on chain by their network and ID. It returns
+// ErrNotFound if the network does not know the transaction, or another error if the
+// transaction could not be looked up, e.g. because the provider is unavailable.
+type Provider interface {
+	Lookup(ctx context.Context, network, txid string) (*Tx, error)
+}
+
+// ProviderFunc adapts a function to the Provider interface.
+type ProviderFunc func(ctx context.Context, network, txid string) (*Tx, error)
+
+// Lookup implements Provider
+func (f ProviderFunc) Lookup(ctx context.Context, network, txid string) (*Tx, error) {
+	return f(ctx, network, txid)
+}
+
+// Verifier verifies settlements with a provider. Settlements are confirmed once the
+// transaction has the required number of Confirmations (at least one) and its amount
+// matches the amount of the Travel Rule message within the Tolerance, a fraction of
+// the amount, e.g. to allow for network fees that are deducted from the amount.
+type Verifier struct {
+	Provider      Provider
+	Confirmations int
+	Tolerance     float64
+}
+
+// Verify looks up the transaction of the settlement and updates its status, the
+// confirmations and amount on chain, and the time it was checked. If the provider
+// could not look up the transaction the error is recorded and returned, and the status
+// is left unchanged so that the settlement is verified again later.
+func (v *Verifier) Verify(ctx context.Context, settlement *store.Settlement) (err error) {
+	settlement.Checks++
+	settlement.Checked = time.Now()
+
+	var tx *Tx
+	if tx, err = v.Provider.Lookup(ctx, settlement.Network, settlement.TxID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			settlement.Status, settlement.Error = store.SettlementNotFound, ""
+			return nil
+		}
+		settlement.Error = err.Error()
+		return err
+	}
+
+	settlement.Error = ""
+	settlement.Confirmations = tx.Confirmations
+	settlement.ChainAmount = tx.Amount
+
+	required := v.Confirmations
+	if required < 1 {
+		required = 1
+	}
+
+	switch {
+	case settlement.Amount > 0 && math.Abs(tx.Amount-settlement.Amount) > settlement.Amount*v.Tolerance:
+		settlement.Status = store.SettlementMismatch
+	case tx.Confirmations < required:
+		settlement.Status = store.SettlementPending
+	default:
+		settlement.Status = store.SettlementConfirmed
+	}
+	return nil
+}
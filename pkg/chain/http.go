@@ -0,0 +1,71 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HTTPProvider looks up transactions with a chain-data provider that has an HTTP API,
+// e.g. a small adapter service in front of a block explorer or a node. The transaction
+// is requested from the URL with the network and the transaction ID as path segments:
+//
+//	GET {URL}/{network}/{txid}
+//
+// and the provider responds with the transaction, or with 404 Not Found if the network
+// does not know the transaction:
+//
+//	{"amount": 0.25, "confirmations": 3}
+//
+// If Token is set it is sent as a bearer token.
+type HTTPProvider struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+// Lookup implements Provider
+func (p *HTTPProvider) Lookup(ctx context.Context, network, txid string) (_ *Tx, err error) {
+	endpoint := strings.TrimSuffix(p.URL, "/") + "/" + url.PathEscape(network) + "/" + url.PathEscape(txid)
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var rep *http.Response
+	if rep, err = client.Do(req); err != nil {
+		return nil, fmt.Errorf("could not reach chain-data provider: %s", err)
+	}
+	defer rep.Body.Close()
+
+	switch rep.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		msg, _ := ioutil.ReadAll(io.LimitReader(rep.Body, 512))
+		return nil, fmt.Errorf("chain-data provider returned %s: %s", rep.Status, bytes.TrimSpace(msg))
+	}
+
+	tx := &Tx{}
+	if err = json.NewDecoder(rep.Body).Decode(tx); err != nil {
+		return nil, fmt.Errorf("could not decode chain-data provider reply: %s", err)
+	}
+	return tx, nil
+}
@@ -0,0 +1,710 @@
+/*
+Package client originates TRISA transfers from Go services without running a TRISA
+node. Given the common name of the beneficiary VASP, the identity, and the transaction,
+the Client looks up the endpoint of the counterparty in the TRISA directory, exchanges
+signing keys with it if its key is not cached, seals the payload in a secure envelope,
+sends it with the Transfer or TransferStream RPC, and opens the response with the
+private signing key. Replies that are pending messages are decoded so that callers can
+tell a deferred decision from an approval, and rejections with a counter-proposal are
+satisfied and sent again if the client has a proposal provider.
+*/
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/envelope"
+	"github.com/rotationalio/trisa/pkg/pending"
+	"github.com/rotationalio/trisa/pkg/proposal"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	gds "github.com/trisacrypto/trisa/pkg/trisa/gds/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"github.com/trisacrypto/trisa/pkg/trisa/mtls"
+	"github.com/trisacrypto/trisa/pkg/trust"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Timeout is the default timeout of lookups, key exchanges, and transfers that are
+// called with a context without a deadline.
+const Timeout = 30 * time.Second
+
+// Directory looks up the endpoints of TRISA peers, e.g. the directory.Client.
+type Directory interface {
+	Lookup(ctx context.Context, commonName string) (*gds.LookupReply, error)
+}
+
+// Client sends transfers to TRISA peers with the mTLS certificates of the VASP. The
+// signing certificates, which default to the mTLS certificates, are sent to peers in
+// key exchanges and their private key opens the responses. Connections and the signing
+// keys of the peers are cached until the client is closed. A Client is safe for
+// concurrent use.
+type Client struct {
+	certs     *trust.Provider
+	pool      trust.ProviderPool
+	signing   *trust.Provider
+	key       interface{}
+	directory Directory
+	opts      []grpc.DialOption
+	endpoints map[string]string
+	proposals proposal.Provider
+
+	mu    sync.Mutex
+	peers map[string]*peer
+}
+
+// peer is a counterparty that the client has connected to.
+type peer struct {
+	endpoint string
+	cc       *grpc.ClientConn
+	api      protocol.TRISANetworkClient
+
+	mu  sync.Mutex
+	key interface{}
+}
+
+// Option configures the client when it is created with New.
+type Option func(c *Client) error
+
+// WithSigningCerts sets the certificates that are sent to peers in key exchanges, if
+// the VASP uses separate signing certificates. The certificates must have a private key.
+func WithSigningCerts(certs *trust.Provider) Option {
+	return func(c *Client) error {
+		c.signing = certs
+		return nil
+	}
+}
+
+// WithDialOptions adds gRPC dial options to the connections to peers, e.g. to compress
+// calls with gzip.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(c *Client) error {
+		c.opts = append(c.opts, opts...)
+		return nil
+	}
+}
+
+// WithEndpoint sets the endpoint (host:port) of the peer with the common name, which is
+// then not looked up in the directory, e.g. for peers in a private network.
+func WithEndpoint(commonName, endpoint string) Option {
+	return func(c *Client) error {
+		c.endpoints[commonName] = endpoint
+		return nil
+	}
+}
+
+// WithProposalProvider satisfies the counter-proposals of peers that reject transfers,
+// e.g. by looking up the requested customer data in the KYC database of the VASP.
+// Transfers whose counter-proposal is satisfied are sent again once with the same
+// envelope ID and the completed identity.
+func WithProposalProvider(provider proposal.Provider) Option {
+	return func(c *Client) error {
+		c.proposals = provider
+		return nil
+	}
+}
+
+// New creates a client with the mTLS certificates of the VASP, which must have a
+// private key, the pool of trusted certificates of the TRISA network, and the directory
+// that peers are looked up in; the directory may be nil if every peer has an endpoint.
+func New(certs *trust.Provider, pool trust.ProviderPool, directory Directory, opts ...Option) (c *Client, err error) {
+	if certs == nil || !certs.IsPrivate() {
+		return nil, errors.New("mtls certificates with a private key are required")
+	}
+
+	c = &Client{
+		certs:     certs,
+		pool:      pool,
+		signing:   certs,
+		directory: directory,
+		endpoints: make(map[string]string),
+		peers:     make(map[string]*peer),
+	}
+
+	for _, opt := range opts {
+		if err = opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.signing == nil || !c.signing.IsPrivate() {
+		return nil, errors.New("signing certificates with a private key are required")
+	}
+	if c.key, err = envelope.PrivateKey(c.signing); err != nil {
+		return nil, fmt.Errorf("invalid signing key: %s", err)
+	}
+	return c, nil
+}
+
+// Message is an identity and transaction sent to a peer in a secure envelope with the
+// envelope ID, or with a new envelope ID if it is empty.
+type Message struct {
+	EnvelopeID  string
+	Identity    *ivms101.IdentityPayload
+	Transaction *generic.Transaction
+}
+
+// Reply is the response of a peer to a message. The payload of accepted transfers has
+// the identity and transaction of the response, which are decoded if the transaction
+// is a generic transaction; if the peer deferred its decision the payload is a pending
+// message with the time frame in which to expect the decision. Replies to messages
+// sent on a stream have the Error of the peer if it rejected the message.
+type Reply struct {
+	EnvelopeID  string
+	Payload     *protocol.Payload
+	Identity    *ivms101.IdentityPayload
+	Transaction *generic.Transaction
+	Pending     *pending.Pending
+	Error       *protocol.Error
+}
+
+// Transfer sends the message to the counterparty with the Transfer RPC and returns the
+// reply. If the counterparty rejects the transfer, the TRISA error is returned, unless
+// the rejection has a counter-proposal that the proposal provider satisfies, in which
+// case the message is sent again with the completed identity.
+func (c *Client) Transfer(ctx context.Context, counterparty string, msg *Message) (_ *Reply, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var p *peer
+	if p, err = c.connect(ctx, counterparty); err != nil {
+		return nil, err
+	}
+
+	var reply *Reply
+	if reply, err = c.transfer(ctx, p, msg); err != nil {
+		return nil, err
+	}
+
+	if reply.Error != nil {
+		if msg = c.satisfy(ctx, msg, reply); msg == nil {
+			return nil, reply.Error
+		}
+
+		if reply, err = c.transfer(ctx, p, msg); err != nil {
+			return nil, err
+		}
+		if reply.Error != nil {
+			return nil, reply.Error
+		}
+	}
+	return reply, nil
+}
+
+// transfer seals the message and sends it to the peer with the Transfer RPC.
+func (c *Client) transfer(ctx context.Context, p *peer, msg *Message) (_ *Reply, err error) {
+	var in, out *protocol.SecureEnvelope
+	if in, err = c.seal(ctx, p, msg); err != nil {
+		return nil, err
+	}
+
+	if out, err = p.api.Transfer(ctx, in); err != nil {
+		return nil, trisaError(err)
+	}
+	return c.open(out)
+}
+
+// satisfy returns a copy of the message with the envelope ID of the rejected reply and
+// the identity completed by the proposal provider, or nil if the rejection does not
+// have a counter-proposal or it cannot be satisfied. The identity of the message is not
+// modified.
+func (c *Client) satisfy(ctx context.Context, msg *Message, reply *Reply) *Message {
+	if c.proposals == nil || msg.Identity == nil {
+		return nil
+	}
+
+	cp, ok := proposal.FromError(reply.Error)
+	if !ok {
+		return nil
+	}
+
+	identity := proto.Clone(msg.Identity).(*ivms101.IdentityPayload)
+	if _, err := cp.Satisfy(ctx, identity, c.proposals); err != nil {
+		return nil
+	}
+	return &Message{EnvelopeID: reply.EnvelopeID, Identity: identity, Transaction: msg.Transaction}
+}
+
+// TransferStream sends the messages to the counterparty on a single TransferStream RPC
+// and returns the replies in the order of the messages. Rejected messages do not stop
+// the stream; their replies have the error of the peer instead of a payload. If any
+// message could not be sent or was not answered, its error is returned.
+func (c *Client) TransferStream(ctx context.Context, counterparty string, msgs []*Message) (_ []*Reply, err error) {
+	var batch *Batch
+	if batch, err = c.SendBatch(ctx, counterparty, msgs); err != nil {
+		return nil, err
+	}
+
+	replies := make([]*Reply, 0, len(batch.Results))
+	for _, result := range batch.Results {
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		replies = append(replies, result.Reply)
+	}
+	return replies, nil
+}
+
+// Result is the outcome of a message sent in a batch: the reply of the peer, which has
+// the TRISA error of the peer if it rejected the message, or the error if the message
+// could not be sealed or sent, or was not answered before the stream was closed.
+type Result struct {
+	Index      int
+	EnvelopeID string
+	Reply      *Reply
+	Err        error
+}
+
+// Accepted returns true if the peer accepted the message.
+func (r *Result) Accepted() bool {
+	return r.Err == nil && r.Reply != nil && r.Reply.Error == nil
+}
+
+// Batch is the outcome of a batch of messages sent on a single stream, with the result
+// of each message in the order of the messages and the number of messages that were
+// sent, accepted, rejected by the peer, and that failed. Acknowledged is the summary
+// of the stream that the peer reported when it closed the stream, which is nil if the
+// peer does not report one.
+type Batch struct {
+	Results      []*Result
+	Sent         int
+	Accepted     int
+	Rejected     int
+	Failed       int
+	Acknowledged *StreamSummary
+}
+
+// StreamSummary is the number of messages that a peer received on a stream and how
+// many of them it accepted or rejected, as reported in the trailer of the stream.
+type StreamSummary struct {
+	Received uint64
+	Accepted uint64
+	Rejected uint64
+}
+
+// The metadata key with which the client asks trisarl nodes to send the replies on a
+// stream as soon as they are ready, and the trailer keys of the stream summary that
+// trisarl nodes send when they close a stream.
+const (
+	streamUnorderedKey = "x-stream-unordered"
+	streamReceivedKey  = "x-stream-received"
+	streamAcceptedKey  = "x-stream-accepted"
+	streamRejectedKey  = "x-stream-rejected"
+)
+
+// Failures returns the results of the messages that were rejected or failed.
+func (b *Batch) Failures() []*Result {
+	failures := make([]*Result, 0, b.Rejected+b.Failed)
+	for _, result := range b.Results {
+		if !result.Accepted() {
+			failures = append(failures, result)
+		}
+	}
+	return failures
+}
+
+// SendBatch sends the messages to the counterparty on a single TransferStream RPC,
+// correlating the replies with the messages by envelope ID, and returns the result of
+// every message. Partial failures do not stop the batch: messages that cannot be
+// sealed or that have the envelope ID of an earlier message are not sent, and if the
+// stream is closed early, the messages that were not answered fail with the error of
+// the stream. An error is only returned if the stream could not be opened. Large
+// batches should be sent with a context deadline, since the default timeout applies to
+// the whole stream.
+func (c *Client) SendBatch(ctx context.Context, counterparty string, msgs []*Message) (batch *Batch, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var p *peer
+	if p, err = c.connect(ctx, counterparty); err != nil {
+		return nil, err
+	}
+
+	// Exchange keys before the stream is opened so that every message can be sealed
+	if _, err = c.exchangeKeys(ctx, p); err != nil {
+		return nil, err
+	}
+
+	// Replies are correlated by envelope ID, so the peer may send them as soon as they
+	// are ready rather than in the order of the messages
+	var stream protocol.TRISANetwork_TransferStreamClient
+	if stream, err = p.api.TransferStream(metadata.AppendToOutgoingContext(ctx, streamUnorderedKey, "true")); err != nil {
+		return nil, trisaError(err)
+	}
+
+	batch = &Batch{Results: make([]*Result, len(msgs))}
+	pending := make(map[string]*Result, len(msgs))
+
+	// Receive the replies while the envelopes are sent, since the peer may reply to an
+	// envelope before it has received the next one
+	var mu sync.Mutex
+	done := make(chan error, 1)
+	go func() {
+		for {
+			out, err := stream.Recv()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					done <- nil
+					return
+				}
+				done <- trisaError(err)
+				return
+			}
+
+			mu.Lock()
+			if result, ok := pending[out.Id]; ok {
+				result.Reply, result.Err = c.open(out)
+				delete(pending, out.Id)
+			}
+			mu.Unlock()
+		}
+	}()
+
+	var sendErr error
+	for i, msg := range msgs {
+		result := &Result{Index: i}
+		batch.Results[i] = result
+		if msg != nil {
+			result.EnvelopeID = msg.EnvelopeID
+		}
+
+		if sendErr != nil {
+			result.Err = sendErr
+			continue
+		}
+
+		var in *protocol.SecureEnvelope
+		if in, result.Err = c.seal(ctx, p, msg); result.Err != nil {
+			continue
+		}
+		result.EnvelopeID = in.Id
+
+		mu.Lock()
+		_, duplicate := pending[in.Id]
+		if !duplicate {
+			pending[in.Id] = result
+		}
+		mu.Unlock()
+
+		if duplicate {
+			result.Err = fmt.Errorf("envelope %s was already sent in the batch", in.Id)
+			continue
+		}
+
+		if sendErr = stream.Send(in); sendErr != nil {
+			// The error of the stream is returned by Recv
+			if errors.Is(sendErr, io.EOF) {
+				sendErr = fmt.Errorf("stream closed by %s", counterparty)
+			}
+			continue
+		}
+		batch.Sent++
+	}
+
+	if sendErr == nil {
+		sendErr = stream.CloseSend()
+	}
+
+	// Messages that were not answered fail with the error of the stream
+	streamErr := <-done
+	if streamErr == nil {
+		streamErr = sendErr
+	}
+	if streamErr == nil {
+		streamErr = fmt.Errorf("stream closed by %s", counterparty)
+	}
+
+	for _, result := range pending {
+		if result.Err == nil {
+			result.Err = fmt.Errorf("%s did not reply to envelope %s: %w", counterparty, result.EnvelopeID, streamErr)
+		}
+	}
+
+	for _, result := range batch.Results {
+		switch {
+		case result.Err != nil:
+			batch.Failed++
+		case result.Reply.Error != nil:
+			batch.Rejected++
+		default:
+			batch.Accepted++
+		}
+	}
+
+	batch.Acknowledged = streamSummary(stream.Trailer())
+	return batch, nil
+}
+
+// streamSummary parses the summary of the stream from its trailer, returning nil if
+// the peer did not report one.
+func streamSummary(trailer metadata.MD) *StreamSummary {
+	summary := &StreamSummary{}
+	for key, count := range map[string]*uint64{
+		streamReceivedKey: &summary.Received,
+		streamAcceptedKey: &summary.Accepted,
+		streamRejectedKey: &summary.Rejected,
+	} {
+		vals := trailer.Get(key)
+		if len(vals) == 0 {
+			return nil
+		}
+
+		var err error
+		if *count, err = strconv.ParseUint(vals[0], 10, 64); err != nil {
+			return nil
+		}
+	}
+	return summary
+}
+
+// Close the connections to the peers.
+func (c *Client) Close() (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, p := range c.peers {
+		if cerr := p.cc.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(c.peers, name)
+	}
+	return err
+}
+
+// connect returns the connection to the counterparty, looking up its endpoint in the
+// directory the first time it is called for the counterparty.
+func (c *Client) connect(ctx context.Context, counterparty string) (_ *peer, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if p, ok := c.peers[counterparty]; ok {
+		return p, nil
+	}
+
+	endpoint, ok := c.endpoints[counterparty]
+	if !ok {
+		if c.directory == nil {
+			return nil, fmt.Errorf("no endpoint or directory to look up %s", counterparty)
+		}
+
+		var rep *gds.LookupReply
+		if rep, err = c.directory.Lookup(ctx, counterparty); err != nil {
+			return nil, err
+		}
+		if endpoint = rep.Endpoint; endpoint == "" {
+			return nil, fmt.Errorf("%s does not have an endpoint in the directory", counterparty)
+		}
+	}
+
+	var creds grpc.DialOption
+	if creds, err = mtls.ClientCreds(endpoint, c.certs, c.pool); err != nil {
+		return nil, err
+	}
+
+	p := &peer{endpoint: endpoint}
+	if p.cc, err = grpc.Dial(endpoint, append(c.opts, creds)...); err != nil {
+		return nil, err
+	}
+	p.api = protocol.NewTRISANetworkClient(p.cc)
+
+	c.peers[counterparty] = p
+	return p, nil
+}
+
+// seal builds the payload of the message and seals it with the signing key of the
+// peer, exchanging keys with the peer if its key is not cached.
+func (c *Client) seal(ctx context.Context, p *peer, msg *Message) (_ *protocol.SecureEnvelope, err error) {
+	if msg == nil || msg.Identity == nil || msg.Transaction == nil {
+		return nil, errors.New("an identity and a transaction are required to send a transfer")
+	}
+
+	// The transaction is stamped with the time it is sent, which trisarl nodes check
+	transaction := proto.Clone(msg.Transaction).(*generic.Transaction)
+	transaction.ExtraJson = withSentAt(transaction.ExtraJson, time.Now())
+
+	payload := &protocol.Payload{}
+	if payload.Identity, err = anypb.New(msg.Identity); err != nil {
+		return nil, err
+	}
+	if payload.Transaction, err = anypb.New(transaction); err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	if key, err = c.exchangeKeys(ctx, p); err != nil {
+		return nil, err
+	}
+	return envelope.Seal(handler.New(msg.EnvelopeID, payload, nil), key)
+}
+
+// withSentAt adds the sent_at timestamp to the extra JSON object of a transaction,
+// keeping the other fields of the object; extra JSON that is not an object is replaced.
+func withSentAt(extra string, sentAt time.Time) string {
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(extra), &fields); err != nil || fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields["sent_at"] = sentAt.Format(time.RFC3339)
+
+	data, _ := json.Marshal(fields)
+	return string(data)
+}
+
+// exchangeKeys returns the signing key of the peer, sending the signing certificate of
+// the client in a key exchange if the key of the peer is not cached.
+func (c *Client) exchangeKeys(ctx context.Context, p *peer) (_ interface{}, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.key != nil {
+		return p.key, nil
+	}
+
+	var req *protocol.SigningKey
+	if req, err = SigningKey(c.signing); err != nil {
+		return nil, err
+	}
+
+	var rep *protocol.SigningKey
+	if rep, err = p.api.KeyExchange(ctx, req); err != nil {
+		return nil, fmt.Errorf("could not exchange keys with %s: %w", p.endpoint, trisaError(err))
+	}
+
+	var pub interface{}
+	if pub, err = ParseSigningKey(rep.Data); err != nil {
+		return nil, fmt.Errorf("could not parse signing key of %s: %s", p.endpoint, err)
+	}
+
+	if _, err = envelope.Negotiate(rep.PublicKeyAlgorithm, pub); err != nil {
+		return nil, fmt.Errorf("cannot seal envelopes for the signing key of %s: %s", p.endpoint, err)
+	}
+
+	p.key = pub
+	return p.key, nil
+}
+
+// open decrypts the response of the peer with the private signing key and decodes its
+// payload.
+func (c *Client) open(out *protocol.SecureEnvelope) (reply *Reply, err error) {
+	reply = &Reply{EnvelopeID: out.Id}
+	if out.Error != nil && out.Error.Code != 0 {
+		reply.Error = out.Error
+		return reply, nil
+	}
+
+	var env *handler.Envelope
+	if env, err = envelope.Open(out, c.key); err != nil {
+		return nil, fmt.Errorf("could not open response to envelope %s: %s", out.Id, err)
+	}
+	reply.Payload = env.Payload
+
+	if msg, ok := pending.FromPayload(env.Payload); ok {
+		reply.Pending = msg
+	} else if env.Payload.Transaction != nil {
+		transaction := &generic.Transaction{}
+		if env.Payload.Transaction.UnmarshalTo(transaction) == nil {
+			reply.Transaction = transaction
+		}
+	}
+
+	if env.Payload.Identity != nil {
+		identity := &ivms101.IdentityPayload{}
+		if env.Payload.Identity.UnmarshalTo(identity) == nil {
+			reply.Identity = identity
+		}
+	}
+	return reply, nil
+}
+
+// SigningKey returns the public key of the signing certificates in the format used by
+// key exchanges, so that peers can encrypt the envelopes they send to the VASP.
+func SigningKey(certs *trust.Provider) (out *protocol.SigningKey, err error) {
+	var cert *x509.Certificate
+	if cert, err = certs.GetLeafCertificate(); err != nil {
+		return nil, fmt.Errorf("invalid local signing key: %s", err)
+	}
+
+	out = &protocol.SigningKey{
+		Version:            int64(cert.Version),
+		Signature:          cert.Signature,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		PublicKeyAlgorithm: envelope.Advertised(cert.PublicKey),
+		NotBefore:          cert.NotBefore.Format(time.RFC3339),
+		NotAfter:           cert.NotAfter.Format(time.RFC3339),
+	}
+
+	if out.Data, err = x509.MarshalPKIXPublicKey(cert.PublicKey); err != nil {
+		return nil, fmt.Errorf("could not marshal PKIX public key: %s", err)
+	}
+	return out, nil
+}
+
+// ParseSigningKey parses the public key in the data of a key exchange, which is
+// detected as either PEM or DER encoded and may be a PKIX public key, a PKCS #1 RSA
+// public key, or a full certificate, since counterparties do not agree on the format.
+func ParseSigningKey(data []byte) (pub interface{}, err error) {
+	if block, _ := pem.Decode(data); block != nil {
+		switch block.Type {
+		case "PUBLIC KEY":
+			return x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			return x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+				return nil, err
+			}
+			return cert.PublicKey, nil
+		default:
+			return nil, fmt.Errorf("unexpected PEM block %q in signing key", block.Type)
+		}
+	}
+
+	if pub, err = x509.ParsePKIXPublicKey(data); err == nil {
+		return pub, nil
+	}
+
+	if key, perr := x509.ParsePKCS1PublicKey(data); perr == nil {
+		return key, nil
+	}
+
+	if cert, cerr := x509.ParseCertificate(data); cerr == nil {
+		return cert.PublicKey, nil
+	}
+	return nil, err
+}
+
+// trisaError returns the TRISA error in the details of a gRPC status error, which is
+// how peers return TRISA errors from RPCs, or the error itself if it has none.
+func trisaError(err error) error {
+	if st, ok := status.FromError(err); ok {
+		for _, detail := range st.Details() {
+			if perr, ok := detail.(*protocol.Error); ok {
+				return perr
+			}
+		}
+	}
+	return err
+}
+
+// withTimeout returns the context with the default timeout if it has no deadline.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, Timeout)
+}
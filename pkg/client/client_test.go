@@ -0,0 +1,202 @@
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/proposal"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"google.golang.org/grpc"
+)
+
+// beneficiary rejects transfers without the date of birth of the originator with a
+// counter-proposal and accepts the others, recording the identities it received.
+type beneficiary struct {
+	protocol.TRISANetworkClient
+	key        *rsa.PrivateKey
+	client     *rsa.PublicKey
+	received   []*ivms101.IdentityPayload
+	envelopeID []string
+}
+
+func (b *beneficiary) Transfer(ctx context.Context, in *protocol.SecureEnvelope, opts ...grpc.CallOption) (*protocol.SecureEnvelope, error) {
+	env, err := handler.Open(in, b.key)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &ivms101.IdentityPayload{}
+	if err = env.Payload.Identity.UnmarshalTo(identity); err != nil {
+		return nil, err
+	}
+	b.received = append(b.received, identity)
+	b.envelopeID = append(b.envelopeID, in.Id)
+
+	if !proposal.OriginatorDateOfBirth.Present(identity) {
+		rejection, err := proposal.New("date of birth required", proposal.OriginatorDateOfBirth).Reject(protocol.IncompleteIdentity)
+		if err != nil {
+			return nil, err
+		}
+		return &protocol.SecureEnvelope{Id: in.Id, Error: rejection}, nil
+	}
+	return handler.New(in.Id, env.Payload, nil).Seal(b.client)
+}
+
+type birthdays struct{}
+
+func (birthdays) Provide(_ context.Context, req proposal.Requirement, identity *ivms101.IdentityPayload) error {
+	if req.Field != proposal.OriginatorDateOfBirth {
+		return errors.New("not found")
+	}
+	for _, person := range identity.Originator.OriginatorPersons {
+		person.GetNaturalPerson().DateAndPlaceOfBirth = &ivms101.DateAndPlaceOfBirth{DateOfBirth: "1970-01-01"}
+	}
+	return nil
+}
+
+func TestTransferSatisfiesCounterProposal(t *testing.T) {
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testClient := func(provider proposal.Provider) (*Client, *beneficiary) {
+		api := &beneficiary{key: peerKey, client: &clientKey.PublicKey}
+		return &Client{
+			key:       clientKey,
+			proposals: provider,
+			peers:     map[string]*peer{"beneficiary": {endpoint: "beneficiary:443", api: api, key: &peerKey.PublicKey}},
+		}, api
+	}
+
+	msg := &Message{
+		Identity: &ivms101.IdentityPayload{
+			Originator: &ivms101.Originator{
+				OriginatorPersons: []*ivms101.Person{
+					{Person: &ivms101.Person_NaturalPerson{NaturalPerson: &ivms101.NaturalPerson{}}},
+				},
+			},
+		},
+		Transaction: &generic.Transaction{Txid: "1234", Amount: 1},
+	}
+
+	// The rejection is satisfied and the transfer is sent again with the same envelope
+	// ID and the completed identity, without modifying the identity of the message.
+	c, api := testClient(birthdays{})
+	reply, err := c.Transfer(context.Background(), "beneficiary", msg)
+	if err != nil {
+		t.Fatalf("transfer was not sent again: %s", err)
+	}
+	if len(api.received) != 2 || api.envelopeID[0] != api.envelopeID[1] || reply.EnvelopeID != api.envelopeID[0] {
+		t.Fatalf("expected the transfer to be sent twice with the same envelope ID, got %v", api.envelopeID)
+	}
+	if !proposal.OriginatorDateOfBirth.Present(api.received[1]) {
+		t.Error("the transfer was not sent again with the date of birth")
+	}
+	if proposal.OriginatorDateOfBirth.Present(msg.Identity) {
+		t.Error("the identity of the message was modified")
+	}
+
+	// Without a proposal provider the rejection is returned
+	c, api = testClient(nil)
+	_, err = c.Transfer(context.Background(), "beneficiary", msg)
+	if perr, ok := err.(*protocol.Error); !ok || perr.Code != protocol.IncompleteIdentity {
+		t.Fatalf("expected the counter-proposal to be returned, got %v", err)
+	}
+	if len(api.received) != 1 {
+		t.Errorf("expected the transfer to be sent once, got %d", len(api.received))
+	}
+}
+
+func TestParseSigningKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkixKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecPKIXKey, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "beneficiary.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs1Key := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+	encode := func(typ string, der []byte) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		pub  interface{}
+	}{
+		{"pkix der", pkixKey, &key.PublicKey},
+		{"pkix pem", encode("PUBLIC KEY", pkixKey), &key.PublicKey},
+		{"ecdsa pkix der", ecPKIXKey, &ecKey.PublicKey},
+		{"pkcs1 der", pkcs1Key, &key.PublicKey},
+		{"pkcs1 pem", encode("RSA PUBLIC KEY", pkcs1Key), &key.PublicKey},
+		{"certificate der", cert, &key.PublicKey},
+		{"certificate pem", encode("CERTIFICATE", cert), &key.PublicKey},
+		{"unexpected pem block", encode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)), nil},
+		{"mislabeled pem block", encode("PUBLIC KEY", pkcs1Key), nil},
+		{"garbage", []byte("not a key"), nil},
+		{"empty", nil, nil},
+	}
+
+	for _, tc := range tests {
+		pub, err := ParseSigningKey(tc.data)
+		if tc.pub == nil {
+			if err == nil {
+				t.Errorf("%s: expected an error, got a %T", tc.name, pub)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: could not parse signing key: %s", tc.name, err)
+			continue
+		}
+
+		if equal, ok := pub.(interface{ Equal(crypto.PublicKey) bool }); !ok || !equal.Equal(tc.pub) {
+			t.Errorf("%s: parsed the wrong key", tc.name)
+		}
+	}
+}
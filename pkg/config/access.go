@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// AccessConfig restricts which peers can connect to the server by the common name or
+// the SHA-256 fingerprint of their mTLS certificate, e.g. to cut off a compromised
+// counterparty immediately rather than waiting for the directory service to revoke its
+// certificate. Denied peers are rejected even if they are allowed; if the allow list is
+// not empty, only the peers on the allow list can connect.
+type AccessConfig struct {
+	Allow AccessList
+	Deny  AccessList
+}
+
+// AccessList is a list of peer common names and certificate fingerprints. In the
+// environment the entries are comma separated, e.g.
+// TRISA_ACCESS_DENY="vasp.example.com,sha256:9f86d081884c7d65...". Fingerprints are hex
+// encoded and may be prefixed with sha256: or separated by colons.
+type AccessList []string
+
+// Decode implements envconfig.Decoder
+func (l *AccessList) Decode(value string) error {
+	var list AccessList
+	for _, item := range strings.Split(value, ",") {
+		if item = normalizeAccess(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*l = list
+	return nil
+}
+
+// Contains returns true if the common name or the certificate fingerprint is listed.
+func (l AccessList) Contains(commonName, fingerprint string) bool {
+	commonName, fingerprint = normalizeAccess(commonName), normalizeAccess(fingerprint)
+	for _, item := range l {
+		if (commonName != "" && item == commonName) || (fingerprint != "" && item == fingerprint) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed returns true if the peer with the common name and certificate fingerprint
+// is allowed to connect to the server.
+func (c AccessConfig) Allowed(commonName, fingerprint string) bool {
+	if c.Deny.Contains(commonName, fingerprint) {
+		return false
+	}
+	return len(c.Allow) == 0 || c.Allow.Contains(commonName, fingerprint)
+}
+
+// IsZero returns true if no peers are allowed or denied.
+func (c AccessConfig) IsZero() bool {
+	return len(c.Allow) == 0 && len(c.Deny) == 0
+}
+
+// String returns the lists in the format used in the environment.
+func (l AccessList) String() string {
+	return strings.Join(l, ",")
+}
+
+// Common names are case insensitive; fingerprints are normalized to lower case hex
+// without colons or the sha256: prefix so that they can be compared to common names.
+func normalizeAccess(item string) string {
+	item = strings.ToLower(strings.TrimSpace(item))
+	fingerprint := strings.Replace(strings.TrimPrefix(item, "sha256:"), ":", "", -1)
+	if len(fingerprint) == 64 {
+		if _, err := hex.DecodeString(fingerprint); err == nil {
+			return fingerprint
+		}
+	}
+	return item
+}
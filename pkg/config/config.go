@@ -2,27 +2,505 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/kelseyhightower/envconfig"
 	"github.com/rs/zerolog"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
 )
 
 type Config struct {
-	BindAddr       string          `split_words:"true" default:":2384"`
-	Maintenance    bool            `split_words:"true" default:"false"`
-	DirectoryAddr  string          `split_words:"true" default:"api.trisatest.net:443"`
-	ServerCerts    string          `split_words:"true" required:"true"`
-	ServerCertPool string          `envconfig:"TRISA_SERVER_CERTPOOL" required:"true"`
-	LogLevel       LogLevelDecoder `split_words:"true" default:"info"`
-	ConsoleLog     bool            `split_words:"true" default:"false"`
-	processed      bool
+	Environment            Profile          `default:"production"`
+	BindAddr               string           `split_words:"true" default:":2384"`
+	SocketMode             FileMode         `split_words:"true" default:"0660"`
+	DrainTimeout           time.Duration    `split_words:"true" default:"30s"`
+	MaxEnvelopeSize        int              `split_words:"true" default:"8388608"`
+	TransactionTimeout     time.Duration    `split_words:"true" default:"72h"`
+	ReplyTimeout           time.Duration    `split_words:"true" default:"24h"`
+	PendingTimeout         time.Duration    `split_words:"true" default:"0"`
+	ExpiryInterval         time.Duration    `split_words:"true" default:"1h"`
+	RejectExpired          bool             `split_words:"true" default:"false"`
+	Maintenance            bool             `split_words:"true" default:"false"`
+	MaintenanceFile        string           `split_words:"true"`
+	MaintenanceWindows     string           `split_words:"true"`
+	MaintenanceNotice      time.Duration    `split_words:"true" default:"15m"`
+	HealthCheckMinInterval time.Duration    `split_words:"true" default:"30m"`
+	HealthCheckMaxInterval time.Duration    `split_words:"true" default:"1h"`
+	HealthCheckPeers       HealthCheckPeers `split_words:"true"`
+	DirectoryAddr          string           `split_words:"true" default:"api.trisatest.net:443"`
+	ServerCerts            string           `split_words:"true" required:"true"`
+	ServerCertPool         string           `envconfig:"TRISA_SERVER_CERTPOOL" required:"true"`
+	CertsPassword          string           `envconfig:"TRISA_SERVER_CERTS_PASSWORD"`
+	WatchCerts             bool             `split_words:"true" default:"true"`
+	SigningCerts           string           `split_words:"true"`
+	SigningKey             string           `split_words:"true"`
+	LogLevel               LogLevelDecoder  `split_words:"true" default:"info"`
+	ConsoleLog             bool             `split_words:"true" default:"false"`
+	Echo                   bool             `default:"false"`
+	Registry               bool             `default:"false"`
+	Listeners              Listeners
+	Identities             Identities
+	Exchange               ExchangeConfig
+	Access                 AccessConfig
+	Peers                  PeerPolicies
+	Payloads               PayloadsConfig
+	Timestamps             TimestampsConfig
+	RateLimit              RateLimitConfig `split_words:"true"`
+	Duplicates             DuplicatesConfig
+	Rejection              RejectionConfig
+	Retries                RetriesConfig
+	Screening              ScreeningConfig
+	TravelRule             TravelRuleConfig `split_words:"true"`
+	Chain                  ChainConfig
+	Webhooks               WebhooksConfig
+	EventBus               EventBusConfig `split_words:"true"`
+	Notifications          NotificationsConfig
+	GRPC                   GRPCConfig
+	Streams                StreamsConfig
+	Metrics                MetricsConfig
+	Tracing                TracingConfig
+	Debug                  DebugConfig
+	Probes                 ProbesConfig
+	Admin                  AdminConfig
+	Sunrise                SunriseConfig
+	Audit                  AuditConfig
+	Features               FeaturesConfig
+	Storage                StorageConfig
+	processed              bool
+	path                   string
 }
 
-// New creates a new Config object, loading environment variables and defaults.
+// DefaultEncryptedFields are the fields of persisted identities that are sealed with the
+// field key unless the encrypted fields are configured.
+const DefaultEncryptedFields = "date_and_place_of_birth,national_identification,geographic_addresses"
+
+// UnixScheme is the prefix of bind addresses that are unix domain socket paths.
+const UnixScheme = "unix://"
+
+// FileScheme is the prefix of keychains that are directories of PEM files.
+const FileScheme = "file://"
+
+// ListenAddr splits a bind address into the network and address to listen on; bind
+// addresses are TCP host:port pairs unless they are unix:// socket paths, e.g. so that
+// the server can be reached by a local reverse proxy without opening a TCP port.
+func ListenAddr(addr string) (network, address string) {
+	if strings.HasPrefix(addr, UnixScheme) {
+		return "unix", strings.TrimPrefix(addr, UnixScheme)
+	}
+	return "tcp", addr
+}
+
+// FileMode decodes file permissions in octal, e.g. 0660 or 0o660 for the unix socket
+// file. Note that in YAML config files the mode must be quoted, otherwise it is parsed
+// as an octal number and converted to decimal before it is decoded.
+type FileMode os.FileMode
+
+// Decode implements envconfig.Decoder
+func (m *FileMode) Decode(value string) error {
+	value = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(value)), "0o")
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("invalid file mode %q, specify permissions in octal, e.g. 0660", value)
+	}
+	*m = FileMode(mode)
+	return nil
+}
+
+// StorageConfig specifies where the local state of the server is persisted. If no
+// path is specified the state is kept in memory and lost when the server stops. The
+// records can be encrypted at rest with a 32 byte key (loaded from a file or a secret
+// URI) or with a key derived from a passphrase. Records encrypted with previous keys
+// (a comma separated list of key locations) can still be read until they are rekeyed.
+// Decrypted envelope payloads are sealed with the payload key, a 32 byte key that is
+// loaded like the encryption key and is required to persist payloads; payloads sealed
+// with previous payload keys can still be read until they are resealed. The comma
+// separated EncryptedFields of persisted identities, e.g. dates of birth, are sealed
+// with the field key, a separate 32 byte key, if it is configured.
+type StorageConfig struct {
+	Path                string `split_words:"true"`
+	EncryptionKey       string `split_words:"true"`
+	Passphrase          string `split_words:"true"`
+	PreviousKeys        string `split_words:"true"`
+	PayloadKey          string `split_words:"true"`
+	PreviousPayloadKeys string `split_words:"true"`
+	FieldKey            string `split_words:"true"`
+	PreviousFieldKeys   string `split_words:"true"`
+	EncryptedFields     string `split_words:"true" default:"date_and_place_of_birth,national_identification,geographic_addresses"`
+}
+
+// ExchangeConfig enables a dedicated exchange key for envelope encryption that is
+// advertised in key exchanges in place of the key of the signing certificate, so that
+// reissuing the mTLS certificates does not change the key peers seal envelopes with.
+// The private key is loaded from Key (a PEM encoded RSA or ECDSA key in a file or a
+// secret URI, a PKCS #11 URI of an RSA key in an HSM, or a gcpkms:// or awskms:// KMS
+// key) or, if no key is configured, generated as an RSA key with KeySize bits on first
+// boot and kept in the store. Envelopes sealed with the comma separated PreviousKeys
+// can still be opened. Algorithms are the public key algorithms accepted in key
+// exchanges, whether or not the exchange key is enabled; ECDSA keys, whether local or
+// of peers, require the ECDH key wrap, which is not part of the TRISA protocol and must
+// be enabled by accepting ECDSA. Generated keys are rotated every RotateEvery (zero
+// does not rotate the key): the new key is pushed to the known peers and the old key
+// still opens envelopes for the GracePeriod (zero keeps old keys indefinitely) and is
+// then retired; the RetainKeys most recently retired keys are kept in the store to open
+// archived envelopes. Keys are exchanged again with peers whose keys expire within
+// RenewBefore before envelopes are sealed for them; if OnStartup is true, keys are
+// exchanged with every peer in the address book in the background when the server
+// starts. The keys of peers, and generated exchange keys, are kept in the Keychain:
+// "database" keeps them in the store, "memory" keeps them in memory only so that keys
+// are exchanged again after a restart, and a file:// directory keeps them in PEM files
+// in the directory.
+type ExchangeConfig struct {
+	Enabled      bool `default:"false"`
+	Key          string
+	PreviousKeys string        `split_words:"true"`
+	KeySize      int           `split_words:"true" default:"4096"`
+	Algorithms   string        `default:"RSA"`
+	RotateEvery  time.Duration `split_words:"true" default:"0"`
+	GracePeriod  time.Duration `split_words:"true" default:"168h"`
+	RetainKeys   int           `split_words:"true" default:"0"`
+	RenewBefore  time.Duration `split_words:"true" default:"24h"`
+	OnStartup    bool          `split_words:"true" default:"false"`
+	Keychain     string        `default:"database"`
+}
+
+// KeychainDir returns the directory of the keychain if it is a file:// directory.
+func (c ExchangeConfig) KeychainDir() string {
+	if strings.HasPrefix(c.Keychain, FileScheme) {
+		return strings.TrimPrefix(c.Keychain, FileScheme)
+	}
+	return ""
+}
+
+// RateLimitConfig is the default token bucket rate limit of the transfers from each
+// peer, in transfers per second, which can be overridden by the policy of the peer. A
+// rate of zero does not limit transfers.
+type RateLimitConfig struct {
+	RPS   float64 `default:"0"`
+	Burst int     `default:"0"`
+}
+
+// TimestampsConfig determines how the sent_at timestamps of incoming transfers are
+// checked. Transfers without a timestamp are rejected if Required is true, and
+// transfers sent further from the current time than MaxSkew, in either direction, are
+// rejected as stale (zero does not check the clock skew).
+type TimestampsConfig struct {
+	Required bool          `default:"false"`
+	MaxSkew  time.Duration `split_words:"true" default:"1h"`
+}
+
+// DuplicatesConfig determines how retransmissions of secure envelopes are handled, e.g.
+// when a peer retries a transfer after a network error although it was processed. The
+// response to each envelope from a peer is kept for Window (zero disables the detection)
+// for up to MaxEntries envelopes; an identical envelope with the same ID from the same
+// peer is answered with the response that was sent before if Action is "replay" or
+// rejected with a duplicate error if Action is "reject", rather than being processed
+// again.
+type DuplicatesConfig struct {
+	Window     time.Duration `default:"24h"`
+	MaxEntries int           `split_words:"true" default:"10000"`
+	Action     string        `default:"replay"`
+}
+
+// Actions taken on retransmitted envelopes.
+const (
+	DuplicateReplay = "replay"
+	DuplicateReject = "reject"
+)
+
+// RejectionConfig is the error returned to peers by the default handler of the transfer
+// pipeline, which rejects every transfer unless echo mode is enabled. Operators that
+// perform Travel Rule compliance should set a transfer handler instead.
+type RejectionConfig struct {
+	Code    ErrorCode `default:"NO_COMPLIANCE"`
+	Message string    `default:"Rotational Labs is not a VASP and therefore cannot perform Travel Rule compliance"`
+	Retry   bool      `default:"false"`
+}
+
+// ErrorCode decodes a TRISA error code from its name (e.g. NO_COMPLIANCE) or number.
+type ErrorCode protocol.Error_Code
+
+// Decode implements envconfig.Decoder
+func (c *ErrorCode) Decode(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if code, ok := protocol.Error_Code_value[value]; ok {
+		*c = ErrorCode(code)
+		return nil
+	}
+
+	if code, err := strconv.ParseInt(value, 10, 32); err == nil {
+		if _, ok := protocol.Error_Code_name[int32(code)]; ok {
+			*c = ErrorCode(code)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown TRISA error code %q", value)
+}
+
+// GRPCConfig tunes the gRPC server, zero values use the gRPC defaults except for the
+// maximum received message size, which is raised so that large IVMS101 payloads are
+// not rejected by the gRPC default limit of 4MB. The keepalive time and timeout control
+// the pings the server sends on idle connections, e.g. so that the connections of long
+// lived transfer streams are not silently dropped by NATs and load balancers, while the
+// keepalive min time and permit without stream are the policy for pings from clients.
+// Compression is the compressor used for calls to peers, e.g. gzip; the server always
+// accepts gzip compressed messages and compresses responses to them.
+type GRPCConfig struct {
+	MaxRecvMsgSize               int           `split_words:"true" default:"16777216"`
+	MaxSendMsgSize               int           `split_words:"true"`
+	MaxConcurrentStreams         uint32        `split_words:"true"`
+	ConnectionTimeout            time.Duration `split_words:"true" default:"120s"`
+	MaxConnectionIdle            time.Duration `split_words:"true"`
+	MaxConnectionAge             time.Duration `split_words:"true"`
+	MaxConnectionAgeGrace        time.Duration `split_words:"true"`
+	KeepaliveTime                time.Duration `split_words:"true"`
+	KeepaliveTimeout             time.Duration `split_words:"true"`
+	KeepaliveMinTime             time.Duration `split_words:"true" default:"5m"`
+	KeepalivePermitWithoutStream bool          `split_words:"true" default:"false"`
+	Compression                  string        `split_words:"true"`
+}
+
+// Compressors of the calls to peers; calls are not compressed by default.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// StreamsConfig limits the transfer streams held open by peers so that peers cannot
+// exhaust the memory of the server; a limit of zero on open streams is unlimited.
+// MaxInFlight is the maximum number of unanswered messages per stream and only applies
+// if the concurrent streams feature is enabled, otherwise the messages on a stream are
+// handled one at a time.
+type StreamsConfig struct {
+	MaxOpen     int `split_words:"true" default:"256"`
+	MaxPerPeer  int `split_words:"true" default:"8"`
+	MaxInFlight int `split_words:"true" default:"16"`
+}
+
+// MetricsConfig enables the Prometheus metrics endpoint, which is served on its own
+// HTTP listener so that it can be scraped without TRISA mTLS credentials.
+type MetricsConfig struct {
+	Enabled bool   `default:"false"`
+	Addr    string `default:":9090"`
+	Path    string `default:"/metrics"`
+}
+
+// TracingConfig enables OpenTelemetry tracing of the TRISA RPCs and the stages of the
+// transfer pipeline. Spans are exported to an OTLP/HTTP collector endpoint, e.g. the
+// OpenTelemetry collector of the service mesh. A sample ratio less than 1 only traces
+// a fraction of the RPCs that are not already sampled by the calling peer.
+type TracingConfig struct {
+	Enabled     bool    `default:"false"`
+	Endpoint    string  `default:"http://localhost:4318"`
+	ServiceName string  `split_words:"true" default:"trisarl"`
+	SampleRatio float64 `split_words:"true" default:"1.0"`
+}
+
+// DebugConfig enables the pprof profiles and runtime stats of the server on their own
+// HTTP listener, which is bound to the loopback interface by default since profiles
+// expose internal details of the server. Reflection registers the gRPC reflection
+// service on the TRISA listeners so that tools like grpcurl can introspect the TRISA
+// services; it is enabled by default in development only.
+type DebugConfig struct {
+	Enabled    bool   `default:"false"`
+	Addr       string `default:"127.0.0.1:6060"`
+	Reflection bool   `default:"false"`
+}
+
+// ProbesConfig enables the Kubernetes liveness (/healthz) and readiness (/readyz) probes,
+// which are served on their own plain HTTP listener since the kubelet cannot present
+// TRISA mTLS credentials to probe the TRISA port.
+type ProbesConfig struct {
+	Enabled bool   `default:"false"`
+	Addr    string `default:":8080"`
+}
+
+// AdminConfig enables the admin API of the server on its own HTTP listener, e.g. to
+// manage the customer accounts of the address book. The listener is bound to the
+// loopback interface by default and every request must present the bearer token, which
+// may be a secret URI, since the admin API exposes and modifies customer PII.
+type AdminConfig struct {
+	Enabled bool   `default:"false"`
+	Addr    string `default:"127.0.0.1:7070"`
+	Token   string
+}
+
+// SunriseConfig enables the sunrise fallback for outgoing transfers to VASPs that are
+// not yet in the TRISA directory: the payload is encrypted and stored behind a secure
+// link that expires, and the link is emailed to the compliance contact of the VASP,
+// who acknowledges the transfer on the sunrise listener. URL is the public https base
+// URL of the listener that is used in the links. The listener serves TLS with the PEM
+// encoded TLSCertFile and TLSKeyFile if they are set; otherwise it serves plain HTTP and
+// must be behind a TLS terminating proxy. The SMTP password may be a secret URI.
+type SunriseConfig struct {
+	Enabled      bool   `default:"false"`
+	Addr         string `default:":8200"`
+	URL          string
+	TLSCertFile  string        `split_words:"true"`
+	TLSKeyFile   string        `split_words:"true"`
+	Expires      time.Duration `default:"168h"`
+	From         string
+	SMTPAddr     string `split_words:"true"`
+	SMTPUsername string `split_words:"true"`
+	SMTPPassword string `split_words:"true"`
+}
+
+// ScreeningConfig screens the originators of incoming transfers against sanctions lists
+// loaded from the comma separated CSV files in Lists and with the HTTP screening
+// provider at URL, which is sent Token as a bearer token (a token or a secret URI).
+// Transfers with sanctioned originators are rejected if Action is "reject" or held for
+// review with a pending message if Action is "flag".
+type ScreeningConfig struct {
+	Enabled bool `default:"false"`
+	Lists   string
+	URL     string
+	Token   string
+	Timeout time.Duration `default:"10s"`
+	Action  string        `default:"reject"`
+}
+
+// Actions taken on transfers with sanctioned originators.
+const (
+	ScreeningReject = "reject"
+	ScreeningFlag   = "flag"
+)
+
+// ChainConfig verifies that the transactions of Travel Rule exchanges settle on chain
+// with the chain-data provider at URL, which is sent Token as a bearer token (a token or
+// a secret URI). Settlements are checked every Interval until they have the required
+// number of Confirmations or until Window has passed since the exchange; the amount on
+// chain may differ from the amount of the Travel Rule message by the Tolerance, a
+// fraction of the amount, e.g. to allow for network fees. If Acknowledge is set, the
+// final acknowledgment of a completed transaction is sent to the peer once its
+// settlement is confirmed, which closes the transaction on both sides. The transactions
+// are reconciled with their settlements every ReconcileInterval, which flags mismatched
+// settlements and Travel Rule records that never settled on chain.
+type ChainConfig struct {
+	Enabled           bool `default:"false"`
+	URL               string
+	Token             string
+	Timeout           time.Duration `default:"10s"`
+	Interval          time.Duration `default:"5m"`
+	Window            time.Duration `default:"168h"`
+	Confirmations     int           `default:"1"`
+	Tolerance         float64       `default:"0"`
+	Acknowledge       bool          `default:"false"`
+	ReconcileInterval time.Duration `split_words:"true" default:"24h"`
+}
+
+// EventBusConfig publishes the events of the node to the message Broker at URL, either
+// a NATS server (nats:// or tls://) or a Kafka REST Proxy (http:// or https://), which
+// is sent Token as an auth or bearer token (a token or a secret URI). Events are
+// published to the subjects or topics named after their type with the Prefix, e.g.
+// trisa.envelope.received, serialized with the Encoding ("json" or "cloudevents"), from
+// a queue of QueueSize events; each event must be published within the Timeout.
+type EventBusConfig struct {
+	Enabled   bool   `default:"false"`
+	Broker    string `default:"nats"`
+	URL       string
+	Token     string
+	Prefix    string        `default:"trisa"`
+	Encoding  string        `default:"json"`
+	Timeout   time.Duration `default:"10s"`
+	QueueSize int           `split_words:"true" default:"1000"`
+}
+
+// Message brokers of the event bus.
+const (
+	BrokerNATS  = "nats"
+	BrokerKafka = "kafka"
+)
+
+// NotificationsConfig alerts the compliance officers when an incoming transfer is
+// queued for review or its originator matches a sanctions list. Notifications are posted
+// to the comma separated Slack incoming webhook URLs in SlackWebhooks (each a URL or a
+// secret URI) and emailed to the comma separated To addresses from the From address with
+// the SMTP server at SMTPAddr; the SMTP password may be a secret URI. ReviewURL is the
+// base URL of the review queue of the compliance tooling, which is linked with the
+// envelope ID of the transfer appended. Notifications are sent from a queue of QueueSize
+// notifications and each must be sent within the Timeout.
+type NotificationsConfig struct {
+	Enabled       bool   `default:"false"`
+	SlackWebhooks string `split_words:"true"`
+	SMTPAddr      string `split_words:"true"`
+	SMTPUsername  string `split_words:"true"`
+	SMTPPassword  string `split_words:"true"`
+	From          string
+	To            string
+	ReviewURL     string        `split_words:"true"`
+	Timeout       time.Duration `default:"30s"`
+	QueueSize     int           `split_words:"true" default:"100"`
+}
+
+// RetriesConfig schedules automatic retries of outgoing transfers and key exchanges
+// that the counterparty rejected with a retryable TRISA error, e.g. because it does not
+// have the signing key of the node yet. The first retry is attempted after Backoff and
+// the backoff doubles with every attempt up to MaxBackoff, with jitter so that retries
+// to the same peer are spread out; the transfer is rejected after MaxAttempts attempts.
+// Due retries are checked every Interval. The payloads of retried transfers are kept in
+// the store until they are delivered, sealed with the storage payload key.
+type RetriesConfig struct {
+	Enabled     bool          `default:"false"`
+	MaxAttempts int           `split_words:"true" default:"5"`
+	Backoff     time.Duration `default:"30s"`
+	MaxBackoff  time.Duration `split_words:"true" default:"1h"`
+	Interval    time.Duration `default:"15s"`
+}
+
+// AuditConfig controls the audit records of the server. Every mTLS handshake is logged
+// with the subject, issuer, and serial of the peer certificate and the negotiated cipher
+// suite and TLS version; if PersistHandshakes is set the handshakes are also appended to
+// the audit log in the store as evidence of who connected with which credentials. If
+// PersistEnvelopes is set every secure envelope received from or sent to peers is kept
+// in the store as the Travel Rule record of the transfer, encrypted as it was sent; the
+// decrypted payloads, which contain the PII of the originator and beneficiary, are only
+// kept if PersistPayloads is set as well, sealed with the storage payload key. If
+// PersistKeyUsage is set every use of an envelope key, i.e. envelopes opened with the
+// private keys of the node, envelopes sealed for peers, and keys advertised in key
+// exchanges, is appended to the key usage log in the store. Records of the key usage log
+// are removed once they are older than KeyUsageRetention, unless it is zero.
+type AuditConfig struct {
+	PersistHandshakes bool          `split_words:"true" default:"false"`
+	PersistEnvelopes  bool          `split_words:"true" default:"false"`
+	PersistPayloads   bool          `split_words:"true" default:"false"`
+	PersistKeyUsage   bool          `split_words:"true" default:"false"`
+	KeyUsageRetention time.Duration `split_words:"true" default:"2160h"`
+}
+
+// FeaturesConfig gates experimental subsystems so that they can be enabled
+// independently of each other without requiring a different build.
+type FeaturesConfig struct {
+	ConcurrentStreams bool `split_words:"true" default:"false"`
+}
+
+// New creates a new Config object, loading environment variables and defaults. The
+// configuration is validated and all validation errors are returned together.
+// Defaults that depend on the environment profile are applied before the struct tag
+// defaults, e.g. console logging is enabled by default in development.
 func New() (_ Config, err error) {
-	var conf Config
-	if err = envconfig.Process("trisa", &conf); err != nil {
+	return load(nil)
+}
+
+// load processes the configuration from the environment, falling back to the values
+// of the config file and then to the defaults of the profile, which is selected by
+// either the environment or the config file. The process environment is not modified.
+func load(file map[string]string) (conf Config, err error) {
+	env, ok := os.LookupEnv("TRISA_ENVIRONMENT")
+	if !ok {
+		env = file["TRISA_ENVIRONMENT"]
+	}
+
+	var profile Profile
+	if profile, err = ParseProfile(env); err != nil {
+		return Config{}, err
+	}
+
+	if err = process(&conf, file, profileDefaults[profile]); err != nil {
+		return Config{}, err
+	}
+
+	if err = conf.Validate(); err != nil {
 		return Config{}, err
 	}
 
@@ -30,6 +508,17 @@ func New() (_ Config, err error) {
 	return conf, nil
 }
 
+// Reload the configuration from the same sources it was originally loaded from, e.g.
+// the environment and the config file if one was specified.
+func (c Config) Reload() (Config, error) {
+	return Load(c.path)
+}
+
+// Path returns the config file the configuration was loaded from, if any.
+func (c Config) Path() string {
+	return c.path
+}
+
 func (c Config) GetLogLevel() zerolog.Level {
 	return zerolog.Level(c.LogLevel)
 }
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Aliases map nested configuration file keys to the environment variables of the
+// top-level configuration fields, so that the config file can be organized into
+// sections even though the environment variables are flat. Any key that is not
+// aliased is mapped by joining the section and key names with an underscore, e.g.
+// storage.path is mapped to TRISA_STORAGE_PATH.
+var aliases = map[string]string{
+	"server.bind_addr":           "TRISA_BIND_ADDR",
+	"server.drain_timeout":       "TRISA_DRAIN_TIMEOUT",
+	"server.socket_mode":         "TRISA_SOCKET_MODE",
+	"server.max_envelope_size":   "TRISA_MAX_ENVELOPE_SIZE",
+	"server.transaction_timeout": "TRISA_TRANSACTION_TIMEOUT",
+	"server.reply_timeout":       "TRISA_REPLY_TIMEOUT",
+	"server.pending_timeout":     "TRISA_PENDING_TIMEOUT",
+	"server.expiry_interval":     "TRISA_EXPIRY_INTERVAL",
+	"server.reject_expired":      "TRISA_REJECT_EXPIRED",
+	"server.maintenance":         "TRISA_MAINTENANCE",
+	"server.maintenance_file":    "TRISA_MAINTENANCE_FILE",
+	"server.maintenance_windows": "TRISA_MAINTENANCE_WINDOWS",
+	"server.maintenance_notice":  "TRISA_MAINTENANCE_NOTICE",
+	"server.certs":               "TRISA_SERVER_CERTS",
+	"server.certpool":            "TRISA_SERVER_CERTPOOL",
+	"server.watch_certs":         "TRISA_WATCH_CERTS",
+	"directory.addr":             "TRISA_DIRECTORY_ADDR",
+	"logging.level":              "TRISA_LOG_LEVEL",
+	"logging.console":            "TRISA_CONSOLE_LOG",
+}
+
+// Lists in the config file are joined with commas unless the items may contain commas
+// themselves, e.g. cron expressions in maintenance windows are joined with semicolons.
+var separators = map[string]string{
+	"TRISA_MAINTENANCE_WINDOWS": ";",
+}
+
+// Sections of the config file that are keyed by user-defined names (e.g. the common
+// names of peers) or that are lists of objects (e.g. the listeners of the server)
+// cannot be flattened, so they are passed to the environment as JSON.
+var jsonSections = map[string]string{
+	"peers":                     "TRISA_PEERS",
+	"server.listeners":          "TRISA_LISTENERS",
+	"server.identities":         "TRISA_IDENTITIES",
+	"travel_rule.jurisdictions": "TRISA_TRAVEL_RULE_JURISDICTIONS",
+	"travel_rule.rates":         "TRISA_TRAVEL_RULE_RATES",
+	"webhooks.endpoints":        "TRISA_WEBHOOKS_ENDPOINTS",
+}
+
+// Load the configuration from a YAML or TOML file (detected by the file extension) and
+// merge it with the environment. Environment variables that are set take precedence
+// over the values in the config file, and defaults are applied to any values that are
+// not specified by either. If path is empty, the configuration is loaded from the
+// environment alone.
+func Load(path string) (_ Config, err error) {
+	if path == "" {
+		return New()
+	}
+
+	var values map[string]string
+	if values, err = ReadFile(path); err != nil {
+		return Config{}, err
+	}
+
+	// The environment takes precedence over the config file
+	var conf Config
+	if conf, err = load(values); err != nil {
+		return Config{}, err
+	}
+	conf.path = path
+	return conf, nil
+}
+
+// ReadFile parses a YAML or TOML config file and returns the flattened values keyed by
+// the environment variable that each value corresponds to.
+func ReadFile(path string) (_ map[string]string, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return nil, fmt.Errorf("could not read config file: %s", err)
+	}
+
+	tree := make(map[string]interface{})
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		if err = yaml.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("could not parse yaml config: %s", err)
+		}
+	case ".toml":
+		if err = toml.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("could not parse toml config: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("unhandled config file extension %q", ext)
+	}
+
+	values := make(map[string]string)
+	if err = flatten("", tree, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// flatten the nested config tree into environment variable keys.
+func flatten(prefix string, tree map[string]interface{}, values map[string]string) (err error) {
+	for key, val := range tree {
+		path := strings.ToLower(key)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+
+		if key, ok := jsonSections[path]; ok {
+			var data []byte
+			if data, err = json.Marshal(normalize(val)); err != nil {
+				return fmt.Errorf("could not parse %s section: %s", path, err)
+			}
+			values[key] = string(data)
+			continue
+		}
+
+		switch v := val.(type) {
+		case map[string]interface{}:
+			if err = flatten(path, v, values); err != nil {
+				return err
+			}
+		case map[interface{}]interface{}:
+			// YAML v2 decodes nested maps with interface keys
+			section := make(map[string]interface{}, len(v))
+			for k, sv := range v {
+				section[fmt.Sprintf("%v", k)] = sv
+			}
+			if err = flatten(path, section, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprintf("%v", item))
+			}
+			key, sep := envkey(path), ","
+			if alt, ok := separators[key]; ok {
+				sep = alt
+			}
+			values[key] = strings.Join(items, sep)
+		case nil:
+			continue
+		default:
+			values[envkey(path)] = fmt.Sprintf("%v", v)
+		}
+	}
+	return nil
+}
+
+// normalize converts the maps decoded by YAML v2, which have interface keys, into maps
+// with string keys so that the value can be marshaled as JSON.
+func normalize(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[fmt.Sprintf("%v", k)] = normalize(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = normalize(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			out = append(out, normalize(item))
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func envkey(path string) string {
+	if alias, ok := aliases[path]; ok {
+		return alias
+	}
+	return "TRISA_" + strings.ToUpper(strings.Replace(path, ".", "_", -1))
+}
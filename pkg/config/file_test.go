@@ -0,0 +1,48 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLoadDoesNotModifyEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	certs, pool := filepath.Join(dir, "certs.pem"), filepath.Join(dir, "pool.pem")
+	for _, name := range []string{certs, pool} {
+		if err := ioutil.WriteFile(name, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(dir, "trisa.yaml")
+	data := "environment: development\nserver:\n  certs: " + certs + "\n  certpool: " + pool + "\nlogging:\n  level: warn\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("TRISA_LOG_LEVEL", "error")
+	defer os.Unsetenv("TRISA_LOG_LEVEL")
+
+	conf, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The environment takes precedence over the file, which selects the profile
+	if conf.GetLogLevel() != zerolog.ErrorLevel {
+		t.Errorf("expected the environment to override the config file, got %s", conf.GetLogLevel())
+	}
+	if conf.ServerCerts != certs || conf.ServerCertPool != pool || !conf.Environment.IsDevelopment() || !conf.ConsoleLog {
+		t.Errorf("config file and profile defaults were not applied: %+v", conf)
+	}
+
+	for _, key := range []string{"TRISA_SERVER_CERTS", "TRISA_ENVIRONMENT", "TRISA_CONSOLE_LOG"} {
+		if _, ok := os.LookupEnv(key); ok {
+			t.Errorf("%s was set in the process environment", key)
+		}
+	}
+}
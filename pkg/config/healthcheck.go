@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HealthCheckInterval is the range of time a peer is asked to wait before it sends
+// the next health check.
+type HealthCheckInterval struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// Validate that the interval is positive and that the minimum does not exceed the maximum.
+func (i HealthCheckInterval) Validate() error {
+	if i.Min <= 0 || i.Max <= 0 {
+		return fmt.Errorf("health check intervals must be positive")
+	}
+	if i.Min > i.Max {
+		return fmt.Errorf("minimum health check interval %s exceeds maximum %s", i.Min, i.Max)
+	}
+	return nil
+}
+
+// HealthCheckPeers decodes per-peer health check interval overrides keyed by the
+// common name of the peer, e.g. "noisy.example.com=2h/4h,other.example.com=90m". If
+// only the minimum interval is specified, the maximum is twice the minimum.
+type HealthCheckPeers map[string]HealthCheckInterval
+
+// Decode implements envconfig.Decoder
+func (p *HealthCheckPeers) Decode(value string) (err error) {
+	peers := make(HealthCheckPeers)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if len(parts) != 2 || name == "" {
+			return fmt.Errorf("could not parse health check override %q: expected name=min/max", item)
+		}
+
+		var interval HealthCheckInterval
+		bounds := strings.SplitN(parts[1], "/", 2)
+		if interval.Min, err = time.ParseDuration(strings.TrimSpace(bounds[0])); err != nil {
+			return fmt.Errorf("could not parse health check override for %s: %s", name, err)
+		}
+
+		interval.Max = 2 * interval.Min
+		if len(bounds) == 2 {
+			if interval.Max, err = time.ParseDuration(strings.TrimSpace(bounds[1])); err != nil {
+				return fmt.Errorf("could not parse health check override for %s: %s", name, err)
+			}
+		}
+		peers[name] = interval
+	}
+
+	*p = peers
+	return nil
+}
+
+// HealthCheckInterval returns the interval that the peer with the specified common
+// name should wait between health checks, using the override for the peer if any.
+func (c Config) HealthCheckInterval(commonName string) HealthCheckInterval {
+	if interval, ok := c.HealthCheckPeers[strings.ToLower(commonName)]; ok {
+		return interval
+	}
+	return HealthCheckInterval{Min: c.HealthCheckMinInterval, Max: c.HealthCheckMaxInterval}
+}
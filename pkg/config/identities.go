@@ -0,0 +1,75 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Identity is an additional TRISA identity hosted by the server, e.g. for a service
+// provider that operates nodes on behalf of several VASPs. Each identity has its own
+// TRISA certificates, which are selected by the server name (SNI) that peers connect
+// to. If the trust pool or password of the identity are not specified, the trust pool
+// and password of the server certificates are used.
+type Identity struct {
+	Certs    string `json:"certs"`
+	CertPool string `json:"certpool,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// Identities are the additional TRISA identities hosted by the server. In the
+// environment the identities are comma separated certificate locations with an optional
+// trust pool location, e.g. TRISA_IDENTITIES="fixtures/vasp1.pem,fixtures/vasp2.pem=fixtures/pool.pem",
+// or a JSON array of identities; in the config file they are the identities of the
+// server section, e.g.
+//
+//	server:
+//	  identities:
+//	    - certs: fixtures/vasp1.pem
+//	      certpool: fixtures/pool.pem
+type Identities []Identity
+
+// Decode implements envconfig.Decoder
+func (i *Identities) Decode(value string) (err error) {
+	var identities Identities
+	if value = strings.TrimSpace(value); strings.HasPrefix(value, "[") {
+		if err = json.Unmarshal([]byte(value), &identities); err != nil {
+			return fmt.Errorf("could not parse identities: %s", err)
+		}
+	} else {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+
+			var identity Identity
+			if idx := strings.LastIndex(item, "="); idx >= 0 {
+				identity.Certs, identity.CertPool = item[:idx], item[idx+1:]
+			} else {
+				identity.Certs = item
+			}
+			identities = append(identities, identity)
+		}
+	}
+
+	for j := range identities {
+		identities[j].Certs = strings.TrimSpace(identities[j].Certs)
+		identities[j].CertPool = strings.TrimSpace(identities[j].CertPool)
+	}
+
+	*i = identities
+	return nil
+}
+
+// String returns the certificate locations of the identities, omitting passwords.
+func (i Identities) String() string {
+	items := make([]string, 0, len(i))
+	for _, identity := range i {
+		if identity.CertPool == "" {
+			items = append(items, identity.Certs)
+		} else {
+			items = append(items, identity.Certs+"="+identity.CertPool)
+		}
+	}
+	return strings.Join(items, ",")
+}
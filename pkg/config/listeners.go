@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Credentials of the additional listeners of the server.
+const (
+	CredentialsMTLS     = "mtls"
+	CredentialsInsecure = "insecure"
+)
+
+// Listener is an additional address the server listens on along with the credentials
+// of the listener. Listeners with TRISA mTLS credentials serve every TRISA service,
+// while insecure (plaintext) listeners only serve the TRISA health service, e.g. for
+// health probes on an internal port, since peers cannot be verified without mTLS.
+type Listener struct {
+	Addr        string `json:"addr"`
+	Credentials string `json:"credentials,omitempty"`
+}
+
+// Insecure returns true if the listener does not use TRISA mTLS credentials.
+func (l Listener) Insecure() bool {
+	return l.Credentials == CredentialsInsecure
+}
+
+// String returns the listener in the format used in the environment.
+func (l Listener) String() string {
+	if l.Credentials == "" {
+		return l.Addr
+	}
+	return l.Addr + "=" + l.Credentials
+}
+
+// Listeners are the additional addresses the server listens on besides the bind
+// address. In the environment the listeners are comma separated addresses with an
+// optional credentials suffix, e.g. TRISA_LISTENERS="[::]:2384,127.0.0.1:2385=insecure";
+// in the config file they are the listeners of the server section, e.g.
+//
+//	server:
+//	  listeners:
+//	    - addr: 127.0.0.1:2385
+//	      credentials: insecure
+type Listeners []Listener
+
+// Decode implements envconfig.Decoder
+func (l *Listeners) Decode(value string) (err error) {
+	var listeners Listeners
+	// IPv6 addresses also start with a bracket, so only valid JSON arrays are parsed
+	if value = strings.TrimSpace(value); strings.HasPrefix(value, "[") && json.Valid([]byte(value)) {
+		if err = json.Unmarshal([]byte(value), &listeners); err != nil {
+			return fmt.Errorf("could not parse listeners: %s", err)
+		}
+	} else {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+
+			var listener Listener
+			if idx := strings.LastIndex(item, "="); idx >= 0 {
+				listener.Addr, listener.Credentials = item[:idx], item[idx+1:]
+			} else {
+				listener.Addr = item
+			}
+			listeners = append(listeners, listener)
+		}
+	}
+
+	for i := range listeners {
+		listeners[i].Addr = strings.TrimSpace(listeners[i].Addr)
+		listeners[i].Credentials = strings.ToLower(strings.TrimSpace(listeners[i].Credentials))
+		if listeners[i].Credentials == "" {
+			listeners[i].Credentials = CredentialsMTLS
+		}
+	}
+
+	*l = listeners
+	return nil
+}
+
+// String returns the listeners in the format used in the environment.
+func (l Listeners) String() string {
+	items := make([]string, 0, len(l))
+	for _, listener := range l {
+		items = append(items, listener.String())
+	}
+	return strings.Join(items, ",")
+}
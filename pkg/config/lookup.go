@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"sync"
+	_ "unsafe" // required to link the lookup function of envconfig
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// envconfig reads the configuration variables with its unexported lookupEnv function,
+// which is replaced while the configuration is processed so that the values of the
+// config file can be merged with the environment without modifying the environment
+// of the process, e.g. for other goroutines or when the configuration is reloaded.
+//
+//go:linkname lookupEnv github.com/kelseyhightower/envconfig.lookupEnv
+var lookupEnv func(string) (string, bool)
+
+// Guards lookupEnv, which is shared by every call to envconfig.Process.
+var lookupmu sync.Mutex
+
+// process populates the spec with envconfig.Process, looking variables up in the
+// process environment first and then in each of the sources in order.
+func process(spec interface{}, sources ...map[string]string) error {
+	lookupmu.Lock()
+	defer lookupmu.Unlock()
+
+	environ := lookupEnv
+	defer func() { lookupEnv = environ }()
+
+	lookupEnv = func(key string) (string, bool) {
+		if val, ok := os.LookupEnv(key); ok {
+			return val, true
+		}
+		for _, source := range sources {
+			if val, ok := source[key]; ok {
+				return val, true
+			}
+		}
+		return "", false
+	}
+	return envconfig.Process("trisa", spec)
+}
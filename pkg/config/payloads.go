@@ -0,0 +1,61 @@
+package config
+
+import "strings"
+
+// TypeURLPrefix is the prefix of the type URLs of protocol buffer messages in payloads.
+const TypeURLPrefix = "type.googleapis.com/"
+
+// PayloadsConfig restricts the payload types that the node accepts from every peer to
+// the identity and transaction types listed, e.g. to disable the payload types that a
+// deployment does not process. Types are specified by their type URL or by the fully
+// qualified name of the message, e.g. trisa.data.generic.v1beta1.Transaction. An empty
+// list accepts every type that the server can parse. Note that pending messages and
+// confirmation receipts are transaction types, so they must be listed as well if the
+// transaction types are restricted.
+type PayloadsConfig struct {
+	Identity    []string
+	Transaction []string
+}
+
+// AllowsIdentity returns true if identities with the type URL are accepted.
+func (c PayloadsConfig) AllowsIdentity(typeURL string) bool {
+	return allows(c.Identity, typeURL)
+}
+
+// AllowsTransaction returns true if transactions with the type URL are accepted.
+func (c PayloadsConfig) AllowsTransaction(typeURL string) bool {
+	return allows(c.Transaction, typeURL)
+}
+
+// TypeURL returns the type URL of a payload type specified by its type URL or by the
+// fully qualified name of the message.
+func TypeURL(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.Contains(name, "/") {
+		return name
+	}
+	return TypeURLPrefix + name
+}
+
+// TypeURLs returns the type URLs of the payload types.
+func TypeURLs(names []string) []string {
+	urls := make([]string, 0, len(names))
+	for _, name := range names {
+		urls = append(urls, TypeURL(name))
+	}
+	return urls
+}
+
+// allows returns true if the list of payload types is empty or contains the type URL.
+func allows(types []string, typeURL string) bool {
+	if len(types) == 0 {
+		return true
+	}
+
+	for _, allowed := range types {
+		if TypeURL(allowed) == typeURL {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DefaultPolicy is the key of the policy that applies to peers without their own policy.
+const DefaultPolicy = "*"
+
+// Review modes of a peer policy; transfers are approved automatically by default.
+const (
+	ReviewAuto   = "auto"
+	ReviewManual = "manual"
+)
+
+// PeerPolicy determines how transfers from a counterparty are handled. Zero values
+// are unrestricted, e.g. if no payload types are specified all payload types that
+// the server can parse are allowed. The daily quotas limit the number of transfers and
+// the cumulative transfer amount accepted from the peer per UTC day. The daily amount
+// applies to each asset separately unless a Currency is set, in which case the amounts
+// of all assets are converted to the currency with the rates of the Travel Rule config.
+type PeerPolicy struct {
+	PayloadTypes   []string `json:"payload_types,omitempty"`
+	MaxAmount      float64  `json:"max_amount,omitempty"`
+	DailyTransfers int      `json:"daily_transfers,omitempty"`
+	DailyAmount    float64  `json:"daily_amount,omitempty"`
+	Currency       string   `json:"currency,omitempty"`
+	RateLimit      float64  `json:"rate_limit,omitempty"`
+	Burst          int      `json:"burst,omitempty"`
+	Review         string   `json:"review,omitempty"`
+}
+
+// Allows returns true if the payload type URL is allowed by the policy.
+func (p PeerPolicy) Allows(typeURL string) bool {
+	return allows(p.PayloadTypes, typeURL)
+}
+
+// ManualReview returns true if transfers must be reviewed before they are approved.
+func (p PeerPolicy) ManualReview() bool {
+	return p.Review == ReviewManual
+}
+
+// Validate the policy limits and review mode.
+func (p PeerPolicy) Validate() error {
+	if p.MaxAmount < 0 {
+		return fmt.Errorf("max amount cannot be negative")
+	}
+
+	if p.DailyTransfers < 0 || p.DailyAmount < 0 {
+		return fmt.Errorf("daily quotas cannot be negative")
+	}
+
+	if p.Currency != "" && len(p.Currency) != 3 {
+		return fmt.Errorf("currency must be a three letter currency code, not %q", p.Currency)
+	}
+
+	if p.RateLimit < 0 || p.Burst < 0 {
+		return fmt.Errorf("rate limit and burst cannot be negative")
+	}
+
+	switch p.Review {
+	case "", ReviewAuto, ReviewManual:
+	default:
+		return fmt.Errorf("unknown review mode %q", p.Review)
+	}
+	return nil
+}
+
+// PeerPolicies maps the common names of peers to their policies. The policy with the
+// "*" key applies to peers that do not have their own policy. In the config file the
+// policies are the peers section; in the environment they are specified as JSON, e.g.
+// TRISA_PEERS='{"vasp.example.com": {"max_amount": 1000, "review": "manual"}}'.
+type PeerPolicies map[string]PeerPolicy
+
+// Decode implements envconfig.Decoder
+func (p *PeerPolicies) Decode(value string) (err error) {
+	policies := make(PeerPolicies)
+	if value = strings.TrimSpace(value); value != "" {
+		if err = json.Unmarshal([]byte(value), &policies); err != nil {
+			return fmt.Errorf("could not parse peer policies: %s", err)
+		}
+	}
+
+	*p = make(PeerPolicies, len(policies))
+	for name, policy := range policies {
+		policy.Currency = strings.ToUpper(strings.TrimSpace(policy.Currency))
+		(*p)[strings.ToLower(strings.TrimSpace(name))] = policy
+	}
+	return nil
+}
+
+// Get returns the policy of the peer with the common name, falling back to the default
+// policy and then to an unrestricted policy.
+func (p PeerPolicies) Get(commonName string) PeerPolicy {
+	if policy, ok := p[strings.ToLower(commonName)]; ok {
+		return policy
+	}
+	return p[DefaultPolicy]
+}
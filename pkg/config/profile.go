@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Profile is the deployment environment of the server, specified with the
+// $TRISA_ENVIRONMENT variable. The profile selects defaults that are appropriate for
+// the environment and determines how strictly the configuration is validated.
+type Profile string
+
+// Deployment profiles, production is the default if no environment is specified.
+const (
+	Development Profile = "development"
+	Staging     Profile = "staging"
+	Production  Profile = "production"
+)
+
+// Defaults for each profile that are applied if neither the environment nor the
+// config file specify a value; these take precedence over the struct tag defaults.
+var profileDefaults = map[Profile]map[string]string{
+	Development: {
+		"TRISA_CONSOLE_LOG":      "true",
+		"TRISA_LOG_LEVEL":        "debug",
+		"TRISA_DEBUG_REFLECTION": "true",
+	},
+	Staging: {
+		"TRISA_CONSOLE_LOG": "false",
+		"TRISA_LOG_LEVEL":   "debug",
+	},
+	Production: {
+		"TRISA_CONSOLE_LOG": "false",
+		"TRISA_LOG_LEVEL":   "info",
+	},
+}
+
+// Decode implements envconfig.Decoder, accepting common abbreviations of the profiles.
+func (p *Profile) Decode(value string) (err error) {
+	*p, err = ParseProfile(value)
+	return err
+}
+
+// ParseProfile returns the profile for the environment name.
+func ParseProfile(value string) (Profile, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "development", "develop", "dev", "local":
+		return Development, nil
+	case "staging", "stage", "test", "testnet":
+		return Staging, nil
+	case "production", "prod", "mainnet", "":
+		return Production, nil
+	default:
+		return "", fmt.Errorf("unknown environment %q", value)
+	}
+}
+
+// IsDevelopment returns true if the checks that get in the way of local development,
+// such as self-signed or expired certificates, should be relaxed.
+func (p Profile) IsDevelopment() bool {
+	return p == Development
+}
+
+// IsProduction returns true if the configuration should be strictly validated.
+func (p Profile) IsProduction() bool {
+	return p == Production || p == ""
+}
+
+func (p Profile) String() string {
+	if p == "" {
+		return string(Production)
+	}
+	return string(p)
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Travel Rule requirements of a transfer: full IVMS101 data is required, partial data
+// (the names and account numbers of the parties) suffices, or the transfer can be
+// acknowledged automatically.
+const (
+	RequireFull    = "full"
+	RequirePartial = "partial"
+	RequireAuto    = "auto"
+)
+
+// DefaultJurisdiction is the key of the jurisdiction that applies to transfers whose
+// parties are not in a configured jurisdiction.
+const DefaultJurisdiction = "*"
+
+// TravelRuleConfig determines which data incoming transfers require under the Travel
+// Rule of the jurisdictions of their originators and beneficiaries. The amount of the
+// transfer is converted to the currency of each jurisdiction with the Rates of its asset
+// and compared with the threshold of the jurisdiction; transfers that cannot be
+// converted because the rate of their asset is unknown have the Unpriced requirement.
+//
+// If Minimize is true, the Redact fields of the originator and beneficiary persons are
+// removed from the identity of transfers that do not require full data before the
+// identity is stored or passed on to the transfer handler, so that the node does not
+// keep personal information that the Travel Rule does not require.
+type TravelRuleConfig struct {
+	Enabled       bool `default:"false"`
+	Jurisdictions Jurisdictions
+	Rates         AssetRates
+	Unpriced      string   `default:"full"`
+	Minimize      bool     `default:"false"`
+	Redact        []string `default:"national_identification,date_and_place_of_birth,customer_identification"`
+}
+
+// Fields of the natural and legal persons of the originator and beneficiary that can be
+// redacted by data minimization; the customer identification is the customer number of
+// legal persons.
+const (
+	RedactGeographicAddresses    = "geographic_addresses"
+	RedactNationalIdentification = "national_identification"
+	RedactCustomerIdentification = "customer_identification"
+	RedactDateAndPlaceOfBirth    = "date_and_place_of_birth"
+)
+
+// validateRedact ensures that the redacted fields are known.
+func validateRedact(fields []string) error {
+	for _, field := range fields {
+		switch field {
+		case RedactGeographicAddresses, RedactNationalIdentification, RedactCustomerIdentification, RedactDateAndPlaceOfBirth:
+		default:
+			return fmt.Errorf("unknown field %q, must be %s, %s, %s, or %s", field, RedactGeographicAddresses, RedactNationalIdentification, RedactCustomerIdentification, RedactDateAndPlaceOfBirth)
+		}
+	}
+	return nil
+}
+
+// Jurisdiction is the Travel Rule threshold of a country, e.g. USD 3,000 in the US or
+// EUR 1,000 in the EU. Transfers at or above the threshold require full data, transfers
+// below it have the Below requirement, which is partial data if it is not specified.
+type Jurisdiction struct {
+	Threshold float64 `json:"threshold"`
+	Currency  string  `json:"currency"`
+	Below     string  `json:"below,omitempty"`
+}
+
+// BelowThreshold returns the requirement of transfers below the threshold.
+func (j Jurisdiction) BelowThreshold() string {
+	if j.Below == "" {
+		return RequirePartial
+	}
+	return j.Below
+}
+
+// Validate the threshold, currency, and requirement of the jurisdiction.
+func (j Jurisdiction) Validate() error {
+	if j.Threshold < 0 {
+		return fmt.Errorf("threshold cannot be negative")
+	}
+
+	if len(j.Currency) != 3 {
+		return fmt.Errorf("a three letter currency code is required")
+	}
+	return validateRequirement(j.BelowThreshold())
+}
+
+// Jurisdictions maps ISO 3166-1 alpha-2 country codes to their Travel Rule thresholds;
+// the jurisdiction with the "*" key applies to other countries. In the config file the
+// jurisdictions are the travel_rule.jurisdictions section; in the environment they are
+// specified as JSON, e.g.
+// TRISA_TRAVEL_RULE_JURISDICTIONS='{"US": {"threshold": 3000, "currency": "USD"}}'.
+type Jurisdictions map[string]Jurisdiction
+
+// Decode implements envconfig.Decoder
+func (j *Jurisdictions) Decode(value string) (err error) {
+	jurisdictions := make(Jurisdictions)
+	if value = strings.TrimSpace(value); value != "" {
+		if err = json.Unmarshal([]byte(value), &jurisdictions); err != nil {
+			return fmt.Errorf("could not parse jurisdictions: %s", err)
+		}
+	}
+
+	*j = make(Jurisdictions, len(jurisdictions))
+	for country, jurisdiction := range jurisdictions {
+		jurisdiction.Currency = strings.ToUpper(strings.TrimSpace(jurisdiction.Currency))
+		jurisdiction.Below = strings.ToLower(strings.TrimSpace(jurisdiction.Below))
+		(*j)[strings.ToUpper(strings.TrimSpace(country))] = jurisdiction
+	}
+	return nil
+}
+
+// Get returns the jurisdiction of the country, falling back to the default jurisdiction.
+func (j Jurisdictions) Get(country string) (jurisdiction Jurisdiction, ok bool) {
+	if jurisdiction, ok = j[strings.ToUpper(country)]; ok {
+		return jurisdiction, true
+	}
+	jurisdiction, ok = j[DefaultJurisdiction]
+	return jurisdiction, ok
+}
+
+// AssetRates maps assets (e.g. BTC) to their value in each currency, which is used to
+// convert the amount of a transfer to the currency of a jurisdiction. In the
+// environment the rates are specified as JSON, e.g.
+// TRISA_TRAVEL_RULE_RATES='{"BTC": {"USD": 60000, "EUR": 55000}}'.
+type AssetRates map[string]map[string]float64
+
+// Decode implements envconfig.Decoder
+func (r *AssetRates) Decode(value string) (err error) {
+	rates := make(AssetRates)
+	if value = strings.TrimSpace(value); value != "" {
+		if err = json.Unmarshal([]byte(value), &rates); err != nil {
+			return fmt.Errorf("could not parse asset rates: %s", err)
+		}
+	}
+
+	*r = make(AssetRates, len(rates))
+	for asset, currencies := range rates {
+		asset = strings.ToUpper(strings.TrimSpace(asset))
+		(*r)[asset] = make(map[string]float64, len(currencies))
+		for currency, rate := range currencies {
+			(*r)[asset][strings.ToUpper(strings.TrimSpace(currency))] = rate
+		}
+	}
+	return nil
+}
+
+// Convert the amount of the asset to the currency, returning false if the rate of the
+// asset in the currency is unknown.
+func (r AssetRates) Convert(amount float64, asset, currency string) (float64, bool) {
+	rate, ok := r[strings.ToUpper(asset)][strings.ToUpper(currency)]
+	if !ok {
+		return 0, false
+	}
+	return amount * rate, true
+}
+
+func validateRequirement(requirement string) error {
+	switch requirement {
+	case RequireFull, RequirePartial, RequireAuto:
+		return nil
+	default:
+		return fmt.Errorf("unknown requirement %q, must be %s, %s, or %s", requirement, RequireFull, RequirePartial, RequireAuto)
+	}
+}
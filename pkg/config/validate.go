@@ -0,0 +1,868 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rotationalio/trisa/internal/maintenance"
+	"github.com/rotationalio/trisa/pkg/hsm"
+	"github.com/rotationalio/trisa/pkg/secrets"
+	"github.com/rotationalio/trisa/pkg/webhooks"
+	"github.com/rs/zerolog"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+)
+
+// ValidationErrors aggregates all of the problems found with the configuration so that
+// misconfiguration can be fixed all at once rather than one error at a time.
+type ValidationErrors []*ValidationError
+
+// ValidationError describes a single invalid configuration field.
+type ValidationError struct {
+	Field string
+	Err   error
+}
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return "invalid configuration: " + e[0].Error()
+	}
+
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d configuration errors: %s", len(e), strings.Join(msgs, "; "))
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Validate the configuration, returning ValidationErrors with every problem found if
+// the configuration is invalid. Validation only performs cheap checks (e.g. that the
+// certificate files exist) so that it can be run before any expensive loading.
+func (c Config) Validate() error {
+	var errs ValidationErrors
+	check := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, &ValidationError{Field: field, Err: err})
+		}
+	}
+
+	check("BindAddr", validateBindAddr(c.BindAddr))
+	check("Listeners", validateListeners(c))
+	if c.DrainTimeout <= 0 {
+		check("DrainTimeout", fmt.Errorf("drain timeout must be positive"))
+	}
+	if c.PendingTimeout < 0 {
+		check("PendingTimeout", fmt.Errorf("pending timeout cannot be negative"))
+	}
+	if c.ExpiryInterval <= 0 {
+		check("ExpiryInterval", fmt.Errorf("expiry interval must be positive"))
+	}
+	if c.MaxEnvelopeSize < 0 {
+		check("MaxEnvelopeSize", fmt.Errorf("maximum envelope size cannot be negative"))
+	}
+	check("DirectoryAddr", validateAddr(c.DirectoryAddr, true))
+	check("MaintenanceWindows", validateMaintenance(c))
+	check("HealthCheckMinInterval", validateHealthCheck(c))
+	check("GRPC", validateGRPC(c.GRPC))
+	if c.Streams.MaxOpen < 0 || c.Streams.MaxPerPeer < 0 || c.Streams.MaxInFlight < 0 {
+		check("Streams", fmt.Errorf("stream limits cannot be negative"))
+	}
+	if c.Metrics.Enabled {
+		check("Metrics.Addr", validateAddr(c.Metrics.Addr, false))
+		if !strings.HasPrefix(c.Metrics.Path, "/") {
+			check("Metrics.Path", fmt.Errorf("path must start with a slash"))
+		}
+	}
+	if c.Debug.Enabled {
+		check("Debug.Addr", validateAddr(c.Debug.Addr, false))
+	}
+	if c.Probes.Enabled {
+		check("Probes.Addr", validateAddr(c.Probes.Addr, false))
+	}
+	if c.Admin.Enabled {
+		check("Admin.Addr", validateAddr(c.Admin.Addr, false))
+		if c.Admin.Token == "" {
+			check("Admin.Token", fmt.Errorf("a token is required to enable the admin API"))
+		}
+	}
+	if c.Sunrise.Enabled {
+		check("Sunrise", validateSunrise(c.Sunrise))
+	}
+	if c.Screening.Enabled {
+		check("Screening", validateScreening(c.Screening))
+	}
+	if c.TravelRule.Enabled {
+		check("TravelRule", validateTravelRule(c.TravelRule))
+	}
+	if c.Chain.Enabled {
+		check("Chain", validateChain(c.Chain))
+	}
+	if c.Webhooks.Enabled {
+		check("Webhooks", validateWebhooks(c.Webhooks))
+	}
+	if c.EventBus.Enabled {
+		check("EventBus", validateEventBus(c.EventBus))
+	}
+	if c.Notifications.Enabled {
+		check("Notifications", validateNotifications(c.Notifications))
+	}
+	if c.Tracing.Enabled {
+		check("Tracing", validateTracing(c.Tracing))
+	}
+	check("Peers", validatePolicies(c.Peers))
+	check("Payloads", validatePayloads(c.Payloads))
+	if c.Timestamps.MaxSkew < 0 {
+		check("Timestamps.MaxSkew", fmt.Errorf("maximum clock skew cannot be negative"))
+	}
+	if c.RateLimit.RPS < 0 || c.RateLimit.Burst < 0 {
+		check("RateLimit", fmt.Errorf("rate limit and burst cannot be negative"))
+	}
+	check("Duplicates", validateDuplicates(c.Duplicates))
+	check("Rejection", validateRejection(c.Rejection))
+	if c.Retries.Enabled {
+		check("Retries", validateRetries(c.Retries, c.Storage))
+	}
+	check("Audit", validateAudit(c.Audit, c.Storage))
+	check("ServerCerts", validateFile(c.ServerCerts))
+	check("ServerCertPool", validateFile(c.ServerCertPool))
+	check("Identities", validateIdentities(c.Identities))
+	check("SigningCerts", validateSigning(c.SigningCerts, c.SigningKey))
+	check("Exchange", validateExchange(c.Exchange))
+	check("LogLevel", validateLogLevel(zerolog.Level(c.LogLevel)))
+	check("Storage.Path", validateDir(c.Storage.Path))
+	check("Storage.EncryptionKey", validateEncryption(c.Storage))
+
+	// Production deployments must keep durable records of their TRISA exchanges
+	if c.Environment.IsProduction() && c.Storage.Path == "" {
+		check("Storage.Path", fmt.Errorf("a storage path is required in %s", c.Environment))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateAddr ensures the address is a host:port pair; the host is optional for
+// bind addresses but required for addresses that are dialed.
+func validateAddr(addr string, requireHost bool) (err error) {
+	if addr == "" {
+		return fmt.Errorf("address is required")
+	}
+
+	var host, port string
+	if host, port, err = net.SplitHostPort(addr); err != nil {
+		return err
+	}
+
+	if requireHost && host == "" {
+		return fmt.Errorf("host is required in %q", addr)
+	}
+
+	var n uint64
+	if n, err = strconv.ParseUint(port, 10, 16); err != nil || (requireHost && n == 0) {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// validateBindAddr ensures the bind address is either a host:port pair or the path of
+// a unix socket in an existing directory.
+func validateBindAddr(addr string) (err error) {
+	network, path := ListenAddr(addr)
+	if network != "unix" {
+		return validateAddr(addr, false)
+	}
+
+	if path == "" {
+		return fmt.Errorf("unix socket path is required")
+	}
+
+	var info os.FileInfo
+	if info, err = os.Stat(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("unix socket directory: %s", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", filepath.Dir(path))
+	}
+	return nil
+}
+
+// validateListeners ensures the listeners have valid addresses and credentials and that
+// no address is listened on twice.
+func validateListeners(c Config) error {
+	seen := map[string]bool{c.BindAddr: true}
+	for _, listener := range c.Listeners {
+		if err := validateBindAddr(listener.Addr); err != nil {
+			return fmt.Errorf("listener %q: %s", listener.Addr, err)
+		}
+
+		switch listener.Credentials {
+		case CredentialsMTLS, CredentialsInsecure:
+		default:
+			return fmt.Errorf("listener %q: unknown credentials %q", listener.Addr, listener.Credentials)
+		}
+
+		if seen[listener.Addr] {
+			return fmt.Errorf("listener %q: address is listened on more than once", listener.Addr)
+		}
+		seen[listener.Addr] = true
+	}
+	return nil
+}
+
+// validateIdentities ensures the certificates of the additional identities exist.
+func validateIdentities(identities Identities) (err error) {
+	for _, identity := range identities {
+		if err = validateFile(identity.Certs); err != nil {
+			return fmt.Errorf("identity %q: %s", identity.Certs, err)
+		}
+
+		if identity.CertPool != "" {
+			if err = validateFile(identity.CertPool); err != nil {
+				return fmt.Errorf("identity %q: certpool: %s", identity.Certs, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateFile ensures that local files exist; remote secret locations such as
+// gcpsecret:// URIs are only checked to ensure they can be parsed.
+func validateFile(path string) (err error) {
+	if path == "" {
+		return fmt.Errorf("path is required")
+	}
+
+	if secrets.IsURI(path) {
+		var uri *url.URL
+		if uri, err = url.Parse(path); err != nil {
+			return err
+		}
+
+		if secrets.IsRemote(path) {
+			return nil
+		}
+		path = uri.Host + uri.Path
+	}
+
+	var info os.FileInfo
+	if info, err = os.Stat(path); err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	return nil
+}
+
+// validateSigning ensures that the optional signing certificate and key exist; the
+// signing key can only be specified along with the signing certificate.
+func validateSigning(certs, key string) (err error) {
+	if certs == "" {
+		if key != "" {
+			return fmt.Errorf("a signing certificate is required with the signing key")
+		}
+		return nil
+	}
+
+	if err = validateFile(certs); err != nil {
+		return err
+	}
+
+	if key != "" {
+		if err = validateKey(key); err != nil {
+			return fmt.Errorf("signing key: %s", err)
+		}
+	}
+	return nil
+}
+
+// validateKey ensures that a private key file exists or that the PKCS #11 URI or KMS
+// key URI of the key can be parsed.
+func validateKey(location string) (err error) {
+	if hsm.IsURI(location) {
+		return hsm.Validate(location)
+	}
+	return validateFile(location)
+}
+
+// validateExchange ensures that the accepted key exchange algorithms are known, that
+// the keychain is known, that the exchange key size is secure, that the local key files
+// of the exchange keys exist, and that only generated exchange keys are rotated.
+func validateExchange(c ExchangeConfig) (err error) {
+	var accepted int
+	for _, algorithm := range strings.Split(c.Algorithms, ",") {
+		if algorithm = strings.TrimSpace(algorithm); algorithm == "" {
+			continue
+		}
+
+		switch strings.ToLower(algorithm) {
+		case "rsa", "ecdsa":
+			accepted++
+		default:
+			return fmt.Errorf("unknown key exchange algorithm %q", algorithm)
+		}
+	}
+
+	if accepted == 0 {
+		return fmt.Errorf("at least one key exchange algorithm must be accepted")
+	}
+
+	if c.RenewBefore < 0 {
+		return fmt.Errorf("peer key renewal window cannot be negative")
+	}
+
+	switch {
+	case c.Keychain == "database", c.Keychain == "memory":
+	case c.KeychainDir() != "":
+		if err = validateDir(c.KeychainDir()); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown keychain %q, use database, memory, or a file:// directory", c.Keychain)
+	}
+
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Key == "" && (c.KeySize < 2048 || c.KeySize%1024 != 0) {
+		return fmt.Errorf("exchange key size must be a multiple of 1024 of at least 2048 bits")
+	}
+
+	if c.RotateEvery < 0 || c.GracePeriod < 0 || c.RetainKeys < 0 {
+		return fmt.Errorf("exchange key rotation, grace period, and retained keys cannot be negative")
+	}
+
+	if c.RotateEvery > 0 && c.Key != "" {
+		return fmt.Errorf("only generated exchange keys can be rotated, remove the exchange key or disable rotation")
+	}
+
+	for _, path := range append(strings.Split(c.PreviousKeys, ","), c.Key) {
+		if path = strings.TrimSpace(path); path != "" {
+			if err = validateKey(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateDir ensures that if the path exists it is a directory; it is fine if the
+// path does not exist since it can be created when it is opened.
+func validateDir(path string) (err error) {
+	if path == "" {
+		return nil
+	}
+
+	var info os.FileInfo
+	if info, err = os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	return nil
+}
+
+// encryptableFields are the IVMS101 person fields that can be sealed with the field key.
+var encryptableFields = map[string]bool{
+	"date_and_place_of_birth": true,
+	"national_identification": true,
+	"geographic_addresses":    true,
+	"customer_identification": true,
+	"customer_number":         true,
+	"country_of_residence":    true,
+}
+
+// validateEncryption ensures that at most one of the storage encryption key and the
+// passphrase is specified, that previous keys are only listed with a current key, that
+// the encrypted fields are known, and that the local key files exist.
+func validateEncryption(c StorageConfig) (err error) {
+	if c.EncryptionKey != "" && c.Passphrase != "" {
+		return fmt.Errorf("specify either a storage encryption key or a passphrase, not both")
+	}
+
+	if c.PreviousKeys != "" && c.EncryptionKey == "" && c.Passphrase == "" {
+		return fmt.Errorf("previous storage keys require a current encryption key or passphrase")
+	}
+
+	if c.PreviousPayloadKeys != "" && c.PayloadKey == "" {
+		return fmt.Errorf("previous payload keys require a current payload key")
+	}
+
+	if c.PreviousFieldKeys != "" && c.FieldKey == "" {
+		return fmt.Errorf("previous field keys require a current field key")
+	}
+
+	if c.FieldKey != "" {
+		for _, field := range strings.Split(c.EncryptedFields, ",") {
+			if field = strings.TrimSpace(field); field != "" && !encryptableFields[field] {
+				return fmt.Errorf("unknown encrypted field %q", field)
+			}
+		}
+	}
+
+	paths := append(strings.Split(c.PreviousKeys, ","), strings.Split(c.PreviousPayloadKeys, ",")...)
+	paths = append(paths, strings.Split(c.PreviousFieldKeys, ",")...)
+	for _, path := range append(paths, c.EncryptionKey, c.PayloadKey, c.FieldKey) {
+		if path = strings.TrimSpace(path); path != "" {
+			if err = validateFile(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateMaintenance ensures the maintenance windows can be parsed (and that any
+// calendar files can be read) and that the notice period is not negative.
+func validateMaintenance(c Config) (err error) {
+	if c.MaintenanceNotice < 0 {
+		return fmt.Errorf("maintenance notice cannot be negative")
+	}
+
+	_, err = maintenance.Parse(maintenance.Split(c.MaintenanceWindows)...)
+	return err
+}
+
+// validateHealthCheck ensures the default health check interval and every per-peer
+// override is a valid range.
+func validateHealthCheck(c Config) (err error) {
+	if err = c.HealthCheckInterval("").Validate(); err != nil {
+		return err
+	}
+
+	for name, interval := range c.HealthCheckPeers {
+		if err = interval.Validate(); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// validatePolicies ensures that every peer policy is valid.
+func validatePolicies(policies PeerPolicies) (err error) {
+	for name, policy := range policies {
+		if err = policy.Validate(); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// validatePayloads ensures that none of the accepted payload types are empty.
+func validatePayloads(c PayloadsConfig) error {
+	for _, name := range append(c.Identity, c.Transaction...) {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("payload types cannot be empty")
+		}
+	}
+	return nil
+}
+
+// validateDuplicates ensures that the detection window and capacity are not negative and
+// that the action taken on retransmitted envelopes is known.
+func validateDuplicates(c DuplicatesConfig) error {
+	if c.Window < 0 || c.MaxEntries < 0 {
+		return fmt.Errorf("window and max entries cannot be negative")
+	}
+
+	switch c.Action {
+	case DuplicateReplay, DuplicateReject:
+	default:
+		return fmt.Errorf("unknown action %q, must be %s or %s", c.Action, DuplicateReplay, DuplicateReject)
+	}
+	return nil
+}
+
+// validateRejection ensures the rejection has a message and an error code, since
+// unhandled errors are not meaningful to peers.
+func validateRejection(c RejectionConfig) error {
+	if c.Code == ErrorCode(protocol.Unhandled) {
+		return fmt.Errorf("an error code other than UNHANDLED is required")
+	}
+
+	if strings.TrimSpace(c.Message) == "" {
+		return fmt.Errorf("a rejection message is required")
+	}
+	return nil
+}
+
+// validateRetries ensures the retry policy is positive and that the payloads of retried
+// transfers can be sealed with a payload key rather than kept in plaintext.
+func validateRetries(c RetriesConfig, storage StorageConfig) error {
+	if c.MaxAttempts <= 0 || c.Backoff <= 0 || c.Interval <= 0 {
+		return fmt.Errorf("max attempts, backoff, and interval must be positive")
+	}
+
+	if c.MaxBackoff < c.Backoff {
+		return fmt.Errorf("max backoff cannot be less than the backoff")
+	}
+
+	if storage.PayloadKey == "" {
+		return fmt.Errorf("a storage payload key is required to retry transfers")
+	}
+	return nil
+}
+
+// validateAudit ensures payloads are only persisted with the envelopes they belong to
+// and that they can be sealed with a payload key rather than kept in plaintext, and that
+// the retention of the key usage log is not negative.
+func validateAudit(c AuditConfig, storage StorageConfig) error {
+	if c.PersistPayloads && !c.PersistEnvelopes {
+		return fmt.Errorf("payloads can only be persisted if envelopes are persisted")
+	}
+
+	if c.PersistPayloads && storage.PayloadKey == "" {
+		return fmt.Errorf("a storage payload key is required to persist payloads")
+	}
+
+	if c.KeyUsageRetention < 0 {
+		return fmt.Errorf("key usage retention cannot be negative")
+	}
+	return nil
+}
+
+// validateGRPC ensures the gRPC tuning options are not negative.
+func validateGRPC(c GRPCConfig) error {
+	if c.MaxRecvMsgSize < 0 || c.MaxSendMsgSize < 0 {
+		return fmt.Errorf("maximum message sizes cannot be negative")
+	}
+
+	if c.ConnectionTimeout < 0 || c.KeepaliveMinTime < 0 {
+		return fmt.Errorf("connection timeout and keepalive min time cannot be negative")
+	}
+
+	if c.MaxConnectionIdle < 0 || c.MaxConnectionAge < 0 || c.MaxConnectionAgeGrace < 0 || c.KeepaliveTime < 0 || c.KeepaliveTimeout < 0 {
+		return fmt.Errorf("connection ages and keepalive durations cannot be negative")
+	}
+
+	switch c.Compression {
+	case "", CompressionNone, CompressionGzip:
+	default:
+		return fmt.Errorf("unknown compression %q, only %s is supported", c.Compression, CompressionGzip)
+	}
+	return nil
+}
+
+// validateTracing ensures the collector endpoint is an HTTP URL and that the sample
+// ratio is a fraction.
+func validateTracing(c TracingConfig) error {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint: %s", err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint must be an http or https url, not %q", c.Endpoint)
+	}
+
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("sample ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// validateSunrise ensures the sunrise listener, the public URL of the secure links, and
+// the SMTP server that the links are sent with are configured. The links carry the key
+// of the payload, so the public URL must be an https URL.
+func validateSunrise(c SunriseConfig) (err error) {
+	if err = validateAddr(c.Addr, false); err != nil {
+		return fmt.Errorf("invalid addr: %s", err)
+	}
+
+	var u *url.URL
+	if u, err = url.Parse(c.URL); err != nil {
+		return fmt.Errorf("invalid url: %s", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("url must be an https url, not %q", c.URL)
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("tls cert file and tls key file must be set together")
+	}
+
+	if c.Expires <= 0 {
+		return fmt.Errorf("expires must be positive")
+	}
+
+	if err = validateAddr(c.SMTPAddr, true); err != nil {
+		return fmt.Errorf("invalid smtp addr: %s", err)
+	}
+	if _, err = mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("invalid from address: %s", err)
+	}
+	return nil
+}
+
+// validateScreening ensures that transfers are screened against at least one sanctions
+// list or provider and that the action taken on matches is known.
+func validateScreening(c ScreeningConfig) error {
+	if strings.TrimSpace(c.Lists) == "" && c.URL == "" {
+		return fmt.Errorf("sanctions lists or a screening provider url are required")
+	}
+
+	if c.URL != "" {
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			return fmt.Errorf("invalid url: %s", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url must be an http or https url, not %q", c.URL)
+		}
+	}
+
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+
+	switch c.Action {
+	case ScreeningReject, ScreeningFlag:
+	default:
+		return fmt.Errorf("unknown action %q, must be %s or %s", c.Action, ScreeningReject, ScreeningFlag)
+	}
+	return nil
+}
+
+// validateTravelRule ensures that the jurisdictions and the requirement of transfers
+// that cannot be priced are valid, that the asset rates are positive, and that the
+// fields redacted by data minimization are known.
+func validateTravelRule(c TravelRuleConfig) (err error) {
+	if len(c.Jurisdictions) == 0 {
+		return fmt.Errorf("at least one jurisdiction is required")
+	}
+
+	for country, jurisdiction := range c.Jurisdictions {
+		if country != DefaultJurisdiction && len(country) != 2 {
+			return fmt.Errorf("%s: jurisdictions must be two letter country codes or %q", country, DefaultJurisdiction)
+		}
+		if err = jurisdiction.Validate(); err != nil {
+			return fmt.Errorf("%s: %s", country, err)
+		}
+	}
+
+	for asset, currencies := range c.Rates {
+		for currency, rate := range currencies {
+			if rate <= 0 {
+				return fmt.Errorf("rate of %s in %s must be positive", asset, currency)
+			}
+		}
+	}
+
+	if err = validateRequirement(c.Unpriced); err != nil {
+		return fmt.Errorf("unpriced: %s", err)
+	}
+
+	if c.Minimize {
+		if err = validateRedact(c.Redact); err != nil {
+			return fmt.Errorf("redact: %s", err)
+		}
+	}
+	return nil
+}
+
+// validateChain ensures that settlements are verified with a chain-data provider at an
+// http(s) url and that the verification schedule and requirements are sensible.
+func validateChain(c ChainConfig) error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %s", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("a chain-data provider http or https url is required, not %q", c.URL)
+	}
+
+	if c.Timeout <= 0 || c.Interval <= 0 || c.Window <= 0 {
+		return fmt.Errorf("timeout, interval, and window must be positive")
+	}
+
+	if c.ReconcileInterval < 0 {
+		return fmt.Errorf("reconcile interval cannot be negative")
+	}
+
+	if c.Confirmations < 1 {
+		return fmt.Errorf("at least one confirmation is required")
+	}
+
+	if c.Tolerance < 0 || c.Tolerance >= 1 {
+		return fmt.Errorf("tolerance must be a fraction of the amount between 0 and 1")
+	}
+	return nil
+}
+
+// validateWebhooks ensures that the events of transfers are posted to http(s) urls with
+// a secret to sign them, that only known events are subscribed to, and that deliveries
+// are retried and queued sensibly.
+func validateWebhooks(c WebhooksConfig) error {
+	if len(c.Endpoints) == 0 {
+		return fmt.Errorf("at least one endpoint is required")
+	}
+
+	for _, webhook := range c.Endpoints {
+		u, err := url.Parse(webhook.URL)
+		if err != nil {
+			return fmt.Errorf("invalid url: %s", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("an http or https url is required, not %q", webhook.URL)
+		}
+
+		if webhook.Secret == "" {
+			return fmt.Errorf("%s: a secret is required to sign the events", webhook.URL)
+		}
+
+		for _, event := range webhook.Events {
+			if !knownEvent(event) {
+				return fmt.Errorf("%s: unknown event %q", webhook.URL, event)
+			}
+		}
+	}
+
+	if c.Retries < 0 || c.Backoff < 0 {
+		return fmt.Errorf("retries and backoff cannot be negative")
+	}
+
+	if c.Timeout <= 0 || c.Workers <= 0 || c.QueueSize <= 0 {
+		return fmt.Errorf("timeout, workers, and queue size must be positive")
+	}
+	return nil
+}
+
+// knownEvent returns true if the event is one of the events of the webhooks package.
+func knownEvent(event string) bool {
+	for _, known := range webhooks.Events() {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// validateEventBus ensures that events are published to a NATS server or a Kafka REST
+// Proxy at a url of the broker, with a known encoding, and that the prefix is a valid
+// subject and topic name.
+func validateEventBus(c EventBusConfig) error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %s", err)
+	}
+
+	switch c.Broker {
+	case BrokerNATS:
+		if (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+			return fmt.Errorf("a nats:// or tls:// url is required, not %q", c.URL)
+		}
+	case BrokerKafka:
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("an http or https url of a kafka rest proxy is required, not %q", c.URL)
+		}
+	default:
+		return fmt.Errorf("unknown broker %q, use %q or %q", c.Broker, BrokerNATS, BrokerKafka)
+	}
+
+	if c.Encoding != "json" && c.Encoding != "cloudevents" {
+		return fmt.Errorf("unknown encoding %q, use \"json\" or \"cloudevents\"", c.Encoding)
+	}
+
+	for _, r := range c.Prefix {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return fmt.Errorf("prefix can only contain letters, digits, periods, underscores, and dashes")
+		}
+	}
+
+	if c.Timeout <= 0 || c.QueueSize <= 0 {
+		return fmt.Errorf("timeout and queue size must be positive")
+	}
+	return nil
+}
+
+// validateNotifications ensures that notifications are sent to at least one Slack
+// webhook or email recipient, that the webhooks are https urls or secret URIs, and that
+// emails can be sent.
+func validateNotifications(c NotificationsConfig) error {
+	var webhooks int
+	for _, webhook := range strings.Split(c.SlackWebhooks, ",") {
+		if webhook = strings.TrimSpace(webhook); webhook == "" {
+			continue
+		}
+		webhooks++
+
+		u, err := url.Parse(webhook)
+		if err != nil {
+			return fmt.Errorf("invalid slack webhook: %s", err)
+		}
+		// Webhook URLs are secrets, so they may be loaded from a secret URI
+		isURL := u.Scheme == "https" && u.Host != ""
+		if !isURL && (u.Scheme == "http" || u.Scheme == "https" || !secrets.IsURI(webhook)) {
+			return fmt.Errorf("slack webhooks must be https urls or secret uris")
+		}
+	}
+
+	var recipients int
+	for _, to := range strings.Split(c.To, ",") {
+		if to = strings.TrimSpace(to); to == "" {
+			continue
+		}
+		recipients++
+
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid recipient %q: %s", to, err)
+		}
+	}
+
+	if webhooks == 0 && recipients == 0 {
+		return fmt.Errorf("at least one slack webhook or email recipient is required")
+	}
+
+	if recipients > 0 {
+		if err := validateAddr(c.SMTPAddr, true); err != nil {
+			return fmt.Errorf("invalid smtp addr: %s", err)
+		}
+		if _, err := mail.ParseAddress(c.From); err != nil {
+			return fmt.Errorf("invalid from address: %s", err)
+		}
+	}
+
+	if c.ReviewURL != "" {
+		u, err := url.Parse(c.ReviewURL)
+		if err != nil {
+			return fmt.Errorf("invalid review url: %s", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("review url must be an http or https url, not %q", c.ReviewURL)
+		}
+	}
+
+	if c.Timeout <= 0 || c.QueueSize <= 0 {
+		return fmt.Errorf("timeout and queue size must be positive")
+	}
+	return nil
+}
+
+func validateLogLevel(level zerolog.Level) error {
+	if level < zerolog.TraceLevel || level > zerolog.PanicLevel {
+		return fmt.Errorf("log level %d is out of range", level)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/webhooks"
+)
+
+func TestValidateWebhookEvents(t *testing.T) {
+	conf := WebhooksConfig{
+		Endpoints: Webhooks{{URL: "https://example.com/hooks", Secret: "secret", Events: webhooks.Events()}},
+		Timeout:   time.Second,
+		Workers:   1,
+		QueueSize: 1,
+	}
+	if err := validateWebhooks(conf); err != nil {
+		t.Fatalf("expected every event of the webhooks package to be valid: %s", err)
+	}
+
+	conf.Endpoints[0].Events = []string{webhooks.TransferApproved, "transfer.teleported"}
+	if err := validateWebhooks(conf); err == nil {
+		t.Fatal("expected unknown event to be refused")
+	}
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WebhooksConfig posts the lifecycle events of transfers to the Endpoints, e.g. to the
+// internal systems of the VASP. Each delivery is retried up to Retries times with
+// exponential backoff starting at Backoff, and each request times out after Timeout.
+// Events are delivered by Workers in the background from a queue of QueueSize events;
+// events that cannot be delivered are kept in the dead-letter log in the store.
+type WebhooksConfig struct {
+	Enabled   bool `default:"false"`
+	Endpoints Webhooks
+	Retries   int           `default:"5"`
+	Backoff   time.Duration `default:"1s"`
+	Timeout   time.Duration `default:"10s"`
+	Workers   int           `default:"4"`
+	QueueSize int           `split_words:"true" default:"1000"`
+}
+
+// Webhook is an endpoint that the events of transfers are posted to. The body of each
+// request is signed with the Secret (a secret or a secret URI) using HMAC-SHA256. The
+// endpoint receives the Events it lists, or every event if it does not list any.
+type Webhook struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events,omitempty"`
+}
+
+// Webhooks are the endpoints of the webhooks section. In the config file they are the
+// webhooks.endpoints section; in the environment they are specified as JSON, e.g.
+// TRISA_WEBHOOKS_ENDPOINTS='[{"url": "https://ops.example.com/trisa", "secret": "..."}]'.
+type Webhooks []Webhook
+
+// Decode implements envconfig.Decoder
+func (w *Webhooks) Decode(value string) (err error) {
+	var webhooks Webhooks
+	if value = strings.TrimSpace(value); value != "" {
+		if err = json.Unmarshal([]byte(value), &webhooks); err != nil {
+			return fmt.Errorf("could not parse webhooks: %s", err)
+		}
+	}
+
+	for i := range webhooks {
+		webhooks[i].URL = strings.TrimSpace(webhooks[i].URL)
+		for j, event := range webhooks[i].Events {
+			webhooks[i].Events[j] = strings.ToLower(strings.TrimSpace(event))
+		}
+	}
+
+	*w = webhooks
+	return nil
+}
@@ -0,0 +1,87 @@
+package trisarl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RuntimeStats is the response of the runtime stats endpoint of the debug listener.
+type RuntimeStats struct {
+	Version      string           `json:"version"`
+	GoVersion    string           `json:"go_version"`
+	Uptime       string           `json:"uptime"`
+	NumCPU       int              `json:"num_cpu"`
+	GOMAXPROCS   int              `json:"gomaxprocs"`
+	NumGoroutine int              `json:"num_goroutine"`
+	NumCgoCall   int64            `json:"num_cgo_call"`
+	Panics       uint64           `json:"panics"`
+	MemStats     runtime.MemStats `json:"memstats"`
+}
+
+// serveDebug serves the pprof profiles and runtime stats on a separate HTTP listener so
+// that operators can profile a node without exposing the profiles on the TRISA port.
+// The listener should only be bound to a loopback or otherwise private address.
+func (s *Server) serveDebug(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/runtime", s.runtimeStats)
+
+	// The write timeout must allow for CPU profiles and execution traces, which are
+	// collected for 30 seconds by default.
+	s.debugSrv = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Warn().Str("listen", addr).Msg("debug server started")
+		if err := s.debugSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("debug server stopped")
+		}
+	}()
+}
+
+// runtimeStats responds with the Go runtime statistics of the server as JSON.
+func (s *Server) runtimeStats(w http.ResponseWriter, r *http.Request) {
+	stats := &RuntimeStats{
+		Version:      Version(),
+		GoVersion:    runtime.Version(),
+		Uptime:       time.Since(s.started).Truncate(time.Second).String(),
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumGoroutine: runtime.NumGoroutine(),
+		NumCgoCall:   runtime.NumCgoCall(),
+		Panics:       s.Panics(),
+	}
+	runtime.ReadMemStats(&stats.MemStats)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Warn().Err(err).Msg("could not write runtime stats")
+	}
+}
+
+// shutdownDebug stops the debug HTTP listener if it was started.
+func (s *Server) shutdownDebug() {
+	if s.debugSrv == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.debugSrv.Shutdown(ctx); err != nil {
+		log.Warn().Err(err).Msg("could not shutdown debug server")
+	}
+}
@@ -0,0 +1,99 @@
+package directory
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	gds "github.com/trisacrypto/trisa/pkg/trisa/gds/api/v1beta1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNotFound is returned if the directory service has no record of the TRISA peer.
+var ErrNotFound = errors.New("peer not found in the directory service")
+
+// Client wraps a connection to the TRISA Global Directory Service to handle the
+// registration workflow for the Rotational TRISA node.
+type Client struct {
+	addr string
+	cc   *grpc.ClientConn
+	api  gds.TRISADirectoryClient
+}
+
+// New connects to the directory service at the specified address using TLS.
+func New(addr string) (_ *Client, err error) {
+	if addr == "" {
+		return nil, errors.New("no directory service address to dial")
+	}
+
+	opts := make([]grpc.DialOption, 0, 1)
+	opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})))
+
+	c := &Client{addr: addr}
+	if c.cc, err = grpc.Dial(addr, opts...); err != nil {
+		return nil, err
+	}
+	c.api = gds.NewTRISADirectoryClient(c.cc)
+	return c, nil
+}
+
+// Register submits the registration request to the directory service. If the directory
+// service responds with an error in the reply, it is returned as a Go error.
+func (c *Client) Register(ctx context.Context, req *gds.RegisterRequest) (rep *gds.RegisterReply, err error) {
+	if rep, err = c.api.Register(ctx, req); err != nil {
+		return nil, err
+	}
+
+	if rep.Error != nil && rep.Error.Code != 0 {
+		return nil, rep.Error
+	}
+	return rep, nil
+}
+
+// Lookup the TRISA peer with the specified common name in the directory service. An
+// error wrapping ErrNotFound is returned if the directory has no record of the peer.
+func (c *Client) Lookup(ctx context.Context, commonName string) (rep *gds.LookupReply, err error) {
+	if rep, err = c.api.Lookup(ctx, &gds.LookupRequest{CommonName: commonName}); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, commonName)
+		}
+		return nil, err
+	}
+
+	if rep.Error != nil && rep.Error.Code != 0 {
+		if rep.Error.Code == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, commonName)
+		}
+		return nil, rep.Error
+	}
+	return rep, nil
+}
+
+// Verification returns the verification status of a previously registered VASP.
+func (c *Client) Verification(ctx context.Context, reg *Registration) (*gds.VerificationReply, error) {
+	req := &gds.VerificationRequest{
+		Id:                  reg.ID,
+		RegisteredDirectory: reg.RegisteredDirectory,
+		CommonName:          reg.CommonName,
+	}
+	return c.api.Verification(ctx, req)
+}
+
+// Addr returns the address of the directory service the client is connected to.
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Close the connection to the directory service.
+func (c *Client) Close() error {
+	return c.cc.Close()
+}
+
+// Timeout is the default timeout for directory service requests.
+const Timeout = 30 * time.Second
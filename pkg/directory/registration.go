@@ -0,0 +1,119 @@
+package directory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	gds "github.com/trisacrypto/trisa/pkg/trisa/gds/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trust"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Registration is the local record of a registration with the directory service. It
+// is saved to disk after a successful registration so that the PKCS12 password is
+// available when the certificates issued by the directory service are installed.
+type Registration struct {
+	ID                  string `json:"id"`
+	RegisteredDirectory string `json:"registered_directory"`
+	CommonName          string `json:"common_name"`
+	Status              string `json:"status"`
+	Message             string `json:"message,omitempty"`
+	PKCS12Password      string `json:"pkcs12password"`
+	RegisteredAt        string `json:"registered_at"`
+}
+
+// NewRegistration creates a local registration record from the directory reply.
+func NewRegistration(rep *gds.RegisterReply) *Registration {
+	return &Registration{
+		ID:                  rep.Id,
+		RegisteredDirectory: rep.RegisteredDirectory,
+		CommonName:          rep.CommonName,
+		Status:              rep.Status.String(),
+		Message:             rep.Message,
+		PKCS12Password:      rep.Pkcs12Password,
+		RegisteredAt:        time.Now().Format(time.RFC3339),
+	}
+}
+
+// LoadRegistration reads a registration record from the JSON file at path.
+func LoadRegistration(path string) (reg *Registration, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return nil, err
+	}
+
+	reg = &Registration{}
+	if err = json.Unmarshal(data, reg); err != nil {
+		return nil, fmt.Errorf("could not parse registration record: %s", err)
+	}
+	return reg, nil
+}
+
+// Save the registration record as JSON to the specified path. The record contains the
+// PKCS12 password so the file is only readable by the current user.
+func (r *Registration) Save(path string) (err error) {
+	var data []byte
+	if data, err = json.MarshalIndent(r, "", "  "); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// LoadRequest reads the registration details from a JSON file, formatted as the
+// protocol buffer JSON representation of a TRISA directory RegisterRequest.
+func LoadRequest(path string) (req *gds.RegisterRequest, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return nil, err
+	}
+
+	req = &gds.RegisterRequest{}
+	if err = protojson.Unmarshal(data, req); err != nil {
+		return nil, fmt.Errorf("could not parse registration request: %s", err)
+	}
+	return req, nil
+}
+
+// InstallCertificates decrypts the PKCS12 certificates issued by the directory service
+// (usually delivered as a .zip or .p12 file) and writes the server certificates and
+// trust pool as PEM files to the configured certificate paths.
+func InstallCertificates(archive, password, certsPath, poolPath string) (err error) {
+	var sz *trust.Serializer
+	if sz, err = trust.NewSerializer(true, password); err != nil {
+		return err
+	}
+
+	var certs *trust.Provider
+	if certs, err = sz.ReadFile(archive); err != nil {
+		return fmt.Errorf("could not decrypt certificates: %s", err)
+	}
+
+	// Write the certificates with the private key to the server certs path
+	if sz, err = trust.NewSerializer(false, "", trust.CompressionNone); err != nil {
+		return err
+	}
+
+	if err = writeProvider(sz, certs, certsPath); err != nil {
+		return fmt.Errorf("could not write server certificates: %s", err)
+	}
+
+	// Write the public certificate chain as the trust pool
+	if poolPath != "" && poolPath != certsPath {
+		if err = sz.WritePoolFile(trust.NewPool(certs.Public()), poolPath); err != nil {
+			return fmt.Errorf("could not write certificate pool: %s", err)
+		}
+	}
+	return nil
+}
+
+func writeProvider(sz *trust.Serializer, p *trust.Provider, path string) (err error) {
+	var f *os.File
+	if f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600); err != nil {
+		return err
+	}
+	defer f.Close()
+	return sz.Write(p, f)
+}
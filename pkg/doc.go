@@ -0,0 +1,22 @@
+/*
+Package trisarl implements the Rotational Labs TRISA node, a gRPC server that responds
+to TRISA network requests and can be embedded by integrators that need Travel Rule
+compliance in their own services.
+
+# API Stability
+
+This module follows semantic versioning and APIVersion identifies the version of the
+public Go API. Within a major API version, the exported identifiers of this package
+(the Server, its constructor and Options, the TransferHandler, and the transfer
+Pipeline) and of the addressbook, chain, client, config, directory, eventbus,
+features, ivms, notifications, pending, proposal, screening, secrets, store,
+travelrule, and webhooks packages will not be removed or changed in a backwards
+incompatible way. New identifiers may be added in minor releases, e.g. new Options,
+new config fields, or new fields on exported structs, so structs should be constructed
+with field names rather than positionally.
+
+Packages under internal/ are implementation details and may change in any release,
+as may any exported identifier whose documentation marks it as experimental.
+Experimental subsystems are also gated by feature flags (see the features package).
+*/
+package trisarl
@@ -0,0 +1,136 @@
+package trisarl
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"google.golang.org/protobuf/proto"
+)
+
+// duplicates answers retransmissions of envelopes that were already processed, e.g.
+// when the peer retries because the response was lost, with the response that was sent
+// before (or rejects them) so that retries do not create duplicate records. An envelope
+// is a duplicate if the same peer sent an identical envelope with the same ID. Failures
+// with a retryable error are processed again, e.g. once a review has approved them.
+func (s *Server) duplicates(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		conf := s.config().Duplicates
+		if conf.Window <= 0 || conf.MaxEntries <= 0 || t.In == nil || t.In.Id == "" {
+			return next(ctx, t)
+		}
+
+		key := t.Peer.String() + "/" + t.In.Id
+		digest, derr := envelopeDigest(t.In)
+		if derr != nil {
+			log.Ctx(ctx).Warn().Err(derr).Msg("could not compute digest of secure envelope")
+			return next(ctx, t)
+		}
+
+		if sent, ok := s.replays.get(key, digest, time.Now().Add(-conf.Window)); ok {
+			t.Duplicate = true
+			log.Ctx(ctx).Info().Str("peer", t.Peer.String()).Str("action", conf.Action).Msg("duplicate secure envelope received")
+			if conf.Action == config.DuplicateReject {
+				return protocol.Errorf(protocol.BadRequest, "secure envelope %s was already processed", t.In.Id)
+			}
+
+			if sent.err != nil {
+				return sent.err
+			}
+			t.Out = sent.out
+			return nil
+		}
+
+		if err = next(ctx, t); err != nil {
+			if perr, ok := err.(*protocol.Error); ok && !perr.Retry {
+				s.replays.put(key, &replay{digest: digest, err: perr}, conf.MaxEntries)
+			}
+			return err
+		}
+
+		if t.Out != nil {
+			s.replays.put(key, &replay{digest: digest, out: t.Out}, conf.MaxEntries)
+		}
+		return nil
+	}
+}
+
+// envelopeDigest identifies the contents of a secure envelope so that retransmissions
+// can be told apart from other envelopes with the same ID.
+func envelopeDigest(env *protocol.SecureEnvelope) (digest [sha256.Size]byte, err error) {
+	var data []byte
+	if data, err = (proto.MarshalOptions{Deterministic: true}).Marshal(env); err != nil {
+		return digest, err
+	}
+	return sha256.Sum256(data), nil
+}
+
+// replays are the responses sent to the most recent envelopes from each peer, keyed by
+// the common name of the peer and the envelope ID, in the order they were sent so that
+// the oldest responses are evicted first.
+type replays struct {
+	sync.Mutex
+	entries map[string]*replay
+	order   *list.List
+}
+
+// replay is the response to an envelope, either a sealed envelope or a TRISA error.
+type replay struct {
+	digest [sha256.Size]byte
+	out    *protocol.SecureEnvelope
+	err    *protocol.Error
+	sent   time.Time
+	elem   *list.Element
+}
+
+func newReplays() *replays {
+	return &replays{entries: make(map[string]*replay), order: list.New()}
+}
+
+// get returns the response to the envelope with the key and digest if it was sent after
+// the specified time, evicting the responses that were sent before it.
+func (r *replays) get(key string, digest [sha256.Size]byte, after time.Time) (*replay, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	for front := r.order.Front(); front != nil; front = r.order.Front() {
+		if oldest := r.entries[front.Value.(string)]; oldest.sent.After(after) {
+			break
+		}
+		r.remove(front.Value.(string))
+	}
+
+	sent, ok := r.entries[key]
+	if !ok || sent.digest != digest {
+		return nil, false
+	}
+	return sent, true
+}
+
+// put keeps the response to the envelope with the key, replacing the response to an
+// earlier envelope with the same key and evicting the oldest responses over capacity.
+func (r *replays) put(key string, sent *replay, capacity int) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.remove(key)
+	sent.sent = time.Now()
+	sent.elem = r.order.PushBack(key)
+	r.entries[key] = sent
+
+	for r.order.Len() > capacity {
+		r.remove(r.order.Front().Value.(string))
+	}
+}
+
+func (r *replays) remove(key string) {
+	if sent, ok := r.entries[key]; ok {
+		r.order.Remove(sent.elem)
+		delete(r.entries, key)
+	}
+}
@@ -0,0 +1,70 @@
+package trisarl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+)
+
+func TestDuplicates(t *testing.T) {
+	s := &Server{replays: newReplays(), conf: config.Config{
+		Duplicates: config.DuplicatesConfig{Window: time.Hour, MaxEntries: 2, Action: config.DuplicateReplay},
+	}}
+
+	var processed int
+	stage := s.duplicates(func(ctx context.Context, t *Transfer) error {
+		processed++
+		if t.In.Payload[0] == 'r' {
+			return &protocol.Error{Code: protocol.Unavailable, Retry: true}
+		}
+		t.Out = &protocol.SecureEnvelope{Id: t.In.Id, Payload: []byte("response")}
+		return nil
+	})
+
+	peer := testPeer(t, "peer.example.com")
+	transfer := func(id, payload string) *Transfer {
+		return &Transfer{Peer: peer, In: &protocol.SecureEnvelope{Id: id, Payload: []byte(payload)}}
+	}
+
+	if err := stage(context.Background(), transfer("1", "transfer")); err != nil {
+		t.Fatal(err)
+	}
+
+	// An identical envelope is answered with the response that was sent before
+	dup := transfer("1", "transfer")
+	if err := stage(context.Background(), dup); err != nil {
+		t.Fatal(err)
+	}
+	if !dup.Duplicate || dup.Out == nil || string(dup.Out.Payload) != "response" || processed != 1 {
+		t.Fatalf("duplicate was not replayed: processed %d times", processed)
+	}
+
+	// Envelopes with the same ID and other contents, and retryable failures, are processed
+	if err := stage(context.Background(), transfer("1", "follow-up")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		stage(context.Background(), transfer("2", "retry"))
+	}
+	if processed != 4 {
+		t.Fatalf("expected 4 transfers to be processed, got %d", processed)
+	}
+
+	// Duplicates are rejected rather than replayed with the reject action
+	s.conf.Duplicates.Action = config.DuplicateReject
+	err := stage(context.Background(), transfer("1", "follow-up"))
+	if perr, ok := err.(*protocol.Error); !ok || perr.Code != protocol.BadRequest {
+		t.Fatalf("expected the duplicate to be rejected, got %v", err)
+	}
+
+	// The oldest responses are evicted over capacity
+	for _, id := range []string{"3", "4"} {
+		stage(context.Background(), transfer(id, "transfer"))
+	}
+	if _, ok := s.replays.entries[peer.String()+"/1"]; ok || len(s.replays.entries) != 2 {
+		t.Errorf("expected the oldest response to be evicted, got %d entries", len(s.replays.entries))
+	}
+}
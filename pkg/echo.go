@@ -0,0 +1,41 @@
+package trisarl
+
+import (
+	"context"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/addressbook"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+)
+
+// EchoBeneficiary is the name of the placeholder beneficiary in echo responses.
+const EchoBeneficiary = "Echo Beneficiary"
+
+// echo responds to transfers in echo mode with a valid response so that counterparties
+// can test their TRISA integration end to end against this node: the identity and the
+// transaction of the transfer are returned with placeholders for the beneficiary and
+// the beneficiary VASP if the originator did not provide them, and the time the
+// transfer was received is added to the extra JSON of the transaction. The response is
+// sealed with the signing key of the peer by the seal stage. No compliance checks are
+// performed, so echo mode must only be enabled on test networks.
+func (s *Server) echo(ctx context.Context, peer *peers.Peer, identity *ivms101.IdentityPayload, transaction *generic.Transaction) (_ *protocol.Payload, err error) {
+	responder := &Responder{ReceivedAt: time.Now()}
+	if len(identity.GetBeneficiary().GetBeneficiaryPersons()) == 0 {
+		responder.Beneficiary = &ivms101.Beneficiary{BeneficiaryPersons: []*ivms101.Person{addressbook.NaturalPerson(EchoBeneficiary)}}
+	}
+	if identity.GetBeneficiaryVasp().GetBeneficiaryVasp() == nil {
+		responder.BeneficiaryVasp = &ivms101.BeneficiaryVasp{BeneficiaryVasp: addressbook.LegalPerson(s.commonNameFor(ctx))}
+	}
+
+	var payload *protocol.Payload
+	if payload, err = responder.Payload(identity, transaction); err != nil {
+		return nil, err
+	}
+
+	log.Ctx(ctx).Info().Str("peer", peer.String()).Str("network", transaction.Network).Msg("transfer echoed")
+	return payload, nil
+}
@@ -0,0 +1,248 @@
+/*
+Package envelope seals and opens TRISA secure envelopes with RSA and ECDSA keys. The
+TRISA handler only encrypts the payload encryption key and HMAC secret of an envelope
+with RSA-OAEP, so envelopes for RSA keys are sealed by the handler and are compatible
+with every TRISA implementation. ECDSA (P-256 and P-384) keys cannot encrypt, so the
+encryption key and HMAC secret are wrapped for them with an ephemeral ECDH key
+agreement instead. This key wrap is not defined by the TRISA protocol, so it is
+identified by ECDHKeyWrap in key exchanges and envelopes are only sealed for the ECDSA
+keys of peers that advertised it. Ed25519 keys are not supported, since they would
+have to be converted to X25519 keys, reusing the signing key for key agreement. The
+payload is encrypted with AES-GCM and signed with HMAC-SHA256 in either case, and the
+signature is verified in constant time before the payload is decrypted.
+
+RSA private keys may also be any crypto.Decrypter with an RSA public key, e.g. a key in
+a hardware security module, which decrypts the payload keys with RSA-OAEP-SHA512 so the
+private key is never held in memory. Likewise ECDSA private keys may be any KeyAgreement
+with an ECDSA public key, e.g. a key agreement key in a cloud KMS.
+*/
+package envelope
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/crypto/aesgcm"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"github.com/trisacrypto/trisa/pkg/trust"
+	"google.golang.org/protobuf/proto"
+)
+
+// Public key algorithms of the keys that envelopes can be sealed with, named as in the
+// PublicKeyAlgorithm of key exchanges.
+var (
+	RSA   = x509.RSA.String()
+	ECDSA = x509.ECDSA.String()
+)
+
+// Algorithms are the supported public key algorithms.
+var Algorithms = []string{RSA, ECDSA}
+
+// ECDHKeyWrap identifies the wrapping of the payload keys with an ephemeral ECDH key
+// agreement, HKDF-SHA256, and AES-256-GCM. It is advertised as the public key algorithm
+// of ECDSA keys in key exchanges in place of ECDSA, so that peers that do not implement
+// the key wrap never seal envelopes for the key.
+const ECDHKeyWrap = "ECDH-HKDF-SHA256-AES256-GCM"
+
+// KeyAgreement is an ECDSA private key that is not held in memory but can derive the
+// ECDH shared secret with a peer public key, which is the x coordinate of the shared
+// point in the fixed size big-endian encoding of the curve.
+type KeyAgreement interface {
+	Public() crypto.PublicKey
+	SharedSecret(peer *ecdsa.PublicKey) ([]byte, error)
+}
+
+// Algorithm returns the public key algorithm of the public or private key, or an
+// empty string if envelopes cannot be sealed or opened with the key.
+func Algorithm(key interface{}) string {
+	switch t := key.(type) {
+	case *rsa.PublicKey, *rsa.PrivateKey:
+		return RSA
+	case *ecdsa.PublicKey:
+		if supportedCurve(t.Curve) {
+			return ECDSA
+		}
+	case *ecdsa.PrivateKey:
+		if supportedCurve(t.Curve) {
+			return ECDSA
+		}
+	case KeyAgreement:
+		if pub, ok := t.Public().(*ecdsa.PublicKey); ok && supportedCurve(pub.Curve) {
+			return ECDSA
+		}
+	case crypto.Decrypter:
+		if _, ok := t.Public().(*rsa.PublicKey); ok {
+			return RSA
+		}
+	}
+	return ""
+}
+
+// Advertised returns the public key algorithm that is advertised with the public key in
+// key exchanges: ECDHKeyWrap for ECDSA keys and the algorithm of the key otherwise.
+func Advertised(key interface{}) string {
+	if algorithm := Algorithm(key); algorithm != ECDSA {
+		return algorithm
+	}
+	return ECDHKeyWrap
+}
+
+// Negotiate returns the algorithm of the public key of a peer if envelopes can be
+// sealed for it given the public key algorithm the peer advertised, which may be empty
+// for RSA keys since not every TRISA implementation advertises it. ECDSA keys are only
+// accepted if the peer advertised ECDHKeyWrap.
+func Negotiate(advertised string, pub interface{}) (algorithm string, err error) {
+	switch algorithm = Algorithm(pub); algorithm {
+	case "":
+		return "", fmt.Errorf("unsupported signing key type %T", pub)
+	case ECDSA:
+		if !strings.EqualFold(advertised, ECDHKeyWrap) {
+			return "", fmt.Errorf("ECDSA signing key was advertised as %q rather than with the %s key wrap", advertised, ECDHKeyWrap)
+		}
+	default:
+		if advertised != "" && !strings.EqualFold(advertised, algorithm) {
+			return "", fmt.Errorf("signing key is %s but %s was advertised", algorithm, advertised)
+		}
+	}
+	return algorithm, nil
+}
+
+// supportedCurve returns true for the NIST curves that keys can be wrapped with.
+func supportedCurve(curve elliptic.Curve) bool {
+	return curve == elliptic.P256() || curve == elliptic.P384()
+}
+
+// PublicKey returns the public key of the private key.
+func PublicKey(key interface{}) interface{} {
+	switch t := key.(type) {
+	case *rsa.PrivateKey:
+		return &t.PublicKey
+	case *ecdsa.PrivateKey:
+		return &t.PublicKey
+	case KeyAgreement:
+		return t.Public()
+	case crypto.Decrypter:
+		return t.Public()
+	}
+	return nil
+}
+
+// PrivateKey returns the private key of the certificates, which must be a key that
+// envelopes can be opened with.
+func PrivateKey(certs *trust.Provider) (key interface{}, err error) {
+	if !certs.IsPrivate() {
+		return nil, trust.ErrKeyRequired
+	}
+
+	if key = certs.GetKey(); Algorithm(key) == RSA {
+		return certs.GetRSAKeys()
+	}
+
+	if Algorithm(key) == "" {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return key, nil
+}
+
+// Open a secure envelope with the private key paired with the public key it was sealed
+// with. As with handler.Open, errors are TRISA protocol errors that can be returned to
+// the peer, and an InvalidKey error means that the envelope was sealed for another key.
+// Envelopes without an HMAC signature or whose signature does not match the encrypted
+// payload are rejected with an InvalidSignature error before the payload is decrypted.
+func Open(in *protocol.SecureEnvelope, key interface{}) (_ *handler.Envelope, err error) {
+	if Algorithm(key) == "" {
+		return nil, protocol.Errorf(protocol.UnhandledAlgorithm, "could not use %T for asymetric decryption", key)
+	}
+
+	if in.EncryptionAlgorithm != "AES256-GCM" {
+		return nil, protocol.Errorf(protocol.UnhandledAlgorithm, "%s encryption unsupported", in.EncryptionAlgorithm)
+	}
+	if in.HmacAlgorithm != "HMAC-SHA256" {
+		return nil, protocol.Errorf(protocol.UnhandledAlgorithm, "%s hmac unsupported", in.HmacAlgorithm)
+	}
+
+	var encryptionKey, hmacSecret []byte
+	if encryptionKey, err = unwrap(key, in.EncryptionKey); err != nil {
+		return nil, protocol.Errorf(protocol.InvalidKey, "encryption key signed incorrectly: %s", err).WithRetry()
+	}
+	if hmacSecret, err = unwrap(key, in.HmacSecret); err != nil {
+		return nil, protocol.Errorf(protocol.InvalidKey, "hmac secret signed incorrectly: %s", err).WithRetry()
+	}
+
+	if len(in.Hmac) == 0 || len(in.Payload) == 0 {
+		return nil, protocol.Errorf(protocol.InvalidSignature, "secure envelope payload is not signed")
+	}
+
+	mac := hmac.New(sha256.New, hmacSecret)
+	mac.Write(in.Payload)
+	if !hmac.Equal(mac.Sum(nil), in.Hmac) {
+		return nil, protocol.Errorf(protocol.InvalidSignature, "could not verify HMAC signature: hmac signature mismatch")
+	}
+
+	env := &handler.Envelope{ID: in.Id, Payload: &protocol.Payload{}}
+	if env.Cipher, err = aesgcm.New(encryptionKey, hmacSecret); err != nil {
+		return nil, protocol.Errorf(protocol.InternalError, "could not create AES-GCM cipher for symmetric decryption: %s", err)
+	}
+
+	var payloadData []byte
+	if payloadData, err = env.Cipher.Decrypt(in.Payload); err != nil {
+		return nil, protocol.Errorf(protocol.InvalidKey, "could not decrypt payload with key: %s", err)
+	}
+
+	if err = proto.Unmarshal(payloadData, env.Payload); err != nil {
+		return nil, protocol.Errorf(protocol.EnvelopeDecodeFail, "could not unmarshal payload from decrypted data: %s", err)
+	}
+	return env, nil
+}
+
+// Seal the envelope with the public key of the peer. As with handler.Seal, errors are
+// TRISA protocol errors.
+func Seal(env *handler.Envelope, key interface{}) (out *protocol.SecureEnvelope, err error) {
+	switch Algorithm(key) {
+	case RSA:
+		return env.Seal(key)
+	case "":
+		return nil, protocol.Errorf(protocol.UnhandledAlgorithm, "could not use %T for asymmetric encryption", key)
+	}
+
+	keys, ok := env.Cipher.(*aesgcm.AESGCM)
+	if !ok {
+		return nil, protocol.Errorf(protocol.UnhandledAlgorithm, "could not use %T for symmetric encryption", env.Cipher)
+	}
+
+	var payloadData []byte
+	if payloadData, err = proto.Marshal(env.Payload); err != nil {
+		return nil, protocol.Errorf(protocol.InternalError, "could not marshal payload data: %s", err)
+	}
+
+	out = &protocol.SecureEnvelope{
+		Id:                  env.ID,
+		EncryptionAlgorithm: env.Cipher.EncryptionAlgorithm(),
+		HmacAlgorithm:       env.Cipher.SignatureAlgorithm(),
+	}
+
+	if out.Payload, err = env.Cipher.Encrypt(payloadData); err != nil {
+		return nil, protocol.Errorf(protocol.InternalError, "could not encrypt payload data: %s", err)
+	}
+
+	if out.Hmac, err = env.Cipher.Sign(out.Payload); err != nil {
+		return nil, protocol.Errorf(protocol.InternalError, "could not sign payload data: %s", err)
+	}
+
+	if out.EncryptionKey, err = wrap(key, keys.EncryptionKey()); err != nil {
+		return nil, protocol.Errorf(protocol.InternalError, "could not encrypt payload encryption key: %s", err)
+	}
+
+	if out.HmacSecret, err = wrap(key, keys.HMACSecret()); err != nil {
+		return nil, protocol.Errorf(protocol.InternalError, "could not encrypt hmac secret: %s", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,121 @@
+package envelope
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func testKeys(t *testing.T) map[string]interface{} {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]interface{}{"rsa": rsaKey, "p256": p256, "p384": p384}
+}
+
+func seal(t *testing.T, key interface{}) *protocol.SecureEnvelope {
+	t.Helper()
+	env := handler.New("", &protocol.Payload{Transaction: &anypb.Any{TypeUrl: "type.example.com/transaction", Value: []byte("transaction")}}, nil)
+	out, err := Seal(env, PublicKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSealOpen(t *testing.T) {
+	for name, key := range testKeys(t) {
+		in := seal(t, key)
+		env, err := Open(in, key)
+		if err != nil {
+			t.Fatalf("%s: could not open envelope: %s", name, err)
+		}
+		if env.ID != in.Id || string(env.Payload.Transaction.Value) != "transaction" {
+			t.Errorf("%s: envelope was not opened correctly", name)
+		}
+	}
+}
+
+func TestOpenWrongKey(t *testing.T) {
+	keys := testKeys(t)
+	for name, key := range keys {
+		other := keys["p256"]
+		if name == "p256" {
+			other = keys["p384"]
+		}
+
+		_, err := Open(seal(t, key), other)
+		if perr, ok := err.(*protocol.Error); !ok || perr.Code != protocol.InvalidKey {
+			t.Errorf("%s: expected invalid key error, got %v", name, err)
+		}
+	}
+}
+
+func TestOpenInvalidSignature(t *testing.T) {
+	for name, key := range testKeys(t) {
+		// A tampered payload fails AES-GCM authentication with an InvalidKey error if it
+		// is decrypted, so the InvalidSignature error shows that it was never decrypted.
+		tampered := seal(t, key)
+		tampered.Payload[len(tampered.Payload)-1] ^= 0xff
+
+		unsigned := seal(t, key)
+		unsigned.Hmac = nil
+
+		forged := seal(t, key)
+		forged.Hmac[0] ^= 0xff
+
+		for _, in := range []*protocol.SecureEnvelope{tampered, unsigned, forged} {
+			_, err := Open(in, key)
+			if perr, ok := err.(*protocol.Error); !ok || perr.Code != protocol.InvalidSignature {
+				t.Errorf("%s: expected invalid signature error, got %v", name, err)
+			}
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	keys := testKeys(t)
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		advertised string
+		key        interface{}
+		algorithm  string
+	}{
+		{"rsa", "RSA", keys["rsa"], RSA},
+		{"rsa not advertised", "", keys["rsa"], RSA},
+		{"rsa advertised as ecdsa", "ECDSA", keys["rsa"], ""},
+		{"ecdsa key wrap", ECDHKeyWrap, keys["p256"], ECDSA},
+		{"ecdsa key wrap lowercase", "ecdh-hkdf-sha256-aes256-gcm", keys["p384"], ECDSA},
+		{"ecdsa without key wrap", "ECDSA", keys["p256"], ""},
+		{"ecdsa not advertised", "", keys["p384"], ""},
+		{"unsupported curve", ECDHKeyWrap, p224, ""},
+	}
+
+	for _, tc := range tests {
+		algorithm, err := Negotiate(tc.advertised, PublicKey(tc.key))
+		if algorithm != tc.algorithm || (err == nil) != (tc.algorithm != "") {
+			t.Errorf("%s: expected %q, got %q (%v)", tc.name, tc.algorithm, algorithm, err)
+		}
+	}
+}
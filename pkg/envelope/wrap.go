@@ -0,0 +1,129 @@
+package envelope
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const wrapInfo = "trisa envelope key wrap"
+
+// wrap encrypts the secret for the ECDSA public key with a key encryption key derived
+// with HKDF-SHA256 from a shared secret with an ephemeral key on the curve of the key.
+// The wrapped secret is the uncompressed ephemeral public key, the nonce, and the
+// AES-256-GCM ciphertext, which is authenticated with the ephemeral public key as
+// additional data.
+func wrap(key interface{}, secret []byte) (_ []byte, err error) {
+	var ephemeral, shared []byte
+	switch pub := key.(type) {
+	case *ecdsa.PublicKey:
+		var priv *ecdsa.PrivateKey
+		if priv, err = ecdsa.GenerateKey(pub.Curve, rand.Reader); err != nil {
+			return nil, err
+		}
+
+		x, _ := pub.Curve.ScalarMult(pub.X, pub.Y, priv.D.Bytes())
+		shared = x.FillBytes(make([]byte, (pub.Curve.Params().BitSize+7)/8))
+		ephemeral = elliptic.Marshal(pub.Curve, priv.X, priv.Y)
+	default:
+		return nil, errors.New("unsupported public key")
+	}
+
+	var aead cipher.AEAD
+	if aead, err = keyEncryptionKey(shared, ephemeral); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(append(ephemeral, nonce...), aead.Seal(nil, nonce, secret, ephemeral)...)
+	return out, nil
+}
+
+// unwrap decrypts a secret that was wrapped for the public key of the private key or
+// KeyAgreement, or that was encrypted with RSA-OAEP-SHA512 as by the TRISA handler if
+// the key is a crypto.Decrypter with an RSA public key.
+func unwrap(key interface{}, wrapped []byte) (_ []byte, err error) {
+	var ephemeral, shared []byte
+	switch priv := key.(type) {
+	case *ecdsa.PrivateKey:
+		size := (priv.Curve.Params().BitSize + 7) / 8
+		if len(wrapped) < 1+2*size {
+			return nil, errors.New("wrapped secret is too short")
+		}
+
+		ephemeral = wrapped[:1+2*size]
+		x, y := elliptic.Unmarshal(priv.Curve, ephemeral)
+		if x == nil {
+			return nil, errors.New("invalid ephemeral public key")
+		}
+
+		x, _ = priv.Curve.ScalarMult(x, y, priv.D.Bytes())
+		shared = x.FillBytes(make([]byte, size))
+	case KeyAgreement:
+		pub, ok := priv.Public().(*ecdsa.PublicKey)
+		if !ok {
+			return nil, errors.New("unsupported key agreement key")
+		}
+
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(wrapped) < 1+2*size {
+			return nil, errors.New("wrapped secret is too short")
+		}
+
+		ephemeral = wrapped[:1+2*size]
+		x, y := elliptic.Unmarshal(pub.Curve, ephemeral)
+		if x == nil {
+			return nil, errors.New("invalid ephemeral public key")
+		}
+
+		if shared, err = priv.SharedSecret(&ecdsa.PublicKey{Curve: pub.Curve, X: x, Y: y}); err != nil {
+			return nil, err
+		}
+
+		if len(shared) != size {
+			return nil, errors.New("invalid shared secret")
+		}
+	case crypto.Decrypter:
+		return priv.Decrypt(rand.Reader, wrapped, &rsa.OAEPOptions{Hash: crypto.SHA512})
+	default:
+		return nil, errors.New("unsupported private key")
+	}
+
+	var aead cipher.AEAD
+	if aead, err = keyEncryptionKey(shared, ephemeral); err != nil {
+		return nil, err
+	}
+
+	wrapped = wrapped[len(ephemeral):]
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped secret is too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], ephemeral)
+}
+
+// keyEncryptionKey derives the AES-256-GCM key that wraps the secret.
+func keyEncryptionKey(shared, ephemeral []byte) (_ cipher.AEAD, err error) {
+	kek := make([]byte, 32)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, shared, ephemeral, []byte(wrapInfo)), kek); err != nil {
+		return nil, err
+	}
+
+	var block cipher.Block
+	if block, err = aes.NewCipher(kek); err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,71 @@
+package trisarl
+
+import (
+	"context"
+
+	"github.com/rotationalio/trisa/pkg/envelope"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// sealEnvelope seals the envelope for the peer with the signing key of the peer and
+// records the use of the key in the key usage audit log.
+func (s *Server) sealEnvelope(peer string, env *handler.Envelope, key interface{}) (out *protocol.SecureEnvelope, err error) {
+	out, err = envelope.Seal(env, key)
+	s.auditKeyUsage(store.KeySealed, peer, env.ID, key, err)
+	return out, err
+}
+
+// recordTransfer appends the incoming secure envelope of the transfer and the response
+// that was sent to the peer, if any, to the envelope log in the store.
+func (s *Server) recordTransfer(ctx context.Context, t *Transfer, err error) {
+	var payload *protocol.Payload
+	if t.Envelope != nil {
+		payload = t.Envelope.Payload
+	}
+
+	s.recordEnvelope(ctx, t.Peer.String(), store.Incoming, t.In, payload, err)
+	if t.Out != nil {
+		s.recordEnvelope(ctx, t.Peer.String(), store.Outgoing, t.Out, t.Response, nil)
+	}
+}
+
+// recordEnvelope appends the secure envelope to the envelope log in the store if
+// persistence is enabled, with the error of the transfer of the envelope, if any. The
+// decrypted payload is only kept if persisting payloads is enabled as well, and is
+// sealed with the storage payload key by the store. Envelopes that cannot be persisted
+// are logged but do not fail the transfer.
+func (s *Server) recordEnvelope(ctx context.Context, peer, direction string, env *protocol.SecureEnvelope, payload *protocol.Payload, rejection error) {
+	audit := s.config().Audit
+	if !audit.PersistEnvelopes || env == nil {
+		return
+	}
+
+	var err error
+	record := &store.Envelope{ID: env.Id, Peer: peer, Direction: direction}
+	if record.Envelope, err = proto.Marshal(env); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("id", env.Id).Msg("could not serialize secure envelope for the envelope log")
+		return
+	}
+
+	if audit.PersistPayloads && payload != nil {
+		if record.Payload, err = protojson.Marshal(payload); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Str("id", env.Id).Msg("could not serialize payload for the envelope log")
+		}
+	}
+
+	switch {
+	case rejection != nil:
+		record.Error = rejection.Error()
+	case env.Error != nil && env.Error.Code != 0:
+		record.Error = env.Error.Error()
+	}
+
+	if err = s.db.PutEnvelope(record); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("id", env.Id).Str("direction", direction).Msg("could not persist secure envelope")
+	}
+}
@@ -0,0 +1,123 @@
+package eventbus
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrorFunc handles an event that could not be published, e.g. by logging it.
+type ErrorFunc func(event *Event, err error)
+
+// Bus publishes events in the order they are emitted from a queue in the background.
+// Events that do not fit in the queue are dropped rather than blocking the caller, and
+// events that cannot be published are handed to the error handler.
+type Bus struct {
+	publisher Publisher
+	prefix    string
+	encoding  string
+	timeout   time.Duration
+	onError   ErrorFunc
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *Event
+	done   chan struct{}
+}
+
+// New starts a bus that publishes events with the publisher to the subjects with the
+// prefix, serialized with the encoding, from a queue of the size. Each event must be
+// published within the timeout.
+func New(publisher Publisher, prefix, encoding string, queueSize int, timeout time.Duration, onError ErrorFunc) *Bus {
+	b := &Bus{
+		publisher: publisher,
+		prefix:    prefix,
+		encoding:  encoding,
+		timeout:   timeout,
+		onError:   onError,
+		queue:     make(chan *Event, queueSize),
+		done:      make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Subject returns the subject or topic that events of the type are published to.
+func (b *Bus) Subject(eventType string) string {
+	if b.prefix == "" {
+		return eventType
+	}
+	return b.prefix + "." + eventType
+}
+
+// Emit queues the event to be published, assigning it an ID and time if it does not
+// have them. Emit does not block; false is returned if the event was dropped because
+// the queue is full or the bus is closed.
+func (b *Bus) Emit(event *Event) bool {
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return false
+	}
+
+	select {
+	case b.queue <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close publishes the events that are still queued and closes the publisher.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.queue)
+	b.mu.Unlock()
+
+	<-b.done
+	return b.publisher.Close()
+}
+
+func (b *Bus) run() {
+	defer close(b.done)
+	for event := range b.queue {
+		if err := b.publish(event); err != nil && b.onError != nil {
+			b.onError(event, err)
+		}
+	}
+}
+
+func (b *Bus) publish(event *Event) (err error) {
+	var msg []byte
+	if msg, err = Encode(event, b.encoding); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	return b.publisher.Publish(ctx, b.Subject(event.Type), event.EnvelopeID, msg)
+}
+
+// newEventID returns a random ID for an event.
+func newEventID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Errorf("could not generate event id: %s", err))
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,91 @@
+/*
+Package eventbus publishes structured events of the node, e.g. when a secure envelope
+is received, keys are exchanged with a peer, or a transfer is completed, to a message
+broker so that the node can be integrated into event-driven compliance pipelines. Each
+Event is serialized as JSON or as a CloudEvents JSON document and published to the
+subject (NATS) or topic (Kafka) named after its type with a configurable prefix, e.g.
+trisa.envelope.received. The Bus publishes events in the background so that the broker
+never slows down the Travel Rule exchanges; brokers are integrated by implementing
+Publisher, and publishers for NATS and for the Kafka REST Proxy are provided.
+*/
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Types of the events published to the bus.
+const (
+	EnvelopeReceived  = "envelope.received"
+	KeyExchanged      = "key.exchanged"
+	KeyRotated        = "key.rotated"
+	TransferCompleted = "transfer.completed"
+	TransferExpired   = "transfer.expired"
+	TransferClosed    = "transfer.closed"
+)
+
+// Serializations of the events.
+const (
+	EncodingJSON        = "json"
+	EncodingCloudEvents = "cloudevents"
+)
+
+// CloudEventsTypePrefix is prepended to the event type in CloudEvents documents, which
+// should be prefixed with a reverse-DNS name.
+const CloudEventsTypePrefix = "io.rotational.trisa."
+
+// Event is a structured event of the node. Node is the common name of the TRISA
+// identity of the node, Peer the common name of the counterparty, and Data holds the
+// details of the event that depend on its type, e.g. the direction of a key exchange.
+type Event struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type"`
+	Time       time.Time         `json:"time"`
+	Node       string            `json:"node,omitempty"`
+	Peer       string            `json:"peer,omitempty"`
+	EnvelopeID string            `json:"envelope_id,omitempty"`
+	Data       map[string]string `json:"data,omitempty"`
+}
+
+// Publisher publishes a serialized event to a subject or topic of a message broker. The
+// key of the event, the envelope ID if it has one, can be used to partition the events.
+type Publisher interface {
+	Publish(ctx context.Context, subject, key string, msg []byte) error
+	Close() error
+}
+
+// Encode serializes the event with the encoding.
+func Encode(event *Event, encoding string) ([]byte, error) {
+	switch encoding {
+	case EncodingJSON, "":
+		return json.Marshal(event)
+	case EncodingCloudEvents:
+		return json.Marshal(&cloudEvent{
+			SpecVersion:     "1.0",
+			ID:              event.ID,
+			Source:          "trisa://" + event.Node,
+			Type:            CloudEventsTypePrefix + event.Type,
+			Time:            event.Time,
+			Subject:         event.EnvelopeID,
+			DataContentType: "application/json",
+			Data:            event,
+		})
+	default:
+		return nil, fmt.Errorf("unknown event encoding %q", encoding)
+	}
+}
+
+// cloudEvent is the structured mode JSON format of CloudEvents 1.0.
+type cloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Time            time.Time `json:"time"`
+	Subject         string    `json:"subject,omitempty"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            *Event    `json:"data"`
+}
@@ -0,0 +1,96 @@
+package eventbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// KafkaPublisher publishes events to Kafka topics through the Kafka REST Proxy, so that
+// the node does not have to speak the Kafka protocol. Each event is produced to the
+// topic named after the subject as a JSON record keyed by the envelope ID, so that the
+// events of a transfer are in the same partition:
+//
+//	POST {URL}/topics/{topic}
+//	{"records": [{"key": "<envelope id>", "value": <event>}]}
+//
+// If Token is set it is sent as a bearer token.
+type KafkaPublisher struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+// Content type of the v2 API of the Kafka REST Proxy for JSON records.
+const kafkaJSON = "application/vnd.kafka.json.v2+json"
+
+type kafkaRecord struct {
+	Key   string          `json:"key,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+type kafkaOffsets struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+// Publish implements Publisher
+func (p *KafkaPublisher) Publish(ctx context.Context, subject, key string, msg []byte) (err error) {
+	endpoint := strings.TrimSuffix(p.URL, "/") + "/topics/" + url.PathEscape(subject)
+
+	var body []byte
+	if body, err = json.Marshal(map[string][]kafkaRecord{"records": {{Key: key, Value: msg}}}); err != nil {
+		return err
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body)); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", kafkaJSON)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var rep *http.Response
+	if rep, err = client.Do(req); err != nil {
+		return fmt.Errorf("could not reach kafka rest proxy: %s", err)
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(rep.Body, 512))
+		return fmt.Errorf("kafka rest proxy returned %s: %s", rep.Status, bytes.TrimSpace(msg))
+	}
+
+	offsets := &kafkaOffsets{}
+	if err = json.NewDecoder(rep.Body).Decode(offsets); err != nil {
+		return fmt.Errorf("could not decode kafka rest proxy reply: %s", err)
+	}
+
+	for _, offset := range offsets.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("kafka could not produce event to %s: %s (error code %d)", subject, offset.Error, *offset.ErrorCode)
+		}
+	}
+	return nil
+}
+
+// Close implements Publisher
+func (p *KafkaPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,204 @@
+package eventbus
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATSPublisher publishes events to a NATS server with the NATS client protocol. The URL
+// is nats://host:port, or tls://host:port to require TLS; TLS is also used if the server
+// requires it. If Token is set it is sent as the auth token, otherwise the user and
+// password of the URL are sent, if any. The connection is established when the first
+// event is published and reestablished after an error. Every publish is flushed with a
+// PING so that events the server rejects, e.g. because of permissions, are reported.
+type NATSPublisher struct {
+	URL   string
+	Token string
+	TLS   *tls.Config
+
+	mu         sync.Mutex
+	conn       net.Conn
+	rd         *bufio.Reader
+	maxPayload int
+}
+
+// natsInfo are the fields of the INFO message of the server that the publisher uses.
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+	MaxPayload  int  `json:"max_payload"`
+}
+
+// Publish implements Publisher; the key is not used since NATS subjects have no keys.
+func (p *NATSPublisher) Publish(ctx context.Context, subject, key string, msg []byte) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		if err = p.connect(ctx); err != nil {
+			return fmt.Errorf("could not connect to nats: %s", err)
+		}
+	}
+
+	if p.maxPayload > 0 && len(msg) > p.maxPayload {
+		return fmt.Errorf("event of %d bytes exceeds the maximum nats payload of %d bytes", len(msg), p.maxPayload)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		p.conn.SetDeadline(deadline)
+	} else {
+		p.conn.SetDeadline(time.Time{})
+	}
+
+	cmd := make([]byte, 0, len(subject)+len(msg)+32)
+	cmd = append(cmd, fmt.Sprintf("PUB %s %d\r\n", subject, len(msg))...)
+	cmd = append(cmd, msg...)
+	cmd = append(cmd, "\r\nPING\r\n"...)
+	if _, err = p.conn.Write(cmd); err == nil {
+		err = p.awaitPong()
+	}
+
+	if err != nil {
+		p.close()
+		return fmt.Errorf("could not publish to nats: %s", err)
+	}
+	return nil
+}
+
+// Close implements Publisher
+func (p *NATSPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.close()
+}
+
+func (p *NATSPublisher) close() (err error) {
+	if p.conn != nil {
+		err = p.conn.Close()
+		p.conn, p.rd = nil, nil
+	}
+	return err
+}
+
+// connect dials the server, reads its INFO, upgrades the connection to TLS if needed,
+// and authenticates with CONNECT.
+func (p *NATSPublisher) connect(ctx context.Context) (err error) {
+	var u *url.URL
+	if u, err = url.Parse(p.URL); err != nil {
+		return err
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	var dialer net.Dialer
+	var conn net.Conn
+	if conn, err = dialer.DialContext(ctx, "tcp", host); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var line string
+	rd := bufio.NewReader(conn)
+	if line, err = rd.ReadString('\n'); err != nil {
+		conn.Close()
+		return err
+	}
+
+	info := &natsInfo{}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected message from server: %q", strings.TrimSpace(line))
+	}
+	if err = json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), info); err != nil {
+		conn.Close()
+		return fmt.Errorf("could not parse server info: %s", err)
+	}
+
+	secure := u.Scheme == "tls" || info.TLSRequired
+	if secure {
+		conf := p.TLS
+		if conf == nil {
+			conf = &tls.Config{}
+		}
+		if conf.ServerName == "" {
+			conf = conf.Clone()
+			conf.ServerName = u.Hostname()
+		}
+
+		tlsConn := tls.Client(conn, conf)
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return fmt.Errorf("tls handshake failed: %s", err)
+		}
+		conn, rd = tlsConn, bufio.NewReader(tlsConn)
+	}
+
+	opts := map[string]interface{}{
+		"verbose":      false,
+		"pedantic":     false,
+		"tls_required": secure,
+		"name":         "trisarl",
+		"lang":         "go",
+		"protocol":     0,
+	}
+	switch {
+	case p.Token != "":
+		opts["auth_token"] = p.Token
+	case u.User != nil:
+		opts["user"] = u.User.Username()
+		opts["pass"], _ = u.User.Password()
+	}
+
+	var data []byte
+	if data, err = json.Marshal(opts); err != nil {
+		conn.Close()
+		return err
+	}
+
+	p.conn, p.rd, p.maxPayload = conn, rd, info.MaxPayload
+	if _, err = fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", data); err == nil {
+		err = p.awaitPong()
+	}
+
+	if err != nil {
+		p.close()
+		return err
+	}
+	return nil
+}
+
+// awaitPong reads messages from the server until the PONG that answers the PING sent by
+// the publisher, answering the PINGs of the server and returning any error it sends.
+func (p *NATSPublisher) awaitPong() error {
+	for {
+		line, err := p.rd.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err = p.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package trisarl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/eventbus"
+	"github.com/rotationalio/trisa/pkg/secrets"
+	"github.com/rs/zerolog/log"
+)
+
+// emit publishes the event to the event bus, if it is enabled. Events are published in
+// the background and never affect the Travel Rule exchange.
+func (s *Server) emit(event *eventbus.Event) {
+	if s.bus == nil {
+		return
+	}
+
+	if !s.bus.Emit(event) {
+		log.Warn().Str("event", event.Type).Str("id", event.EnvelopeID).Msg("event bus queue is full or closed, event dropped")
+	}
+}
+
+// receivedEvent is the event of the secure envelope of the transfer, with the TRISA
+// error code if the transfer was rejected.
+func receivedEvent(t *Transfer, err error) *eventbus.Event {
+	event := &eventbus.Event{
+		Type:       eventbus.EnvelopeReceived,
+		Node:       t.Local,
+		Peer:       t.Peer.String(),
+		EnvelopeID: t.In.Id,
+		Data:       map[string]string{"result": "accepted"},
+	}
+
+	switch {
+	case t.Inquiry:
+		event.Data["kind"] = "inquiry"
+	case t.PendingMessage != nil:
+		event.Data["kind"] = "pending"
+	case t.Receipt != nil:
+		event.Data["kind"] = "receipt"
+	default:
+		event.Data["kind"] = "transfer"
+	}
+
+	if err != nil {
+		event.Data["result"] = "rejected"
+		if code, ok := errorCode(err); ok {
+			event.Data["code"] = code.String()
+		}
+	}
+	return event
+}
+
+// newEventBus starts the event bus of the configuration with the publisher of its
+// broker, or returns nil if the event bus is not enabled.
+func newEventBus(conf config.EventBusConfig) (_ *eventbus.Bus, err error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+	defer cancel()
+
+	var token string
+	if token, err = secrets.LoadString(ctx, conf.Token); err != nil {
+		return nil, fmt.Errorf("could not load event bus token: %s", err)
+	}
+
+	var publisher eventbus.Publisher
+	switch conf.Broker {
+	case config.BrokerKafka:
+		publisher = &eventbus.KafkaPublisher{URL: conf.URL, Token: token, Client: &http.Client{Timeout: conf.Timeout}}
+	default:
+		publisher = &eventbus.NATSPublisher{URL: conf.URL, Token: token}
+	}
+
+	onError := func(event *eventbus.Event, err error) {
+		log.Warn().Err(err).Str("broker", conf.Broker).Str("event", event.Type).Str("id", event.EnvelopeID).Msg("could not publish event")
+	}
+	return eventbus.New(publisher, conf.Prefix, conf.Encoding, conf.QueueSize, conf.Timeout, onError), nil
+}
+
+// shutdownEventBus publishes the events that are still queued and disconnects from the
+// broker.
+func (s *Server) shutdownEventBus() {
+	if s.bus == nil {
+		return
+	}
+
+	if err := s.bus.Close(); err != nil {
+		log.Warn().Err(err).Msg("could not close event bus")
+	}
+}
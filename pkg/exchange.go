@@ -0,0 +1,529 @@
+package trisarl
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/client"
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/envelope"
+	"github.com/rotationalio/trisa/pkg/eventbus"
+	"github.com/rotationalio/trisa/pkg/hsm"
+	"github.com/rotationalio/trisa/pkg/keychain"
+	"github.com/rotationalio/trisa/pkg/secrets"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"google.golang.org/protobuf/proto"
+)
+
+// exchangeRotationCheck is how often the server checks whether the exchange key is due
+// to be rotated, retries pushing the key to peers, and removes expired previous keys.
+const exchangeRotationCheck = time.Minute
+
+// previousKey is a previous exchange key in the keyring of the server, identified by
+// the fingerprint of its public key. Retired keys are not used to open the envelopes of
+// transfers since their grace period has passed, but still open archived envelopes.
+type previousKey struct {
+	id      string
+	key     interface{}
+	retired bool
+}
+
+// setupExchangeKeys creates the keychain from the config unless the embedding
+// application provided one, and loads the exchange key and previous exchange keys from
+// the config or, if no key is configured, from the keychain, which generates a new key
+// on first boot. The store must be open before the exchange keys are set up. The
+// exchange key is not swapped when the certificates are rotated, so that envelopes that
+// peers sealed with it before the certificates were reissued can still be opened.
+func (s *Server) setupExchangeKeys(conf config.ExchangeConfig) (err error) {
+	if s.keychain == nil {
+		if s.keychain, err = newKeyChain(conf, s.db); err != nil {
+			return fmt.Errorf("could not open keychain: %s", err)
+		}
+	}
+
+	if !conf.Enabled {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+	defer cancel()
+
+	switch {
+	case hsm.IsURI(conf.Key):
+		if s.keychain, err = keychain.NewKMS(ctx, conf.Key, s.keychain); err != nil {
+			return fmt.Errorf("could not load exchange key: %s", err)
+		}
+	case conf.Key != "":
+		var key interface{}
+		if key, err = keychain.Load(ctx, conf.Key); err != nil {
+			return fmt.Errorf("could not load exchange key: %s", err)
+		}
+		s.keychain = keychain.WithLocalKey(s.keychain, key)
+	}
+
+	// Keychains that only keep the keys of peers generate the exchange key on first use
+	var local *keychain.LocalKey
+	if local, err = s.keychain.GetLocalKey(); errors.Is(err, keychain.ErrNoLocalKey) {
+		local, err = s.keychain.Rotate()
+	}
+	if err != nil {
+		return fmt.Errorf("could not load exchange key: %s", err)
+	}
+
+	if _, ok := s.keychain.(*keychain.Database); ok && s.db.KeyID() == "" {
+		log.Warn().Msg("exchange key is kept in the store without storage encryption")
+	}
+
+	// The keys the keychain rotated out can still open envelopes
+	if keyring, ok := s.keychain.(keychain.Keyring); ok {
+		var prev []*keychain.LocalKey
+		if prev, err = keyring.PreviousKeys(); err != nil {
+			return fmt.Errorf("could not read previous exchange keys: %s", err)
+		}
+
+		for _, key := range prev {
+			s.prevExchange = append(s.prevExchange, &previousKey{id: key.ID, key: key.Key, retired: !key.Retired.IsZero()})
+		}
+	}
+
+	for _, location := range strings.Split(conf.PreviousKeys, ",") {
+		if location = strings.TrimSpace(location); location == "" {
+			continue
+		}
+
+		var key interface{}
+		if key, err = keychain.Load(ctx, location); err != nil {
+			return fmt.Errorf("could not load previous exchange key: %s", err)
+		}
+		s.prevExchange = append(s.prevExchange, &previousKey{id: keychain.KeyID(envelope.PublicKey(key)), key: key})
+	}
+
+	// Peers seal their envelopes with the exchange key that was last advertised to them
+	var known []*store.Peer
+	if known, err = s.db.Peers(); err != nil {
+		return fmt.Errorf("could not read peers: %s", err)
+	}
+
+	s.advertised = make(map[string]string)
+	for _, peer := range known {
+		if peer.ExchangeKeyID != "" {
+			s.advertised[peer.CommonName] = peer.ExchangeKeyID
+		}
+	}
+
+	log.Info().Str("key_id", local.ID).Str("algorithm", envelope.Algorithm(local.Key)).Msg("exchange key enabled")
+	return nil
+}
+
+// newKeyChain creates the keychain in the config that keeps the keys of peers and the
+// generated exchange keys.
+func newKeyChain(conf config.ExchangeConfig, db *store.Store) (keychain.KeyChain, error) {
+	switch {
+	case conf.Keychain == "memory":
+		return keychain.NewMemory(nil), nil
+	case conf.KeychainDir() != "":
+		return keychain.NewFile(conf.KeychainDir(), conf.KeySize)
+	default:
+		return keychain.NewDatabase(db, conf.KeySize)
+	}
+}
+
+// exchange returns the current exchange key, when it was generated if it was generated
+// by the keychain, and the keyring of previous exchange keys. The key is nil if the
+// exchange key is not enabled. Generated keys are rotated on a schedule, so once the
+// server is running the keys must be accessed with exchange rather than the fields of
+// the server.
+func (s *Server) exchange() (key interface{}, since time.Time, prev []*previousKey) {
+	if !s.config().Exchange.Enabled {
+		return nil, time.Time{}, nil
+	}
+
+	s.exchmu.RLock()
+	defer s.exchmu.RUnlock()
+	local, err := s.keychain.GetLocalKey()
+	if err != nil {
+		log.Error().Err(err).Msg("could not get exchange key from keychain")
+		return nil, time.Time{}, s.prevExchange
+	}
+	return local.Key, local.Created, s.prevExchange
+}
+
+// ErrNotRotatable is returned if the exchange key is not generated by the keychain and
+// therefore cannot be rotated.
+var ErrNotRotatable = keychain.ErrNotRotatable
+
+// RotateExchangeKey rotates the exchange key in the keychain, so that the new key is
+// advertised in key exchanges from now on, and pushes it to the peers in the address
+// book. The previous key still opens envelopes for the grace period so that transfers
+// peers seal with the old key before they receive the new key do not fail. Only keys
+// generated by the keychain can be rotated. Peers the key could not be pushed to are
+// retried in the background.
+func (s *Server) RotateExchangeKey(ctx context.Context) (results []*BroadcastResult, err error) {
+	conf := s.config().Exchange
+	if !conf.Enabled {
+		return nil, ErrNotRotatable
+	}
+
+	// The keyring is updated with the rotation so that no envelope misses the old key
+	var prev, key *keychain.LocalKey
+	s.exchmu.Lock()
+	if prev, err = s.keychain.GetLocalKey(); err == nil {
+		key, err = s.keychain.Rotate()
+	}
+	if err == nil {
+		s.prevExchange = append([]*previousKey{{id: prev.ID, key: prev.Key}}, s.prevExchange...)
+		s.unpushed = nil
+	}
+	s.exchmu.Unlock()
+
+	if err != nil {
+		if errors.Is(err, ErrNotRotatable) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("could not rotate exchange key: %w", err)
+	}
+
+	log.Info().Str("key_id", key.ID).Str("previous_key_id", prev.ID).Dur("grace_period", conf.GracePeriod).Msg("exchange key rotated")
+	s.emit(&eventbus.Event{
+		Type: eventbus.KeyRotated,
+		Node: s.commonName(),
+		Data: map[string]string{"key_id": key.ID, "previous_key_id": prev.ID},
+	})
+
+	if results, err = s.BroadcastKeys(ctx, rotationConcurrency); err != nil {
+		return nil, fmt.Errorf("could not push exchange key to peers: %s", err)
+	}
+
+	var unpushed []string
+	for _, result := range results {
+		if result.Error != "" {
+			unpushed = append(unpushed, result.Peer)
+		}
+	}
+
+	// Only retry the push if the key was not rotated again in the meantime
+	s.exchmu.Lock()
+	if current, err := s.keychain.GetLocalKey(); err == nil && current.ID == key.ID {
+		s.unpushed = unpushed
+	}
+	s.exchmu.Unlock()
+	return results, nil
+}
+
+// rotateExchangeKeys periodically rotates the generated exchange key when it is due,
+// until the server starts shutting down. Changes to the exchange config require a
+// restart, so the config is read once.
+func (s *Server) rotateExchangeKeys() {
+	conf := s.config().Exchange
+	if !conf.Enabled || conf.Key != "" || (conf.RotateEvery <= 0 && conf.GracePeriod <= 0) {
+		return
+	}
+
+	s.background(func(ctx context.Context) {
+		ticker := time.NewTicker(exchangeRotationCheck)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.checkExchangeKey(ctx, conf)
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// checkExchangeKey rotates the exchange key if it is due, otherwise retries pushing the
+// current key to the peers it could not be pushed to, and removes the previous keys
+// whose grace period has passed.
+func (s *Server) checkExchangeKey(ctx context.Context, conf config.ExchangeConfig) {
+	_, since, _ := s.exchange()
+	if conf.RotateEvery > 0 && !since.IsZero() && time.Since(since) >= conf.RotateEvery {
+		if _, err := s.RotateExchangeKey(ctx); err != nil {
+			log.Error().Err(err).Msg("could not rotate exchange key")
+		}
+	} else {
+		s.pushExchangeKey(ctx, conf)
+	}
+	s.pruneExchangeKeys(conf)
+}
+
+// pushExchangeKey retries pushing the current exchange key to the peers it could not be
+// pushed to after the last rotation. Peers that still have not received the key when
+// the grace period has passed receive it with their next key exchange instead, since
+// they will be rejected for sealing envelopes with the old key.
+func (s *Server) pushExchangeKey(ctx context.Context, conf config.ExchangeConfig) {
+	s.exchmu.Lock()
+	unpushed := s.unpushed
+	current, err := s.keychain.GetLocalKey()
+	if err != nil || (conf.GracePeriod > 0 && time.Since(current.Created) > conf.GracePeriod) {
+		unpushed, s.unpushed = nil, nil
+	}
+	s.exchmu.Unlock()
+
+	var failed []string
+	for _, name := range unpushed {
+		if result := s.pushKey(ctx, name); result.Error != "" {
+			failed = append(failed, name)
+		}
+	}
+
+	if len(unpushed) > 0 {
+		s.exchmu.Lock()
+		if now, err := s.keychain.GetLocalKey(); err == nil && now.ID == current.ID {
+			s.unpushed = failed
+		}
+		s.exchmu.Unlock()
+	}
+}
+
+// pruneExchangeKeys retires the generated previous exchange keys whose grace period
+// has passed, so that they no longer open the envelopes of transfers, and deletes the
+// retired keys from the store except for the RetainKeys most recently retired keys,
+// which still open archived envelopes. The grace period of a key starts when the next
+// key was generated. Previous keys in the config and in other keychains are kept.
+func (s *Server) pruneExchangeKeys(conf config.ExchangeConfig) {
+	if _, ok := s.keychain.(*keychain.Database); !ok || conf.GracePeriod <= 0 {
+		return
+	}
+
+	stored, err := s.db.ExchangeKeys()
+	if err != nil {
+		log.Error().Err(err).Msg("could not read exchange keys")
+		return
+	}
+
+	// Maps the IDs of retired keys to true if the key was deleted
+	retired := make(map[string]bool)
+	retained := 0
+	for i := 1; i < len(stored); i++ {
+		key := stored[i]
+		if key.Retired.IsZero() {
+			if time.Since(stored[i-1].Created) < conf.GracePeriod {
+				continue
+			}
+
+			key.Retired = time.Now().UTC()
+			if err = s.db.PutExchangeKey(key); err != nil {
+				log.Error().Err(err).Str("key_id", key.ID).Msg("could not retire exchange key")
+				continue
+			}
+			log.Info().Str("key_id", key.ID).Msg("previous exchange key retired")
+		}
+
+		retired[key.ID] = false
+		if retained < conf.RetainKeys {
+			retained++
+			continue
+		}
+
+		if err = s.db.DeleteExchangeKey(key.ID); err != nil {
+			log.Error().Err(err).Str("key_id", key.ID).Msg("could not delete retired exchange key")
+			continue
+		}
+		retired[key.ID] = true
+		log.Info().Str("key_id", key.ID).Msg("retired exchange key deleted")
+	}
+
+	if len(retired) == 0 {
+		return
+	}
+
+	// The keyring is replaced rather than modified since it is shared with readers
+	s.exchmu.Lock()
+	defer s.exchmu.Unlock()
+	prev := make([]*previousKey, 0, len(s.prevExchange))
+	for _, key := range s.prevExchange {
+		deleted, ok := retired[key.id]
+		switch {
+		case !ok:
+			prev = append(prev, key)
+		case !deleted:
+			prev = append(prev, &previousKey{id: key.id, key: key.key, retired: true})
+		}
+	}
+	s.prevExchange = prev
+}
+
+// advertisedKey returns the public key that is sent to peers in key exchanges: the
+// exchange key if it is enabled, otherwise the key of the signing certificates of the
+// identity of the RPC. Peers can only seal envelopes for ECDSA keys with the ECDH key
+// wrap, so ECDSA keys are only advertised if ECDSA is an accepted algorithm.
+func (s *Server) advertisedKey(ctx context.Context) (out *protocol.SigningKey, err error) {
+	key, since, _ := s.exchange()
+	signingCerts, signingKey := s.signingFor(ctx)
+	local := key
+	if local == nil {
+		local = signingKey
+	}
+
+	if algorithm := envelope.Algorithm(local); !s.accepts(algorithm) {
+		return nil, fmt.Errorf("%s keys cannot be advertised unless %s is an accepted key exchange algorithm", algorithm, algorithm)
+	}
+
+	if key == nil {
+		return client.SigningKey(signingCerts)
+	}
+
+	out = &protocol.SigningKey{PublicKeyAlgorithm: envelope.Advertised(key)}
+	if !since.IsZero() {
+		out.NotBefore = since.Format(time.RFC3339)
+	}
+
+	if out.Data, err = x509.MarshalPKIXPublicKey(envelope.PublicKey(key)); err != nil {
+		return nil, fmt.Errorf("could not marshal PKIX public key: %s", err)
+	}
+	return out, nil
+}
+
+// recordAdvertisedKey records the key that was advertised to the peer in a key exchange
+// in the key usage audit log. If it is an exchange key, which the peer seals its
+// envelopes with from then on, the key is also remembered so that it is tried first
+// when opening envelopes from the peer, and kept with the peer in the address book so
+// that it survives a restart.
+func (s *Server) recordAdvertisedKey(peer string, key *protocol.SigningKey) {
+	pub, err := client.ParseSigningKey(key.Data)
+	s.auditKeyUsage(store.KeyAdvertised, peer, "", pub, err)
+
+	if exchangeKey, _, _ := s.exchange(); exchangeKey == nil {
+		return
+	}
+
+	id := keychain.Fingerprint(key.Data)
+	s.exchmu.Lock()
+	if s.advertised == nil {
+		s.advertised = make(map[string]string)
+	}
+	prev := s.advertised[peer]
+	s.advertised[peer] = id
+	s.exchmu.Unlock()
+
+	if prev != id {
+		if err := s.db.PutPeer(&store.Peer{CommonName: peer, ExchangeKeyID: id}); err != nil {
+			log.Warn().Err(err).Str("peer", peer).Msg("could not record exchange key advertised to peer")
+		}
+	}
+}
+
+// openEnvelope opens the secure envelope from the peer with the exchange key if it is
+// enabled, falling back to the previous exchange keys that are not retired and then to
+// the key of the signing certificates, since peers may have sealed the envelope with a
+// key from an earlier key exchange. The key that was last advertised to the peer is
+// selected first by its fingerprint, so that other keys, which may be in an HSM or KMS,
+// are only tried if the peer has not received the advertised key yet. Without an
+// exchange key the envelope is opened with the key of the signing certificates, falling
+// back to the signing keys of certificates that were rotated.
+func (s *Server) openEnvelope(in *protocol.SecureEnvelope, peer string, certKey interface{}) (env *handler.Envelope, err error) {
+	exchangeKey, _, prev := s.exchange()
+	if exchangeKey == nil {
+		// Signing keys replaced by reissued certificates open the envelopes that peers
+		// sealed before they received the new key
+		keys := []interface{}{certKey}
+		s.exchmu.RLock()
+		for _, key := range s.prevExchange {
+			if !key.retired {
+				keys = append(keys, key.key)
+			}
+		}
+		s.exchmu.RUnlock()
+
+		var key interface{}
+		env, key, err = openWith(in, keys)
+		s.auditKeyUsage(store.KeyOpened, peer, in.Id, key, err)
+		return env, err
+	}
+
+	s.exchmu.RLock()
+	hint := s.advertised[peer]
+	s.exchmu.RUnlock()
+
+	keys := []interface{}{exchangeKey}
+	for _, key := range prev {
+		switch {
+		case key.retired:
+		case key.id == hint:
+			keys = append([]interface{}{key.key}, keys...)
+		default:
+			keys = append(keys, key.key)
+		}
+	}
+
+	var key interface{}
+	env, key, err = openWith(in, append(keys, certKey))
+	s.auditKeyUsage(store.KeyOpened, peer, in.Id, key, err)
+	return env, err
+}
+
+// openWith opens the secure envelope with the first key that can decrypt it and
+// returns the key that opened it. The error of the first key is returned if no key can
+// open it.
+func openWith(in *protocol.SecureEnvelope, keys []interface{}) (env *handler.Envelope, opened interface{}, err error) {
+	for _, key := range keys {
+		var kerr error
+		if env, kerr = envelope.Open(in, key); kerr == nil {
+			return env, key, nil
+		}
+
+		if err == nil {
+			err = kerr
+		}
+
+		// Only a key that cannot decrypt the envelope is a reason to try the next key
+		if perr, ok := kerr.(*protocol.Error); !ok || perr.Code != protocol.InvalidKey {
+			return nil, nil, kerr
+		}
+	}
+	return nil, nil, err
+}
+
+// OpenArchivedEnvelope opens an incoming secure envelope from the envelope log with the
+// generated exchange keys in the store, including the retired keys that no longer open
+// the envelopes of transfers, e.g. to inspect an envelope after the key it was sealed
+// with was rotated. The key that was last advertised to the peer is tried first.
+// Exchange keys loaded from the config are not kept in the store and envelopes sealed
+// with them cannot be opened this way. Since the envelope is opened with a private key
+// of the node, the use of the key is always appended to the key usage audit log.
+func OpenArchivedEnvelope(db *store.Store, record *store.Envelope) (env *handler.Envelope, err error) {
+	in := &protocol.SecureEnvelope{}
+	if err = proto.Unmarshal(record.Envelope, in); err != nil {
+		return nil, fmt.Errorf("could not parse secure envelope: %s", err)
+	}
+
+	var keys []interface{}
+	if keys, err = archivedKeys(db); err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no exchange keys are kept in the store")
+	}
+
+	if peer, err := db.GetPeer(record.Peer); err == nil && peer.ExchangeKeyID != "" {
+		for i, key := range keys {
+			if keychain.KeyID(envelope.PublicKey(key)) == peer.ExchangeKeyID {
+				keys[0], keys[i] = keys[i], keys[0]
+				break
+			}
+		}
+	}
+
+	var key interface{}
+	usage := &store.KeyUsage{Use: store.KeyOpened, Peer: record.Peer, EnvelopeID: record.ID}
+	if env, key, err = openWith(in, keys); err != nil {
+		usage.Error = err.Error()
+	} else {
+		usage.KeyID = keychain.KeyID(envelope.PublicKey(key))
+	}
+
+	if aerr := db.PutKeyUsage(usage); aerr != nil {
+		return nil, fmt.Errorf("could not record key usage: %s", aerr)
+	}
+	return env, err
+}
@@ -0,0 +1,150 @@
+package features
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rotationalio/trisa/pkg/config"
+)
+
+// Flag identifies an experimental subsystem that can be enabled independently.
+type Flag string
+
+// Feature flags for experimental subsystems.
+const (
+	ConcurrentStreams Flag = "concurrent_streams"
+)
+
+// Flags returns all of the feature flags known by the server.
+func Flags() []Flag {
+	return []Flag{ConcurrentStreams}
+}
+
+// Parse a feature flag from a string, returning an error if the flag is unknown.
+func Parse(s string) (Flag, error) {
+	flag := Flag(strings.Replace(strings.TrimSpace(strings.ToLower(s)), "-", "_", -1))
+	for _, f := range Flags() {
+		if f == flag {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown feature flag %q", s)
+}
+
+// Set is a thread-safe collection of feature flags that is initialized from the
+// configuration but can be toggled at runtime (e.g. by an administrator).
+type Set struct {
+	sync.RWMutex
+	flags      map[Flag]bool
+	configured map[Flag]bool
+}
+
+// New creates a feature flag set from the features configuration.
+func New(conf config.FeaturesConfig) *Set {
+	configured := flags(conf)
+	s := &Set{flags: make(map[Flag]bool, len(configured)), configured: configured}
+	for f, enabled := range configured {
+		s.flags[f] = enabled
+	}
+	return s
+}
+
+// flags returns the state of the feature flags in the features configuration.
+func flags(conf config.FeaturesConfig) map[Flag]bool {
+	return map[Flag]bool{
+		ConcurrentStreams: conf.ConcurrentStreams,
+	}
+}
+
+// Reload applies the reloaded features configuration. Flags that were toggled at
+// runtime keep their runtime state unless their configured state changed.
+func (s *Set) Reload(conf config.FeaturesConfig) {
+	if s == nil {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	for f, enabled := range flags(conf) {
+		if enabled != s.configured[f] {
+			s.flags[f] = enabled
+		}
+		s.configured[f] = enabled
+	}
+}
+
+// Enabled returns true if the feature flag is on. A nil set has no features enabled.
+func (s *Set) Enabled(f Flag) bool {
+	if s == nil {
+		return false
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	return s.flags[f]
+}
+
+// Enable the feature at runtime.
+func (s *Set) Enable(f Flag) error {
+	return s.Toggle(f, true)
+}
+
+// Disable the feature at runtime.
+func (s *Set) Disable(f Flag) error {
+	return s.Toggle(f, false)
+}
+
+// Toggle sets the feature flag to the specified state, returning an error if the flag
+// is unknown or the set is nil.
+func (s *Set) Toggle(f Flag, enabled bool) (err error) {
+	if s == nil {
+		return errors.New("no feature flags to toggle")
+	}
+
+	if f, err = Parse(string(f)); err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.flags[f] = enabled
+	s.Unlock()
+	return nil
+}
+
+// All returns a copy of the current state of all feature flags.
+func (s *Set) All() map[Flag]bool {
+	if s == nil {
+		return make(map[Flag]bool)
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	flags := make(map[Flag]bool, len(s.flags))
+	for f, enabled := range s.flags {
+		flags[f] = enabled
+	}
+	return flags
+}
+
+// Active returns the sorted names of the enabled feature flags, e.g. for logging.
+func (s *Set) Active() []string {
+	if s == nil {
+		return []string{}
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	active := make([]string, 0, len(s.flags))
+	for f, enabled := range s.flags {
+		if enabled {
+			active = append(active, string(f))
+		}
+	}
+	sort.Strings(active)
+	return active
+}
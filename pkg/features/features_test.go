@@ -0,0 +1,57 @@
+package features
+
+import (
+	"testing"
+
+	"github.com/rotationalio/trisa/pkg/config"
+)
+
+func TestReloadKeepsRuntimeToggles(t *testing.T) {
+	s := New(config.FeaturesConfig{ConcurrentStreams: true})
+	s.Toggle(ConcurrentStreams, false)
+
+	// Unchanged configuration keeps the runtime state
+	s.Reload(config.FeaturesConfig{ConcurrentStreams: true})
+	if s.Enabled(ConcurrentStreams) {
+		t.Fatalf("runtime toggles were reset by reload: %v", s.All())
+	}
+
+	// Changes to the configuration replace the runtime state
+	s.Reload(config.FeaturesConfig{ConcurrentStreams: false})
+	s.Reload(config.FeaturesConfig{ConcurrentStreams: true})
+	if !s.Enabled(ConcurrentStreams) {
+		t.Fatalf("configuration changes were not applied: %v", s.All())
+	}
+
+	s.Reload(config.FeaturesConfig{ConcurrentStreams: false})
+	s.Toggle(ConcurrentStreams, true)
+	s.Reload(config.FeaturesConfig{ConcurrentStreams: false})
+	if !s.Enabled(ConcurrentStreams) {
+		t.Fatalf("runtime toggles were reset by reload: %v", s.All())
+	}
+}
+
+func TestToggle(t *testing.T) {
+	s := New(config.FeaturesConfig{})
+	if err := s.Enable("Concurrent-Streams"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Enabled(ConcurrentStreams) {
+		t.Error("flag was not enabled")
+	}
+
+	if err := s.Toggle("warp_drive", true); err == nil {
+		t.Error("expected unknown flag to be refused")
+	}
+	if _, ok := s.All()["warp_drive"]; ok {
+		t.Error("unknown flag was added to the set")
+	}
+
+	var nilSet *Set
+	if err := nilSet.Toggle(ConcurrentStreams, true); err == nil {
+		t.Error("expected toggling a nil set to fail")
+	}
+	if nilSet.Enabled(ConcurrentStreams) || len(nilSet.All()) != 0 || len(nilSet.Active()) != 0 {
+		t.Error("nil set has features enabled")
+	}
+}
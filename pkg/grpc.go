@@ -0,0 +1,56 @@
+package trisarl
+
+import (
+	"github.com/rotationalio/trisa/pkg/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+)
+
+// serverOptions returns the gRPC server options for the tuning configuration; options
+// that are not configured are omitted so that the gRPC defaults apply.
+func serverOptions(conf config.GRPCConfig) (opts []grpc.ServerOption) {
+	if conf.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(conf.MaxRecvMsgSize))
+	}
+
+	if conf.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(conf.MaxSendMsgSize))
+	}
+
+	if conf.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(conf.MaxConcurrentStreams))
+	}
+
+	if conf.ConnectionTimeout > 0 {
+		opts = append(opts, grpc.ConnectionTimeout(conf.ConnectionTimeout))
+	}
+
+	// Close idle or old connections and ping clients on connections without activity;
+	// transfer streams on connections that reach the maximum age are closed with the
+	// grace period so that peers can reconnect, e.g. to rebalance behind load balancers.
+	opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+		MaxConnectionIdle:     conf.MaxConnectionIdle,
+		MaxConnectionAge:      conf.MaxConnectionAge,
+		MaxConnectionAgeGrace: conf.MaxConnectionAgeGrace,
+		Time:                  conf.KeepaliveTime,
+		Timeout:               conf.KeepaliveTimeout,
+	}))
+
+	// Clients that ping more frequently than the minimum time are disconnected
+	opts = append(opts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             conf.KeepaliveMinTime,
+		PermitWithoutStream: conf.KeepalivePermitWithoutStream,
+	}))
+	return opts
+}
+
+// dialOptions returns the gRPC dial options for calls to peers. The gzip compressor is
+// registered with gRPC by importing it, so the server also decompresses gzip requests
+// from peers regardless of the compression of its own calls.
+func dialOptions(conf config.GRPCConfig) (opts []grpc.DialOption) {
+	if conf.Compression == config.CompressionGzip {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)))
+	}
+	return opts
+}
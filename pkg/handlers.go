@@ -0,0 +1,32 @@
+package trisarl
+
+import (
+	"context"
+
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+)
+
+// TransferHandler responds to the transfers that were received from verified peers and
+// that passed the earlier stages of the pipeline, e.g. by looking up the beneficiary in
+// the database of the VASP. The returned payload is sealed with the signing key of the
+// peer and sent as the response. Errors should be TRISA protocol errors so that they
+// can be returned to the peer; any other error is returned as an internal error. The
+// transaction is nil if the transfer has a payload type registered with WithPayloadType
+// whose unmarshaler only sets the Data of the transfer, which is handled by replacing
+// StageHandle with a middleware that has access to the whole Transfer. The envelope ID
+// and the local identity the transfer was sent to are available from the context with
+// EnvelopeID and LocalIdentity.
+type TransferHandler interface {
+	HandleTransfer(ctx context.Context, peer *peers.Peer, identity *ivms101.IdentityPayload, transaction *generic.Transaction) (*protocol.Payload, error)
+}
+
+// TransferHandlerFunc adapts a function to the TransferHandler interface.
+type TransferHandlerFunc func(ctx context.Context, peer *peers.Peer, identity *ivms101.IdentityPayload, transaction *generic.Transaction) (*protocol.Payload, error)
+
+// HandleTransfer implements TransferHandler
+func (f TransferHandlerFunc) HandleTransfer(ctx context.Context, peer *peers.Peer, identity *ivms101.IdentityPayload, transaction *generic.Transaction) (*protocol.Payload, error) {
+	return f(ctx, peer, identity, transaction)
+}
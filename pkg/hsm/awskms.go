@@ -0,0 +1,187 @@
+package hsm
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rotationalio/trisa/pkg/secrets"
+)
+
+// AWSKMSScheme is the URI scheme of keys in AWS KMS.
+const AWSKMSScheme = "awskms://"
+
+// AWS KMS endpoint, formatted with the region
+var awsKMSURL = "https://kms.%s.amazonaws.com/"
+
+// isAWSKMS returns true if the location is an AWS KMS key.
+func isAWSKMS(location string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(location)), AWSKMSScheme)
+}
+
+// parseAWSKMS parses the key ID, region, and options of an AWS KMS key URI. The key is
+// identified by its ID, an alias, e.g. awskms://alias/trisa-exchange, or an ARN, e.g.
+// awskms:///arn:aws:kms:us-east-1:111122223333:key/... (note the empty host since ARNs
+// cannot be parsed as a hostname). The region is the region query parameter, the region
+// of the ARN, or the region in the environment. Since AWS KMS keys cannot open envelopes
+// sealed with RSA-OAEP by standard TRISA peers, the URI must opt in to ECDH key wrapping
+// with the ecdh=true query parameter.
+func parseAWSKMS(location string) (keyID, region string, opts *kmsOptions, err error) {
+	var uri *url.URL
+	if uri, err = url.Parse(strings.TrimSpace(location)); err != nil {
+		return "", "", nil, err
+	}
+
+	if keyID = strings.Trim(uri.Host+uri.Path, "/"); keyID == "" {
+		return "", "", nil, fmt.Errorf("could not parse aws kms key id from %q", location)
+	}
+
+	query := uri.Query()
+	if ecdh, _ := strconv.ParseBool(query.Get("ecdh")); !ecdh {
+		return "", "", nil, fmt.Errorf("aws kms key %s cannot open envelopes sealed with rsa-oaep by standard trisa peers, set ecdh=true to accept only peers that wrap payload keys with ecdh", keyID)
+	}
+
+	region = query.Get("region")
+	if parts := strings.Split(keyID, ":"); region == "" && len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+
+	if region, err = secrets.AWSRegion(region); err != nil {
+		return "", "", nil, err
+	}
+
+	if opts, err = parseKMSOptions(query); err != nil {
+		return "", "", nil, err
+	}
+	return keyID, region, opts, nil
+}
+
+// awsKMSKey is an ECC key agreement key in AWS KMS, which derives the shared secrets of
+// the payload keys of envelopes with the DeriveSharedSecret API. AWS KMS RSA keys only
+// decrypt with OAEP using SHA-1 or SHA-256, so they cannot open envelopes encrypted by
+// the TRISA handler with RSA-OAEP-SHA512; envelopes for the ECDSA public key of a key
+// agreement key are wrapped with ECDH instead. This is not part of the TRISA protocol:
+// only peers that implement the envelope.ECDHKeyWrap key wrap can send to a node with
+// this key, so the key URI must opt in with ecdh=true.
+type awsKMSKey struct {
+	keyID  string
+	region string
+	pub    *ecdsa.PublicKey
+	cache  *kmsCache
+}
+
+// openAWSKMS fetches the public key of the AWS KMS key, which must be an ECC NIST key
+// for key agreement.
+func openAWSKMS(ctx context.Context, location string) (_ PrivateKey, err error) {
+	var opts *kmsOptions
+	key := &awsKMSKey{}
+	if key.keyID, key.region, opts, err = parseAWSKMS(location); err != nil {
+		return nil, err
+	}
+	key.cache = newKMSCache(opts)
+
+	var rep struct {
+		PublicKey string `json:"PublicKey"`
+		KeyUsage  string `json:"KeyUsage"`
+	}
+	if err = key.do(ctx, "GetPublicKey", map[string]string{"KeyId": key.keyID}, &rep); err != nil {
+		return nil, fmt.Errorf("could not get public key of %s: %s", key.keyID, err)
+	}
+
+	if rep.KeyUsage != "KEY_AGREEMENT" {
+		return nil, fmt.Errorf("aws kms key usage %s is not KEY_AGREEMENT", rep.KeyUsage)
+	}
+
+	var der []byte
+	if der, err = base64.StdEncoding.DecodeString(rep.PublicKey); err != nil {
+		return nil, err
+	}
+
+	var pub interface{}
+	if pub, err = x509.ParsePKIXPublicKey(der); err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	if key.pub, ok = pub.(*ecdsa.PublicKey); !ok {
+		return nil, fmt.Errorf("unexpected public key type %T of aws kms key", pub)
+	}
+	return key, nil
+}
+
+// Public returns the ECDSA public key of the key.
+func (k *awsKMSKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// SharedSecret derives the ECDH shared secret with the peer public key in AWS KMS.
+func (k *awsKMSKey) SharedSecret(peer *ecdsa.PublicKey) (_ []byte, err error) {
+	var der []byte
+	if der, err = x509.MarshalPKIXPublicKey(peer); err != nil {
+		return nil, err
+	}
+
+	return k.cache.do(der, func(ctx context.Context) (_ []byte, err error) {
+		req := map[string]string{
+			"KeyId":                 k.keyID,
+			"KeyAgreementAlgorithm": "ECDH",
+			"PublicKey":             base64.StdEncoding.EncodeToString(der),
+		}
+
+		var rep struct {
+			SharedSecret string `json:"SharedSecret"`
+		}
+		if err = k.do(ctx, "DeriveSharedSecret", req, &rep); err != nil {
+			if errors.Is(err, errKMSExhausted) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("could not derive shared secret with %s: %s", k.keyID, err)
+		}
+		return base64.StdEncoding.DecodeString(rep.SharedSecret)
+	})
+}
+
+// do sends a signed request for the action to the AWS KMS API and decodes the response.
+func (k *awsKMSKey) do(ctx context.Context, action string, in, out interface{}) (err error) {
+	var body []byte
+	if body, err = json.Marshal(in); err != nil {
+		return err
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(awsKMSURL, k.region), bytes.NewReader(body)); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+
+	if err = secrets.AWSSign(ctx, req, body, "kms", k.region); err != nil {
+		return fmt.Errorf("could not sign aws kms request: %s", err)
+	}
+
+	var rep *http.Response
+	if rep, err = http.DefaultClient.Do(req); err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode < 200 || rep.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(rep.Body)
+		if rep.StatusCode >= 500 || bytes.Contains(data, []byte("ThrottlingException")) {
+			return errKMSExhausted
+		}
+		return fmt.Errorf("%s: %s", rep.Status, strings.TrimSpace(string(data)))
+	}
+	return json.NewDecoder(rep.Body).Decode(out)
+}
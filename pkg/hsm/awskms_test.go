@@ -0,0 +1,110 @@
+package hsm
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseAWSKMSRequiresECDH(t *testing.T) {
+	os.Setenv("AWS_REGION", "us-west-2")
+	defer os.Unsetenv("AWS_REGION")
+
+	if err := Validate("awskms://alias/trisa-exchange"); err == nil {
+		t.Fatal("expected an aws kms key without ecdh=true to be rejected")
+	}
+	if err := Validate("awskms://alias/trisa-exchange?ecdh=false"); err == nil {
+		t.Fatal("expected an aws kms key with ecdh=false to be rejected")
+	}
+
+	keyID, region, _, err := parseAWSKMS("awskms:///arn:aws:kms:us-east-1:111122223333:key/1234?ecdh=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "arn:aws:kms:us-east-1:111122223333:key/1234" || region != "us-east-1" {
+		t.Errorf("unexpected key %s in region %s", keyID, region)
+	}
+
+	if _, region, _, err = parseAWSKMS("awskms://alias/trisa-exchange?ecdh=true"); err != nil {
+		t.Fatal(err)
+	}
+	if region != "us-west-2" {
+		t.Errorf("expected the region of the environment, got %s", region)
+	}
+}
+
+func TestAWSKMSSharedSecret(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests are signed with the credentials in the environment for the region
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "/us-west-2/kms/aws4_request") {
+			http.Error(w, "unsigned request", http.StatusForbidden)
+			return
+		}
+
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GetPublicKey":
+			json.NewEncoder(w).Encode(map[string]string{"PublicKey": base64.StdEncoding.EncodeToString(der), "KeyUsage": "KEY_AGREEMENT"})
+		case "TrentService.DeriveSharedSecret":
+			data, _ := base64.StdEncoding.DecodeString(req["PublicKey"])
+			pub, err := x509.ParsePKIXPublicKey(data)
+			if err != nil || req["KeyAgreementAlgorithm"] != "ECDH" {
+				http.Error(w, "invalid public key", http.StatusBadRequest)
+				return
+			}
+			peer := pub.(*ecdsa.PublicKey)
+			x, _ := peer.Curve.ScalarMult(peer.X, peer.Y, priv.D.Bytes())
+			json.NewEncoder(w).Encode(map[string]string{"SharedSecret": base64.StdEncoding.EncodeToString(x.FillBytes(make([]byte, 32)))})
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	defer func(url string) { awsKMSURL = url }(awsKMSURL)
+	awsKMSURL = srv.URL + "/?region=%s"
+	for key, value := range map[string]string{"AWS_ACCESS_KEY_ID": "AKIDEXAMPLE", "AWS_SECRET_ACCESS_KEY": "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	key, err := Open(context.Background(), "awskms://alias/trisa-exchange?region=us-west-2&ecdh=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ephemeral, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := key.(*awsKMSKey).SharedSecret(&ephemeral.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, _ := elliptic.P256().ScalarMult(priv.X, priv.Y, ephemeral.D.Bytes())
+	if string(secret) != string(x.FillBytes(make([]byte, 32))) {
+		t.Error("the shared secret derived by kms does not match")
+	}
+}
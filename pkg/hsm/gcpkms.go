@@ -0,0 +1,219 @@
+package hsm
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/secrets"
+)
+
+// GCPKMSScheme is the URI scheme of keys in Google Cloud KMS.
+const GCPKMSScheme = "gcpkms://"
+
+// Cloud KMS endpoint and the lifetime of cached access tokens
+var (
+	gcpKMSURL     = "https://cloudkms.googleapis.com/v1/"
+	kmsTokenTTL   = 5 * time.Minute
+	kmsCastagnoli = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// isGCPKMS returns true if the location is a Cloud KMS key.
+func isGCPKMS(location string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(location)), GCPKMSScheme)
+}
+
+// parseGCPKMS parses the resource name of the key version and the options of a Cloud
+// KMS key URI, e.g. gcpkms://projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1?rate=10.
+func parseGCPKMS(location string) (name string, opts *kmsOptions, err error) {
+	var uri *url.URL
+	if uri, err = url.Parse(strings.TrimSpace(location)); err != nil {
+		return "", nil, err
+	}
+
+	name = strings.Trim(uri.Host+uri.Path, "/")
+	parts := strings.Split(name, "/")
+	if len(parts) != 10 || parts[0] != "projects" || parts[2] != "locations" || parts[4] != "keyRings" || parts[6] != "cryptoKeys" || parts[8] != "cryptoKeyVersions" {
+		return "", nil, fmt.Errorf("%q is not the resource name of a cloud kms key version", name)
+	}
+
+	if opts, err = parseKMSOptions(uri.Query()); err != nil {
+		return "", nil, err
+	}
+	return name, opts, nil
+}
+
+// gcpKMSKey is an RSA decryption key version in Cloud KMS, which decrypts the payload
+// keys of envelopes with the asymmetricDecrypt API.
+type gcpKMSKey struct {
+	name    string
+	pub     *rsa.PublicKey
+	cache   *kmsCache
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// openGCPKMS fetches the public key of the Cloud KMS key version, which must be an RSA
+// decryption key with OAEP padding.
+func openGCPKMS(ctx context.Context, location string) (_ crypto.Decrypter, err error) {
+	var opts *kmsOptions
+	key := &gcpKMSKey{}
+	if key.name, opts, err = parseGCPKMS(location); err != nil {
+		return nil, err
+	}
+	key.cache = newKMSCache(opts)
+
+	var rep struct {
+		PEM       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err = key.do(ctx, http.MethodGet, key.name+"/publicKey", nil, &rep); err != nil {
+		return nil, fmt.Errorf("could not get public key of %s: %s", key.name, err)
+	}
+
+	// Envelope keys are encrypted with RSA-OAEP-SHA512 by the TRISA handler
+	if !strings.HasPrefix(rep.Algorithm, "RSA_DECRYPT_OAEP_") || !strings.HasSuffix(rep.Algorithm, "_SHA512") {
+		return nil, fmt.Errorf("cloud kms key algorithm %s is not an RSA-OAEP-SHA512 decryption key", rep.Algorithm)
+	}
+
+	block, _ := pem.Decode([]byte(rep.PEM))
+	if block == nil {
+		return nil, errors.New("could not decode public key of cloud kms key")
+	}
+
+	var pub interface{}
+	if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	if key.pub, ok = pub.(*rsa.PublicKey); !ok {
+		return nil, fmt.Errorf("unexpected public key type %T of cloud kms key", pub)
+	}
+	return key, nil
+}
+
+// Public returns the RSA public key of the key version.
+func (k *gcpKMSKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// Decrypt decrypts the ciphertext in Cloud KMS, which requires OAEP options with SHA-512
+// and no label.
+func (k *gcpKMSKey) Decrypt(_ io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) (_ []byte, err error) {
+	oaep, ok := opts.(*rsa.OAEPOptions)
+	if !ok || len(oaep.Label) > 0 || oaep.Hash != crypto.SHA512 {
+		return nil, errors.New("cloud kms key only decrypts with RSA-OAEP-SHA512")
+	}
+
+	return k.cache.do(ciphertext, func(ctx context.Context) ([]byte, error) {
+		return k.decrypt(ctx, ciphertext)
+	})
+}
+
+// decrypt calls asymmetricDecrypt, verifying the CRC32C checksums of the request and
+// response.
+func (k *gcpKMSKey) decrypt(ctx context.Context, ciphertext []byte) (_ []byte, err error) {
+	req := map[string]string{
+		"ciphertext":       base64.StdEncoding.EncodeToString(ciphertext),
+		"ciphertextCrc32c": strconv.FormatUint(uint64(crc32.Checksum(ciphertext, kmsCastagnoli)), 10),
+	}
+
+	var rep struct {
+		Plaintext                string `json:"plaintext"`
+		PlaintextCrc32c          string `json:"plaintextCrc32c"`
+		VerifiedCiphertextCrc32c bool   `json:"verifiedCiphertextCrc32c"`
+	}
+	if err = k.do(ctx, http.MethodPost, k.name+":asymmetricDecrypt", req, &rep); err != nil {
+		if errors.Is(err, errKMSExhausted) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("could not decrypt with %s: %s", k.name, err)
+	}
+
+	if !rep.VerifiedCiphertextCrc32c {
+		return nil, errors.New("cloud kms did not verify the ciphertext checksum")
+	}
+
+	var plaintext []byte
+	if plaintext, err = base64.StdEncoding.DecodeString(rep.Plaintext); err != nil {
+		return nil, err
+	}
+
+	if strconv.FormatUint(uint64(crc32.Checksum(plaintext, kmsCastagnoli)), 10) != rep.PlaintextCrc32c {
+		return nil, errors.New("cloud kms plaintext checksum does not match")
+	}
+	return plaintext, nil
+}
+
+// do sends an authenticated request to the Cloud KMS API and decodes the response.
+func (k *gcpKMSKey) do(ctx context.Context, method, path string, in, out interface{}) (err error) {
+	var token string
+	if token, err = k.accessToken(ctx); err != nil {
+		return fmt.Errorf("could not authenticate with google cloud: %s", err)
+	}
+
+	var body io.Reader
+	if in != nil {
+		var data []byte
+		if data, err = json.Marshal(in); err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, method, gcpKMSURL+path, body); err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	var rep *http.Response
+	if rep, err = http.DefaultClient.Do(req); err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+
+	switch {
+	case rep.StatusCode == http.StatusTooManyRequests || rep.StatusCode == http.StatusServiceUnavailable:
+		return errKMSExhausted
+	case rep.StatusCode < 200 || rep.StatusCode >= 300:
+		data, _ := ioutil.ReadAll(rep.Body)
+		return fmt.Errorf("%s: %s", rep.Status, strings.TrimSpace(string(data)))
+	}
+	return json.NewDecoder(rep.Body).Decode(out)
+}
+
+// accessToken returns a cached access token so that the metadata server is not asked
+// for a token for every decryption.
+func (k *gcpKMSKey) accessToken(ctx context.Context) (_ string, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.token != "" && time.Now().Before(k.expires) {
+		return k.token, nil
+	}
+
+	if k.token, err = secrets.GCPAccessToken(ctx); err != nil {
+		return "", err
+	}
+	k.expires = time.Now().Add(kmsTokenTTL)
+	return k.token, nil
+}
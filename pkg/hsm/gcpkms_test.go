@@ -0,0 +1,104 @@
+package hsm
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+)
+
+const testKeyVersion = "projects/p/locations/global/keyRings/trisa/cryptoKeys/exchange/cryptoKeyVersions/1"
+
+func TestGCPKMSDecrypt(t *testing.T) {
+	// CRC32C check value of the published test vector "123456789"
+	if sum := crc32.Checksum([]byte("123456789"), kmsCastagnoli); sum != 0xE3069283 {
+		t.Fatalf("unexpected crc32c check value %08x", sum)
+	}
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decryptions int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/" + testKeyVersion + "/publicKey":
+			json.NewEncoder(w).Encode(map[string]string{
+				"pem":       string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+				"algorithm": "RSA_DECRYPT_OAEP_2048_SHA512",
+			})
+		case "/" + testKeyVersion + ":asymmetricDecrypt":
+			decryptions++
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			ciphertext, _ := base64.StdEncoding.DecodeString(req["ciphertext"])
+			plaintext, err := rsa.DecryptOAEP(sha512.New(), nil, priv, ciphertext, nil)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"plaintext":                base64.StdEncoding.EncodeToString(plaintext),
+				"plaintextCrc32c":          strconv.FormatUint(uint64(crc32.Checksum(plaintext, kmsCastagnoli)), 10),
+				"verifiedCiphertextCrc32c": req["ciphertextCrc32c"] == strconv.FormatUint(uint64(crc32.Checksum(ciphertext, kmsCastagnoli)), 10),
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	defer func(url string) { gcpKMSURL = url }(gcpKMSURL)
+	gcpKMSURL = srv.URL + "/"
+	os.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "token")
+	defer os.Unsetenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+
+	key, err := Open(context.Background(), GCPKMSScheme+testKeyVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := rsa.EncryptOAEP(sha512.New(), rand.Reader, &priv.PublicKey, []byte("payload key"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypter := key.(crypto.Decrypter)
+	for i := 0; i < 2; i++ {
+		plaintext, err := decrypter.Decrypt(nil, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA512})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(plaintext) != "payload key" {
+			t.Fatalf("unexpected plaintext %q", plaintext)
+		}
+	}
+	if decryptions != 1 {
+		t.Errorf("expected the decrypted key to be cached, got %d decryptions", decryptions)
+	}
+
+	if _, err = decrypter.Decrypt(nil, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256}); err == nil {
+		t.Error("expected decryption with RSA-OAEP-SHA256 to be refused")
+	}
+}
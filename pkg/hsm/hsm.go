@@ -0,0 +1,208 @@
+/*
+Package hsm opens RSA private keys that are kept in a hardware security module and
+accessed with PKCS #11, so that envelopes can be opened without the private key ever
+being in the memory of the process: the keys implement crypto.Decrypter and decrypt
+the payload keys of secure envelopes in the token with RSA-OAEP.
+
+Keys are identified by PKCS #11 URIs (RFC 7512), e.g.
+
+	pkcs11:token=trisa;object=signing-key?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=/run/secrets/hsm-pin
+
+The token is selected by its label (token) or slot (slot-id) and the key by its label
+(object) and/or its ID (id, percent encoded). The PIN is given inline with pin-value or
+loaded from a secret location with pin-source. PKCS #11 support requires cgo and the
+pkcs11 build tag, since the PKCS #11 module of the HSM vendor is loaded at runtime.
+
+Keys may also be RSA-OAEP-SHA512 decryption keys in Google Cloud KMS, identified by the
+resource name of the key version, e.g.
+
+	gcpkms://projects/p/locations/global/keyRings/trisa/cryptoKeys/exchange/cryptoKeyVersions/1?rate=10
+
+or ECC NIST key agreement keys in AWS KMS, identified by key ID, alias, or ARN, e.g.
+
+	awskms://alias/trisa-exchange?region=us-east-1&ecdh=true
+
+which open envelopes that are wrapped with ECDH for their ECDSA public keys. Standard
+TRISA peers seal envelopes with RSA-OAEP and cannot send to these keys, so AWS KMS key
+URIs must opt in with ecdh=true. Cloud KMS
+keys are authenticated like Secret Manager secrets and AWS KMS keys like Secrets
+Manager secrets. Since decryptions are subject to KMS quotas, decrypted payload keys
+are cached (cache entries for cache-ttl), concurrent decryptions of the same key are
+coalesced, and requests are limited to rate per second (with bursts of burst) and
+retried with backoff if the quota is exhausted.
+*/
+package hsm
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rotationalio/trisa/pkg/secrets"
+)
+
+// Scheme is the URI scheme of PKCS #11 key locations.
+const Scheme = "pkcs11:"
+
+// ErrUnsupported is returned if the binary was built without PKCS #11 support.
+var ErrUnsupported = errors.New("built without PKCS #11 support (requires cgo and the pkcs11 build tag)")
+
+// PrivateKey is an opened key: a crypto.Decrypter for RSA keys or an envelope
+// KeyAgreement for ECDSA keys.
+type PrivateKey interface {
+	Public() crypto.PublicKey
+}
+
+// IsURI returns true if the key location is a PKCS #11 URI or a Cloud KMS or AWS KMS key
+// rather than a secret location.
+func IsURI(location string) bool {
+	return isPKCS11(location) || isGCPKMS(location) || isAWSKMS(location)
+}
+
+// Open the private key at the PKCS #11 URI or in Cloud KMS or AWS KMS.
+func Open(ctx context.Context, location string) (PrivateKey, error) {
+	switch {
+	case isGCPKMS(location):
+		return openGCPKMS(ctx, location)
+	case isAWSKMS(location):
+		return openAWSKMS(ctx, location)
+	default:
+		return openPKCS11(ctx, location)
+	}
+}
+
+// Validate parses the key location without opening the key.
+func Validate(location string) (err error) {
+	switch {
+	case isGCPKMS(location):
+		_, _, err = parseGCPKMS(location)
+	case isAWSKMS(location):
+		_, _, _, err = parseAWSKMS(location)
+	default:
+		_, err = ParseURI(location)
+	}
+	return err
+}
+
+// isPKCS11 returns true if the location is a PKCS #11 URI.
+func isPKCS11(location string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(location)), Scheme)
+}
+
+// URI is a parsed PKCS #11 URI that identifies a private key in a token.
+type URI struct {
+	Module    string
+	Token     string
+	Slot      int
+	HasSlot   bool
+	Object    string
+	ID        []byte
+	PinValue  string
+	PinSource string
+}
+
+// ParseURI parses the PKCS #11 URI of a private key.
+func ParseURI(location string) (uri *URI, err error) {
+	location = strings.TrimSpace(location)
+	if !isPKCS11(location) {
+		return nil, fmt.Errorf("%q is not a PKCS #11 URI", location)
+	}
+
+	path, query := location[len(Scheme):], ""
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+
+	uri = &URI{}
+	for _, attr := range strings.Split(path, ";") {
+		if attr == "" {
+			continue
+		}
+
+		var name, value string
+		if name, value, err = splitAttr(attr); err != nil {
+			return nil, err
+		}
+
+		switch name {
+		case "token":
+			uri.Token = value
+		case "object":
+			uri.Object = value
+		case "id":
+			uri.ID = []byte(value)
+		case "slot-id":
+			if uri.Slot, err = strconv.Atoi(value); err != nil || uri.Slot < 0 {
+				return nil, fmt.Errorf("invalid slot-id %q", value)
+			}
+			uri.HasSlot = true
+		case "type":
+			if value != "private" {
+				return nil, fmt.Errorf("PKCS #11 object must be a private key, not %q", value)
+			}
+		}
+	}
+
+	for _, attr := range strings.Split(query, "&") {
+		if attr == "" {
+			continue
+		}
+
+		var name, value string
+		if name, value, err = splitAttr(attr); err != nil {
+			return nil, err
+		}
+
+		switch name {
+		case "module-path":
+			uri.Module = value
+		case "pin-value":
+			uri.PinValue = value
+		case "pin-source":
+			uri.PinSource = value
+		}
+	}
+
+	if uri.Module == "" {
+		return nil, errors.New("PKCS #11 URI requires the module-path of the PKCS #11 library")
+	}
+
+	if uri.Token == "" && !uri.HasSlot {
+		return nil, errors.New("PKCS #11 URI requires a token label or slot-id")
+	}
+
+	if uri.Object == "" && len(uri.ID) == 0 {
+		return nil, errors.New("PKCS #11 URI requires an object label or id")
+	}
+	return uri, nil
+}
+
+// splitAttr splits a URI attribute and percent decodes its value.
+func splitAttr(attr string) (name, value string, err error) {
+	parts := strings.SplitN(attr, "=", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid PKCS #11 URI attribute %q", attr)
+	}
+
+	if value, err = url.PathUnescape(parts[1]); err != nil {
+		return "", "", fmt.Errorf("invalid PKCS #11 URI attribute %q: %s", attr, err)
+	}
+	return strings.ToLower(parts[0]), value, nil
+}
+
+// Pin returns the user PIN of the token, loading it from the pin source if specified.
+func (u *URI) Pin(ctx context.Context) (_ string, err error) {
+	if u.PinSource == "" {
+		return u.PinValue, nil
+	}
+
+	var data []byte
+	if data, err = secrets.Load(ctx, u.PinSource); err != nil {
+		return "", fmt.Errorf("could not load PKCS #11 pin: %s", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,184 @@
+package hsm
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/secrets"
+	"golang.org/x/time/rate"
+)
+
+// Defaults of the options of cloud KMS keys and the retries of throttled requests
+var (
+	kmsRetries      = 4
+	kmsBackoff      = 250 * time.Millisecond
+	kmsDefaultCache = 4096
+	kmsDefaultTTL   = 10 * time.Minute
+	kmsDefaultRate  = 25.0
+	kmsDefaultBurst = 50
+	errKMSExhausted = errors.New("kms quota exhausted")
+)
+
+// kmsOptions are the options of a cloud KMS key that are specified in the query of the
+// key URI: the size (cache) and lifetime (cache-ttl) of the cache of decrypted payload
+// keys and the rate of KMS requests per second with bursts of burst requests.
+type kmsOptions struct {
+	cache int
+	ttl   time.Duration
+	rate  float64
+	burst int
+}
+
+// parseKMSOptions parses the options from the query of the key URI.
+func parseKMSOptions(query url.Values) (opts *kmsOptions, err error) {
+	opts = &kmsOptions{
+		cache: kmsDefaultCache,
+		ttl:   kmsDefaultTTL,
+		rate:  kmsDefaultRate,
+		burst: kmsDefaultBurst,
+	}
+
+	if value := query.Get("cache"); value != "" {
+		if opts.cache, err = strconv.Atoi(value); err != nil || opts.cache < 0 {
+			return nil, fmt.Errorf("invalid kms cache size %q", value)
+		}
+	}
+
+	if value := query.Get("cache-ttl"); value != "" {
+		if opts.ttl, err = time.ParseDuration(value); err != nil || opts.ttl < 0 {
+			return nil, fmt.Errorf("invalid kms cache ttl %q", value)
+		}
+	}
+
+	if value := query.Get("rate"); value != "" {
+		if opts.rate, err = strconv.ParseFloat(value, 64); err != nil || opts.rate <= 0 {
+			return nil, fmt.Errorf("invalid kms rate %q", value)
+		}
+	}
+
+	if value := query.Get("burst"); value != "" {
+		if opts.burst, err = strconv.Atoi(value); err != nil || opts.burst < 1 {
+			return nil, fmt.Errorf("invalid kms burst %q", value)
+		}
+	}
+	return opts, nil
+}
+
+// kmsCache makes requests to a cloud KMS on behalf of a key. Since every envelope
+// requires two requests and peers retransmit envelopes, the results are cached by the
+// digest of the input for a short time, concurrent requests with the same input are
+// coalesced into one request, and requests are rate limited below the KMS quota and
+// retried with backoff if the quota is exhausted anyway.
+type kmsCache struct {
+	opts    *kmsOptions
+	limiter *rate.Limiter
+	mu      sync.Mutex
+	results map[[32]byte]*kmsResult
+	calls   map[[32]byte]*kmsCall
+}
+
+type kmsResult struct {
+	output  []byte
+	expires time.Time
+}
+
+type kmsCall struct {
+	done   chan struct{}
+	output []byte
+	err    error
+}
+
+func newKMSCache(opts *kmsOptions) *kmsCache {
+	return &kmsCache{
+		opts:    opts,
+		limiter: rate.NewLimiter(rate.Limit(opts.rate), opts.burst),
+		results: make(map[[32]byte]*kmsResult),
+		calls:   make(map[[32]byte]*kmsCall),
+	}
+}
+
+// do returns the cached output for the input or calls the KMS with request, which
+// returns errKMSExhausted if the request was throttled and should be retried.
+func (c *kmsCache) do(input []byte, request func(context.Context) ([]byte, error)) (_ []byte, err error) {
+	digest := sha256.Sum256(input)
+	c.mu.Lock()
+	if result, ok := c.results[digest]; ok && time.Now().Before(result.expires) {
+		c.mu.Unlock()
+		return result.output, nil
+	}
+
+	// Wait for a concurrent request with the same input rather than repeating it
+	if call, ok := c.calls[digest]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.output, call.err
+	}
+
+	call := &kmsCall{done: make(chan struct{})}
+	c.calls[digest] = call
+	c.mu.Unlock()
+
+	call.output, call.err = c.retry(request)
+
+	c.mu.Lock()
+	delete(c.calls, digest)
+	if call.err == nil && c.opts.cache > 0 && c.opts.ttl > 0 {
+		c.store(digest, call.output)
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.output, call.err
+}
+
+// retry waits for the rate limiter before every attempt and retries throttled requests
+// with jittered exponential backoff.
+func (c *kmsCache) retry(request func(context.Context) ([]byte, error)) (output []byte, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+	defer cancel()
+
+	backoff := kmsBackoff
+	for attempt := 0; ; attempt++ {
+		if err = c.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		if output, err = request(ctx); !errors.Is(err, errKMSExhausted) || attempt >= kmsRetries {
+			return output, err
+		}
+
+		select {
+		case <-time.After(backoff/2 + time.Duration(rand.Int63n(int64(backoff)))):
+			backoff *= 2
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// store caches the output, evicting expired entries and then arbitrary entries if the
+// cache is full. The lock must be held by the caller.
+func (c *kmsCache) store(digest [32]byte, output []byte) {
+	now := time.Now()
+	if len(c.results) >= c.opts.cache {
+		for key, result := range c.results {
+			if now.After(result.expires) {
+				delete(c.results, key)
+			}
+		}
+	}
+
+	for key := range c.results {
+		if len(c.results) < c.opts.cache {
+			break
+		}
+		delete(c.results, key)
+	}
+	c.results[digest] = &kmsResult{output: output, expires: now.Add(c.opts.ttl)}
+}
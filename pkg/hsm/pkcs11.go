@@ -0,0 +1,380 @@
+//go:build pkcs11 && cgo
+// +build pkcs11,cgo
+
+package hsm
+
+/*
+#cgo linux LDFLAGS: -ldl
+
+#include <dlfcn.h>
+#include <stdlib.h>
+#include <string.h>
+
+// The subset of the PKCS #11 v2.40 API that is needed to decrypt with a private key in
+// a token. The functions are looked up in the module at runtime, so the headers and
+// library of the HSM vendor are not required to build.
+typedef unsigned long CK_ULONG;
+typedef CK_ULONG CK_RV;
+typedef CK_ULONG CK_SLOT_ID;
+typedef CK_ULONG CK_SESSION_HANDLE;
+typedef CK_ULONG CK_OBJECT_HANDLE;
+
+typedef struct { unsigned char major; unsigned char minor; } CK_VERSION;
+
+typedef struct {
+	unsigned char label[32];
+	unsigned char manufacturerID[32];
+	unsigned char model[16];
+	unsigned char serialNumber[16];
+	CK_ULONG flags;
+	CK_ULONG ulMaxSessionCount;
+	CK_ULONG ulSessionCount;
+	CK_ULONG ulMaxRwSessionCount;
+	CK_ULONG ulRwSessionCount;
+	CK_ULONG ulMaxPinLen;
+	CK_ULONG ulMinPinLen;
+	CK_ULONG ulTotalPublicMemory;
+	CK_ULONG ulFreePublicMemory;
+	CK_ULONG ulTotalPrivateMemory;
+	CK_ULONG ulFreePrivateMemory;
+	CK_VERSION hardwareVersion;
+	CK_VERSION firmwareVersion;
+	unsigned char utcTime[16];
+} CK_TOKEN_INFO;
+
+typedef struct { CK_ULONG type; void *pValue; CK_ULONG ulValueLen; } CK_ATTRIBUTE;
+typedef struct { CK_ULONG mechanism; void *pParameter; CK_ULONG ulParameterLen; } CK_MECHANISM;
+typedef struct {
+	CK_ULONG hashAlg;
+	CK_ULONG mgf;
+	CK_ULONG source;
+	void *pSourceData;
+	CK_ULONG ulSourceDataLen;
+} CK_RSA_PKCS_OAEP_PARAMS;
+
+#define CKR_OK                           0x000UL
+#define CKR_USER_ALREADY_LOGGED_IN       0x100UL
+#define CKR_CRYPTOKI_ALREADY_INITIALIZED 0x191UL
+#define CKF_SERIAL_SESSION               0x004UL
+#define CKU_USER                         1UL
+#define CKA_CLASS                        0x000UL
+#define CKA_LABEL                        0x003UL
+#define CKA_ID                           0x102UL
+#define CKA_MODULUS                      0x120UL
+#define CKA_PUBLIC_EXPONENT              0x122UL
+#define CKO_PRIVATE_KEY                  3UL
+#define CKM_RSA_PKCS_OAEP                0x009UL
+#define CKG_MGF1_SHA256                  0x002UL
+#define CKG_MGF1_SHA512                  0x004UL
+#define CKM_SHA256                       0x250UL
+#define CKM_SHA512                       0x270UL
+
+// Errors of the helpers that are not PKCS #11 return values
+#define P11_FUNCTION_MISSING             0xFFFFFFFFUL
+#define P11_TOKEN_NOT_FOUND              0xFFFFFFFEUL
+#define P11_NO_MEMORY                    0xFFFFFFFDUL
+
+static void *p11_open(const char *path) {
+	return dlopen(path, RTLD_NOW | RTLD_LOCAL);
+}
+
+static const char *p11_error(void) {
+	const char *err = dlerror();
+	return err ? err : "unknown error";
+}
+
+static CK_RV p11_initialize(void *module) {
+	CK_RV (*fn)(void *) = dlsym(module, "C_Initialize");
+	if (!fn) return P11_FUNCTION_MISSING;
+	CK_RV rv = fn(NULL);
+	return rv == CKR_CRYPTOKI_ALREADY_INITIALIZED ? CKR_OK : rv;
+}
+
+// p11_find_slot finds the slot of the token with the label (padded with spaces).
+static CK_RV p11_find_slot(void *module, const unsigned char *label, CK_SLOT_ID *slot) {
+	CK_RV (*list)(unsigned char, CK_SLOT_ID *, CK_ULONG *) = dlsym(module, "C_GetSlotList");
+	CK_RV (*info)(CK_SLOT_ID, CK_TOKEN_INFO *) = dlsym(module, "C_GetTokenInfo");
+	if (!list || !info) return P11_FUNCTION_MISSING;
+
+	CK_ULONG count = 0;
+	CK_RV rv = list(1, NULL, &count);
+	if (rv != CKR_OK) return rv;
+	if (count == 0) return P11_TOKEN_NOT_FOUND;
+
+	CK_SLOT_ID *slots = calloc(count, sizeof(CK_SLOT_ID));
+	if (!slots) return P11_NO_MEMORY;
+	if ((rv = list(1, slots, &count)) != CKR_OK) {
+		free(slots);
+		return rv;
+	}
+
+	for (CK_ULONG i = 0; i < count; i++) {
+		CK_TOKEN_INFO token;
+		if (info(slots[i], &token) == CKR_OK && memcmp(token.label, label, 32) == 0) {
+			*slot = slots[i];
+			free(slots);
+			return CKR_OK;
+		}
+	}
+	free(slots);
+	return P11_TOKEN_NOT_FOUND;
+}
+
+static CK_RV p11_login(void *module, CK_SLOT_ID slot, const char *pin, CK_ULONG pinLen, CK_SESSION_HANDLE *session) {
+	CK_RV (*open)(CK_SLOT_ID, CK_ULONG, void *, void *, CK_SESSION_HANDLE *) = dlsym(module, "C_OpenSession");
+	CK_RV (*login)(CK_SESSION_HANDLE, CK_ULONG, const char *, CK_ULONG) = dlsym(module, "C_Login");
+	if (!open || !login) return P11_FUNCTION_MISSING;
+
+	CK_RV rv = open(slot, CKF_SERIAL_SESSION, NULL, NULL, session);
+	if (rv != CKR_OK || pinLen == 0) return rv;
+
+	rv = login(*session, CKU_USER, pin, pinLen);
+	return rv == CKR_USER_ALREADY_LOGGED_IN ? CKR_OK : rv;
+}
+
+// p11_find_key finds the private key with the label and/or ID, either may be empty.
+static CK_RV p11_find_key(void *module, CK_SESSION_HANDLE session, void *label, CK_ULONG labelLen, void *id, CK_ULONG idLen, CK_OBJECT_HANDLE *key, CK_ULONG *found) {
+	CK_RV (*init)(CK_SESSION_HANDLE, CK_ATTRIBUTE *, CK_ULONG) = dlsym(module, "C_FindObjectsInit");
+	CK_RV (*find)(CK_SESSION_HANDLE, CK_OBJECT_HANDLE *, CK_ULONG, CK_ULONG *) = dlsym(module, "C_FindObjects");
+	CK_RV (*final)(CK_SESSION_HANDLE) = dlsym(module, "C_FindObjectsFinal");
+	if (!init || !find || !final) return P11_FUNCTION_MISSING;
+
+	CK_ULONG class = CKO_PRIVATE_KEY;
+	CK_ATTRIBUTE tmpl[3] = {{CKA_CLASS, &class, sizeof(class)}};
+	CK_ULONG n = 1;
+	if (labelLen > 0) tmpl[n++] = (CK_ATTRIBUTE){CKA_LABEL, label, labelLen};
+	if (idLen > 0) tmpl[n++] = (CK_ATTRIBUTE){CKA_ID, id, idLen};
+
+	CK_RV rv = init(session, tmpl, n);
+	if (rv != CKR_OK) return rv;
+
+	CK_OBJECT_HANDLE keys[2];
+	rv = find(session, keys, 2, found);
+	final(session);
+	if (rv == CKR_OK && *found > 0) *key = keys[0];
+	return rv;
+}
+
+static CK_RV p11_attribute(void *module, CK_SESSION_HANDLE session, CK_OBJECT_HANDLE key, CK_ULONG type, void *value, CK_ULONG *valueLen) {
+	CK_RV (*get)(CK_SESSION_HANDLE, CK_OBJECT_HANDLE, CK_ATTRIBUTE *, CK_ULONG) = dlsym(module, "C_GetAttributeValue");
+	if (!get) return P11_FUNCTION_MISSING;
+
+	CK_ATTRIBUTE attr = {type, value, *valueLen};
+	CK_RV rv = get(session, key, &attr, 1);
+	*valueLen = attr.ulValueLen;
+	return rv;
+}
+
+static CK_RV p11_decrypt_oaep(void *module, CK_SESSION_HANDLE session, CK_OBJECT_HANDLE key, int sha512, void *in, CK_ULONG inLen, void *out, CK_ULONG *outLen) {
+	CK_RV (*init)(CK_SESSION_HANDLE, CK_MECHANISM *, CK_OBJECT_HANDLE) = dlsym(module, "C_DecryptInit");
+	CK_RV (*decrypt)(CK_SESSION_HANDLE, void *, CK_ULONG, void *, CK_ULONG *) = dlsym(module, "C_Decrypt");
+	if (!init || !decrypt) return P11_FUNCTION_MISSING;
+
+	CK_RSA_PKCS_OAEP_PARAMS params = {
+		sha512 ? CKM_SHA512 : CKM_SHA256,
+		sha512 ? CKG_MGF1_SHA512 : CKG_MGF1_SHA256,
+		0, NULL, 0,
+	};
+	CK_MECHANISM mech = {CKM_RSA_PKCS_OAEP, &params, sizeof(params)};
+
+	CK_RV rv = init(session, &mech, key);
+	if (rv != CKR_OK) return rv;
+	return decrypt(session, in, inLen, out, outLen);
+}
+*/
+import "C"
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+	"unsafe"
+)
+
+var (
+	modmu   sync.Mutex
+	modules = make(map[string]unsafe.Pointer)
+)
+
+// Key is an RSA private key in a PKCS #11 token. The key decrypts in the token with
+// RSA-OAEP and the private key material is never exported from the token.
+type Key struct {
+	mu      sync.Mutex
+	module  unsafe.Pointer
+	session C.CK_SESSION_HANDLE
+	handle  C.CK_OBJECT_HANDLE
+	pub     *rsa.PublicKey
+}
+
+// openPKCS11 logs into the token of the PKCS #11 URI and finds the private key. PKCS #11
+// modules are loaded and initialized once per process.
+func openPKCS11(ctx context.Context, location string) (_ crypto.Decrypter, err error) {
+	var uri *URI
+	if uri, err = ParseURI(location); err != nil {
+		return nil, err
+	}
+
+	var pin string
+	if pin, err = uri.Pin(ctx); err != nil {
+		return nil, err
+	}
+
+	key := &Key{}
+	if key.module, err = loadModule(uri.Module); err != nil {
+		return nil, err
+	}
+
+	slot := C.CK_SLOT_ID(uri.Slot)
+	if !uri.HasSlot {
+		label := make([]byte, 32)
+		for i := range label {
+			label[i] = ' '
+		}
+		copy(label, uri.Token)
+
+		if rv := C.p11_find_slot(key.module, (*C.uchar)(unsafe.Pointer(&label[0])), &slot); rv != C.CKR_OK {
+			return nil, fmt.Errorf("could not find PKCS #11 token %q: %s", uri.Token, rvError(rv))
+		}
+	}
+
+	cpin := C.CString(pin)
+	defer C.free(unsafe.Pointer(cpin))
+	if rv := C.p11_login(key.module, slot, cpin, C.CK_ULONG(len(pin)), &key.session); rv != C.CKR_OK {
+		return nil, fmt.Errorf("could not log into PKCS #11 token: %s", rvError(rv))
+	}
+
+	label, id := cbytes(uri.Object), cbytes(string(uri.ID))
+	defer C.free(label)
+	defer C.free(id)
+
+	var found C.CK_ULONG
+	if rv := C.p11_find_key(key.module, key.session, label, C.CK_ULONG(len(uri.Object)), id, C.CK_ULONG(len(uri.ID)), &key.handle, &found); rv != C.CKR_OK {
+		return nil, fmt.Errorf("could not find PKCS #11 key: %s", rvError(rv))
+	}
+
+	switch found {
+	case 0:
+		return nil, errors.New("no private key in the PKCS #11 token matches the URI")
+	case 1:
+	default:
+		return nil, errors.New("more than one private key in the PKCS #11 token matches the URI")
+	}
+
+	var modulus, exponent []byte
+	if modulus, err = key.attribute(C.CKA_MODULUS); err != nil {
+		return nil, fmt.Errorf("could not read modulus of PKCS #11 key (only RSA keys are supported): %s", err)
+	}
+	if exponent, err = key.attribute(C.CKA_PUBLIC_EXPONENT); err != nil {
+		return nil, fmt.Errorf("could not read public exponent of PKCS #11 key: %s", err)
+	}
+
+	e := new(big.Int).SetBytes(exponent)
+	if !e.IsInt64() || e.Int64() > int64(^uint32(0)>>1) {
+		return nil, errors.New("unsupported public exponent of PKCS #11 key")
+	}
+
+	key.pub = &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(e.Int64())}
+	return key, nil
+}
+
+// loadModule loads and initializes the PKCS #11 module at the path.
+func loadModule(path string) (_ unsafe.Pointer, err error) {
+	modmu.Lock()
+	defer modmu.Unlock()
+	if module, ok := modules[path]; ok {
+		return module, nil
+	}
+
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	module := C.p11_open(cpath)
+	if module == nil {
+		return nil, fmt.Errorf("could not load PKCS #11 module %s: %s", path, C.GoString(C.p11_error()))
+	}
+
+	if rv := C.p11_initialize(module); rv != C.CKR_OK {
+		return nil, fmt.Errorf("could not initialize PKCS #11 module %s: %s", path, rvError(rv))
+	}
+
+	modules[path] = module
+	return module, nil
+}
+
+// attribute reads an attribute of the key.
+func (k *Key) attribute(attr C.CK_ULONG) (_ []byte, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var size C.CK_ULONG
+	if rv := C.p11_attribute(k.module, k.session, k.handle, attr, nil, &size); rv != C.CKR_OK {
+		return nil, rvError(rv)
+	}
+
+	if size == 0 {
+		return nil, errors.New("attribute is empty")
+	}
+
+	buf := C.malloc(C.size_t(size))
+	defer C.free(buf)
+	if rv := C.p11_attribute(k.module, k.session, k.handle, attr, buf, &size); rv != C.CKR_OK {
+		return nil, rvError(rv)
+	}
+	return C.GoBytes(buf, C.int(size)), nil
+}
+
+// Public returns the RSA public key of the private key in the token.
+func (k *Key) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// Decrypt decrypts the ciphertext with RSA-OAEP in the token. Only OAEP with SHA-256 or
+// SHA-512 and no label is supported, which includes the RSA-OAEP-SHA512 of envelopes.
+func (k *Key) Decrypt(_ io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) (_ []byte, err error) {
+	oaep, ok := opts.(*rsa.OAEPOptions)
+	if !ok || len(oaep.Label) > 0 || (oaep.Hash != crypto.SHA256 && oaep.Hash != crypto.SHA512) {
+		return nil, errors.New("PKCS #11 keys only decrypt with RSA-OAEP with SHA-256 or SHA-512")
+	}
+
+	var sha512 C.int
+	if oaep.Hash == crypto.SHA512 {
+		sha512 = 1
+	}
+
+	in := C.CBytes(ciphertext)
+	defer C.free(in)
+
+	size := C.CK_ULONG(len(ciphertext))
+	out := C.malloc(C.size_t(size))
+	defer C.free(out)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if rv := C.p11_decrypt_oaep(k.module, k.session, k.handle, sha512, in, C.CK_ULONG(len(ciphertext)), out, &size); rv != C.CKR_OK {
+		return nil, fmt.Errorf("could not decrypt with PKCS #11 key: %s", rvError(rv))
+	}
+	return C.GoBytes(out, C.int(size)), nil
+}
+
+// cbytes copies the string to C memory, which must be freed by the caller.
+func cbytes(s string) unsafe.Pointer {
+	return C.CBytes(append([]byte(s), 0))
+}
+
+// rvError describes a PKCS #11 return value.
+func rvError(rv C.CK_RV) error {
+	switch rv {
+	case C.P11_FUNCTION_MISSING:
+		return errors.New("function not exported by the PKCS #11 module")
+	case C.P11_TOKEN_NOT_FOUND:
+		return errors.New("token not found")
+	case C.P11_NO_MEMORY:
+		return errors.New("out of memory")
+	}
+	return fmt.Errorf("CKR 0x%08X", uint64(rv))
+}
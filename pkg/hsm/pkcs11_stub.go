@@ -0,0 +1,17 @@
+//go:build !pkcs11 || !cgo
+// +build !pkcs11 !cgo
+
+package hsm
+
+import (
+	"context"
+	"crypto"
+)
+
+// openPKCS11 returns ErrUnsupported since the binary was built without PKCS #11 support.
+func openPKCS11(ctx context.Context, location string) (crypto.Decrypter, error) {
+	if _, err := ParseURI(location); err != nil {
+		return nil, err
+	}
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,171 @@
+package trisarl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/envelope"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+	"github.com/trisacrypto/trisa/pkg/trust"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// identity is an additional TRISA identity hosted by the server, which has its own
+// certificates, signing key, and peers manager. The server certificates are the default
+// identity, which is used if peers do not connect to the server name of an identity.
+type identity struct {
+	certs   *trust.Provider
+	pool    trust.ProviderPool
+	leaf    *x509.Certificate
+	key     interface{}
+	tlsConf *tls.Config
+	peers   *peers.Peers
+}
+
+// loadIdentities loads and verifies the certificates of the additional identities. The
+// certificates of each identity are also its signing certificates.
+func loadIdentities(conf config.Config) (identities []*identity, err error) {
+	seen := map[string]bool{}
+	for _, ident := range conf.Identities {
+		// Load the certificates in the same way as the server certificates
+		identConf := conf
+		identConf.ServerCerts = ident.Certs
+		if ident.CertPool != "" {
+			identConf.ServerCertPool = ident.CertPool
+		}
+		if ident.Password != "" {
+			identConf.CertsPassword = ident.Password
+		}
+
+		id := &identity{}
+		if id.certs, id.pool, err = loadCertificates(identConf); err != nil {
+			return nil, fmt.Errorf("could not load identity %q: %s", ident.Certs, err)
+		}
+
+		name := id.certs.String()
+		if err = checkCertificates(conf.Environment, name, id.certs, id.pool); err != nil {
+			return nil, err
+		}
+
+		if seen[name] {
+			return nil, fmt.Errorf("identity %q is hosted more than once", name)
+		}
+		seen[name] = true
+
+		if id.leaf, err = id.certs.GetLeafCertificate(); err != nil {
+			return nil, err
+		}
+
+		if id.key, err = envelope.PrivateKey(id.certs); err != nil {
+			return nil, err
+		}
+
+		if id.tlsConf, err = serverTLSConfig(id.certs, id.pool); err != nil {
+			return nil, err
+		}
+
+		id.peers = peers.New(id.certs, id.pool, conf.DirectoryAddr)
+		identities = append(identities, id)
+	}
+	return identities, nil
+}
+
+// matchIdentity returns the identity whose certificates are valid for the server name
+// that the peer connected to, or nil for the default identity. The certificate lock
+// must be held by the caller.
+func (s *Server) matchIdentity(serverName string) *identity {
+	if serverName == "" {
+		return nil
+	}
+
+	for _, id := range s.identities {
+		if id.leaf.VerifyHostname(serverName) == nil {
+			return id
+		}
+	}
+	return nil
+}
+
+// identityFor returns the identity that the peer of the RPC connected to, or nil for
+// the default identity.
+func (s *Server) identityFor(ctx context.Context) *identity {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			s.certmu.RLock()
+			defer s.certmu.RUnlock()
+			return s.matchIdentity(info.State.ServerName)
+		}
+	}
+	return nil
+}
+
+// networkFor returns the peers manager of the identity of the RPC.
+func (s *Server) networkFor(ctx context.Context) *peers.Peers {
+	if id := s.identityFor(ctx); id != nil {
+		return id.peers
+	}
+	return s.network()
+}
+
+// signingFor returns the signing certificates and private key of the identity of the
+// RPC, which are used to open the envelopes sent to the identity.
+func (s *Server) signingFor(ctx context.Context) (*trust.Provider, interface{}) {
+	if id := s.identityFor(ctx); id != nil {
+		return id.certs, id.key
+	}
+	return s.signing()
+}
+
+type localIdentityKey struct{}
+
+// LocalIdentity returns the common name of the TRISA identity of the node that the
+// transfer being handled was sent to from the context of the transfer pipeline, e.g. so
+// that transfer handlers of a server with multiple identities can route transfers to
+// the VASP they are for, or an empty string outside of the pipeline.
+func LocalIdentity(ctx context.Context) string {
+	if name, ok := ctx.Value(localIdentityKey{}).(string); ok {
+		return name
+	}
+	return ""
+}
+
+func withLocalIdentity(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, localIdentityKey{}, name)
+}
+
+// commonNameFor returns the common name of the identity of the RPC.
+func (s *Server) commonNameFor(ctx context.Context) string {
+	if id := s.identityFor(ctx); id != nil {
+		return id.certs.String()
+	}
+	return s.commonName()
+}
+
+// Identities returns the common names of the TRISA identities hosted by the server,
+// starting with the identity of the server certificates.
+func (s *Server) Identities() []string {
+	s.certmu.RLock()
+	defer s.certmu.RUnlock()
+	names := []string{s.mtlsCerts.String()}
+	for _, id := range s.identities {
+		names = append(names, id.certs.String())
+	}
+	return names
+}
+
+// identityPeers returns the peers manager of the hosted identity with the common name,
+// or nil if the server does not host the identity.
+func (s *Server) identityPeers(commonName string) *peers.Peers {
+	s.certmu.RLock()
+	defer s.certmu.RUnlock()
+	for _, id := range s.identities {
+		if id.certs.String() == commonName {
+			return id.peers
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package trisarl
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLocalIdentity(t *testing.T) {
+	if name := LocalIdentity(context.Background()); name != "" {
+		t.Errorf("expected no local identity outside of the pipeline, got %q", name)
+	}
+
+	ctx := withLocalIdentity(context.Background(), "vasp2.example.com")
+	if name := LocalIdentity(ctx); name != "vasp2.example.com" {
+		t.Errorf("expected local identity vasp2.example.com, got %q", name)
+	}
+}
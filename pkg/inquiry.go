@@ -0,0 +1,166 @@
+package trisarl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/addressbook"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// A beneficiary inquiry is a lightweight exchange that allows an originator to confirm
+// that the counterparty controls a wallet address before composing the full Travel
+// Rule message. The inquiry is a secure envelope whose payload has no identity and
+// whose transaction only contains the beneficiary address and network. The reply is a
+// confirmation receipt if the address is in the address book, otherwise an unknown wallet
+// address error is returned.
+const inquiryConfirmed = "beneficiary wallet address can receive transfers"
+
+// validateInquiry ensures an inquiry only contains the beneficiary address and network
+// so that a full transfer with a missing identity is not mistaken for an inquiry.
+func validateInquiry(t *Transfer) error {
+	tx := t.Transaction
+	if tx.Txid != "" || tx.Originator != "" || tx.Amount != 0 {
+		return protocol.Errorf(protocol.MissingFields, "an identity payload is required for transfers")
+	}
+
+	if tx.Beneficiary == "" || tx.Network == "" {
+		return protocol.Errorf(protocol.MissingFields, "beneficiary address and network are required for inquiries")
+	}
+
+	t.Inquiry = true
+	return nil
+}
+
+// Answer beneficiary inquiries from the address book. The response is sealed
+// directly so that inquiries never reach the handle stage.
+func (s *Server) inquiry(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		if !t.Inquiry {
+			return next(ctx, t)
+		}
+
+		tx := t.Transaction
+		if err = s.controlsAddress(ctx, t.Peer.String(), tx.Network, tx.Beneficiary); err != nil {
+			return err
+		}
+
+		receipt := &generic.ConfirmationReceipt{
+			EnvelopeId: t.In.Id,
+			ReceivedBy: t.Local,
+			ReceivedAt: time.Now().Format(time.RFC3339),
+			Message:    inquiryConfirmed,
+		}
+
+		t.Response = &protocol.Payload{}
+		if t.Response.Transaction, err = anypb.New(receipt); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("could not marshal confirmation receipt")
+			return protocol.Errorf(protocol.InternalError, "could not create inquiry response")
+		}
+
+		log.Ctx(ctx).Info().Str("peer", t.Peer.String()).Str("network", tx.Network).Msg("beneficiary inquiry confirmed")
+		return s.sealResponse(t)
+	}
+}
+
+// controlsAddress returns an unknown wallet address error if the wallet address on the
+// network is not in the address book. Addresses without a customer account are still
+// controlled by the node.
+func (s *Server) controlsAddress(ctx context.Context, peer, network, address string) (err error) {
+	if _, err = s.book.Lookup(network, address); err != nil && !errors.Is(err, addressbook.ErrNoAccount) {
+		if errors.Is(err, addressbook.ErrNotFound) {
+			log.Ctx(ctx).Info().Str("peer", peer).Str("network", network).Msg("unknown beneficiary wallet address")
+			return protocol.Errorf(protocol.UnkownWalletAddress, "beneficiary wallet address is not known")
+		}
+		log.Ctx(ctx).Error().Err(err).Msg("could not lookup beneficiary address")
+		return protocol.Errorf(protocol.InternalError, "could not lookup beneficiary address")
+	}
+	return nil
+}
+
+// The Address and AddressConfirmation messages of the v1beta1 protocol do not specify
+// any fields yet, so ConfirmAddress requests carry the wallet address in these request
+// metadata keys.
+const (
+	AddressNetworkKey = "x-trisa-network"
+	AddressKey        = "x-trisa-address"
+)
+
+// addressFromContext returns the network and wallet address of a ConfirmAddress request.
+func addressFromContext(ctx context.Context) (network, address string) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(AddressNetworkKey); len(vals) > 0 {
+			network = vals[0]
+		}
+		if vals := md.Get(AddressKey); len(vals) > 0 {
+			address = vals[0]
+		}
+	}
+	return network, address
+}
+
+// Inquire asks the TRISA peer with the common name if it controls the beneficiary
+// wallet address on the network before a full transfer is sent. A protocol error with
+// the unknown wallet address code is returned if the peer does not know the address.
+func (s *Server) Inquire(ctx context.Context, commonName, network, address string) (receipt *generic.ConfirmationReceipt, err error) {
+	var peer *peers.Peer
+	if peer, err = s.lookup(ctx, commonName); err != nil {
+		return nil, err
+	}
+
+	var sealKey interface{}
+	if sealKey, err = s.exchangeKeys(ctx, peer, false); err != nil {
+		return nil, fmt.Errorf("could not exchange keys with %s: %s", commonName, err)
+	}
+
+	payload := &protocol.Payload{}
+	if payload.Transaction, err = anypb.New(&generic.Transaction{Beneficiary: address, Network: network}); err != nil {
+		return nil, err
+	}
+
+	var in, out *protocol.SecureEnvelope
+	if in, err = s.sealEnvelope(peer.String(), handler.New("", payload, nil), sealKey); err != nil {
+		return nil, err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if out, err = peer.Transfer(in); err != nil {
+		s.recordEnvelope(ctx, peer.String(), store.Outgoing, in, payload, err)
+		return nil, err
+	}
+	s.recordEnvelope(ctx, peer.String(), store.Outgoing, in, payload, nil)
+
+	if out.Error != nil && out.Error.Code != 0 {
+		s.recordEnvelope(ctx, peer.String(), store.Incoming, out, nil, nil)
+		return nil, out.Error
+	}
+
+	var env *handler.Envelope
+	_, key := s.signing()
+	if env, err = s.openEnvelope(out, peer.String(), key); err != nil {
+		s.recordEnvelope(ctx, peer.String(), store.Incoming, out, nil, err)
+		return nil, err
+	}
+	s.recordEnvelope(ctx, peer.String(), store.Incoming, out, env.Payload, nil)
+
+	receipt = &generic.ConfirmationReceipt{}
+	if env.Payload.Transaction == nil {
+		return nil, errors.New("no confirmation receipt in inquiry response")
+	}
+	if err = env.Payload.Transaction.UnmarshalTo(receipt); err != nil {
+		return nil, fmt.Errorf("could not unmarshal confirmation receipt: %s", err)
+	}
+	return receipt, nil
+}
@@ -0,0 +1,93 @@
+package trisarl
+
+import (
+	"context"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// The interceptor chains run before the RPC handlers in the order they were added. The
+// built-in interceptors are added when the server is created so that they run before
+// any interceptors added by the embedding application with WithUnaryInterceptors or
+// WithStreamInterceptors.
+func (s *Server) interceptors() {
+	s.unary = append(s.unary, unaryRequestID, s.unaryTracing, unaryLogging, s.unaryErrors, s.unaryAccess, s.unaryRecovery)
+	s.stream = append(s.stream, streamRequestID, s.streamTracing, streamLogging, s.streamErrors, s.streamAccess, s.streamRecovery)
+}
+
+// chain returns the server options that install the interceptor chains.
+func (s *Server) chain() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.unary...),
+		grpc.ChainStreamInterceptor(s.stream...),
+	}
+}
+
+// unaryLogging logs the method, status code, and latency of every unary RPC.
+func unaryLogging(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (rep interface{}, err error) {
+	start := time.Now()
+	rep, err = handler(ctx, req)
+	log.Ctx(ctx).Debug().
+		Str("method", info.FullMethod).
+		Str("code", status.Code(err).String()).
+		Dur("latency", time.Since(start)).
+		Msg("unary rpc")
+	return rep, err
+}
+
+// streamLogging logs the method, status code, and duration of every streaming RPC.
+func streamLogging(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	start := time.Now()
+	err = handler(srv, stream)
+	log.Ctx(stream.Context()).Debug().
+		Str("method", info.FullMethod).
+		Str("code", status.Code(err).String()).
+		Dur("duration", time.Since(start)).
+		Msg("stream rpc")
+	return err
+}
+
+// unaryRecovery converts panics in the RPC handlers into internal errors so that a bug
+// in the handling of one request does not crash the server.
+func (s *Server) unaryRecovery(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (rep interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = s.recovered(ctx, info.FullMethod, r).Err()
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// streamRecovery converts panics in the streaming RPC handlers into internal errors.
+func (s *Server) streamRecovery(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = s.recovered(stream.Context(), info.FullMethod, r).Err()
+		}
+	}()
+	return handler(srv, stream)
+}
+
+// recovered logs the stack trace of the recovered panic, counts it, and returns the
+// internal error that is sent to the peer instead of the details of the panic.
+func (s *Server) recovered(ctx context.Context, method string, r interface{}) *protocol.Error {
+	atomic.AddUint64(&s.panics, 1)
+	log.Ctx(ctx).Error().
+		Str("method", method).
+		Interface("panic", r).
+		Str("stack", string(debug.Stack())).
+		Msg("recovered from panic")
+	return protocol.Errorf(protocol.InternalError, "an internal error occurred, please try again later")
+}
+
+// Panics returns the number of panics that have been recovered from since the server
+// was created.
+func (s *Server) Panics() uint64 {
+	return atomic.LoadUint64(&s.panics)
+}
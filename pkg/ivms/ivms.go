@@ -0,0 +1,448 @@
+/*
+Package ivms validates IVMS101 identity payloads against the constraints of the IVMS101
+data model. The Validate methods of the ivms101 package stop at the first violated
+constraint and leave many constraints unchecked, e.g. country codes, LEI checksums, and
+the originator information that FATF requires, so the originator VASP would have to fix
+and resend its payload once for every problem. Validate checks the whole payload and
+returns every violated constraint with the path of the field, which is attached to the
+UnparseableIdentity error returned to the peer so that it can be read by a machine.
+*/
+package ivms
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The key in the error details that identifies the struct as a list of violations.
+const detailsKey = "ivms101_violations"
+
+// Violation is an IVMS101 constraint that a field of the identity payload violates. The
+// field is the path of the field in the payload with the protocol buffer field names,
+// e.g. "originator.originator_persons[0].natural_person.name".
+type Violation struct {
+	Field   string
+	Message string
+}
+
+// Violations are the IVMS101 constraints violated by an identity payload.
+type Violations []Violation
+
+// Error lists every violation.
+func (v Violations) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, violation := range v {
+		msgs = append(msgs, violation.Field+": "+violation.Message)
+	}
+	return fmt.Sprintf("identity payload violates %d IVMS101 constraint(s): %s", len(v), strings.Join(msgs, "; "))
+}
+
+// Reject creates an UnparseableIdentity TRISA protocol error that lists the violations
+// in its message and in its details.
+func (v Violations) Reject() *protocol.Error {
+	err := protocol.Errorf(protocol.UnparseableIdentity, v.Error())
+	if details, derr := v.Details(); derr == nil {
+		err.Details = details
+	}
+	return err
+}
+
+// Details encodes the violations as a protocol buffer Any message.
+func (v Violations) Details() (_ *anypb.Any, err error) {
+	items := make([]interface{}, 0, len(v))
+	for _, violation := range v {
+		items = append(items, map[string]interface{}{
+			"field":   violation.Field,
+			"message": violation.Message,
+		})
+	}
+
+	var details *structpb.Struct
+	if details, err = structpb.NewStruct(map[string]interface{}{detailsKey: items}); err != nil {
+		return nil, fmt.Errorf("could not encode violations: %s", err)
+	}
+	return anypb.New(details)
+}
+
+// FromError extracts the violations from a TRISA protocol error. If the error has no
+// details or the details are not violations, ok is false.
+func FromError(e *protocol.Error) (_ Violations, ok bool) {
+	if e == nil || e.Details == nil {
+		return nil, false
+	}
+
+	details := &structpb.Struct{}
+	if err := e.Details.UnmarshalTo(details); err != nil {
+		return nil, false
+	}
+
+	var value *structpb.Value
+	if value, ok = details.Fields[detailsKey]; !ok || value.GetListValue() == nil {
+		return nil, false
+	}
+
+	violations := make(Violations, 0, len(value.GetListValue().GetValues()))
+	for _, item := range value.GetListValue().GetValues() {
+		fields := item.GetStructValue().GetFields()
+		violations = append(violations, Violation{
+			Field:   fields["field"].GetStringValue(),
+			Message: fields["message"].GetStringValue(),
+		})
+	}
+	return violations, true
+}
+
+// Validate returns every IVMS101 constraint that the identity payload of a transfer
+// violates, or nil if the payload is valid. The originator must be present with at
+// least one person; the beneficiary and the VASPs are validated if they are present,
+// since the beneficiary VASP completes them in its response.
+func Validate(identity *ivms101.IdentityPayload) Violations {
+	v := &validator{}
+	if identity == nil {
+		v.add("", "an identity payload is required")
+		return v.violations
+	}
+
+	if identity.Originator == nil || len(identity.Originator.OriginatorPersons) == 0 {
+		v.add("originator.originator_persons", "at least one originator person is required")
+	} else {
+		for i, person := range identity.Originator.OriginatorPersons {
+			path := fmt.Sprintf("originator.originator_persons[%d]", i)
+			v.person(path, person)
+			v.originatorInformation(path, person)
+		}
+		v.accounts("originator.account_numbers", identity.Originator.AccountNumbers)
+	}
+
+	if identity.Beneficiary != nil {
+		for i, person := range identity.Beneficiary.BeneficiaryPersons {
+			v.person(fmt.Sprintf("beneficiary.beneficiary_persons[%d]", i), person)
+		}
+		v.accounts("beneficiary.account_numbers", identity.Beneficiary.AccountNumbers)
+	}
+
+	if vasp := identity.OriginatingVasp.GetOriginatingVasp(); vasp != nil {
+		v.person("originating_vasp.originating_vasp", vasp)
+	}
+	if vasp := identity.BeneficiaryVasp.GetBeneficiaryVasp(); vasp != nil {
+		v.person("beneficiary_vasp.beneficiary_vasp", vasp)
+	}
+
+	if identity.TransferPath != nil {
+		sequences := make(map[uint64]struct{})
+		for i, intermediary := range identity.TransferPath.TransferPath {
+			path := fmt.Sprintf("transfer_path.transfer_path[%d]", i)
+			if intermediary.IntermediaryVasp == nil {
+				v.add(path+".intermediary_vasp", "an intermediary VASP is required")
+			} else {
+				v.person(path+".intermediary_vasp", intermediary.IntermediaryVasp)
+			}
+
+			// Constraint: SequentialIntegrity
+			if _, ok := sequences[intermediary.Sequence]; ok {
+				v.add(path+".sequence", "sequence %d is not unique", intermediary.Sequence)
+			}
+			sequences[intermediary.Sequence] = struct{}{}
+		}
+	}
+
+	if identity.PayloadMetadata != nil {
+		for i, method := range identity.PayloadMetadata.TransliterationMethod {
+			if _, ok := ivms101.TransliterationMethodCode_name[int32(method)]; !ok {
+				v.add(fmt.Sprintf("payload_metadata.transliteration_method[%d]", i), "invalid transliteration method code %d", method)
+			}
+		}
+	}
+	return v.violations
+}
+
+type validator struct {
+	violations Violations
+}
+
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) maxText(field, value string, max int) {
+	if len(value) > max {
+		v.add(field, "must be at most %d characters", max)
+	}
+}
+
+func (v *validator) country(field, code string) {
+	if code != "" && !validCountry(code) {
+		v.add(field, "%q is not an ISO 3166-1 alpha-2 country code", code)
+	}
+}
+
+func (v *validator) accounts(field string, accounts []string) {
+	for i, account := range accounts {
+		v.maxText(fmt.Sprintf("%s[%d]", field, i), account, 100)
+	}
+}
+
+func (v *validator) person(path string, person *ivms101.Person) {
+	switch {
+	case person.GetNaturalPerson() != nil:
+		v.naturalPerson(join(path, "natural_person"), person.GetNaturalPerson())
+	case person.GetLegalPerson() != nil:
+		v.legalPerson(join(path, "legal_person"), person.GetLegalPerson())
+	default:
+		v.add(path, "a natural person or a legal person is required")
+	}
+}
+
+// Constraint: OriginatorInformationNaturalPerson and OriginatorInformationLegalPerson
+func (v *validator) originatorInformation(path string, person *ivms101.Person) {
+	if p := person.GetNaturalPerson(); p != nil {
+		if len(p.GeographicAddresses) == 0 && p.CustomerIdentification == "" && p.NationalIdentification == nil && p.DateAndPlaceOfBirth == nil {
+			v.add(join(path, "natural_person"), "a natural person originator requires a geographic address, customer identification, national identification, or date and place of birth")
+		}
+	}
+	if p := person.GetLegalPerson(); p != nil {
+		if len(p.GeographicAddresses) == 0 && p.CustomerNumber == "" && p.NationalIdentification == nil {
+			v.add(join(path, "legal_person"), "a legal person originator requires a geographic address, customer number, or national identification")
+		}
+	}
+}
+
+func (v *validator) naturalPerson(path string, p *ivms101.NaturalPerson) {
+	if p.Name == nil || len(p.Name.NameIdentifiers) == 0 {
+		v.add(join(path, "name.name_identifiers"), "at least one name identifier is required")
+	} else {
+		var legal bool
+		for i, name := range p.Name.NameIdentifiers {
+			v.naturalName(fmt.Sprintf("%s.name.name_identifiers[%d]", path, i), name.PrimaryIdentifier, name.SecondaryIdentifier, name.NameIdentifierType)
+			legal = legal || name.NameIdentifierType == ivms101.NaturalPersonLegal
+		}
+
+		// Constraint: LegalNamePresent
+		if !legal {
+			v.add(join(path, "name.name_identifiers"), "at least one name identifier must have the LEGL name identifier type")
+		}
+
+		for i, name := range p.Name.LocalNameIdentifiers {
+			v.naturalName(fmt.Sprintf("%s.name.local_name_identifiers[%d]", path, i), name.PrimaryIdentifier, name.SecondaryIdentifier, name.NameIdentifierType)
+		}
+		for i, name := range p.Name.PhoneticNameIdentifiers {
+			v.naturalName(fmt.Sprintf("%s.name.phonetic_name_identifiers[%d]", path, i), name.PrimaryIdentifier, name.SecondaryIdentifier, name.NameIdentifierType)
+		}
+	}
+
+	for i, addr := range p.GeographicAddresses {
+		v.address(fmt.Sprintf("%s.geographic_addresses[%d]", path, i), addr)
+	}
+
+	if p.NationalIdentification != nil {
+		v.nationalIdentification(join(path, "national_identification"), p.NationalIdentification)
+	}
+
+	v.maxText(join(path, "customer_identification"), p.CustomerIdentification, 50)
+
+	if p.DateAndPlaceOfBirth != nil {
+		v.dateAndPlaceOfBirth(join(path, "date_and_place_of_birth"), p.DateAndPlaceOfBirth)
+	}
+
+	v.country(join(path, "country_of_residence"), p.CountryOfResidence)
+}
+
+func (v *validator) naturalName(path, primary, secondary string, typeCode ivms101.NaturalPersonNameTypeCode) {
+	if primary == "" {
+		v.add(join(path, "primary_identifier"), "a primary identifier is required")
+	}
+	v.maxText(join(path, "primary_identifier"), primary, 100)
+	v.maxText(join(path, "secondary_identifier"), secondary, 100)
+	if _, ok := ivms101.NaturalPersonNameTypeCode_name[int32(typeCode)]; !ok {
+		v.add(join(path, "name_identifier_type"), "invalid natural person name type code %d", typeCode)
+	}
+}
+
+func (v *validator) legalPerson(path string, p *ivms101.LegalPerson) {
+	if p.Name == nil || len(p.Name.NameIdentifiers) == 0 {
+		v.add(join(path, "name.name_identifiers"), "at least one name identifier is required")
+	} else {
+		var legal bool
+		for i, name := range p.Name.NameIdentifiers {
+			v.legalName(fmt.Sprintf("%s.name.name_identifiers[%d]", path, i), name.LegalPersonName, name.LegalPersonNameIdentifierType)
+			legal = legal || name.LegalPersonNameIdentifierType == ivms101.LegalPersonLegal
+		}
+
+		// Constraint: LegalNamePresentLegalPerson
+		if !legal {
+			v.add(join(path, "name.name_identifiers"), "at least one name identifier must have the LEGL name identifier type")
+		}
+
+		for i, name := range p.Name.LocalNameIdentifiers {
+			v.legalName(fmt.Sprintf("%s.name.local_name_identifiers[%d]", path, i), name.LegalPersonName, name.LegalPersonNameIdentifierType)
+		}
+		for i, name := range p.Name.PhoneticNameIdentifiers {
+			v.legalName(fmt.Sprintf("%s.name.phonetic_name_identifiers[%d]", path, i), name.LegalPersonName, name.LegalPersonNameIdentifierType)
+		}
+	}
+
+	for i, addr := range p.GeographicAddresses {
+		v.address(fmt.Sprintf("%s.geographic_addresses[%d]", path, i), addr)
+	}
+
+	v.maxText(join(path, "customer_number"), p.CustomerNumber, 50)
+
+	if id := p.NationalIdentification; id != nil {
+		idPath := join(path, "national_identification")
+		v.nationalIdentification(idPath, id)
+
+		// Constraint: ValidNationalIdentifierLegalPerson
+		switch id.NationalIdentifierType {
+		case ivms101.NationalIdentifierRAID, ivms101.NationalIdentifierMISC, ivms101.NationalIdentifierLEIX, ivms101.NationalIdentifierTXID:
+		default:
+			v.add(join(idPath, "national_identifier_type"), "a legal person must have a national identifier of type RAID, MISC, LEIX, or TXID")
+		}
+
+		// Constraint: CompleteNationalIdentifierLegalPerson
+		if id.CountryOfIssue != "" {
+			v.add(join(idPath, "country_of_issue"), "a legal person must not have a country of issue")
+		}
+		if id.NationalIdentifierType != ivms101.NationalIdentifierLEIX && id.RegistrationAuthority == "" {
+			v.add(join(idPath, "registration_authority"), "a registration authority is required unless the national identifier type is LEIX")
+		}
+	}
+
+	v.country(join(path, "country_of_registration"), p.CountryOfRegistration)
+}
+
+func (v *validator) legalName(path, name string, typeCode ivms101.LegalPersonNameTypeCode) {
+	if name == "" {
+		v.add(join(path, "legal_person_name"), "a legal person name is required")
+	}
+	v.maxText(join(path, "legal_person_name"), name, 100)
+	if _, ok := ivms101.LegalPersonNameTypeCode_name[int32(typeCode)]; !ok {
+		v.add(join(path, "legal_person_name_identifier_type"), "invalid legal person name type code %d", typeCode)
+	}
+}
+
+func (v *validator) address(path string, a *ivms101.Address) {
+	if _, ok := ivms101.AddressTypeCode_name[int32(a.AddressType)]; !ok {
+		v.add(join(path, "address_type"), "invalid address type code %d", a.AddressType)
+	}
+
+	limits := []struct {
+		field string
+		value string
+		max   int
+	}{
+		{"department", a.Department, 50},
+		{"sub_department", a.SubDepartment, 70},
+		{"street_name", a.StreetName, 70},
+		{"building_number", a.BuildingNumber, 16},
+		{"building_name", a.BuildingName, 35},
+		{"floor", a.Floor, 70},
+		{"post_box", a.PostBox, 16},
+		{"room", a.Room, 70},
+		{"post_code", a.PostCode, 16},
+		{"town_name", a.TownName, 35},
+		{"town_location_name", a.TownLocationName, 35},
+		{"district_name", a.DistrictName, 35},
+		{"country_sub_division", a.CountrySubDivision, 35},
+	}
+	for _, limit := range limits {
+		v.maxText(join(path, limit.field), limit.value, limit.max)
+	}
+
+	if len(a.AddressLine) > 7 {
+		v.add(join(path, "address_line"), "an address can contain at most 7 address lines")
+	}
+	for i, line := range a.AddressLine {
+		v.maxText(fmt.Sprintf("%s.address_line[%d]", path, i), line, 70)
+	}
+
+	// Constraint: ValidAddress
+	if len(a.AddressLine) == 0 && (a.StreetName == "" || (a.BuildingName == "" && a.BuildingNumber == "")) {
+		v.add(path, "an address requires an address line or a street name with a building name or number")
+	}
+
+	if a.Country == "" {
+		v.add(join(path, "country"), "a country is required")
+	}
+	v.country(join(path, "country"), a.Country)
+}
+
+// GLEIF registration authority codes, e.g. RA000589.
+var registrationAuthority = regexp.MustCompile(`^RA\d{6}$`)
+
+// ISO 17442 legal entity identifiers: 18 alphanumeric characters and 2 check digits.
+var lei = regexp.MustCompile(`^[A-Z0-9]{18}\d{2}$`)
+
+func (v *validator) nationalIdentification(path string, id *ivms101.NationalIdentification) {
+	if id.NationalIdentifier == "" {
+		v.add(join(path, "national_identifier"), "a national identifier is required")
+	}
+	v.maxText(join(path, "national_identifier"), id.NationalIdentifier, 35)
+
+	if _, ok := ivms101.NationalIdentifierTypeCode_name[int32(id.NationalIdentifierType)]; !ok {
+		v.add(join(path, "national_identifier_type"), "invalid national identifier type code %d", id.NationalIdentifierType)
+	}
+
+	// Constraint: ValidLEI
+	if id.NationalIdentifierType == ivms101.NationalIdentifierLEIX && id.NationalIdentifier != "" && !validLEI(id.NationalIdentifier) {
+		v.add(join(path, "national_identifier"), "%q is not a valid ISO 17442 legal entity identifier", id.NationalIdentifier)
+	}
+
+	// Constraint: RegistrationAuthority
+	if id.RegistrationAuthority != "" {
+		if id.NationalIdentifierType == ivms101.NationalIdentifierLEIX {
+			v.add(join(path, "registration_authority"), "a registration authority must not be set if the national identifier type is LEIX")
+		} else if !registrationAuthority.MatchString(id.RegistrationAuthority) {
+			v.add(join(path, "registration_authority"), "%q is not a GLEIF registration authority code", id.RegistrationAuthority)
+		}
+	}
+
+	v.country(join(path, "country_of_issue"), id.CountryOfIssue)
+}
+
+func (v *validator) dateAndPlaceOfBirth(path string, d *ivms101.DateAndPlaceOfBirth) {
+	if date, err := time.Parse("2006-01-02", d.DateOfBirth); err != nil {
+		v.add(join(path, "date_of_birth"), "a date of birth in YYYY-MM-DD format is required")
+	} else if date.After(time.Now()) {
+		// Constraint: DateInPast
+		v.add(join(path, "date_of_birth"), "the date of birth must be in the past")
+	}
+
+	if d.PlaceOfBirth == "" {
+		v.add(join(path, "place_of_birth"), "a place of birth is required")
+	}
+	v.maxText(join(path, "place_of_birth"), d.PlaceOfBirth, 70)
+}
+
+// validLEI checks the format and the ISO 7064 MOD 97-10 check digits of the LEI.
+func validLEI(id string) bool {
+	if !lei.MatchString(id) {
+		return false
+	}
+
+	digits := &strings.Builder{}
+	for _, c := range id {
+		if c >= 'A' && c <= 'Z' {
+			fmt.Fprintf(digits, "%d", c-'A'+10)
+		} else {
+			digits.WriteRune(c)
+		}
+	}
+
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+func join(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
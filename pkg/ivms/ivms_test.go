@@ -0,0 +1,184 @@
+package ivms
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+)
+
+func testIdentity() *ivms101.IdentityPayload {
+	return &ivms101.IdentityPayload{
+		Originator: &ivms101.Originator{
+			OriginatorPersons: []*ivms101.Person{{
+				Person: &ivms101.Person_NaturalPerson{NaturalPerson: &ivms101.NaturalPerson{
+					Name: &ivms101.NaturalPersonName{
+						NameIdentifiers: []*ivms101.NaturalPersonNameId{{
+							PrimaryIdentifier:   "Doe",
+							SecondaryIdentifier: "Jane",
+							NameIdentifierType:  ivms101.NaturalPersonLegal,
+						}},
+					},
+					GeographicAddresses: []*ivms101.Address{{
+						AddressType: ivms101.AddressTypeHome,
+						AddressLine: []string{"1 Main Street", "Springfield"},
+						Country:     "US",
+					}},
+					CountryOfResidence: "US",
+				}},
+			}},
+			AccountNumbers: []string{"1AbC2dEf3GhI"},
+		},
+		OriginatingVasp: &ivms101.OriginatingVasp{
+			OriginatingVasp: &ivms101.Person{
+				Person: &ivms101.Person_LegalPerson{LegalPerson: &ivms101.LegalPerson{
+					Name: &ivms101.LegalPersonName{
+						NameIdentifiers: []*ivms101.LegalPersonNameId{{
+							LegalPersonName:               "Alice VASP",
+							LegalPersonNameIdentifierType: ivms101.LegalPersonLegal,
+						}},
+					},
+					NationalIdentification: &ivms101.NationalIdentification{
+						NationalIdentifier:     "5493001KJTIIGC8Y1R12",
+						NationalIdentifierType: ivms101.NationalIdentifierLEIX,
+					},
+					CountryOfRegistration: "US",
+				}},
+			},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	natural := func(identity *ivms101.IdentityPayload) *ivms101.NaturalPerson {
+		return identity.Originator.OriginatorPersons[0].GetNaturalPerson()
+	}
+	legal := func(identity *ivms101.IdentityPayload) *ivms101.LegalPerson {
+		return identity.OriginatingVasp.OriginatingVasp.GetLegalPerson()
+	}
+
+	const (
+		person = "originator.originator_persons[0].natural_person"
+		vasp   = "originating_vasp.originating_vasp.legal_person"
+	)
+
+	tests := []struct {
+		name   string
+		modify func(*ivms101.IdentityPayload)
+		fields []string
+	}{
+		{"valid", func(*ivms101.IdentityPayload) {}, nil},
+		{"no originator", func(i *ivms101.IdentityPayload) { i.Originator = nil }, []string{"originator.originator_persons"}},
+		{"no legal name", func(i *ivms101.IdentityPayload) {
+			natural(i).Name.NameIdentifiers[0].NameIdentifierType = ivms101.NaturalPersonAlias
+		}, []string{person + ".name.name_identifiers"}},
+		{"no primary identifier", func(i *ivms101.IdentityPayload) {
+			natural(i).Name.NameIdentifiers[0].PrimaryIdentifier = ""
+		}, []string{person + ".name.name_identifiers[0].primary_identifier"}},
+		{"primary identifier too long", func(i *ivms101.IdentityPayload) {
+			natural(i).Name.NameIdentifiers[0].PrimaryIdentifier = strings.Repeat("x", 101)
+		}, []string{person + ".name.name_identifiers[0].primary_identifier"}},
+		{"no originator information", func(i *ivms101.IdentityPayload) {
+			natural(i).GeographicAddresses = nil
+		}, []string{person}},
+		{"unknown country", func(i *ivms101.IdentityPayload) {
+			natural(i).CountryOfResidence = "ZZ"
+		}, []string{person + ".country_of_residence"}},
+		{"lowercase country", func(i *ivms101.IdentityPayload) {
+			natural(i).CountryOfResidence = "us"
+		}, nil},
+		{"unknown country of the address", func(i *ivms101.IdentityPayload) {
+			natural(i).CountryOfResidence = "XX"
+			natural(i).GeographicAddresses[0].Country = "ZZ"
+		}, []string{person + ".geographic_addresses[0].country"}},
+		{"incomplete address", func(i *ivms101.IdentityPayload) {
+			addr := natural(i).GeographicAddresses[0]
+			addr.AddressLine = nil
+			addr.StreetName = "Main Street"
+			addr.Country = ""
+		}, []string{person + ".geographic_addresses[0]", person + ".geographic_addresses[0].country"}},
+		{"too many address lines", func(i *ivms101.IdentityPayload) {
+			natural(i).GeographicAddresses[0].AddressLine = make([]string, 8)
+		}, []string{person + ".geographic_addresses[0].address_line"}},
+		{"date of birth in the future", func(i *ivms101.IdentityPayload) {
+			natural(i).DateAndPlaceOfBirth = &ivms101.DateAndPlaceOfBirth{DateOfBirth: "2999-01-01", PlaceOfBirth: "Springfield"}
+		}, []string{person + ".date_and_place_of_birth.date_of_birth"}},
+		{"malformed date of birth", func(i *ivms101.IdentityPayload) {
+			natural(i).DateAndPlaceOfBirth = &ivms101.DateAndPlaceOfBirth{DateOfBirth: "01/01/1980"}
+		}, []string{person + ".date_and_place_of_birth.date_of_birth", person + ".date_and_place_of_birth.place_of_birth"}},
+		{"invalid lei checksum", func(i *ivms101.IdentityPayload) {
+			legal(i).NationalIdentification.NationalIdentifier = "5493001KJTIIGC8Y1R13"
+		}, []string{vasp + ".national_identification.national_identifier"}},
+		{"lei with registration authority", func(i *ivms101.IdentityPayload) {
+			legal(i).NationalIdentification.RegistrationAuthority = "RA000589"
+		}, []string{vasp + ".national_identification.registration_authority"}},
+		{"legal person with passport", func(i *ivms101.IdentityPayload) {
+			id := legal(i).NationalIdentification
+			id.NationalIdentifierType = ivms101.NationalIdentifierCCPT
+			id.RegistrationAuthority = "RA000589"
+		}, []string{vasp + ".national_identification.national_identifier_type"}},
+		{"invalid registration authority", func(i *ivms101.IdentityPayload) {
+			id := legal(i).NationalIdentification
+			id.NationalIdentifierType = ivms101.NationalIdentifierRAID
+			id.RegistrationAuthority = "GLEIF"
+		}, []string{vasp + ".national_identification.registration_authority"}},
+		{"duplicate transfer path sequence", func(i *ivms101.IdentityPayload) {
+			i.TransferPath = &ivms101.TransferPath{TransferPath: []*ivms101.IntermediaryVasp{
+				{IntermediaryVasp: i.OriginatingVasp.OriginatingVasp, Sequence: 1},
+				{IntermediaryVasp: i.OriginatingVasp.OriginatingVasp, Sequence: 1},
+			}}
+		}, []string{"transfer_path.transfer_path[1].sequence"}},
+		{"every violation", func(i *ivms101.IdentityPayload) {
+			natural(i).CountryOfResidence = "ZZ"
+			legal(i).CountryOfRegistration = "ZZ"
+			i.Originator.AccountNumbers = []string{strings.Repeat("x", 101)}
+		}, []string{"originator.account_numbers[0]", person + ".country_of_residence", vasp + ".country_of_registration"}},
+	}
+
+	for _, tc := range tests {
+		identity := testIdentity()
+		tc.modify(identity)
+
+		var fields []string
+		for _, violation := range Validate(identity) {
+			fields = append(fields, violation.Field)
+		}
+		sort.Strings(fields)
+		sort.Strings(tc.fields)
+
+		if strings.Join(fields, ", ") != strings.Join(tc.fields, ", ") {
+			t.Errorf("%s: expected violations of [%s], got [%s]", tc.name, strings.Join(tc.fields, ", "), strings.Join(fields, ", "))
+		}
+	}
+}
+
+func TestFromError(t *testing.T) {
+	identity := testIdentity()
+	identity.Originator.OriginatorPersons[0].GetNaturalPerson().CountryOfResidence = "ZZ"
+	identity.Originator.AccountNumbers = []string{strings.Repeat("x", 101)}
+
+	violations := Validate(identity)
+	reject := violations.Reject()
+	if reject.Code != protocol.UnparseableIdentity {
+		t.Fatalf("expected an unparseable identity error, got %s", reject.Code)
+	}
+
+	decoded, ok := FromError(reject)
+	if !ok {
+		t.Fatal("expected the violations in the error details")
+	}
+	if len(decoded) != len(violations) {
+		t.Fatalf("expected %d violations, got %d", len(violations), len(decoded))
+	}
+	for i := range violations {
+		if decoded[i] != violations[i] {
+			t.Errorf("expected violation %v, got %v", violations[i], decoded[i])
+		}
+	}
+
+	if _, ok = FromError(protocol.Errorf(protocol.UnparseableIdentity, "no details")); ok {
+		t.Error("expected an error without details to have no violations")
+	}
+}
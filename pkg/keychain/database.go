@@ -0,0 +1,169 @@
+package keychain
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"sync"
+
+	"github.com/rotationalio/trisa/pkg/envelope"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+)
+
+// Database keeps the keys in the store of the node: the local keys are generated RSA
+// keys, which are generated on first use and kept with the keys they replaced, and the
+// keys of peers are kept with the peers in the address book. The store should be
+// encrypted since the private keys are kept in it.
+type Database struct {
+	mu    sync.Mutex
+	db    *store.Store
+	bits  int
+	local *LocalKey
+	peers peerKeys
+}
+
+// Ensure Database implements the Keyring interface
+var _ Keyring = &Database{}
+
+// NewDatabase returns a keychain that generates RSA keys with the specified number of
+// bits, loading the keys of the peers in the address book from their last key exchange.
+// Keys that cannot be parsed are skipped so that they are exchanged again.
+func NewDatabase(db *store.Store, bits int) (_ *Database, err error) {
+	var known []*store.Peer
+	if known, err = db.Peers(); err != nil {
+		return nil, err
+	}
+
+	d := &Database{db: db, bits: bits}
+	for _, peer := range known {
+		if len(peer.SigningKey) == 0 {
+			continue
+		}
+
+		var pub interface{}
+		if pub, err = x509.ParsePKIXPublicKey(peer.SigningKey); err != nil || envelope.Algorithm(pub) == "" {
+			log.Warn().Err(err).Str("peer", peer.CommonName).Msg("could not load signing key of peer")
+			continue
+		}
+
+		d.peers.put(peer.CommonName, &PeerKey{
+			Key:       pub,
+			Exchanged: peer.KeyExchanged,
+			NotBefore: peer.KeyNotBefore,
+			NotAfter:  peer.KeyNotAfter,
+		})
+	}
+
+	log.Debug().Int("peers", d.peers.len()).Msg("loaded signing keys of peers")
+	return d, nil
+}
+
+// GetLocalKey returns the newest generated key in the store, generating and storing a
+// new key if the store does not have one yet.
+func (d *Database) GetLocalKey() (_ *LocalKey, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.local != nil {
+		return d.local, nil
+	}
+
+	var stored []*store.ExchangeKey
+	if stored, err = d.db.ExchangeKeys(); err != nil {
+		return nil, fmt.Errorf("could not read exchange keys: %s", err)
+	}
+
+	if len(stored) > 0 {
+		if d.local, err = parseExchangeKey(stored[0]); err != nil {
+			return nil, err
+		}
+		return d.local, nil
+	}
+	return d.rotate()
+}
+
+// GetPeerKey returns the key of the peer or ErrNotFound.
+func (d *Database) GetPeerKey(commonName string) (*PeerKey, error) {
+	return d.peers.get(commonName)
+}
+
+// StorePeerKey replaces or removes the key of the peer in memory and in the address
+// book. The key is used even if it could not be persisted, in which case an error is
+// returned and keys must be exchanged with the peer again after a restart.
+func (d *Database) StorePeerKey(commonName string, key *PeerKey) (err error) {
+	if err = d.peers.put(commonName, key); err != nil {
+		return err
+	}
+
+	if key == nil {
+		return d.db.DeletePeerKey(commonName)
+	}
+
+	var data []byte
+	if data, err = x509.MarshalPKIXPublicKey(key.Key); err != nil {
+		return err
+	}
+	return d.db.PutPeerKey(commonName, data, key.NotBefore, key.NotAfter)
+}
+
+// Rotate generates a new local key and stores it; the previous keys are kept in the
+// store until they are deleted.
+func (d *Database) Rotate() (*LocalKey, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rotate()
+}
+
+func (d *Database) rotate() (local *LocalKey, err error) {
+	if local, err = generate(d.bits); err != nil {
+		return nil, fmt.Errorf("could not generate exchange key: %s", err)
+	}
+
+	key := &store.ExchangeKey{
+		ID:         local.ID,
+		PrivateKey: x509.MarshalPKCS1PrivateKey(local.Key.(*rsa.PrivateKey)),
+		Created:    local.Created,
+	}
+
+	if err = d.db.PutExchangeKey(key); err != nil {
+		return nil, fmt.Errorf("could not store exchange key: %s", err)
+	}
+	d.local = local
+	return local, nil
+}
+
+// PreviousKeys returns the generated keys in the store other than the current key,
+// newest first, including retired keys.
+func (d *Database) PreviousKeys() (keys []*LocalKey, err error) {
+	var current *LocalKey
+	if current, err = d.GetLocalKey(); err != nil {
+		return nil, err
+	}
+
+	var stored []*store.ExchangeKey
+	if stored, err = d.db.ExchangeKeys(); err != nil {
+		return nil, fmt.Errorf("could not read exchange keys: %s", err)
+	}
+
+	for _, key := range stored {
+		if key.ID == current.ID {
+			continue
+		}
+
+		var prev *LocalKey
+		if prev, err = parseExchangeKey(key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, prev)
+	}
+	return keys, nil
+}
+
+// parseExchangeKey parses a generated key from the store.
+func parseExchangeKey(key *store.ExchangeKey) (*LocalKey, error) {
+	priv, err := x509.ParsePKCS1PrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse exchange key %s: %s", key.ID, err)
+	}
+	return &LocalKey{ID: key.ID, Key: priv, Created: key.Created, Retired: key.Retired}, nil
+}
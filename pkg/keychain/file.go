@@ -0,0 +1,247 @@
+package keychain
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/envelope"
+)
+
+// File keeps the keys in PEM files in a directory, e.g. a volume that is managed with
+// the keys of other services. The local key is exchange.pem, which may be any private
+// key that can be loaded as an exchange key and is generated as an RSA key if it does
+// not exist; rotated keys are moved to previous/ and the keys of peers are kept in
+// peers/, named by the common name of the peer. Generated keys and the keys of peers
+// carry their timestamps as PEM headers.
+type File struct {
+	mu    sync.Mutex
+	dir   string
+	bits  int
+	local *LocalKey
+	peers peerKeys
+}
+
+// Ensure File implements the Keyring interface
+var _ Keyring = &File{}
+
+// PEM headers of the timestamps of the keys in the files.
+const (
+	headerCreated   = "Created"
+	headerExchanged = "Exchanged"
+	headerNotBefore = "Not-Before"
+	headerNotAfter  = "Not-After"
+)
+
+// Files and directories of the keychain.
+const (
+	localFile   = "exchange.pem"
+	previousDir = "previous"
+	peersDir    = "peers"
+)
+
+// NewFile returns a keychain in the directory, creating it if it does not exist, which
+// generates RSA keys with the specified number of bits, and loads the keys of peers.
+// Keys that cannot be parsed are skipped so that they are exchanged again.
+func NewFile(dir string, bits int) (_ *File, err error) {
+	for _, path := range []string{dir, filepath.Join(dir, previousDir), filepath.Join(dir, peersDir)} {
+		if err = os.MkdirAll(path, 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	var paths []string
+	if paths, err = filepath.Glob(filepath.Join(dir, peersDir, "*.pem")); err != nil {
+		return nil, err
+	}
+
+	f := &File{dir: dir, bits: bits}
+	for _, path := range paths {
+		var commonName string
+		if commonName, err = url.PathUnescape(strings.TrimSuffix(filepath.Base(path), ".pem")); err != nil {
+			continue
+		}
+
+		var key *PeerKey
+		if key, err = readPeerKey(path); err != nil {
+			continue
+		}
+		f.peers.put(commonName, key)
+	}
+	return f, nil
+}
+
+// GetLocalKey returns the key in exchange.pem, generating it if it does not exist.
+func (f *File) GetLocalKey() (_ *LocalKey, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.local != nil {
+		return f.local, nil
+	}
+
+	if f.local, err = readLocalKey(filepath.Join(f.dir, localFile)); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return f.rotate()
+	}
+	return f.local, nil
+}
+
+// GetPeerKey returns the key of the peer or ErrNotFound.
+func (f *File) GetPeerKey(commonName string) (*PeerKey, error) {
+	return f.peers.get(commonName)
+}
+
+// StorePeerKey replaces or removes the key of the peer in memory and in its file. The
+// key is used even if the file could not be written, in which case an error is returned.
+func (f *File) StorePeerKey(commonName string, key *PeerKey) (err error) {
+	if err = f.peers.put(commonName, key); err != nil {
+		return err
+	}
+
+	path := filepath.Join(f.dir, peersDir, url.PathEscape(commonName)+".pem")
+	if key == nil {
+		if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	block := &pem.Block{Type: "PUBLIC KEY", Headers: make(map[string]string)}
+	if block.Bytes, err = x509.MarshalPKIXPublicKey(key.Key); err != nil {
+		return err
+	}
+
+	setTime(block, headerExchanged, key.Exchanged)
+	setTime(block, headerNotBefore, key.NotBefore)
+	setTime(block, headerNotAfter, key.NotAfter)
+	return writeFile(path, block)
+}
+
+// Rotate generates a new local key and moves the current key to previous/.
+func (f *File) Rotate() (*LocalKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rotate()
+}
+
+func (f *File) rotate() (local *LocalKey, err error) {
+	if local, err = generate(f.bits); err != nil {
+		return nil, fmt.Errorf("could not generate exchange key: %s", err)
+	}
+
+	block := &pem.Block{Type: "PRIVATE KEY", Headers: make(map[string]string)}
+	if block.Bytes, err = x509.MarshalPKCS8PrivateKey(local.Key); err != nil {
+		return nil, err
+	}
+	setTime(block, headerCreated, local.Created)
+
+	path := filepath.Join(f.dir, localFile)
+	if f.local != nil {
+		if err = os.Rename(path, filepath.Join(f.dir, previousDir, f.local.ID+".pem")); err != nil {
+			return nil, fmt.Errorf("could not keep previous exchange key: %s", err)
+		}
+	}
+
+	if err = writeFile(path, block); err != nil {
+		return nil, fmt.Errorf("could not store exchange key: %s", err)
+	}
+	f.local = local
+	return local, nil
+}
+
+// PreviousKeys returns the keys in previous/, newest first.
+func (f *File) PreviousKeys() (keys []*LocalKey, err error) {
+	var paths []string
+	if paths, err = filepath.Glob(filepath.Join(f.dir, previousDir, "*.pem")); err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		var key *LocalKey
+		if key, err = readLocalKey(path); err != nil {
+			return nil, fmt.Errorf("could not read previous exchange key %s: %s", filepath.Base(path), err)
+		}
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.After(keys[j].Created) })
+	return keys, nil
+}
+
+// readLocalKey reads a private key and the time it was created from a PEM file.
+func readLocalKey(path string) (_ *LocalKey, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	if key, err = ParsePrivateKey(data); err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	return NewLocalKey(key, getTime(block, headerCreated)), nil
+}
+
+// readPeerKey reads a public key and its timestamps from a PEM file.
+func readPeerKey(path string) (_ *PeerKey, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("signing key of peer must be a PEM encoded public key")
+	}
+
+	key := &PeerKey{
+		Exchanged: getTime(block, headerExchanged),
+		NotBefore: getTime(block, headerNotBefore),
+		NotAfter:  getTime(block, headerNotAfter),
+	}
+
+	if key.Key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return nil, err
+	}
+
+	if envelope.Algorithm(key.Key) == "" {
+		return nil, fmt.Errorf("unsupported public key type %T", key.Key)
+	}
+	return key, nil
+}
+
+// writeFile writes the PEM block to a temporary file that replaces the file, so that
+// the file is never partially written.
+func writeFile(path string, block *pem.Block) (err error) {
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, pem.EncodeToMemory(block), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func setTime(block *pem.Block, header string, ts time.Time) {
+	if !ts.IsZero() {
+		block.Headers[header] = ts.UTC().Format(time.RFC3339)
+	}
+}
+
+func getTime(block *pem.Block, header string) (ts time.Time) {
+	if block != nil {
+		ts, _ = time.Parse(time.RFC3339, block.Headers[header])
+	}
+	return ts
+}
@@ -0,0 +1,194 @@
+/*
+Package keychain keeps the keys that secure envelopes are sealed and opened with: the
+local key of the node, whose public key is advertised to peers in key exchanges and
+which opens the envelopes that peers seal for the node, and the public keys that peers
+sent in key exchanges, which envelopes for the peers are sealed with.
+
+Keychains are pluggable so that the keys can be kept where the deployment requires:
+
+	Memory     keeps the keys in memory only, e.g. for tests or ephemeral nodes
+	File       keeps the keys in PEM files in a directory
+	Database   keeps the keys in the store of the node, generating the local key
+	KMS        opens the local key in an HSM or a cloud KMS and delegates the peer keys
+
+Keys are interface{} values like the keys of the envelope package: RSA and ECDSA keys,
+or the keys of the hsm package. Keychains are safe for concurrent use.
+*/
+package keychain
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/envelope"
+	"github.com/rotationalio/trisa/pkg/hsm"
+	"github.com/rotationalio/trisa/pkg/secrets"
+)
+
+// DefaultKeySize is the size in bits of the RSA keys that are generated by keychains
+// that are not configured with a key size.
+const DefaultKeySize = 4096
+
+var (
+	// ErrNoLocalKey is returned if the keychain does not have a local key.
+	ErrNoLocalKey = errors.New("keychain does not have a local key")
+
+	// ErrNotFound is returned if keys have not been exchanged with the peer.
+	ErrNotFound = errors.New("signing key of peer not found")
+
+	// ErrNotRotatable is returned if the local key is not generated by the keychain and
+	// therefore cannot be rotated.
+	ErrNotRotatable = errors.New("only generated exchange keys can be rotated")
+)
+
+// KeyChain keeps the local key of the node and the keys of peers.
+type KeyChain interface {
+	// GetLocalKey returns the current local key, which is advertised to peers.
+	GetLocalKey() (*LocalKey, error)
+
+	// GetPeerKey returns the key the peer sent in its last key exchange, or ErrNotFound.
+	GetPeerKey(commonName string) (*PeerKey, error)
+
+	// StorePeerKey replaces the key of the peer, or removes it if the key is nil, e.g.
+	// when the peer has rotated its key so that keys must be exchanged again.
+	StorePeerKey(commonName string, key *PeerKey) error
+
+	// Rotate replaces the local key with a newly generated key and returns it. The
+	// previous key is not kept by the keychain unless it implements Keyring, so the
+	// caller must keep it to open envelopes that were sealed before the rotation.
+	Rotate() (*LocalKey, error)
+}
+
+// Keyring is implemented by keychains that keep the local keys they rotated out, so
+// that envelopes sealed with previous keys can be opened after a restart.
+type Keyring interface {
+	KeyChain
+
+	// PreviousKeys returns the previous local keys, newest first.
+	PreviousKeys() ([]*LocalKey, error)
+}
+
+// LocalKey is a private key of the node, identified by the fingerprint of its public
+// key. Created is when the key was generated, which is zero for keys that were not
+// generated by the keychain. Retired is when a previous key stopped opening the
+// envelopes of transfers; retired keys only open archived envelopes.
+type LocalKey struct {
+	ID      string
+	Key     interface{}
+	Created time.Time
+	Retired time.Time
+}
+
+// NewLocalKey returns the local key with the fingerprint of its public key.
+func NewLocalKey(key interface{}, created time.Time) *LocalKey {
+	return &LocalKey{ID: KeyID(envelope.PublicKey(key)), Key: key, Created: created}
+}
+
+// PeerKey is a public key that a peer sent in a key exchange, with the validity period
+// the peer advertised, where zero times are not bounded.
+type PeerKey struct {
+	Key       interface{}
+	Exchanged time.Time
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// Load loads a PEM encoded private key from a file or a secret location, or opens the
+// key in an HSM or KMS if the location is a PKCS #11 URI or KMS key.
+func Load(ctx context.Context, location string) (key interface{}, err error) {
+	if hsm.IsURI(location) {
+		return hsm.Open(ctx, location)
+	}
+
+	var data []byte
+	if data, err = secrets.Load(ctx, location); err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(data)
+}
+
+// ParsePrivateKey parses a PEM encoded RSA or ECDSA private key in PKCS #8 form or in
+// the PKCS #1 or SEC 1 form of RSA and ECDSA keys.
+func ParsePrivateKey(data []byte) (key interface{}, err error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("exchange key must be PEM encoded")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q in exchange key", block.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if envelope.Algorithm(key) == "" {
+		return nil, fmt.Errorf("unsupported exchange key type %T", key)
+	}
+	return key, nil
+}
+
+// KeyID is the truncated SHA-256 fingerprint of the public key, which is used to
+// identify the key in logs without revealing it.
+func KeyID(pub interface{}) string {
+	data, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return ""
+	}
+	return Fingerprint(data)
+}
+
+// Fingerprint is the truncated SHA-256 fingerprint of a PKIX DER encoded public key.
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
+
+// generate generates a new RSA local key with the specified number of bits.
+func generate(bits int) (_ *LocalKey, err error) {
+	if bits <= 0 {
+		bits = DefaultKeySize
+	}
+
+	var key *rsa.PrivateKey
+	if key, err = rsa.GenerateKey(rand.Reader, bits); err != nil {
+		return nil, err
+	}
+	return NewLocalKey(key, time.Now().UTC()), nil
+}
+
+// WithLocalKey returns a keychain with a fixed local key that cannot be rotated, e.g. a
+// key loaded from the config, which keeps the keys of peers in the peers keychain.
+func WithLocalKey(peers KeyChain, key interface{}) KeyChain {
+	return &fixed{KeyChain: peers, local: NewLocalKey(key, time.Time{})}
+}
+
+// fixed overrides the local key of the keychain it embeds.
+type fixed struct {
+	KeyChain
+	local *LocalKey
+}
+
+func (f *fixed) GetLocalKey() (*LocalKey, error) {
+	return f.local, nil
+}
+
+func (f *fixed) Rotate() (*LocalKey, error) {
+	return nil, ErrNotRotatable
+}
@@ -0,0 +1,147 @@
+package keychain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/store"
+)
+
+const testKeySize = 2048
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	chain, err := NewFile(dir, testKeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, peer := testKeyring(t, chain)
+
+	// The keys are loaded from the files when the keychain is reopened
+	if chain, err = NewFile(dir, testKeySize); err != nil {
+		t.Fatal(err)
+	}
+	testReopened(t, chain, current, peer)
+}
+
+func TestDatabase(t *testing.T) {
+	conf := config.StorageConfig{Path: filepath.Join(t.TempDir(), "db")}
+	db, err := store.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SeenPeer("peer.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err := NewDatabase(db, testKeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, peer := testKeyring(t, chain)
+	db.Close()
+
+	// The keys are loaded from the store when the keychain is reopened
+	if db, err = store.Open(conf); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if chain, err = NewDatabase(db, testKeySize); err != nil {
+		t.Fatal(err)
+	}
+	testReopened(t, chain, current, peer)
+}
+
+func TestMemoryRotate(t *testing.T) {
+	chain := NewMemory(nil)
+	if _, err := chain.GetLocalKey(); err != ErrNoLocalKey {
+		t.Fatalf("expected no local key, got %v", err)
+	}
+
+	local, err := chain.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, err := chain.GetLocalKey(); err != nil || current.ID != local.ID {
+		t.Fatalf("rotated key is not the local key: %v", err)
+	}
+}
+
+// testKeyring generates and rotates the local key of the keyring and stores the key of
+// a peer, returning the current local key and the key of the peer.
+func testKeyring(t *testing.T, chain Keyring) (*LocalKey, *PeerKey) {
+	first, err := chain.GetLocalKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := chain.GetLocalKey(); again.ID != first.ID {
+		t.Fatal("a new local key was generated on every call")
+	}
+
+	current, err := chain.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.ID == first.ID {
+		t.Fatal("rotation did not generate a new key")
+	}
+
+	prev, err := chain.PreviousKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prev) != 1 || prev[0].ID != first.ID {
+		t.Fatalf("expected the rotated key to be the previous key, got %d keys", len(prev))
+	}
+
+	if _, err = chain.GetPeerKey("peer.example.com"); err != ErrNotFound {
+		t.Fatalf("expected no key for the peer, got %v", err)
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &PeerKey{Key: &priv.PublicKey, Exchanged: time.Now().Truncate(time.Second), NotAfter: time.Now().Add(time.Hour).Truncate(time.Second)}
+	if err = chain.StorePeerKey("peer.example.com", peer); err != nil {
+		t.Fatal(err)
+	}
+	return current, peer
+}
+
+// testReopened checks that the reopened keyring has the keys of testKeyring, and that
+// the key of the peer can be removed.
+func testReopened(t *testing.T, chain Keyring, current *LocalKey, peer *PeerKey) {
+	local, err := chain.GetLocalKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local.ID != current.ID {
+		t.Errorf("expected local key %s, got %s", current.ID, local.ID)
+	}
+
+	if prev, err := chain.PreviousKeys(); err != nil || len(prev) != 1 {
+		t.Errorf("expected one previous key, got %d (%v)", len(prev), err)
+	}
+
+	stored, err := chain.GetPeerKey("peer.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyID(stored.Key) != KeyID(peer.Key) || !stored.NotAfter.Equal(peer.NotAfter) {
+		t.Errorf("the key of the peer was not restored: %+v", stored)
+	}
+
+	if err = chain.StorePeerKey("peer.example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = chain.GetPeerKey("peer.example.com"); err != ErrNotFound {
+		t.Errorf("expected the key of the peer to be removed, got %v", err)
+	}
+}
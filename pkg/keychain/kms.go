@@ -0,0 +1,42 @@
+package keychain
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/hsm"
+)
+
+// KMS is a keychain whose local key is kept in an HSM or a cloud KMS and never leaves
+// it, so that the key cannot be rotated by the node; keys are rotated in the KMS and
+// loaded from the new location after a restart. KMS keys cannot keep the public keys of
+// peers, so the keys of peers are kept in the peers keychain.
+type KMS struct {
+	KeyChain
+	local *LocalKey
+}
+
+// NewKMS opens the key at the PKCS #11 URI or KMS key location and keeps the keys of
+// peers in the peers keychain.
+func NewKMS(ctx context.Context, location string, peers KeyChain) (_ *KMS, err error) {
+	if !hsm.IsURI(location) {
+		return nil, fmt.Errorf("%q is not a PKCS #11 URI or KMS key", location)
+	}
+
+	var key hsm.PrivateKey
+	if key, err = hsm.Open(ctx, location); err != nil {
+		return nil, err
+	}
+	return &KMS{KeyChain: peers, local: NewLocalKey(key, time.Time{})}, nil
+}
+
+// GetLocalKey returns the key in the KMS.
+func (k *KMS) GetLocalKey() (*LocalKey, error) {
+	return k.local, nil
+}
+
+// Rotate returns ErrNotRotatable since KMS keys are rotated in the KMS.
+func (k *KMS) Rotate() (*LocalKey, error) {
+	return nil, ErrNotRotatable
+}
@@ -0,0 +1,117 @@
+package keychain
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/envelope"
+)
+
+// Memory keeps the local key and the keys of peers in memory, so keys must be exchanged
+// again after a restart. A Memory keychain without a local key generates one when it is
+// rotated.
+type Memory struct {
+	mu    sync.RWMutex
+	local *LocalKey
+	peers peerKeys
+}
+
+// Ensure Memory implements the KeyChain interface
+var _ KeyChain = &Memory{}
+
+// NewMemory returns a keychain with the local key, which may be nil if the keychain only
+// keeps the keys of peers.
+func NewMemory(local interface{}) *Memory {
+	m := &Memory{}
+	if local != nil {
+		m.local = NewLocalKey(local, time.Time{})
+	}
+	return m
+}
+
+// GetLocalKey returns the local key or ErrNoLocalKey.
+func (m *Memory) GetLocalKey() (*LocalKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.local == nil {
+		return nil, ErrNoLocalKey
+	}
+	return m.local, nil
+}
+
+// GetPeerKey returns the key of the peer or ErrNotFound.
+func (m *Memory) GetPeerKey(commonName string) (*PeerKey, error) {
+	return m.peers.get(commonName)
+}
+
+// StorePeerKey replaces or removes the key of the peer.
+func (m *Memory) StorePeerKey(commonName string, key *PeerKey) error {
+	return m.peers.put(commonName, key)
+}
+
+// Rotate generates an RSA key with the size of the current RSA key, or DefaultKeySize
+// bits, to replace the local key.
+func (m *Memory) Rotate() (_ *LocalKey, err error) {
+	m.mu.RLock()
+	bits := DefaultKeySize
+	if m.local != nil {
+		if key, ok := m.local.Key.(*rsa.PrivateKey); ok {
+			bits = key.N.BitLen()
+		}
+	}
+	m.mu.RUnlock()
+
+	var local *LocalKey
+	if local, err = generate(bits); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.local = local
+	m.mu.Unlock()
+	return local, nil
+}
+
+// peerKeys is the in-memory cache of the keys of peers that every keychain keeps, so
+// that keys are not read from disk every time an envelope is sealed.
+type peerKeys struct {
+	mu   sync.RWMutex
+	keys map[string]*PeerKey
+}
+
+func (p *peerKeys) get(commonName string) (*PeerKey, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if key, ok := p.keys[commonName]; ok {
+		return key, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (p *peerKeys) put(commonName string, key *PeerKey) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key == nil {
+		delete(p.keys, commonName)
+		return nil
+	}
+
+	if envelope.Algorithm(key.Key) == "" {
+		return fmt.Errorf("unsupported public key type %T", key.Key)
+	}
+
+	if p.keys == nil {
+		p.keys = make(map[string]*PeerKey)
+	}
+	p.keys[commonName] = key
+	return nil
+}
+
+// len returns the number of peers with keys.
+func (p *peerKeys) len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.keys)
+}
@@ -0,0 +1,230 @@
+package trisarl
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/client"
+	"github.com/rotationalio/trisa/pkg/envelope"
+	"github.com/rotationalio/trisa/pkg/eventbus"
+	"github.com/rotationalio/trisa/pkg/keychain"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/mtls"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+	"google.golang.org/grpc"
+)
+
+// KeyExchangeTimeout is the maximum amount of time to wait for a remote key exchange.
+const KeyExchangeTimeout = 30 * time.Second
+
+// keyClockSkew is the tolerance for the clocks of peers when checking the validity
+// period of their keys.
+const keyClockSkew = 5 * time.Minute
+
+// exchangeKeys ensures the signing key of the remote peer is available, performing a
+// key exchange if the key is not cached, has expired or expires within the renewal
+// window, or if force is true. A key that expires soon is still returned if it cannot
+// be renewed, but an expired key is never returned; if it cannot be renewed either, a
+// retryable NO_SIGNING_KEY error is returned so that the transfer is retried later.
+func (s *Server) exchangeKeys(ctx context.Context, peer *peers.Peer, force bool) (key interface{}, err error) {
+	if !force {
+		if key = s.peerKey(peer); key != nil && !s.peerKeyExpiring(peer) {
+			return key, nil
+		}
+	}
+
+	var pub interface{}
+	if pub, err = s.keyExchange(ctx, peer); err != nil {
+		if key != nil {
+			log.Warn().Err(err).Str("peer", peer.String()).Msg("could not renew signing key of peer before it expires")
+			return key, nil
+		}
+
+		if _, ok := retryable(err); !ok && s.peerKeyExpired(peer) {
+			return nil, &protocol.Error{
+				Code:    protocol.NoSigningKey,
+				Message: fmt.Sprintf("signing key of peer has expired and could not be renewed: %s", err),
+				Retry:   true,
+			}
+		}
+		return nil, err
+	}
+	return pub, nil
+}
+
+// keyExchange performs a key exchange with the peer and caches the key of the peer.
+// The key exchange is performed directly rather than with the peers package, which
+// always sends the mTLS certificate, does not accept dial options, and only accepts RSA
+// keys from peers.
+func (s *Server) keyExchange(ctx context.Context, peer *peers.Peer) (pub interface{}, err error) {
+	s.metrics.keyExchanges.WithLabelValues(peer.String(), "outgoing").Inc()
+	defer func() {
+		if err == nil {
+			s.emit(&eventbus.Event{
+				Type: eventbus.KeyExchanged,
+				Node: s.commonName(),
+				Peer: peer.String(),
+				Data: map[string]string{"direction": "outgoing"},
+			})
+		}
+	}()
+
+	endpoint := peer.Info().Endpoint
+	if endpoint == "" {
+		return nil, errors.New("peer does not have an endpoint to connect to")
+	}
+
+	var req *protocol.SigningKey
+	if req, err = s.advertisedKey(ctx); err != nil {
+		return nil, err
+	}
+
+	certs, pool := s.certificates()
+	var creds grpc.DialOption
+	if creds, err = mtls.ClientCreds(endpoint, certs, pool); err != nil {
+		return nil, err
+	}
+
+	var cc *grpc.ClientConn
+	if cc, err = grpc.Dial(endpoint, append(dialOptions(s.config().GRPC), creds)...); err != nil {
+		return nil, err
+	}
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, KeyExchangeTimeout)
+	defer cancel()
+
+	var rep *protocol.SigningKey
+	if rep, err = protocol.NewTRISANetworkClient(cc).KeyExchange(ctx, req); err != nil {
+		return nil, err
+	}
+	s.recordAdvertisedKey(peer.String(), req)
+
+	if pub, err = client.ParseSigningKey(rep.Data); err != nil {
+		return nil, err
+	}
+
+	if err = s.acceptKey(rep, pub); err != nil {
+		return nil, err
+	}
+
+	key := advertisedPeerKey(rep, pub)
+	if err = checkPeerKey(key, time.Now()); err != nil {
+		return nil, err
+	}
+
+	s.updatePeerKey(peer, key)
+	return pub, nil
+}
+
+// advertisedPeerKey returns the key of the peer from a key exchange with the validity
+// period that the peer advertised, or the validity period of the certificate if the
+// peer sent the key as a certificate without a validity period. Timestamps that cannot
+// be parsed are ignored since peers are not required to send them.
+func advertisedPeerKey(in *protocol.SigningKey, pub interface{}) (key *keychain.PeerKey) {
+	key = &keychain.PeerKey{Key: pub, Exchanged: time.Now()}
+	if in.NotBefore != "" {
+		key.NotBefore, _ = time.Parse(time.RFC3339, in.NotBefore)
+	}
+	if in.NotAfter != "" {
+		key.NotAfter, _ = time.Parse(time.RFC3339, in.NotAfter)
+	}
+
+	if in.NotBefore == "" && in.NotAfter == "" {
+		data := in.Data
+		if block, _ := pem.Decode(data); block != nil && block.Type == "CERTIFICATE" {
+			data = block.Bytes
+		}
+
+		if cert, err := x509.ParseCertificate(data); err == nil {
+			key.NotBefore, key.NotAfter = cert.NotBefore, cert.NotAfter
+		}
+	}
+	return key
+}
+
+// checkPeerKey returns an error if the key of the peer is not valid at the specified
+// time, allowing for the skew of the clocks of peers.
+func checkPeerKey(key *keychain.PeerKey, now time.Time) error {
+	if !key.NotBefore.IsZero() && now.Add(keyClockSkew).Before(key.NotBefore) {
+		return fmt.Errorf("signing key of peer is not valid before %s", key.NotBefore.Format(time.RFC3339))
+	}
+	if !key.NotAfter.IsZero() && !now.Before(key.NotAfter) {
+		return fmt.Errorf("signing key of peer expired at %s", key.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// acceptKey negotiates the public key algorithm of a key exchange: the key of the peer
+// must match the algorithm the peer advertised and use one of the accepted algorithms,
+// so that envelopes are only sealed with the ECDH key wrap if it was enabled.
+func (s *Server) acceptKey(in *protocol.SigningKey, pub interface{}) (err error) {
+	var algorithm string
+	if algorithm, err = envelope.Negotiate(in.PublicKeyAlgorithm, pub); err != nil {
+		return err
+	}
+
+	if !s.accepts(algorithm) {
+		return fmt.Errorf("%s signing keys are not accepted", algorithm)
+	}
+	return nil
+}
+
+// accepts returns true if the public key algorithm is accepted in key exchanges.
+func (s *Server) accepts(algorithm string) bool {
+	for _, accepted := range strings.Split(s.config().Exchange.Algorithms, ",") {
+		if strings.EqualFold(strings.TrimSpace(accepted), algorithm) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerKey returns the public key of the peer from the last key exchange, or nil if
+// keys have not been exchanged with the peer or if the key is outside of its validity
+// period, so that envelopes are never sealed with an expired key.
+func (s *Server) peerKey(peer *peers.Peer) interface{} {
+	key, err := s.keychain.GetPeerKey(peer.String())
+	if err != nil || checkPeerKey(key, time.Now()) != nil {
+		return nil
+	}
+	return key.Key
+}
+
+// peerKeyExpiring returns true if the key of the peer expires within the renewal
+// window, so keys should be exchanged again before sealing envelopes for the peer.
+func (s *Server) peerKeyExpiring(peer *peers.Peer) bool {
+	key, err := s.keychain.GetPeerKey(peer.String())
+	return err == nil && !key.NotAfter.IsZero() && time.Until(key.NotAfter) < s.config().Exchange.RenewBefore
+}
+
+// peerKeyExpired returns true if the key of the peer in the keychain has expired.
+func (s *Server) peerKeyExpired(peer *peers.Peer) bool {
+	key, err := s.keychain.GetPeerKey(peer.String())
+	return err == nil && !key.NotAfter.IsZero() && !time.Now().Before(key.NotAfter)
+}
+
+// updatePeerKey keeps the key of the peer from a key exchange in the keychain, replacing
+// the previous key of the peer even if it used another algorithm. The key is used even
+// if the keychain could not persist it, in which case keys must be exchanged again
+// after a restart.
+func (s *Server) updatePeerKey(peer *peers.Peer, key *keychain.PeerKey) {
+	if err := s.keychain.StorePeerKey(peer.String(), key); err != nil {
+		log.Warn().Err(err).Str("peer", peer.String()).Msg("could not persist signing key of peer, keys must be exchanged again after a restart")
+	}
+}
+
+// forgetPeerKey removes the key of the peer from the keychain, e.g. a key persisted
+// before the peer rotated it, so that keys are exchanged again before the next envelope
+// is sealed.
+func (s *Server) forgetPeerKey(peer *peers.Peer) {
+	if err := s.keychain.StorePeerKey(peer.String(), nil); err != nil {
+		log.Warn().Err(err).Str("peer", peer.String()).Msg("could not drop signing key of peer")
+	}
+}
@@ -0,0 +1,59 @@
+package trisarl
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+)
+
+// listen on the bind address, which is either a TCP host:port pair or a unix:// socket
+// path. The socket file is created with the configured permissions so that only the
+// local reverse proxy or sidecars that should reach the server can connect, and it is
+// removed when the listener is closed.
+func listen(addr string, mode os.FileMode) (sock net.Listener, err error) {
+	network, address := config.ListenAddr(addr)
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+
+	if err = removeStaleSocket(address); err != nil {
+		return nil, err
+	}
+
+	if sock, err = net.Listen(network, address); err != nil {
+		return nil, err
+	}
+
+	if err = os.Chmod(address, mode); err != nil {
+		sock.Close()
+		return nil, fmt.Errorf("could not set unix socket permissions: %s", err)
+	}
+	return sock, nil
+}
+
+// removeStaleSocket removes a socket file left behind by a server that did not shut
+// down cleanly, refusing to remove files that are not sockets or sockets that are
+// still being listened on by another process.
+func removeStaleSocket(path string) (err error) {
+	var info os.FileInfo
+	if info, err = os.Lstat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a unix socket", path)
+	}
+
+	var conn net.Conn
+	if conn, err = net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("unix socket %s is already in use", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,33 @@
+package trisarl
+
+import "github.com/rs/zerolog/log"
+
+// SetMaintenance enables or disables maintenance mode on the running server, e.g. from
+// an admin API of the application that embeds the server, so that operators can drain
+// traffic for upgrades without a restart. While in maintenance, the Status RPC reports
+// MAINTENANCE and transfers are rejected with a retryable error. The runtime setting
+// is kept when the configuration is reloaded unless the configured setting changes.
+func (s *Server) SetMaintenance(enabled bool) {
+	s.confmu.Lock()
+	s.conf.Maintenance = enabled
+	s.confmu.Unlock()
+	log.Info().Bool("maintenance", enabled).Msg("maintenance mode changed")
+}
+
+// InMaintenance returns true if the server is in maintenance mode, either because it
+// was enabled in the configuration or at runtime, or because the maintenance file
+// exists. Planned maintenance windows are not included.
+func (s *Server) InMaintenance() bool {
+	conf, _ := s.maintenanceState()
+	return conf.Maintenance
+}
+
+// toggleMaintenance switches maintenance mode on or off, e.g. on SIGUSR1. If the
+// maintenance file exists, the server remains in maintenance until it is removed.
+func (s *Server) toggleMaintenance() {
+	enabled := !s.config().Maintenance
+	s.SetMaintenance(enabled)
+	if !enabled && s.InMaintenance() {
+		log.Warn().Str("path", s.config().MaintenanceFile).Msg("maintenance file exists, remove it to leave maintenance")
+	}
+}
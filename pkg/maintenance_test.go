@@ -0,0 +1,58 @@
+package trisarl
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/trisa/internal/maintenance"
+	"github.com/rotationalio/trisa/pkg/config"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+)
+
+func TestMaintenance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maintenance")
+	s := &Server{conf: config.Config{MaintenanceFile: path}}
+	stage := s.maintenance(handled)
+
+	unavailable := func(err error) bool {
+		perr, ok := err.(*protocol.Error)
+		return ok && perr.Code == protocol.Unavailable && perr.Retry
+	}
+
+	if err := stage(context.Background(), &Transfer{}); err != nil || s.InMaintenance() {
+		t.Fatalf("expected transfers to be handled outside of maintenance, got %v", err)
+	}
+
+	s.SetMaintenance(true)
+	if err := stage(context.Background(), &Transfer{}); !unavailable(err) || !s.InMaintenance() {
+		t.Fatalf("expected transfers to be rejected in maintenance, got %v", err)
+	}
+
+	// The server remains in maintenance while the maintenance file exists
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s.toggleMaintenance()
+	if err := stage(context.Background(), &Transfer{}); !unavailable(err) {
+		t.Fatalf("expected transfers to be rejected while the maintenance file exists, got %v", err)
+	}
+
+	os.Remove(path)
+	if s.InMaintenance() {
+		t.Fatal("expected maintenance to end when the maintenance file is removed")
+	}
+
+	// Transfers are rejected during planned maintenance windows
+	now := time.Now().UTC()
+	var err error
+	if s.schedule, err = maintenance.Parse(now.Add(-time.Minute).Format(time.RFC3339) + "/" + now.Add(time.Hour).Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	if err = stage(context.Background(), &Transfer{}); !unavailable(err) {
+		t.Fatalf("expected transfers to be rejected during planned maintenance, got %v", err)
+	}
+}
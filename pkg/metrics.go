@@ -0,0 +1,281 @@
+package trisarl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Prometheus metrics of the TRISA node. Metrics are labeled by the common name of the
+// peer, which is bounded by the number of members of the TRISA network. The metrics
+// are collected in a registry that belongs to the server rather than the global
+// registry so that several servers can be created in the same process.
+type metrics struct {
+	registry          *prometheus.Registry
+	transfers         *prometheus.CounterVec
+	transferDuration  *prometheus.HistogramVec
+	envelopesOpened   *prometheus.CounterVec
+	envelopesRejected *prometheus.CounterVec
+	duplicates        *prometheus.CounterVec
+	securityEvents    *prometheus.CounterVec
+	keyExchanges      *prometheus.CounterVec
+	streamDuration    *prometheus.HistogramVec
+	reviewDecisions   *prometheus.HistogramVec
+	errors            *prometheus.CounterVec
+}
+
+func (s *Server) newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		transfers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "trisarl",
+			Name:      "transfers_received_total",
+			Help:      "Secure envelopes received from peers by unary or streaming transfers.",
+		}, []string{"peer", "rpc"}),
+		transferDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "trisarl",
+			Name:      "transfer_duration_seconds",
+			Help:      "Time taken to process a secure envelope in the transfer pipeline.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"peer"}),
+		envelopesOpened: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "trisarl",
+			Name:      "envelopes_opened_total",
+			Help:      "Secure envelopes that were decrypted with the signing key.",
+		}, []string{"peer"}),
+		envelopesRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "trisarl",
+			Name:      "envelopes_rejected_total",
+			Help:      "Secure envelopes that were rejected, by TRISA error code.",
+		}, []string{"peer", "code"}),
+		duplicates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "trisarl",
+			Name:      "envelopes_duplicate_total",
+			Help:      "Retransmitted secure envelopes that were replayed or rejected without processing.",
+		}, []string{"peer"}),
+		securityEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "trisarl",
+			Name:      "security_events_total",
+			Help:      "Messages from peers that failed a security check, by event.",
+		}, []string{"peer", "event"}),
+		keyExchanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "trisarl",
+			Name:      "key_exchanges_total",
+			Help:      "Key exchanges initiated by peers (incoming) or by the server (outgoing).",
+		}, []string{"peer", "direction"}),
+		streamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "trisarl",
+			Name:      "stream_duration_seconds",
+			Help:      "How long transfer streams were held open by peers.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+		}, []string{"peer"}),
+		reviewDecisions: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "trisarl",
+			Name:      "review_decision_seconds",
+			Help:      "Time from receiving a transfer to sending the decision on its review, by decision.",
+			Buckets:   prometheus.ExponentialBuckets(60, 4, 8),
+		}, []string{"decision"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "trisarl",
+			Name:      "errors_total",
+			Help:      "TRISA errors returned to peers, by RPC and TRISA error code.",
+		}, []string{"peer", "rpc", "code"}),
+	}
+
+	m.registry.MustRegister(
+		m.transfers,
+		m.transferDuration,
+		m.envelopesOpened,
+		m.envelopesRejected,
+		m.duplicates,
+		m.securityEvents,
+		m.keyExchanges,
+		m.streamDuration,
+		m.reviewDecisions,
+		m.errors,
+		&reviewQueue{server: s},
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "trisarl",
+			Name:      "panics_total",
+			Help:      "Panics in RPC handlers that were recovered from.",
+		}, func() float64 { return float64(s.Panics()) }),
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// unaryErrors counts the TRISA errors returned by unary RPCs, including the errors of
+// the access and recovery interceptors, by the common name of the peer certificate so
+// that errors are counted even if the peer could not be verified.
+func (s *Server) unaryErrors(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (rep interface{}, err error) {
+	rep, err = handler(ctx, req)
+	s.metrics.observeError(peerName(ctx), path.Base(info.FullMethod), err)
+	return rep, err
+}
+
+// streamErrors counts the TRISA errors that close streaming RPCs; errors that are sent
+// to the peer in secure envelopes on the stream are counted when they are sent.
+func (s *Server) streamErrors(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	err = handler(srv, stream)
+	s.metrics.observeError(peerName(stream.Context()), path.Base(info.FullMethod), err)
+	return err
+}
+
+// peerName returns the common name of the peer certificate of the RPC for metrics.
+func peerName(ctx context.Context) string {
+	if cert := peerCertificate(ctx); cert != nil {
+		return cert.Subject.CommonName
+	}
+	return "unknown"
+}
+
+// Registry returns the Prometheus registry of the server so that applications that
+// embed the server can add their own metrics to the metrics endpoint.
+func (s *Server) Registry() *prometheus.Registry {
+	return s.metrics.registry
+}
+
+// observeTransfer records the outcome of a secure envelope processed by the pipeline.
+func (m *metrics) observeTransfer(t *Transfer, err error, started time.Time) {
+	peer := t.Peer.String()
+	m.transferDuration.WithLabelValues(peer).Observe(time.Since(started).Seconds())
+
+	if t.Envelope != nil {
+		m.envelopesOpened.WithLabelValues(peer).Inc()
+	}
+
+	if t.Duplicate {
+		m.duplicates.WithLabelValues(peer).Inc()
+	}
+
+	if err != nil {
+		code := protocol.InternalError
+		if perr, ok := protocol.Errorp(err); ok {
+			code = perr.Code
+		}
+		m.envelopesRejected.WithLabelValues(peer, code.String()).Inc()
+	}
+}
+
+// observeReview records the decision on a reviewed transfer that was received at the
+// time.
+func (m *metrics) observeReview(decision string, receivedAt time.Time) {
+	m.reviewDecisions.WithLabelValues(decision).Observe(time.Since(receivedAt).Seconds())
+}
+
+// reviewQueue collects the depth and age of the review queue from the store when the
+// metrics are scraped, so that transfers waiting for a decision are measured even if
+// they were queued before the server was restarted.
+type reviewQueue struct {
+	server *Server
+}
+
+var (
+	reviewsPending = prometheus.NewDesc("trisarl_reviews_pending", "Transfers in the review queue waiting for a decision.", nil, nil)
+	reviewsOverdue = prometheus.NewDesc("trisarl_reviews_overdue", "Transfers in the review queue whose reply deadline has passed.", nil, nil)
+	reviewsAge     = prometheus.NewDesc("trisarl_reviews_oldest_age_seconds", "Time since the oldest transfer in the review queue was received.", nil, nil)
+)
+
+// Describe implements prometheus.Collector
+func (q *reviewQueue) Describe(ch chan<- *prometheus.Desc) {
+	ch <- reviewsPending
+	ch <- reviewsOverdue
+	ch <- reviewsAge
+}
+
+// Collect implements prometheus.Collector
+func (q *reviewQueue) Collect(ch chan<- prometheus.Metric) {
+	reviews, err := q.server.db.Reviews()
+	if err != nil {
+		log.Warn().Err(err).Msg("could not collect review queue metrics")
+		return
+	}
+
+	var overdue int
+	var oldest time.Duration
+	now := time.Now()
+	for _, review := range reviews {
+		if now.After(review.ReplyNotAfter) {
+			overdue++
+		}
+		if age := now.Sub(review.ReceivedAt); age > oldest {
+			oldest = age
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(reviewsPending, prometheus.GaugeValue, float64(len(reviews)))
+	ch <- prometheus.MustNewConstMetric(reviewsOverdue, prometheus.GaugeValue, float64(overdue))
+	ch <- prometheus.MustNewConstMetric(reviewsAge, prometheus.GaugeValue, oldest.Seconds())
+}
+
+// observeError counts the TRISA error returned to the peer by the RPC. Errors that are
+// not TRISA errors, e.g. canceled contexts, are not counted.
+func (m *metrics) observeError(peer, rpc string, err error) {
+	if code, ok := errorCode(err); ok {
+		m.errors.WithLabelValues(peer, rpc, code.String()).Inc()
+	}
+}
+
+// errorCode returns the code of a TRISA error, which may have been converted into a
+// gRPC status error with the TRISA error in its details.
+func errorCode(err error) (protocol.Error_Code, bool) {
+	if err == nil {
+		return 0, false
+	}
+
+	if perr, ok := err.(*protocol.Error); ok {
+		return perr.Code, true
+	}
+
+	if st, ok := status.FromError(err); ok {
+		for _, detail := range st.Details() {
+			if perr, ok := detail.(*protocol.Error); ok {
+				return perr.Code, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// serveMetrics serves the metrics endpoint on its own HTTP listener, which is shut down
+// with the server.
+func (s *Server) serveMetrics(addr, path string) {
+	mux := http.NewServeMux()
+	mux.Handle(path, promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}))
+
+	s.metricsSrv = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Info().Str("listen", addr).Str("path", path).Msg("metrics server started")
+		if err := s.metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("metrics server stopped")
+		}
+	}()
+}
+
+// shutdownMetrics stops the metrics HTTP listener if it was started.
+func (s *Server) shutdownMetrics() {
+	if s.metricsSrv == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.metricsSrv.Shutdown(ctx); err != nil {
+		log.Warn().Err(err).Msg("could not shutdown metrics server")
+	}
+}
@@ -0,0 +1,113 @@
+package trisarl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/notifications"
+	"github.com/rotationalio/trisa/pkg/secrets"
+	"github.com/rs/zerolog/log"
+)
+
+// sendNotification sends the notification to the compliance officers, if they are
+// enabled. Notifications are sent in the background so that they never affect the
+// Travel Rule exchange.
+func (s *Server) sendNotification(n *notifications.Notification) {
+	if s.notifier == nil {
+		return
+	}
+
+	if !s.notifier.Send(n) {
+		log.Warn().Str("notification", n.Kind).Str("id", n.EnvelopeID).Msg("notification queue is full or closed, notification dropped")
+	}
+}
+
+// transferNotification summarizes the transfer for the notification of the kind, with
+// a link to the review if the transfer is queued for review.
+func (s *Server) transferNotification(kind string, t *Transfer, reason, outcome string, queued bool) *notifications.Notification {
+	n := &notifications.Notification{
+		Kind:        kind,
+		Node:        t.Local,
+		Peer:        t.Peer.String(),
+		Amount:      t.Transaction.GetAmount(),
+		Asset:       t.Transaction.GetNetwork(),
+		Originator:  notifications.Party(t.Identity.GetOriginator().GetOriginatorPersons(), t.Identity.GetOriginator().GetAccountNumbers()),
+		Beneficiary: notifications.Party(t.Identity.GetBeneficiary().GetBeneficiaryPersons(), t.Identity.GetBeneficiary().GetAccountNumbers()),
+		Reason:      reason,
+		Outcome:     outcome,
+	}
+
+	if t.In != nil {
+		n.EnvelopeID = t.In.Id
+	}
+
+	if reviewURL := s.config().Notifications.ReviewURL; queued && reviewURL != "" && n.EnvelopeID != "" {
+		n.ReviewURL = strings.TrimSuffix(reviewURL, "/") + "/" + url.PathEscape(n.EnvelopeID)
+	}
+	return n
+}
+
+// newNotifier starts the sender of the notifications with the Slack webhooks and email
+// recipients of the configuration, or returns nil if notifications are not enabled.
+func newNotifier(conf config.NotificationsConfig) (_ *notifications.Sender, err error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+	defer cancel()
+
+	var notifiers []notifications.Notifier
+	client := &http.Client{Timeout: conf.Timeout}
+	for _, webhook := range strings.Split(conf.SlackWebhooks, ",") {
+		if webhook = strings.TrimSpace(webhook); webhook == "" {
+			continue
+		}
+
+		// Webhook URLs are used as they are, other URIs are secrets with the webhook URL
+		if !strings.HasPrefix(strings.ToLower(webhook), "https://") {
+			if webhook, err = secrets.LoadString(ctx, webhook); err != nil {
+				return nil, fmt.Errorf("could not load slack webhook: %s", err)
+			}
+		}
+		notifiers = append(notifiers, &notifications.Slack{URL: webhook, Client: client})
+	}
+
+	var to []string
+	for _, recipient := range strings.Split(conf.To, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			to = append(to, recipient)
+		}
+	}
+
+	if len(to) > 0 {
+		email := &notifications.Email{Addr: conf.SMTPAddr, Username: conf.SMTPUsername, To: to}
+		if email.From, err = validEmail(conf.From); err != nil {
+			return nil, err
+		}
+		if email.Password, err = secrets.LoadString(ctx, conf.SMTPPassword); err != nil {
+			return nil, fmt.Errorf("could not load smtp password: %s", err)
+		}
+		notifiers = append(notifiers, email)
+	}
+
+	onError := func(notifier notifications.Notifier, n *notifications.Notification, err error) {
+		channel := "email"
+		if _, ok := notifier.(*notifications.Slack); ok {
+			channel = "slack"
+		}
+		log.Warn().Err(err).Str("channel", channel).Str("notification", n.Kind).Str("id", n.EnvelopeID).Msg("could not send notification")
+	}
+	return notifications.NewSender(notifiers, conf.QueueSize, conf.Timeout, onError), nil
+}
+
+// shutdownNotifications sends the notifications that are still queued.
+func (s *Server) shutdownNotifications() {
+	if s.notifier != nil {
+		s.notifier.Close()
+	}
+}
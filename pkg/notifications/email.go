@@ -0,0 +1,46 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Email sends notifications to the To recipients from the From address with the SMTP
+// server at Addr (host:port), authenticating with the username and password if a
+// username is set. The SMTP client does not support contexts, so a slow server is only
+// bounded by the timeouts of the connection.
+type Email struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// Notify implements Notifier
+func (e *Email) Notify(_ context.Context, n *Notification) error {
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, _ := net.SplitHostPort(e.Addr)
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, "From: %s\r\n", e.From)
+	fmt.Fprintf(body, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(body, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(n.Subject()))
+	fmt.Fprintf(body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(body, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(body, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	body.WriteString(strings.ReplaceAll(n.Text(), "\n", "\r\n"))
+
+	if err := smtp.SendMail(e.Addr, auth, e.From, e.To, body.Bytes()); err != nil {
+		return fmt.Errorf("could not send email: %s", err)
+	}
+	return nil
+}
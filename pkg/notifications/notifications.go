@@ -0,0 +1,148 @@
+/*
+Package notifications alerts the compliance officers of the VASP when an incoming
+transfer needs their attention, i.e. when it is queued for review or when its
+originator matches a sanctions list. Each Notification is a short summary of the
+transfer with the peer, amount, and asset, the redacted names and accounts of the
+originator and beneficiary, and a link to the review, which is sent to Slack incoming
+webhooks or by email. The Sender delivers notifications in the background so that slow
+chat or mail servers never delay the Travel Rule exchange; channels are integrated by
+implementing Notifier.
+*/
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+)
+
+// Kinds of the notifications.
+const (
+	Review       = "review"
+	ScreeningHit = "screening"
+	Expired      = "expired"
+)
+
+// Notification summarizes an incoming transfer for the compliance officers. Originator
+// and Beneficiary are the redacted names and accounts of the parties, which are the only
+// information about the parties that is sent, since chat channels and mailboxes are not
+// suitable for PII. Outcome describes what happened to the transfer, e.g. that it was
+// held for review, and ReviewURL links to the review if the transfer is in the queue.
+type Notification struct {
+	Kind        string
+	Time        time.Time
+	Node        string
+	Peer        string
+	EnvelopeID  string
+	Amount      float64
+	Asset       string
+	Originator  string
+	Beneficiary string
+	Reason      string
+	Outcome     string
+	ReviewURL   string
+}
+
+// Notifier sends a notification to a channel, e.g. a Slack webhook or email recipients.
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// Subject is the one line summary of the notification, e.g. the subject of the email.
+func (n *Notification) Subject() string {
+	switch n.Kind {
+	case ScreeningHit:
+		return fmt.Sprintf("Sanctions screening hit on a transfer from %s", n.Peer)
+	case Expired:
+		return fmt.Sprintf("Transaction with %s expired", n.Peer)
+	default:
+		return fmt.Sprintf("Transfer from %s queued for review", n.Peer)
+	}
+}
+
+// Text is the plain text summary of the notification, one field per line.
+func (n *Notification) Text() string {
+	var b strings.Builder
+	line := func(label, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", label, value)
+		}
+	}
+
+	line("Peer", n.Peer)
+	if n.Amount != 0 {
+		line("Amount", strings.TrimSpace(strconv.FormatFloat(n.Amount, 'f', -1, 64)+" "+n.Asset))
+	} else {
+		line("Asset", n.Asset)
+	}
+	line("Originator", n.Originator)
+	line("Beneficiary", n.Beneficiary)
+	line("Reason", n.Reason)
+	line("Outcome", n.Outcome)
+	line("Envelope", n.EnvelopeID)
+	line("Node", n.Node)
+	if !n.Time.IsZero() {
+		line("Received", n.Time.UTC().Format(time.RFC1123))
+	}
+	line("Review", n.ReviewURL)
+	return b.String()
+}
+
+// Party returns the redacted names of the persons and the redacted account numbers of a
+// party to the transfer, e.g. "J*** S**** (account ****5678)".
+func Party(persons []*ivms101.Person, accounts []string) string {
+	var names []string
+	for _, person := range persons {
+		var personNames []string
+		switch {
+		case person.GetNaturalPerson() != nil:
+			personNames = person.GetNaturalPerson().Names()
+		case person.GetLegalPerson() != nil:
+			personNames = person.GetLegalPerson().Names()
+		}
+		if len(personNames) > 0 {
+			names = append(names, RedactName(personNames[0]))
+		}
+	}
+
+	party := strings.Join(names, ", ")
+	if len(accounts) > 0 {
+		redacted := make([]string, 0, len(accounts))
+		for _, account := range accounts {
+			redacted = append(redacted, RedactAccount(account))
+		}
+
+		label := "account"
+		if len(accounts) > 1 {
+			label = "accounts"
+		}
+		party = strings.TrimSpace(fmt.Sprintf("%s (%s %s)", party, label, strings.Join(redacted, ", ")))
+	}
+	return party
+}
+
+// RedactName keeps the first letter of each word of the name, e.g. "J*** S****" for
+// "Jane Smith", so that reviewers can tell parties apart without seeing their names.
+func RedactName(name string) string {
+	words := strings.Fields(name)
+	for i, word := range words {
+		first, size := utf8.DecodeRuneInString(word)
+		words[i] = string(first) + strings.Repeat("*", utf8.RuneCountInString(word[size:]))
+	}
+	return strings.Join(words, " ")
+}
+
+// RedactAccount keeps the last four characters of an account number or wallet address,
+// e.g. "****5678"; accounts of eight characters or less are redacted completely.
+func RedactAccount(account string) string {
+	runes := []rune(strings.TrimSpace(account))
+	if len(runes) <= 8 {
+		return strings.Repeat("*", len(runes))
+	}
+	return "****" + string(runes[len(runes)-4:])
+}
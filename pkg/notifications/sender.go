@@ -0,0 +1,93 @@
+package notifications
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ErrorFunc handles a notification that could not be sent with the notifier, e.g. by
+// logging it.
+type ErrorFunc func(notifier Notifier, n *Notification, err error)
+
+// Sender sends notifications with every notifier from a queue in the background.
+// Notifications that do not fit in the queue are dropped rather than blocking the
+// caller, and notifications that a notifier cannot send are handed to the error handler;
+// a failing notifier does not keep the notification from the other notifiers.
+type Sender struct {
+	notifiers []Notifier
+	timeout   time.Duration
+	onError   ErrorFunc
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *Notification
+	done   chan struct{}
+}
+
+// NewSender starts a sender that sends notifications with the notifiers from a queue of
+// the size. Each notifier must send a notification within the timeout.
+func NewSender(notifiers []Notifier, queueSize int, timeout time.Duration, onError ErrorFunc) *Sender {
+	s := &Sender{
+		notifiers: notifiers,
+		timeout:   timeout,
+		onError:   onError,
+		queue:     make(chan *Notification, queueSize),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Send queues the notification, setting its time if it does not have one. Send does not
+// block; false is returned if the notification was dropped because the queue is full or
+// the sender is closed.
+func (s *Sender) Send(n *Notification) bool {
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return false
+	}
+
+	select {
+	case s.queue <- n:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close sends the notifications that are still queued and stops the sender.
+func (s *Sender) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	<-s.done
+}
+
+func (s *Sender) run() {
+	defer close(s.done)
+	for n := range s.queue {
+		for _, notifier := range s.notifiers {
+			if err := s.send(notifier, n); err != nil && s.onError != nil {
+				s.onError(notifier, n, err)
+			}
+		}
+	}
+}
+
+func (s *Sender) send(notifier Notifier, n *Notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	return notifier.Notify(ctx, n)
+}
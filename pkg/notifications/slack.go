@@ -0,0 +1,75 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Slack posts notifications to a Slack incoming webhook, which posts them to the channel
+// the webhook was created for. The URL of the webhook is a secret, since anyone with the
+// URL can post to the channel.
+type Slack struct {
+	URL    string
+	Client *http.Client
+}
+
+// slackMessage is a message of a Slack incoming webhook with a fallback text and the
+// summary in a section block.
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type string     `json:"type"`
+	Text *slackText `json:"text,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Notify implements Notifier
+func (s *Slack) Notify(ctx context.Context, n *Notification) (err error) {
+	msg := &slackMessage{
+		Text: n.Subject(),
+		Blocks: []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: n.Subject()}},
+			{Type: "section", Text: &slackText{Type: "plain_text", Text: n.Text()}},
+		},
+	}
+
+	var body []byte
+	if body, err = json.Marshal(msg); err != nil {
+		return err
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body)); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var rep *http.Response
+	if rep, err = client.Do(req); err != nil {
+		return fmt.Errorf("could not reach slack: %s", err)
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(rep.Body, 512))
+		return fmt.Errorf("slack returned %s: %s", rep.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
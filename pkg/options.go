@@ -0,0 +1,119 @@
+package trisarl
+
+import (
+	"github.com/rotationalio/trisa/pkg/chain"
+	"github.com/rotationalio/trisa/pkg/keychain"
+	"github.com/rotationalio/trisa/pkg/proposal"
+	"github.com/rotationalio/trisa/pkg/screening"
+	"google.golang.org/grpc"
+)
+
+// Option configures the server when it is created with New, allowing applications that
+// embed the Rotational TRISA node to customize how incoming transfers are processed.
+type Option func(s *Server) error
+
+// WithStageBefore inserts a named middleware stage into the transfer pipeline before
+// the specified stage, e.g. a custom screen before the default StageScreen.
+func WithStageBefore(before, name string, mw Middleware) Option {
+	return func(s *Server) error {
+		return s.stages.InsertBefore(before, Stage{Name: name, Middleware: mw})
+	}
+}
+
+// WithStageAfter inserts a named middleware stage into the transfer pipeline after the
+// specified stage, e.g. to inspect the identity and transaction after StageValidate.
+func WithStageAfter(after, name string, mw Middleware) Option {
+	return func(s *Server) error {
+		return s.stages.InsertAfter(after, Stage{Name: name, Middleware: mw})
+	}
+}
+
+// WithStage replaces the middleware of one of the stages of the transfer pipeline, e.g.
+// to replace StageHandle with a middleware that has access to the whole Transfer.
+// Applications that only respond to transfers should use WithTransferHandler instead.
+func WithStage(name string, mw Middleware) Option {
+	return func(s *Server) error {
+		return s.stages.Replace(name, mw)
+	}
+}
+
+// WithTransferHandler sets the handler that responds to transfers after the secure
+// envelope has been opened and validated, rather than rejecting every transfer with the
+// configured rejection error.
+func WithTransferHandler(handler TransferHandler) Option {
+	return func(s *Server) error {
+		s.handler = handler
+		return nil
+	}
+}
+
+// WithProposalProvider satisfies the counter-proposals of counterparties that reject
+// transfers sent with Server.Send, e.g. by looking up the requested customer data in
+// the KYC database of the VASP. Transfers whose counter-proposal is satisfied are sent
+// again once with the same envelope ID and the completed identity.
+func WithProposalProvider(provider proposal.Provider) Option {
+	return func(s *Server) error {
+		s.proposals = provider
+		return nil
+	}
+}
+
+// WithScreener screens the originators of transfers with the screener, e.g. to integrate
+// a sanctions screening provider, instead of the sanctions lists in the configuration.
+// Matches are handled with the configured screening action.
+func WithScreener(screener screening.Screener) Option {
+	return func(s *Server) error {
+		s.screenerOpt = screener
+		return nil
+	}
+}
+
+// WithKeyChain keeps the keys of peers and the generated exchange keys in the keychain,
+// e.g. a keychain backed by the secret store of the VASP, instead of the keychain in the
+// configuration. An exchange key in the configuration is still loaded and replaces the
+// local key of the keychain.
+func WithKeyChain(kc keychain.KeyChain) Option {
+	return func(s *Server) error {
+		s.keychain = kc
+		return nil
+	}
+}
+
+// WithChainProvider verifies the settlements of Travel Rule exchanges on chain with the
+// provider, e.g. to integrate a node or block explorer of the VASP, instead of the
+// chain-data provider in the configuration.
+func WithChainProvider(provider chain.Provider) Option {
+	return func(s *Server) error {
+		s.chainOpt = provider
+		return nil
+	}
+}
+
+// WithPayloadType accepts transfers whose transaction has the type URL, which are
+// decoded and validated by the unmarshaler, e.g. to accept additional transaction
+// schemas such as custom extensions. Registering one of the default type URLs, e.g.
+// TypeTransaction, replaces how that type is decoded.
+func WithPayloadType(typeURL string, unmarshal Unmarshaler) Option {
+	return func(s *Server) error {
+		return s.payloads.Register(typeURL, unmarshal)
+	}
+}
+
+// WithUnaryInterceptors adds interceptors that run before the unary RPC handlers, e.g.
+// for authentication, metrics, or tracing. They run after the built-in interceptors in
+// the order they are added.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(s *Server) error {
+		s.unary = append(s.unary, interceptors...)
+		return nil
+	}
+}
+
+// WithStreamInterceptors adds interceptors that run before the streaming RPC handlers.
+// They run after the built-in interceptors in the order they are added.
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(s *Server) error {
+		s.stream = append(s.stream, interceptors...)
+		return nil
+	}
+}
@@ -0,0 +1,186 @@
+package trisarl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/ivms"
+	"github.com/rotationalio/trisa/pkg/pending"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Type URLs of the payload types that are accepted by default.
+const (
+	TypeIdentityPayload = "type.googleapis.com/ivms101.IdentityPayload"
+	TypeTransaction     = "type.googleapis.com/trisa.data.generic.v1beta1.Transaction"
+	TypePending         = pending.TypeURL
+	TypeReceipt         = "type.googleapis.com/trisa.data.generic.v1beta1.ConfirmationReceipt"
+
+	// Pending messages sent by earlier versions of the node are structs.
+	typeStruct = "type.googleapis.com/google.protobuf.Struct"
+)
+
+// Unmarshaler decodes and validates the transaction of an incoming transfer with the
+// type URL it is registered for. The validate stage decodes the IVMS101 identity of the
+// payload into the Identity of the transfer, if the payload has one, before calling the
+// unmarshaler, which decodes the transaction into the Transfer, e.g. into Transaction
+// for generic transactions or into Data for custom transaction schemas, and checks that
+// the identity is present and valid if the schema requires it, e.g. with ivms.Validate.
+// Errors should be TRISA protocol errors such as UnparseableTransaction so that they can
+// be returned to the peer.
+type Unmarshaler func(ctx context.Context, t *Transfer, transaction *anypb.Any) error
+
+// PayloadTypes maps the type URLs of the transactions that the node accepts to the
+// unmarshalers that decode them. Transfers with a transaction type that is not
+// registered are rejected with an UnparseableTransaction error. Payload types are
+// registered when the server is created, e.g. with WithPayloadType, and are not
+// modified while the server is running.
+type PayloadTypes struct {
+	types map[string]Unmarshaler
+}
+
+// Creates the payload types that are accepted by default: generic transactions,
+// including beneficiary inquiries that have no identity, pending messages, and the
+// confirmation receipts of final acknowledgments.
+func defaultPayloadTypes() *PayloadTypes {
+	p := &PayloadTypes{types: make(map[string]Unmarshaler)}
+	p.Register(TypeTransaction, unmarshalTransaction)
+	p.Register(TypePending, unmarshalPending)
+	p.Register(typeStruct, unmarshalPending)
+	p.Register(TypeReceipt, unmarshalReceipt)
+	return p
+}
+
+// Register the unmarshaler for transactions with the type URL, replacing the
+// unmarshaler of the type URL if it is already registered.
+func (p *PayloadTypes) Register(typeURL string, unmarshal Unmarshaler) error {
+	if typeURL == "" || unmarshal == nil {
+		return fmt.Errorf("payload types require a type url and an unmarshaler")
+	}
+	p.types[typeURL] = unmarshal
+	return nil
+}
+
+// Lookup returns the unmarshaler for transactions with the type URL.
+func (p *PayloadTypes) Lookup(typeURL string) (unmarshal Unmarshaler, ok bool) {
+	unmarshal, ok = p.types[typeURL]
+	return unmarshal, ok
+}
+
+// TypeURLs returns the sorted type URLs of the registered transactions.
+func (p *PayloadTypes) TypeURLs() []string {
+	urls := make([]string, 0, len(p.types))
+	for typeURL := range p.types {
+		urls = append(urls, typeURL)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// Accepted returns the sorted type URLs of the registered transactions that are
+// accepted by the configuration.
+func (p *PayloadTypes) Accepted(conf config.PayloadsConfig) []string {
+	urls := make([]string, 0, len(p.types))
+	for _, typeURL := range p.TypeURLs() {
+		if conf.AllowsTransaction(typeURL) {
+			urls = append(urls, typeURL)
+		}
+	}
+	return urls
+}
+
+// Check that every payload type accepted by the configuration can be parsed: identities
+// must be IVMS101 identity payloads and transactions must have a registered unmarshaler.
+func (p *PayloadTypes) Check(conf config.PayloadsConfig) error {
+	for _, typeURL := range config.TypeURLs(conf.Identity) {
+		if typeURL != TypeIdentityPayload {
+			return fmt.Errorf("unsupported identity payload type %q, only %s identities can be parsed", typeURL, TypeIdentityPayload)
+		}
+	}
+
+	for _, typeURL := range config.TypeURLs(conf.Transaction) {
+		if _, ok := p.Lookup(typeURL); !ok {
+			return fmt.Errorf("unsupported transaction payload type %q, no unmarshaler is registered for it", typeURL)
+		}
+	}
+	return nil
+}
+
+// acceptedIdentities returns the type URLs of the identities accepted by the configuration.
+func acceptedIdentities(conf config.PayloadsConfig) []string {
+	if conf.AllowsIdentity(TypeIdentityPayload) {
+		return []string{TypeIdentityPayload}
+	}
+	return nil
+}
+
+// listTypes formats the accepted type URLs for the errors returned to peers.
+func listTypes(urls []string) string {
+	if len(urls) == 0 {
+		return "none"
+	}
+	return strings.Join(urls, ", ")
+}
+
+// Decode the payload with the unmarshaler registered for the type of its transaction.
+// The identity of the payload must be an IVMS 101 identity if it is present. Payload
+// types that are not accepted by the configuration are rejected with an error that
+// lists the accepted types.
+func (s *Server) validate(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		conf := s.config().Payloads
+		payload := t.Envelope.Payload
+		typeURL := payload.Transaction.GetTypeUrl()
+
+		unmarshal, ok := s.payloads.Lookup(typeURL)
+		if !ok || !conf.AllowsTransaction(typeURL) {
+			log.Ctx(ctx).Warn().Str("type", typeURL).Msg("unsupported transaction type")
+			return protocol.Errorf(protocol.UnparseableTransaction, "unsupported payload transaction type %q, accepted types: %s", typeURL, listTypes(s.payloads.Accepted(conf)))
+		}
+
+		if payload.Identity.GetTypeUrl() != "" {
+			if payload.Identity.TypeUrl != TypeIdentityPayload || !conf.AllowsIdentity(payload.Identity.TypeUrl) {
+				log.Ctx(ctx).Warn().Str("type", payload.Identity.TypeUrl).Msg("unsupported identity type")
+				return protocol.Errorf(protocol.UnparseableIdentity, "unsupported payload identity type %q, accepted types: %s", payload.Identity.TypeUrl, listTypes(acceptedIdentities(conf)))
+			}
+
+			t.Identity = &ivms101.IdentityPayload{}
+			if err = payload.Identity.UnmarshalTo(t.Identity); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("could not unmarshal identity")
+				return protocol.Errorf(protocol.UnparseableIdentity, "could not unmarshal identity")
+			}
+		}
+
+		if err = unmarshal(ctx, t, payload.Transaction); err != nil {
+			return err
+		}
+		return next(ctx, t)
+	}
+}
+
+// Decode a generic transaction. Transfers without an identity are beneficiary inquiries,
+// otherwise the identity must satisfy every IVMS101 constraint.
+func unmarshalTransaction(ctx context.Context, t *Transfer, transaction *anypb.Any) (err error) {
+	t.Transaction = &generic.Transaction{}
+	if err = transaction.UnmarshalTo(t.Transaction); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("could not unmarshal transaction")
+		return protocol.Errorf(protocol.UnparseableTransaction, "could not unmarshal transaction")
+	}
+
+	if t.Identity == nil {
+		return validateInquiry(t)
+	}
+
+	if violations := ivms.Validate(t.Identity); len(violations) > 0 {
+		log.Ctx(ctx).Warn().Int("violations", len(violations)).Str("error", violations.Error()).Msg("invalid identity payload")
+		return violations.Reject()
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package trisarl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/notifications"
+	"github.com/rotationalio/trisa/pkg/pending"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// pendingAcknowledged is the reason of the confirmation receipt that acknowledges a
+// pending message from a peer, whose transaction then awaits the follow-up transfer.
+const pendingAcknowledged = "pending message received, awaiting follow-up"
+
+// ErrReplyDeadline is returned if the decision on a reviewed transfer is sent after the
+// reply deadline that was promised to the originator in the pending message.
+var ErrReplyDeadline = errors.New("the reply deadline has passed")
+
+// PendingError is returned by transfer handlers to respond to a transfer with a pending
+// message and review it asynchronously; once it has been reviewed, Approve or Reject
+// sends the decision back to the originator with the same envelope ID. The message is
+// sent to the peer in the pending message; if ReplyNotAfter is zero, the decision is
+// promised within the configured reply timeout. Reason is kept with the review but is
+// not sent to the peer, e.g. to tell the reviewer why the transfer was held; the
+// message is used if it is empty.
+type PendingError struct {
+	Message       string
+	Reason        string
+	ReplyNotAfter time.Time
+}
+
+// Pending returns a PendingError with the message and the default reply timeout.
+func Pending(message string) error {
+	return &PendingError{Message: message}
+}
+
+func (e *PendingError) Error() string {
+	if e.Message == "" {
+		return "transfer pending review"
+	}
+	return fmt.Sprintf("transfer pending review: %s", e.Message)
+}
+
+// pend queues the transfer for review and returns the pending message, which echoes the
+// identity of the transfer back to the peer along with the time frame for the reply.
+func (s *Server) pend(ctx context.Context, t *Transfer, perr *PendingError) (payload *protocol.Payload, err error) {
+	if t.In == nil || t.In.Id == "" {
+		return nil, protocol.Errorf(protocol.MissingFields, "envelope id is required for asynchronous transfers")
+	}
+
+	msg := pending.New(t.In.Id, t.Local, perr.Message, s.config().ReplyTimeout)
+	if !perr.ReplyNotAfter.IsZero() {
+		msg.ReplyNotAfter = perr.ReplyNotAfter
+	}
+
+	reason := perr.Reason
+	if reason == "" {
+		reason = perr.Message
+	}
+
+	review := &store.Review{
+		EnvelopeID:     msg.EnvelopeID,
+		Peer:           t.Peer.String(),
+		Reason:         reason,
+		ReceivedAt:     msg.ReceivedAt,
+		ReplyNotBefore: msg.ReplyNotBefore,
+		ReplyNotAfter:  msg.ReplyNotAfter,
+	}
+	if review.Identity, err = protojson.Marshal(t.Identity); err != nil {
+		return nil, err
+	}
+	if review.Transaction, err = protojson.Marshal(t.Transaction); err != nil {
+		return nil, err
+	}
+	if err = s.db.PutReview(review); err != nil {
+		return nil, err
+	}
+
+	if payload, err = msg.Payload(t.Identity); err != nil {
+		return nil, err
+	}
+
+	t.Pending = true
+	if tx := s.receiveTransaction(ctx, t); tx != nil && tx.State != store.PendingReview {
+		s.transition(ctx, tx, store.PendingReview, reason)
+	}
+
+	log.Ctx(ctx).Info().Str("peer", t.Peer.String()).Str("id", msg.EnvelopeID).Time("reply_not_after", msg.ReplyNotAfter).Msg("transfer queued for review")
+
+	// Transfers held by the screening are notified as screening hits instead
+	if len(t.Screening) == 0 {
+		s.sendNotification(s.transferNotification(notifications.Review, t, reason, "queued for review", true))
+	}
+	return payload, nil
+}
+
+// Reviews returns the transfers that were answered with a pending message and are
+// waiting for a decision. The identity and transaction of each review are the protojson
+// encoded ivms101.IdentityPayload and generic.Transaction of the transfer.
+func (s *Server) Reviews() ([]*store.Review, error) {
+	return s.db.Reviews()
+}
+
+// ApproveReview approves a reviewed transfer with the response built by the Responder
+// from the identity and transaction that were queued with the review, i.e. the identity
+// is echoed back with the beneficiary account numbers completed from the transaction,
+// e.g. when a reviewer approves the transfer in the admin API without editing it.
+func (s *Server) ApproveReview(ctx context.Context, envelopeID string) (err error) {
+	var review *store.Review
+	if review, err = s.db.GetReview(envelopeID); err != nil {
+		return fmt.Errorf("could not find transfer %s in the review queue: %w", envelopeID, err)
+	}
+
+	identity := &ivms101.IdentityPayload{}
+	if err = protojson.Unmarshal(review.Identity, identity); err != nil {
+		return fmt.Errorf("could not decode identity of the review: %s", err)
+	}
+
+	transaction := &generic.Transaction{}
+	if err = protojson.Unmarshal(review.Transaction, transaction); err != nil {
+		return fmt.Errorf("could not decode transaction of the review: %s", err)
+	}
+
+	var payload *protocol.Payload
+	responder := &Responder{ReceivedAt: review.ReceivedAt}
+	if payload, err = responder.Payload(identity, transaction); err != nil {
+		return err
+	}
+	return s.Approve(ctx, envelopeID, payload)
+}
+
+// Approve sends the response payload of a reviewed transfer to the originator in a
+// transfer with the envelope ID of the original transfer and completes the transaction.
+// If the decision cannot be delivered an error is returned and the transfer remains
+// queued so that the decision can be sent again.
+func (s *Server) Approve(ctx context.Context, envelopeID string, payload *protocol.Payload) (err error) {
+	if payload == nil || payload.Identity == nil || payload.Transaction == nil {
+		return errors.New("an identity and a transaction are required to approve a transfer")
+	}
+
+	if tx, err := s.db.GetTransaction(envelopeID); err == nil && tx.State != store.Approved {
+		s.transition(ctx, tx, store.Approved, "")
+	}
+
+	if err = s.resolve(ctx, envelopeID, payload, nil); err != nil {
+		return err
+	}
+
+	if tx, err := s.db.GetTransaction(envelopeID); err == nil {
+		s.transition(ctx, tx, store.Completed, "response sent")
+	}
+	return nil
+}
+
+// Reject sends the rejection of a reviewed transfer to the originator in a transfer with
+// the envelope ID of the original transfer and rejects the transaction. If the decision
+// cannot be delivered an error is returned and the transfer remains queued.
+func (s *Server) Reject(ctx context.Context, envelopeID string, rejection *protocol.Error) (err error) {
+	if rejection == nil || rejection.Code == 0 {
+		return errors.New("an error code is required to reject a transfer")
+	}
+
+	if err = s.resolve(ctx, envelopeID, nil, rejection); err != nil {
+		return err
+	}
+
+	if tx, err := s.db.GetTransaction(envelopeID); err == nil {
+		s.transition(ctx, tx, store.Rejected, rejection.Message)
+	}
+	return nil
+}
+
+// resolve delivers the decision, either the response payload sealed with the signing
+// key of the peer or the rejection, to the originator of the reviewed transfer and
+// removes the transfer from the review queue. Errors returned by the originator in
+// response to the decision are recorded but do not prevent the transfer from being
+// resolved since the decision has been delivered.
+func (s *Server) resolve(ctx context.Context, envelopeID string, payload *protocol.Payload, rejection *protocol.Error) (err error) {
+	var review *store.Review
+	if review, err = s.db.GetReview(envelopeID); err != nil {
+		return fmt.Errorf("could not find transfer %s in the review queue: %w", envelopeID, err)
+	}
+
+	if time.Now().After(review.ReplyNotAfter) {
+		return fmt.Errorf("%w for transfer %s at %s", ErrReplyDeadline, envelopeID, review.ReplyNotAfter.Format(time.RFC3339))
+	}
+
+	var peer *peers.Peer
+	if peer, err = s.lookup(ctx, review.Peer); err != nil {
+		return err
+	}
+
+	var sealKey interface{}
+	if sealKey, err = s.exchangeKeys(ctx, peer, false); err != nil {
+		return fmt.Errorf("could not exchange keys with %s: %s", review.Peer, err)
+	}
+
+	var in, out *protocol.SecureEnvelope
+	if rejection != nil {
+		in = &protocol.SecureEnvelope{Id: envelopeID, Error: rejection}
+	} else if in, err = s.sealEnvelope(peer.String(), handler.New(envelopeID, payload, nil), sealKey); err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if out, err = peer.Transfer(in); err != nil {
+		s.recordEnvelope(ctx, peer.String(), store.Outgoing, in, payload, err)
+		return err
+	}
+	s.recordEnvelope(ctx, peer.String(), store.Outgoing, in, payload, nil)
+	s.recordEnvelope(ctx, peer.String(), store.Incoming, out, nil, nil)
+
+	if out.Error != nil && out.Error.Code != 0 {
+		log.Ctx(ctx).Warn().Str("peer", peer.String()).Str("id", envelopeID).Str("code", out.Error.Code.String()).Str("error", out.Error.Message).Msg("originator returned an error for the decision")
+	}
+
+	if err = s.db.DeleteReview(envelopeID); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("id", envelopeID).Msg("could not remove transfer from the review queue")
+	}
+
+	decision := "approved"
+	if rejection != nil {
+		decision = "rejected"
+	}
+	s.metrics.observeReview(decision, review.ReceivedAt)
+
+	log.Ctx(ctx).Info().Str("peer", peer.String()).Str("id", envelopeID).Msg("review decision sent")
+	return nil
+}
+
+// unmarshalPending decodes the pending message of the payload. The identity is optional
+// in pending messages.
+func unmarshalPending(ctx context.Context, t *Transfer, transaction *anypb.Any) (err error) {
+	if t.PendingMessage, err = pending.FromAny(transaction); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("could not decode pending message")
+		return protocol.Errorf(protocol.UnparseableTransaction, "could not unmarshal pending message")
+	}
+	return nil
+}
+
+// awaitReply moves the transaction of a pending message from the peer to awaiting the
+// counterparty and acknowledges the message with a confirmation receipt.
+func (s *Server) awaitReply(ctx context.Context, t *Transfer) (err error) {
+	msg := t.PendingMessage
+	if tx := s.receiveTransaction(ctx, t); tx != nil && tx.State != store.AwaitingCounterparty && !tx.State.Final() {
+		s.transition(ctx, tx, store.AwaitingCounterparty, msg.Message)
+	}
+
+	receipt := &generic.ConfirmationReceipt{
+		EnvelopeId: t.In.Id,
+		ReceivedBy: t.Local,
+		ReceivedAt: time.Now().Format(time.RFC3339),
+		Message:    pendingAcknowledged,
+	}
+
+	t.Response = &protocol.Payload{}
+	if t.Response.Transaction, err = anypb.New(receipt); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("could not marshal confirmation receipt")
+		return protocol.Errorf(protocol.InternalError, "could not acknowledge pending message")
+	}
+
+	log.Ctx(ctx).Info().Str("peer", t.Peer.String()).Str("id", t.In.Id).Time("reply_not_after", msg.ReplyNotAfter).Msg("pending message received")
+	return nil
+}
@@ -0,0 +1,256 @@
+/*
+Package pending implements the pending messages of the TRISA asynchronous transfer
+pattern. Instead of answering a transfer immediately, the beneficiary VASP responds with
+a pending message that tells the originator when to expect a reply, reviews the transfer,
+and later initiates a transfer back to the originator with the final decision.
+
+Pending messages are trisa.data.generic.v1beta1.Pending protocol buffers. The TRISA
+v1beta1 protocol buffers used by this node do not include the generated Pending type,
+so the message is encoded and decoded on the wire with the field numbers of the TRISA
+specification. Earlier versions of the node sent pending messages as a
+google.protobuf.Struct with the fields of the Pending message, which are still decoded.
+*/
+package pending
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// TypeURL is the type of the pending message in the transaction of a payload.
+const TypeURL = "type.googleapis.com/trisa.data.generic.v1beta1.Pending"
+
+// The type of pending messages encoded as a struct by earlier versions of the node and
+// the key in the struct that identifies it as a pending message.
+const (
+	structURL  = "type.googleapis.com/google.protobuf.Struct"
+	pendingKey = "pending"
+)
+
+// Field numbers of the trisa.data.generic.v1beta1.Pending message.
+const (
+	fieldEnvelopeID     protowire.Number = 1
+	fieldReceivedBy     protowire.Number = 2
+	fieldReceivedAt     protowire.Number = 3
+	fieldMessage        protowire.Number = 4
+	fieldReplyNotAfter  protowire.Number = 5
+	fieldReplyNotBefore protowire.Number = 6
+	fieldExtraJSON      protowire.Number = 7
+)
+
+// ErrNotPending is returned if a message is not a pending message.
+var ErrNotPending = errors.New("not a pending message")
+
+// Pending tells the originator that the transfer is being reviewed and that the final
+// decision will be sent in a transfer with the same envelope ID between the reply not
+// before and the reply not after timestamps.
+type Pending struct {
+	EnvelopeID     string
+	ReceivedBy     string
+	ReceivedAt     time.Time
+	Message        string
+	ReplyNotAfter  time.Time
+	ReplyNotBefore time.Time
+	ExtraJSON      string
+}
+
+// New returns a pending message for the envelope received now by the VASP with the
+// common name that promises a reply within the window.
+func New(envelopeID, receivedBy, message string, window time.Duration) *Pending {
+	now := time.Now()
+	return &Pending{
+		EnvelopeID:     envelopeID,
+		ReceivedBy:     receivedBy,
+		ReceivedAt:     now,
+		Message:        message,
+		ReplyNotBefore: now,
+		ReplyNotAfter:  now.Add(window),
+	}
+}
+
+// Marshal encodes the pending message in the protocol buffer wire format.
+func (p *Pending) Marshal() (data []byte) {
+	fields := []struct {
+		num   protowire.Number
+		value string
+	}{
+		{fieldEnvelopeID, p.EnvelopeID},
+		{fieldReceivedBy, p.ReceivedBy},
+		{fieldReceivedAt, formatTime(p.ReceivedAt)},
+		{fieldMessage, p.Message},
+		{fieldReplyNotAfter, formatTime(p.ReplyNotAfter)},
+		{fieldReplyNotBefore, formatTime(p.ReplyNotBefore)},
+		{fieldExtraJSON, p.ExtraJSON},
+	}
+
+	for _, field := range fields {
+		if field.value != "" {
+			data = protowire.AppendTag(data, field.num, protowire.BytesType)
+			data = protowire.AppendString(data, field.value)
+		}
+	}
+	return data
+}
+
+// Unmarshal decodes a pending message from the protocol buffer wire format. Unknown
+// fields, e.g. fields added in later versions of the specification, are skipped.
+func Unmarshal(data []byte) (_ *Pending, err error) {
+	p := &Pending{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ != protowire.BytesType || num < fieldEnvelopeID || num > fieldExtraJSON {
+			if n = protowire.ConsumeFieldValue(num, typ, data); n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+
+		var value string
+		if value, n = protowire.ConsumeString(data); n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch num {
+		case fieldEnvelopeID:
+			p.EnvelopeID = value
+		case fieldReceivedBy:
+			p.ReceivedBy = value
+		case fieldReceivedAt:
+			p.ReceivedAt, err = parseTime("received_at", value)
+		case fieldMessage:
+			p.Message = value
+		case fieldReplyNotAfter:
+			p.ReplyNotAfter, err = parseTime("reply_not_after", value)
+		case fieldReplyNotBefore:
+			p.ReplyNotBefore, err = parseTime("reply_not_before", value)
+		case fieldExtraJSON:
+			p.ExtraJSON = value
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Any encodes the pending message as a protocol buffer Any message.
+func (p *Pending) Any() (*anypb.Any, error) {
+	return &anypb.Any{TypeUrl: TypeURL, Value: p.Marshal()}, nil
+}
+
+// Payload returns a response payload with the pending message that echoes the identity
+// of the transfer back to the originator, as the TRISA spec requires.
+func (p *Pending) Payload(identity *ivms101.IdentityPayload) (payload *protocol.Payload, err error) {
+	payload = &protocol.Payload{}
+	if identity != nil {
+		if payload.Identity, err = anypb.New(identity); err != nil {
+			return nil, err
+		}
+	}
+	if payload.Transaction, err = p.Any(); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// IsPending returns true if the message is a pending message.
+func IsPending(msg *anypb.Any) bool {
+	if msg == nil {
+		return false
+	}
+	if msg.TypeUrl == TypeURL {
+		return true
+	}
+	if msg.TypeUrl == structURL {
+		_, err := fromStruct(msg)
+		return err == nil
+	}
+	return false
+}
+
+// FromAny decodes the pending message, returning ErrNotPending if the message is not a
+// pending message.
+func FromAny(msg *anypb.Any) (*Pending, error) {
+	switch {
+	case msg == nil:
+		return nil, ErrNotPending
+	case msg.TypeUrl == TypeURL:
+		return Unmarshal(msg.Value)
+	case msg.TypeUrl == structURL:
+		return fromStruct(msg)
+	}
+	return nil, ErrNotPending
+}
+
+// FromPayload extracts the pending message from the transaction of a response payload.
+// If the payload is not a pending message, ok is false.
+func FromPayload(payload *protocol.Payload) (_ *Pending, ok bool) {
+	p, err := FromAny(payload.GetTransaction())
+	if err != nil {
+		return nil, false
+	}
+	return p, true
+}
+
+// fromStruct decodes a pending message encoded as a struct by earlier versions.
+func fromStruct(msg *anypb.Any) (_ *Pending, err error) {
+	data := &structpb.Struct{}
+	if err = msg.UnmarshalTo(data); err != nil {
+		return nil, ErrNotPending
+	}
+
+	value, ok := data.Fields[pendingKey]
+	if !ok || value.GetStructValue() == nil {
+		return nil, ErrNotPending
+	}
+
+	fields := value.GetStructValue().Fields
+	p := &Pending{
+		EnvelopeID: fields["envelope_id"].GetStringValue(),
+		ReceivedBy: fields["received_by"].GetStringValue(),
+		Message:    fields["message"].GetStringValue(),
+		ExtraJSON:  fields["extra_json"].GetStringValue(),
+	}
+	if p.ReceivedAt, err = parseTime("received_at", fields["received_at"].GetStringValue()); err != nil {
+		return nil, err
+	}
+	if p.ReplyNotAfter, err = parseTime("reply_not_after", fields["reply_not_after"].GetStringValue()); err != nil {
+		return nil, err
+	}
+	if p.ReplyNotBefore, err = parseTime("reply_not_before", fields["reply_not_before"].GetStringValue()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func formatTime(ts time.Time) string {
+	if ts.IsZero() {
+		return ""
+	}
+	return ts.Format(time.RFC3339)
+}
+
+func parseTime(field, value string) (ts time.Time, err error) {
+	if value == "" {
+		return ts, nil
+	}
+	if ts, err = time.Parse(time.RFC3339, value); err != nil {
+		return ts, fmt.Errorf("could not parse %s of pending message: %s", field, err)
+	}
+	return ts, nil
+}
@@ -0,0 +1,428 @@
+package trisarl
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/pending"
+	"github.com/rotationalio/trisa/pkg/screening"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rotationalio/trisa/pkg/travelrule"
+	"github.com/rotationalio/trisa/pkg/webhooks"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+)
+
+// Names of the default stages of the transfer pipeline, in the order they are run.
+const (
+	StageMaintenance = "maintenance"
+	StageAuthn       = "authn"
+	StageRateLimit   = "ratelimit"
+	StageSize        = "size"
+	StageDuplicates  = "duplicates"
+	StageOpen        = "open"
+	StageValidate    = "validate"
+	StageTimestamps  = "timestamps"
+	StageScreen      = "screen"
+	StagePolicy      = "policy"
+	StageTravelRule  = "travelrule"
+	StageInquiry     = "inquiry"
+	StageHandle      = "handle"
+	StageSeal        = "seal"
+)
+
+// Transfer holds the state of an incoming secure envelope as it moves through the
+// transfer pipeline. Each stage populates the fields that later stages depend on.
+type Transfer struct {
+	Peer *peers.Peer
+
+	// Local is the common name of the TRISA identity hosted by the server that the
+	// envelope was sent to.
+	Local string
+	In    *protocol.SecureEnvelope
+
+	// Envelope is the decrypted envelope, set by the open stage.
+	Envelope *handler.Envelope
+
+	// Identity is the IVMS101 identity of the payload, set by the validate stage.
+	// Beneficiary inquiries do not have an identity.
+	Identity *ivms101.IdentityPayload
+
+	// Transaction is a generic transaction decoded by the validate stage, while Data
+	// holds transactions of payload types registered with WithPayloadType.
+	Transaction *generic.Transaction
+	Data        proto.Message
+
+	// Screening holds the sanctions list entries that the originators matched.
+	Screening []screening.Match
+
+	// Inquiry is set for beneficiary inquiries, which are answered by the inquiry stage
+	// rather than the handle stage.
+	Inquiry bool
+
+	// Duplicate is set if the envelope is a retransmission of an envelope that was
+	// already processed, which is answered by the duplicates stage and not recorded.
+	Duplicate bool
+
+	// Pending is set if the transfer handler deferred the decision, in which case the
+	// Response is a pending message.
+	Pending bool
+
+	// PendingMessage is set if the peer sent a pending message rather than a
+	// transaction, e.g. to defer its reply to a transfer sent by this node.
+	PendingMessage *pending.Pending
+
+	// Receipt is set if the peer sent the final acknowledgment of a completed
+	// transaction.
+	Receipt *generic.ConfirmationReceipt
+
+	// Policy of the peer and the TravelRule decision with the data the transfer
+	// requires, set by the policy and travel rule stages.
+	Policy     config.PeerPolicy
+	TravelRule travelrule.Decision
+
+	// Response is the payload set by the handle stage, which the seal stage encrypts
+	// into the Out envelope that is returned to the peer.
+	Response *protocol.Payload
+	Out      *protocol.SecureEnvelope
+}
+
+// Handler processes a transfer. Errors should be TRISA protocol errors so that they
+// can be returned to the peer, any other error is treated as an internal error.
+type Handler func(ctx context.Context, t *Transfer) error
+
+// Middleware wraps the next handler in the pipeline. A middleware may stop processing
+// the transfer by returning without calling next, usually with a protocol error.
+type Middleware func(next Handler) Handler
+
+// Stage is a named middleware in the transfer pipeline.
+type Stage struct {
+	Name       string
+	Middleware Middleware
+}
+
+// Pipeline is an ordered list of stages that each incoming transfer is run through.
+type Pipeline struct {
+	stages []Stage
+}
+
+// Stages returns the names of the stages in the order they are run.
+func (p *Pipeline) Stages() []string {
+	names := make([]string, 0, len(p.stages))
+	for _, stage := range p.stages {
+		names = append(names, stage.Name)
+	}
+	return names
+}
+
+// InsertBefore adds the stage to the pipeline immediately before the named stage.
+func (p *Pipeline) InsertBefore(name string, stage Stage) error {
+	return p.insert(name, 0, stage)
+}
+
+// InsertAfter adds the stage to the pipeline immediately after the named stage.
+func (p *Pipeline) InsertAfter(name string, stage Stage) error {
+	return p.insert(name, 1, stage)
+}
+
+// Replace the middleware of the named stage, e.g. to provide a custom handle stage.
+func (p *Pipeline) Replace(name string, mw Middleware) error {
+	idx, err := p.index(name)
+	if err != nil {
+		return err
+	}
+	p.stages[idx].Middleware = mw
+	return nil
+}
+
+// Handler composes the stages into a single handler that runs them in order.
+func (p *Pipeline) Handler() Handler {
+	h := Handler(func(context.Context, *Transfer) error { return nil })
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		h = p.stages[i].Middleware(h)
+	}
+	return h
+}
+
+func (p *Pipeline) insert(name string, offset int, stage Stage) error {
+	if stage.Name == "" || stage.Middleware == nil {
+		return fmt.Errorf("pipeline stages require a name and middleware")
+	}
+	if _, err := p.index(stage.Name); err == nil {
+		return fmt.Errorf("pipeline stage %q already exists", stage.Name)
+	}
+
+	idx, err := p.index(name)
+	if err != nil {
+		return err
+	}
+	idx += offset
+
+	p.stages = append(p.stages, Stage{})
+	copy(p.stages[idx+1:], p.stages[idx:])
+	p.stages[idx] = stage
+	return nil
+}
+
+func (p *Pipeline) index(name string) (int, error) {
+	for i, stage := range p.stages {
+		if stage.Name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("unknown pipeline stage %q", name)
+}
+
+// Creates the default transfer pipeline of the server.
+func (s *Server) pipeline() *Pipeline {
+	return &Pipeline{
+		stages: []Stage{
+			{StageMaintenance, s.maintenance},
+			{StageAuthn, s.authn},
+			{StageRateLimit, s.ratelimit},
+			{StageSize, s.size},
+			{StageDuplicates, s.duplicates},
+			{StageOpen, s.open},
+			{StageValidate, s.validate},
+			{StageTimestamps, s.timestamps},
+			{StageScreen, s.screen},
+			{StagePolicy, s.policy},
+			{StageTravelRule, s.travelRule},
+			{StageInquiry, s.inquiry},
+			{StageHandle, s.handle},
+			{StageSeal, s.seal},
+		},
+	}
+}
+
+// Run the transfer pipeline on the incoming secure envelope from the peer.
+func (s *Server) handleTransaction(ctx context.Context, peer *peers.Peer, in *protocol.SecureEnvelope) (out *protocol.SecureEnvelope, err error) {
+	ctx, span := s.tracer.Start(ctx, "trisarl.Transfer", trace.WithAttributes(
+		attribute.String("trisarl.peer", peer.String()),
+		attribute.String("trisarl.envelope_id", in.Id),
+	))
+	defer func() { endSpan(span, err) }()
+
+	// Recover here as well as in the interceptors so that a panic while handling one
+	// message on a transfer stream is returned as an error without closing the stream.
+	defer func() {
+		if r := recover(); r != nil {
+			out, err = nil, s.recovered(ctx, "transfer", r)
+		}
+	}()
+
+	atomic.AddInt64(&s.inflight, 1)
+	defer atomic.AddInt64(&s.inflight, -1)
+
+	t := &Transfer{Peer: peer, Local: s.commonNameFor(ctx), In: in}
+	ctx = withLocalIdentity(withEnvelopeID(ctx, in.Id), t.Local)
+	started := time.Now()
+	err = s.transfer(ctx, t)
+	s.metrics.observeTransfer(t, err, started)
+	if !t.Duplicate {
+		if !t.Inquiry {
+			s.publish(webhooks.TransferReceived, in.Id, peer.String(), "", "")
+		}
+		s.emit(receivedEvent(t, err))
+		s.recordTransfer(ctx, t, err)
+		s.trackTransaction(ctx, t, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Out == nil {
+		log.Ctx(ctx).Error().Str("id", in.Id).Msg("transfer pipeline did not produce a response")
+		return nil, protocol.Errorf(protocol.InternalError, "could not process transfer")
+	}
+	return t.Out, nil
+}
+
+// Reject transfers with a retryable error in maintenance mode and during planned
+// maintenance windows so that peers resend them once the maintenance is over.
+func (s *Server) maintenance(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		now := time.Now()
+		conf, schedule := s.maintenanceState()
+		if conf.Maintenance {
+			log.Ctx(ctx).Debug().Msg("transfer rejected during maintenance")
+			return &protocol.Error{
+				Code:    protocol.Unavailable,
+				Message: "the server is in maintenance mode, please retry later",
+				Retry:   true,
+			}
+		}
+
+		if window, ok := schedule.Next(now); ok && window.Contains(now) {
+			log.Ctx(ctx).Debug().Time("until", window.End).Msg("transfer rejected during planned maintenance")
+			return &protocol.Error{
+				Code:    protocol.Unavailable,
+				Message: fmt.Sprintf("planned maintenance until %s", window.End.Format(time.RFC3339)),
+				Retry:   true,
+			}
+		}
+		return next(ctx, t)
+	}
+}
+
+// Ensure the peer is verified and that its signing key is available to send a response.
+func (s *Server) authn(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		if t.Peer == nil {
+			if t.Peer, err = s.lookupPeer(ctx); err != nil {
+				return &protocol.Error{
+					Code:    protocol.Unverified,
+					Message: err.Error(),
+				}
+			}
+		}
+
+		if s.peerKey(t.Peer) == nil {
+			log.Ctx(ctx).Warn().Str("peer", t.Peer.String()).Msg("no signing key available")
+			return &protocol.Error{
+				Code:    protocol.NoSigningKey,
+				Message: "please retry transfer after key exchange",
+				Retry:   true,
+			}
+		}
+		return next(ctx, t)
+	}
+}
+
+// Decrypt the encryption key and HMAC secret with private signing keys (asymmetric phase)
+// Note that the envelope.Open function will return a TRISA protocol error. Envelopes
+// whose HMAC signature does not verify are rejected as a security event, since the
+// payload may have been tampered with.
+func (s *Server) open(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		_, key := s.signingFor(ctx)
+		if t.Envelope, err = s.openEnvelope(t.In, t.Peer.String(), key); err != nil {
+			if perr, ok := err.(*protocol.Error); ok && perr.Code == protocol.InvalidSignature {
+				s.securityEvent(ctx, store.EventInvalidSignature, t, err)
+				return err
+			}
+			log.Ctx(ctx).Error().Err(err).Msg("could not open secure envelope")
+			return err
+		}
+		return next(ctx, t)
+	}
+}
+
+// Reject secure envelopes over the maximum size before they are decrypted, so that peers
+// cannot exhaust the memory of the server by sending giant encrypted payloads.
+func (s *Server) size(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		limit := s.config().MaxEnvelopeSize
+		if size := proto.Size(t.In); limit > 0 && size > limit {
+			log.Ctx(ctx).Warn().Int("size", size).Int("max_envelope_size", limit).Msg("secure envelope too large")
+			return protocol.Errorf(protocol.BadRequest, "secure envelope of %d bytes exceeds the maximum envelope size of %d bytes", size, limit)
+		}
+		return next(ctx, t)
+	}
+}
+
+// Respond to the transfer with the transfer handler of the server, which returns the
+// beneficiary information, e.g. loaded from the database of the VASP. If no transfer
+// handler is configured the transfer is echoed in echo mode or answered from the address
+// registry if it is enabled, otherwise it is rejected with the configured error, which
+// is a no compliance error unless configured otherwise; echo mode and the registry only
+// answer generic transactions. Pending messages and final acknowledgments from the peer
+// are answered without calling the transfer handler.
+func (s *Server) handle(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		if t.PendingMessage != nil {
+			if err = s.awaitReply(ctx, t); err != nil {
+				return err
+			}
+			return next(ctx, t)
+		}
+
+		if t.Receipt != nil {
+			if err = s.closeTransaction(ctx, t); err != nil {
+				return err
+			}
+			return next(ctx, t)
+		}
+
+		conf := s.config()
+		respond := s.handler
+		if respond == nil && conf.Echo && t.Transaction != nil {
+			respond = TransferHandlerFunc(s.echo)
+		}
+		if respond == nil && conf.Registry && t.Transaction != nil {
+			respond = TransferHandlerFunc(s.registry)
+		}
+
+		if respond == nil {
+			rejection := conf.Rejection
+			return &protocol.Error{
+				Code:    protocol.Error_Code(rejection.Code),
+				Message: rejection.Message,
+				Retry:   rejection.Retry,
+			}
+		}
+
+		s.receiveTransaction(ctx, t)
+		if t.Response, err = respond.HandleTransfer(ctx, t.Peer, t.Identity, t.Transaction); err != nil {
+			if perr, ok := err.(*PendingError); ok {
+				if t.Response, err = s.pend(ctx, t, perr); err != nil {
+					if perr, ok := err.(*protocol.Error); ok {
+						return perr
+					}
+					log.Ctx(ctx).Error().Err(err).Str("peer", t.Peer.String()).Msg("could not queue transfer for review")
+					return protocol.Errorf(protocol.InternalError, "could not handle transfer")
+				}
+				return next(ctx, t)
+			}
+			if perr, ok := err.(*protocol.Error); ok {
+				return perr
+			}
+			log.Ctx(ctx).Error().Err(err).Str("peer", t.Peer.String()).Msg("could not handle transfer")
+			return protocol.Errorf(protocol.InternalError, "could not handle transfer")
+		}
+
+		if t.Response == nil {
+			log.Ctx(ctx).Error().Str("peer", t.Peer.String()).Msg("transfer handler did not return a response")
+			return protocol.Errorf(protocol.InternalError, "could not handle transfer")
+		}
+		return next(ctx, t)
+	}
+}
+
+// Encrypt the response payload with the peer's public signing key. If no response
+// was set by the handle stage, the transfer is passed on unsealed. Generic transactions
+// in the response are stamped with the time the response was sealed if the handler did
+// not stamp when the transfer was received.
+func (s *Server) seal(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		if t.Response != nil {
+			if err = s.sealResponse(t); err != nil {
+				return err
+			}
+		}
+		return next(ctx, t)
+	}
+}
+
+func (s *Server) sealResponse(t *Transfer) (err error) {
+	if t.Response, err = stampTransaction(t.Response, fieldReceivedAt, time.Now(), false); err != nil {
+		log.Error().Err(err).Msg("could not stamp response received at")
+		return err
+	}
+
+	if t.Out, err = s.sealEnvelope(t.Peer.String(), handler.New(t.In.Id, t.Response, nil), s.peerKey(t.Peer)); err != nil {
+		log.Error().Err(err).Msg("could not seal secure envelope")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package trisarl
+
+import (
+	"context"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Enforce the policy configured for the peer: the allowed payload types, the maximum
+// transfer amount, and the daily quotas of transfers and cumulative amount (the rate
+// limit of the policy is enforced by the rate limit stage before the envelope is
+// opened). Transfers that are rejected by a later stage do not count towards the daily
+// quotas. The policy is added to the transfer so that the handle stage can determine
+// if the transfer requires manual review.
+func (s *Server) policy(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		commonName := t.Peer.String()
+		policy := s.config().Peers.Get(commonName)
+
+		payload := t.Envelope.Payload
+		for _, data := range []*anypb.Any{payload.Identity, payload.Transaction} {
+			if data != nil && data.TypeUrl != "" && !policy.Allows(data.TypeUrl) {
+				log.Ctx(ctx).Warn().Str("peer", commonName).Str("type", data.TypeUrl).Msg("payload type not allowed by peer policy")
+				return protocol.Errorf(protocol.Rejected, "payload type %s is not accepted, accepted types: %s", data.TypeUrl, listTypes(config.TypeURLs(policy.PayloadTypes)))
+			}
+		}
+
+		if policy.MaxAmount > 0 && t.Transaction != nil && t.Transaction.Amount > policy.MaxAmount {
+			log.Ctx(ctx).Warn().Str("peer", commonName).Float64("amount", t.Transaction.Amount).Msg("transfer amount exceeds peer policy")
+			return protocol.Errorf(protocol.ExceededTradingVolume, "transfer amount exceeds the maximum of %g", policy.MaxAmount)
+		}
+
+		t.Policy = policy
+		if (policy.DailyTransfers == 0 && policy.DailyAmount == 0) || !counted(t) {
+			return next(ctx, t)
+		}
+
+		var (
+			unit   string
+			amount float64
+		)
+		if unit, amount, err = quotaAmount(policy, s.config().TravelRule.Rates, t.Transaction); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Str("peer", commonName).Msg("transfer amount cannot be counted towards daily quota of peer policy")
+			return err
+		}
+
+		var release func()
+		if release, err = s.reserveQuota(commonName, policy, unit, amount, time.Now()); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Str("peer", commonName).Str("unit", unit).Float64("amount", amount).Msg("transfer exceeds daily quota of peer policy")
+			return err
+		}
+
+		if err = next(ctx, t); err != nil {
+			release()
+		}
+		return err
+	}
+}
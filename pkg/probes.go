@@ -0,0 +1,124 @@
+package trisarl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Probe is the response of the liveness and readiness endpoints of the probes listener.
+// Checks maps the name of each readiness check to "ok" or the reason the check failed.
+type Probe struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Status of the probes.
+const (
+	ProbeOK       = "ok"
+	ProbeNotReady = "not ready"
+)
+
+// serveProbes serves the Kubernetes liveness and readiness probes on a separate plain
+// HTTP listener, since the kubelet cannot present TRISA mTLS credentials.
+func (s *Server) serveProbes(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+
+	s.probesSrv = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Info().Str("listen", addr).Msg("probes server started")
+		if err := s.probesSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("probes server stopped")
+		}
+	}()
+}
+
+// healthz responds ok as long as the process is able to serve HTTP requests; the server
+// remains live while it is draining so that it is not killed before the drain timeout.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, http.StatusOK, &Probe{Status: ProbeOK})
+}
+
+// readyz responds ok if the server should receive traffic: all of the TRISA listeners
+// are serving, the certificates are valid, and the server is neither in maintenance
+// nor shutting down. Otherwise it responds 503 with the checks that failed.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	probe := &Probe{Status: ProbeOK, Checks: s.readiness(time.Now())}
+	code := http.StatusOK
+	for _, check := range probe.Checks {
+		if check != ProbeOK {
+			probe.Status = ProbeNotReady
+			code = http.StatusServiceUnavailable
+			break
+		}
+	}
+	writeProbe(w, code, probe)
+}
+
+// readiness runs the readiness checks of the server.
+func (s *Server) readiness(now time.Time) map[string]string {
+	checks := map[string]string{
+		"listeners":    ProbeOK,
+		"certificates": ProbeOK,
+		"maintenance":  ProbeOK,
+		"draining":     ProbeOK,
+	}
+
+	if serving, expected := atomic.LoadInt32(&s.listening), atomic.LoadInt32(&s.nlisteners); expected == 0 || serving < expected {
+		checks["listeners"] = fmt.Sprintf("%d of %d listeners serving", serving, expected)
+	}
+
+	// Invalid certificates are allowed in development, e.g. self-signed certificates
+	conf, schedule := s.maintenanceState()
+	certs, pool := s.certificates()
+	if err := verifyCertificates(certs, pool); err != nil && !conf.Environment.IsDevelopment() {
+		checks["certificates"] = err.Error()
+	}
+
+	if conf.Maintenance {
+		checks["maintenance"] = "maintenance mode"
+	} else if schedule.Active(now) {
+		checks["maintenance"] = "planned maintenance window"
+	}
+
+	select {
+	case <-s.draining:
+		checks["draining"] = "server is shutting down"
+	default:
+	}
+	return checks
+}
+
+func writeProbe(w http.ResponseWriter, code int, probe *Probe) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(probe); err != nil {
+		log.Warn().Err(err).Msg("could not write probe")
+	}
+}
+
+// shutdownProbes stops the probes HTTP listener if it was started.
+func (s *Server) shutdownProbes() {
+	if s.probesSrv == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.probesSrv.Shutdown(ctx); err != nil {
+		log.Warn().Err(err).Msg("could not shutdown probes server")
+	}
+}
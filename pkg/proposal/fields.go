@@ -0,0 +1,153 @@
+package proposal
+
+import "github.com/trisacrypto/trisa/pkg/ivms101"
+
+// Field is a path to an IVMS101 identity field that can be requested in a
+// counter-proposal, e.g. "originator.date_of_birth".
+type Field string
+
+// Identity fields that can be requested in a counter-proposal.
+const (
+	OriginatorName                   Field = "originator.name"
+	OriginatorGeographicAddress      Field = "originator.geographic_address"
+	OriginatorNationalIdentification Field = "originator.national_identification"
+	OriginatorCustomerIdentification Field = "originator.customer_identification"
+	OriginatorDateOfBirth            Field = "originator.date_of_birth"
+	OriginatorPlaceOfBirth           Field = "originator.place_of_birth"
+	OriginatorCountryOfResidence     Field = "originator.country_of_residence"
+	OriginatorAccountNumber          Field = "originator.account_number"
+
+	BeneficiaryName                   Field = "beneficiary.name"
+	BeneficiaryGeographicAddress      Field = "beneficiary.geographic_address"
+	BeneficiaryNationalIdentification Field = "beneficiary.national_identification"
+	BeneficiaryCustomerIdentification Field = "beneficiary.customer_identification"
+	BeneficiaryDateOfBirth            Field = "beneficiary.date_of_birth"
+	BeneficiaryPlaceOfBirth           Field = "beneficiary.place_of_birth"
+	BeneficiaryCountryOfResidence     Field = "beneficiary.country_of_residence"
+	BeneficiaryAccountNumber          Field = "beneficiary.account_number"
+)
+
+type fieldSpec struct {
+	description string
+	present     func(*ivms101.IdentityPayload) bool
+}
+
+var fields = map[Field]fieldSpec{
+	OriginatorName:                    {"originator name is required", originators(hasName)},
+	OriginatorGeographicAddress:       {"originator geographic address is required", originators(hasAddress)},
+	OriginatorNationalIdentification:  {"originator national identification is required", originators(hasNationalID)},
+	OriginatorCustomerIdentification:  {"originator customer identification is required", originators(hasCustomerID)},
+	OriginatorDateOfBirth:             {"originator date of birth is required", originators(hasDateOfBirth)},
+	OriginatorPlaceOfBirth:            {"originator place of birth is required", originators(hasPlaceOfBirth)},
+	OriginatorCountryOfResidence:      {"originator country of residence is required", originators(hasCountryOfResidence)},
+	OriginatorAccountNumber:           {"originator account number is required", func(i *ivms101.IdentityPayload) bool { return len(i.GetOriginator().GetAccountNumbers()) > 0 }},
+	BeneficiaryName:                   {"beneficiary name is required", beneficiaries(hasName)},
+	BeneficiaryGeographicAddress:      {"beneficiary geographic address is required", beneficiaries(hasAddress)},
+	BeneficiaryNationalIdentification: {"beneficiary national identification is required", beneficiaries(hasNationalID)},
+	BeneficiaryCustomerIdentification: {"beneficiary customer identification is required", beneficiaries(hasCustomerID)},
+	BeneficiaryDateOfBirth:            {"beneficiary date of birth is required", beneficiaries(hasDateOfBirth)},
+	BeneficiaryPlaceOfBirth:           {"beneficiary place of birth is required", beneficiaries(hasPlaceOfBirth)},
+	BeneficiaryCountryOfResidence:     {"beneficiary country of residence is required", beneficiaries(hasCountryOfResidence)},
+	BeneficiaryAccountNumber:          {"beneficiary account number is required", func(i *ivms101.IdentityPayload) bool { return len(i.GetBeneficiary().GetAccountNumbers()) > 0 }},
+}
+
+// Known returns true if the field can be checked and satisfied automatically.
+func (f Field) Known() bool {
+	_, ok := fields[f]
+	return ok
+}
+
+// Description returns a human readable description of the requirement.
+func (f Field) Description() string {
+	if spec, ok := fields[f]; ok {
+		return spec.description
+	}
+	return string(f) + " is required"
+}
+
+// Present returns true if the field is populated in the identity payload. Unknown
+// fields are never considered present.
+func (f Field) Present(identity *ivms101.IdentityPayload) bool {
+	if spec, ok := fields[f]; ok && identity != nil {
+		return spec.present(identity)
+	}
+	return false
+}
+
+// The field is present if all of the originator persons have it.
+func originators(fn func(*ivms101.Person) bool) func(*ivms101.IdentityPayload) bool {
+	return func(identity *ivms101.IdentityPayload) bool {
+		return all(identity.GetOriginator().GetOriginatorPersons(), fn)
+	}
+}
+
+// The field is present if all of the beneficiary persons have it.
+func beneficiaries(fn func(*ivms101.Person) bool) func(*ivms101.IdentityPayload) bool {
+	return func(identity *ivms101.IdentityPayload) bool {
+		return all(identity.GetBeneficiary().GetBeneficiaryPersons(), fn)
+	}
+}
+
+func all(persons []*ivms101.Person, fn func(*ivms101.Person) bool) bool {
+	if len(persons) == 0 {
+		return false
+	}
+
+	for _, person := range persons {
+		if !fn(person) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasName(p *ivms101.Person) bool {
+	if np := p.GetNaturalPerson(); np != nil {
+		return len(np.GetName().GetNameIdentifiers()) > 0
+	}
+	return len(p.GetLegalPerson().GetName().GetNameIdentifiers()) > 0
+}
+
+func hasAddress(p *ivms101.Person) bool {
+	if np := p.GetNaturalPerson(); np != nil {
+		return len(np.GeographicAddresses) > 0
+	}
+	return len(p.GetLegalPerson().GetGeographicAddresses()) > 0
+}
+
+func hasNationalID(p *ivms101.Person) bool {
+	if np := p.GetNaturalPerson(); np != nil {
+		return np.GetNationalIdentification().GetNationalIdentifier() != ""
+	}
+	return p.GetLegalPerson().GetNationalIdentification().GetNationalIdentifier() != ""
+}
+
+func hasCustomerID(p *ivms101.Person) bool {
+	if np := p.GetNaturalPerson(); np != nil {
+		return np.CustomerIdentification != ""
+	}
+	return p.GetLegalPerson().GetCustomerNumber() != ""
+}
+
+// Legal persons do not have a date or place of birth so they always satisfy these.
+func hasDateOfBirth(p *ivms101.Person) bool {
+	if np := p.GetNaturalPerson(); np != nil {
+		return np.GetDateAndPlaceOfBirth().GetDateOfBirth() != ""
+	}
+	return p.GetLegalPerson() != nil
+}
+
+func hasPlaceOfBirth(p *ivms101.Person) bool {
+	if np := p.GetNaturalPerson(); np != nil {
+		return np.GetDateAndPlaceOfBirth().GetPlaceOfBirth() != ""
+	}
+	return p.GetLegalPerson() != nil
+}
+
+// Legal persons have a country of registration rather than a country of residence.
+func hasCountryOfResidence(p *ivms101.Person) bool {
+	if np := p.GetNaturalPerson(); np != nil {
+		return np.CountryOfResidence != ""
+	}
+	return p.GetLegalPerson().GetCountryOfRegistration() != ""
+}
@@ -0,0 +1,163 @@
+/*
+Package proposal implements counter-proposals that can be attached to TRISA rejections.
+A counter-proposal is a machine-readable list of requirements (e.g. "resend with the
+originator date of birth included") that a counterparty running this software can
+automatically satisfy and resend, reducing manual back-and-forth between compliance
+teams. Counter-proposals are encoded as a google.protobuf.Struct in the Details field
+of the TRISA protocol error so that they are backwards compatible with all peers.
+*/
+package proposal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The key in the error details that identifies the struct as a counter-proposal.
+const detailsKey = "counter_proposal"
+
+// CounterProposal describes the requirements a counterparty must satisfy for the
+// transfer to be accepted if it is resent.
+type CounterProposal struct {
+	Message      string
+	Requirements []Requirement
+}
+
+// Requirement is a single machine-readable requirement of a counter-proposal.
+type Requirement struct {
+	Field       Field
+	Description string
+}
+
+// New creates a counter-proposal requiring the specified fields.
+func New(message string, fields ...Field) *CounterProposal {
+	cp := &CounterProposal{Message: message, Requirements: make([]Requirement, 0, len(fields))}
+	for _, field := range fields {
+		cp.Require(field, "")
+	}
+	return cp
+}
+
+// Require adds a requirement to the counter-proposal.
+func (c *CounterProposal) Require(field Field, description string) *CounterProposal {
+	if description == "" {
+		description = field.Description()
+	}
+	c.Requirements = append(c.Requirements, Requirement{Field: field, Description: description})
+	return c
+}
+
+// Reject creates a TRISA protocol error with the counter-proposal in the details. The
+// rejection is marked as retryable since the counterparty is expected to resend.
+func (c *CounterProposal) Reject(code protocol.Error_Code) (_ *protocol.Error, err error) {
+	var details *anypb.Any
+	if details, err = c.Details(); err != nil {
+		return nil, err
+	}
+
+	return &protocol.Error{
+		Code:    code,
+		Message: c.Message,
+		Retry:   true,
+		Details: details,
+	}, nil
+}
+
+// Details encodes the counter-proposal as a protocol buffer Any message.
+func (c *CounterProposal) Details() (_ *anypb.Any, err error) {
+	requirements := make([]interface{}, 0, len(c.Requirements))
+	for _, req := range c.Requirements {
+		requirements = append(requirements, map[string]interface{}{
+			"field":       string(req.Field),
+			"description": req.Description,
+		})
+	}
+
+	var details *structpb.Struct
+	if details, err = structpb.NewStruct(map[string]interface{}{
+		detailsKey: map[string]interface{}{
+			"message":      c.Message,
+			"requirements": requirements,
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("could not encode counter-proposal: %s", err)
+	}
+	return anypb.New(details)
+}
+
+// FromError extracts a counter-proposal from a TRISA protocol error. If the error has
+// no details or the details are not a counter-proposal, ok is false.
+func FromError(e *protocol.Error) (_ *CounterProposal, ok bool) {
+	if e == nil || e.Details == nil {
+		return nil, false
+	}
+
+	details := &structpb.Struct{}
+	if err := e.Details.UnmarshalTo(details); err != nil {
+		return nil, false
+	}
+
+	var value *structpb.Value
+	if value, ok = details.Fields[detailsKey]; !ok || value.GetStructValue() == nil {
+		return nil, false
+	}
+
+	cpv := value.GetStructValue().Fields
+	cp := &CounterProposal{Message: cpv["message"].GetStringValue()}
+	for _, item := range cpv["requirements"].GetListValue().GetValues() {
+		req := item.GetStructValue().GetFields()
+		cp.Requirements = append(cp.Requirements, Requirement{
+			Field:       Field(req["field"].GetStringValue()),
+			Description: req["description"].GetStringValue(),
+		})
+	}
+	return cp, true
+}
+
+// Unsatisfied returns the requirements that are not met by the identity payload.
+func (c *CounterProposal) Unsatisfied(identity *ivms101.IdentityPayload) []Requirement {
+	unsatisfied := make([]Requirement, 0, len(c.Requirements))
+	for _, req := range c.Requirements {
+		if !req.Field.Present(identity) {
+			unsatisfied = append(unsatisfied, req)
+		}
+	}
+	return unsatisfied
+}
+
+// Provider is implemented by integrators that can look up customer data requested by a
+// counter-proposal, e.g. from a KYC database, and add it to the identity payload.
+type Provider interface {
+	Provide(ctx context.Context, req Requirement, identity *ivms101.IdentityPayload) error
+}
+
+// ErrUnsatisfied is returned when a counter-proposal cannot be automatically satisfied.
+var ErrUnsatisfied = errors.New("could not satisfy all counter-proposal requirements")
+
+// Satisfy attempts to automatically satisfy the counter-proposal by asking the provider
+// to fill in each missing field of the identity payload. The identity payload is
+// modified in place; if any requirements cannot be satisfied they are returned along
+// with ErrUnsatisfied so that the transfer can be escalated to manual review.
+func (c *CounterProposal) Satisfy(ctx context.Context, identity *ivms101.IdentityPayload, p Provider) (unsatisfied []Requirement, err error) {
+	for _, req := range c.Unsatisfied(identity) {
+		if !req.Field.Known() {
+			unsatisfied = append(unsatisfied, req)
+			continue
+		}
+
+		if err = p.Provide(ctx, req, identity); err != nil || !req.Field.Present(identity) {
+			unsatisfied = append(unsatisfied, req)
+		}
+	}
+
+	if len(unsatisfied) > 0 {
+		return unsatisfied, ErrUnsatisfied
+	}
+	return nil, nil
+}
@@ -0,0 +1,77 @@
+package proposal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+)
+
+func TestRejectFromError(t *testing.T) {
+	cp := New("date of birth required", OriginatorDateOfBirth).Require(Field("originator.tax_id"), "tax id required")
+	rejection, err := cp.Reject(protocol.IncompleteIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rejection.Retry || rejection.Code != protocol.IncompleteIdentity {
+		t.Fatalf("unexpected rejection %v", rejection)
+	}
+
+	decoded, ok := FromError(rejection)
+	if !ok {
+		t.Fatal("counter-proposal was not decoded")
+	}
+	if decoded.Message != cp.Message || len(decoded.Requirements) != 2 {
+		t.Fatalf("unexpected counter-proposal %+v", decoded)
+	}
+	for i, req := range decoded.Requirements {
+		if req != cp.Requirements[i] {
+			t.Errorf("requirement %d: expected %+v, got %+v", i, cp.Requirements[i], req)
+		}
+	}
+
+	if _, ok = FromError(&protocol.Error{Code: protocol.Rejected}); ok {
+		t.Error("error without details decoded as a counter-proposal")
+	}
+}
+
+type birthdays struct{}
+
+func (birthdays) Provide(_ context.Context, req Requirement, identity *ivms101.IdentityPayload) error {
+	if req.Field != OriginatorDateOfBirth {
+		return errors.New("not found")
+	}
+	for _, person := range identity.Originator.OriginatorPersons {
+		person.GetNaturalPerson().DateAndPlaceOfBirth = &ivms101.DateAndPlaceOfBirth{DateOfBirth: "1970-01-01"}
+	}
+	return nil
+}
+
+func identity() *ivms101.IdentityPayload {
+	return &ivms101.IdentityPayload{
+		Originator: &ivms101.Originator{
+			OriginatorPersons: []*ivms101.Person{
+				{Person: &ivms101.Person_NaturalPerson{NaturalPerson: &ivms101.NaturalPerson{}}},
+			},
+		},
+	}
+}
+
+func TestSatisfy(t *testing.T) {
+	cp := New("date of birth required", OriginatorDateOfBirth)
+	id := identity()
+	if unsatisfied, err := cp.Satisfy(context.Background(), id, birthdays{}); err != nil || len(unsatisfied) > 0 {
+		t.Fatalf("could not satisfy counter-proposal: %v %v", unsatisfied, err)
+	}
+	if !OriginatorDateOfBirth.Present(id) {
+		t.Error("date of birth was not provided")
+	}
+
+	cp = New("more required", OriginatorDateOfBirth, OriginatorNationalIdentification, Field("originator.tax_id"))
+	unsatisfied, err := cp.Satisfy(context.Background(), identity(), birthdays{})
+	if !errors.Is(err, ErrUnsatisfied) || len(unsatisfied) != 2 {
+		t.Fatalf("expected two unsatisfied requirements, got %v %v", unsatisfied, err)
+	}
+}
@@ -0,0 +1,105 @@
+package trisarl
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rotationalio/trisa/pkg/travelrule"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+)
+
+// counted returns true if the transfer counts towards the daily quotas of the peer;
+// beneficiary inquiries, pending messages, and final acknowledgments are not transfers.
+func counted(t *Transfer) bool {
+	return t.Identity != nil && t.PendingMessage == nil
+}
+
+// quotaAmount returns the amount of the transaction that counts towards the daily
+// amount of the peer's policy and the asset or currency it is counted in. Amounts are
+// counted per asset unless the policy has a currency, in which case transfers whose
+// asset has no rate in the currency are rejected since their amount cannot be capped.
+func quotaAmount(policy config.PeerPolicy, rates config.AssetRates, transaction *generic.Transaction) (unit string, amount float64, err error) {
+	unit, amount = travelrule.Asset(transaction), transaction.GetAmount()
+	if policy.Currency == "" || amount == 0 {
+		return unit, amount, nil
+	}
+
+	var ok bool
+	if amount, ok = rates.Convert(amount, unit, policy.Currency); !ok {
+		return "", 0, quotaExceeded("the amount of %s cannot be converted to %s to check the daily quota", unit, policy.Currency)
+	}
+	return policy.Currency, amount, nil
+}
+
+// reserveQuota counts the transfer towards the daily quotas of the peer's policy in the
+// store, returning an exceeded trading volume error if the transfer would exceed the
+// maximum number of transfers or the maximum cumulative amount of the asset or currency
+// on the day. The transfer is reserved before it is handled so that concurrent
+// transfers cannot exceed the quotas, and the returned function releases the
+// reservation if the transfer is rejected. The usage of previous days is pruned when
+// the day changes.
+func (s *Server) reserveQuota(commonName string, policy config.PeerPolicy, unit string, amount float64, now time.Time) (release func(), err error) {
+	day := now.UTC().Format("2006-01-02")
+	s.pruneQuotas(day)
+
+	if err = s.db.UpdateQuota(commonName, day, func(q *store.Quota) error {
+		switch {
+		case policy.DailyTransfers > 0 && q.Transfers >= policy.DailyTransfers:
+			return quotaExceeded("the daily quota of %d transfers has been reached", policy.DailyTransfers)
+		case policy.DailyAmount > 0 && q.Amounts[unit]+amount > policy.DailyAmount:
+			return quotaExceeded("transfer amount exceeds the remaining daily quota of %g %s", policy.DailyAmount-q.Amounts[unit], unit)
+		}
+
+		q.Transfers++
+		q.Amounts[unit] += amount
+		return nil
+	}); err != nil {
+		if _, ok := err.(*protocol.Error); ok {
+			return nil, err
+		}
+		log.Error().Err(err).Str("peer", commonName).Msg("could not reserve daily quota")
+		return nil, protocol.Errorf(protocol.Unavailable, "could not check the daily quota").WithRetry()
+	}
+
+	return func() {
+		if err := s.db.UpdateQuota(commonName, day, func(q *store.Quota) error {
+			q.Transfers--
+			q.Amounts[unit] -= amount
+			return nil
+		}); err != nil {
+			log.Error().Err(err).Str("peer", commonName).Msg("could not release daily quota")
+		}
+	}, nil
+}
+
+// pruneQuotas removes the usage of the days before the day once per day.
+func (s *Server) pruneQuotas(day string) {
+	s.quotamu.Lock()
+	defer s.quotamu.Unlock()
+	if s.quotaDay == day {
+		return
+	}
+
+	if n, err := s.db.DeleteQuotasBefore(day); err != nil {
+		log.Warn().Err(err).Msg("could not prune daily quotas")
+		return
+	} else if n > 0 {
+		log.Debug().Int("quotas", n).Msg("pruned daily quotas of previous days")
+	}
+	s.quotaDay = day
+}
+
+// quotaExceeded returns the error that transfers over the daily quotas of the peer's
+// policy are rejected with. The error is not retryable since the quota is only reset
+// at the start of the next UTC day.
+func quotaExceeded(format string, a ...interface{}) *protocol.Error {
+	return &protocol.Error{
+		Code:    protocol.ExceededTradingVolume,
+		Message: "quota exceeded: " + fmt.Sprintf(format, a...),
+		Retry:   false,
+	}
+}
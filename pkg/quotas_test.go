@@ -0,0 +1,120 @@
+package trisarl
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/store"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+)
+
+func storeServer(t *testing.T, path string) *Server {
+	t.Helper()
+	db, err := store.Open(config.StorageConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &Server{db: db}
+}
+
+func exceeded(err error) bool {
+	perr, ok := err.(*protocol.Error)
+	return ok && perr.Code == protocol.ExceededTradingVolume
+}
+
+func TestDailyTransfersQuota(t *testing.T) {
+	s := storeServer(t, "")
+	policy := config.PeerPolicy{DailyTransfers: 2}
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	release, err := s.reserveQuota("peer", policy, "BTC", 1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.reserveQuota("peer", policy, "BTC", 1, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.reserveQuota("peer", policy, "BTC", 1, now); !exceeded(err) {
+		t.Fatalf("expected quota exceeded, got %v", err)
+	}
+
+	// Rejected transfers release their reservation
+	release()
+	if _, err = s.reserveQuota("peer", policy, "BTC", 1, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Quotas are per peer and per day
+	if _, err = s.reserveQuota("other", policy, "BTC", 1, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.reserveQuota("peer", policy, "BTC", 1, now.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDailyAmountQuota(t *testing.T) {
+	s := storeServer(t, "")
+	policy := config.PeerPolicy{DailyAmount: 10}
+	now := time.Now()
+
+	if _, err := s.reserveQuota("peer", policy, "BTC", 8, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.reserveQuota("peer", policy, "BTC", 3, now); !exceeded(err) {
+		t.Fatalf("expected quota exceeded, got %v", err)
+	}
+
+	// Amounts of other assets are counted separately
+	if _, err := s.reserveQuota("peer", policy, "ETH", 9, now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuotaAmount(t *testing.T) {
+	rates := config.AssetRates{"BTC": {"USD": 50000}}
+	btc := &generic.Transaction{Network: "btc", Amount: 0.5}
+	eth := &generic.Transaction{Network: "eth", Amount: 2}
+
+	unit, amount, err := quotaAmount(config.PeerPolicy{}, rates, btc)
+	if err != nil || unit != "BTC" || amount != 0.5 {
+		t.Fatalf("unexpected amount %g %s: %v", amount, unit, err)
+	}
+
+	policy := config.PeerPolicy{Currency: "USD"}
+	if unit, amount, err = quotaAmount(policy, rates, btc); err != nil || unit != "USD" || amount != 25000 {
+		t.Fatalf("unexpected amount %g %s: %v", amount, unit, err)
+	}
+	if _, _, err = quotaAmount(policy, rates, eth); !exceeded(err) {
+		t.Fatalf("expected unpriced asset to be rejected, got %v", err)
+	}
+}
+
+func TestQuotasPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	policy := config.PeerPolicy{DailyTransfers: 1}
+	now := time.Now()
+
+	s := storeServer(t, path)
+	if _, err := s.reserveQuota("peer", policy, "BTC", 1, now); err != nil {
+		t.Fatal(err)
+	}
+	s.db.Close()
+
+	s = storeServer(t, path)
+	if _, err := s.reserveQuota("peer", policy, "BTC", 1, now); !exceeded(err) {
+		t.Fatalf("expected quota to survive a restart, got %v", err)
+	}
+
+	// The usage of previous days is pruned
+	if _, err := s.reserveQuota("peer", policy, "BTC", 1, now.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.db.DeleteQuotasBefore(now.UTC().Format("2006-01-02")); err != nil || n != 0 {
+		t.Fatalf("expected quotas of previous days to be pruned, %d remain: %v", n, err)
+	}
+}
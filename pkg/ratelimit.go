@@ -0,0 +1,61 @@
+package trisarl
+
+import (
+	"context"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"golang.org/x/time/rate"
+)
+
+// Limit the rate of transfers from each peer with a token bucket keyed by the common
+// name of the peer, so that a misbehaving counterparty flooding the transfer stream
+// cannot exhaust the server. Transfers are limited before the envelope is decrypted
+// since opening envelopes is the most expensive part of handling a transfer; messages
+// over the limit on a transfer stream are rejected without closing the stream.
+func (s *Server) ratelimit(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		commonName := t.Peer.String()
+		if !s.limiter(commonName, s.config()).Allow() {
+			log.Ctx(ctx).Warn().Str("peer", commonName).Msg("transfer rate limit exceeded")
+			return &protocol.Error{
+				Code:    protocol.Unavailable,
+				Message: "transfer rate limit exceeded, please retry later",
+				Retry:   true,
+			}
+		}
+		return next(ctx, t)
+	}
+}
+
+// limiter returns the token bucket rate limiter of the peer, which is replaced if the
+// rate limit of the peer has changed since it was created, e.g. on reload. The rate
+// limit of the peer's policy takes precedence over the default rate limit.
+func (s *Server) limiter(commonName string, conf config.Config) *rate.Limiter {
+	rps, burst := conf.RateLimit.RPS, conf.RateLimit.Burst
+	if policy := conf.Peers.Get(commonName); policy.RateLimit > 0 {
+		rps, burst = policy.RateLimit, policy.Burst
+	}
+
+	limit := rate.Inf
+	if rps > 0 {
+		limit = rate.Limit(rps)
+		if burst == 0 {
+			burst = 1
+		}
+	}
+
+	s.limitmu.Lock()
+	defer s.limitmu.Unlock()
+	if s.limiters == nil {
+		s.limiters = make(map[string]*rate.Limiter)
+	}
+
+	lim, ok := s.limiters[commonName]
+	if !ok || lim.Limit() != limit || lim.Burst() != burst {
+		lim = rate.NewLimiter(limit, burst)
+		s.limiters[commonName] = lim
+	}
+	return lim
+}
@@ -0,0 +1,58 @@
+package trisarl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+)
+
+// testPeer returns a peer with the common name that is not connected to the network.
+func testPeer(t *testing.T, commonName string) *peers.Peer {
+	t.Helper()
+	network := peers.New(nil, nil, "")
+	if err := network.Add(&peers.PeerInfo{CommonName: commonName}); err != nil {
+		t.Fatal(err)
+	}
+
+	peer, err := network.Get(commonName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peer
+}
+
+func handled(ctx context.Context, t *Transfer) error {
+	return nil
+}
+
+func TestRateLimit(t *testing.T) {
+	s := &Server{conf: config.Config{
+		RateLimit: config.RateLimitConfig{RPS: 0.001, Burst: 2},
+		Peers:     config.PeerPolicies{"trusted.example.com": {RateLimit: 0.001, Burst: 3}},
+	}}
+	stage := s.ratelimit(handled)
+
+	// Peers are limited independently, with the burst of their policy if it has one
+	for peer, burst := range map[string]int{"peer.example.com": 2, "trusted.example.com": 3} {
+		transfer := &Transfer{Peer: testPeer(t, peer)}
+		for i := 0; i < burst; i++ {
+			if err := stage(context.Background(), transfer); err != nil {
+				t.Fatalf("%s: transfer %d was limited: %s", peer, i, err)
+			}
+		}
+
+		err := stage(context.Background(), transfer)
+		if perr, ok := err.(*protocol.Error); !ok || perr.Code != protocol.Unavailable || !perr.Retry {
+			t.Fatalf("%s: expected a retryable unavailable error over the burst, got %v", peer, err)
+		}
+	}
+
+	// Limiters are replaced when the rate limit changes, e.g. on reload
+	s.conf.RateLimit = config.RateLimitConfig{}
+	if err := stage(context.Background(), &Transfer{Peer: testPeer(t, "peer.example.com")}); err != nil {
+		t.Fatalf("expected transfers not to be limited without a rate limit, got %s", err)
+	}
+}
@@ -0,0 +1,163 @@
+package trisarl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// ErrNotSettled is returned if a transaction is acknowledged before the settlement that
+// was recorded for it has been confirmed on chain.
+var ErrNotSettled = errors.New("the settlement of the transaction has not been confirmed")
+
+const receiptAcknowledged = "final acknowledgment received, transaction closed"
+
+// unmarshalReceipt decodes the final acknowledgment of the payload, a transfer with the
+// envelope ID of a completed exchange whose transaction is a
+// generic.ConfirmationReceipt and that has no identity.
+func unmarshalReceipt(ctx context.Context, t *Transfer, transaction *anypb.Any) (err error) {
+	t.Receipt = &generic.ConfirmationReceipt{}
+	if err = transaction.UnmarshalTo(t.Receipt); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("could not unmarshal confirmation receipt")
+		return protocol.Errorf(protocol.UnparseableTransaction, "could not unmarshal confirmation receipt")
+	}
+	return nil
+}
+
+// closeTransaction closes the completed transaction of the final acknowledgment from
+// the peer and answers it with a confirmation receipt. Acknowledgments of transactions
+// that are already closed are answered again so that peers can retry them.
+func (s *Server) closeTransaction(ctx context.Context, t *Transfer) (err error) {
+	var tx *store.Transaction
+	if tx, err = s.db.GetTransaction(t.In.Id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return protocol.Errorf(protocol.BadRequest, "unknown transaction %s", t.In.Id)
+		}
+		log.Ctx(ctx).Error().Err(err).Str("id", t.In.Id).Msg("could not look up transaction to close")
+		return protocol.Errorf(protocol.InternalError, "could not close transaction")
+	}
+
+	if tx.Peer != t.Peer.String() {
+		log.Ctx(ctx).Warn().Str("peer", t.Peer.String()).Str("id", tx.EnvelopeID).Msg("acknowledgment received for the transaction of another peer")
+		return protocol.Errorf(protocol.BadRequest, "unknown transaction %s", t.In.Id)
+	}
+
+	switch tx.State {
+	case store.Completed:
+		reason := t.Receipt.Message
+		if reason == "" {
+			reason = "acknowledged by the peer"
+		}
+		s.transition(ctx, tx, store.Closed, reason)
+	case store.Closed:
+	default:
+		return protocol.Errorf(protocol.BadRequest, "transaction %s is %s, only completed transactions can be acknowledged", tx.EnvelopeID, tx.State)
+	}
+
+	receipt := &generic.ConfirmationReceipt{
+		EnvelopeId: t.In.Id,
+		ReceivedBy: t.Local,
+		ReceivedAt: time.Now().Format(time.RFC3339),
+		Message:    receiptAcknowledged,
+	}
+
+	t.Response = &protocol.Payload{}
+	if t.Response.Transaction, err = anypb.New(receipt); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("could not marshal confirmation receipt")
+		return protocol.Errorf(protocol.InternalError, "could not acknowledge receipt")
+	}
+
+	log.Ctx(ctx).Info().Str("peer", t.Peer.String()).Str("id", t.In.Id).Msg("final acknowledgment received")
+	return nil
+}
+
+// Acknowledge sends the final acknowledgment of the completed transaction with the
+// envelope ID to the peer with the message, and closes the transaction once the peer
+// has answered it with its receipt. If a settlement was recorded for the transaction,
+// it must have been confirmed on chain, otherwise ErrNotSettled is returned.
+// Transactions that are already closed are not acknowledged again.
+func (s *Server) Acknowledge(ctx context.Context, envelopeID, message string) (err error) {
+	var tx *store.Transaction
+	if tx, err = s.db.GetTransaction(envelopeID); err != nil {
+		return err
+	}
+
+	switch {
+	case tx.State == store.Closed:
+		return nil
+	case tx.State != store.Completed:
+		return fmt.Errorf("transaction %s is %s, only completed transactions can be acknowledged", envelopeID, tx.State)
+	case tx.Settlement != nil && tx.Settlement.Status != store.SettlementConfirmed:
+		return fmt.Errorf("%w: transaction %s is %s", ErrNotSettled, envelopeID, tx.Settlement.Status)
+	}
+
+	var peer *peers.Peer
+	if peer, err = s.lookup(ctx, tx.Peer); err != nil {
+		return err
+	}
+
+	var sealKey interface{}
+	if sealKey, err = s.exchangeKeys(ctx, peer, false); err != nil {
+		return fmt.Errorf("could not exchange keys with %s: %w", tx.Peer, err)
+	}
+
+	receipt := &generic.ConfirmationReceipt{
+		EnvelopeId: envelopeID,
+		ReceivedBy: s.commonName(),
+		ReceivedAt: time.Now().Format(time.RFC3339),
+		Message:    message,
+	}
+
+	payload := &protocol.Payload{}
+	if payload.Transaction, err = anypb.New(receipt); err != nil {
+		return err
+	}
+
+	var in, out *protocol.SecureEnvelope
+	if in, err = s.sealEnvelope(peer.String(), handler.New(envelopeID, payload, nil), sealKey); err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if out, err = peer.Transfer(in); err != nil {
+		s.recordEnvelope(ctx, peer.String(), store.Outgoing, in, payload, err)
+		return err
+	}
+	s.recordEnvelope(ctx, peer.String(), store.Outgoing, in, payload, nil)
+
+	if out.Error != nil && out.Error.Code != 0 {
+		s.recordEnvelope(ctx, peer.String(), store.Incoming, out, nil, nil)
+		return out.Error
+	}
+
+	var opened *handler.Envelope
+	_, key := s.signing()
+	if opened, err = s.openEnvelope(out, peer.String(), key); err != nil {
+		s.recordEnvelope(ctx, peer.String(), store.Incoming, out, nil, err)
+		return err
+	}
+	s.recordEnvelope(ctx, peer.String(), store.Incoming, out, opened.Payload, nil)
+
+	reason := message
+	if reason == "" {
+		reason = "acknowledgment sent"
+	}
+
+	if tx, err = s.db.GetTransaction(envelopeID); err == nil && tx.State == store.Completed {
+		s.transition(ctx, tx, store.Closed, reason)
+	}
+	log.Ctx(ctx).Info().Str("peer", peer.String()).Str("id", envelopeID).Msg("final acknowledgment sent")
+	return nil
+}
@@ -0,0 +1,147 @@
+package trisarl
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+)
+
+// Reconcile links the transactions created since the specified time, or all of the
+// transactions if it is zero, to their settlements on chain and returns the report of
+// the records that compliance should follow up on, which replaces the latest
+// reconciliation report. Settlements that are not final and that are within the
+// verification window or were never checked are looked up with the chain-data provider
+// first, so that the report is up to date.
+func (s *Server) Reconcile(ctx context.Context, since time.Time) (report *store.Reconciliation, err error) {
+	verifier, conf := s.verifier()
+	if verifier == nil {
+		return nil, ErrNoChainProvider
+	}
+
+	var txns []*store.Transaction
+	if txns, err = s.db.Transactions(""); err != nil {
+		return nil, err
+	}
+
+	report = &store.Reconciliation{
+		Generated: time.Now(),
+		Since:     since,
+		Counts:    make(map[store.Finding]int),
+		Flagged:   make([]*store.ReconciliationItem, 0),
+	}
+
+	window := report.Generated.Add(-conf.Window)
+	for _, tx := range txns {
+		if tx.Created.Before(since) {
+			continue
+		}
+
+		if tx.Settlement != nil && !tx.Settlement.Status.Final() && (tx.Settlement.Recorded.After(window) || tx.Settlement.Checks == 0) {
+			if updated, err := s.VerifySettlement(ctx, tx.EnvelopeID); updated != nil {
+				tx = updated
+			} else if err != nil {
+				log.Ctx(ctx).Warn().Err(err).Str("id", tx.EnvelopeID).Msg("could not verify settlement")
+			}
+		}
+
+		finding, ok := reconcile(tx, window)
+		if !ok {
+			continue
+		}
+
+		report.Counts[finding]++
+		if finding.Flagged() {
+			report.Flagged = append(report.Flagged, &store.ReconciliationItem{
+				EnvelopeID: tx.EnvelopeID,
+				Peer:       tx.Peer,
+				State:      tx.State,
+				Created:    tx.Created,
+				Finding:    finding,
+				Settlement: tx.Settlement,
+			})
+		}
+	}
+
+	sort.Slice(report.Flagged, func(i, j int) bool {
+		return report.Flagged[i].Created.Before(report.Flagged[j].Created)
+	})
+
+	if err = s.db.PutReconciliation(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// reconcile returns the finding of the transaction, whose settlement is orphaned if it
+// has not been confirmed since before the verification window. Transactions that were
+// rejected or expired are only reconciled if their transaction was found on chain.
+func reconcile(tx *store.Transaction, window time.Time) (store.Finding, bool) {
+	settlement := tx.Settlement
+	if tx.State == store.Rejected || tx.State == store.Expired {
+		if settlement != nil && (settlement.Status == store.SettlementConfirmed || settlement.Status == store.SettlementMismatch || settlement.Status == store.SettlementPending) {
+			return store.FindingUnexpected, true
+		}
+		return "", false
+	}
+
+	if settlement == nil {
+		if tx.State == store.Completed || tx.State == store.Closed {
+			return store.FindingUnlinked, true
+		}
+		return store.FindingPending, true
+	}
+
+	switch {
+	case settlement.Status == store.SettlementConfirmed:
+		return store.FindingMatched, true
+	case settlement.Status == store.SettlementMismatch:
+		return store.FindingMismatch, true
+	case settlement.Recorded.Before(window):
+		return store.FindingOrphaned, true
+	}
+	return store.FindingPending, true
+}
+
+// reconcileSettlements periodically reconciles the transactions with their settlements
+// until the server starts shutting down.
+func (s *Server) reconcileSettlements() {
+	interval := s.config().Chain.ReconcileInterval
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				verifier, conf := s.verifier()
+				if conf.ReconcileInterval > 0 && conf.ReconcileInterval != interval {
+					interval = conf.ReconcileInterval
+					ticker.Reset(interval)
+				}
+				if verifier == nil {
+					continue
+				}
+
+				report, err := s.Reconcile(context.Background(), time.Time{})
+				if err != nil {
+					log.Error().Err(err).Msg("could not reconcile transactions")
+					continue
+				}
+
+				event := log.Info()
+				for finding, count := range report.Counts {
+					event = event.Int(string(finding), count)
+				}
+				event.Int("flagged", len(report.Flagged)).Msg("transactions reconciled")
+			case <-s.draining:
+				return
+			}
+		}
+	}()
+}
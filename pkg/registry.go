@@ -0,0 +1,58 @@
+package trisarl
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rotationalio/trisa/pkg/addressbook"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+)
+
+// registry responds to transfers with the beneficiary from the address book: the
+// beneficiary wallet address of the transaction is resolved to the customer account
+// that controls it and the beneficiary of the identity is replaced by the IVMS101
+// person of the account and the address. If the originator did not provide the
+// beneficiary VASP it is set to the common name of the node. Transfers to addresses
+// that are not registered are rejected with an unknown wallet address error and
+// transfers to addresses without a customer record with an unknown beneficiary error.
+func (s *Server) registry(ctx context.Context, peer *peers.Peer, identity *ivms101.IdentityPayload, transaction *generic.Transaction) (_ *protocol.Payload, err error) {
+	var acct *addressbook.Account
+	if acct, err = s.book.Lookup(transaction.Network, transaction.Beneficiary); err != nil {
+		switch {
+		case errors.Is(err, addressbook.ErrNotFound):
+			log.Ctx(ctx).Info().Str("peer", peer.String()).Str("network", transaction.Network).Msg("transfer to unknown beneficiary address")
+			return nil, protocol.Errorf(protocol.UnkownWalletAddress, "beneficiary wallet address is not known")
+		case errors.Is(err, addressbook.ErrNoAccount):
+			log.Ctx(ctx).Warn().Str("network", transaction.Network).Msg("registered address has no customer account")
+			return nil, protocol.Errorf(protocol.UnkownBeneficiary, "beneficiary of the wallet address is not known")
+		}
+		return nil, err
+	}
+
+	var person *ivms101.Person
+	if person, err = acct.IVMS101(); err != nil {
+		return nil, err
+	}
+
+	responder := &Responder{
+		Beneficiary: &ivms101.Beneficiary{
+			BeneficiaryPersons: []*ivms101.Person{person},
+			AccountNumbers:     []string{transaction.Beneficiary},
+		},
+	}
+	if identity.GetBeneficiaryVasp().GetBeneficiaryVasp() == nil {
+		responder.BeneficiaryVasp = &ivms101.BeneficiaryVasp{BeneficiaryVasp: addressbook.LegalPerson(s.commonNameFor(ctx))}
+	}
+
+	var payload *protocol.Payload
+	if payload, err = responder.Payload(identity, transaction); err != nil {
+		return nil, err
+	}
+
+	log.Ctx(ctx).Info().Str("peer", peer.String()).Str("network", transaction.Network).Str("account", acct.ID).Msg("beneficiary resolved from the address book")
+	return payload, nil
+}
@@ -0,0 +1,173 @@
+package trisarl
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/envelope"
+	"github.com/rotationalio/trisa/pkg/keychain"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// RekeyOptions specify how archived envelopes are rekeyed by RekeyEnvelopes. OldKeys
+// are the locations of the private keys that envelopes were sealed with, in the same
+// formats as exchange keys, e.g. the PEM encoded key of certificates that are being
+// replaced; the generated exchange keys in the store, including retired keys, are
+// always tried as well. If Reseal is false, the decrypted payloads are sealed with the
+// storage payload key and the envelopes are kept as they were received. If Reseal is
+// true, the envelopes are sealed again for the exchange key at the ExchangeKey location
+// or, if it is empty, for the current generated exchange key in the store.
+type RekeyOptions struct {
+	OldKeys     []string
+	Reseal      bool
+	ExchangeKey string
+}
+
+// RekeyEnvelopes decrypts the incoming envelopes in the envelope log with the old keys
+// and keeps them readable under a current key, so that archived envelopes can still be
+// opened after the keys they were sealed with are retired or migrated, and returns the
+// number of envelopes that were rekeyed. Envelopes that are already readable, i.e.
+// whose payload is kept or that the exchange key opens, are skipped, as are outgoing
+// envelopes, which are sealed with the keys of peers. Envelopes that none of the keys
+// can open are logged and skipped. Every key use is appended to the key usage log. The
+// server must be stopped while the envelopes are rekeyed.
+func RekeyEnvelopes(ctx context.Context, db *store.Store, opts RekeyOptions) (nrecords uint64, err error) {
+	if !opts.Reseal && db.PayloadKeyID() == "" {
+		return 0, store.ErrNoPayloadKey
+	}
+
+	var keys []interface{}
+	if keys, err = archivedKeys(db); err != nil {
+		return 0, err
+	}
+	generated := len(keys)
+
+	for _, location := range opts.OldKeys {
+		var key interface{}
+		if key, err = keychain.Load(ctx, location); err != nil {
+			return 0, fmt.Errorf("could not load old key: %s", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return 0, errors.New("no keys to open the envelopes with")
+	}
+
+	var (
+		newKey interface{}
+		newID  string
+	)
+	trial := keys
+	if opts.Reseal {
+		switch {
+		case opts.ExchangeKey != "":
+			if newKey, err = keychain.Load(ctx, opts.ExchangeKey); err != nil {
+				return 0, fmt.Errorf("could not load exchange key: %s", err)
+			}
+		case generated == 0:
+			return 0, errors.New("no generated exchange key in the store, specify the exchange key")
+		default:
+			// The newest generated exchange key is the first of the archived keys
+			newKey = keys[0]
+		}
+
+		// Envelopes that the exchange key opens are already readable
+		newID = keychain.KeyID(envelope.PublicKey(newKey))
+		trial = append([]interface{}{newKey}, keys...)
+	}
+
+	var records []*store.Envelope
+	if records, err = db.Envelopes("", time.Time{}); err != nil {
+		return 0, err
+	}
+
+	for _, record := range records {
+		if record.Direction != store.Incoming || (!opts.Reseal && len(record.Payload) > 0) {
+			continue
+		}
+
+		in := &protocol.SecureEnvelope{}
+		if err = proto.Unmarshal(record.Envelope, in); err != nil {
+			return nrecords, fmt.Errorf("could not parse secure envelope %s: %s", record.ID, err)
+		}
+
+		// Rejections sent by peers do not have a payload to rekey
+		if len(in.Payload) == 0 {
+			continue
+		}
+
+		usage := &store.KeyUsage{Use: store.KeyOpened, Peer: record.Peer, EnvelopeID: record.ID}
+		env, opened, oerr := openWith(in, trial)
+		if oerr != nil {
+			usage.Error = oerr.Error()
+		} else {
+			usage.KeyID = keychain.KeyID(envelope.PublicKey(opened))
+		}
+
+		if err = db.PutKeyUsage(usage); err != nil {
+			return nrecords, fmt.Errorf("could not record key usage: %s", err)
+		}
+
+		if oerr != nil {
+			log.Warn().Err(oerr).Str("id", record.ID).Str("peer", record.Peer).Msg("could not open archived envelope")
+			continue
+		}
+
+		if opts.Reseal {
+			if usage.KeyID == newID {
+				continue
+			}
+
+			var out *protocol.SecureEnvelope
+			usage = &store.KeyUsage{Use: store.KeySealed, Peer: record.Peer, EnvelopeID: record.ID, KeyID: newID}
+			if out, err = envelope.Seal(env, envelope.PublicKey(newKey)); err != nil {
+				return nrecords, fmt.Errorf("could not reseal envelope %s: %s", record.ID, err)
+			}
+
+			if err = db.PutKeyUsage(usage); err != nil {
+				return nrecords, fmt.Errorf("could not record key usage: %s", err)
+			}
+
+			if record.Envelope, err = proto.Marshal(out); err != nil {
+				return nrecords, err
+			}
+			record.Resealed = time.Now().UTC()
+		} else if record.Payload, err = protojson.Marshal(env.Payload); err != nil {
+			return nrecords, err
+		}
+
+		if err = db.PutEnvelope(record); err != nil {
+			return nrecords, fmt.Errorf("could not store envelope %s: %s", record.ID, err)
+		}
+		nrecords++
+	}
+	return nrecords, nil
+}
+
+// archivedKeys returns the generated exchange keys in the store, newest first,
+// including the retired keys that only open archived envelopes.
+func archivedKeys(db *store.Store) (keys []interface{}, err error) {
+	var stored []*store.ExchangeKey
+	if stored, err = db.ExchangeKeys(); err != nil {
+		return nil, err
+	}
+
+	keys = make([]interface{}, 0, len(stored))
+	for _, key := range stored {
+		var priv *rsa.PrivateKey
+		if priv, err = x509.ParsePKCS1PrivateKey(key.PrivateKey); err != nil {
+			return nil, fmt.Errorf("could not parse exchange key %s: %s", key.ID, err)
+		}
+		keys = append(keys, priv)
+	}
+	return keys, nil
+}
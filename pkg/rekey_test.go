@@ -0,0 +1,113 @@
+package trisarl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/envelope"
+	"github.com/rotationalio/trisa/pkg/keychain"
+	"github.com/rotationalio/trisa/pkg/store"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestRekeyEnvelopes(t *testing.T) {
+	dir := t.TempDir()
+	s := storeServer(t, filepath.Join(dir, "db"))
+
+	oldKey, oldPath := testKeyFile(t, dir, "old.pem")
+	newKey, newPath := testKeyFile(t, dir, "new.pem")
+
+	env := handler.New("", &protocol.Payload{Transaction: &anypb.Any{TypeUrl: "type.example.com/transaction"}}, nil)
+	in, err := envelope.Seal(env, envelope.PublicKey(oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := proto.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.db.PutEnvelope(&store.Envelope{ID: in.Id, Peer: "peer", Direction: store.Incoming, Envelope: data}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Payloads cannot be kept without a payload key
+	if _, err = RekeyEnvelopes(context.Background(), s.db, RekeyOptions{OldKeys: []string{oldPath}}); err != store.ErrNoPayloadKey {
+		t.Fatalf("expected ErrNoPayloadKey, got %v", err)
+	}
+
+	opts := RekeyOptions{OldKeys: []string{oldPath}, Reseal: true, ExchangeKey: newPath}
+	n, err := RekeyEnvelopes(context.Background(), s.db, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 envelope to be rekeyed, got %d", n)
+	}
+
+	records, err := s.db.Envelopes(in.Id, time.Time{})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("could not read rekeyed envelope: %v", err)
+	}
+	if records[0].Resealed.IsZero() {
+		t.Error("rekeyed envelope is not marked as resealed")
+	}
+
+	resealed := &protocol.SecureEnvelope{}
+	if err = proto.Unmarshal(records[0].Envelope, resealed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = envelope.Open(resealed, newKey); err != nil {
+		t.Fatalf("could not open resealed envelope with the new key: %s", err)
+	}
+
+	// Envelopes that the new key opens are skipped
+	if n, err = RekeyEnvelopes(context.Background(), s.db, opts); err != nil || n != 0 {
+		t.Fatalf("expected no envelopes to be rekeyed again, got %d (%v)", n, err)
+	}
+
+	usage, err := s.db.KeyUsage(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opened, sealed int
+	for _, record := range usage {
+		switch {
+		case record.Use == store.KeyOpened && record.KeyID == keychain.KeyID(envelope.PublicKey(oldKey)):
+			opened++
+		case record.Use == store.KeySealed && record.KeyID == keychain.KeyID(envelope.PublicKey(newKey)):
+			sealed++
+		}
+	}
+	if opened != 1 || sealed != 1 {
+		t.Errorf("expected the old key to open and the new key to seal the envelope once, got %d and %d", opened, sealed)
+	}
+}
+
+// testKeyFile generates an ECDSA key and writes it to a PEM file in the directory.
+func testKeyFile(t *testing.T, dir, name string) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return key, path
+}
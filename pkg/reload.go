@@ -0,0 +1,185 @@
+package trisarl
+
+import (
+	"os"
+
+	"github.com/rotationalio/trisa/internal/maintenance"
+	"github.com/rotationalio/trisa/pkg/chain"
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/screening"
+	"github.com/rotationalio/trisa/pkg/webhooks"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Reload re-reads the configuration from the environment and config file and applies any
+// changes that can be made to the running server without a restart (e.g. the log level,
+// maintenance mode, and sanctions lists) and rotates the TRISA certificates. Open
+// connections and in-flight streams are unaffected. Changes to settings that require a
+// restart, such as the bind address, are logged but otherwise ignored until the server
+// is restarted. Maintenance mode and feature flags that were changed at runtime keep
+// their runtime setting unless the reloaded configuration changes them.
+func (s *Server) Reload() (err error) {
+	prev := s.config()
+
+	var conf config.Config
+	if conf, err = prev.Reload(); err != nil {
+		return err
+	}
+
+	// Settings that cannot be changed on a running server
+	conf.BindAddr, conf.SocketMode = prev.BindAddr, prev.SocketMode
+	if conf.Listeners.String() != prev.Listeners.String() {
+		log.Warn().Msg("listener changes require a restart")
+		conf.Listeners = prev.Listeners
+	}
+	if conf.SigningCerts != prev.SigningCerts || conf.SigningKey != prev.SigningKey {
+		log.Warn().Msg("signing certificate changes require a restart")
+		conf.SigningCerts, conf.SigningKey = prev.SigningCerts, prev.SigningKey
+	}
+	if conf.Exchange != prev.Exchange {
+		log.Warn().Msg("exchange key changes require a restart")
+		conf.Exchange = prev.Exchange
+	}
+	if conf.DirectoryAddr != prev.DirectoryAddr {
+		log.Warn().Msg("directory address changes require a restart")
+		conf.DirectoryAddr = prev.DirectoryAddr
+	}
+	if conf.Metrics != prev.Metrics {
+		log.Warn().Msg("metrics server changes require a restart")
+		conf.Metrics = prev.Metrics
+	}
+	if conf.Debug != prev.Debug {
+		log.Warn().Msg("debug server changes require a restart")
+		conf.Debug = prev.Debug
+	}
+	if conf.Probes != prev.Probes {
+		log.Warn().Msg("probes server changes require a restart")
+		conf.Probes = prev.Probes
+	}
+	if conf.Tracing != prev.Tracing {
+		log.Warn().Msg("tracing changes require a restart")
+		conf.Tracing = prev.Tracing
+	}
+	if conf.GRPC != prev.GRPC {
+		log.Warn().Msg("grpc server tuning changes require a restart")
+		conf.GRPC = prev.GRPC
+	}
+	if conf.Storage != prev.Storage {
+		log.Warn().Msg("storage changes require a restart")
+		conf.Storage = prev.Storage
+	}
+	if conf.EventBus != prev.EventBus {
+		log.Warn().Msg("event bus changes require a restart")
+		conf.EventBus = prev.EventBus
+	}
+	if conf.Notifications != prev.Notifications {
+		log.Warn().Msg("notification changes require a restart")
+		conf.Notifications = prev.Notifications
+	}
+	if conf.ConsoleLog != prev.ConsoleLog {
+		log.Warn().Msg("console logging changes require a restart")
+		conf.ConsoleLog = prev.ConsoleLog
+	}
+
+	// Apply the reloadable settings
+	var schedule *maintenance.Schedule
+	if schedule, err = parseSchedule(conf); err != nil {
+		return err
+	}
+
+	// The payload types accepted by the configuration may have changed
+	if err = s.payloads.Check(conf.Payloads); err != nil {
+		return err
+	}
+
+	// Reload the sanctions lists, which may have been updated
+	var sanctions screening.Screener
+	if sanctions, err = newScreener(conf.Screening); err != nil {
+		return err
+	}
+
+	// Recreate the chain-data provider, whose url or token may have changed
+	var provider chain.Provider
+	if provider, err = newChainProvider(conf.Chain); err != nil {
+		return err
+	}
+
+	// Reload the webhook endpoints, whose urls or secrets may have changed
+	var endpoints []webhooks.Endpoint
+	if endpoints, err = newWebhookEndpoints(conf.Webhooks); err != nil {
+		return err
+	}
+
+	// Reload the server certificates, which may have been replaced or moved
+	certsMoved := conf.ServerCerts != prev.ServerCerts || conf.ServerCertPool != prev.ServerCertPool
+	if err := s.rotateCertificates(conf); err != nil {
+		log.Error().Err(err).Msg("could not rotate certificates, continuing with the current certificates")
+		conf.ServerCerts, conf.ServerCertPool, conf.CertsPassword = prev.ServerCerts, prev.ServerCertPool, prev.CertsPassword
+		conf.Identities = prev.Identities
+		certsMoved = false
+	}
+
+	// The global logger is read concurrently, so only the global level is changed
+	zerolog.SetGlobalLevel(conf.GetLogLevel())
+	s.features.Reload(conf.Features)
+
+	s.confmu.Lock()
+	// Keep the maintenance mode set at runtime unless the configured setting changed
+	configured := conf.Maintenance
+	if configured == s.maintenanceConf {
+		conf.Maintenance = s.conf.Maintenance
+	}
+	s.maintenanceConf = configured
+	s.conf = conf
+	s.schedule = schedule
+	s.sanctions = sanctions
+	s.chainProvider = provider
+	s.confmu.Unlock()
+	s.setupWebhooks(conf.Webhooks, endpoints)
+
+	// Watch the new certificate locations if the certificates have moved
+	if certsMoved && s.watcher != nil {
+		s.watcher.Close()
+		if err = s.watchCertificates(); err != nil {
+			log.Error().Err(err).Msg("could not watch certificates")
+		}
+	}
+
+	log.Info().
+		Str("log_level", conf.GetLogLevel().String()).
+		Bool("maintenance", conf.Maintenance).
+		Int("maintenance_windows", schedule.Len()).
+		Strs("features", s.features.Active()).
+		Msg("configuration reloaded")
+	return nil
+}
+
+// config returns the current configuration of the server, which may be reloaded.
+func (s *Server) config() config.Config {
+	s.confmu.RLock()
+	defer s.confmu.RUnlock()
+	return s.conf
+}
+
+// maintenanceState returns the current configuration along with the maintenance schedule
+// that was parsed from it so that both are consistent with each other. Maintenance is
+// true if maintenance mode is enabled in the configuration, at runtime, or by the file.
+func (s *Server) maintenanceState() (conf config.Config, schedule *maintenance.Schedule) {
+	s.confmu.RLock()
+	conf, schedule = s.conf, s.schedule
+	s.confmu.RUnlock()
+
+	// Maintenance mode is also enabled while the maintenance file exists
+	if !conf.Maintenance && conf.MaintenanceFile != "" {
+		if _, err := os.Stat(conf.MaintenanceFile); err == nil {
+			conf.Maintenance = true
+		}
+	}
+	return conf, schedule
+}
+
+// parseSchedule parses the planned maintenance windows from the configuration.
+func parseSchedule(conf config.Config) (*maintenance.Schedule, error) {
+	return maintenance.Parse(maintenance.Split(conf.MaintenanceWindows)...)
+}
@@ -0,0 +1,137 @@
+package trisarl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDKey is the gRPC metadata key of the request ID. If a peer sends a request ID
+// it is used for the request, otherwise one is generated. The request ID is returned to
+// the peer in the response headers and in error messages so that a peer's support
+// request can be correlated with the server logs.
+const RequestIDKey = "x-request-id"
+
+// maxRequestIDLength limits the length of request IDs sent by peers.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request from the context of an RPC handler or of the
+// transfer pipeline, or an empty string if the context does not have a request ID.
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// withRequestID adds the request ID to the context along with a logger that includes
+// the request ID in every log entry, and sets the request ID response header.
+func withRequestID(ctx context.Context) (context.Context, string) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(RequestIDKey); len(vals) > 0 && validRequestID(vals[0]) {
+			id = vals[0]
+		}
+	}
+
+	if id == "" {
+		id = newRequestID()
+	}
+
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	logger := log.With().Str("request_id", id).Logger()
+	ctx = logger.WithContext(ctx)
+
+	if err := grpc.SetHeader(ctx, metadata.Pairs(RequestIDKey, id)); err != nil {
+		log.Ctx(ctx).Debug().Err(err).Msg("could not set request id header")
+	}
+	return ctx, id
+}
+
+func newRequestID() string {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Errorf("could not generate request id: %s", err))
+	}
+	return hex.EncodeToString(id)
+}
+
+// validRequestID ensures request IDs sent by peers are short and printable.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// annotate adds the request ID to the message of the error returned to the peer.
+func annotate(id string, err error) error {
+	if err == nil || id == "" {
+		return err
+	}
+
+	if perr, ok := err.(*protocol.Error); ok {
+		return annotateError(id, perr)
+	}
+
+	if st, ok := status.FromError(err); ok {
+		pb := st.Proto()
+		pb.Message = fmt.Sprintf("%s (request id %s)", pb.Message, id)
+		return status.FromProto(pb).Err()
+	}
+	return err
+}
+
+// annotateError returns a copy of the TRISA error with the request ID in the message,
+// e.g. for errors that are sent to the peer in secure envelopes on a transfer stream.
+func annotateError(id string, err *protocol.Error) *protocol.Error {
+	if id == "" {
+		return err
+	}
+	return &protocol.Error{
+		Code:    err.Code,
+		Message: fmt.Sprintf("%s (request id %s)", err.Message, id),
+		Retry:   err.Retry,
+		Details: err.Details,
+	}
+}
+
+// unaryRequestID assigns a request ID to every unary RPC.
+func unaryRequestID(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (rep interface{}, err error) {
+	var id string
+	ctx, id = withRequestID(ctx)
+	rep, err = handler(ctx, req)
+	return rep, annotate(id, err)
+}
+
+// streamRequestID assigns a request ID to every streaming RPC; every message on the
+// stream shares the request ID of the stream.
+func streamRequestID(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	ctx, id := withRequestID(stream.Context())
+	err = handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+	return annotate(id, err)
+}
+
+// contextStream replaces the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
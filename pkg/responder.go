@@ -0,0 +1,84 @@
+package trisarl
+
+import (
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/envelope"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Responder builds the response to a transfer as the TRISA spec describes it: the
+// identity of the transfer is echoed back to the originator with the beneficiary fields
+// completed by the beneficiary VASP, and the transaction is echoed back with the time
+// the transfer was received. The payload in TRISA v1beta1 has no sent at or received at
+// timestamps, so the received_at timestamp is added to the extra JSON of the
+// transaction, where a sent_at timestamp set by the originator is preserved. The
+// identity and transaction of the transfer are never modified.
+//
+// Beneficiary replaces the beneficiary persons of the identity if it has any; its
+// account numbers are used if it has any, otherwise the account numbers provided by the
+// originator are kept, or the beneficiary address of the transaction is used. The
+// beneficiary VASP replaces the beneficiary VASP of the identity if it is set. If
+// ReceivedAt is zero the current time is used.
+type Responder struct {
+	Beneficiary     *ivms101.Beneficiary
+	BeneficiaryVasp *ivms101.BeneficiaryVasp
+	ReceivedAt      time.Time
+}
+
+// Payload returns the response payload to the transfer with the identity and
+// transaction, e.g. to return from a TransferHandler.
+func (r *Responder) Payload(identity *ivms101.IdentityPayload, transaction *generic.Transaction) (payload *protocol.Payload, err error) {
+	identity = proto.Clone(identity).(*ivms101.IdentityPayload)
+	transaction = proto.Clone(transaction).(*generic.Transaction)
+
+	if identity.Beneficiary == nil {
+		identity.Beneficiary = &ivms101.Beneficiary{}
+	}
+	if r.Beneficiary != nil {
+		if len(r.Beneficiary.BeneficiaryPersons) > 0 {
+			identity.Beneficiary.BeneficiaryPersons = r.Beneficiary.BeneficiaryPersons
+		}
+		if len(r.Beneficiary.AccountNumbers) > 0 {
+			identity.Beneficiary.AccountNumbers = r.Beneficiary.AccountNumbers
+		}
+	}
+	if len(identity.Beneficiary.AccountNumbers) == 0 && transaction.Beneficiary != "" {
+		identity.Beneficiary.AccountNumbers = []string{transaction.Beneficiary}
+	}
+	if r.BeneficiaryVasp != nil && r.BeneficiaryVasp.BeneficiaryVasp != nil {
+		identity.BeneficiaryVasp = r.BeneficiaryVasp
+	}
+
+	receivedAt := r.ReceivedAt
+	if receivedAt.IsZero() {
+		receivedAt = time.Now()
+	}
+	transaction.ExtraJson = withTimestamp(transaction.ExtraJson, fieldReceivedAt, receivedAt)
+
+	payload = &protocol.Payload{}
+	if payload.Identity, err = anypb.New(identity); err != nil {
+		return nil, err
+	}
+	if payload.Transaction, err = anypb.New(transaction); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// Seal returns the response to the transfer in a secure envelope with the envelope ID of
+// the transfer, encrypted with the public signing key of the peer, e.g. to send the
+// decision on a reviewed transfer back to the originator. Responses returned by a
+// TransferHandler are sealed by the transfer pipeline.
+func (r *Responder) Seal(envelopeID string, identity *ivms101.IdentityPayload, transaction *generic.Transaction, key interface{}) (_ *protocol.SecureEnvelope, err error) {
+	var payload *protocol.Payload
+	if payload, err = r.Payload(identity, transaction); err != nil {
+		return nil, err
+	}
+	return envelope.Seal(handler.New(envelopeID, payload, nil), key)
+}
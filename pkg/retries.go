@@ -0,0 +1,210 @@
+package trisarl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/pending"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rotationalio/trisa/pkg/webhooks"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// retryable returns the TRISA error of a failed transfer or key exchange if the peer
+// has indicated that the request can be retried.
+func retryable(err error) (*protocol.Error, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if perr, ok := err.(*protocol.Error); ok {
+			return perr, perr.Retry
+		}
+
+		if st, ok := status.FromError(err); ok {
+			for _, detail := range st.Details() {
+				if perr, ok := detail.(*protocol.Error); ok {
+					return perr, perr.Retry
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// refreshKeys returns true if the error indicates that the peer does not have or could
+// not use the signing key of the node, so keys must be exchanged again before a retry.
+func refreshKeys(code string) bool {
+	return code == protocol.NoSigningKey.String() || code == protocol.InvalidKey.String()
+}
+
+// backoff returns the delay before the next attempt after the specified number of
+// attempts: the backoff doubles with every attempt up to the maximum backoff, and half
+// of the delay is jittered so that retries to the same peer are spread out.
+func backoff(conf config.RetriesConfig, attempts int) time.Duration {
+	delay := conf.Backoff
+	for i := 1; i < attempts && delay < conf.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > conf.MaxBackoff {
+		delay = conf.MaxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// scheduleRetry persists the transfer to be retried if retries are enabled and the
+// error of the first attempt is retryable. Retries are kept in the store so that they
+// survive restarts and are sent with a jittered exponential backoff until they are
+// delivered, rejected with an error that is not retryable, or run out of attempts.
+func (s *Server) scheduleRetry(ctx context.Context, counterparty Counterparty, envelopeID string, payload *protocol.Payload, err error) {
+	conf := s.config().Retries
+	perr, ok := retryable(err)
+	if !conf.Enabled || !ok || conf.MaxAttempts < 2 {
+		return
+	}
+
+	retry := &store.Retry{
+		EnvelopeID:   envelopeID,
+		Counterparty: counterparty.CommonName,
+		Email:        counterparty.Email,
+		Attempts:     1,
+		Code:         perr.Code.String(),
+		Error:        perr.Message,
+		NextAttempt:  time.Now().Add(backoff(conf, 1)),
+	}
+
+	if retry.Payload, err = protojson.Marshal(payload); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("id", envelopeID).Msg("could not serialize payload to retry")
+		return
+	}
+
+	if err = s.db.PutRetry(retry); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("id", envelopeID).Msg("could not schedule retry")
+		return
+	}
+	log.Ctx(ctx).Info().Str("id", envelopeID).Str("peer", counterparty.CommonName).Str("code", retry.Code).Time("next_attempt", retry.NextAttempt).Msg("transfer scheduled for retry")
+}
+
+// retryTransfers periodically sends the transfers that are due to be retried until the
+// server starts shutting down.
+func (s *Server) retryTransfers() {
+	interval := s.config().Retries.Interval
+	if interval <= 0 {
+		return
+	}
+
+	s.background(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				conf := s.config().Retries
+				if conf.Interval > 0 && conf.Interval != interval {
+					interval = conf.Interval
+					ticker.Reset(interval)
+				}
+				if !conf.Enabled {
+					continue
+				}
+
+				retries, err := s.db.Retries(time.Now())
+				if err != nil {
+					log.Error().Err(err).Msg("could not list scheduled retries")
+					continue
+				}
+
+				for _, retry := range retries {
+					if ctx.Err() != nil {
+						return
+					}
+					s.retry(ctx, conf, retry)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// retry sends the transfer again and either removes the retry once the transfer is
+// delivered or rejected with an error that is not retryable, or reschedules it with
+// the backoff of its attempts. Errors that are not TRISA errors, e.g. if the peer is
+// unreachable, count as failed attempts. The transaction is rejected when the maximum
+// number of attempts is reached. Attempts interrupted by shutdown are not counted.
+func (s *Server) retry(ctx context.Context, conf config.RetriesConfig, retry *store.Retry) {
+	payload := &protocol.Payload{}
+	if err := protojson.Unmarshal(retry.Payload, payload); err != nil {
+		log.Error().Err(err).Str("id", retry.EnvelopeID).Msg("could not read payload of scheduled retry")
+		return
+	}
+
+	env := handler.New(retry.EnvelopeID, payload, nil)
+	reply, err := s.send(ctx, Counterparty{CommonName: retry.Counterparty, Email: retry.Email}, env, refreshKeys(retry.Code))
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	retry.Attempts++
+
+	perr, ok := retryable(err)
+	switch {
+	case err == nil:
+		log.Info().Str("id", retry.EnvelopeID).Str("peer", retry.Counterparty).Int("attempts", retry.Attempts).Msg("transfer delivered after retry")
+		s.deleteRetry(retry.EnvelopeID)
+		s.publishDelivered(retry, reply)
+		return
+	case perr != nil && !ok:
+		log.Warn().Err(err).Str("id", retry.EnvelopeID).Str("peer", retry.Counterparty).Int("attempts", retry.Attempts).Msg("retried transfer was rejected")
+		s.deleteRetry(retry.EnvelopeID)
+		return
+	case perr != nil:
+		retry.Code, retry.Error = perr.Code.String(), perr.Message
+	default:
+		retry.Code, retry.Error = "", err.Error()
+	}
+
+	if retry.Attempts >= conf.MaxAttempts {
+		log.Warn().Str("error", retry.Error).Str("id", retry.EnvelopeID).Str("peer", retry.Counterparty).Int("attempts", retry.Attempts).Msg("giving up on retried transfer")
+		s.deleteRetry(retry.EnvelopeID)
+
+		if tx, err := s.db.GetTransaction(retry.EnvelopeID); err == nil {
+			s.transition(ctx, tx, store.Rejected, fmt.Sprintf("gave up after %d attempts: %s", retry.Attempts, retry.Error))
+		}
+		return
+	}
+
+	retry.NextAttempt = time.Now().Add(backoff(conf, retry.Attempts))
+	if err = s.db.PutRetry(retry); err != nil {
+		log.Error().Err(err).Str("id", retry.EnvelopeID).Msg("could not reschedule retry")
+		return
+	}
+	log.Debug().Str("error", retry.Error).Str("id", retry.EnvelopeID).Int("attempts", retry.Attempts).Time("next_attempt", retry.NextAttempt).Msg("transfer rescheduled for retry")
+}
+
+// deleteRetry removes the retry of the transfer from the store.
+func (s *Server) deleteRetry(envelopeID string) {
+	if err := s.db.DeleteRetry(envelopeID); err != nil {
+		log.Error().Err(err).Str("id", envelopeID).Msg("could not remove scheduled retry")
+	}
+}
+
+// publishDelivered publishes the delivery of the retried transfer with the state of its
+// transaction, and the message of the reply if the counterparty deferred its reply.
+func (s *Server) publishDelivered(retry *store.Retry, reply *protocol.Payload) {
+	reason := fmt.Sprintf("delivered after %d attempts", retry.Attempts)
+	if msg, ok := pending.FromPayload(reply); ok && msg.Message != "" {
+		reason += ": " + msg.Message
+	}
+
+	var state store.State
+	if tx, err := s.db.GetTransaction(retry.EnvelopeID); err == nil {
+		state = tx.State
+	}
+	s.publish(webhooks.TransferDelivered, retry.EnvelopeID, retry.Counterparty, state, reason)
+}
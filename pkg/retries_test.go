@@ -0,0 +1,56 @@
+package trisarl
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/store"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestScheduleRetry(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "payload.key")
+	if err := ioutil.WriteFile(keyPath, []byte(hex.EncodeToString(make([]byte, 32))), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := store.Open(config.StorageConfig{PayloadKey: keyPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	s := &Server{db: db, conf: config.Config{Retries: config.RetriesConfig{Enabled: true, MaxAttempts: 3, Backoff: time.Second, MaxBackoff: time.Minute}}}
+	counterparty := Counterparty{CommonName: "beneficiary.example.com", Email: "compliance@example.com"}
+	transaction, err := anypb.New(&generic.Transaction{Network: "btc", Amount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := &protocol.Payload{Transaction: transaction}
+
+	// Errors that are not retryable are not scheduled
+	s.scheduleRetry(context.Background(), counterparty, "rejected", payload, &protocol.Error{Code: protocol.Rejected})
+	if _, err = db.GetRetry("rejected"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected rejected transfer not to be retried, got %v", err)
+	}
+
+	s.scheduleRetry(context.Background(), counterparty, "unavailable", payload, &protocol.Error{Code: protocol.Unavailable, Retry: true})
+	retry, err := db.GetRetry("unavailable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.Counterparty != counterparty.CommonName || retry.Email != counterparty.Email {
+		t.Errorf("counterparty was not kept with the retry: %+v", retry)
+	}
+	if retry.Attempts != 1 || retry.Code != protocol.Unavailable.String() || !retry.NextAttempt.After(time.Now()) {
+		t.Errorf("unexpected retry %+v", retry)
+	}
+}
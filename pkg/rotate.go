@@ -0,0 +1,220 @@
+package trisarl
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/envelope"
+	"github.com/rotationalio/trisa/pkg/keychain"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/trisa/pkg/trisa/mtls"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+	"github.com/trisacrypto/trisa/pkg/trust"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// rotationConcurrency is the maximum number of simultaneous key exchanges that push a
+// rotated key to peers.
+const rotationConcurrency = 8
+
+// certificates returns the current mTLS certificates and trust pool. Renewed
+// certificates are swapped in without a restart, so the certificates and everything
+// derived from them must be accessed with the methods that hold the certificate lock
+// rather than the fields of the server.
+func (s *Server) certificates() (*trust.Provider, trust.ProviderPool) {
+	s.certmu.RLock()
+	defer s.certmu.RUnlock()
+	return s.mtlsCerts, s.trustPool
+}
+
+// signing returns the current signing certificates and private key used to open and
+// seal secure envelopes.
+func (s *Server) signing() (*trust.Provider, interface{}) {
+	s.certmu.RLock()
+	defer s.certmu.RUnlock()
+	return s.signingCerts, s.signingKey
+}
+
+// commonName returns the common name of the current mTLS certificates.
+func (s *Server) commonName() string {
+	s.certmu.RLock()
+	defer s.certmu.RUnlock()
+	return s.mtlsCerts.String()
+}
+
+// network returns the peers manager, which uses the current mTLS certificates.
+func (s *Server) network() *peers.Peers {
+	s.certmu.RLock()
+	defer s.certmu.RUnlock()
+	return s.peers
+}
+
+// serverCreds returns gRPC server credentials that look up the TLS configuration for
+// each new connection, so that new handshakes use the current certificates while
+// established connections are unaffected by a rotation. If the server hosts multiple
+// identities the certificates are selected by the server name the peer connects to.
+// Every handshake is audited.
+func (s *Server) serverCreds() grpc.ServerOption {
+	creds := credentials.NewTLS(&tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			s.certmu.RLock()
+			defer s.certmu.RUnlock()
+			if id := s.matchIdentity(hello.ServerName); id != nil {
+				return id.tlsConf, nil
+			}
+			return s.tlsConf, nil
+		},
+	})
+	return grpc.Creds(&auditCreds{TransportCredentials: creds, s: s})
+}
+
+// serverTLSConfig returns the TRISA mTLS configuration for the certificates. The
+// configuration is returned for each client hello, so it must advertise HTTP/2 itself.
+func serverTLSConfig(certs *trust.Provider, pool trust.ProviderPool) (conf *tls.Config, err error) {
+	if conf, err = mtls.Config(certs, pool); err != nil {
+		return nil, err
+	}
+	conf.NextProtos = []string{"h2"}
+	return conf, nil
+}
+
+// RotateCertificates reloads the TRISA mTLS certificates and trust pool from the
+// configured locations and swaps them in without a restart, e.g. after new certificates
+// have been installed manually or fetched from a secret store. New TLS handshakes use
+// the new certificates immediately while established connections continue to use the
+// certificates they were established with until they are closed. The current
+// certificates are kept if the new certificates cannot be loaded or verified.
+func (s *Server) RotateCertificates() error {
+	return s.rotateCertificates(s.config())
+}
+
+// rotateCertificates loads the mTLS certificates from the locations in the config and
+// swaps them in if they are valid. The peers manager is rebuilt so that outgoing
+// connections use the new certificates, carrying over the endpoints of the known peers;
+// their signing keys are kept in the keychain. If the signing key is the key of the
+// mTLS certificates it is rotated as well: the old key is retired to the keyring of
+// previous keys so that it opens the envelopes peers seal before they receive the new
+// key, and the new key is pushed to the peers in the background if peers seal their
+// envelopes with it. The certificates of the additional identities are reloaded with
+// the server certificates.
+func (s *Server) rotateCertificates(conf config.Config) (err error) {
+	var (
+		certs   *trust.Provider
+		pool    trust.ProviderPool
+		tlsConf *tls.Config
+		key     interface{}
+		idents  []*identity
+	)
+
+	if certs, pool, err = loadCertificates(conf); err != nil {
+		return err
+	}
+
+	if err = checkCertificates(conf.Environment, "server", certs, pool); err != nil {
+		return err
+	}
+
+	if tlsConf, err = serverTLSConfig(certs, pool); err != nil {
+		return err
+	}
+
+	if key, err = envelope.PrivateKey(certs); err != nil {
+		return err
+	}
+
+	if idents, err = loadIdentities(conf); err != nil {
+		return err
+	}
+
+	network := peers.New(certs, pool, conf.DirectoryAddr)
+	s.carryOverPeers(s.network(), network)
+	for _, id := range idents {
+		s.carryOverPeers(s.identityPeers(id.certs.String()), id.peers)
+	}
+
+	var retired interface{}
+	s.certmu.Lock()
+	if s.signingCerts == s.mtlsCerts {
+		if keychain.KeyID(envelope.PublicKey(s.signingKey)) != keychain.KeyID(envelope.PublicKey(key)) {
+			retired = s.signingKey
+		}
+		s.signingCerts, s.signingKey = certs, key
+	}
+	s.mtlsCerts, s.trustPool, s.tlsConf = certs, pool, tlsConf
+	s.peers = network
+	s.identities = idents
+	s.certmu.Unlock()
+
+	log.Info().Str("common_name", certs.String()).Msg("server certificates rotated")
+	if retired != nil {
+		s.retireSigningKey(retired)
+	}
+	return nil
+}
+
+// retireSigningKey keeps the signing key that was replaced by reissued certificates in
+// the keyring of previous keys, where it opens envelopes until the server restarts, and
+// pushes the new signing key to the peers in the address book unless peers seal their
+// envelopes with the exchange key, which does not change with the certificates.
+func (s *Server) retireSigningKey(key interface{}) {
+	id := keychain.KeyID(envelope.PublicKey(key))
+	s.exchmu.Lock()
+	s.prevExchange = append([]*previousKey{{id: id, key: key}}, s.prevExchange...)
+	s.exchmu.Unlock()
+	log.Info().Str("key_id", id).Msg("previous signing key retired to keyring")
+
+	if s.config().Exchange.Enabled || s.db == nil || s.directory == nil {
+		return
+	}
+	s.broadcastInBackground("pushed rotated signing key to known peers")
+}
+
+// broadcastInBackground pushes the current key to the peers in the address book in the
+// background, abandoning the key exchanges when the server starts shutting down, and
+// logs the number of peers the key was pushed to with the message.
+func (s *Server) broadcastInBackground(msg string) {
+	s.background(func(ctx context.Context) {
+		results, err := s.BroadcastKeys(ctx, rotationConcurrency)
+		if err != nil {
+			log.Error().Err(err).Msg("could not exchange keys with known peers")
+			return
+		}
+
+		var failed int
+		for _, result := range results {
+			if result.Error != "" {
+				failed++
+			}
+		}
+		log.Info().Int("peers", len(results)).Int("failed", failed).Msg(msg)
+	})
+}
+
+// carryOverPeers copies the info of the peers in the address book, including their
+// endpoints, to the new peers manager. The signing keys of peers are kept in the
+// keychain, so they do not have to be carried over.
+func (s *Server) carryOverPeers(prev, next *peers.Peers) {
+	if prev == nil || s.db == nil {
+		return
+	}
+
+	known, err := s.db.Peers()
+	if err != nil {
+		log.Warn().Err(err).Msg("could not read address book, peers must repeat key exchanges")
+		return
+	}
+
+	for _, record := range known {
+		var peer *peers.Peer
+		if peer, err = prev.Get(record.CommonName); err != nil {
+			continue
+		}
+
+		info := peer.Info()
+		if err = next.Add(&info); err != nil {
+			log.Debug().Err(err).Str("peer", record.CommonName).Msg("could not carry over peer")
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package trisarl
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestRetiredSigningKeyOpensEnvelopes(t *testing.T) {
+	old, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := handler.New("", &protocol.Payload{Transaction: &anypb.Any{TypeUrl: "type.example.com/transaction"}}, nil)
+	in, err := env.Seal(&old.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{}
+	if _, err = s.openEnvelope(in, "peer", current); err == nil {
+		t.Fatal("expected envelope sealed with the old key to fail before it is retired")
+	}
+
+	s.retireSigningKey(old)
+	if _, err = s.openEnvelope(in, "peer", current); err != nil {
+		t.Fatalf("could not open envelope with the retired signing key: %s", err)
+	}
+}
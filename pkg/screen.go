@@ -0,0 +1,117 @@
+package trisarl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/notifications"
+	"github.com/rotationalio/trisa/pkg/screening"
+	"github.com/rotationalio/trisa/pkg/secrets"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+)
+
+// The message of the pending message that is sent to the peer if a transfer is held for
+// review by the screening; the matches are kept with the review but are not disclosed.
+const screeningHeld = "transfer held for compliance review"
+
+// Screen the originators of the transfer against sanctions lists. Transfers with
+// sanctioned originators are rejected with a compliance check error or, if the action
+// is to flag them, answered with a pending message and queued for review. Transfers that
+// cannot be screened, e.g. because the screening provider is unavailable, are rejected
+// with a retryable error so that they are never answered without being screened.
+// Inquiries and pending messages do not have originators to screen.
+func (s *Server) screen(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		screener, conf := s.screener()
+		if screener == nil || t.Identity == nil || t.PendingMessage != nil {
+			return next(ctx, t)
+		}
+
+		sctx, cancel := context.WithTimeout(ctx, conf.Timeout)
+		defer cancel()
+
+		for _, person := range t.Identity.GetOriginator().GetOriginatorPersons() {
+			var matches []screening.Match
+			if matches, err = screener.Screen(sctx, person); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("could not screen originator")
+				return protocol.Errorf(protocol.Unavailable, "could not complete compliance screening, please retry later").WithRetry()
+			}
+			t.Screening = append(t.Screening, matches...)
+		}
+
+		if len(t.Screening) == 0 {
+			return next(ctx, t)
+		}
+
+		matched := make([]string, 0, len(t.Screening))
+		for _, match := range t.Screening {
+			matched = append(matched, match.String())
+		}
+		reason := "originator matched sanctions lists: " + strings.Join(matched, ", ")
+		log.Ctx(ctx).Warn().Str("peer", t.Peer.String()).Strs("matches", matched).Str("action", conf.Action).Msg("originator matched sanctions lists")
+
+		if conf.Action == config.ScreeningFlag {
+			if t.Response, err = s.pend(ctx, t, &PendingError{Message: screeningHeld, Reason: reason}); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("could not queue flagged transfer for review")
+				return protocol.Errorf(protocol.InternalError, "could not process transfer")
+			}
+			s.sendNotification(s.transferNotification(notifications.ScreeningHit, t, reason, "held for review", true))
+			return s.sealResponse(t)
+		}
+
+		s.sendNotification(s.transferNotification(notifications.ScreeningHit, t, reason, "rejected", false))
+		return protocol.Errorf(protocol.ComplianceCheckFail, "transfer rejected by compliance screening")
+	}
+}
+
+// screener returns the screener of the server, which is the screener set by the
+// embedding application or otherwise the screener of the current configuration, along
+// with the screening configuration.
+func (s *Server) screener() (screening.Screener, config.ScreeningConfig) {
+	s.confmu.RLock()
+	defer s.confmu.RUnlock()
+	if s.screenerOpt != nil {
+		return s.screenerOpt, s.conf.Screening
+	}
+	return s.sanctions, s.conf.Screening
+}
+
+// newScreener creates the screener of the sanctions lists and the screening provider in
+// the configuration, or returns nil if screening is not enabled. The lists are loaded
+// when the server is created and when the configuration is reloaded, e.g. after the
+// lists were updated.
+func newScreener(conf config.ScreeningConfig) (_ screening.Screener, err error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+
+	var screeners screening.Multi
+	for _, path := range strings.Split(conf.Lists, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+
+		var list *screening.List
+		if list, err = screening.LoadCSV(path); err != nil {
+			return nil, fmt.Errorf("could not load sanctions list: %s", err)
+		}
+		log.Debug().Str("list", list.Name).Int("names", list.Len()).Msg("sanctions list loaded")
+		screeners = append(screeners, list)
+	}
+
+	if conf.URL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+		defer cancel()
+
+		provider := &screening.HTTPProvider{URL: conf.URL, Client: &http.Client{Timeout: conf.Timeout}}
+		if provider.Token, err = secrets.LoadString(ctx, conf.Token); err != nil {
+			return nil, fmt.Errorf("could not load screening provider token: %s", err)
+		}
+		screeners = append(screeners, provider)
+	}
+	return screeners, nil
+}
@@ -0,0 +1,86 @@
+package screening
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// HTTPProvider screens persons with a screening provider that has an HTTP API, e.g. a
+// small adapter service in front of a commercial screening API. The person is posted as
+// JSON with its names and the protojson encoded IVMS101 person:
+//
+//	{"names": ["Jane Smith"], "legal": false, "person": {...}}
+//
+// and the provider responds with the matches:
+//
+//	{"matches": [{"list": "ofac-sdn", "entry": "12345", "name": "SMITH, Jane", "score": 0.97}]}
+//
+// If Token is set it is sent as a bearer token.
+type HTTPProvider struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+type screenRequest struct {
+	Names  []string        `json:"names"`
+	Legal  bool            `json:"legal"`
+	Person json.RawMessage `json:"person"`
+}
+
+type screenReply struct {
+	Matches []Match `json:"matches"`
+}
+
+// Screen implements Screener
+func (p *HTTPProvider) Screen(ctx context.Context, person *ivms101.Person) (_ []Match, err error) {
+	req := &screenRequest{Names: Names(person), Legal: person.GetLegalPerson() != nil}
+	if req.Person, err = protojson.Marshal(person); err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	if body, err = json.Marshal(req); err != nil {
+		return nil, err
+	}
+
+	var hreq *http.Request
+	if hreq, err = http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body)); err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	hreq.Header.Set("Accept", "application/json")
+	if p.Token != "" {
+		hreq.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var rep *http.Response
+	if rep, err = client.Do(hreq); err != nil {
+		return nil, fmt.Errorf("could not reach screening provider: %s", err)
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(rep.Body, 512))
+		return nil, fmt.Errorf("screening provider returned %s: %s", rep.Status, bytes.TrimSpace(msg))
+	}
+
+	reply := &screenReply{}
+	if err = json.NewDecoder(rep.Body).Decode(reply); err != nil {
+		return nil, fmt.Errorf("could not decode screening provider reply: %s", err)
+	}
+	return reply.Matches, nil
+}
@@ -0,0 +1,114 @@
+package screening
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+)
+
+// List is a sanctions list loaded into memory that screens persons by name. A person
+// matches an entry if any of its names is the name or one of the aliases of the entry.
+type List struct {
+	Name    string
+	entries map[string][]Match
+}
+
+// LoadCSV loads the sanctions list from a CSV file, which is named after the file
+// without its extension, e.g. "ofac-sdn" for ofac-sdn.csv.
+func LoadCSV(path string) (_ *List, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return ReadCSV(name, f)
+}
+
+// ReadCSV reads a sanctions list in CSV format. The first row is the header and the
+// name column is required; the optional id column is the ID of the entry on the list
+// and the optional aliases column contains other names of the entry separated by
+// semicolons. Other columns are ignored, so exports of the official lists only need
+// their columns renamed.
+func ReadCSV(name string, r io.Reader) (_ *List, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var header []string
+	if header, err = reader.Read(); err != nil {
+		return nil, fmt.Errorf("could not read header of sanctions list %s: %s", name, err)
+	}
+
+	columns := map[string]int{"name": -1, "id": -1, "aliases": -1}
+	for i, column := range header {
+		if _, ok := columns[strings.ToLower(strings.TrimSpace(column))]; ok {
+			columns[strings.ToLower(strings.TrimSpace(column))] = i
+		}
+	}
+	if columns["name"] < 0 {
+		return nil, fmt.Errorf("sanctions list %s has no name column", name)
+	}
+
+	list := &List{Name: name, entries: make(map[string][]Match)}
+	for {
+		var record []string
+		if record, err = reader.Read(); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("could not read sanctions list %s: %s", name, err)
+		}
+
+		entry := Match{List: name, Name: field(record, columns["name"]), Entry: field(record, columns["id"]), Score: 1}
+		if entry.Name == "" {
+			continue
+		}
+
+		list.add(entry.Name, entry)
+		for _, alias := range strings.Split(field(record, columns["aliases"]), ";") {
+			list.add(alias, entry)
+		}
+	}
+	return list, nil
+}
+
+// Len returns the number of names and aliases on the list.
+func (l *List) Len() int {
+	return len(l.entries)
+}
+
+// Screen implements Screener
+func (l *List) Screen(_ context.Context, person *ivms101.Person) (matches []Match, _ error) {
+	seen := make(map[Match]bool)
+	for _, name := range Names(person) {
+		for _, match := range l.entries[Normalize(name)] {
+			if !seen[match] {
+				seen[match] = true
+				matches = append(matches, match)
+			}
+		}
+	}
+	return matches, nil
+}
+
+func (l *List) add(name string, entry Match) {
+	if key := Normalize(name); key != "" {
+		l.entries[key] = append(l.entries[key], entry)
+	}
+}
+
+func field(record []string, idx int) string {
+	if idx < 0 || idx >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[idx])
+}
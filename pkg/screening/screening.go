@@ -0,0 +1,87 @@
+/*
+Package screening screens the parties of incoming transfers against sanctions lists such
+as the OFAC SDN list or the EU and UN consolidated lists. A Screener is called with the
+IVMS101 person of each originator and returns the list entries the person matches. The
+package provides a screener for lists loaded from CSV files and an adapter for HTTP
+screening providers; other providers are integrated by implementing Screener.
+*/
+package screening
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+)
+
+// Match is an entry of a sanctions list that a person matches.
+type Match struct {
+	List  string  `json:"list"`
+	Entry string  `json:"entry,omitempty"`
+	Name  string  `json:"name"`
+	Score float64 `json:"score,omitempty"`
+}
+
+func (m Match) String() string {
+	if m.Entry != "" {
+		return fmt.Sprintf("%s (%s %s)", m.Name, m.List, m.Entry)
+	}
+	return fmt.Sprintf("%s (%s)", m.Name, m.List)
+}
+
+// Screener screens a natural or legal person against sanctions lists. It returns the
+// entries that the person matches, which are empty if the person is not sanctioned, or
+// an error if the person could not be screened, e.g. because a provider is unavailable.
+type Screener interface {
+	Screen(ctx context.Context, person *ivms101.Person) ([]Match, error)
+}
+
+// ScreenerFunc adapts a function to the Screener interface.
+type ScreenerFunc func(ctx context.Context, person *ivms101.Person) ([]Match, error)
+
+// Screen implements Screener
+func (f ScreenerFunc) Screen(ctx context.Context, person *ivms101.Person) ([]Match, error) {
+	return f(ctx, person)
+}
+
+// Multi screens persons with every screener and returns all of their matches. The
+// person is not screened by the remaining screeners if one of them fails.
+type Multi []Screener
+
+// Screen implements Screener
+func (m Multi) Screen(ctx context.Context, person *ivms101.Person) (matches []Match, err error) {
+	for _, screener := range m {
+		var found []Match
+		if found, err = screener.Screen(ctx, person); err != nil {
+			return nil, err
+		}
+		matches = append(matches, found...)
+	}
+	return matches, nil
+}
+
+// Names returns the names of the natural or legal person, with the secondary (first)
+// name of natural persons before the primary (last) name.
+func Names(person *ivms101.Person) []string {
+	switch {
+	case person.GetNaturalPerson() != nil:
+		return person.GetNaturalPerson().Names()
+	case person.GetLegalPerson() != nil:
+		return person.GetLegalPerson().Names()
+	}
+	return nil
+}
+
+// Normalize returns the name in a form that is compared with the names of list entries:
+// case, punctuation, and the order of the words are ignored, so that "SMITH, Jane" and
+// "Jane Smith" are the same name.
+func Normalize(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
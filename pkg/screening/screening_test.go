@@ -0,0 +1,116 @@
+package screening
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"Jane Smith", "jane smith"},
+		{"SMITH, Jane", "jane smith"},
+		{"  jane   smith ", "jane smith"},
+		{"O'Brien-Smith, Jane", "brien jane o smith"},
+		{"Müller, Jürgen", "jürgen müller"},
+		{"Acme Holdings Ltd.", "acme holdings ltd"},
+		{"Acme 2000", "2000 acme"},
+		{"", ""},
+		{"--", ""},
+	}
+
+	for _, tc := range tests {
+		if actual := Normalize(tc.name); actual != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+const testList = `id,name,aliases,program
+SDN-1,"SMITH, Jane",Jane Doe;J. Smith,SDGT
+SDN-2,Acme Holdings Ltd.,,IRAN
+SDN-3,"DOE, John",Jane Doe,SDGT
+SDN-4,,Nameless,SDGT
+`
+
+func naturalPerson(names ...[2]string) *ivms101.Person {
+	person := &ivms101.NaturalPerson{Name: &ivms101.NaturalPersonName{}}
+	for _, name := range names {
+		person.Name.NameIdentifiers = append(person.Name.NameIdentifiers, &ivms101.NaturalPersonNameId{
+			PrimaryIdentifier:   name[0],
+			SecondaryIdentifier: name[1],
+			NameIdentifierType:  ivms101.NaturalPersonLegal,
+		})
+	}
+	return &ivms101.Person{Person: &ivms101.Person_NaturalPerson{NaturalPerson: person}}
+}
+
+func legalPerson(name string) *ivms101.Person {
+	return &ivms101.Person{Person: &ivms101.Person_LegalPerson{LegalPerson: &ivms101.LegalPerson{
+		Name: &ivms101.LegalPersonName{NameIdentifiers: []*ivms101.LegalPersonNameId{{
+			LegalPersonName:               name,
+			LegalPersonNameIdentifierType: ivms101.LegalPersonLegal,
+		}}},
+	}}}
+}
+
+func TestListScreen(t *testing.T) {
+	list, err := ReadCSV("ofac-sdn", strings.NewReader(testList))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries without a name are skipped along with their aliases.
+	if list.Len() != 5 {
+		t.Fatalf("expected 5 distinct names and aliases on the list, got %d", list.Len())
+	}
+
+	tests := []struct {
+		name    string
+		person  *ivms101.Person
+		entries []string
+	}{
+		{"name", naturalPerson([2]string{"Smith", "Jane"}), []string{"SDN-1"}},
+		{"name in another case and order", naturalPerson([2]string{"SMITH", "jane"}), []string{"SDN-1"}},
+		{"alias", naturalPerson([2]string{"Smith", "J."}), []string{"SDN-1"}},
+		{"alias of several entries", naturalPerson([2]string{"Doe", "Jane"}), []string{"SDN-1", "SDN-3"}},
+		{"several names of one entry", naturalPerson([2]string{"Smith", "Jane"}, [2]string{"Smith", "J"}), []string{"SDN-1"}},
+		{"several names of several entries", naturalPerson([2]string{"Smith", "Jane"}, [2]string{"Doe", "John"}), []string{"SDN-1", "SDN-3"}},
+		{"partial name", naturalPerson([2]string{"Smith", ""}), nil},
+		{"other name", naturalPerson([2]string{"Smith", "John"}), nil},
+		{"legal person", legalPerson("ACME HOLDINGS LTD"), []string{"SDN-2"}},
+		{"legal person with another name", legalPerson("Acme Holdings Inc."), nil},
+		{"alias of an entry without a name", legalPerson("Nameless"), nil},
+		{"no person", &ivms101.Person{}, nil},
+	}
+
+	for _, tc := range tests {
+		matches, err := list.Screen(context.Background(), tc.person)
+		if err != nil {
+			t.Fatalf("%s: could not screen person: %s", tc.name, err)
+		}
+
+		entries := make([]string, 0, len(matches))
+		for _, match := range matches {
+			if match.List != "ofac-sdn" {
+				t.Errorf("%s: expected match on the ofac-sdn list, got %s", tc.name, match.List)
+			}
+			entries = append(entries, match.Entry)
+		}
+
+		if strings.Join(entries, ",") != strings.Join(tc.entries, ",") {
+			t.Errorf("%s: expected matches [%s], got [%s]", tc.name, strings.Join(tc.entries, ","), strings.Join(entries, ","))
+		}
+	}
+}
+
+func TestReadCSVRequiresName(t *testing.T) {
+	if _, err := ReadCSV("ofac-sdn", strings.NewReader("id,aliases\nSDN-1,Jane Smith\n")); err == nil {
+		t.Fatal("expected a list without a name column to be rejected")
+	}
+}
@@ -0,0 +1,558 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWS credential endpoints for containers (ECS, EKS), EC2 instances, and web identities
+var (
+	awsContainerCredentialsHost = "http://169.254.170.2"
+	awsInstanceMetadataURL      = "http://169.254.169.254/latest/"
+	awsSTSURL                   = "https://sts.amazonaws.com/"
+)
+
+func init() {
+	Register("s3", LoaderFunc(loadS3Object))
+	Register("awssecret", LoaderFunc(loadAWSSecret))
+}
+
+// loadS3Object downloads an object from S3, e.g. s3://bucket/path/to/trisa.pem. The
+// region is specified with the region query parameter or $AWS_REGION and a specific
+// version of the object can be downloaded with the version query parameter.
+func loadS3Object(ctx context.Context, uri *url.URL) (_ []byte, err error) {
+	bucket, key := uri.Host, strings.TrimPrefix(uri.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("could not parse s3 bucket and key from %q", uri.String())
+	}
+
+	var region string
+	if region, err = awsRegion(uri.Query().Get("region")); err != nil {
+		return nil, err
+	}
+
+	// Use virtual hosted style requests unless the bucket name is not a valid hostname
+	endpoint := &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, region), Path: "/" + key}
+	if strings.Contains(bucket, ".") {
+		endpoint.Host = fmt.Sprintf("s3.%s.amazonaws.com", region)
+		endpoint.Path = "/" + bucket + "/" + key
+	}
+	endpoint.RawPath = awsEscapePath(endpoint.Path)
+
+	if version := uri.Query().Get("version"); version != "" {
+		endpoint.RawQuery = url.Values{"versionId": {version}}.Encode()
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil); err != nil {
+		return nil, err
+	}
+
+	if err = awsSign(ctx, req, nil, "s3", region); err != nil {
+		return nil, err
+	}
+
+	var rep *http.Response
+	if rep, err = http.DefaultClient.Do(req); err != nil {
+		return nil, err
+	}
+	defer rep.Body.Close()
+
+	var data []byte
+	if data, err = ioutil.ReadAll(rep.Body); err != nil {
+		return nil, err
+	}
+
+	if rep.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not get s3 object %s/%s: %s: %s", bucket, key, rep.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// loadAWSSecret gets a secret value from AWS Secrets Manager by name, e.g.
+// awssecret://trisa/certs, or by ARN, e.g. awssecret:///arn:aws:secretsmanager:...
+// (note the empty host since ARNs cannot be parsed as a hostname). The version_stage
+// and version_id query parameters select a version other than AWSCURRENT. If the
+// secret is a JSON object, the field query parameter selects the value of one key.
+func loadAWSSecret(ctx context.Context, uri *url.URL) (_ []byte, err error) {
+	name := strings.Trim(uri.Host+uri.Path, "/")
+	if name == "" {
+		return nil, fmt.Errorf("could not parse secret name from %q", uri.String())
+	}
+
+	// The region of an ARN is the fourth component
+	query := uri.Query()
+	region := query.Get("region")
+	if parts := strings.Split(name, ":"); region == "" && len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+
+	if region, err = awsRegion(region); err != nil {
+		return nil, err
+	}
+
+	input := map[string]string{"SecretId": name}
+	if stage := query.Get("version_stage"); stage != "" {
+		input["VersionStage"] = stage
+	}
+	if version := query.Get("version_id"); version != "" {
+		input["VersionId"] = version
+	}
+
+	var body []byte
+	if body, err = json.Marshal(input); err != nil {
+		return nil, err
+	}
+
+	var req *http.Request
+	endpoint := fmt.Sprintf("https://secretsmanager.%s.amazonaws.com/", region)
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body)); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+
+	if err = awsSign(ctx, req, body, "secretsmanager", region); err != nil {
+		return nil, err
+	}
+
+	var rep struct {
+		SecretString *string `json:"SecretString"`
+		SecretBinary string  `json:"SecretBinary"`
+	}
+	if err = doJSON(http.DefaultClient, req, &rep); err != nil {
+		return nil, fmt.Errorf("could not get secret %s: %s", name, err)
+	}
+
+	if rep.SecretString == nil {
+		return base64.StdEncoding.DecodeString(rep.SecretBinary)
+	}
+
+	if field := query.Get("field"); field != "" {
+		values := make(map[string]interface{})
+		if err = json.Unmarshal([]byte(*rep.SecretString), &values); err != nil {
+			return nil, fmt.Errorf("secret %s is not a json object: %s", name, err)
+		}
+
+		var value string
+		if value, err = secretField(values, field); err != nil {
+			return nil, fmt.Errorf("secret %s: %s", name, err)
+		}
+		return []byte(value), nil
+	}
+	return []byte(*rep.SecretString), nil
+}
+
+// AWSRegion returns the region if specified, otherwise the region from the environment.
+func AWSRegion(region string) (string, error) {
+	return awsRegion(region)
+}
+
+// awsRegion returns the region if specified, otherwise the region from the environment.
+func awsRegion(region string) (string, error) {
+	for _, val := range []string{region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+		if val != "" {
+			return val, nil
+		}
+	}
+	return "", errors.New("aws region required: set $AWS_REGION or the region query parameter")
+}
+
+//===========================================================================
+// AWS Credentials
+//===========================================================================
+
+type awsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	SessionToken    string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// Credentials fetched from containers or instances are cached until shortly before
+// they expire; credentials from the environment and shared files are read every time.
+var (
+	awsmu    sync.Mutex
+	awscreds *awsCredentials
+)
+
+// awsCredentialChain resolves credentials in the same order as the AWS SDKs: the
+// environment, the shared credentials file, the IAM role of a web identity (e.g. an EKS
+// service account), the container credentials endpoint, and finally the EC2 instance
+// metadata service.
+func awsCredentialChain(ctx context.Context) (creds *awsCredentials, err error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	if creds, err = awsSharedCredentials(); err != nil || creds != nil {
+		return creds, err
+	}
+
+	awsmu.Lock()
+	defer awsmu.Unlock()
+	if awscreds != nil && time.Until(awscreds.Expiration) > 5*time.Minute {
+		return awscreds, nil
+	}
+
+	if os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" && os.Getenv("AWS_ROLE_ARN") != "" {
+		if creds, err = awsWebIdentityCredentials(ctx); err != nil {
+			return nil, fmt.Errorf("could not assume role with web identity: %s", err)
+		}
+	} else if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+		if creds, err = awsContainerCredentials(ctx); err != nil {
+			return nil, fmt.Errorf("could not get container credentials: %s", err)
+		}
+	} else {
+		if creds, err = awsInstanceCredentials(ctx); err != nil {
+			return nil, fmt.Errorf("no aws credentials found in the environment, shared credentials file, or instance metadata: %s", err)
+		}
+	}
+
+	awscreds = creds
+	return creds, nil
+}
+
+// awsSharedCredentials reads the profile from the shared credentials file if it exists.
+func awsSharedCredentials() (_ *awsCredentials, err error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		var home string
+		if home, err = os.UserHomeDir(); err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		section string
+		creds   awsCredentials
+	)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+		case section == profile:
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			val := strings.TrimSpace(kv[1])
+			switch strings.TrimSpace(kv[0]) {
+			case "aws_access_key_id":
+				creds.AccessKeyID = val
+			case "aws_secret_access_key":
+				creds.SecretAccessKey = val
+			case "aws_session_token":
+				creds.SessionToken = val
+			}
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if creds.AccessKeyID == "" {
+		return nil, nil
+	}
+	return &creds, nil
+}
+
+// awsWebIdentityCredentials assumes the role in $AWS_ROLE_ARN with the web identity
+// token in $AWS_WEB_IDENTITY_TOKEN_FILE, which EKS mounts for pods whose service account
+// is associated with an IAM role. The request is authenticated by the token, not signed.
+func awsWebIdentityCredentials(ctx context.Context) (creds *awsCredentials, err error) {
+	var token []byte
+	if token, err = ioutil.ReadFile(os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")); err != nil {
+		return nil, err
+	}
+
+	session := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = fmt.Sprintf("trisa-%d", time.Now().Unix())
+	}
+
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {os.Getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, awsSTSURL, strings.NewReader(query.Encode())); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var body []byte
+	if body, err = doText(http.DefaultClient, req); err != nil {
+		return nil, err
+	}
+
+	var rep struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err = xml.Unmarshal(body, &rep); err != nil {
+		return nil, err
+	}
+
+	if rep.Credentials.AccessKeyID == "" {
+		return nil, errors.New("no credentials in the assume role response")
+	}
+
+	return &awsCredentials{
+		AccessKeyID:     rep.Credentials.AccessKeyID,
+		SecretAccessKey: rep.Credentials.SecretAccessKey,
+		SessionToken:    rep.Credentials.SessionToken,
+		Expiration:      rep.Credentials.Expiration,
+	}, nil
+}
+
+func awsContainerCredentials(ctx context.Context) (creds *awsCredentials, err error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		endpoint = awsContainerCredentialsHost + rel
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil); err != nil {
+		return nil, err
+	}
+
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	creds = &awsCredentials{}
+	if err = doJSON(http.DefaultClient, req, creds); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// awsInstanceCredentials uses IMDSv2 to get the credentials of the instance role.
+func awsInstanceCredentials(ctx context.Context) (creds *awsCredentials, err error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPut, awsInstanceMetadataURL+"api/token", nil); err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+
+	var token, role []byte
+	if token, err = doText(client, req); err != nil {
+		return nil, err
+	}
+
+	metadata := awsInstanceMetadataURL + "meta-data/iam/security-credentials/"
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, metadata, nil); err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+
+	if role, err = doText(client, req); err != nil {
+		return nil, err
+	}
+
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, metadata+strings.TrimSpace(string(role)), nil); err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+
+	creds = &awsCredentials{}
+	if err = doJSON(client, req, creds); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// doText executes the request and returns the body of a successful response.
+func doText(client *http.Client, req *http.Request) (_ []byte, err error) {
+	var rep *http.Response
+	if rep, err = client.Do(req); err != nil {
+		return nil, err
+	}
+	defer rep.Body.Close()
+
+	var body []byte
+	if body, err = ioutil.ReadAll(rep.Body); err != nil {
+		return nil, err
+	}
+
+	if rep.StatusCode < 200 || rep.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s: %s", rep.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+//===========================================================================
+// AWS Signature Version 4
+//===========================================================================
+
+const awsTimeFormat = "20060102T150405Z"
+
+// AWSSign signs the request to the AWS service in the region with AWS Signature Version
+// 4, using the same credentials as requests to S3 and Secrets Manager.
+func AWSSign(ctx context.Context, req *http.Request, body []byte, service, region string) error {
+	return awsSign(ctx, req, body, service, region)
+}
+
+// awsSign signs the request with AWS Signature Version 4 using the credential chain.
+func awsSign(ctx context.Context, req *http.Request, body []byte, service, region string) (err error) {
+	var creds *awsCredentials
+	if creds, err = awsCredentialChain(ctx); err != nil {
+		return err
+	}
+	awsSignRequest(req, body, creds, service, region, time.Now().UTC())
+	return nil
+}
+
+func awsSignRequest(req *http.Request, body []byte, creds *awsCredentials, service, region string, now time.Time) {
+	timestamp := now.Format(awsTimeFormat)
+	date := timestamp[:8]
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", timestamp)
+	if service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	// Canonical headers are lowercase, sorted, and have trimmed values
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		awsEscapePath(req.URL.Path),
+		awsCanonicalQuery(req.URL.Query()),
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", timestamp, scope, sha256Hex([]byte(canonical))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Del("Host")
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func awsEscapePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func awsCanonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for key, vals := range query {
+		for _, val := range vals {
+			pairs = append(pairs, awsEscape(key)+"="+awsEscape(val))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape percent-encodes everything except the unreserved characters.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Requests and signatures from the AWS Signature Version 4 test suite and the IAM
+// examples of the AWS General Reference, which are all signed with the example
+// credentials for us-east-1 at 20150830T123600Z.
+func TestAWSSignRequest(t *testing.T) {
+	creds := &awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		method    string
+		url       string
+		headers   map[string]string
+		body      string
+		service   string
+		signed    string
+		signature string
+	}{
+		{
+			name:      "get-vanilla",
+			method:    http.MethodGet,
+			url:       "https://example.amazonaws.com/",
+			service:   "service",
+			signed:    "host;x-amz-date",
+			signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:      "get-vanilla-query-order-key-case",
+			method:    http.MethodGet,
+			url:       "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			service:   "service",
+			signed:    "host;x-amz-date",
+			signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:      "post-vanilla",
+			method:    http.MethodPost,
+			url:       "https://example.amazonaws.com/",
+			service:   "service",
+			signed:    "host;x-amz-date",
+			signature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:      "iam-list-users",
+			method:    http.MethodGet,
+			url:       "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			headers:   map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			service:   "iam",
+			signed:    "content-type;host;x-amz-date",
+			signature: "5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+
+	for _, tc := range tests {
+		req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range tc.headers {
+			req.Header.Set(name, value)
+		}
+
+		awsSignRequest(req, []byte(tc.body), creds, tc.service, "us-east-1", now)
+
+		expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/" + tc.service + "/aws4_request, SignedHeaders=" + tc.signed + ", Signature=" + tc.signature
+		if auth := req.Header.Get("Authorization"); auth != expected {
+			t.Errorf("%s: unexpected authorization\n  expected: %s\n       got: %s", tc.name, expected, auth)
+		}
+		if date := req.Header.Get("X-Amz-Date"); date != "20150830T123600Z" {
+			t.Errorf("%s: unexpected date %s", tc.name, date)
+		}
+	}
+}
+
+func TestAWSSignSessionToken(t *testing.T) {
+	creds := &awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", SessionToken: "token"}
+	req, err := http.NewRequest(http.MethodGet, "https://bucket.s3.us-east-1.amazonaws.com/trisa.pem", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	awsSignRequest(req, nil, creds, "s3", "us-east-1", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	if req.Header.Get("X-Amz-Security-Token") != "token" {
+		t.Error("the session token was not sent")
+	}
+	if req.Header.Get("X-Amz-Content-Sha256") != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Error("the payload hash was not sent to s3")
+	}
+	if auth := req.Header.Get("Authorization"); !strings.Contains(auth, "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token,") {
+		t.Errorf("the session token and payload hash were not signed: %s", auth)
+	}
+}
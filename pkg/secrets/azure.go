@@ -0,0 +1,191 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Azure Active Directory and instance metadata endpoints
+var (
+	azureAuthorityURL   = "https://login.microsoftonline.com/"
+	azureIdentityURL    = "http://169.254.169.254/metadata/identity/oauth2/token"
+	azureKeyVaultSuffix = ".vault.azure.net"
+	azureKeyVaultAPI    = "7.4"
+)
+
+func init() {
+	Register("azkv", LoaderFunc(loadAzureSecret))
+}
+
+// loadAzureSecret gets a secret from Azure Key Vault, e.g. azkv://myvault/trisa-exchange-key
+// for the vault https://myvault.vault.azure.net, or with the full hostname of a vault in
+// a sovereign cloud, e.g. azkv://myvault.vault.azure.cn/trisa-exchange-key. The version
+// query parameter selects a version other than the current version. Secrets that back
+// Key Vault certificates are PKCS12 or PEM encoded depending on the content type of the
+// certificate policy, and PKCS12 data is base64 decoded.
+func loadAzureSecret(ctx context.Context, uri *url.URL) (_ []byte, err error) {
+	vault, name := uri.Host, strings.Trim(uri.Path, "/")
+	if vault == "" || name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("could not parse key vault and secret name from %q", uri.String())
+	}
+
+	if !strings.Contains(vault, ".") {
+		vault += azureKeyVaultSuffix
+	}
+
+	// The token is requested for the vault resource of the cloud of the vault
+	resource := "https://" + vault[strings.Index(vault, ".")+1:]
+
+	var token string
+	if token, err = azureAccessToken(ctx, resource); err != nil {
+		return nil, fmt.Errorf("could not authenticate with azure: %s", err)
+	}
+
+	endpoint := &url.URL{
+		Scheme:   "https",
+		Host:     vault,
+		Path:     "/secrets/" + name + "/" + uri.Query().Get("version"),
+		RawQuery: url.Values{"api-version": {azureKeyVaultAPI}}.Encode(),
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var rep struct {
+		Value       string `json:"value"`
+		ContentType string `json:"contentType"`
+	}
+	if err = doJSON(http.DefaultClient, req, &rep); err != nil {
+		return nil, fmt.Errorf("could not get secret %s from %s: %s", name, vault, err)
+	}
+
+	if rep.ContentType == "application/x-pkcs12" {
+		return base64.StdEncoding.DecodeString(rep.Value)
+	}
+	return []byte(rep.Value), nil
+}
+
+// Access tokens are cached by resource until shortly before they expire.
+var (
+	azuremu     sync.Mutex
+	azureTokens = make(map[string]*azureToken)
+)
+
+type azureToken struct {
+	token   string
+	expires time.Time
+}
+
+// azureAccessToken gets an access token for the resource in the same order as the Azure
+// SDKs: a service principal with a client secret ($AZURE_TENANT_ID, $AZURE_CLIENT_ID,
+// and $AZURE_CLIENT_SECRET), a workload identity ($AZURE_FEDERATED_TOKEN_FILE, e.g. in
+// AKS), and finally the managed identity of the VM, App Service, or container instance,
+// selecting a user assigned identity with $AZURE_CLIENT_ID.
+func azureAccessToken(ctx context.Context, resource string) (_ string, err error) {
+	azuremu.Lock()
+	defer azuremu.Unlock()
+	if cached, ok := azureTokens[resource]; ok && time.Until(cached.expires) > 5*time.Minute {
+		return cached.token, nil
+	}
+
+	var req *http.Request
+	tenant, client := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID")
+	switch {
+	case tenant != "" && client != "" && os.Getenv("AZURE_CLIENT_SECRET") != "":
+		req, err = azureClientRequest(ctx, tenant, url.Values{
+			"client_id":     {client},
+			"client_secret": {os.Getenv("AZURE_CLIENT_SECRET")},
+			"scope":         {resource + "/.default"},
+			"grant_type":    {"client_credentials"},
+		})
+	case tenant != "" && client != "" && os.Getenv("AZURE_FEDERATED_TOKEN_FILE") != "":
+		var assertion []byte
+		if assertion, err = ioutil.ReadFile(os.Getenv("AZURE_FEDERATED_TOKEN_FILE")); err != nil {
+			return "", err
+		}
+
+		req, err = azureClientRequest(ctx, tenant, url.Values{
+			"client_id":             {client},
+			"client_assertion":      {strings.TrimSpace(string(assertion))},
+			"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+			"scope":                 {resource + "/.default"},
+			"grant_type":            {"client_credentials"},
+		})
+	default:
+		req, err = azureManagedIdentityRequest(ctx, resource, client)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	// Managed identity endpoints return expires_in as a string
+	var rep struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   interface{} `json:"expires_in"`
+	}
+	if err = doJSON(&http.Client{Timeout: 10 * time.Second}, req, &rep); err != nil {
+		return "", err
+	}
+
+	if rep.AccessToken == "" {
+		return "", errors.New("no access token returned by azure")
+	}
+
+	var seconds float64
+	switch v := rep.ExpiresIn.(type) {
+	case float64:
+		seconds = v
+	case string:
+		fmt.Sscan(v, &seconds)
+	}
+
+	azureTokens[resource] = &azureToken{token: rep.AccessToken, expires: time.Now().Add(time.Duration(seconds) * time.Second)}
+	return rep.AccessToken, nil
+}
+
+func azureClientRequest(ctx context.Context, tenant string, form url.Values) (req *http.Request, err error) {
+	endpoint := azureAuthorityURL + url.PathEscape(tenant) + "/oauth2/v2.0/token"
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode())); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// azureManagedIdentityRequest uses the App Service identity endpoint if it is available
+// and otherwise the instance metadata service.
+func azureManagedIdentityRequest(ctx context.Context, resource, client string) (req *http.Request, err error) {
+	endpoint, version := azureIdentityURL, "2018-02-01"
+	if os.Getenv("IDENTITY_ENDPOINT") != "" && os.Getenv("IDENTITY_HEADER") != "" {
+		endpoint, version = os.Getenv("IDENTITY_ENDPOINT"), "2019-08-01"
+	}
+
+	query := url.Values{"api-version": {version}, "resource": {resource}}
+	if client != "" {
+		query.Set("client_id", client)
+	}
+
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil); err != nil {
+		return nil, err
+	}
+
+	if header := os.Getenv("IDENTITY_HEADER"); header != "" && endpoint != azureIdentityURL {
+		req.Header.Set("X-IDENTITY-HEADER", header)
+	} else {
+		req.Header.Set("Metadata", "true")
+	}
+	return req, nil
+}
@@ -0,0 +1,84 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+const testVaultResource = "https://vault.azure.net"
+
+func TestAzureClientSecretToken(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		r.ParseForm()
+		if r.URL.Path != "/tenant/oauth2/v2.0/token" || r.PostForm.Get("grant_type") != "client_credentials" ||
+			r.PostForm.Get("client_id") != "client" || r.PostForm.Get("client_secret") != "secret" ||
+			r.PostForm.Get("scope") != testVaultResource+"/.default" {
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "client", "expires_in": 3599, "token_type": "Bearer"})
+	}))
+	defer srv.Close()
+
+	defer func(url string) { azureAuthorityURL = url }(azureAuthorityURL)
+	azureAuthorityURL = srv.URL + "/"
+	defer resetAzureTokens()
+
+	for key, value := range map[string]string{"AZURE_TENANT_ID": "tenant", "AZURE_CLIENT_ID": "client", "AZURE_CLIENT_SECRET": "secret"} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	for i := 0; i < 2; i++ {
+		token, err := azureAccessToken(context.Background(), testVaultResource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "client" {
+			t.Fatalf("unexpected token %q", token)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the token to be cached, got %d token requests", requests)
+	}
+}
+
+func TestAzureManagedIdentityToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.Header.Get("Metadata") != "true" || query.Get("api-version") != "2018-02-01" || query.Get("resource") != testVaultResource {
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
+		// The instance metadata service returns expires_in as a string
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "managed", "expires_in": "86399", "token_type": "Bearer"})
+	}))
+	defer srv.Close()
+
+	defer func(url string) { azureIdentityURL = url }(azureIdentityURL)
+	azureIdentityURL = srv.URL
+	defer resetAzureTokens()
+
+	token, err := azureAccessToken(context.Background(), testVaultResource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "managed" {
+		t.Fatalf("unexpected token %q", token)
+	}
+	if cached := azureTokens[testVaultResource]; cached == nil || cached.expires.IsZero() {
+		t.Error("the managed identity token was not cached until it expires")
+	}
+}
+
+func resetAzureTokens() {
+	azuremu.Lock()
+	azureTokens = make(map[string]*azureToken)
+	azuremu.Unlock()
+}
@@ -0,0 +1,122 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// GCP Secret Manager and metadata server endpoints
+var (
+	gcpSecretManagerURL = "https://secretmanager.googleapis.com/v1/"
+	gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+func init() {
+	Register("gcpsecret", LoaderFunc(loadGCPSecret))
+}
+
+// loadGCPSecret accesses a secret version in Google Secret Manager. The URI is either
+// the full resource name of the secret version, gcpsecret://projects/p/secrets/s/versions/v,
+// or the short form gcpsecret://project/secret[/version]; if no version is specified
+// then the latest version is accessed. Requests are authenticated with an access token
+// from $GOOGLE_OAUTH_ACCESS_TOKEN or from the metadata server when running on GCP.
+func loadGCPSecret(ctx context.Context, uri *url.URL) (_ []byte, err error) {
+	var name string
+	if name, err = gcpSecretName(uri); err != nil {
+		return nil, err
+	}
+
+	var token string
+	if token, err = gcpAccessToken(ctx); err != nil {
+		return nil, fmt.Errorf("could not authenticate with google cloud: %s", err)
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, gcpSecretManagerURL+name+":access", nil); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var rep struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err = doJSON(http.DefaultClient, req, &rep); err != nil {
+		return nil, fmt.Errorf("could not access secret %s: %s", name, err)
+	}
+	return base64.StdEncoding.DecodeString(rep.Payload.Data)
+}
+
+func gcpSecretName(uri *url.URL) (string, error) {
+	name := strings.Trim(uri.Host+uri.Path, "/")
+	if strings.HasPrefix(name, "projects/") {
+		if !strings.Contains(name, "/versions/") {
+			name += "/versions/latest"
+		}
+		return name, nil
+	}
+
+	parts := strings.Split(name, "/")
+	switch len(parts) {
+	case 2:
+		return fmt.Sprintf("projects/%s/secrets/%s/versions/latest", parts[0], parts[1]), nil
+	case 3:
+		return fmt.Sprintf("projects/%s/secrets/%s/versions/%s", parts[0], parts[1], parts[2]), nil
+	default:
+		return "", fmt.Errorf("could not parse secret name from %q", uri.String())
+	}
+}
+
+// GCPAccessToken returns an access token for Google Cloud APIs, which is authenticated
+// in the same way as requests to Secret Manager.
+func GCPAccessToken(ctx context.Context) (string, error) {
+	return gcpAccessToken(ctx)
+}
+
+func gcpAccessToken(ctx context.Context) (_ string, err error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, gcpMetadataTokenURL, nil); err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	var rep struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = doJSON(http.DefaultClient, req, &rep); err != nil {
+		return "", err
+	}
+
+	if rep.AccessToken == "" {
+		return "", errors.New("no access token returned by metadata server")
+	}
+	return rep.AccessToken, nil
+}
+
+// doJSON executes the request and decodes a successful JSON response into v.
+func doJSON(client *http.Client, req *http.Request, v interface{}) (err error) {
+	var rep *http.Response
+	if rep, err = client.Do(req); err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode < 200 || rep.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(rep.Body)
+		return fmt.Errorf("%s: %s", rep.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(rep.Body).Decode(v)
+}
@@ -0,0 +1,39 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestGCPAccessToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing metadata flavor", http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "metadata", "expires_in": 3599, "token_type": "Bearer"})
+	}))
+	defer srv.Close()
+
+	defer func(url string) { gcpMetadataTokenURL = url }(gcpMetadataTokenURL)
+	gcpMetadataTokenURL = srv.URL
+
+	token, err := GCPAccessToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "metadata" {
+		t.Errorf("expected the token of the metadata server, got %q", token)
+	}
+
+	// The token in the environment takes precedence over the metadata server
+	os.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "environment")
+	defer os.Unsetenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	if token, err = GCPAccessToken(context.Background()); err != nil || token != "environment" {
+		t.Errorf("expected the token of the environment, got %q (%v)", token, err)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Package secrets loads sensitive material such as TRISA certificates and passwords from
+local files or from remote secret stores, identified by the URI scheme of the location,
+e.g. gcpsecret://projects/example/secrets/trisa-certs/versions/latest. Locations that
+do not have a scheme are treated as paths to local files.
+*/
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeout is the default timeout for loading secrets from remote secret stores.
+const Timeout = 30 * time.Second
+
+// Loader fetches the secret data at the location specified by the URI.
+type Loader interface {
+	Load(ctx context.Context, uri *url.URL) ([]byte, error)
+}
+
+// LoaderFunc adapts an ordinary function to the Loader interface.
+type LoaderFunc func(ctx context.Context, uri *url.URL) ([]byte, error)
+
+// Load implements the Loader interface.
+func (f LoaderFunc) Load(ctx context.Context, uri *url.URL) ([]byte, error) {
+	return f(ctx, uri)
+}
+
+var (
+	mu      sync.RWMutex
+	loaders = map[string]Loader{
+		"file": LoaderFunc(loadFile),
+	}
+)
+
+// Register a loader for the specified URI scheme, replacing any existing loader.
+func Register(scheme string, loader Loader) {
+	mu.Lock()
+	loaders[strings.ToLower(scheme)] = loader
+	mu.Unlock()
+}
+
+// IsURI returns true if the location has a scheme and should be loaded by a Loader
+// rather than being treated as a path to a local file.
+func IsURI(location string) bool {
+	return strings.Contains(location, "://")
+}
+
+// IsRemote returns true if the location must be fetched from a remote secret store.
+func IsRemote(location string) bool {
+	return IsURI(location) && !strings.HasPrefix(strings.ToLower(location), "file://")
+}
+
+// Load the secret data from the specified location.
+func Load(ctx context.Context, location string) (_ []byte, err error) {
+	if !IsURI(location) {
+		return ioutil.ReadFile(location)
+	}
+
+	var uri *url.URL
+	if uri, err = url.Parse(location); err != nil {
+		return nil, fmt.Errorf("could not parse secret location: %s", err)
+	}
+
+	mu.RLock()
+	loader, ok := loaders[strings.ToLower(uri.Scheme)]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("no secret loader registered for %q", uri.Scheme)
+	}
+	return loader.Load(ctx, uri)
+}
+
+// LoadString is a helper that loads a secret such as a password as a string. If the
+// value is not a URI then it is returned directly as the secret.
+func LoadString(ctx context.Context, value string) (_ string, err error) {
+	if !IsURI(value) {
+		return value, nil
+	}
+
+	var data []byte
+	if data, err = Load(ctx, value); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func loadFile(ctx context.Context, uri *url.URL) ([]byte, error) {
+	path := uri.Path
+	if uri.Host != "" {
+		path = uri.Host + path
+	}
+	return ioutil.ReadFile(path)
+}
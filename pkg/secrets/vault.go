@@ -0,0 +1,404 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The Vault client is configured with the same environment variables as the Vault CLI.
+// Requests are authenticated with $VAULT_TOKEN or, if $VAULT_ROLE_ID and
+// $VAULT_SECRET_ID are set, with a token from an AppRole login. Renewable tokens are
+// renewed in the background for as long as the process is running.
+const (
+	vaultDefaultAddr   = "https://127.0.0.1:8200"
+	vaultRenewalWindow = 3 // renew when a third of the token TTL remains
+	vaultMinRenewal    = 10 * time.Second
+)
+
+var vault = &vaultClient{}
+
+func init() {
+	Register("vault", LoaderFunc(vault.loadKV))
+	Register("vaultpki", LoaderFunc(vault.loadPKI))
+}
+
+type vaultClient struct {
+	sync.Mutex
+	addr      string
+	namespace string
+	client    *http.Client
+	token     string
+	ttl       time.Duration
+	renewable bool
+	renewing  bool
+	configerr error
+	configure sync.Once
+}
+
+// loadKV reads a field from a secret in a KV secrets engine. The URI host is the mount
+// of the secrets engine and the path is the path to the secret, for example
+// vault://secret/trisa/certs?field=pem. The field is optional if the secret only has a
+// single field. KV version 2 is assumed unless kv=1 is specified, and a specific
+// version of the secret can be read with version=n. If encoding=base64 is specified
+// then the field is decoded, e.g. for storing PKCS12 archives in Vault.
+func (v *vaultClient) loadKV(ctx context.Context, uri *url.URL) (_ []byte, err error) {
+	mount, path := uri.Host, strings.Trim(uri.Path, "/")
+	if mount == "" || path == "" {
+		return nil, fmt.Errorf("could not parse vault secret from %q", uri.String())
+	}
+
+	query := uri.Query()
+	kv2 := query.Get("kv") != "1"
+
+	endpoint := mount + "/" + path
+	if kv2 {
+		endpoint = mount + "/data/" + path
+		if version := query.Get("version"); version != "" {
+			endpoint += "?version=" + url.QueryEscape(version)
+		}
+	}
+
+	var rep struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err = v.do(ctx, http.MethodGet, endpoint, nil, &rep); err != nil {
+		return nil, fmt.Errorf("could not read vault secret %s/%s: %s", mount, path, err)
+	}
+
+	data := rep.Data
+	if kv2 {
+		data, _ = rep.Data["data"].(map[string]interface{})
+	}
+
+	var value string
+	if value, err = secretField(data, query.Get("field")); err != nil {
+		return nil, fmt.Errorf("vault secret %s/%s: %s", mount, path, err)
+	}
+
+	if query.Get("encoding") == "base64" {
+		return base64.StdEncoding.DecodeString(value)
+	}
+	return []byte(value), nil
+}
+
+// loadPKI fetches certificates from a PKI secrets engine. The URI host is the mount of
+// the secrets engine. If the path is issue/<role>, a new certificate and private key
+// are issued and returned as a PEM bundle of the certificate, the CA chain, and the
+// private key; the remaining query parameters are passed to Vault as the request,
+// e.g. vaultpki://pki/issue/trisa?common_name=trisa.example.com&ttl=720h. Any other
+// path reads a certificate, e.g. vaultpki://pki/cert/ca_chain for the trust pool.
+func (v *vaultClient) loadPKI(ctx context.Context, uri *url.URL) (_ []byte, err error) {
+	mount, path := uri.Host, strings.Trim(uri.Path, "/")
+	if mount == "" || path == "" {
+		return nil, fmt.Errorf("could not parse vault pki path from %q", uri.String())
+	}
+
+	var rep struct {
+		Data struct {
+			Certificate string   `json:"certificate"`
+			IssuingCA   string   `json:"issuing_ca"`
+			CAChain     []string `json:"ca_chain"`
+			PrivateKey  string   `json:"private_key"`
+		} `json:"data"`
+	}
+
+	if strings.HasPrefix(path, "issue/") {
+		req := make(map[string]string)
+		for key := range uri.Query() {
+			req[key] = uri.Query().Get(key)
+		}
+
+		if err = v.do(ctx, http.MethodPost, mount+"/"+path, req, &rep); err != nil {
+			return nil, fmt.Errorf("could not issue vault certificate %s/%s: %s", mount, path, err)
+		}
+	} else {
+		if err = v.do(ctx, http.MethodGet, mount+"/"+path, nil, &rep); err != nil {
+			return nil, fmt.Errorf("could not read vault certificate %s/%s: %s", mount, path, err)
+		}
+	}
+
+	if rep.Data.Certificate == "" {
+		return nil, fmt.Errorf("no certificate returned from vault %s/%s", mount, path)
+	}
+
+	// Vault returns the issuing CA separately if there is no chain
+	chain := rep.Data.CAChain
+	if len(chain) == 0 && rep.Data.IssuingCA != "" {
+		chain = []string{rep.Data.IssuingCA}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(strings.TrimSpace(rep.Data.Certificate) + "\n")
+	for _, block := range chain {
+		if block != rep.Data.Certificate {
+			buf.WriteString(strings.TrimSpace(block) + "\n")
+		}
+	}
+	if rep.Data.PrivateKey != "" {
+		buf.WriteString(strings.TrimSpace(rep.Data.PrivateKey) + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// secretField returns the string value of the field from the secret data. If no field is
+// specified, the secret must contain exactly one field.
+func secretField(data map[string]interface{}, field string) (string, error) {
+	if len(data) == 0 {
+		return "", errors.New("secret has no data")
+	}
+
+	if field == "" {
+		if len(data) != 1 {
+			keys := make([]string, 0, len(data))
+			for key := range data {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			return "", fmt.Errorf("field required to select one of %s", strings.Join(keys, ", "))
+		}
+
+		for key := range data {
+			field = key
+		}
+	}
+
+	switch val := data[field].(type) {
+	case string:
+		return val, nil
+	case nil:
+		return "", fmt.Errorf("no field %q in secret", field)
+	default:
+		return "", fmt.Errorf("field %q is a %T not a string", field, val)
+	}
+}
+
+// do executes an authenticated request against the Vault HTTP API and decodes the
+// JSON response into v.
+func (v *vaultClient) do(ctx context.Context, method, path string, body, rep interface{}) (err error) {
+	v.configure.Do(func() { v.configerr = v.setup() })
+	if v.configerr != nil {
+		return v.configerr
+	}
+
+	var token string
+	if token, err = v.authenticate(ctx); err != nil {
+		return fmt.Errorf("could not authenticate with vault: %s", err)
+	}
+
+	var req *http.Request
+	if req, err = v.request(ctx, method, path, body); err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	return doJSON(v.client, req, rep)
+}
+
+func (v *vaultClient) request(ctx context.Context, method, path string, body interface{}) (_ *http.Request, err error) {
+	var data io.Reader
+	if body != nil {
+		var buf []byte
+		if buf, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+		data = bytes.NewReader(buf)
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, method, v.addr+"/v1/"+strings.TrimPrefix(path, "/"), data); err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	return req, nil
+}
+
+// setup configures the Vault address and the HTTP client from the environment.
+func (v *vaultClient) setup() (err error) {
+	if v.addr = strings.TrimRight(os.Getenv("VAULT_ADDR"), "/"); v.addr == "" {
+		v.addr = vaultDefaultAddr
+	}
+	v.namespace = os.Getenv("VAULT_NAMESPACE")
+
+	conf := &tls.Config{InsecureSkipVerify: os.Getenv("VAULT_SKIP_VERIFY") == "true"}
+	if path := os.Getenv("VAULT_CACERT"); path != "" {
+		var pem []byte
+		if pem, err = ioutil.ReadFile(path); err != nil {
+			return fmt.Errorf("could not read vault ca certificate: %s", err)
+		}
+
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", path)
+		}
+	}
+
+	v.client = &http.Client{
+		Timeout:   Timeout,
+		Transport: &http.Transport{TLSClientConfig: conf, Proxy: http.ProxyFromEnvironment},
+	}
+	return nil
+}
+
+// authenticate returns the current token, logging in with AppRole credentials if no
+// token is available, and starts renewing the token in the background if possible.
+func (v *vaultClient) authenticate(ctx context.Context) (_ string, err error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if v.token != "" {
+		return v.token, nil
+	}
+
+	if v.token = os.Getenv("VAULT_TOKEN"); v.token != "" {
+		// Lookup the token to determine if and when it needs to be renewed
+		if err = v.lookup(ctx); err != nil {
+			log.Warn().Err(err).Msg("could not lookup vault token, it will not be renewed")
+		}
+	} else if err = v.login(ctx); err != nil {
+		return "", err
+	}
+
+	if v.renewable && v.ttl > 0 && !v.renewing {
+		v.renewing = true
+		go v.renew()
+	}
+	return v.token, nil
+}
+
+// login with AppRole credentials; must be called with the lock held.
+func (v *vaultClient) login(ctx context.Context) (err error) {
+	roleID, secretID := os.Getenv("VAULT_ROLE_ID"), os.Getenv("VAULT_SECRET_ID")
+	if roleID == "" || secretID == "" {
+		return errors.New("$VAULT_TOKEN or $VAULT_ROLE_ID and $VAULT_SECRET_ID are required")
+	}
+
+	mount := os.Getenv("VAULT_APPROLE_MOUNT")
+	if mount == "" {
+		mount = "approle"
+	}
+
+	var req *http.Request
+	if req, err = v.request(ctx, http.MethodPost, "auth/"+mount+"/login", map[string]string{"role_id": roleID, "secret_id": secretID}); err != nil {
+		return err
+	}
+
+	var rep vaultAuth
+	if err = doJSON(v.client, req, &rep); err != nil {
+		return err
+	}
+
+	v.token = rep.Auth.ClientToken
+	v.ttl = time.Duration(rep.Auth.LeaseDuration) * time.Second
+	v.renewable = rep.Auth.Renewable
+	return nil
+}
+
+// lookup the TTL of the current token; must be called with the lock held.
+func (v *vaultClient) lookup(ctx context.Context) (err error) {
+	var req *http.Request
+	if req, err = v.request(ctx, http.MethodGet, "auth/token/lookup-self", nil); err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+
+	var rep struct {
+		Data struct {
+			TTL       int64 `json:"ttl"`
+			Renewable bool  `json:"renewable"`
+		} `json:"data"`
+	}
+	if err = doJSON(v.client, req, &rep); err != nil {
+		return err
+	}
+
+	v.ttl = time.Duration(rep.Data.TTL) * time.Second
+	v.renewable = rep.Data.Renewable
+	return nil
+}
+
+// renew the token in the background before it expires. If the token can no longer be
+// renewed (e.g. it has reached its max TTL), a new token is obtained by logging in
+// again with AppRole credentials if they are available.
+func (v *vaultClient) renew() {
+	for {
+		v.Lock()
+		wait := v.ttl - v.ttl/vaultRenewalWindow
+		v.Unlock()
+
+		if wait < vaultMinRenewal {
+			wait = vaultMinRenewal
+		}
+		time.Sleep(wait)
+
+		ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+		err := v.renewSelf(ctx)
+		cancel()
+
+		if err != nil {
+			log.Warn().Err(err).Msg("could not renew vault token")
+			if os.Getenv("VAULT_ROLE_ID") == "" {
+				v.Lock()
+				v.renewing = false
+				v.Unlock()
+				return
+			}
+		}
+	}
+}
+
+func (v *vaultClient) renewSelf(ctx context.Context) (err error) {
+	v.Lock()
+	defer v.Unlock()
+
+	var req *http.Request
+	if req, err = v.request(ctx, http.MethodPost, "auth/token/renew-self", map[string]string{}); err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+
+	var rep vaultAuth
+	if err = doJSON(v.client, req, &rep); err == nil && rep.Auth.LeaseDuration > 0 {
+		v.ttl = time.Duration(rep.Auth.LeaseDuration) * time.Second
+		v.renewable = rep.Auth.Renewable
+		log.Debug().Dur("ttl", v.ttl).Msg("vault token renewed")
+		return nil
+	}
+
+	if os.Getenv("VAULT_ROLE_ID") == "" {
+		if err == nil {
+			err = errors.New("token was not renewed")
+		}
+		return err
+	}
+	return v.login(ctx)
+}
+
+type vaultAuth struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int64  `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+}
@@ -0,0 +1,38 @@
+package trisarl
+
+import (
+	"context"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/peer"
+)
+
+// securityEvent logs a message from the peer of the transfer that failed a security
+// check, counts it, and appends it to the security event audit log in the store.
+func (s *Server) securityEvent(ctx context.Context, event string, t *Transfer, err error) {
+	record := &store.SecurityEvent{Time: time.Now(), Event: event, Peer: t.Peer.String()}
+	if t.In != nil {
+		record.EnvelopeID = t.In.Id
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		record.RemoteAddr = p.Addr.String()
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	log.Ctx(ctx).Error().
+		Err(err).
+		Str("security_event", event).
+		Str("peer", record.Peer).
+		Str("remote_addr", record.RemoteAddr).
+		Str("id", record.EnvelopeID).
+		Msg("security event")
+
+	s.metrics.securityEvents.WithLabelValues(record.Peer, event).Inc()
+	if err = s.db.PutSecurityEvent(record); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("security_event", event).Msg("could not persist security event")
+	}
+}
@@ -0,0 +1,192 @@
+package trisarl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/directory"
+	"github.com/rotationalio/trisa/pkg/pending"
+	"github.com/rotationalio/trisa/pkg/proposal"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Counterparty is the beneficiary VASP of an outgoing transfer, identified by the common
+// name of its TRISA certificate. Email is the compliance contact of the VASP, which the
+// transfer is sent to by the sunrise fallback if the VASP is not in the TRISA directory.
+type Counterparty struct {
+	CommonName string
+	Email      string
+}
+
+// Send initiates a transfer of the payload to the counterparty in a secure envelope with
+// the envelope ID, or a new envelope ID if it is empty, and returns the payload of the
+// response, which is a pending message if the counterparty defers its reply. If the
+// counterparty is not in the TRISA directory and the sunrise fallback is enabled, the
+// payload is sent to the email of the counterparty with a secure link instead and a
+// pending message that expires with the link is returned. The state of the exchange is
+// tracked by the envelope ID like the state of incoming transfers. If retries are
+// enabled and the counterparty rejects the transfer or the key exchange with a
+// retryable error, the error is returned and the transfer is retried in the background.
+// If the counterparty rejects the transfer with a counter-proposal that the proposal
+// provider can satisfy, the transfer is sent again once with the completed identity.
+func (s *Server) Send(ctx context.Context, counterparty Counterparty, envelopeID string, payload *protocol.Payload) (reply *protocol.Payload, err error) {
+	if payload == nil || payload.Identity == nil || payload.Transaction == nil {
+		return nil, errors.New("an identity and a transaction are required to send a transfer")
+	}
+	env := handler.New(envelopeID, payload, nil)
+
+	if reply, err = s.send(ctx, counterparty, env, false); err != nil {
+		if reply, err = s.satisfy(ctx, counterparty, env, err); err != nil {
+			s.scheduleRetry(ctx, counterparty, env.ID, env.Payload, err)
+			return nil, err
+		}
+	}
+
+	if s.config().Retries.Enabled {
+		s.deleteRetry(env.ID)
+	}
+	return reply, nil
+}
+
+// send seals the envelope for the counterparty and sends it, exchanging keys first if
+// the signing key of the counterparty is not cached, expires soon, or if refresh is
+// true. Generic transactions are stamped with the time they are sent.
+func (s *Server) send(ctx context.Context, counterparty Counterparty, env *handler.Envelope, refresh bool) (reply *protocol.Payload, err error) {
+	// Every attempt is stamped with the time it is sent so that retries are not stale
+	if env.Payload, err = stampTransaction(env.Payload, fieldSentAt, time.Now(), true); err != nil {
+		return nil, err
+	}
+	payload := env.Payload
+
+	var peer *peers.Peer
+	if peer, err = s.lookup(ctx, counterparty.CommonName); err != nil {
+		if errors.Is(err, directory.ErrNotFound) && s.config().Sunrise.Enabled {
+			return s.sunrise(ctx, counterparty, env)
+		}
+		return nil, err
+	}
+
+	var sealKey interface{}
+	if sealKey, err = s.exchangeKeys(ctx, peer, refresh); err != nil {
+		return nil, fmt.Errorf("could not exchange keys with %s: %w", counterparty.CommonName, err)
+	}
+
+	var in, out *protocol.SecureEnvelope
+	if in, err = s.sealEnvelope(peer.String(), env, sealKey); err != nil {
+		return nil, err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tx := s.sentTransaction(ctx, env.ID, peer.String())
+	if out, err = peer.Transfer(in); err != nil {
+		s.recordEnvelope(ctx, peer.String(), store.Outgoing, in, payload, err)
+		return nil, err
+	}
+	s.recordEnvelope(ctx, peer.String(), store.Outgoing, in, payload, nil)
+
+	if out.Error != nil && out.Error.Code != 0 {
+		s.recordEnvelope(ctx, peer.String(), store.Incoming, out, nil, nil)
+		if refreshKeys(out.Error.Code.String()) {
+			s.forgetPeerKey(peer)
+		}
+		if tx != nil && !out.Error.Retry {
+			s.transition(ctx, tx, store.Rejected, out.Error.Error())
+		}
+		return nil, out.Error
+	}
+
+	var opened *handler.Envelope
+	_, key := s.signing()
+	if opened, err = s.openEnvelope(out, peer.String(), key); err != nil {
+		s.recordEnvelope(ctx, peer.String(), store.Incoming, out, nil, err)
+		return nil, err
+	}
+	s.recordEnvelope(ctx, peer.String(), store.Incoming, out, opened.Payload, nil)
+
+	if tx != nil {
+		transaction := &generic.Transaction{}
+		if err = payload.Transaction.UnmarshalTo(transaction); err == nil {
+			s.recordSettlement(ctx, tx.EnvelopeID, transaction)
+		}
+
+		if msg, ok := pending.FromPayload(opened.Payload); ok {
+			s.transition(ctx, tx, store.AwaitingCounterparty, msg.Message)
+		} else {
+			s.transition(ctx, tx, store.Approved, "")
+			s.transition(ctx, tx, store.Completed, "response received")
+		}
+	}
+	return opened.Payload, nil
+}
+
+// satisfy sends the envelope again if the counterparty rejected it with a counter-proposal
+// that the proposal provider can satisfy; otherwise the rejection is returned unchanged.
+// The identity of the envelope is replaced by the completed identity, so that retries of
+// the transfer send the completed identity as well.
+func (s *Server) satisfy(ctx context.Context, counterparty Counterparty, env *handler.Envelope, rejection error) (_ *protocol.Payload, err error) {
+	perr, ok := rejection.(*protocol.Error)
+	if !ok || s.proposals == nil {
+		return nil, rejection
+	}
+
+	var cp *proposal.CounterProposal
+	if cp, ok = proposal.FromError(perr); !ok {
+		return nil, rejection
+	}
+
+	identity := &ivms101.IdentityPayload{}
+	if err = env.Payload.Identity.UnmarshalTo(identity); err != nil {
+		return nil, rejection
+	}
+
+	var unsatisfied []proposal.Requirement
+	if unsatisfied, err = cp.Satisfy(ctx, identity, s.proposals); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Int("unsatisfied", len(unsatisfied)).Str("peer", counterparty.CommonName).Str("id", env.ID).Msg("could not satisfy counter-proposal")
+		return nil, rejection
+	}
+
+	payload := proto.Clone(env.Payload).(*protocol.Payload)
+	if payload.Identity, err = anypb.New(identity); err != nil {
+		return nil, rejection
+	}
+	env.Payload = payload
+
+	log.Ctx(ctx).Info().Int("requirements", len(cp.Requirements)).Str("peer", counterparty.CommonName).Str("id", env.ID).Msg("sending transfer again to satisfy counter-proposal")
+	return s.send(ctx, counterparty, env, false)
+}
+
+// sentTransaction returns the transaction of an outgoing transfer, creating it if this
+// is the first message of the exchange. State tracking is best effort, so nil is
+// returned if the state is unavailable.
+func (s *Server) sentTransaction(ctx context.Context, envelopeID, peer string) *store.Transaction {
+	tx, err := s.db.ReceiveTransaction(envelopeID, peer)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("id", envelopeID).Msg("could not track transaction state")
+		return nil
+	}
+	return tx
+}
+
+// validEmail returns the address of the email of the counterparty, which is used in
+// email headers and therefore must be a single valid address.
+func validEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return "", fmt.Errorf("invalid email address %q: %s", email, err)
+	}
+	return addr.Address, nil
+}
@@ -0,0 +1,74 @@
+package trisarl
+
+import (
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+)
+
+// received assigns a sequence number to an incoming message from the peer, logging
+// duplicates. Sequence tracking is best effort and never causes a request to fail.
+func (s *Server) received(peer *peers.Peer, envelopeID string) (seq uint64) {
+	var (
+		err  error
+		orig uint64
+	)
+
+	if seq, orig, err = s.db.Receive(peer.String(), envelopeID); err != nil {
+		log.Error().Err(err).Str("peer", peer.String()).Msg("could not assign sequence number to incoming message")
+		return 0
+	}
+
+	if orig > 0 {
+		log.Warn().
+			Str("peer", peer.String()).
+			Str("id", envelopeID).
+			Uint64("seq", seq).
+			Uint64("original_seq", orig).
+			Msg("duplicate envelope received")
+	}
+	return seq
+}
+
+// sent assigns a sequence number to an outgoing message (or error) to the peer.
+func (s *Server) sent(peer *peers.Peer) (seq uint64) {
+	var err error
+	if seq, err = s.db.Sent(peer.String()); err != nil {
+		log.Error().Err(err).Str("peer", peer.String()).Msg("could not assign sequence number to outgoing message")
+		return 0
+	}
+	return seq
+}
+
+// streamOpened logs any messages left unanswered by a previous stream with the peer.
+func (s *Server) streamOpened(peer *peers.Peer) {
+	gap, err := s.db.StreamOpened(peer.String())
+	s.logGap(peer, gap, err)
+}
+
+// streamClosed logs any messages that were not answered before the stream closed.
+func (s *Server) streamClosed(peer *peers.Peer) {
+	gap, err := s.db.StreamClosed(peer.String())
+	s.logGap(peer, gap, err)
+}
+
+func (s *Server) logGap(peer *peers.Peer, gap *store.Gap, err error) {
+	if err != nil {
+		log.Error().Err(err).Str("peer", peer.String()).Msg("could not check stream sequence")
+		return
+	}
+
+	if gap != nil {
+		log.Warn().
+			Str("peer", peer.String()).
+			Uint64("from", gap.From).
+			Uint64("to", gap.To).
+			Str("reason", gap.Reason).
+			Msg("sequence gap detected")
+	}
+}
+
+// SequenceReport returns the sequence state of every counterparty for reconciliation.
+func (s *Server) SequenceReport() ([]*store.Sequence, error) {
+	return s.db.Sequences()
+}
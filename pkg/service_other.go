@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package trisarl
+
+// isService returns false since services are only supported on Windows; on other
+// platforms the server is managed by signals.
+func isService() bool {
+	return false
+}
+
+// runService is never called on platforms other than Windows.
+func (s *Server) runService() error {
+	return nil
+}
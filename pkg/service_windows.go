@@ -0,0 +1,91 @@
+//go:build windows
+// +build windows
+
+package trisarl
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/windows/svc"
+)
+
+// ServiceName is the name the server runs under as a Windows service; the service
+// control manager ignores it for services that run in their own process.
+const ServiceName = "trisarl"
+
+// isService returns true if the process was started by the Windows service control
+// manager rather than from a console.
+func isService() bool {
+	ok, err := svc.IsWindowsService()
+	if err != nil {
+		log.Warn().Err(err).Msg("could not determine if running as a windows service")
+		return false
+	}
+	return ok
+}
+
+// runService runs the server under the Windows service control manager until the
+// service is stopped or the system shuts down.
+func (s *Server) runService() error {
+	handler := &service{s: s}
+	if err := svc.Run(ServiceName, handler); err != nil {
+		return err
+	}
+	return handler.err
+}
+
+// service implements svc.Handler to serve until the service control manager stops the
+// service; the configuration is reloaded when the service parameters change, which is
+// the service equivalent of SIGHUP.
+type service struct {
+	s   *Server
+	err error
+}
+
+// Execute implements svc.Handler
+func (h *service) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
+	changes <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() { errc <- h.s.Run(ctx) }()
+	changes <- svc.Status{State: svc.Running, Accepts: accepts}
+
+	for {
+		select {
+		case h.err = <-errc:
+			return h.exit()
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.ParamChange:
+				if err := h.s.Reload(); err != nil {
+					log.Error().Err(err).Msg("could not reload configuration")
+				}
+				changes <- svc.Status{State: svc.Running, Accepts: accepts}
+			case svc.Stop, svc.Shutdown:
+				// Give the server time to drain before the service manager kills it
+				wait := h.s.config().DrainTimeout + 5*time.Second
+				changes <- svc.Status{State: svc.StopPending, WaitHint: uint32(wait / time.Millisecond)}
+				cancel()
+				h.err = <-errc
+				return h.exit()
+			}
+		}
+	}
+}
+
+// exit returns a service specific exit code if the server stopped with an error.
+func (h *service) exit() (bool, uint32) {
+	if h.err != nil {
+		log.Error().Err(h.err).Msg("windows service stopped with an error")
+		return true, 1
+	}
+	return false, 0
+}
@@ -0,0 +1,170 @@
+package trisarl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/chain"
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/secrets"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+)
+
+// ErrNoChainProvider is returned if settlements are verified without a chain-data
+// provider.
+var ErrNoChainProvider = errors.New("settlements are not verified, no chain-data provider is configured")
+
+// VerifySettlement looks up the settlement of the transaction with the envelope ID on
+// chain immediately rather than waiting for the next scheduled verification, and returns
+// the transaction annotated with the result. If the chain-data provider could not look up
+// the transaction the error is returned along with the transaction.
+func (s *Server) VerifySettlement(ctx context.Context, envelopeID string) (tx *store.Transaction, err error) {
+	verifier, conf := s.verifier()
+	if verifier == nil {
+		return nil, ErrNoChainProvider
+	}
+
+	if tx, err = s.db.GetTransaction(envelopeID); err != nil {
+		return nil, err
+	}
+
+	if tx.Settlement == nil {
+		return nil, fmt.Errorf("transaction %s does not have a settlement to verify", envelopeID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, conf.Timeout)
+	defer cancel()
+
+	verr := verifier.Verify(ctx, tx.Settlement)
+	if tx, err = s.db.SetSettlement(envelopeID, tx.Settlement); err != nil {
+		return nil, err
+	}
+	return tx, verr
+}
+
+// recordSettlement annotates the transaction of the exchange with the settlement of the
+// generic transaction so that it is verified on chain. Transactions without a network
+// and transaction ID cannot be verified, and settlements that were already recorded are
+// only replaced if the transaction ID changed, e.g. in the follow-up to a pending
+// message. Verification never affects the Travel Rule exchange, since the transaction
+// is usually broadcast only after the exchange.
+func (s *Server) recordSettlement(ctx context.Context, envelopeID string, transaction *generic.Transaction) {
+	if verifier, _ := s.verifier(); verifier == nil || transaction.GetTxid() == "" || transaction.GetNetwork() == "" {
+		return
+	}
+
+	tx, err := s.db.GetTransaction(envelopeID)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("id", envelopeID).Msg("could not record settlement")
+		return
+	}
+
+	if tx.Settlement != nil && tx.Settlement.Network == transaction.Network && tx.Settlement.TxID == transaction.Txid {
+		return
+	}
+
+	settlement := &store.Settlement{
+		Network:  transaction.Network,
+		TxID:     transaction.Txid,
+		Amount:   transaction.Amount,
+		Status:   store.SettlementUnverified,
+		Recorded: time.Now(),
+	}
+	if _, err = s.db.SetSettlement(envelopeID, settlement); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("id", envelopeID).Msg("could not record settlement")
+		return
+	}
+	log.Ctx(ctx).Debug().Str("id", envelopeID).Str("network", settlement.Network).Msg("settlement recorded for verification")
+}
+
+// verifySettlements periodically verifies the settlements that are not final and that
+// were recorded within the verification window until the server starts shutting down,
+// acknowledging completed transactions once their settlement is confirmed if enabled.
+func (s *Server) verifySettlements() {
+	interval := s.config().Chain.Interval
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				verifier, conf := s.verifier()
+				if conf.Interval > 0 && conf.Interval != interval {
+					interval = conf.Interval
+					ticker.Reset(interval)
+				}
+				if verifier == nil {
+					continue
+				}
+
+				txns, err := s.db.UnsettledTransactions(time.Now().Add(-conf.Window))
+				if err != nil {
+					log.Error().Err(err).Msg("could not list unsettled transactions")
+					continue
+				}
+
+				for _, tx := range txns {
+					id, status := tx.EnvelopeID, tx.Settlement.Status
+					if tx, err = s.VerifySettlement(context.Background(), id); err != nil {
+						log.Warn().Err(err).Str("id", id).Msg("could not verify settlement")
+						continue
+					}
+					if tx.Settlement.Status != status {
+						log.Info().Str("id", id).Str("status", string(tx.Settlement.Status)).Int("confirmations", tx.Settlement.Confirmations).Msg("settlement status updated")
+					}
+
+					if conf.Acknowledge && tx.State == store.Completed && tx.Settlement.Status == store.SettlementConfirmed {
+						if err = s.Acknowledge(context.Background(), id, "settlement confirmed"); err != nil {
+							log.Warn().Err(err).Str("id", id).Msg("could not send final acknowledgment")
+						}
+					}
+				}
+			case <-s.draining:
+				return
+			}
+		}
+	}()
+}
+
+// verifier returns the verifier of settlements with the chain-data provider set by the
+// embedding application or otherwise the provider of the current configuration, along
+// with the chain configuration. The verifier is nil if settlements are not verified.
+func (s *Server) verifier() (*chain.Verifier, config.ChainConfig) {
+	s.confmu.RLock()
+	defer s.confmu.RUnlock()
+
+	provider := s.chainOpt
+	if provider == nil {
+		provider = s.chainProvider
+	}
+	if provider == nil {
+		return nil, s.conf.Chain
+	}
+	return &chain.Verifier{Provider: provider, Confirmations: s.conf.Chain.Confirmations, Tolerance: s.conf.Chain.Tolerance}, s.conf.Chain
+}
+
+// newChainProvider creates the chain-data provider of the configuration, or returns nil
+// if settlements are not verified.
+func newChainProvider(conf config.ChainConfig) (_ chain.Provider, err error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+	defer cancel()
+
+	provider := &chain.HTTPProvider{URL: conf.URL, Client: &http.Client{Timeout: conf.Timeout}}
+	if provider.Token, err = secrets.LoadString(ctx, conf.Token); err != nil {
+		return nil, fmt.Errorf("could not load chain-data provider token: %s", err)
+	}
+	return provider, nil
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package trisarl
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyShutdown relays the signals that shut the server down gracefully: SIGINT from
+// the terminal, SIGTERM from process managers such as Kubernetes or systemd, and
+// SIGQUIT.
+func notifyShutdown(c chan<- os.Signal) {
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+}
+
+// notifyMaintenance relays the signals that toggle maintenance mode.
+func notifyMaintenance(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package trisarl
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyShutdown relays Ctrl-C and the console close, logoff, and shutdown events,
+// which are delivered as SIGTERM on Windows. Windows services are stopped by the
+// service control manager instead.
+func notifyShutdown(c chan<- os.Signal) {
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+}
+
+// notifyMaintenance does nothing on Windows, which does not have SIGUSR1; maintenance
+// mode can be toggled with the maintenance file instead.
+func notifyMaintenance(c chan<- os.Signal) {}
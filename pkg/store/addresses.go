@@ -0,0 +1,89 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const nsAddresses = "addresses"
+
+// Address is a wallet address on a specific network that is controlled by one of the
+// node's customers and can therefore receive transfers, identified by the account.
+type Address struct {
+	Network  string    `json:"network"`
+	Address  string    `json:"address"`
+	Account  string    `json:"account,omitempty"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+}
+
+// AddressKey returns the key of the address in the registry. Networks are case
+// insensitive but addresses are not since many address encodings are case sensitive.
+func AddressKey(network, address string) string {
+	return strings.ToLower(strings.TrimSpace(network)) + ":" + strings.TrimSpace(address)
+}
+
+// GetAddress returns the registered wallet address on the network.
+func (s *Store) GetAddress(network, address string) (addr *Address, err error) {
+	var val []byte
+	if val, err = s.get(nsAddresses, AddressKey(network, address)); err != nil {
+		return nil, err
+	}
+
+	addr = &Address{}
+	if err = json.Unmarshal(val, addr); err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
+
+// PutAddress creates or updates the wallet address in the registry.
+func (s *Store) PutAddress(addr *Address) (err error) {
+	addr.Network = strings.ToLower(strings.TrimSpace(addr.Network))
+	addr.Address = strings.TrimSpace(addr.Address)
+	if addr.Network == "" || addr.Address == "" {
+		return errors.New("network and address are required for all wallet addresses")
+	}
+
+	var prev *Address
+	if prev, err = s.GetAddress(addr.Network, addr.Address); err == nil {
+		addr.Created = prev.Created
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	addr.Modified = time.Now()
+	if addr.Created.IsZero() {
+		addr.Created = addr.Modified
+	}
+
+	var val []byte
+	if val, err = json.Marshal(addr); err != nil {
+		return err
+	}
+	return s.put(nsAddresses, AddressKey(addr.Network, addr.Address), val)
+}
+
+// DeleteAddress removes the wallet address from the registry.
+func (s *Store) DeleteAddress(network, address string) (err error) {
+	if _, err = s.GetAddress(network, address); err != nil {
+		return err
+	}
+	return s.delete(nsAddresses, AddressKey(network, address))
+}
+
+// Addresses returns all of the wallet addresses in the registry.
+func (s *Store) Addresses() (addrs []*Address, err error) {
+	addrs = make([]*Address, 0)
+	err = s.iter(nsAddresses, func(_ string, val []byte) error {
+		addr := &Address{}
+		if err := json.Unmarshal(val, addr); err != nil {
+			return err
+		}
+		addrs = append(addrs, addr)
+		return nil
+	})
+	return addrs, err
+}
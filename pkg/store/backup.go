@@ -0,0 +1,195 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Backup archive format: the magic header, followed by the scrypt salt, the AES-GCM
+// nonce, and the encrypted gzip compressed stream of length-prefixed key/value pairs.
+var backupMagic = []byte("TRISARL-BACKUP-1")
+
+const (
+	saltSize = 16
+	keySize  = 32
+)
+
+// Backup writes an encrypted archive of every record in the store to w. The archive is
+// encrypted with AES-256-GCM using a key derived from the passphrase with scrypt. The
+// backup is taken from a snapshot so the server can continue to write to the store.
+func (s *Store) Backup(w io.Writer, passphrase string) (nrecords uint64, err error) {
+	if passphrase == "" {
+		return 0, errors.New("a passphrase is required to encrypt the backup")
+	}
+
+	var snap *leveldb.Snapshot
+	if snap, err = s.db.GetSnapshot(); err != nil {
+		return 0, err
+	}
+	defer snap.Release()
+
+	// Serialize and compress all records in the snapshot
+	var buf bytes.Buffer
+	archive := gzip.NewWriter(&buf)
+	iter := snap.NewIterator(nil, nil)
+	for iter.Next() {
+		if err = writeRecord(archive, iter.Key()); err != nil {
+			break
+		}
+		if err = writeRecord(archive, iter.Value()); err != nil {
+			break
+		}
+		nrecords++
+	}
+	iter.Release()
+
+	if err != nil {
+		return 0, err
+	}
+	if err = iter.Error(); err != nil {
+		return 0, err
+	}
+	if err = archive.Close(); err != nil {
+		return 0, err
+	}
+
+	// Encrypt the archive with the passphrase
+	salt := make([]byte, saltSize)
+	if _, err = rand.Read(salt); err != nil {
+		return 0, err
+	}
+
+	var aead cipher.AEAD
+	if aead, err = newAEAD(passphrase, salt); err != nil {
+		return 0, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return 0, err
+	}
+
+	for _, chunk := range [][]byte{backupMagic, salt, nonce, aead.Seal(nil, nonce, buf.Bytes(), backupMagic)} {
+		if _, err = w.Write(chunk); err != nil {
+			return 0, err
+		}
+	}
+	return nrecords, nil
+}
+
+// Restore the records from an encrypted backup archive into the store. The store must
+// be empty so that a restore rehydrates a fresh node rather than merging state.
+func (s *Store) Restore(r io.Reader, passphrase string) (nrecords uint64, err error) {
+	if !s.Empty() {
+		return 0, ErrNotEmpty
+	}
+
+	var data []byte
+	if data, err = ioutil.ReadAll(r); err != nil {
+		return 0, err
+	}
+
+	if len(data) < len(backupMagic)+saltSize || !bytes.Equal(data[:len(backupMagic)], backupMagic) {
+		return 0, errors.New("not a trisarl backup archive")
+	}
+	data = data[len(backupMagic):]
+	salt, data := data[:saltSize], data[saltSize:]
+
+	var aead cipher.AEAD
+	if aead, err = newAEAD(passphrase, salt); err != nil {
+		return 0, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return 0, errors.New("backup archive is truncated")
+	}
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	var plaintext []byte
+	if plaintext, err = aead.Open(nil, nonce, data, backupMagic); err != nil {
+		return 0, errors.New("could not decrypt backup: incorrect passphrase or corrupted archive")
+	}
+
+	var archive *gzip.Reader
+	if archive, err = gzip.NewReader(bytes.NewReader(plaintext)); err != nil {
+		return 0, err
+	}
+	defer archive.Close()
+
+	// Write the records in batches to the store
+	reader := bufio.NewReader(archive)
+	batch := new(leveldb.Batch)
+	for {
+		var key, val []byte
+		if key, err = readRecord(reader); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, err
+		}
+		if val, err = readRecord(reader); err != nil {
+			return 0, fmt.Errorf("could not read value for key %q: %s", key, err)
+		}
+
+		batch.Put(key, val)
+		nrecords++
+
+		if batch.Len() >= 1000 {
+			if err = s.db.Write(batch, nil); err != nil {
+				return 0, err
+			}
+			batch.Reset()
+		}
+	}
+
+	if err = s.db.Write(batch, nil); err != nil {
+		return 0, err
+	}
+	return nrecords, nil
+}
+
+func newAEAD(passphrase string, salt []byte) (_ cipher.AEAD, err error) {
+	var key []byte
+	if key, err = scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, keySize); err != nil {
+		return nil, err
+	}
+
+	var block cipher.Block
+	if block, err = aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func writeRecord(w io.Writer, data []byte) (err error) {
+	if err = binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func readRecord(r io.Reader) (data []byte, err error) {
+	var size uint32
+	if err = binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+
+	data = make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,316 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/secrets"
+	"github.com/syndtr/goleveldb/leveldb"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted record format: the magic header, the length of the key ID and the key ID,
+// the AES-GCM nonce, and the encrypted value. The record key is used as additional
+// data so that encrypted values cannot be swapped between records. Record keys are not
+// encrypted since they are used to look up records.
+var encryptedMagic = []byte("\x00TRISARL-ENC1")
+
+// The salt of the key derived from the storage passphrase is stored unencrypted so
+// that the same key is derived every time the store is opened.
+var saltKey = key(nsMeta, "passphrase_salt")
+
+// The key check value is a known plaintext encrypted with the storage key, so that a
+// wrong storage key or passphrase is detected when the store is opened rather than when
+// the first encrypted record is read.
+var (
+	checkKey   = key(nsMeta, "key_check")
+	checkValue = []byte("TRISARL storage key check")
+)
+
+// ErrWrongKey is returned if the storage key or passphrase does not open the store.
+var ErrWrongKey = errors.New("storage encryption key or passphrase does not match the store")
+
+// ErrUnknownKey is returned if a record was encrypted with a key that is not available.
+var ErrUnknownKey = errors.New("record was encrypted with an unknown storage key")
+
+// storageKey is an AES-256-GCM data-at-rest encryption key, identified by its key ID.
+type storageKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// encryption holds the current storage key, which all records are encrypted with, and
+// the previous storage keys, which can only be used to decrypt records.
+type encryption struct {
+	current *storageKey
+	keys    map[string]*storageKey
+}
+
+// KeyID returns the ID of the current storage encryption key, or an empty string if
+// records are not encrypted.
+func (s *Store) KeyID() string {
+	if s.crypto == nil {
+		return ""
+	}
+	return s.crypto.current.id
+}
+
+// setupEncryption loads the storage encryption key and previous keys from the config.
+func (s *Store) setupEncryption(conf config.StorageConfig) (err error) {
+	if conf.EncryptionKey == "" && conf.Passphrase == "" {
+		if conf.PreviousKeys != "" {
+			return errors.New("previous storage keys require a current encryption key or passphrase")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+	defer cancel()
+
+	s.crypto = &encryption{keys: make(map[string]*storageKey)}
+	if conf.EncryptionKey != "" {
+		if s.crypto.current, err = loadStorageKey(ctx, conf.EncryptionKey); err != nil {
+			return fmt.Errorf("could not load storage encryption key: %s", err)
+		}
+	} else {
+		var passphrase string
+		if passphrase, err = secrets.LoadString(ctx, conf.Passphrase); err != nil {
+			return fmt.Errorf("could not load storage passphrase: %s", err)
+		}
+
+		if s.crypto.current, err = s.deriveStorageKey(passphrase); err != nil {
+			return err
+		}
+	}
+
+	s.crypto.keys[s.crypto.current.id] = s.crypto.current
+	if err = s.crypto.loadPrevious(ctx, conf.PreviousKeys); err != nil {
+		return fmt.Errorf("could not load previous storage key: %s", err)
+	}
+	return s.checkStorageKey()
+}
+
+// checkStorageKey ensures the key check value in the store can be decrypted with the
+// current or a previous storage key, and stores it encrypted with the current key if
+// the store does not have one yet or if it was encrypted with a previous key. Stores
+// that were encrypted before key check values were introduced cannot be checked, so
+// the check value is created with the key that the store is first opened with.
+func (s *Store) checkStorageKey() (err error) {
+	var val []byte
+	if val, err = s.db.Get(checkKey, nil); err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return err
+	}
+
+	if err == nil {
+		var id string
+		if id, _, err = parseEncrypted(val); err != nil {
+			return fmt.Errorf("could not parse storage key check: %s", err)
+		}
+
+		if val, err = s.crypto.decrypt(checkKey, val); err != nil || !bytes.Equal(val, checkValue) {
+			return ErrWrongKey
+		}
+
+		if id == s.crypto.current.id {
+			return nil
+		}
+	}
+
+	if val, err = s.crypto.encrypt(checkKey, checkValue); err != nil {
+		return err
+	}
+	return s.db.Put(checkKey, val, nil)
+}
+
+// loadPrevious loads the comma separated locations of previous keys, which can only be
+// used to decrypt values.
+func (e *encryption) loadPrevious(ctx context.Context, locations string) (err error) {
+	for _, location := range strings.Split(locations, ",") {
+		if location = strings.TrimSpace(location); location == "" {
+			continue
+		}
+
+		var prev *storageKey
+		if prev, err = loadStorageKey(ctx, location); err != nil {
+			return err
+		}
+		e.keys[prev.id] = prev
+	}
+	return nil
+}
+
+// loadStorageKey loads a 32 byte key that is either raw or hex or base64 encoded.
+func loadStorageKey(ctx context.Context, location string) (_ *storageKey, err error) {
+	var data []byte
+	if data, err = secrets.Load(ctx, location); err != nil {
+		return nil, err
+	}
+
+	if len(data) != keySize {
+		text := strings.TrimSpace(string(data))
+		if data, err = hex.DecodeString(text); err != nil {
+			if data, err = base64.StdEncoding.DecodeString(text); err != nil {
+				return nil, errors.New("key must be 32 bytes, hex encoded, or base64 encoded")
+			}
+		}
+	}
+
+	if len(data) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, not %d", keySize, len(data))
+	}
+	return newStorageKey(data)
+}
+
+// deriveStorageKey derives the storage key from the passphrase with scrypt, using the
+// salt in the store or creating one if the store does not have a salt yet. Any other
+// error reading the salt is returned, since replacing the salt would make every record
+// encrypted with the derived key unreadable.
+func (s *Store) deriveStorageKey(passphrase string) (_ *storageKey, err error) {
+	var salt []byte
+	if salt, err = s.db.Get(saltKey, nil); err != nil {
+		if !errors.Is(err, leveldb.ErrNotFound) {
+			return nil, fmt.Errorf("could not read storage passphrase salt: %s", err)
+		}
+
+		salt = make([]byte, saltSize)
+		if _, err = rand.Read(salt); err != nil {
+			return nil, err
+		}
+
+		if err = s.db.Put(saltKey, salt, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	var key []byte
+	if key, err = scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, keySize); err != nil {
+		return nil, err
+	}
+	return newStorageKey(key)
+}
+
+// newStorageKey creates the cipher for the key; the key ID is a fingerprint of the key
+// so that it does not change unless the key does.
+func newStorageKey(key []byte) (_ *storageKey, err error) {
+	var block cipher.Block
+	if block, err = aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+
+	sk := &storageKey{}
+	if sk.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+
+	fingerprint := sha256.Sum256(key)
+	sk.id = hex.EncodeToString(fingerprint[:8])
+	return sk, nil
+}
+
+// encrypt the value of the record with the current storage key.
+func (e *encryption) encrypt(key, val []byte) (_ []byte, err error) {
+	id := e.current.id
+	nonce := make([]byte, e.current.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(encryptedMagic)+1+len(id)+len(nonce)+len(val)+e.current.aead.Overhead())
+	out = append(out, encryptedMagic...)
+	out = append(out, byte(len(id)))
+	out = append(out, id...)
+	out = append(out, nonce...)
+	return e.current.aead.Seal(out, nonce, val, key), nil
+}
+
+// decrypt the value of the record with the key it was encrypted with. Values that are
+// not encrypted, e.g. records written before encryption was enabled, are returned as is.
+func (e *encryption) decrypt(key, val []byte) (_ []byte, err error) {
+	var id string
+	var data []byte
+	if id, data, err = parseEncrypted(val); err != nil || id == "" {
+		return val, err
+	}
+
+	sk, ok := e.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+
+	size := sk.aead.NonceSize()
+	if len(data) < size {
+		return nil, errors.New("encrypted record is truncated")
+	}
+
+	if val, err = sk.aead.Open(nil, data[:size], data[size:], key); err != nil {
+		return nil, fmt.Errorf("could not decrypt record: %s", err)
+	}
+	return val, nil
+}
+
+// parseEncrypted returns the key ID and the nonce and ciphertext of an encrypted value,
+// or an empty key ID if the value is not encrypted.
+func parseEncrypted(val []byte) (id string, data []byte, err error) {
+	if !bytes.HasPrefix(val, encryptedMagic) {
+		return "", nil, nil
+	}
+
+	val = val[len(encryptedMagic):]
+	if len(val) == 0 || len(val) < int(val[0])+1 {
+		return "", nil, errors.New("encrypted record is truncated")
+	}
+	return string(val[1 : val[0]+1]), val[val[0]+1:], nil
+}
+
+// Rekey re-encrypts every record that is not encrypted with the current storage key,
+// including records that are not encrypted at all, so that previous storage keys can
+// be retired after the key is rotated. The number of records rewritten is returned.
+func (s *Store) Rekey() (nrecords uint64, err error) {
+	if s.crypto == nil {
+		return 0, errors.New("storage encryption is not configured")
+	}
+
+	iter := s.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		key := iter.Key()
+		if bytes.HasPrefix(key, []byte(nsMeta+"::")) {
+			continue
+		}
+
+		var id string
+		if id, _, err = parseEncrypted(iter.Value()); err != nil {
+			return nrecords, fmt.Errorf("could not parse record %q: %s", key, err)
+		}
+
+		if id == s.crypto.current.id {
+			continue
+		}
+
+		var val []byte
+		if val, err = s.crypto.decrypt(key, iter.Value()); err != nil {
+			return nrecords, fmt.Errorf("could not decrypt record %q: %s", key, err)
+		}
+
+		if val, err = s.crypto.encrypt(key, val); err != nil {
+			return nrecords, err
+		}
+
+		if err = s.db.Put(append([]byte(nil), key...), val, nil); err != nil {
+			return nrecords, err
+		}
+		nrecords++
+	}
+	return nrecords, iter.Error()
+}
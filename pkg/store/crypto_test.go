@@ -0,0 +1,120 @@
+package store
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/rotationalio/trisa/pkg/config"
+)
+
+func writeKey(t *testing.T, dir, name string, b byte) string {
+	t.Helper()
+	key := make([]byte, keySize)
+	for i := range key {
+		key[i] = b
+	}
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPassphrase(t *testing.T) {
+	conf := config.StorageConfig{Path: filepath.Join(t.TempDir(), "db"), Passphrase: "correct horse"}
+	db, err := Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SeenPeer("peer.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	conf.Passphrase = "battery staple"
+	if _, err = Open(conf); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("expected wrong key error, got %v", err)
+	}
+
+	conf.Passphrase = "correct horse"
+	if db, err = Open(conf); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err = db.GetPeer("peer.example.com"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyCheckRotation(t *testing.T) {
+	dir := t.TempDir()
+	keyA, keyB, keyC := writeKey(t, dir, "a", 1), writeKey(t, dir, "b", 2), writeKey(t, dir, "c", 3)
+	conf := config.StorageConfig{Path: filepath.Join(dir, "db"), EncryptionKey: keyA}
+
+	db, err := Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// The store cannot be opened with a new key unless the previous key is listed
+	conf.EncryptionKey = keyB
+	if _, err = Open(conf); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("expected wrong key error, got %v", err)
+	}
+
+	conf.PreviousKeys = keyA
+	if db, err = Open(conf); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// The check value was re-encrypted with the new key
+	conf.PreviousKeys = ""
+	if db, err = Open(conf); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	conf.EncryptionKey = keyC
+	if _, err = Open(conf); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("expected wrong key error, got %v", err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	sk, err := newStorageKey(make([]byte, keySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &encryption{current: sk, keys: map[string]*storageKey{sk.id: sk}}
+
+	val, err := e.encrypt([]byte("ns::a"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := e.decrypt([]byte("ns::a"), val); err != nil || string(out) != "secret" {
+		t.Fatalf("could not decrypt record: %v", err)
+	}
+
+	// Values cannot be moved to another record
+	if _, err = e.decrypt([]byte("ns::b"), val); err == nil {
+		t.Fatal("expected record key to be authenticated")
+	}
+
+	// Plaintext records are returned as is
+	if out, err := e.decrypt([]byte("ns::a"), []byte("plain")); err != nil || string(out) != "plain" {
+		t.Fatalf("expected plaintext record, got %q %v", out, err)
+	}
+
+	delete(e.keys, sk.id)
+	if _, err = e.decrypt([]byte("ns::a"), val); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected unknown key error, got %v", err)
+	}
+}
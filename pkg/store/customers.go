@@ -0,0 +1,88 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const nsCustomers = "customers"
+
+// Customer is the record of the customer that owns an account, which is used to fill
+// in the beneficiary of transfers to the wallet addresses registered to the account.
+// Person is the protojson encoded IVMS101 person of the customer; if it is empty, a
+// natural person (or a legal person if Legal is set) is created from the name.
+type Customer struct {
+	Account  string          `json:"account"`
+	Name     string          `json:"name,omitempty"`
+	Legal    bool            `json:"legal,omitempty"`
+	Person   json.RawMessage `json:"person,omitempty"`
+	Created  time.Time       `json:"created"`
+	Modified time.Time       `json:"modified"`
+}
+
+// GetCustomer returns the customer that owns the account.
+func (s *Store) GetCustomer(account string) (customer *Customer, err error) {
+	var val []byte
+	if val, err = s.get(nsCustomers, strings.TrimSpace(account)); err != nil {
+		return nil, err
+	}
+
+	customer = &Customer{}
+	if err = json.Unmarshal(val, customer); err != nil {
+		return nil, err
+	}
+	return customer, nil
+}
+
+// PutCustomer creates or updates the customer record of the account.
+func (s *Store) PutCustomer(customer *Customer) (err error) {
+	customer.Account = strings.TrimSpace(customer.Account)
+	if customer.Account == "" {
+		return errors.New("account is required for all customers")
+	}
+	if customer.Name == "" && len(customer.Person) == 0 {
+		return errors.New("a name or an IVMS101 person is required for all customers")
+	}
+
+	var prev *Customer
+	if prev, err = s.GetCustomer(customer.Account); err == nil {
+		customer.Created = prev.Created
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	customer.Modified = time.Now()
+	if customer.Created.IsZero() {
+		customer.Created = customer.Modified
+	}
+
+	var val []byte
+	if val, err = json.Marshal(customer); err != nil {
+		return err
+	}
+	return s.put(nsCustomers, customer.Account, val)
+}
+
+// DeleteCustomer removes the customer record of the account.
+func (s *Store) DeleteCustomer(account string) (err error) {
+	if _, err = s.GetCustomer(account); err != nil {
+		return err
+	}
+	return s.delete(nsCustomers, strings.TrimSpace(account))
+}
+
+// Customers returns all of the customer records.
+func (s *Store) Customers() (customers []*Customer, err error) {
+	customers = make([]*Customer, 0)
+	err = s.iter(nsCustomers, func(_ string, val []byte) error {
+		customer := &Customer{}
+		if err := json.Unmarshal(val, customer); err != nil {
+			return err
+		}
+		customers = append(customers, customer)
+		return nil
+	})
+	return customers, err
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const nsEnvelopes = "envelopes"
+
+// Directions of the secure envelopes in the envelope log.
+const (
+	Incoming = "incoming"
+	Outgoing = "outgoing"
+)
+
+// Envelope is the record of a secure envelope that was received from or sent to a peer,
+// which is kept as the Travel Rule record of the transfer. The envelope is the
+// serialized secure envelope as it was sent, i.e. encrypted; the payload is the
+// decrypted payload in JSON if the server is configured to keep decrypted payloads.
+// Payloads are sealed with the payload key when the envelope is persisted and opened
+// when it is read, or remain sealed if the payload key is not available. Envelopes that
+// were rejected or could not be sent are recorded with the error. Envelopes that were
+// sealed again for a new key after the key they were received with was migrated are
+// marked with the time they were resealed.
+type Envelope struct {
+	ID            string          `json:"id"`
+	Peer          string          `json:"peer"`
+	Direction     string          `json:"direction"`
+	Timestamp     time.Time       `json:"timestamp"`
+	Envelope      []byte          `json:"envelope"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	SealedPayload []byte          `json:"sealed_payload,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	Resealed      time.Time       `json:"resealed,omitempty"`
+}
+
+// envelopeKey orders the envelopes by time; the request and the response of a transfer
+// have the same envelope ID and are distinguished by their direction.
+func envelopeKey(e *Envelope) string {
+	return fmt.Sprintf("%020d:%s:%s", e.Timestamp.UnixNano(), e.Direction, e.ID)
+}
+
+// PutEnvelope appends the envelope to the envelope log. The decrypted payload of the
+// envelope, if any, is sealed with the payload key; ErrNoPayloadKey is returned if no
+// payload key is configured, so that payloads are never kept in plaintext.
+func (s *Store) PutEnvelope(e *Envelope) (err error) {
+	if e.Direction != Incoming && e.Direction != Outgoing {
+		return fmt.Errorf("unknown envelope direction %q", e.Direction)
+	}
+
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	return s.putEnvelope(e)
+}
+
+func (s *Store) putEnvelope(e *Envelope) (err error) {
+	if len(e.Payload) > 0 {
+		if err = s.sealPayload(e); err != nil {
+			return err
+		}
+	}
+
+	var val []byte
+	if val, err = json.Marshal(e); err != nil {
+		return err
+	}
+	return s.put(nsEnvelopes, envelopeKey(e), val)
+}
+
+// Envelopes returns the envelopes in the envelope log since the specified time, oldest
+// first; a zero time returns all of the envelopes. If id is not empty, only the
+// envelopes with the envelope ID are returned. Sealed payloads are opened if the
+// payload key is configured.
+func (s *Store) Envelopes(id string, since time.Time) (envelopes []*Envelope, err error) {
+	envelopes = make([]*Envelope, 0)
+	err = s.iter(nsEnvelopes, func(_ string, val []byte) error {
+		e := &Envelope{}
+		if err := json.Unmarshal(val, e); err != nil {
+			return err
+		}
+
+		if (id == "" || e.ID == id) && !e.Timestamp.Before(since) {
+			if err := s.openPayload(e); err != nil {
+				return err
+			}
+			envelopes = append(envelopes, e)
+		}
+		return nil
+	})
+	return envelopes, err
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+const nsExchangeKeys = "exchange_keys"
+
+// ExchangeKey is a key pair for envelope encryption that was generated by the server
+// rather than loaded from the config. The private key is PKCS #1 DER encoded, so the
+// store should be encrypted if exchange keys are kept in it. Keys are retired when the
+// grace period after a rotation has passed; retired keys no longer open the envelopes
+// of transfers but are kept to open archived envelopes.
+type ExchangeKey struct {
+	ID         string    `json:"id"`
+	PrivateKey []byte    `json:"private_key"`
+	Created    time.Time `json:"created"`
+	Retired    time.Time `json:"retired,omitempty"`
+}
+
+// PutExchangeKey creates or updates the exchange key.
+func (s *Store) PutExchangeKey(key *ExchangeKey) (err error) {
+	var val []byte
+	if val, err = json.Marshal(key); err != nil {
+		return err
+	}
+	return s.put(nsExchangeKeys, key.ID, val)
+}
+
+// DeleteExchangeKey removes the exchange key, e.g. once it is no longer retained.
+func (s *Store) DeleteExchangeKey(id string) error {
+	return s.delete(nsExchangeKeys, id)
+}
+
+// ExchangeKeys returns the generated exchange keys, newest first.
+func (s *Store) ExchangeKeys() (keys []*ExchangeKey, err error) {
+	keys = make([]*ExchangeKey, 0)
+	err = s.iter(nsExchangeKeys, func(_ string, val []byte) error {
+		key := &ExchangeKey{}
+		if err := json.Unmarshal(val, key); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		return nil
+	})
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.After(keys[j].Created) })
+	return keys, err
+}
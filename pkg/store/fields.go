@@ -0,0 +1,239 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/secrets"
+)
+
+// ErrNoFieldKey is returned if fields are resealed without a field key.
+var ErrNoFieldKey = errors.New("fields cannot be sealed without a field key")
+
+// sealedField is the key of the JSON objects that replace sealed fields; protocol buffer
+// JSON field names cannot start with @ other than the @type of Any messages.
+const sealedField = "@sealed"
+
+// FieldKeyID returns the ID of the current field key, or an empty string if no field
+// key is configured.
+func (s *Store) FieldKeyID() string {
+	if s.fields == nil {
+		return ""
+	}
+	return s.fields.current.id
+}
+
+// setupFieldKeys loads the field key and previous field keys from the config along
+// with the names of the fields that are sealed, which default to the default encrypted
+// fields if they are not configured.
+func (s *Store) setupFieldKeys(conf config.StorageConfig) (err error) {
+	if conf.FieldKey == "" {
+		if conf.PreviousFieldKeys != "" {
+			return errors.New("previous field keys require a current field key")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+	defer cancel()
+
+	s.fields = &encryption{keys: make(map[string]*storageKey)}
+	if s.fields.current, err = loadStorageKey(ctx, conf.FieldKey); err != nil {
+		return fmt.Errorf("could not load field key: %s", err)
+	}
+
+	s.fields.keys[s.fields.current.id] = s.fields.current
+	if err = s.fields.loadPrevious(ctx, conf.PreviousFieldKeys); err != nil {
+		return fmt.Errorf("could not load previous field key: %s", err)
+	}
+
+	// Fields are matched by their original and their JSON (lower camel case) names
+	fields := conf.EncryptedFields
+	if strings.TrimSpace(fields) == "" {
+		fields = config.DefaultEncryptedFields
+	}
+
+	s.sealed = make(map[string]bool)
+	for _, name := range strings.Split(fields, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			s.sealed[name] = true
+			s.sealed[camelCase(name)] = true
+		}
+	}
+	return nil
+}
+
+// sealFields seals the sensitive fields anywhere in the JSON value with the current
+// field key, which can be kept in a different secret store than the other keys so that
+// a compromised database and payload key do not leak full identities. Each field is
+// replaced by an object with the sealedField key, whose value is the encrypted JSON of
+// the field sealed with ad. The value is returned as is if no field key is configured.
+func (s *Store) sealFields(ad []byte, val json.RawMessage) (_ json.RawMessage, err error) {
+	if s.fields == nil || len(val) == 0 {
+		return val, nil
+	}
+
+	return transformJSON(val, func(tree interface{}) (interface{}, error) {
+		return s.seal(ad, tree)
+	})
+}
+
+// openFields opens the sealed fields anywhere in the JSON value with the field keys
+// they were sealed with. Fields remain sealed if no field key is configured.
+func (s *Store) openFields(ad []byte, val json.RawMessage) (_ json.RawMessage, err error) {
+	if s.fields == nil || len(val) == 0 || !bytes.Contains(val, []byte(sealedField)) {
+		return val, nil
+	}
+
+	return transformJSON(val, func(tree interface{}) (interface{}, error) {
+		return s.open(ad, tree)
+	})
+}
+
+// seal replaces the sensitive fields of the JSON tree with sealed field objects, leaving
+// fields that are already sealed as they are.
+func (s *Store) seal(ad []byte, tree interface{}) (_ interface{}, err error) {
+	switch node := tree.(type) {
+	case map[string]interface{}:
+		if isSealed(node) {
+			return node, nil
+		}
+
+		for name, field := range node {
+			if !s.sealed[name] {
+				if node[name], err = s.seal(ad, field); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			// Fields that are already sealed are not sealed again
+			if obj, ok := field.(map[string]interface{}); ok && isSealed(obj) {
+				continue
+			}
+
+			var data []byte
+			if data, err = json.Marshal(field); err != nil {
+				return nil, err
+			}
+
+			if data, err = s.fields.encrypt(ad, data); err != nil {
+				return nil, err
+			}
+			node[name] = map[string]interface{}{sealedField: base64.StdEncoding.EncodeToString(data)}
+		}
+	case []interface{}:
+		for i, item := range node {
+			if node[i], err = s.seal(ad, item); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tree, nil
+}
+
+// open replaces the sealed field objects of the JSON tree with the fields they seal.
+func (s *Store) open(ad []byte, tree interface{}) (_ interface{}, err error) {
+	switch node := tree.(type) {
+	case map[string]interface{}:
+		if isSealed(node) {
+			encoded, _ := node[sealedField].(string)
+
+			var data []byte
+			if data, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+				return nil, fmt.Errorf("could not decode sealed field: %s", err)
+			}
+
+			if data, err = s.fields.decrypt(ad, data); err != nil {
+				return nil, fmt.Errorf("could not open sealed field: %w", err)
+			}
+
+			var field interface{}
+			if err = unmarshalJSON(data, &field); err != nil {
+				return nil, err
+			}
+			return field, nil
+		}
+
+		for name, field := range node {
+			if node[name], err = s.open(ad, field); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, item := range node {
+			if node[i], err = s.open(ad, item); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tree, nil
+}
+
+// isSealed returns true if the JSON object is a sealed field.
+func isSealed(node map[string]interface{}) bool {
+	_, ok := node[sealedField]
+	return ok && len(node) == 1
+}
+
+// transformJSON decodes the JSON value, transforms the decoded tree, and encodes it.
+func transformJSON(val json.RawMessage, transform func(interface{}) (interface{}, error)) (_ json.RawMessage, err error) {
+	var tree interface{}
+	if err = unmarshalJSON(val, &tree); err != nil {
+		return nil, err
+	}
+
+	if tree, err = transform(tree); err != nil {
+		return nil, err
+	}
+	return json.Marshal(tree)
+}
+
+// unmarshalJSON decodes JSON numbers as numbers rather than floats so that large
+// integers, e.g. in the amounts of transactions, are not rounded.
+func unmarshalJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// camelCase converts a snake case field name to its lower camel case JSON name.
+func camelCase(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// ResealFields seals the identities of the queued reviews with the current field key,
+// including identities that were queued before the field key was configured, so that
+// previous field keys can be retired after the key is rotated; the fields of envelope
+// and retry payloads are resealed with the payloads by ResealPayloads. The number of
+// records rewritten is returned.
+func (s *Store) ResealFields() (nrecords uint64, err error) {
+	if s.fields == nil {
+		return 0, ErrNoFieldKey
+	}
+
+	var reviews []*Review
+	if reviews, err = s.Reviews(); err != nil {
+		return 0, err
+	}
+
+	for _, review := range reviews {
+		if err = s.PutReview(review); err != nil {
+			return nrecords, err
+		}
+		nrecords++
+	}
+	return nrecords, nil
+}
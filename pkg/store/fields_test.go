@@ -0,0 +1,67 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/rotationalio/trisa/pkg/config"
+)
+
+func TestSealFields(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(config.StorageConfig{
+		Path:            filepath.Join(dir, "db"),
+		FieldKey:        writeKey(t, dir, "field.key", 3),
+		EncryptedFields: "date_and_place_of_birth,national_identification",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if camelCase("date_and_place_of_birth") != "dateAndPlaceOfBirth" {
+		t.Fatalf("unexpected json name %s", camelCase("date_and_place_of_birth"))
+	}
+
+	// Fields are sealed by their JSON names anywhere in the identity, and large numbers
+	// are not rounded when the JSON is rewritten
+	identity := json.RawMessage(`{"originatorPersons":[{"naturalPerson":{"name":"Alice","dateAndPlaceOfBirth":{"dateOfBirth":"1990-01-01"},"nationalIdentification":{"nationalIdentifier":"123-45-6789"}}}],"amount":12345678901234567890}`)
+	sealed, err := db.sealFields([]byte("record"), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"1990-01-01", "123-45-6789"} {
+		if bytes.Contains(sealed, []byte(secret)) {
+			t.Errorf("field %s was not sealed", secret)
+		}
+	}
+	if !bytes.Contains(sealed, []byte("Alice")) || !bytes.Contains(sealed, []byte(sealedField)) {
+		t.Errorf("unexpected sealed identity %s", sealed)
+	}
+
+	// Sealing is idempotent so that resealed records are not sealed twice
+	twice, err := db.sealFields([]byte("record"), sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.openFields([]byte("other"), twice); err == nil {
+		t.Error("expected fields sealed for another record not to open")
+	}
+
+	opened, err := db.openFields([]byte("record"), twice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fields are opened in place, but the keys of objects are reordered
+	expected, err := transformJSON(identity, func(tree interface{}) (interface{}, error) { return tree, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, expected) {
+		t.Errorf("opened identity does not match\n  expected: %s\n       got: %s", expected, opened)
+	}
+}
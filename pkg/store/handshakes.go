@@ -0,0 +1,62 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const nsHandshakes = "handshakes"
+
+// Handshake is the audit record of an mTLS handshake with a peer, which is evidence of
+// who connected to the node and with which credentials. Failed handshakes are recorded
+// with the error and any details of the peer certificate that are available.
+type Handshake struct {
+	Time        time.Time `json:"time"`
+	RemoteAddr  string    `json:"remote_addr"`
+	CommonName  string    `json:"common_name,omitempty"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	Serial      string    `json:"serial,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	CipherSuite string    `json:"cipher_suite,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// handshakeKey orders the handshakes by time; the remote address distinguishes
+// handshakes that happen at the same time.
+func handshakeKey(h *Handshake) string {
+	return fmt.Sprintf("%020d:%s", h.Time.UnixNano(), h.RemoteAddr)
+}
+
+// PutHandshake appends the handshake to the audit log.
+func (s *Store) PutHandshake(h *Handshake) (err error) {
+	if h.Time.IsZero() {
+		h.Time = time.Now()
+	}
+
+	var val []byte
+	if val, err = json.Marshal(h); err != nil {
+		return err
+	}
+	return s.put(nsHandshakes, handshakeKey(h), val)
+}
+
+// Handshakes returns the handshakes in the audit log since the specified time, oldest
+// first; a zero time returns all of the handshakes.
+func (s *Store) Handshakes(since time.Time) (handshakes []*Handshake, err error) {
+	handshakes = make([]*Handshake, 0)
+	err = s.iter(nsHandshakes, func(_ string, val []byte) error {
+		h := &Handshake{}
+		if err := json.Unmarshal(val, h); err != nil {
+			return err
+		}
+
+		if !h.Time.Before(since) {
+			handshakes = append(handshakes, h)
+		}
+		return nil
+	})
+	return handshakes, err
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const nsKeyUsage = "key_usage"
+
+// Uses of keys in the key usage audit log.
+const (
+	KeyOpened     = "opened"
+	KeySealed     = "sealed"
+	KeyAdvertised = "advertised"
+)
+
+// KeyUsage is the audit record of a use of an envelope key: a secure envelope from a
+// peer that was opened with a private key of the node, an envelope that was sealed for
+// a peer with the key of the peer, or a key of the node that was advertised to a peer
+// in a key exchange. The key is identified by the truncated SHA-256 fingerprint of its
+// public key. Envelopes that could not be opened are recorded with the error. Records
+// are only ever appended to the key usage log, and are removed once they are older than
+// the retention period of the log.
+type KeyUsage struct {
+	Time       time.Time `json:"time"`
+	Use        string    `json:"use"`
+	KeyID      string    `json:"key_id,omitempty"`
+	Peer       string    `json:"peer,omitempty"`
+	EnvelopeID string    `json:"envelope_id,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// keyUsageTime is the prefix of the keys of the records at the time, which orders the
+// key usage log by time so that it can be read and pruned from a point in time.
+func keyUsageTime(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// keyUsageKey orders the key usage records by time; a random suffix distinguishes
+// records that happen at the same time so that they do not overwrite each other.
+func keyUsageKey(u *KeyUsage) (_ string, err error) {
+	suffix := make([]byte, 8)
+	if _, err = rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return keyUsageTime(u.Time) + ":" + hex.EncodeToString(suffix), nil
+}
+
+// PutKeyUsage appends the key usage record to the audit log.
+func (s *Store) PutKeyUsage(u *KeyUsage) (err error) {
+	if u.Time.IsZero() {
+		u.Time = time.Now()
+	}
+
+	var id string
+	if id, err = keyUsageKey(u); err != nil {
+		return err
+	}
+
+	var val []byte
+	if val, err = json.Marshal(u); err != nil {
+		return err
+	}
+	return s.put(nsKeyUsage, id, val)
+}
+
+// KeyUsage returns the key usage records in the audit log since the specified time,
+// oldest first; a zero time returns all of the records.
+func (s *Store) KeyUsage(since time.Time) (records []*KeyUsage, err error) {
+	span := prefix(nsKeyUsage)
+	if !since.IsZero() {
+		span.Start = key(nsKeyUsage, keyUsageTime(since))
+	}
+
+	iter := s.db.NewIterator(span, nil)
+	defer iter.Release()
+
+	records = make([]*KeyUsage, 0)
+	for iter.Next() {
+		val := iter.Value()
+		if s.crypto != nil {
+			if val, err = s.crypto.decrypt(iter.Key(), val); err != nil {
+				return nil, err
+			}
+		}
+
+		u := &KeyUsage{}
+		if err = json.Unmarshal(val, u); err != nil {
+			return nil, err
+		}
+		records = append(records, u)
+	}
+
+	if err = iter.Error(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// DeleteKeyUsageBefore removes the key usage records before the specified time from the
+// audit log and returns the number of records that were removed.
+func (s *Store) DeleteKeyUsageBefore(before time.Time) (n int, err error) {
+	iter := s.db.NewIterator(&util.Range{Start: prefix(nsKeyUsage).Start, Limit: key(nsKeyUsage, keyUsageTime(before))}, nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	if err = iter.Error(); err != nil {
+		return 0, err
+	}
+
+	if err = s.db.Write(batch, nil); err != nil {
+		return 0, err
+	}
+	return batch.Len(), nil
+}
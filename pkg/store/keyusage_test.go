@@ -0,0 +1,49 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+)
+
+func TestKeyUsage(t *testing.T) {
+	db, err := Open(config.StorageConfig{Passphrase: "key usage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		// Identical records at the same time are all kept
+		for j := 0; j < 2; j++ {
+			if err = db.PutKeyUsage(&KeyUsage{Time: start.Add(time.Duration(i) * time.Hour), Use: KeyOpened, Peer: "peer", EnvelopeID: "envelope"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	records, err := db.KeyUsage(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 6 {
+		t.Fatalf("expected 6 records, got %d", len(records))
+	}
+
+	if records, err = db.KeyUsage(start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || !records[0].Time.Equal(start.Add(time.Hour)) {
+		t.Fatalf("expected 4 records since the second hour, got %d", len(records))
+	}
+
+	n, err := db.DeleteKeyUsageBefore(start.Add(2 * time.Hour))
+	if err != nil || n != 4 {
+		t.Fatalf("expected 4 records to be pruned, got %d: %v", n, err)
+	}
+	if records, err = db.KeyUsage(time.Time{}); err != nil || len(records) != 2 {
+		t.Fatalf("expected 2 records to remain, got %d: %v", len(records), err)
+	}
+}
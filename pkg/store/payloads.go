@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/secrets"
+)
+
+// ErrNoPayloadKey is returned if a decrypted payload is persisted without a payload key.
+var ErrNoPayloadKey = errors.New("payloads cannot be persisted without a payload key")
+
+// PayloadKeyID returns the ID of the current payload key, or an empty string if no
+// payload key is configured.
+func (s *Store) PayloadKeyID() string {
+	if s.payloads == nil {
+		return ""
+	}
+	return s.payloads.current.id
+}
+
+// setupPayloadKeys loads the payload key and previous payload keys from the config.
+func (s *Store) setupPayloadKeys(conf config.StorageConfig) (err error) {
+	if conf.PayloadKey == "" {
+		if conf.PreviousPayloadKeys != "" {
+			return errors.New("previous payload keys require a current payload key")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+	defer cancel()
+
+	s.payloads = &encryption{keys: make(map[string]*storageKey)}
+	if s.payloads.current, err = loadStorageKey(ctx, conf.PayloadKey); err != nil {
+		return fmt.Errorf("could not load payload key: %s", err)
+	}
+
+	s.payloads.keys[s.payloads.current.id] = s.payloads.current
+	if err = s.payloads.loadPrevious(ctx, conf.PreviousPayloadKeys); err != nil {
+		return fmt.Errorf("could not load previous payload key: %s", err)
+	}
+	return nil
+}
+
+// sealPayload seals the decrypted payload of the envelope with the current payload key,
+// after sealing its sensitive fields with the field key, and clears the plaintext
+// payload. The payload key is distinct from the signing keys so that records can still
+// be read after the certificates are rotated, and the key of the envelope record is the
+// additional data so that sealed payloads cannot be swapped between records.
+func (s *Store) sealPayload(e *Envelope) (err error) {
+	if s.payloads == nil {
+		return ErrNoPayloadKey
+	}
+
+	ad := key(nsEnvelopes, envelopeKey(e))
+	var payload []byte
+	if payload, err = s.sealFields(ad, e.Payload); err != nil {
+		return err
+	}
+
+	if e.SealedPayload, err = s.payloads.encrypt(ad, payload); err != nil {
+		return err
+	}
+	e.Payload = nil
+	return nil
+}
+
+// openPayload decrypts the sealed payload of the envelope with the payload key it was
+// sealed with, and its sealed fields with the field key. Payloads remain sealed if no
+// payload key is configured.
+func (s *Store) openPayload(e *Envelope) (err error) {
+	if s.payloads == nil || len(e.SealedPayload) == 0 {
+		return nil
+	}
+
+	ad := key(nsEnvelopes, envelopeKey(e))
+	var payload []byte
+	if payload, err = s.payloads.decrypt(ad, e.SealedPayload); err != nil {
+		return fmt.Errorf("could not open payload of envelope %s: %w", e.ID, err)
+	}
+
+	if payload, err = s.openFields(ad, payload); err != nil {
+		return fmt.Errorf("could not open payload of envelope %s: %w", e.ID, err)
+	}
+	e.Payload, e.SealedPayload = payload, nil
+	return nil
+}
+
+// ResealPayloads seals every payload in the envelope log that is not sealed with the
+// current payload key, including payloads that were kept in plaintext before payloads
+// were sealed, and the payload of every scheduled retry, so that previous payload keys
+// can be retired after the key is rotated. If a field key is configured, every payload
+// is resealed so that its sensitive fields are sealed with the current field key as
+// well. The number of records rewritten is returned.
+func (s *Store) ResealPayloads() (nrecords uint64, err error) {
+	if s.payloads == nil {
+		return 0, ErrNoPayloadKey
+	}
+
+	var envelopes []*Envelope
+	err = s.iter(nsEnvelopes, func(_ string, val []byte) error {
+		e := &Envelope{}
+		if err := json.Unmarshal(val, e); err != nil {
+			return err
+		}
+
+		if len(e.Payload) > 0 {
+			envelopes = append(envelopes, e)
+			return nil
+		}
+
+		if len(e.SealedPayload) > 0 {
+			id, _, err := parseEncrypted(e.SealedPayload)
+			if err != nil {
+				return fmt.Errorf("could not parse payload of envelope %s: %s", e.ID, err)
+			}
+
+			// Payloads are opened to reseal their fields if a field key is configured
+			if id != s.payloads.current.id || s.fields != nil {
+				if err = s.openPayload(e); err != nil {
+					return err
+				}
+				envelopes = append(envelopes, e)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range envelopes {
+		if err = s.putEnvelope(e); err != nil {
+			return nrecords, err
+		}
+		nrecords++
+	}
+
+	var retries []*Retry
+	if retries, err = s.Retries(time.Time{}); err != nil {
+		return nrecords, err
+	}
+
+	for _, retry := range retries {
+		if err = s.PutRetry(retry); err != nil {
+			return nrecords, err
+		}
+		nrecords++
+	}
+	return nrecords, nil
+}
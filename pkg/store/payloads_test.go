@@ -0,0 +1,80 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+)
+
+func TestSealPayloads(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, newKey := writeKey(t, dir, "old.key", 1), writeKey(t, dir, "new.key", 2)
+	conf := config.StorageConfig{Path: filepath.Join(dir, "db"), PayloadKey: oldKey}
+
+	db, err := Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := json.RawMessage(`{"identity":{"originator":"secret"}}`)
+	for _, id := range []string{"1", "2"} {
+		if err = db.PutEnvelope(&Envelope{ID: id, Peer: "peer", Direction: Incoming, Payload: payload}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := db.Envelopes("", time.Time{})
+	if err != nil || len(records) != 2 {
+		t.Fatalf("could not read envelopes: %v", err)
+	}
+	raw, err := db.get(nsEnvelopes, envelopeKey(records[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret")) {
+		t.Fatal("payload was persisted in plaintext")
+	}
+	if !bytes.Equal(records[0].Payload, payload) {
+		t.Fatalf("unexpected payload %s", records[0].Payload)
+	}
+
+	// Sealed payloads cannot be moved to another record
+	var sealed Envelope
+	if err = json.Unmarshal(raw, &sealed); err != nil {
+		t.Fatal(err)
+	}
+	other := &Envelope{ID: "2", Timestamp: records[1].Timestamp, Direction: Incoming, SealedPayload: sealed.SealedPayload}
+	if err = db.openPayload(other); err == nil {
+		t.Fatal("expected a payload moved to another record not to open")
+	}
+	db.Close()
+
+	// Payloads sealed with the previous key are resealed with the current key
+	conf.PayloadKey, conf.PreviousPayloadKeys = newKey, oldKey
+	if db, err = Open(conf); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.ResealPayloads(); err != nil || n != 2 {
+		t.Fatalf("expected 2 payloads to be resealed, got %d (%v)", n, err)
+	}
+	db.Close()
+
+	conf.PreviousPayloadKeys = ""
+	if db, err = Open(conf); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if records, err = db.Envelopes("", time.Time{}); err != nil {
+		t.Fatalf("could not open resealed payloads without the previous key: %s", err)
+	}
+	for _, record := range records {
+		if !bytes.Equal(record.Payload, payload) {
+			t.Errorf("unexpected payload %s of envelope %s", record.Payload, record.ID)
+		}
+	}
+}
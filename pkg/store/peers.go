@@ -0,0 +1,137 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const nsPeers = "peers"
+
+// Peer is the persisted record of a TRISA counterparty that the node has interacted
+// with, forming the address book of peers that the node knows about. The signing key
+// of the peer from the last key exchange is kept as a PKIX DER encoded public key so
+// that keys do not have to be exchanged again after a restart, with the validity period
+// of the key that the peer advertised, if any. ExchangeKeyID is the ID of the exchange
+// key that was last advertised to the peer, which peers seal their envelopes with.
+type Peer struct {
+	CommonName          string    `json:"common_name"`
+	ID                  string    `json:"id,omitempty"`
+	RegisteredDirectory string    `json:"registered_directory,omitempty"`
+	Endpoint            string    `json:"endpoint,omitempty"`
+	FirstSeen           time.Time `json:"first_seen"`
+	LastSeen            time.Time `json:"last_seen"`
+	SigningKey          []byte    `json:"signing_key,omitempty"`
+	KeyExchanged        time.Time `json:"key_exchanged,omitempty"`
+	KeyNotBefore        time.Time `json:"key_not_before,omitempty"`
+	KeyNotAfter         time.Time `json:"key_not_after,omitempty"`
+	ExchangeKeyID       string    `json:"exchange_key_id,omitempty"`
+}
+
+// GetPeer returns the peer record with the specified common name.
+func (s *Store) GetPeer(commonName string) (peer *Peer, err error) {
+	var val []byte
+	if val, err = s.get(nsPeers, commonName); err != nil {
+		return nil, err
+	}
+
+	peer = &Peer{}
+	if err = json.Unmarshal(val, peer); err != nil {
+		return nil, err
+	}
+	return peer, nil
+}
+
+// PutPeer creates or updates the peer record. Empty fields do not overwrite the
+// existing values of the peer record so that partial information can be merged.
+func (s *Store) PutPeer(peer *Peer) (err error) {
+	if peer.CommonName == "" {
+		return errors.New("common name is required for all peers")
+	}
+
+	var prev *Peer
+	if prev, err = s.GetPeer(peer.CommonName); err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		prev = &Peer{CommonName: peer.CommonName, FirstSeen: time.Now()}
+	}
+
+	if peer.ID != "" {
+		prev.ID = peer.ID
+	}
+	if peer.RegisteredDirectory != "" {
+		prev.RegisteredDirectory = peer.RegisteredDirectory
+	}
+	if peer.Endpoint != "" {
+		prev.Endpoint = peer.Endpoint
+	}
+	if !peer.LastSeen.IsZero() {
+		prev.LastSeen = peer.LastSeen
+	}
+	if len(peer.SigningKey) > 0 {
+		prev.SigningKey, prev.KeyExchanged = peer.SigningKey, peer.KeyExchanged
+		prev.KeyNotBefore, prev.KeyNotAfter = peer.KeyNotBefore, peer.KeyNotAfter
+	}
+	if peer.ExchangeKeyID != "" {
+		prev.ExchangeKeyID = peer.ExchangeKeyID
+	}
+
+	var val []byte
+	if val, err = json.Marshal(prev); err != nil {
+		return err
+	}
+	return s.put(nsPeers, prev.CommonName, val)
+}
+
+// SeenPeer records that the peer with the common name has contacted the node.
+func (s *Store) SeenPeer(commonName string) error {
+	return s.PutPeer(&Peer{CommonName: commonName, LastSeen: time.Now()})
+}
+
+// PutPeerKey records the signing key the peer sent in a key exchange and its validity
+// period, where zero times are not bounded.
+func (s *Store) PutPeerKey(commonName string, key []byte, notBefore, notAfter time.Time) error {
+	return s.PutPeer(&Peer{
+		CommonName:   commonName,
+		SigningKey:   key,
+		KeyExchanged: time.Now(),
+		KeyNotBefore: notBefore,
+		KeyNotAfter:  notAfter,
+	})
+}
+
+// DeletePeerKey removes the signing key of the peer and its validity period, e.g. when
+// the peer has rotated its key, so that keys are exchanged again.
+func (s *Store) DeletePeerKey(commonName string) (err error) {
+	var peer *Peer
+	if peer, err = s.GetPeer(commonName); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	peer.SigningKey, peer.KeyExchanged = nil, time.Time{}
+	peer.KeyNotBefore, peer.KeyNotAfter = time.Time{}, time.Time{}
+
+	var val []byte
+	if val, err = json.Marshal(peer); err != nil {
+		return err
+	}
+	return s.put(nsPeers, peer.CommonName, val)
+}
+
+// Peers returns all of the peer records in the address book.
+func (s *Store) Peers() (peers []*Peer, err error) {
+	peers = make([]*Peer, 0)
+	err = s.iter(nsPeers, func(_ string, val []byte) error {
+		peer := &Peer{}
+		if err := json.Unmarshal(val, peer); err != nil {
+			return err
+		}
+		peers = append(peers, peer)
+		return nil
+	})
+	return peers, err
+}
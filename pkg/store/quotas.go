@@ -0,0 +1,80 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const nsQuotas = "quotas"
+
+// Quota is the usage of the daily quotas of a peer on a UTC day (formatted as
+// 2006-01-02): the number of transfers accepted from the peer and their cumulative
+// amount, keyed by the asset of the transfers or by the currency they were converted
+// to. Usage is kept in the store so that the quotas are not reset by a restart.
+type Quota struct {
+	Peer      string             `json:"peer"`
+	Day       string             `json:"day"`
+	Transfers int                `json:"transfers"`
+	Amounts   map[string]float64 `json:"amounts,omitempty"`
+}
+
+// quotaKey orders the usage by day so that the usage of past days can be pruned.
+func quotaKey(day, peer string) string {
+	return day + ":" + peer
+}
+
+// UpdateQuota calls fn with the usage of the peer on the day and stores the usage if fn
+// returns nil. Updates are serialized so that concurrent transfers cannot both reserve
+// the remainder of a quota.
+func (s *Store) UpdateQuota(peer, day string, fn func(*Quota) error) (err error) {
+	s.quotamu.Lock()
+	defer s.quotamu.Unlock()
+
+	q := &Quota{Peer: peer, Day: day}
+	var val []byte
+	if val, err = s.get(nsQuotas, quotaKey(day, peer)); err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	} else if err = json.Unmarshal(val, q); err != nil {
+		return err
+	}
+
+	if q.Amounts == nil {
+		q.Amounts = make(map[string]float64)
+	}
+	if err = fn(q); err != nil {
+		return err
+	}
+
+	if val, err = json.Marshal(q); err != nil {
+		return err
+	}
+	return s.put(nsQuotas, quotaKey(day, peer), val)
+}
+
+// DeleteQuotasBefore removes the usage of the days before the day and returns the
+// number of records that were removed.
+func (s *Store) DeleteQuotasBefore(day string) (n int, err error) {
+	s.quotamu.Lock()
+	defer s.quotamu.Unlock()
+
+	iter := s.db.NewIterator(&util.Range{Start: prefix(nsQuotas).Start, Limit: key(nsQuotas, day)}, nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	if err = iter.Error(); err != nil {
+		return 0, err
+	}
+
+	if err = s.db.Write(batch, nil); err != nil {
+		return 0, err
+	}
+	return batch.Len(), nil
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	nsReconciliation = "reconciliation"
+	latestReport     = "latest"
+)
+
+// Finding is the outcome of reconciling a transaction with its settlement on chain.
+type Finding string
+
+// Findings of a reconciliation. Transactions are matched if their settlement was
+// confirmed on chain and are pending while their settlement is verified. Mismatched
+// settlements differ in amount from the Travel Rule message, orphaned transactions are
+// Travel Rule records whose transaction never settled on chain within the verification
+// window, unlinked transactions completed without a network and transaction ID to
+// verify, and unexpected settlements are transactions that settled on chain although
+// the Travel Rule exchange was rejected or expired. All but matched and pending
+// transactions are flagged for follow-up.
+const (
+	FindingMatched    Finding = "matched"
+	FindingPending    Finding = "pending"
+	FindingMismatch   Finding = "mismatch"
+	FindingOrphaned   Finding = "orphaned"
+	FindingUnlinked   Finding = "unlinked"
+	FindingUnexpected Finding = "unexpected"
+)
+
+// Flagged returns true if the finding requires follow-up.
+func (f Finding) Flagged() bool {
+	return f != FindingMatched && f != FindingPending
+}
+
+// Reconciliation is a report that links the Travel Rule records of the node to their
+// settlements on chain. Counts has the number of transactions of each finding and
+// Flagged has the transactions that require follow-up, oldest first.
+type Reconciliation struct {
+	Generated time.Time             `json:"generated"`
+	Since     time.Time             `json:"since"`
+	Counts    map[Finding]int       `json:"counts"`
+	Flagged   []*ReconciliationItem `json:"flagged"`
+}
+
+// ReconciliationItem is a transaction flagged by a reconciliation with the settlement
+// that was recorded for it, if any.
+type ReconciliationItem struct {
+	EnvelopeID string      `json:"envelope_id"`
+	Peer       string      `json:"peer"`
+	State      State       `json:"state"`
+	Created    time.Time   `json:"created"`
+	Finding    Finding     `json:"finding"`
+	Settlement *Settlement `json:"settlement,omitempty"`
+}
+
+// GetReconciliation returns the latest reconciliation report.
+func (s *Store) GetReconciliation() (report *Reconciliation, err error) {
+	var val []byte
+	if val, err = s.get(nsReconciliation, latestReport); err != nil {
+		return nil, err
+	}
+
+	report = &Reconciliation{}
+	if err = json.Unmarshal(val, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// PutReconciliation replaces the latest reconciliation report.
+func (s *Store) PutReconciliation(report *Reconciliation) (err error) {
+	var val []byte
+	if val, err = json.Marshal(report); err != nil {
+		return err
+	}
+	return s.put(nsReconciliation, latestReport, val)
+}
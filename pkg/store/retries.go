@@ -0,0 +1,131 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const nsRetries = "retries"
+
+// Retry is an outgoing transfer that the counterparty rejected with a retryable TRISA
+// error and that is sent again at the next attempt timestamp. The payload is the payload
+// of the transfer in JSON, which is sealed with the payload key when the retry is
+// persisted and opened when it is read, and is kept only until the transfer is
+// delivered or given up on. The code and error are the TRISA error of the last attempt.
+// The counterparty is the common name of the beneficiary VASP and Email its compliance
+// contact, which the sunrise fallback sends the transfer to.
+type Retry struct {
+	EnvelopeID    string          `json:"envelope_id"`
+	Counterparty  string          `json:"counterparty"`
+	Email         string          `json:"email,omitempty"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	SealedPayload []byte          `json:"sealed_payload,omitempty"`
+	Attempts      int             `json:"attempts"`
+	Code          string          `json:"code,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	Created       time.Time       `json:"created"`
+	NextAttempt   time.Time       `json:"next_attempt"`
+}
+
+// GetRetry returns the retry of the transfer with the envelope ID.
+func (s *Store) GetRetry(envelopeID string) (retry *Retry, err error) {
+	var val []byte
+	if val, err = s.get(nsRetries, envelopeID); err != nil {
+		return nil, err
+	}
+
+	retry = &Retry{}
+	if err = json.Unmarshal(val, retry); err != nil {
+		return nil, err
+	}
+
+	if err = s.openRetry(retry); err != nil {
+		return nil, err
+	}
+	return retry, nil
+}
+
+// PutRetry schedules the retry of the transfer, sealing its payload with the payload
+// key and its sensitive fields with the field key; ErrNoPayloadKey is returned if no
+// payload key is configured.
+func (s *Store) PutRetry(retry *Retry) (err error) {
+	if retry.Created.IsZero() {
+		retry.Created = time.Now()
+	}
+
+	if len(retry.Payload) > 0 {
+		if s.payloads == nil {
+			return ErrNoPayloadKey
+		}
+
+		ad := key(nsRetries, retry.EnvelopeID)
+		var payload []byte
+		if payload, err = s.sealFields(ad, retry.Payload); err != nil {
+			return err
+		}
+
+		sealed := *retry
+		if sealed.SealedPayload, err = s.payloads.encrypt(ad, payload); err != nil {
+			return err
+		}
+		sealed.Payload = nil
+		retry = &sealed
+	}
+
+	var val []byte
+	if val, err = json.Marshal(retry); err != nil {
+		return err
+	}
+	return s.put(nsRetries, retry.EnvelopeID, val)
+}
+
+// DeleteRetry removes the retry once the transfer is delivered or given up on.
+func (s *Store) DeleteRetry(envelopeID string) error {
+	return s.delete(nsRetries, envelopeID)
+}
+
+// Retries returns the scheduled retries that are due by the specified time; a zero time
+// returns all of the scheduled retries. Sealed payloads are opened if the payload key
+// is configured.
+func (s *Store) Retries(due time.Time) (retries []*Retry, err error) {
+	retries = make([]*Retry, 0)
+	err = s.iter(nsRetries, func(_ string, val []byte) error {
+		retry := &Retry{}
+		if err := json.Unmarshal(val, retry); err != nil {
+			return err
+		}
+
+		if !due.IsZero() && retry.NextAttempt.After(due) {
+			return nil
+		}
+
+		if err := s.openRetry(retry); err != nil {
+			return err
+		}
+		retries = append(retries, retry)
+		return nil
+	})
+	return retries, err
+}
+
+// openRetry decrypts the sealed payload of the retry with the payload key it was sealed
+// with, and its sealed fields with the field key. Payloads remain sealed if no payload
+// key is configured.
+func (s *Store) openRetry(retry *Retry) (err error) {
+	if s.payloads == nil || len(retry.SealedPayload) == 0 {
+		return nil
+	}
+
+	ad := key(nsRetries, retry.EnvelopeID)
+	var payload []byte
+	if payload, err = s.payloads.decrypt(ad, retry.SealedPayload); err != nil {
+		return fmt.Errorf("could not open payload of retry %s: %w", retry.EnvelopeID, err)
+	}
+
+	if payload, err = s.openFields(ad, payload); err != nil {
+		return fmt.Errorf("could not open payload of retry %s: %w", retry.EnvelopeID, err)
+	}
+	retry.Payload, retry.SealedPayload = payload, nil
+	return nil
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const nsReviews = "reviews"
+
+// Review is a transfer that was answered with a pending message and is queued for a
+// human or automated review; the final decision must be sent to the peer before the
+// reply not after timestamp. The reason tells the reviewer why the transfer is being
+// reviewed and is not sent to the peer. The identity and transaction are the decrypted
+// identity and transaction of the transfer in JSON, so they are kept only until the
+// review is resolved, and the sensitive fields of the identity are sealed with the
+// field key if one is configured.
+type Review struct {
+	EnvelopeID     string          `json:"envelope_id"`
+	Peer           string          `json:"peer"`
+	Reason         string          `json:"reason,omitempty"`
+	Identity       json.RawMessage `json:"identity,omitempty"`
+	Transaction    json.RawMessage `json:"transaction,omitempty"`
+	ReceivedAt     time.Time       `json:"received_at"`
+	ReplyNotBefore time.Time       `json:"reply_not_before"`
+	ReplyNotAfter  time.Time       `json:"reply_not_after"`
+}
+
+// GetReview returns the review of the transfer with the envelope ID.
+func (s *Store) GetReview(envelopeID string) (review *Review, err error) {
+	var val []byte
+	if val, err = s.get(nsReviews, envelopeID); err != nil {
+		return nil, err
+	}
+
+	review = &Review{}
+	if err = json.Unmarshal(val, review); err != nil {
+		return nil, err
+	}
+
+	if review.Identity, err = s.openFields(key(nsReviews, review.EnvelopeID), review.Identity); err != nil {
+		return nil, fmt.Errorf("could not open identity of review %s: %w", review.EnvelopeID, err)
+	}
+	return review, nil
+}
+
+// PutReview queues the transfer for review, sealing the sensitive fields of its
+// identity with the field key if one is configured.
+func (s *Store) PutReview(review *Review) (err error) {
+	sealed := *review
+	if sealed.Identity, err = s.sealFields(key(nsReviews, review.EnvelopeID), review.Identity); err != nil {
+		return err
+	}
+
+	var val []byte
+	if val, err = json.Marshal(&sealed); err != nil {
+		return err
+	}
+	return s.put(nsReviews, review.EnvelopeID, val)
+}
+
+// DeleteReview removes the review once the decision has been sent to the peer.
+func (s *Store) DeleteReview(envelopeID string) error {
+	return s.delete(nsReviews, envelopeID)
+}
+
+// Reviews returns the transfers that are waiting for a decision.
+func (s *Store) Reviews() (reviews []*Review, err error) {
+	reviews = make([]*Review, 0)
+	err = s.iter(nsReviews, func(_ string, val []byte) error {
+		review := &Review{}
+		if err := json.Unmarshal(val, review); err != nil {
+			return err
+		}
+
+		var err error
+		if review.Identity, err = s.openFields(key(nsReviews, review.EnvelopeID), review.Identity); err != nil {
+			return fmt.Errorf("could not open identity of review %s: %w", review.EnvelopeID, err)
+		}
+		reviews = append(reviews, review)
+		return nil
+	})
+	return reviews, err
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const nsSecurityEvents = "security_events"
+
+// Kinds of security events.
+const (
+	EventInvalidSignature = "invalid_signature"
+)
+
+// SecurityEvent is the audit record of a message from a peer that failed a security
+// check, e.g. a secure envelope whose payload does not match its HMAC signature, which
+// is evidence that the message was tampered with or forged. Security events are always
+// kept, whether or not the other audit records are persisted.
+type SecurityEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Peer       string    `json:"peer,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	EnvelopeID string    `json:"envelope_id,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// securityEventKey orders the security events by time; the peer and envelope ID
+// distinguish events that happen at the same time.
+func securityEventKey(e *SecurityEvent) string {
+	return fmt.Sprintf("%020d:%s:%s", e.Time.UnixNano(), e.Peer, e.EnvelopeID)
+}
+
+// PutSecurityEvent appends the security event to the audit log.
+func (s *Store) PutSecurityEvent(e *SecurityEvent) (err error) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	var val []byte
+	if val, err = json.Marshal(e); err != nil {
+		return err
+	}
+	return s.put(nsSecurityEvents, securityEventKey(e), val)
+}
+
+// SecurityEvents returns the security events in the audit log since the specified time,
+// oldest first; a zero time returns all of the security events.
+func (s *Store) SecurityEvents(since time.Time) (events []*SecurityEvent, err error) {
+	events = make([]*SecurityEvent, 0)
+	err = s.iter(nsSecurityEvents, func(_ string, val []byte) error {
+		e := &SecurityEvent{}
+		if err := json.Unmarshal(val, e); err != nil {
+			return err
+		}
+
+		if !e.Time.Before(since) {
+			events = append(events, e)
+		}
+		return nil
+	})
+	return events, err
+}
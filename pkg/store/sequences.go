@@ -0,0 +1,284 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Namespaces for sequence tracking
+const (
+	nsSequences = "sequences"
+	nsSeqIndex  = "seqindex"
+)
+
+// Maximum number of gaps and duplicates retained per peer for the reconciliation report.
+const (
+	maxGaps       = 100
+	maxDuplicates = 100
+)
+
+// The sequence index maps the envelope IDs received from a peer to their sequence
+// numbers to detect duplicates. Envelopes more than seqIndexWindow messages below the
+// contiguous high-water mark, the last message up to which every message received from
+// the peer has been answered, are removed from the index every seqIndexCompaction
+// messages sent to the peer so that the index does not grow without bound.
+const (
+	seqIndexWindow     = 10000
+	seqIndexCompaction = 1000
+)
+
+// Sequence tracks the monotonically increasing sequence numbers of messages exchanged
+// with a counterparty, along with any gaps or duplicates detected in the exchange.
+type Sequence struct {
+	Peer               string      `json:"peer"`
+	Received           uint64      `json:"received"`
+	Sent               uint64      `json:"sent"`
+	Duplicates         uint64      `json:"duplicates"`
+	Streams            uint64      `json:"streams"`
+	Gaps               []Gap       `json:"gaps,omitempty"`
+	DuplicateEnvelopes []Duplicate `json:"duplicate_envelopes,omitempty"`
+	LastSeen           time.Time   `json:"last_seen"`
+}
+
+// Gap records messages that were lost in the exchange with a counterparty.
+type Gap struct {
+	From       uint64    `json:"from"`
+	To         uint64    `json:"to"`
+	Reason     string    `json:"reason"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// Duplicate records an envelope that the counterparty sent again, with the sequence
+// number of the duplicate and of the original message.
+type Duplicate struct {
+	EnvelopeID  string    `json:"envelope_id"`
+	Seq         uint64    `json:"seq"`
+	OriginalSeq uint64    `json:"original_seq"`
+	DetectedAt  time.Time `json:"detected_at"`
+}
+
+// Outstanding returns the number of received messages that have not been answered.
+func (s *Sequence) Outstanding() uint64 {
+	if s.Received > s.Sent {
+		return s.Received - s.Sent
+	}
+	return 0
+}
+
+func (s *Sequence) addGap(from, to uint64, reason string) Gap {
+	gap := Gap{From: from, To: to, Reason: reason, DetectedAt: time.Now()}
+	s.Gaps = append(s.Gaps, gap)
+	if len(s.Gaps) > maxGaps {
+		s.Gaps = s.Gaps[len(s.Gaps)-maxGaps:]
+	}
+	return gap
+}
+
+func (s *Sequence) addDuplicate(envelopeID string, seq, orig uint64) {
+	s.Duplicates++
+	s.DuplicateEnvelopes = append(s.DuplicateEnvelopes, Duplicate{EnvelopeID: envelopeID, Seq: seq, OriginalSeq: orig, DetectedAt: time.Now()})
+	if len(s.DuplicateEnvelopes) > maxDuplicates {
+		s.DuplicateEnvelopes = s.DuplicateEnvelopes[len(s.DuplicateEnvelopes)-maxDuplicates:]
+	}
+}
+
+// Receive assigns the next sequence number to a message received from the peer. If the
+// envelope ID has been seen from the peer before, the duplicate is recorded and the
+// sequence number of the original message is returned as orig.
+func (s *Store) Receive(peer, envelopeID string) (seq, orig uint64, err error) {
+	s.seqmu.Lock()
+	defer s.seqmu.Unlock()
+
+	var state *Sequence
+	if state, err = s.getSequence(peer); err != nil {
+		return 0, 0, err
+	}
+
+	state.Received++
+	state.LastSeen = time.Now()
+	seq = state.Received
+
+	if envelopeID != "" {
+		idx := peer + "::" + envelopeID
+		if orig, err = s.lookupSeq(idx); err != nil {
+			return 0, 0, err
+		}
+
+		if orig > 0 {
+			state.addDuplicate(envelopeID, seq, orig)
+		} else if err = s.put(nsSeqIndex, idx, []byte(strconv.FormatUint(seq, 10))); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err = s.putSequence(state); err != nil {
+		return 0, 0, err
+	}
+	return seq, orig, nil
+}
+
+// Sent assigns the next sequence number to a message sent to the peer.
+func (s *Store) Sent(peer string) (seq uint64, err error) {
+	s.seqmu.Lock()
+	defer s.seqmu.Unlock()
+
+	var state *Sequence
+	if state, err = s.getSequence(peer); err != nil {
+		return 0, err
+	}
+
+	state.Sent++
+	state.LastSeen = time.Now()
+	if err = s.putSequence(state); err != nil {
+		return 0, err
+	}
+
+	if state.Sent%seqIndexCompaction == 0 {
+		if err = s.compactSeqIndex(state); err != nil {
+			return 0, err
+		}
+	}
+	return state.Sent, nil
+}
+
+// compactSeqIndex removes the envelopes of the peer from the sequence index that are
+// more than seqIndexWindow messages below the contiguous high-water mark.
+func (s *Store) compactSeqIndex(state *Sequence) (err error) {
+	mark := state.Sent
+	if state.Received < mark {
+		mark = state.Received
+	}
+	if mark <= seqIndexWindow {
+		return nil
+	}
+	below := mark - seqIndexWindow
+
+	iter := s.db.NewIterator(util.BytesPrefix(key(nsSeqIndex, state.Peer+"::")), nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		val := iter.Value()
+		if s.crypto != nil {
+			if val, err = s.crypto.decrypt(iter.Key(), val); err != nil {
+				return err
+			}
+		}
+
+		var seq uint64
+		if seq, err = strconv.ParseUint(string(val), 10, 64); err != nil {
+			return err
+		}
+		if seq <= below {
+			batch.Delete(append([]byte(nil), iter.Key()...))
+		}
+	}
+	if err = iter.Error(); err != nil {
+		return err
+	}
+	return s.db.Write(batch, nil)
+}
+
+// StreamOpened records that a new stream was opened with the peer, returning a gap if
+// messages were left unanswered by a previous stream, e.g. after a reconnect.
+func (s *Store) StreamOpened(peer string) (gap *Gap, err error) {
+	s.seqmu.Lock()
+	defer s.seqmu.Unlock()
+
+	var state *Sequence
+	if state, err = s.getSequence(peer); err != nil {
+		return nil, err
+	}
+
+	state.Streams++
+	gap = s.checkGap(state, "unanswered before reconnect")
+	if err = s.putSequence(state); err != nil {
+		return nil, err
+	}
+	return gap, nil
+}
+
+// StreamClosed records the end of a stream, returning a gap if any received messages
+// were not answered before the stream was closed.
+func (s *Store) StreamClosed(peer string) (gap *Gap, err error) {
+	s.seqmu.Lock()
+	defer s.seqmu.Unlock()
+
+	var state *Sequence
+	if state, err = s.getSequence(peer); err != nil {
+		return nil, err
+	}
+
+	gap = s.checkGap(state, "unanswered when stream closed")
+	if err = s.putSequence(state); err != nil {
+		return nil, err
+	}
+	return gap, nil
+}
+
+// checkGap records outstanding messages as a gap then resynchronizes the sequences so
+// that the same gap is not reported more than once.
+func (s *Store) checkGap(state *Sequence, reason string) *Gap {
+	if state.Outstanding() == 0 {
+		return nil
+	}
+
+	gap := state.addGap(state.Sent+1, state.Received, reason)
+	state.Sent = state.Received
+	return &gap
+}
+
+// Sequences returns the sequence state of every peer for a reconciliation report.
+func (s *Store) Sequences() (report []*Sequence, err error) {
+	report = make([]*Sequence, 0)
+	err = s.iter(nsSequences, func(_ string, val []byte) error {
+		state := &Sequence{}
+		if err := json.Unmarshal(val, state); err != nil {
+			return err
+		}
+		report = append(report, state)
+		return nil
+	})
+	return report, err
+}
+
+// lookupSeq returns the sequence number of a previously seen envelope or 0 if unseen.
+func (s *Store) lookupSeq(idx string) (seq uint64, err error) {
+	var val []byte
+	if val, err = s.get(nsSeqIndex, idx); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseUint(string(val), 10, 64)
+}
+
+func (s *Store) getSequence(peer string) (state *Sequence, err error) {
+	var val []byte
+	if val, err = s.get(nsSequences, peer); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return &Sequence{Peer: peer}, nil
+		}
+		return nil, err
+	}
+
+	state = &Sequence{}
+	if err = json.Unmarshal(val, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *Store) putSequence(state *Sequence) (err error) {
+	var val []byte
+	if val, err = json.Marshal(state); err != nil {
+		return err
+	}
+	return s.put(nsSequences, state.Peer, val)
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rotationalio/trisa/pkg/config"
+)
+
+func TestDuplicatesAreNotGaps(t *testing.T) {
+	db, err := Open(config.StorageConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, _, err = db.Receive("peer", "envelope"); err != nil {
+		t.Fatal(err)
+	}
+	seq, orig, err := db.Receive("peer", "envelope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 2 || orig != 1 {
+		t.Fatalf("expected duplicate of message 1 as message 2, got %d of %d", seq, orig)
+	}
+
+	state, err := db.getSequence("peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Gaps) != 0 {
+		t.Errorf("duplicate was recorded as a gap: %+v", state.Gaps)
+	}
+	if state.Duplicates != 1 || len(state.DuplicateEnvelopes) != 1 || state.DuplicateEnvelopes[0].EnvelopeID != "envelope" {
+		t.Errorf("duplicate was not recorded: %+v", state)
+	}
+}
+
+func TestCompactSeqIndex(t *testing.T) {
+	db, err := Open(config.StorageConfig{Passphrase: "sequences"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	n := seqIndexWindow + 2*seqIndexCompaction
+	for i := 1; i <= n; i++ {
+		if _, _, err = db.Receive("peer", fmt.Sprintf("envelope-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.Sent("peer"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var indexed int
+	if err = db.iter(nsSeqIndex, func(string, []byte) error { indexed++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if indexed != seqIndexWindow {
+		t.Errorf("expected %d indexed envelopes after compaction, got %d", seqIndexWindow, indexed)
+	}
+
+	// Envelopes within the window are still recognized as duplicates
+	if _, orig, err := db.Receive("peer", fmt.Sprintf("envelope-%d", n)); err != nil || orig != uint64(n) {
+		t.Errorf("expected recent envelope to be a duplicate of %d, got %d: %v", n, orig, err)
+	}
+	if _, orig, err := db.Receive("peer", "envelope-1"); err != nil || orig != 0 {
+		t.Errorf("expected compacted envelope to be forgotten, got %d: %v", orig, err)
+	}
+}
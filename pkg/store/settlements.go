@@ -0,0 +1,90 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// SettlementStatus is the on-chain status of the transaction of a Travel Rule exchange.
+type SettlementStatus string
+
+// Statuses of a settlement. A settlement is unverified until a chain-data provider has
+// been asked about it; it is pending while it has fewer confirmations than required or
+// has not been mined yet, and not found if the network does not know the transaction
+// (yet, since Travel Rule messages are usually exchanged before the transaction is
+// broadcast). Confirmed settlements and settlements whose amount does not match the
+// amount of the Travel Rule message are final.
+const (
+	SettlementUnverified SettlementStatus = "unverified"
+	SettlementPending    SettlementStatus = "pending"
+	SettlementConfirmed  SettlementStatus = "confirmed"
+	SettlementNotFound   SettlementStatus = "not_found"
+	SettlementMismatch   SettlementStatus = "mismatch"
+)
+
+// Valid returns true if the status is one of the settlement statuses.
+func (s SettlementStatus) Valid() bool {
+	switch s {
+	case SettlementUnverified, SettlementPending, SettlementConfirmed, SettlementNotFound, SettlementMismatch:
+		return true
+	}
+	return false
+}
+
+// Final returns true if the settlement does not have to be verified again.
+func (s SettlementStatus) Final() bool {
+	return s == SettlementConfirmed || s == SettlementMismatch
+}
+
+// Settlement annotates a transaction with the on-chain status of the transfer of the
+// asset for reconciliation: the network, ID, and amount of the transaction in the Travel
+// Rule message, and the confirmations and amount of the transaction on chain when it was
+// last checked. Error is the error of the last check if the provider could not be asked.
+type Settlement struct {
+	Network       string           `json:"network"`
+	TxID          string           `json:"txid"`
+	Amount        float64          `json:"amount,omitempty"`
+	Status        SettlementStatus `json:"status"`
+	Confirmations int              `json:"confirmations,omitempty"`
+	ChainAmount   float64          `json:"chain_amount,omitempty"`
+	Checks        int              `json:"checks,omitempty"`
+	Recorded      time.Time        `json:"recorded"`
+	Checked       time.Time        `json:"checked"`
+	Error         string           `json:"error,omitempty"`
+}
+
+// SetSettlement annotates the transaction with the envelope ID with the settlement. The
+// state and the update time of the transaction are not changed, since the settlement
+// happens outside of the Travel Rule exchange.
+func (s *Store) SetSettlement(envelopeID string, settlement *Settlement) (tx *Transaction, err error) {
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
+
+	if tx, err = s.GetTransaction(envelopeID); err != nil {
+		return nil, err
+	}
+
+	tx.Settlement = settlement
+	if err = s.putTransaction(tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// UnsettledTransactions returns the transactions with settlements that are not final
+// and that were recorded after the specified time.
+func (s *Store) UnsettledTransactions(since time.Time) (txns []*Transaction, err error) {
+	txns = make([]*Transaction, 0)
+	err = s.iter(nsTransactions, func(_ string, val []byte) error {
+		tx := &Transaction{}
+		if err := json.Unmarshal(val, tx); err != nil {
+			return err
+		}
+
+		if tx.Settlement != nil && !tx.Settlement.Status.Final() && tx.Settlement.Recorded.After(since) {
+			txns = append(txns, tx)
+		}
+		return nil
+	})
+	return txns, err
+}
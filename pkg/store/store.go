@@ -0,0 +1,167 @@
+/*
+Package store persists the local state of the Rotational TRISA node, e.g. envelopes,
+peer keys, and transaction state, in an embedded leveldb database. Each kind of record
+is stored in its own namespace, identified by a key prefix.
+*/
+package store
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Store errors
+var (
+	ErrNotFound = errors.New("record not found")
+	ErrNotEmpty = errors.New("cannot restore into a non-empty store")
+)
+
+// Store wraps the leveldb database that holds the local state of the TRISA node.
+type Store struct {
+	db       *leveldb.DB
+	path     string
+	crypto   *encryption
+	payloads *encryption
+	fields   *encryption
+	sealed   map[string]bool
+	seqmu    sync.Mutex
+	txmu     sync.Mutex
+	quotamu  sync.Mutex
+}
+
+// The meta namespace holds unencrypted records about the store itself.
+const nsMeta = "meta"
+
+// Open the store at the configured path. If no path is configured, an in-memory store
+// is opened, which is useful for development and testing but is not durable. If an
+// encryption key or passphrase is configured, the values of records are encrypted.
+func Open(conf config.StorageConfig) (s *Store, err error) {
+	return open(conf, nil)
+}
+
+// OpenExisting opens the store at the configured path like Open but returns an error
+// rather than creating an empty database if there is no store at the path, e.g. so
+// that administrative commands do not back up an empty store from a mistyped path.
+func OpenExisting(conf config.StorageConfig) (s *Store, err error) {
+	if conf.Path == "" {
+		return nil, errors.New("the path to the store is required")
+	}
+	return open(conf, &opt.Options{ErrorIfMissing: true})
+}
+
+func open(conf config.StorageConfig, opts *opt.Options) (s *Store, err error) {
+	s = &Store{path: conf.Path}
+	if conf.Path == "" {
+		if s.db, err = leveldb.Open(storage.NewMemStorage(), nil); err != nil {
+			return nil, err
+		}
+	} else {
+		if s.db, err = leveldb.OpenFile(conf.Path, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = s.setupEncryption(conf); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+
+	if err = s.setupPayloadKeys(conf); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+
+	if err = s.setupFieldKeys(conf); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close the store, flushing any pending writes to disk.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Path returns the location of the store on disk (empty if the store is in-memory).
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Empty returns true if there are no records in the store other than metadata.
+func (s *Store) Empty() bool {
+	iter := s.db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if !bytes.HasPrefix(iter.Key(), []byte(nsMeta+"::")) {
+			return false
+		}
+	}
+	return true
+}
+
+// Namespaced keys
+func key(namespace, id string) []byte {
+	return []byte(namespace + "::" + id)
+}
+
+func prefix(namespace string) *util.Range {
+	return util.BytesPrefix([]byte(namespace + "::"))
+}
+
+func (s *Store) get(namespace, id string) (val []byte, err error) {
+	k := key(namespace, id)
+	if val, err = s.db.Get(k, nil); err != nil {
+		if errors.Is(err, leveldb.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if s.crypto != nil {
+		return s.crypto.decrypt(k, val)
+	}
+	return val, nil
+}
+
+func (s *Store) put(namespace, id string, val []byte) (err error) {
+	k := key(namespace, id)
+	if s.crypto != nil {
+		if val, err = s.crypto.encrypt(k, val); err != nil {
+			return err
+		}
+	}
+	return s.db.Put(k, val, nil)
+}
+
+func (s *Store) delete(namespace, id string) error {
+	return s.db.Delete(key(namespace, id), nil)
+}
+
+// iter calls fn for every record in the namespace until fn returns an error.
+func (s *Store) iter(namespace string, fn func(id string, val []byte) error) (err error) {
+	iter := s.db.NewIterator(prefix(namespace), nil)
+	defer iter.Release()
+
+	skip := len(namespace) + 2
+	for iter.Next() {
+		val := iter.Value()
+		if s.crypto != nil {
+			if val, err = s.crypto.decrypt(iter.Key(), val); err != nil {
+				return err
+			}
+		}
+
+		if err = fn(string(iter.Key()[skip:]), val); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
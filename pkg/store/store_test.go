@@ -0,0 +1,48 @@
+package store
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/rotationalio/trisa/pkg/config"
+)
+
+func TestOpenExisting(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenExisting(config.StorageConfig{Path: filepath.Join(dir, "missing")}); err == nil {
+		t.Fatal("expected a missing store to fail to open")
+	}
+
+	db, err := Open(config.StorageConfig{Path: filepath.Join(dir, "db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.put("peers", "peer.example.com", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if db, err = OpenExisting(config.StorageConfig{Path: filepath.Join(dir, "db")}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var archive bytes.Buffer
+	if n, err := db.Backup(&archive, "passphrase"); err != nil || n == 0 {
+		t.Fatalf("could not back up existing store: %d records, %v", n, err)
+	}
+
+	restored, err := Open(config.StorageConfig{Path: filepath.Join(dir, "restored")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	if _, err = restored.Restore(&archive, "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = restored.get("peers", "peer.example.com"); err != nil {
+		t.Fatal(err)
+	}
+}
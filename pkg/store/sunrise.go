@@ -0,0 +1,64 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const nsSunrise = "sunrise"
+
+// Sunrise is an outgoing transfer that was sent to a VASP that is not yet in the TRISA
+// directory with a secure link that was emailed to the compliance contact of the VASP.
+// The record is identified by the hash of the link token and the payload is encrypted
+// with the token, so the payload can only be read with the link. Viewed and
+// Acknowledged are zero until the counterparty opens the link and acknowledges the
+// transfer.
+type Sunrise struct {
+	ID           string    `json:"id"`
+	EnvelopeID   string    `json:"envelope_id"`
+	Counterparty string    `json:"counterparty"`
+	Email        string    `json:"email"`
+	Payload      []byte    `json:"payload,omitempty"`
+	Created      time.Time `json:"created"`
+	Expires      time.Time `json:"expires"`
+	Viewed       time.Time `json:"viewed"`
+	Acknowledged time.Time `json:"acknowledged"`
+}
+
+// GetSunrise returns the sunrise message with the ID.
+func (s *Store) GetSunrise(id string) (msg *Sunrise, err error) {
+	var val []byte
+	if val, err = s.get(nsSunrise, id); err != nil {
+		return nil, err
+	}
+
+	msg = &Sunrise{}
+	if err = json.Unmarshal(val, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// PutSunrise creates or updates the sunrise message.
+func (s *Store) PutSunrise(msg *Sunrise) (err error) {
+	var val []byte
+	if val, err = json.Marshal(msg); err != nil {
+		return err
+	}
+	return s.put(nsSunrise, msg.ID, val)
+}
+
+// SunriseMessages returns the sunrise messages without their encrypted payloads.
+func (s *Store) SunriseMessages() (msgs []*Sunrise, err error) {
+	msgs = make([]*Sunrise, 0)
+	err = s.iter(nsSunrise, func(_ string, val []byte) error {
+		msg := &Sunrise{}
+		if err := json.Unmarshal(val, msg); err != nil {
+			return err
+		}
+		msg.Payload = nil
+		msgs = append(msgs, msg)
+		return nil
+	})
+	return msgs, err
+}
@@ -0,0 +1,243 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const nsTransactions = "transactions"
+
+// State of a Travel Rule exchange with a counterparty.
+type State string
+
+// States of a transaction. A transaction is received when the first secure envelope of
+// the exchange arrives and ends when it is rejected, completed, or expires; completed
+// transactions are closed once a final acknowledgment has been exchanged with the peer.
+const (
+	Received             State = "received"
+	PendingReview        State = "pending_review"
+	AwaitingCounterparty State = "awaiting_counterparty"
+	Approved             State = "approved"
+	Rejected             State = "rejected"
+	Completed            State = "completed"
+	Expired              State = "expired"
+	Closed               State = "closed"
+)
+
+// ErrInvalidTransition is returned if a transaction cannot move to the requested state.
+var ErrInvalidTransition = errors.New("invalid transaction state transition")
+
+// transitions are the states each state can move to; rejected, expired, and closed
+// transactions cannot move to any other state and completed transactions can only be
+// closed.
+var transitions = map[State][]State{
+	Received:             {PendingReview, AwaitingCounterparty, Approved, Rejected, Expired},
+	PendingReview:        {AwaitingCounterparty, Approved, Rejected, Expired},
+	AwaitingCounterparty: {PendingReview, Approved, Rejected, Expired},
+	Approved:             {Completed, Rejected, Expired},
+	Completed:            {Closed},
+}
+
+// Valid returns true if the state is one of the transaction states.
+func (s State) Valid() bool {
+	switch s {
+	case Received, PendingReview, AwaitingCounterparty, Approved, Rejected, Completed, Expired, Closed:
+		return true
+	}
+	return false
+}
+
+// Final returns true if the exchange has ended, i.e. the transaction cannot move to any
+// other state or it is completed and can only be closed by a final acknowledgment.
+func (s State) Final() bool {
+	return s == Completed || (s.Valid() && len(transitions[s]) == 0)
+}
+
+// CanTransition returns true if a transaction in the state can move to the next state.
+func (s State) CanTransition(next State) bool {
+	for _, state := range transitions[s] {
+		if state == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Transaction tracks the state of a Travel Rule exchange with a counterparty, which is
+// identified by the envelope ID that all of the messages of the exchange share, so that
+// exchanges that span several messages, e.g. retries after a review, have a coherent
+// state. Every change of state is kept in the history of the transaction, and the
+// settlement of the transaction on chain is recorded if it is verified.
+type Transaction struct {
+	EnvelopeID string       `json:"envelope_id"`
+	Peer       string       `json:"peer"`
+	State      State        `json:"state"`
+	Created    time.Time    `json:"created"`
+	Updated    time.Time    `json:"updated"`
+	History    []Transition `json:"history,omitempty"`
+	Settlement *Settlement  `json:"settlement,omitempty"`
+}
+
+// Transition records a change of the state of a transaction.
+type Transition struct {
+	From   State     `json:"from"`
+	To     State     `json:"to"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// GetTransaction returns the transaction with the envelope ID.
+func (s *Store) GetTransaction(envelopeID string) (tx *Transaction, err error) {
+	var val []byte
+	if val, err = s.get(nsTransactions, envelopeID); err != nil {
+		return nil, err
+	}
+
+	tx = &Transaction{}
+	if err = json.Unmarshal(val, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// ReceiveTransaction returns the transaction with the envelope ID, creating it in the
+// received state if this is the first message of the exchange with the peer.
+func (s *Store) ReceiveTransaction(envelopeID, peer string) (tx *Transaction, err error) {
+	if envelopeID == "" {
+		return nil, errors.New("envelope id is required for all transactions")
+	}
+
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
+
+	if tx, err = s.GetTransaction(envelopeID); err == nil || !errors.Is(err, ErrNotFound) {
+		return tx, err
+	}
+
+	now := time.Now()
+	tx = &Transaction{EnvelopeID: envelopeID, Peer: peer, State: Received, Created: now, Updated: now}
+	if err = s.putTransaction(tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// TransitionTransaction moves the transaction with the envelope ID to the next state,
+// returning ErrInvalidTransition if the transaction cannot move to the state.
+func (s *Store) TransitionTransaction(envelopeID string, next State, reason string) (tx *Transaction, err error) {
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
+
+	if tx, err = s.GetTransaction(envelopeID); err != nil {
+		return nil, err
+	}
+
+	if err = tx.transition(next, reason, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if err = s.putTransaction(tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// ExpireTransactions moves the transactions that have not been updated since the
+// specified time and that are not final to the expired state, returning the number of
+// transactions that expired.
+func (s *Store) ExpireTransactions(before time.Time) (expired int, err error) {
+	var txns []*Transaction
+	txns, err = s.ExpireStaleTransactions(before)
+	return len(txns), err
+}
+
+// ExpireStaleTransactions expires the transactions like ExpireTransactions but returns
+// the transactions that expired, e.g. to notify other systems about them. If an error
+// occurs, the transactions that expired before the error are returned with it.
+func (s *Store) ExpireStaleTransactions(before time.Time) (expired []*Transaction, err error) {
+	return s.expire("no activity", func(tx *Transaction) bool {
+		return tx.Updated.Before(before)
+	})
+}
+
+// ExpirePendingTransactions expires the transactions that have been pending review or
+// awaiting the counterparty since the specified time, returning the transactions that
+// expired like ExpireStaleTransactions.
+func (s *Store) ExpirePendingTransactions(before time.Time) (expired []*Transaction, err error) {
+	return s.expire("no decision within the pending timeout", func(tx *Transaction) bool {
+		return (tx.State == PendingReview || tx.State == AwaitingCounterparty) && tx.Updated.Before(before)
+	})
+}
+
+// expire moves the transactions that are not final and that match to the expired state.
+func (s *Store) expire(reason string, match func(*Transaction) bool) (expired []*Transaction, err error) {
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
+
+	var stale []*Transaction
+	if err = s.iter(nsTransactions, func(_ string, val []byte) error {
+		tx := &Transaction{}
+		if err := json.Unmarshal(val, tx); err != nil {
+			return err
+		}
+
+		if !tx.State.Final() && match(tx) {
+			stale = append(stale, tx)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, tx := range stale {
+		if err = tx.transition(Expired, reason, now); err != nil {
+			return expired, err
+		}
+
+		if err = s.putTransaction(tx); err != nil {
+			return expired, err
+		}
+		expired = append(expired, tx)
+	}
+	return expired, nil
+}
+
+// Transactions returns the transactions in the state, or all of the transactions if
+// the state is empty.
+func (s *Store) Transactions(state State) (txns []*Transaction, err error) {
+	txns = make([]*Transaction, 0)
+	err = s.iter(nsTransactions, func(_ string, val []byte) error {
+		tx := &Transaction{}
+		if err := json.Unmarshal(val, tx); err != nil {
+			return err
+		}
+
+		if state == "" || tx.State == state {
+			txns = append(txns, tx)
+		}
+		return nil
+	})
+	return txns, err
+}
+
+func (tx *Transaction) transition(next State, reason string, now time.Time) error {
+	if !tx.State.CanTransition(next) {
+		return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, tx.State, next)
+	}
+
+	tx.History = append(tx.History, Transition{From: tx.State, To: next, Reason: reason, Time: now})
+	tx.State = next
+	tx.Updated = now
+	return nil
+}
+
+func (s *Store) putTransaction(tx *Transaction) (err error) {
+	var val []byte
+	if val, err = json.Marshal(tx); err != nil {
+		return err
+	}
+	return s.put(nsTransactions, tx.EnvelopeID, val)
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const nsDeadLetters = "dead_letters"
+
+// DeadLetter is the record of a webhook event that could not be delivered to an
+// endpoint, with the number of delivery attempts and the error of the last attempt, so
+// that operators can find out which notifications their systems missed. The event is
+// kept as it would have been sent.
+type DeadLetter struct {
+	Time     time.Time       `json:"time"`
+	URL      string          `json:"url"`
+	EventID  string          `json:"event_id"`
+	Event    json.RawMessage `json:"event"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// deadLetterKey orders the dead letters by time; an event can be dead-lettered for
+// several endpoints at the same time, so the key includes the event ID and the URL.
+func deadLetterKey(d *DeadLetter) string {
+	return fmt.Sprintf("%020d:%s:%s", d.Time.UnixNano(), d.EventID, d.URL)
+}
+
+// PutDeadLetter appends the undelivered webhook event to the dead-letter log.
+func (s *Store) PutDeadLetter(d *DeadLetter) (err error) {
+	if d.Time.IsZero() {
+		d.Time = time.Now()
+	}
+
+	var val []byte
+	if val, err = json.Marshal(d); err != nil {
+		return err
+	}
+	return s.put(nsDeadLetters, deadLetterKey(d), val)
+}
+
+// DeadLetters returns the undelivered webhook events since the specified time, oldest
+// first; a zero time returns all of the dead letters.
+func (s *Store) DeadLetters(since time.Time) (letters []*DeadLetter, err error) {
+	letters = make([]*DeadLetter, 0)
+	err = s.iter(nsDeadLetters, func(_ string, val []byte) error {
+		d := &DeadLetter{}
+		if err := json.Unmarshal(val, d); err != nil {
+			return err
+		}
+
+		if !d.Time.Before(since) {
+			letters = append(letters, d)
+		}
+		return nil
+	})
+	return letters, err
+}
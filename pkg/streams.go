@@ -0,0 +1,238 @@
+package trisarl
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+	"google.golang.org/grpc/metadata"
+)
+
+// The gRPC trailer keys of the summary of a transfer stream, which is sent to the peer
+// when the stream is closed so that peers sending batches of envelopes can check that
+// every envelope they sent was received and answered: the number of messages received
+// on the stream, and how many of them were accepted or rejected with a TRISA error.
+const (
+	StreamReceivedKey = "x-stream-received"
+	StreamAcceptedKey = "x-stream-accepted"
+	StreamRejectedKey = "x-stream-rejected"
+)
+
+// received is a message, or the error that closed the stream, received from a peer.
+type received struct {
+	in  *protocol.SecureEnvelope
+	err error
+}
+
+// openStream reserves one of the open transfer streams allowed by the limits, returning
+// a retryable error if the server or the peer already has the maximum number of open
+// streams. The stream must be released with closeStream when it is closed.
+func (s *Server) openStream(peer *peers.Peer, conf config.StreamsConfig) error {
+	commonName := peer.String()
+
+	s.streammu.Lock()
+	defer s.streammu.Unlock()
+	if s.streams == nil {
+		s.streams = make(map[string]int)
+	}
+
+	var reason string
+	switch {
+	case conf.MaxOpen > 0 && s.nstreams >= conf.MaxOpen:
+		reason = fmt.Sprintf("the server has the maximum of %d open transfer streams", conf.MaxOpen)
+	case conf.MaxPerPeer > 0 && s.streams[commonName] >= conf.MaxPerPeer:
+		reason = fmt.Sprintf("the maximum of %d open transfer streams per peer has been reached", conf.MaxPerPeer)
+	}
+
+	if reason != "" {
+		log.Warn().Str("peer", commonName).Int("open_streams", s.nstreams).Int("peer_streams", s.streams[commonName]).Msg("transfer stream limit exceeded")
+		return &protocol.Error{
+			Code:    protocol.Unavailable,
+			Message: reason + ", please retry later",
+			Retry:   true,
+		}
+	}
+
+	s.nstreams++
+	s.streams[commonName]++
+	return nil
+}
+
+// closeStream releases the open stream reserved by openStream.
+func (s *Server) closeStream(peer *peers.Peer) {
+	commonName := peer.String()
+
+	s.streammu.Lock()
+	defer s.streammu.Unlock()
+	s.nstreams--
+	if s.streams[commonName]--; s.streams[commonName] <= 0 {
+		delete(s.streams, commonName)
+	}
+}
+
+// OpenStreams returns the number of transfer streams that are currently open.
+func (s *Server) OpenStreams() int {
+	s.streammu.Lock()
+	defer s.streammu.Unlock()
+	return s.nstreams
+}
+
+// StreamUnorderedKey is the gRPC metadata key that peers set to "true" when they open
+// a transfer stream if they correlate the responses with their messages by envelope ID,
+// in which case the responses to messages that are handled concurrently are sent as
+// soon as they are ready rather than in the order of the messages.
+const StreamUnorderedKey = "x-stream-unordered"
+
+// streamUnordered returns true if the peer does not require the responses on the
+// stream to be sent in the order of its messages.
+func streamUnordered(ctx context.Context) bool {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(StreamUnorderedKey); len(vals) > 0 {
+			unordered, _ := strconv.ParseBool(vals[0])
+			return unordered
+		}
+	}
+	return false
+}
+
+// streamJob is a message on a transfer stream that is handed to a worker with its
+// sequence number on the stream. The response is sent once prev is closed, and next is
+// closed once the message has been answered so that the response to the following
+// message can be sent.
+type streamJob struct {
+	in   *protocol.SecureEnvelope
+	seq  uint64
+	prev <-chan struct{}
+	next chan struct{}
+}
+
+// streamPool is the pool of workers that decrypt and handle the messages of a transfer
+// stream. Each worker handles a message at a time; if the responses are in order, the
+// response to each message is sent after the response to the previous message,
+// otherwise responses are sent as soon as they are ready.
+type streamPool struct {
+	jobs    chan *streamJob
+	turn    <-chan struct{}
+	inOrder bool
+	workers sync.WaitGroup
+}
+
+// newStreamPool starts n workers that call handle with each job and a function that
+// sends its response with send in turn, then call answered once the message has been
+// answered. The workers stop when the pool is stopped or the context is canceled.
+func newStreamPool(ctx context.Context, n int, inOrder bool, send func(*protocol.SecureEnvelope) error, handle func(*streamJob, func(*protocol.SecureEnvelope) error), answered func()) *streamPool {
+	turn := make(chan struct{})
+	close(turn)
+
+	p := &streamPool{jobs: make(chan *streamJob), turn: turn, inOrder: inOrder}
+	for i := 0; i < n; i++ {
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for job := range p.jobs {
+				handle(job, ordered(ctx, job.prev, send))
+				close(job.next)
+				answered()
+			}
+		}()
+	}
+	return p
+}
+
+// next creates the job of the next message on the stream. Jobs must be handed to the
+// workers in the order they were created.
+func (p *streamPool) next(in *protocol.SecureEnvelope, seq uint64) *streamJob {
+	job := &streamJob{in: in, seq: seq, prev: p.turn, next: make(chan struct{})}
+	if p.inOrder {
+		p.turn = job.next
+	}
+	return job
+}
+
+// stop stops the workers once they have handled the jobs they were handed.
+func (p *streamPool) stop() {
+	close(p.jobs)
+}
+
+// wait waits for the workers to stop.
+func (p *streamPool) wait() {
+	p.workers.Wait()
+}
+
+// streamSummary counts the messages received on a transfer stream and their responses.
+// Responses are counted concurrently if the concurrent streams feature is enabled.
+type streamSummary struct {
+	received uint64
+	accepted uint64
+	rejected uint64
+}
+
+// answered counts the response to a message as accepted or rejected.
+func (s *streamSummary) answered(out *protocol.SecureEnvelope) {
+	if out.Error != nil && out.Error.Code != 0 {
+		atomic.AddUint64(&s.rejected, 1)
+		return
+	}
+	atomic.AddUint64(&s.accepted, 1)
+}
+
+// trailer returns the summary as the gRPC trailer of the stream.
+func (s *streamSummary) trailer() metadata.MD {
+	return metadata.Pairs(
+		StreamReceivedKey, strconv.FormatUint(atomic.LoadUint64(&s.received), 10),
+		StreamAcceptedKey, strconv.FormatUint(atomic.LoadUint64(&s.accepted), 10),
+		StreamRejectedKey, strconv.FormatUint(atomic.LoadUint64(&s.rejected), 10),
+	)
+}
+
+// ordered returns a send function that waits until the response to the previous
+// message has been sent, i.e. until prev is closed, so that the responses on a stream
+// are sent in the order of the messages even if they are handled concurrently.
+func ordered(ctx context.Context, prev <-chan struct{}, send func(*protocol.SecureEnvelope) error) func(*protocol.SecureEnvelope) error {
+	return func(out *protocol.SecureEnvelope) error {
+		select {
+		case <-prev:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return send(out)
+	}
+}
+
+// streamTransfer handles a single message on a transfer stream and sends the response.
+// TRISA errors are sent to the peer in the response envelope without closing the
+// stream; any other error, including a failure to send, closes the stream.
+func (s *Server) streamTransfer(ctx context.Context, peer *peers.Peer, in *protocol.SecureEnvelope, nmessages uint64, summary *streamSummary, send func(*protocol.SecureEnvelope) error) (err error) {
+	seq := s.received(peer, in.Id)
+
+	var out *protocol.SecureEnvelope
+	if out, err = s.handleTransaction(ctx, peer, in); err != nil {
+		// Do not close the stream for TRISA coded errors, send the error in the secure
+		// envelope with the ID of the envelope so that the peer can tell which it rejected
+		switch trisaErr := err.(type) {
+		case *protocol.Error:
+			s.metrics.observeError(peer.String(), "TransferStream", trisaErr)
+			out = &protocol.SecureEnvelope{Id: in.Id, Error: annotateError(RequestID(ctx), trisaErr)}
+		default:
+			return err
+		}
+	}
+
+	// Send the response
+	if err = send(out); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("transfer stream send error")
+		return protocol.Errorf(protocol.Unavailable, "stream closed prematurely: %s", err)
+	}
+	s.sent(peer)
+	summary.answered(out)
+
+	// Log the message
+	log.Ctx(ctx).Info().Str("peer", peer.String()).Str("id", in.Id).Uint64("seq", seq).Uint64("n_messages", nmessages).Msg("streaming transfer request received")
+	return nil
+}
@@ -0,0 +1,157 @@
+package trisarl
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestStreamUnordered(t *testing.T) {
+	tests := []struct {
+		value     string
+		unordered bool
+	}{
+		{"true", true},
+		{"1", true},
+		{"false", false},
+		{"", false},
+		{"bogus", false},
+	}
+
+	for _, tc := range tests {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(StreamUnorderedKey, tc.value))
+		if unordered := streamUnordered(ctx); unordered != tc.unordered {
+			t.Errorf("%q: expected unordered %t, got %t", tc.value, tc.unordered, unordered)
+		}
+	}
+
+	if streamUnordered(context.Background()) {
+		t.Error("expected responses to be in order without metadata")
+	}
+}
+
+func TestStreamPool(t *testing.T) {
+	tests := []struct {
+		name     string
+		workers  int
+		inOrder  bool
+		messages int
+	}{
+		{"one worker", 1, true, 5},
+		{"one worker unordered", 1, false, 5},
+		{"workers in order", 4, true, 12},
+		{"workers unordered", 4, false, 12},
+		{"more workers than messages", 8, true, 3},
+		{"more workers than messages unordered", 8, false, 3},
+	}
+
+	for _, tc := range tests {
+		var (
+			mu       sync.Mutex
+			sent     []string
+			running  int
+			max      int
+			answered sync.WaitGroup
+		)
+
+		send := func(out *protocol.SecureEnvelope) error {
+			mu.Lock()
+			defer mu.Unlock()
+			sent = append(sent, out.Id)
+			return nil
+		}
+
+		// Earlier messages take longer to handle so that later responses are ready first
+		handle := func(job *streamJob, send func(*protocol.SecureEnvelope) error) {
+			mu.Lock()
+			if running++; running > max {
+				max = running
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Duration(tc.messages-int(job.seq)) * 10 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+
+			if err := send(&protocol.SecureEnvelope{Id: job.in.Id}); err != nil {
+				t.Errorf("%s: could not send response: %s", tc.name, err)
+			}
+		}
+
+		pool := newStreamPool(context.Background(), tc.workers, tc.inOrder, send, handle, answered.Done)
+
+		ids := make([]string, 0, tc.messages)
+		for i := 1; i <= tc.messages; i++ {
+			ids = append(ids, strconv.Itoa(i))
+			answered.Add(1)
+			pool.jobs <- pool.next(&protocol.SecureEnvelope{Id: ids[i-1]}, uint64(i))
+		}
+		answered.Wait()
+		pool.stop()
+		pool.wait()
+
+		if max > tc.workers {
+			t.Errorf("%s: expected at most %d messages handled at a time, got %d", tc.name, tc.workers, max)
+		}
+
+		if tc.inOrder || tc.workers == 1 {
+			if strings.Join(sent, ",") != strings.Join(ids, ",") {
+				t.Errorf("%s: expected responses in order [%s], got [%s]", tc.name, strings.Join(ids, ","), strings.Join(sent, ","))
+			}
+			continue
+		}
+
+		if sent[0] == ids[0] {
+			t.Errorf("%s: expected the responses to be sent as soon as they are ready, got [%s]", tc.name, strings.Join(sent, ","))
+		}
+		sort.Strings(sent)
+		sort.Strings(ids)
+		if strings.Join(sent, ",") != strings.Join(ids, ",") {
+			t.Errorf("%s: expected a response to every message [%s], got [%s]", tc.name, strings.Join(ids, ","), strings.Join(sent, ","))
+		}
+	}
+}
+
+func TestStreamPoolCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// The first message is never answered, so the response to the second message
+	// cannot be sent in order until the stream is canceled.
+	block := make(chan struct{})
+	errs := make(chan error, 1)
+	handle := func(job *streamJob, send func(*protocol.SecureEnvelope) error) {
+		if job.seq == 1 {
+			<-block
+			return
+		}
+		errs <- send(&protocol.SecureEnvelope{Id: job.in.Id})
+	}
+
+	pool := newStreamPool(ctx, 2, true, func(*protocol.SecureEnvelope) error { return nil }, handle, func() {})
+	pool.jobs <- pool.next(&protocol.SecureEnvelope{Id: "1"}, 1)
+	pool.jobs <- pool.next(&protocol.SecureEnvelope{Id: "2"}, 2)
+
+	select {
+	case err := <-errs:
+		t.Fatalf("expected the response to wait for the previous message, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expected the send to be canceled, got %v", err)
+	}
+
+	close(block)
+	pool.stop()
+	pool.wait()
+}
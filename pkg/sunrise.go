@@ -0,0 +1,343 @@
+package trisarl
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/pending"
+	"github.com/rotationalio/trisa/pkg/secrets"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/handler"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Path prefix of the secure links served by the sunrise listener.
+const sunrisePath = "/sunrise/"
+
+// Size of the random token of the secure links, which is also the AES-256 key that the
+// payload is encrypted with.
+const sunriseTokenSize = 32
+
+// sunrise sends the envelope to the counterparty by email with a secure link and returns
+// a pending message that expires with the link, so that transfers to VASPs that have
+// not joined the TRISA network are not dropped. The payload is encrypted with the
+// random token of the link, which is never stored, so it can only be read with the
+// link.
+func (s *Server) sunrise(ctx context.Context, counterparty Counterparty, env *handler.Envelope) (reply *protocol.Payload, err error) {
+	if counterparty.Email == "" {
+		return nil, fmt.Errorf("%s is not in the TRISA directory and has no email for the sunrise fallback", counterparty.CommonName)
+	}
+
+	var email string
+	if email, err = validEmail(counterparty.Email); err != nil {
+		return nil, err
+	}
+
+	conf := s.config().Sunrise
+	token := make([]byte, sunriseTokenSize)
+	if _, err = rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if data, err = protojson.Marshal(env.Payload); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	msg := &store.Sunrise{
+		ID:           sunriseID(token),
+		EnvelopeID:   env.ID,
+		Counterparty: counterparty.CommonName,
+		Email:        email,
+		Created:      now,
+		Expires:      now.Add(conf.Expires),
+	}
+	if msg.Payload, err = sunriseSeal(token, data); err != nil {
+		return nil, err
+	}
+
+	if err = s.db.PutSunrise(msg); err != nil {
+		return nil, err
+	}
+
+	link := strings.TrimSuffix(conf.URL, "/") + sunrisePath + base64.RawURLEncoding.EncodeToString(token)
+	if err = s.sendSunriseEmail(ctx, conf, msg, link); err != nil {
+		return nil, fmt.Errorf("could not send sunrise email to %s: %s", email, err)
+	}
+
+	if tx := s.sentTransaction(ctx, env.ID, counterparty.CommonName); tx != nil {
+		s.transition(ctx, tx, store.AwaitingCounterparty, "sent by email with the sunrise fallback")
+	}
+	log.Ctx(ctx).Info().Str("counterparty", counterparty.CommonName).Str("id", env.ID).Time("expires", msg.Expires).Msg("transfer sent with the sunrise fallback")
+
+	answer := pending.New(env.ID, counterparty.CommonName, "sent by email to a VASP that is not in the TRISA directory", conf.Expires)
+	answer.ReplyNotAfter = msg.Expires
+	if reply, err = answer.Payload(nil); err != nil {
+		return nil, err
+	}
+	reply.Identity = env.Payload.Identity
+	return reply, nil
+}
+
+// sendSunriseEmail emails the secure link to the compliance contact of the counterparty.
+func (s *Server) sendSunriseEmail(ctx context.Context, conf config.SunriseConfig, msg *store.Sunrise, link string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, secrets.Timeout)
+	defer cancel()
+
+	var password string
+	if password, err = secrets.LoadString(ctx, conf.SMTPPassword); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if conf.SMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(conf.SMTPAddr)
+		auth = smtp.PlainAuth("", conf.SMTPUsername, password, host)
+	}
+
+	var from string
+	if from, err = validEmail(conf.From); err != nil {
+		return err
+	}
+
+	sender := s.commonName()
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, "From: %s\r\n", conf.From)
+	fmt.Fprintf(body, "To: %s\r\n", msg.Email)
+	fmt.Fprintf(body, "Subject: Travel Rule information from %s\r\n", sender)
+	fmt.Fprintf(body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(body, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(body, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(body, "%s has sent you the Travel Rule information of a virtual asset transfer (envelope %s).\r\n\r\n", sender, msg.EnvelopeID)
+	fmt.Fprintf(body, "Your VASP could not be found in the TRISA directory, so the information is available at the secure link below, where you can also acknowledge the transfer:\r\n\r\n")
+	fmt.Fprintf(body, "%s\r\n\r\n", link)
+	fmt.Fprintf(body, "The link expires on %s. Do not forward this email, anyone with the link can view the information.\r\n\r\n", msg.Expires.Format(time.RFC1123))
+	fmt.Fprintf(body, "To exchange Travel Rule information directly in the future, register with the TRISA directory at https://trisa.directory.\r\n")
+
+	return smtp.SendMail(conf.SMTPAddr, auth, from, []string{msg.Email}, body.Bytes())
+}
+
+// serveSunrise serves the secure links of the sunrise fallback on a separate listener,
+// which must be reachable by the counterparties at the configured URL. The listener
+// serves TLS with the configured certificate, or plain HTTP behind a TLS terminating
+// proxy if no certificate is configured.
+func (s *Server) serveSunrise(conf config.SunriseConfig) (err error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(sunrisePath, s.sunriseLink)
+
+	s.sunriseSrv = &http.Server{
+		Addr:              conf.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	if conf.TLSCertFile != "" {
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(conf.TLSCertFile, conf.TLSKeyFile); err != nil {
+			return fmt.Errorf("could not load sunrise tls certificate: %s", err)
+		}
+		s.sunriseSrv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	} else {
+		log.Warn().Str("listen", conf.Addr).Msg("sunrise server does not serve tls and must be behind a tls terminating proxy")
+	}
+
+	go func() {
+		log.Info().Str("listen", conf.Addr).Bool("tls", s.sunriseSrv.TLSConfig != nil).Msg("sunrise server started")
+
+		var err error
+		if s.sunriseSrv.TLSConfig != nil {
+			err = s.sunriseSrv.ListenAndServeTLS("", "")
+		} else {
+			err = s.sunriseSrv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("sunrise server stopped")
+		}
+	}()
+	return nil
+}
+
+// sunrisePage is rendered by the sunrise listener.
+type sunrisePage struct {
+	Sender       string
+	EnvelopeID   string
+	Expires      time.Time
+	Payload      string
+	Acknowledged time.Time
+	Error        string
+}
+
+var sunriseTemplate = template.Must(template.New("sunrise").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Travel Rule Information</title></head>
+<body>
+<h1>Travel Rule Information</h1>
+{{ if .Error }}<p>{{ .Error }}</p>{{ else }}
+<p>{{ .Sender }} has sent you the Travel Rule information of the transfer with envelope ID {{ .EnvelopeID }}. This link expires on {{ .Expires.Format "Jan 2, 2006 15:04 MST" }}.</p>
+<pre>{{ .Payload }}</pre>
+{{ if .Acknowledged.IsZero }}<form method="post"><button type="submit">Acknowledge Receipt</button></form>
+{{ else }}<p>Receipt acknowledged on {{ .Acknowledged.Format "Jan 2, 2006 15:04 MST" }}.</p>{{ end }}
+{{ end }}
+</body>
+</html>
+`))
+
+// sunriseLink shows the payload of the secure link (GET) or acknowledges the transfer
+// (POST).
+func (s *Server) sunriseLink(w http.ResponseWriter, r *http.Request) {
+	// The token is in the URL, so it must not leak to other sites or caches
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Frame-Options", "DENY")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeSunrise(w, http.StatusMethodNotAllowed, &sunrisePage{Error: "Method not allowed."})
+		return
+	}
+
+	token, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, sunrisePath))
+	if err != nil || len(token) != sunriseTokenSize {
+		writeSunrise(w, http.StatusNotFound, &sunrisePage{Error: "This link is not valid."})
+		return
+	}
+
+	var msg *store.Sunrise
+	if msg, err = s.db.GetSunrise(sunriseID(token)); err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Error().Err(err).Msg("could not get sunrise message")
+		}
+		writeSunrise(w, http.StatusNotFound, &sunrisePage{Error: "This link is not valid."})
+		return
+	}
+
+	if time.Now().After(msg.Expires) {
+		writeSunrise(w, http.StatusGone, &sunrisePage{Error: "This link has expired."})
+		return
+	}
+
+	var data []byte
+	if data, err = sunriseOpen(token, msg.Payload); err != nil {
+		log.Error().Err(err).Str("id", msg.EnvelopeID).Msg("could not decrypt sunrise message")
+		writeSunrise(w, http.StatusInternalServerError, &sunrisePage{Error: "The information could not be loaded."})
+		return
+	}
+
+	now := time.Now()
+	if msg.Viewed.IsZero() {
+		msg.Viewed = now
+		log.Info().Str("counterparty", msg.Counterparty).Str("id", msg.EnvelopeID).Msg("sunrise message viewed")
+	}
+
+	if r.Method == http.MethodPost && msg.Acknowledged.IsZero() {
+		msg.Acknowledged = now
+		if tx, err := s.db.GetTransaction(msg.EnvelopeID); err == nil {
+			ctx := log.Logger.WithContext(r.Context())
+			s.transition(ctx, tx, store.Approved, "")
+			s.transition(ctx, tx, store.Completed, "acknowledged with the sunrise fallback")
+		}
+		log.Info().Str("counterparty", msg.Counterparty).Str("id", msg.EnvelopeID).Msg("sunrise message acknowledged")
+	}
+
+	if err = s.db.PutSunrise(msg); err != nil {
+		log.Error().Err(err).Str("id", msg.EnvelopeID).Msg("could not update sunrise message")
+	}
+
+	pretty := &bytes.Buffer{}
+	if err = json.Indent(pretty, data, "", "  "); err != nil {
+		pretty = bytes.NewBuffer(data)
+	}
+
+	writeSunrise(w, http.StatusOK, &sunrisePage{
+		Sender:       s.commonName(),
+		EnvelopeID:   msg.EnvelopeID,
+		Expires:      msg.Expires,
+		Payload:      pretty.String(),
+		Acknowledged: msg.Acknowledged,
+	})
+}
+
+func writeSunrise(w http.ResponseWriter, code int, page *sunrisePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := sunriseTemplate.Execute(w, page); err != nil {
+		log.Warn().Err(err).Msg("could not write sunrise page")
+	}
+}
+
+// shutdownSunrise stops the sunrise HTTP listener if it was started.
+func (s *Server) shutdownSunrise() {
+	if s.sunriseSrv == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.sunriseSrv.Shutdown(ctx); err != nil {
+		log.Warn().Err(err).Msg("could not shutdown sunrise server")
+	}
+}
+
+// sunriseID returns the ID of the sunrise message of the token, so that the token is not
+// stored with the message.
+func sunriseID(token []byte) string {
+	sum := sha256.Sum256(token)
+	return hex.EncodeToString(sum[:])
+}
+
+// sunriseSeal encrypts the data with AES-256-GCM using the token as the key.
+func sunriseSeal(token, data []byte) (_ []byte, err error) {
+	var aead cipher.AEAD
+	if aead, err = sunriseCipher(token); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// sunriseOpen decrypts data encrypted by sunriseSeal.
+func sunriseOpen(token, data []byte) (_ []byte, err error) {
+	var aead cipher.AEAD
+	if aead, err = sunriseCipher(token); err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("sunrise payload is too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+func sunriseCipher(token []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(token)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,48 @@
+package trisarl
+
+import (
+	"time"
+
+	"github.com/rotationalio/trisa/internal/systemd"
+	"github.com/rs/zerolog/log"
+)
+
+// notify reports the state of the server to systemd if the server is run as a
+// Type=notify service; notifications are ignored otherwise.
+func notify(states ...string) {
+	for _, state := range states {
+		if _, err := systemd.Notify(state); err != nil {
+			log.Warn().Err(err).Str("state", state).Msg("could not notify systemd")
+			return
+		}
+	}
+}
+
+// watchdog sends keepalives to the systemd watchdog at half of the watchdog interval
+// until the server starts shutting down, after which systemd stops the watchdog and
+// the drain is bounded by the drain timeout instead.
+func (s *Server) watchdog() {
+	interval, err := systemd.WatchdogInterval()
+	if err != nil {
+		log.Warn().Err(err).Msg("could not determine systemd watchdog interval")
+		return
+	}
+
+	if interval == 0 {
+		return
+	}
+
+	log.Debug().Dur("interval", interval).Msg("systemd watchdog enabled")
+	ticker := time.NewTicker(interval / 2)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				notify(systemd.Watchdog)
+			case <-s.draining:
+				return
+			}
+		}
+	}()
+}
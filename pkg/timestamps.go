@@ -0,0 +1,116 @@
+package trisarl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// The payload in TRISA v1beta1 has no sent at or received at timestamps, so they are
+// kept in the extra JSON of generic transactions as RFC 3339 timestamps: originators
+// stamp sent_at when they send a transfer and beneficiaries stamp received_at on their
+// response.
+const (
+	fieldSentAt     = "sent_at"
+	fieldReceivedAt = "received_at"
+)
+
+// Check the sent_at timestamp of generic transfers: transfers with a malformed
+// timestamp are rejected, as are transfers without one if timestamps are required, and
+// transfers that were sent further from the current time than the maximum clock skew,
+// e.g. stale envelopes that are replayed long after they were sent. Beneficiary
+// inquiries, pending messages, and final acknowledgments are not checked.
+func (s *Server) timestamps(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		if t.Transaction == nil || t.Identity == nil {
+			return next(ctx, t)
+		}
+
+		conf := s.config().Timestamps
+		sentAt, ok, err := extraTimestamp(t.Transaction.ExtraJson, fieldSentAt)
+		switch {
+		case err != nil:
+			log.Ctx(ctx).Warn().Err(err).Str("peer", t.Peer.String()).Msg("malformed sent_at timestamp")
+			return protocol.Errorf(protocol.BadRequest, "%s, an RFC 3339 timestamp is required", err)
+		case !ok && conf.Required:
+			log.Ctx(ctx).Warn().Str("peer", t.Peer.String()).Msg("transfer without sent_at timestamp")
+			return protocol.Errorf(protocol.MissingFields, "a sent_at timestamp is required in the extra json of the transaction")
+		case !ok:
+			return next(ctx, t)
+		}
+
+		if skew := time.Since(sentAt); conf.MaxSkew > 0 && (skew > conf.MaxSkew || skew < -conf.MaxSkew) {
+			log.Ctx(ctx).Warn().Str("peer", t.Peer.String()).Time("sent_at", sentAt).Dur("skew", skew).Msg("transfer sent_at timestamp outside of the allowed clock skew")
+			return protocol.Errorf(protocol.BadRequest, "transfer sent at %s is outside of the allowed clock skew of %s", sentAt.Format(time.RFC3339), conf.MaxSkew)
+		}
+		return next(ctx, t)
+	}
+}
+
+// extraTimestamp returns the timestamp of the field in the extra JSON of a transaction,
+// and false if the extra JSON does not have the field.
+func extraTimestamp(extra, field string) (ts time.Time, ok bool, err error) {
+	fields := make(map[string]interface{})
+	if extra == "" || json.Unmarshal([]byte(extra), &fields) != nil {
+		return ts, false, nil
+	}
+
+	var val interface{}
+	if val, ok = fields[field]; !ok || val == nil {
+		return ts, false, nil
+	}
+
+	s, isString := val.(string)
+	if !isString {
+		return ts, true, fmt.Errorf("malformed %s timestamp", field)
+	}
+	if ts, err = time.Parse(time.RFC3339, s); err != nil {
+		return ts, true, fmt.Errorf("malformed %s timestamp %q", field, s)
+	}
+	return ts, true, nil
+}
+
+// withTimestamp sets the timestamp of the field in the extra JSON object of a
+// transaction, keeping the other fields of the object; extra JSON that is not an
+// object is replaced.
+func withTimestamp(extra, field string, ts time.Time) string {
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(extra), &fields); err != nil || fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields[field] = ts.Format(time.RFC3339)
+
+	data, _ := json.Marshal(fields)
+	return string(data)
+}
+
+// stampTransaction returns a copy of the payload whose generic transaction has the
+// timestamp of the field, which replaces the timestamp of the transaction if it has
+// one and replace is true. Payloads with other transaction types are returned as is.
+func stampTransaction(payload *protocol.Payload, field string, ts time.Time, replace bool) (_ *protocol.Payload, err error) {
+	if payload == nil || payload.Transaction.GetTypeUrl() != TypeTransaction {
+		return payload, nil
+	}
+
+	transaction := &generic.Transaction{}
+	if err = payload.Transaction.UnmarshalTo(transaction); err != nil {
+		return nil, err
+	}
+
+	if _, ok, _ := extraTimestamp(transaction.ExtraJson, field); ok && !replace {
+		return payload, nil
+	}
+	transaction.ExtraJson = withTimestamp(transaction.ExtraJson, field, ts)
+
+	stamped := &protocol.Payload{Identity: payload.Identity}
+	if stamped.Transaction, err = anypb.New(transaction); err != nil {
+		return nil, err
+	}
+	return stamped, nil
+}
@@ -0,0 +1,199 @@
+package trisarl
+
+import (
+	"context"
+	"time"
+
+	"github.com/rotationalio/trisa/internal/otlp"
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TracerName is the name of the instrumentation library of the spans of the server.
+const TracerName = "github.com/rotationalio/trisa/pkg"
+
+// Trace context is propagated from peers with the W3C traceparent metadata so that the
+// spans of the server are part of the trace of the peer's transfer if it is sampled.
+var propagator = propagation.TraceContext{}
+
+// setupTracing creates the tracer of the server, which does not record spans unless
+// tracing is enabled. The tracer provider of the server is not registered globally so
+// that it does not interfere with the tracing of an application that embeds the server.
+func (s *Server) setupTracing(conf config.TracingConfig) {
+	if !conf.Enabled {
+		s.tracer = trace.NewNoopTracerProvider().Tracer(TracerName)
+		return
+	}
+
+	s.tracing = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(otlp.New(conf.Endpoint)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(conf.ServiceName),
+			semconv.ServiceVersionKey.String(Version()),
+		)),
+	)
+	s.tracer = s.tracing.Tracer(TracerName, trace.WithInstrumentationVersion(Version()))
+	log.Info().Str("endpoint", conf.Endpoint).Float64("sample_ratio", conf.SampleRatio).Msg("tracing enabled")
+}
+
+// shutdownTracing flushes the spans that have not been exported yet.
+func (s *Server) shutdownTracing() {
+	if s.tracing == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.tracing.Shutdown(ctx); err != nil {
+		log.Warn().Err(err).Msg("could not flush traces")
+	}
+	s.tracing = nil
+}
+
+// unaryTracing creates a server span for every unary RPC.
+func (s *Server) unaryTracing(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (rep interface{}, err error) {
+	var span trace.Span
+	ctx, span = s.startRPC(ctx, info.FullMethod)
+	defer func() { endSpan(span, err) }()
+	return handler(ctx, req)
+}
+
+// streamTracing creates a server span for every streaming RPC; each message on a
+// transfer stream has its own transfer span as a child of the stream span.
+func (s *Server) streamTracing(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	ctx, span := s.startRPC(stream.Context(), info.FullMethod)
+	defer func() { endSpan(span, err) }()
+	return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+}
+
+// startRPC starts the server span of the RPC, continuing the trace of the peer if the
+// peer sent a trace context, and adds the trace ID to the request-scoped logger.
+func (s *Server) startRPC(ctx context.Context, method string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = propagator.Extract(ctx, metadataCarrier(md))
+
+	ctx, span := s.tracer.Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("grpc"),
+			semconv.RPCMethodKey.String(method),
+			attribute.String("trisarl.request_id", RequestID(ctx)),
+		),
+	)
+
+	if sc := span.SpanContext(); sc.IsSampled() {
+		logger := log.Ctx(ctx).With().Str("trace_id", sc.TraceID().String()).Logger()
+		ctx = logger.WithContext(ctx)
+	}
+	return ctx, span
+}
+
+// lookupPeer returns the verified peer of the RPC from the mTLS certificates of the
+// connection, which may require a lookup in the directory service. The peer is managed
+// by the identity that the peer connected to.
+func (s *Server) lookupPeer(ctx context.Context) (peer *peers.Peer, err error) {
+	var span trace.Span
+	ctx, span = s.tracer.Start(ctx, "peers.FromContext")
+	defer func() { endSpan(span, err) }()
+
+	if peer, err = s.networkFor(ctx).FromContext(ctx); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("trisarl.peer", peer.String()))
+	return peer, nil
+}
+
+// tracedHandler composes the stages of the pipeline into a handler that creates a span
+// for each stage, e.g. to see how long it takes to open envelopes or to run the handler.
+func (p *Pipeline) tracedHandler(tracer trace.Tracer) Handler {
+	traced := &Pipeline{stages: make([]Stage, 0, len(p.stages))}
+	for _, stage := range p.stages {
+		traced.stages = append(traced.stages, Stage{stage.Name, traceStage(tracer, stage)})
+	}
+	return traced.Handler()
+}
+
+type stageSpanKey struct{}
+
+// stageSpan is the span of a stage, which ends when the stage calls the next stage so
+// that the span only measures the time spent in the stage itself.
+type stageSpan struct {
+	span   trace.Span
+	parent trace.Span
+	done   bool
+}
+
+func traceStage(tracer trace.Tracer, stage Stage) Middleware {
+	return func(next Handler) Handler {
+		inner := stage.Middleware(func(ctx context.Context, t *Transfer) error {
+			if st, ok := ctx.Value(stageSpanKey{}).(*stageSpan); ok && !st.done {
+				st.done = true
+				st.span.End()
+				ctx = trace.ContextWithSpan(ctx, st.parent)
+			}
+			return next(ctx, t)
+		})
+
+		return func(ctx context.Context, t *Transfer) (err error) {
+			st := &stageSpan{parent: trace.SpanFromContext(ctx)}
+			ctx, st.span = tracer.Start(ctx, "pipeline."+stage.Name, trace.WithAttributes(attribute.String("trisarl.stage", stage.Name)))
+			err = inner(context.WithValue(ctx, stageSpanKey{}, st), t)
+			if !st.done {
+				st.done = true
+				endSpan(st.span, err)
+			}
+			return err
+		}
+	}
+}
+
+// endSpan records the TRISA error code or gRPC status of the error, if any, and ends
+// the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		if perr, ok := protocol.Errorp(err); ok {
+			span.SetAttributes(attribute.String("trisa.error_code", perr.Code.String()))
+		} else if st, ok := status.FromError(err); ok {
+			span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(st.Code())))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// metadataCarrier adapts incoming gRPC metadata for trace context propagation.
+type metadataCarrier metadata.MD
+
+func (m metadataCarrier) Get(key string) string {
+	if vals := metadata.MD(m).Get(key); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+func (m metadataCarrier) Set(key, value string) {
+	metadata.MD(m).Set(key, value)
+}
+
+func (m metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,260 @@
+package trisarl
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rotationalio/trisa/pkg/eventbus"
+	"github.com/rotationalio/trisa/pkg/notifications"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"github.com/trisacrypto/trisa/pkg/trisa/peers"
+)
+
+type envelopeIDKey struct{}
+
+// EnvelopeID returns the ID of the secure envelope that is being handled from the
+// context of the transfer pipeline, e.g. so that transfer handlers can look up or
+// update the state of the transaction, or an empty string outside of the pipeline.
+func EnvelopeID(ctx context.Context) string {
+	if id, ok := ctx.Value(envelopeIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+func withEnvelopeID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, envelopeIDKey{}, id)
+}
+
+// Transaction returns the state of the Travel Rule exchange with the envelope ID.
+func (s *Server) Transaction(envelopeID string) (*store.Transaction, error) {
+	return s.db.GetTransaction(envelopeID)
+}
+
+// TransitionTransaction moves the Travel Rule exchange with the envelope ID to the next
+// state, e.g. to pending review or to approved once it has been reviewed. An error
+// wrapping store.ErrInvalidTransition is returned if the transaction cannot move to the
+// state.
+func (s *Server) TransitionTransaction(envelopeID string, next store.State, reason string) (tx *store.Transaction, err error) {
+	if tx, err = s.db.TransitionTransaction(envelopeID, next, reason); err != nil {
+		return nil, err
+	}
+	s.transitioned(tx, reason)
+	return tx, nil
+}
+
+// receiveTransaction returns the transaction of the transfer, creating it if this is
+// the first message of the exchange. It is called before the transfer handler so that
+// the handler can update the state of the transaction. State tracking is best effort
+// and never causes a transfer to fail, so nil is returned if the state is unavailable.
+func (s *Server) receiveTransaction(ctx context.Context, t *Transfer) *store.Transaction {
+	if t.Inquiry || t.In == nil || t.In.Id == "" {
+		return nil
+	}
+
+	tx, err := s.db.ReceiveTransaction(t.In.Id, t.Peer.String())
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("id", t.In.Id).Msg("could not track transaction state")
+		return nil
+	}
+	return tx
+}
+
+// trackTransaction updates the state of the transaction of the transfer once it has
+// been processed by the pipeline and records its settlement to verify on chain if the
+// transfer was not rejected. Beneficiary inquiries are not transactions and transfers
+// rejected with a retryable error before they were handled, e.g. in maintenance, are
+// not tracked until they are retried. Final acknowledgments close the transaction when
+// they are handled and are not tracked again. Since retryable errors leave the state
+// unchanged, transfer handlers can implement manual reviews by returning a retryable
+// error until the transaction has been approved.
+func (s *Server) trackTransaction(ctx context.Context, t *Transfer, err error) {
+	if perr, ok := err.(*protocol.Error); ok && perr.Retry {
+		return
+	}
+
+	if t.Receipt != nil {
+		return
+	}
+
+	tx := s.receiveTransaction(ctx, t)
+	if tx == nil {
+		return
+	}
+
+	if tx.State.Final() {
+		log.Ctx(ctx).Warn().Str("id", tx.EnvelopeID).Str("state", string(tx.State)).Msg("message received for a transaction that has ended")
+		return
+	}
+
+	if err == nil {
+		s.recordSettlement(ctx, tx.EnvelopeID, t.Transaction)
+	}
+
+	switch {
+	case err != nil:
+		s.transition(ctx, tx, store.Rejected, err.Error())
+	case t.Pending, t.PendingMessage != nil:
+		return
+	case t.Out != nil:
+		if tx.State != store.Approved {
+			s.transition(ctx, tx, store.Approved, "")
+		}
+		s.transition(ctx, tx, store.Completed, "response sent")
+	}
+}
+
+func (s *Server) transition(ctx context.Context, tx *store.Transaction, next store.State, reason string) {
+	updated, err := s.db.TransitionTransaction(tx.EnvelopeID, next, reason)
+	if err != nil {
+		if errors.Is(err, store.ErrInvalidTransition) {
+			log.Ctx(ctx).Warn().Err(err).Str("id", tx.EnvelopeID).Msg("transaction state not updated")
+			return
+		}
+		log.Ctx(ctx).Error().Err(err).Str("id", tx.EnvelopeID).Msg("could not update transaction state")
+		return
+	}
+	log.Ctx(ctx).Debug().Str("id", tx.EnvelopeID).Str("state", string(next)).Msg("transaction state updated")
+	s.transitioned(updated, reason)
+}
+
+// transitioned notifies the webhooks and the event bus that the transaction has moved
+// to its current state.
+func (s *Server) transitioned(tx *store.Transaction, reason string) {
+	s.publishTransition(tx, reason)
+	var eventType string
+	switch tx.State {
+	case store.Completed:
+		eventType = eventbus.TransferCompleted
+	case store.Expired:
+		eventType = eventbus.TransferExpired
+	case store.Closed:
+		eventType = eventbus.TransferClosed
+	default:
+		return
+	}
+
+	s.emit(&eventbus.Event{
+		Type:       eventType,
+		Node:       s.commonName(),
+		Peer:       tx.Peer,
+		EnvelopeID: tx.EnvelopeID,
+		Data:       map[string]string{"reason": reason},
+	})
+}
+
+// expireTransactions periodically expires the transactions that have not been updated
+// within the transaction timeout and the transactions that have been pending review or
+// awaiting the counterparty for longer than the pending timeout until the server starts
+// shutting down.
+func (s *Server) expireTransactions() {
+	interval := s.config().ExpiryInterval
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				conf := s.config()
+				if conf.ExpiryInterval > 0 && conf.ExpiryInterval != interval {
+					interval = conf.ExpiryInterval
+					ticker.Reset(interval)
+				}
+
+				var expired []*store.Transaction
+				if conf.PendingTimeout > 0 {
+					txns, err := s.db.ExpirePendingTransactions(time.Now().Add(-conf.PendingTimeout))
+					if err != nil {
+						log.Error().Err(err).Msg("could not expire pending transactions")
+					}
+					expired = append(expired, txns...)
+				}
+
+				if conf.TransactionTimeout > 0 {
+					txns, err := s.db.ExpireStaleTransactions(time.Now().Add(-conf.TransactionTimeout))
+					if err != nil {
+						log.Error().Err(err).Msg("could not expire transactions")
+					}
+					expired = append(expired, txns...)
+				}
+
+				if len(expired) > 0 {
+					log.Info().Int("expired", len(expired)).Msg("stale transactions expired")
+				}
+
+				for _, tx := range expired {
+					s.expired(tx, conf.RejectExpired)
+				}
+			case <-s.draining:
+				return
+			}
+		}
+	}()
+}
+
+// expired notifies other systems and the compliance officers that the transaction has
+// expired and drops its review and retry, since a decision can no longer be sent. If
+// reject is true, the counterparty is sent a rejection with the envelope ID of the
+// transaction so that it does not wait for a reply that will never come.
+func (s *Server) expired(tx *store.Transaction, reject bool) {
+	var reason string
+	if n := len(tx.History); n > 0 {
+		reason = tx.History[n-1].Reason
+	}
+	s.transitioned(tx, reason)
+
+	if err := s.db.DeleteReview(tx.EnvelopeID); err != nil {
+		log.Error().Err(err).Str("id", tx.EnvelopeID).Msg("could not remove expired transfer from the review queue")
+	}
+	s.deleteRetry(tx.EnvelopeID)
+
+	outcome := "expired"
+	if reject {
+		rejection := protocol.Errorf(protocol.Rejected, "transaction expired: %s", reason)
+		if err := s.sendRejection(context.Background(), tx.Peer, tx.EnvelopeID, rejection); err != nil {
+			log.Warn().Err(err).Str("peer", tx.Peer).Str("id", tx.EnvelopeID).Msg("could not send rejection of expired transaction")
+			outcome = "expired, the rejection could not be sent to the peer"
+		} else {
+			outcome = "expired and rejected"
+		}
+	}
+
+	s.sendNotification(&notifications.Notification{
+		Kind:       notifications.Expired,
+		Node:       s.commonName(),
+		Peer:       tx.Peer,
+		EnvelopeID: tx.EnvelopeID,
+		Reason:     reason,
+		Outcome:    outcome,
+	})
+}
+
+// sendRejection sends a secure envelope with the rejection and the envelope ID of the
+// transaction to the peer. Rejections are not encrypted, so no key exchange is needed.
+func (s *Server) sendRejection(ctx context.Context, commonName, envelopeID string, rejection *protocol.Error) (err error) {
+	var peer *peers.Peer
+	if peer, err = s.lookup(ctx, commonName); err != nil {
+		return err
+	}
+
+	in := &protocol.SecureEnvelope{Id: envelopeID, Error: rejection}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	var out *protocol.SecureEnvelope
+	if out, err = peer.Transfer(in); err != nil {
+		s.recordEnvelope(ctx, peer.String(), store.Outgoing, in, nil, err)
+		return err
+	}
+	s.recordEnvelope(ctx, peer.String(), store.Outgoing, in, nil, nil)
+	s.recordEnvelope(ctx, peer.String(), store.Incoming, out, nil, nil)
+	return nil
+}
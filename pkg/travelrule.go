@@ -0,0 +1,82 @@
+package trisarl
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rotationalio/trisa/pkg/travelrule"
+	"github.com/rs/zerolog/log"
+	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Evaluate the transfer against the Travel Rule thresholds of the jurisdictions of its
+// originators and beneficiaries. Transfers that are missing the data their requirement
+// calls for are rejected with an incomplete identity error that lists the missing
+// fields; transfers that can be acknowledged automatically are answered without calling
+// the transfer handler unless the policy of the peer requires manual review. If data
+// minimization is enabled, the fields that the requirement does not call for are
+// redacted from the identity first. Inquiries, pending messages, and payload types
+// other than generic transactions are not evaluated.
+func (s *Server) travelRule(next Handler) Handler {
+	return func(ctx context.Context, t *Transfer) (err error) {
+		conf := s.config().TravelRule
+		if !conf.Enabled || t.Inquiry || t.PendingMessage != nil || t.Identity == nil || t.Transaction == nil {
+			return next(ctx, t)
+		}
+
+		t.TravelRule = travelrule.New(conf).Evaluate(t.Identity, t.Transaction)
+		log.Ctx(ctx).Debug().Str("requirement", t.TravelRule.Requirement.String()).Str("jurisdiction", t.TravelRule.Jurisdiction).Str("asset", t.TravelRule.Asset).Msg(t.TravelRule.Reason())
+
+		if missing := travelrule.Check(t.TravelRule.Requirement, t.Identity, t.Transaction); len(missing) > 0 {
+			fields := make([]string, 0, len(missing))
+			for _, violation := range missing {
+				fields = append(fields, violation.Field)
+			}
+			log.Ctx(ctx).Warn().Str("peer", t.Peer.String()).Strs("missing", fields).Msg("transfer does not have the data required by the travel rule")
+
+			perr := protocol.Errorf(protocol.IncompleteIdentity, "%s, missing %s", t.TravelRule.Reason(), strings.Join(fields, ", "))
+			if details, derr := missing.Details(); derr == nil {
+				perr.Details = details
+			}
+			return perr
+		}
+
+		if conf.Minimize && t.TravelRule.Requirement < travelrule.Full {
+			if err = minimize(ctx, t, conf.Redact); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("could not minimize identity")
+				return protocol.Errorf(protocol.InternalError, "could not process transfer")
+			}
+		}
+
+		if t.TravelRule.Requirement == travelrule.Auto && !t.Policy.ManualReview() {
+			if t.Response, err = (&Responder{}).Payload(t.Identity, t.Transaction); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("could not acknowledge transfer")
+				return protocol.Errorf(protocol.InternalError, "could not process transfer")
+			}
+			log.Ctx(ctx).Info().Str("peer", t.Peer.String()).Str("jurisdiction", t.TravelRule.Jurisdiction).Msg("transfer acknowledged automatically")
+			return s.sealResponse(t)
+		}
+		return next(ctx, t)
+	}
+}
+
+// minimize redacts the fields from the identity of the transfer and from the decrypted
+// payload of its envelope, so that the redacted identity is the one that is stored,
+// queued for review, passed on to the transfer handler, and echoed in the response.
+func minimize(ctx context.Context, t *Transfer, redact []string) (err error) {
+	identity, redacted := travelrule.Minimize(t.Identity, redact)
+	if len(redacted) == 0 {
+		return nil
+	}
+
+	payload := &protocol.Payload{Transaction: t.Envelope.Payload.Transaction}
+	if payload.Identity, err = anypb.New(identity); err != nil {
+		return err
+	}
+
+	t.Identity = identity
+	t.Envelope.Payload = payload
+	log.Ctx(ctx).Debug().Strs("redacted", redacted).Str("requirement", t.TravelRule.Requirement.String()).Msg("identity minimized")
+	return nil
+}
@@ -0,0 +1,63 @@
+package travelrule
+
+import (
+	"fmt"
+
+	"github.com/rotationalio/trisa/pkg/ivms"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+)
+
+// Check returns the fields that the transfer is missing to satisfy the requirement, in
+// the same form as IVMS101 violations so that they can be attached to the error that is
+// returned to the peer. Partial data is the names and account numbers of the originator
+// and the beneficiary; the addresses of the transaction are accepted as the account
+// numbers. Full data additionally requires the originating VASP and the information that
+// identifies the originator, i.e. a geographic address, customer identification,
+// national identification, or date and place of birth. Transfers that can be
+// acknowledged automatically are not missing any fields.
+func Check(requirement Requirement, identity *ivms101.IdentityPayload, transaction *generic.Transaction) (missing ivms.Violations) {
+	if requirement < Partial {
+		return nil
+	}
+
+	add := func(field, message string) {
+		missing = append(missing, ivms.Violation{Field: field, Message: message})
+	}
+
+	if len(identity.GetOriginator().GetOriginatorPersons()) == 0 {
+		add("originator.originator_persons", "the name of the originator is required")
+	}
+	if len(identity.GetOriginator().GetAccountNumbers()) == 0 && transaction.GetOriginator() == "" {
+		add("originator.account_numbers", "the account number of the originator is required")
+	}
+	if len(identity.GetBeneficiary().GetBeneficiaryPersons()) == 0 {
+		add("beneficiary.beneficiary_persons", "the name of the beneficiary is required")
+	}
+	if len(identity.GetBeneficiary().GetAccountNumbers()) == 0 && transaction.GetBeneficiary() == "" {
+		add("beneficiary.account_numbers", "the account number of the beneficiary is required")
+	}
+
+	if requirement < Full {
+		return missing
+	}
+
+	if identity.GetOriginatingVasp().GetOriginatingVasp() == nil {
+		add("originating_vasp.originating_vasp", "the originating VASP is required")
+	}
+
+	for i, person := range identity.GetOriginator().GetOriginatorPersons() {
+		path := fmt.Sprintf("originator.originator_persons[%d]", i)
+		if p := person.GetNaturalPerson(); p != nil {
+			if len(p.GeographicAddresses) == 0 && p.CustomerIdentification == "" && p.NationalIdentification == nil && p.DateAndPlaceOfBirth == nil {
+				add(path+".natural_person", "a geographic address, customer identification, national identification, or date and place of birth of the originator is required")
+			}
+		}
+		if p := person.GetLegalPerson(); p != nil {
+			if len(p.GeographicAddresses) == 0 && p.CustomerNumber == "" && p.NationalIdentification == nil {
+				add(path+".legal_person", "a geographic address, customer number, or national identification of the originator is required")
+			}
+		}
+	}
+	return missing
+}
@@ -0,0 +1,55 @@
+package travelrule
+
+import (
+	"fmt"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	"google.golang.org/protobuf/proto"
+)
+
+// Minimize returns a copy of the identity without the fields of the originator and
+// beneficiary persons that are redacted, e.g. the national identification of persons in
+// transfers below the threshold of their jurisdiction, along with the paths of the
+// fields that were removed. The identity is returned as is if no field was removed. The
+// VASPs of the identity are not redacted since they are not personal information.
+func Minimize(identity *ivms101.IdentityPayload, redact []string) (_ *ivms101.IdentityPayload, redacted []string) {
+	fields := make(map[string]bool, len(redact))
+	for _, field := range redact {
+		fields[field] = true
+	}
+
+	minimized := proto.Clone(identity).(*ivms101.IdentityPayload)
+	remove := func(path, field string, set bool, clear func()) {
+		if fields[field] && set {
+			clear()
+			redacted = append(redacted, path)
+		}
+	}
+
+	persons := func(prefix string, persons []*ivms101.Person) {
+		for i, person := range persons {
+			path := fmt.Sprintf("%s[%d]", prefix, i)
+			if p := person.GetNaturalPerson(); p != nil {
+				path := path + ".natural_person"
+				remove(path+".geographic_addresses", config.RedactGeographicAddresses, len(p.GeographicAddresses) > 0, func() { p.GeographicAddresses = nil })
+				remove(path+".national_identification", config.RedactNationalIdentification, p.NationalIdentification != nil, func() { p.NationalIdentification = nil })
+				remove(path+".customer_identification", config.RedactCustomerIdentification, p.CustomerIdentification != "", func() { p.CustomerIdentification = "" })
+				remove(path+".date_and_place_of_birth", config.RedactDateAndPlaceOfBirth, p.DateAndPlaceOfBirth != nil, func() { p.DateAndPlaceOfBirth = nil })
+			}
+			if p := person.GetLegalPerson(); p != nil {
+				path := path + ".legal_person"
+				remove(path+".geographic_addresses", config.RedactGeographicAddresses, len(p.GeographicAddresses) > 0, func() { p.GeographicAddresses = nil })
+				remove(path+".national_identification", config.RedactNationalIdentification, p.NationalIdentification != nil, func() { p.NationalIdentification = nil })
+				remove(path+".customer_number", config.RedactCustomerIdentification, p.CustomerNumber != "", func() { p.CustomerNumber = "" })
+			}
+		}
+	}
+
+	persons("originator.originator_persons", minimized.GetOriginator().GetOriginatorPersons())
+	persons("beneficiary.beneficiary_persons", minimized.GetBeneficiary().GetBeneficiaryPersons())
+	if len(redacted) == 0 {
+		return identity, nil
+	}
+	return minimized, redacted
+}
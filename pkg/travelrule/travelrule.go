@@ -0,0 +1,195 @@
+/*
+Package travelrule decides which data the Travel Rule requires for a transfer. The amount
+of the transfer is converted to the currency of the jurisdictions of its originators and
+beneficiaries and compared with their thresholds, e.g. USD 3,000 in the US or EUR 1,000
+in the EU, and the strictest requirement of those jurisdictions applies: full IVMS101
+data, partial data (the names and account numbers of the parties), or none, in which
+case the transfer can be acknowledged automatically.
+*/
+package travelrule
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+)
+
+// Requirement is the data the Travel Rule requires for a transfer, ordered from the
+// least to the most strict so that requirements can be compared.
+type Requirement uint8
+
+// Requirements of a transfer; the zero value means that the transfer was not evaluated.
+const (
+	Unevaluated Requirement = iota
+	Auto
+	Partial
+	Full
+)
+
+// Parse a requirement from its name in the configuration.
+func Parse(s string) (Requirement, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case config.RequireAuto:
+		return Auto, nil
+	case config.RequirePartial:
+		return Partial, nil
+	case config.RequireFull:
+		return Full, nil
+	}
+	return Unevaluated, fmt.Errorf("unknown travel rule requirement %q", s)
+}
+
+func (r Requirement) String() string {
+	switch r {
+	case Auto:
+		return config.RequireAuto
+	case Partial:
+		return config.RequirePartial
+	case Full:
+		return config.RequireFull
+	}
+	return "unevaluated"
+}
+
+// Decision is the requirement of a transfer and the jurisdiction it was determined by.
+// Value is the amount of the transfer in the currency of the jurisdiction, and is zero
+// if the asset could not be priced in that currency. Jurisdiction is empty if none of
+// the configured jurisdictions applies to the parties of the transfer.
+type Decision struct {
+	Requirement  Requirement
+	Jurisdiction string
+	Asset        string
+	Value        float64
+	Currency     string
+	Threshold    float64
+	Priced       bool
+}
+
+// Reason describes why the requirement applies, e.g. for logs and reviews.
+func (d Decision) Reason() string {
+	switch {
+	case d.Jurisdiction == "":
+		return fmt.Sprintf("%s data required since no jurisdiction applies", d.Requirement)
+	case !d.Priced:
+		return fmt.Sprintf("%s data required in %s since %s cannot be priced in %s", d.Requirement, d.Jurisdiction, d.Asset, d.Currency)
+	case d.Value >= d.Threshold:
+		return fmt.Sprintf("%s data required in %s for %.2f %s at or above the threshold of %.2f %s", d.Requirement, d.Jurisdiction, d.Value, d.Currency, d.Threshold, d.Currency)
+	default:
+		return fmt.Sprintf("%s data required in %s for %.2f %s below the threshold of %.2f %s", d.Requirement, d.Jurisdiction, d.Value, d.Currency, d.Threshold, d.Currency)
+	}
+}
+
+// Rules evaluates transfers against the jurisdictions of the configuration.
+type Rules struct {
+	conf     config.TravelRuleConfig
+	unpriced Requirement
+}
+
+// New creates the rules of the configuration, which must have been validated.
+func New(conf config.TravelRuleConfig) *Rules {
+	unpriced, err := Parse(conf.Unpriced)
+	if err != nil {
+		unpriced = Full
+	}
+	return &Rules{conf: conf, unpriced: unpriced}
+}
+
+// Evaluate the transfer against the jurisdictions of its originators and beneficiaries
+// and return the strictest requirement. Countries without a jurisdiction fall back to
+// the default jurisdiction; if no jurisdiction applies at all, full data is required so
+// that an unconfigured country is never a way around the Travel Rule.
+func (r *Rules) Evaluate(identity *ivms101.IdentityPayload, transaction *generic.Transaction) Decision {
+	decision := Decision{Requirement: Full, Asset: Asset(transaction)}
+
+	countries := Countries(identity)
+	if len(countries) == 0 {
+		countries = []string{config.DefaultJurisdiction}
+	}
+
+	evaluated := false
+	for _, country := range countries {
+		jurisdiction, ok := r.conf.Jurisdictions.Get(country)
+		if !ok {
+			continue
+		}
+
+		candidate := Decision{
+			Jurisdiction: country,
+			Asset:        decision.Asset,
+			Currency:     jurisdiction.Currency,
+			Threshold:    jurisdiction.Threshold,
+		}
+		if _, ok := r.conf.Jurisdictions[country]; !ok {
+			candidate.Jurisdiction = config.DefaultJurisdiction
+		}
+
+		candidate.Value, candidate.Priced = r.conf.Rates.Convert(transaction.GetAmount(), decision.Asset, jurisdiction.Currency)
+		switch {
+		case !candidate.Priced:
+			candidate.Requirement = r.unpriced
+		case candidate.Value >= jurisdiction.Threshold:
+			candidate.Requirement = Full
+		default:
+			candidate.Requirement, _ = Parse(jurisdiction.BelowThreshold())
+		}
+
+		if !evaluated || candidate.Requirement > decision.Requirement {
+			decision, evaluated = candidate, true
+		}
+	}
+	return decision
+}
+
+// Asset returns the asset of the transaction, which is the asset_type in the extra JSON
+// of the transaction if it is set (e.g. for tokens), otherwise the network.
+func Asset(transaction *generic.Transaction) string {
+	if extra := transaction.GetExtraJson(); extra != "" {
+		var fields struct {
+			AssetType string `json:"asset_type"`
+		}
+		if err := json.Unmarshal([]byte(extra), &fields); err == nil && fields.AssetType != "" {
+			return strings.ToUpper(fields.AssetType)
+		}
+	}
+	return strings.ToUpper(transaction.GetNetwork())
+}
+
+// Countries returns the sorted countries of the originators and beneficiaries of the
+// identity: the countries of residence or registration and of the geographic addresses.
+func Countries(identity *ivms101.IdentityPayload) []string {
+	seen := make(map[string]bool)
+	add := func(country string) {
+		if country = strings.ToUpper(strings.TrimSpace(country)); country != "" {
+			seen[country] = true
+		}
+	}
+
+	persons := append([]*ivms101.Person{}, identity.GetOriginator().GetOriginatorPersons()...)
+	persons = append(persons, identity.GetBeneficiary().GetBeneficiaryPersons()...)
+	for _, person := range persons {
+		if p := person.GetNaturalPerson(); p != nil {
+			add(p.CountryOfResidence)
+			for _, addr := range p.GeographicAddresses {
+				add(addr.Country)
+			}
+		}
+		if p := person.GetLegalPerson(); p != nil {
+			add(p.CountryOfRegistration)
+			for _, addr := range p.GeographicAddresses {
+				add(addr.Country)
+			}
+		}
+	}
+
+	countries := make([]string, 0, len(seen))
+	for country := range seen {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+	return countries
+}
@@ -0,0 +1,161 @@
+package travelrule
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/trisacrypto/trisa/pkg/ivms101"
+	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
+)
+
+func testConfig() config.TravelRuleConfig {
+	return config.TravelRuleConfig{
+		Enabled: true,
+		Jurisdictions: config.Jurisdictions{
+			"US":                       {Threshold: 3000, Currency: "USD"},
+			"GB":                       {Threshold: 1000, Currency: "GBP", Below: config.RequireAuto},
+			"CH":                       {Threshold: 0, Currency: "CHF"},
+			config.DefaultJurisdiction: {Threshold: 1000, Currency: "EUR"},
+		},
+		Rates: config.AssetRates{
+			"BTC":  {"USD": 60000, "GBP": 50000, "EUR": 55000, "CHF": 58000},
+			"USDC": {"USD": 1, "GBP": 0.8, "EUR": 0.9},
+		},
+		Unpriced: config.RequireFull,
+	}
+}
+
+// testIdentity creates an identity with a natural person originator and beneficiary
+// that reside in the specified countries; empty countries are omitted.
+func testIdentity(originator, beneficiary string) *ivms101.IdentityPayload {
+	person := func(country string) *ivms101.Person {
+		return &ivms101.Person{Person: &ivms101.Person_NaturalPerson{NaturalPerson: &ivms101.NaturalPerson{
+			Name: &ivms101.NaturalPersonName{NameIdentifiers: []*ivms101.NaturalPersonNameId{{
+				PrimaryIdentifier:  "Doe",
+				NameIdentifierType: ivms101.NaturalPersonLegal,
+			}}},
+			CountryOfResidence: country,
+		}}}
+	}
+
+	return &ivms101.IdentityPayload{
+		Originator:  &ivms101.Originator{OriginatorPersons: []*ivms101.Person{person(originator)}},
+		Beneficiary: &ivms101.Beneficiary{BeneficiaryPersons: []*ivms101.Person{person(beneficiary)}},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	btc := func(amount float64) *generic.Transaction {
+		return &generic.Transaction{Network: "btc", Amount: amount}
+	}
+	usdc := func(amount float64) *generic.Transaction {
+		return &generic.Transaction{Network: "ETH", Amount: amount, ExtraJson: `{"asset_type": "usdc"}`}
+	}
+
+	noDefault := testConfig()
+	delete(noDefault.Jurisdictions, config.DefaultJurisdiction)
+
+	unpricedPartial := testConfig()
+	unpricedPartial.Unpriced = config.RequirePartial
+
+	tests := []struct {
+		name         string
+		conf         config.TravelRuleConfig
+		identity     *ivms101.IdentityPayload
+		transaction  *generic.Transaction
+		requirement  Requirement
+		jurisdiction string
+		value        float64
+	}{
+		{"below threshold", testConfig(), testIdentity("US", "US"), btc(0.01), Partial, "US", 600},
+		{"at threshold", testConfig(), testIdentity("US", "US"), btc(0.05), Full, "US", 3000},
+		{"above threshold", testConfig(), testIdentity("US", "US"), btc(1), Full, "US", 60000},
+		{"below threshold auto", testConfig(), testIdentity("GB", "GB"), btc(0.01), Auto, "GB", 500},
+		{"zero threshold", testConfig(), testIdentity("CH", "CH"), btc(0.0001), Full, "CH", 5.8},
+		{"strictest jurisdiction", testConfig(), testIdentity("GB", "US"), btc(0.01), Partial, "US", 600},
+		{"strictest jurisdiction above threshold", testConfig(), testIdentity("US", "GB"), btc(0.04), Full, "GB", 2000},
+		{"lowercase country", testConfig(), testIdentity("gb", "gb"), btc(0.01), Auto, "GB", 500},
+		{"default jurisdiction", testConfig(), testIdentity("DE", "FR"), btc(0.01), Partial, config.DefaultJurisdiction, 550},
+		{"default jurisdiction above threshold", testConfig(), testIdentity("DE", "GB"), btc(0.02), Full, config.DefaultJurisdiction, 1100},
+		{"no countries", testConfig(), testIdentity("", ""), btc(0.01), Partial, config.DefaultJurisdiction, 550},
+		{"no jurisdiction", noDefault, testIdentity("DE", "FR"), btc(0.01), Full, "", 0},
+		{"token below threshold", testConfig(), testIdentity("US", "US"), usdc(2999), Partial, "US", 2999},
+		{"token at threshold", testConfig(), testIdentity("US", "US"), usdc(3000), Full, "US", 3000},
+		{"unpriced asset", testConfig(), testIdentity("GB", "GB"), &generic.Transaction{Network: "DOGE", Amount: 1}, Full, "GB", 0},
+		{"unpriced asset partial", unpricedPartial, testIdentity("GB", "GB"), &generic.Transaction{Network: "DOGE", Amount: 1}, Partial, "GB", 0},
+		{"unpriced in currency", testConfig(), testIdentity("CH", "CH"), usdc(1), Full, "CH", 0},
+	}
+
+	for _, tc := range tests {
+		decision := New(tc.conf).Evaluate(tc.identity, tc.transaction)
+		if decision.Requirement != tc.requirement || decision.Jurisdiction != tc.jurisdiction {
+			t.Errorf("%s: expected %s data in %q, got %s", tc.name, tc.requirement, tc.jurisdiction, decision.Reason())
+			continue
+		}
+
+		if diff := decision.Value - tc.value; diff > 1e-6 || diff < -1e-6 {
+			t.Errorf("%s: expected value %.2f, got %.2f", tc.name, tc.value, decision.Value)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	complete := func() *ivms101.IdentityPayload {
+		identity := testIdentity("US", "US")
+		identity.Originator.AccountNumbers = []string{"1AbC2dEf3GhI"}
+		identity.Originator.OriginatorPersons[0].GetNaturalPerson().CustomerIdentification = "customer-1"
+		identity.Beneficiary.AccountNumbers = []string{"4JkL5mNo6PqR"}
+		identity.OriginatingVasp = &ivms101.OriginatingVasp{OriginatingVasp: &ivms101.Person{}}
+		return identity
+	}
+
+	tests := []struct {
+		name        string
+		requirement Requirement
+		modify      func(*ivms101.IdentityPayload, *generic.Transaction)
+		missing     []string
+	}{
+		{"complete", Full, func(*ivms101.IdentityPayload, *generic.Transaction) {}, nil},
+		{"auto", Auto, func(i *ivms101.IdentityPayload, _ *generic.Transaction) {
+			i.Originator, i.Beneficiary = nil, nil
+		}, nil},
+		{"partial without names", Partial, func(i *ivms101.IdentityPayload, _ *generic.Transaction) {
+			i.Originator.OriginatorPersons, i.Beneficiary.BeneficiaryPersons = nil, nil
+		}, []string{"originator.originator_persons", "beneficiary.beneficiary_persons"}},
+		{"partial without account numbers", Partial, func(i *ivms101.IdentityPayload, _ *generic.Transaction) {
+			i.Originator.AccountNumbers, i.Beneficiary.AccountNumbers = nil, nil
+		}, []string{"originator.account_numbers", "beneficiary.account_numbers"}},
+		{"partial with transaction addresses", Partial, func(i *ivms101.IdentityPayload, tx *generic.Transaction) {
+			i.Originator.AccountNumbers, i.Beneficiary.AccountNumbers = nil, nil
+			tx.Originator, tx.Beneficiary = "1AbC2dEf3GhI", "4JkL5mNo6PqR"
+		}, nil},
+		{"partial without originator information", Partial, func(i *ivms101.IdentityPayload, _ *generic.Transaction) {
+			i.OriginatingVasp = nil
+			i.Originator.OriginatorPersons[0].GetNaturalPerson().CustomerIdentification = ""
+		}, nil},
+		{"full without originating vasp", Full, func(i *ivms101.IdentityPayload, _ *generic.Transaction) {
+			i.OriginatingVasp = nil
+		}, []string{"originating_vasp.originating_vasp"}},
+		{"full without originator information", Full, func(i *ivms101.IdentityPayload, _ *generic.Transaction) {
+			i.Originator.OriginatorPersons[0].GetNaturalPerson().CustomerIdentification = ""
+		}, []string{"originator.originator_persons[0].natural_person"}},
+		{"full without legal person information", Full, func(i *ivms101.IdentityPayload, _ *generic.Transaction) {
+			i.Originator.OriginatorPersons[0] = &ivms101.Person{Person: &ivms101.Person_LegalPerson{LegalPerson: &ivms101.LegalPerson{}}}
+		}, []string{"originator.originator_persons[0].legal_person"}},
+	}
+
+	for _, tc := range tests {
+		identity, transaction := complete(), &generic.Transaction{}
+		tc.modify(identity, transaction)
+
+		var missing []string
+		for _, violation := range Check(tc.requirement, identity, transaction) {
+			missing = append(missing, violation.Field)
+		}
+
+		if strings.Join(missing, ", ") != strings.Join(tc.missing, ", ") {
+			t.Errorf("%s: expected missing [%s], got [%s]", tc.name, strings.Join(tc.missing, ", "), strings.Join(missing, ", "))
+		}
+	}
+}
@@ -2,27 +2,45 @@ package trisarl
 
 import (
 	"context"
-	"crypto/rsa"
-	"crypto/x509"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/rotationalio/trisa/internal/logger"
+	"github.com/rotationalio/trisa/internal/maintenance"
+	"github.com/rotationalio/trisa/internal/systemd"
+	"github.com/rotationalio/trisa/pkg/addressbook"
+	"github.com/rotationalio/trisa/pkg/chain"
+	"github.com/rotationalio/trisa/pkg/client"
 	"github.com/rotationalio/trisa/pkg/config"
-	"github.com/rotationalio/trisa/pkg/logger"
+	"github.com/rotationalio/trisa/pkg/directory"
+	"github.com/rotationalio/trisa/pkg/eventbus"
+	"github.com/rotationalio/trisa/pkg/features"
+	"github.com/rotationalio/trisa/pkg/keychain"
+	"github.com/rotationalio/trisa/pkg/notifications"
+	"github.com/rotationalio/trisa/pkg/proposal"
+	"github.com/rotationalio/trisa/pkg/screening"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rotationalio/trisa/pkg/webhooks"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/trisacrypto/trisa/pkg/ivms101"
 	protocol "github.com/trisacrypto/trisa/pkg/trisa/api/v1beta1"
-	generic "github.com/trisacrypto/trisa/pkg/trisa/data/generic/v1beta1"
-	"github.com/trisacrypto/trisa/pkg/trisa/handler"
-	"github.com/trisacrypto/trisa/pkg/trisa/mtls"
 	"github.com/trisacrypto/trisa/pkg/trisa/peers"
 	"github.com/trisacrypto/trisa/pkg/trust"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 )
 
 func init() {
@@ -32,13 +50,31 @@ func init() {
 	zerolog.MessageFieldName = logger.GCPFieldKeyMsg
 
 	// Add the severity hook for GCP logging
+	log.Logger = gcpLogger()
+
+	// Log with the global logger if a context does not have a request-scoped logger
+	zerolog.DefaultContextLogger = &log.Logger
+}
+
+func gcpLogger() zerolog.Logger {
 	var gcpHook logger.SeverityHook
-	log.Logger = zerolog.New(os.Stdout).Hook(gcpHook).With().Timestamp().Logger()
+	return zerolog.New(os.Stdout).Hook(gcpHook).With().Timestamp().Logger()
+}
+
+// Set the global log level and human readable logging if console log is requested,
+// otherwise log JSON messages that are compatible with GCP logging.
+func configureLogging(conf config.Config) {
+	zerolog.SetGlobalLevel(conf.GetLogLevel())
+	if conf.ConsoleLog {
+		log.Logger = gcpLogger().Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	} else {
+		log.Logger = gcpLogger()
+	}
 }
 
 // New creates a new Rotational TRISA Server with the specified configuration and
 // prepares it to listen for and respond to gRPC requests on the TRISA network.
-func New(conf config.Config) (s *Server, err error) {
+func New(conf config.Config, opts ...Option) (s *Server, err error) {
 	// Load default configuration from the environment
 	if conf.IsZero() {
 		if conf, err = config.New(); err != nil {
@@ -46,148 +82,564 @@ func New(conf config.Config) (s *Server, err error) {
 		}
 	}
 
-	// Set the global log level
-	zerolog.SetGlobalLevel(zerolog.Level(conf.LogLevel))
-
-	// Set human readable logging if console log is requested
-	if conf.ConsoleLog {
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	// Ensure the configuration is valid before loading certificates or opening the store
+	if err = conf.Validate(); err != nil {
+		return nil, err
 	}
 
+	// Set the global log level and console logging if requested
+	configureLogging(conf)
+	log.Debug().Str("environment", conf.Environment.String()).Msg("configuration loaded")
+
 	// Create the server
-	s = &Server{conf: conf, errc: make(chan error, 1)}
+	s = &Server{conf: conf, maintenanceConf: conf.Maintenance, features: features.New(conf.Features), started: time.Now(), draining: make(chan struct{}), errc: make(chan error, 1)}
+	s.stages = s.pipeline()
+	s.payloads = defaultPayloadTypes()
+	s.replays = newReplays()
+	s.metrics = s.newMetrics()
+	s.setupTracing(conf.Tracing)
+	s.interceptors()
+
+	// Parse the planned maintenance windows to advertise in status checks
+	if s.schedule, err = parseSchedule(conf); err != nil {
+		return nil, err
+	}
 
-	// Attempt to load and parse the TRISA certificates for server-side TLS
-	// Note that the signingKey is the same as the TRISA mTLS certificates for now
-	var sz *trust.Serializer
-	if sz, err = trust.NewSerializer(false); err != nil {
+	// Load the sanctions lists that the originators of transfers are screened against
+	if s.sanctions, err = newScreener(conf.Screening); err != nil {
 		return nil, err
 	}
 
-	// Read the certificates that were issued by the directory service
-	if s.mtlsCerts, err = sz.ReadFile(conf.ServerCerts); err != nil {
+	// Create the chain-data provider that the settlements of transactions are verified with
+	if s.chainProvider, err = newChainProvider(conf.Chain); err != nil {
 		return nil, err
 	}
+	if active := s.features.Active(); len(active) > 0 {
+		log.Info().Strs("features", active).Msg("experimental features enabled")
+	}
+	if conf.Echo {
+		log.Warn().Msg("echo mode enabled, transfers are answered without compliance checks")
+	}
 
-	// Read the trust pool that was issued by the directory service (public CA keys)
-	if s.trustPool, err = sz.ReadPoolFile(conf.ServerCertPool); err != nil {
+	// Attempt to load and parse the TRISA certificates for server-side TLS and the trust
+	// pool that was issued by the directory service (public CA keys).
+	if s.mtlsCerts, s.trustPool, err = loadCertificates(conf); err != nil {
 		return nil, err
 	}
 
-	// Extract the signing key from the TRISA certificate
-	if s.signingKey, err = s.mtlsCerts.GetRSAKeys(); err != nil {
+	// The signing key is the key of the TRISA mTLS certificates unless a separate,
+	// usually longer-lived, signing certificate is configured for envelope encryption.
+	if s.signingCerts, err = loadSigningCertificates(conf); err != nil {
+		return nil, err
+	}
+	if s.signingCerts == nil {
+		s.signingCerts = s.mtlsCerts
+	}
+
+	if s.signingKey, err = loadSigningKey(conf, s.signingCerts); err != nil {
+		return nil, err
+	}
+
+	// Validity checks are strict in production and relaxed in development
+	if err = checkCertificates(conf.Environment, "server", s.mtlsCerts, s.trustPool); err != nil {
+		return nil, err
+	}
+	if s.signingCerts != s.mtlsCerts {
+		if err = checkCertificates(conf.Environment, "signing", s.signingCerts, s.trustPool); err != nil {
+			return nil, err
+		}
+	}
+
+	// Build the TLS configuration for new connections from the server certificates
+	if s.tlsConf, err = serverTLSConfig(s.mtlsCerts, s.trustPool); err != nil {
 		return nil, err
 	}
 
 	// Manage remote peers using the same credentials as the server
 	s.peers = peers.New(s.mtlsCerts, s.trustPool, s.conf.DirectoryAddr)
+
+	// Additional identities have their own certificates and peers and are selected by
+	// the server name that peers connect to
+	if s.identities, err = loadIdentities(conf); err != nil {
+		return nil, err
+	}
+	if len(s.identities) > 0 {
+		log.Info().Strs("identities", s.Identities()).Msg("hosting multiple trisa identities")
+	}
+
+	// Connect to the directory service to look up peers (the connection is lazy)
+	if s.directory, err = directory.New(s.conf.DirectoryAddr); err != nil {
+		return nil, err
+	}
+
+	// Open the store to persist the local state of the server
+	if s.db, err = store.Open(conf.Storage); err != nil {
+		return nil, err
+	}
+	if keyID := s.db.KeyID(); keyID != "" {
+		log.Debug().Str("key_id", keyID).Msg("storage encryption enabled")
+	}
+	s.book = addressbook.New(s.db)
+
+	// Start posting the lifecycle events of transfers to the webhooks
+	var endpoints []webhooks.Endpoint
+	if endpoints, err = newWebhookEndpoints(conf.Webhooks); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.setupWebhooks(conf.Webhooks, endpoints)
+
+	// Connect the event bus that the events of the node are published to
+	if s.bus, err = newEventBus(conf.EventBus); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	// Start sending notifications to the compliance officers
+	if s.notifier, err = newNotifier(conf.Notifications); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	// Apply the options from the embedding application and build the transfer pipeline
+	for _, opt := range opts {
+		if err = opt(s); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	// Envelopes are sealed with the keys peers sent before the restart, which are kept in
+	// the keychain, and opened with the exchange key if it is enabled rather than only
+	// with the key of the signing certificates
+	if err = s.setupExchangeKeys(conf.Exchange); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	// Ensure the node can parse every payload type that the configuration accepts
+	if err = s.payloads.Check(conf.Payloads); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if s.tracing != nil {
+		s.transfer = s.stages.tracedHandler(s.tracer)
+	} else {
+		s.transfer = s.stages.Handler()
+	}
 	return s, nil
 }
 
+// Ensure the Server implements the TRISA network and health services
+var (
+	_ protocol.TRISANetworkServer = &Server{}
+	_ protocol.TRISAHealthServer  = &Server{}
+)
+
 // Server implements the TRISAIntegration and TRISAHealth Services
 type Server struct {
+	panics     uint64 // accessed atomically, first for 64-bit alignment
+	inflight   int64  // accessed atomically, number of transfers being handled
+	listening  int32  // accessed atomically, number of listeners that are serving
+	nlisteners int32  // accessed atomically, number of listeners that were configured
 	protocol.UnimplementedTRISANetworkServer
 	protocol.UnimplementedTRISAHealthServer
-	conf       config.Config
-	srv        *grpc.Server
-	mtlsCerts  *trust.Provider
-	trustPool  trust.ProviderPool
-	signingKey *rsa.PrivateKey
-	peers      *peers.Peers
-	errc       chan error
+	confmu          sync.RWMutex
+	conf            config.Config
+	maintenanceConf bool
+	schedule        *maintenance.Schedule
+	sanctions       screening.Screener
+	screenerOpt     screening.Screener
+	chainProvider   chain.Provider
+	chainOpt        chain.Provider
+	proposals       proposal.Provider
+	webhooks        *webhooks.Dispatcher
+	bus             *eventbus.Bus
+	notifier        *notifications.Sender
+	srv             *grpc.Server
+	insecureSrv     *grpc.Server
+	certmu          sync.RWMutex
+	mtlsCerts       *trust.Provider
+	trustPool       trust.ProviderPool
+	signingCerts    *trust.Provider
+	signingKey      interface{}
+	exchmu          sync.RWMutex
+	keychain        keychain.KeyChain
+	prevExchange    []*previousKey
+	advertised      map[string]string
+	unpushed        []string
+	peers           *peers.Peers
+	tlsConf         *tls.Config
+	identities      []*identity
+	watcher         *fsnotify.Watcher
+	directory       *directory.Client
+	features        *features.Set
+	db              *store.Store
+	book            *addressbook.Book
+	limitmu         sync.Mutex
+	limiters        map[string]*rate.Limiter
+	quotamu         sync.Mutex
+	quotaDay        string
+	streammu        sync.Mutex
+	nstreams        int
+	streams         map[string]int
+	stages          *Pipeline
+	payloads        *PayloadTypes
+	replays         *replays
+	handler         TransferHandler
+	unary           []grpc.UnaryServerInterceptor
+	stream          []grpc.StreamServerInterceptor
+	transfer        Handler
+	metrics         *metrics
+	metricsSrv      *http.Server
+	debugSrv        *http.Server
+	probesSrv       *http.Server
+	adminSrv        *http.Server
+	sunriseSrv      *http.Server
+	tracing         *sdktrace.TracerProvider
+	tracer          trace.Tracer
+	started         time.Time
+	draining        chan struct{}
+	drainOnce       sync.Once
+	tasks           sync.WaitGroup
+	errc            chan error
 }
 
-// Serve TRISA requests.
+// Features returns the feature flags of the server, which can be toggled at runtime.
+func (s *Server) Features() *features.Set {
+	return s.features
+}
+
+// Serve TRISA requests until the process is interrupted or terminated, or until the
+// service is stopped when running as a Windows service. The configuration is reloaded
+// on SIGHUP and maintenance mode is toggled on SIGUSR1. Applications that embed the
+// server and handle signals themselves should use Run instead.
 func (s *Server) Serve() (err error) {
-	// Create TLS Credentials for the server
-	var creds grpc.ServerOption
-	if creds, err = mtls.ServerCreds(s.mtlsCerts, s.trustPool); err != nil {
-		return err
+	// Windows services are stopped by the service control manager rather than signals
+	if isService() {
+		return s.runService()
 	}
 
-	// Initialize the gRPC server
-	s.srv = grpc.NewServer(creds)
-	protocol.RegisterTRISANetworkServer(s.srv, s)
-	protocol.RegisterTRISAHealthServer(s.srv, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// Catch OS signals to ensure graceful shutdowns occur
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	notifyShutdown(quit)
 	go func() {
-		<-quit
-		s.errc <- s.Shutdown()
+		select {
+		case <-quit:
+			cancel()
+		case <-ctx.Done():
+		}
 	}()
 
-	// Listen for TRISA service requests on the configured bind address and port
-	var sock net.Listener
-	if sock, err = net.Listen("tcp", s.conf.BindAddr); err != nil {
-		return fmt.Errorf("could not listen on %q", s.conf.BindAddr)
-	}
-	defer sock.Close()
+	// Reload the configuration without restarting the server on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := s.Reload(); err != nil {
+				log.Error().Err(err).Msg("could not reload configuration")
+			}
+		}
+	}()
 
-	// Run the server and handle requests
+	// Toggle maintenance mode without restarting the server on SIGUSR1
+	usr1 := make(chan os.Signal, 1)
+	notifyMaintenance(usr1)
 	go func() {
-		log.Info().Str("listen", s.conf.BindAddr).Str("version", Version()).Msg("server started")
-		if err := s.srv.Serve(sock); err != nil {
-			s.errc <- err
+		for range usr1 {
+			s.toggleMaintenance()
 		}
 	}()
 
-	// Listen for any errors and wait for all go routines to finish.
-	if err = <-s.errc; err != nil {
+	return s.Run(ctx)
+}
+
+// Run serves TRISA requests until the context is cancelled, then shuts the server down
+// gracefully and returns the error of the shutdown, if any. Run does not handle OS
+// signals so that the server can be embedded in an application that manages its own
+// lifecycle; it returns early if any of the listeners fail.
+func (s *Server) Run(ctx context.Context) (err error) {
+	// Watch the certificate files so that renewed certificates are used without a restart
+	if s.conf.WatchCerts {
+		if err = s.watchCertificates(); err != nil {
+			return fmt.Errorf("could not watch certificates: %s", err)
+		}
+	}
+
+	// Serve the Prometheus metrics on a separate HTTP listener if enabled
+	if s.conf.Metrics.Enabled {
+		s.serveMetrics(s.conf.Metrics.Addr, s.conf.Metrics.Path)
+	}
+
+	// Serve the pprof profiles on a separate, usually loopback, HTTP listener if enabled
+	if s.conf.Debug.Enabled {
+		s.serveDebug(s.conf.Debug.Addr)
+	}
+
+	// Serve the Kubernetes liveness and readiness probes on a plain HTTP listener
+	if s.conf.Probes.Enabled {
+		s.serveProbes(s.conf.Probes.Addr)
+	}
+
+	// Serve the admin API on a separate, usually loopback, HTTP listener if enabled
+	if s.conf.Admin.Enabled {
+		if err = s.serveAdmin(s.conf.Admin.Addr); err != nil {
+			return fmt.Errorf("could not start admin server: %s", err)
+		}
+	}
+
+	// Serve the secure links of the sunrise fallback on a public listener if enabled
+	if s.conf.Sunrise.Enabled {
+		if err = s.serveSunrise(s.conf.Sunrise); err != nil {
+			return fmt.Errorf("could not start sunrise server: %s", err)
+		}
+	}
+
+	// Initialize the gRPC server with TLS credentials that follow certificate rotations
+	opts := append([]grpc.ServerOption{s.serverCreds()}, serverOptions(s.conf.GRPC)...)
+	opts = append(opts, s.chain()...)
+	s.srv = grpc.NewServer(opts...)
+	protocol.RegisterTRISANetworkServer(s.srv, s)
+	protocol.RegisterTRISAHealthServer(s.srv, s)
+
+	// Plaintext listeners are served by a separate gRPC server that only serves the
+	// health service, since peers cannot be verified without mTLS credentials.
+	listeners := append(config.Listeners{{Addr: s.conf.BindAddr, Credentials: config.CredentialsMTLS}}, s.conf.Listeners...)
+	for _, listener := range listeners {
+		if listener.Insecure() && s.insecureSrv == nil {
+			s.insecureSrv = grpc.NewServer(append(serverOptions(s.conf.GRPC), s.chain()...)...)
+			protocol.RegisterTRISAHealthServer(s.insecureSrv, s)
+		}
+	}
+
+	// Allow tools like grpcurl to introspect the TRISA services during development
+	if s.conf.Debug.Reflection {
+		log.Warn().Msg("gRPC reflection service enabled")
+		for _, srv := range s.grpcServers() {
+			reflection.Register(srv)
+		}
+	}
+
+	// Listen for TRISA service requests on the configured bind address or unix socket
+	// and on any additional listeners, e.g. dual-stack or internal addresses.
+	atomic.StoreInt32(&s.nlisteners, int32(len(listeners)))
+	for _, listener := range listeners {
+		var sock net.Listener
+		if sock, err = listen(listener.Addr, os.FileMode(s.conf.SocketMode)); err != nil {
+			return fmt.Errorf("could not listen on %q: %s", listener.Addr, err)
+		}
+		defer sock.Close()
+
+		srv := s.srv
+		if listener.Insecure() {
+			srv = s.insecureSrv
+		}
+
+		// Run the server and handle requests
+		go func(listener config.Listener) {
+			log.Debug().Str("listen", listener.Addr).Str("credentials", listener.Credentials).Msg("listener started")
+			defer atomic.AddInt32(&s.listening, -1)
+			if err := srv.Serve(sock); err != nil {
+				s.errc <- err
+			}
+		}(listener)
+		atomic.AddInt32(&s.listening, 1)
+	}
+	log.Info().Str("listen", s.conf.BindAddr).Str("listeners", s.conf.Listeners.String()).Str("version", Version()).Msg("server started")
+
+	// Tell systemd that the server is ready once all of the listeners are bound
+	notify(systemd.Ready, systemd.Status("serving TRISA requests on "+s.conf.BindAddr))
+	s.watchdog()
+	s.expireTransactions()
+	s.verifySettlements()
+	s.reconcileSettlements()
+	s.retryTransfers()
+	s.pruneKeyUsage()
+	s.rotateExchangeKeys()
+	s.exchangeKeysOnStartup()
+
+	// Wait until the context is cancelled or one of the listeners fails
+	select {
+	case <-ctx.Done():
+		return s.Shutdown()
+	case err = <-s.errc:
 		return err
 	}
-	return nil
 }
 
-// Shutdown the gRPC server gracefully.
+// Shutdown the gRPC server gracefully. Open transfer streams are closed with a
+// retryable error once the messages in flight have been answered, and in-flight unary
+// requests are allowed to complete. If the server has not drained within the drain
+// timeout, the remaining connections are closed forcefully.
 func (s *Server) Shutdown() (err error) {
-	log.Info().Msg("gracefully shutting down")
-	s.srv.GracefulStop()
+	log.Info().Int64("inflight_transfers", s.InFlight()).Msg("gracefully shutting down")
+	notify(systemd.Stopping, systemd.Status("draining in-flight transfers"))
+	s.drainOnce.Do(func() { close(s.draining) })
+
+	if servers := s.grpcServers(); len(servers) > 0 {
+		stopped := make(chan struct{})
+		go func() {
+			for _, srv := range servers {
+				srv.GracefulStop()
+			}
+			close(stopped)
+		}()
+
+		timeout := s.config().DrainTimeout
+		select {
+		case <-stopped:
+		case <-time.After(timeout):
+			log.Warn().
+				Dur("drain_timeout", timeout).
+				Int64("inflight_transfers", s.InFlight()).
+				Msg("server did not drain before the timeout, closing connections")
+			for _, srv := range servers {
+				srv.Stop()
+			}
+			<-stopped
+		}
+	}
+	s.shutdownMetrics()
+	s.shutdownDebug()
+	s.shutdownProbes()
+	s.shutdownAdmin()
+	s.shutdownSunrise()
+
+	if err = s.Close(); err != nil {
+		return err
+	}
+
 	log.Debug().Msg("successful shut down")
 	return nil
 }
 
+// background runs the task in a goroutine that Close waits for before the store is
+// closed. The context of the task is cancelled when the server starts shutting down.
+func (s *Server) background(task func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.tasks.Add(1)
+	go func() {
+		defer s.tasks.Done()
+		defer cancel()
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-s.draining:
+				cancel()
+			case <-stop:
+			}
+		}()
+		task(ctx)
+	}()
+}
+
+// grpcServers returns the gRPC servers that have been started.
+func (s *Server) grpcServers() (servers []*grpc.Server) {
+	for _, srv := range []*grpc.Server{s.srv, s.insecureSrv} {
+		if srv != nil {
+			servers = append(servers, srv)
+		}
+	}
+	return servers
+}
+
+// InFlight returns the number of transfers that are currently being handled.
+func (s *Server) InFlight() int64 {
+	return atomic.LoadInt64(&s.inflight)
+}
+
+// Close the resources held by the server, such as the store and directory connection.
+// Close is called by Shutdown and only needs to be called directly if the server was
+// created but never served, e.g. when it is used by a command line utility. Background
+// tasks are cancelled and have returned before the store is closed.
+func (s *Server) Close() (err error) {
+	s.drainOnce.Do(func() { close(s.draining) })
+	s.tasks.Wait()
+
+	if s.watcher != nil {
+		s.watcher.Close()
+	}
+	s.shutdownTracing()
+	s.shutdownWebhooks()
+	s.shutdownEventBus()
+	s.shutdownNotifications()
+
+	if err = s.directory.Close(); err != nil {
+		log.Warn().Err(err).Msg("could not close directory service connection")
+	}
+
+	if err = s.db.Close(); err != nil {
+		log.Error().Err(err).Msg("could not close store")
+		return err
+	}
+	return nil
+}
+
 func (s *Server) Transfer(ctx context.Context, in *protocol.SecureEnvelope) (out *protocol.SecureEnvelope, err error) {
 	// Get the peer from the context
 	var peer *peers.Peer
-	if peer, err = s.peers.FromContext(ctx); err != nil {
-		log.Error().Err(err).Msg("could not verify peer from incoming request")
+	if peer, err = s.lookupPeer(ctx); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("could not verify peer from incoming request")
 		return nil, &protocol.Error{
 			Code:    protocol.Unverified,
 			Message: err.Error(),
 		}
 	}
-	log.Info().Str("peer", peer.String()).Str("id", in.Id).Msg("unary transfer request received")
-
-	// Ensure peer signing key is available to send a response
-	if peer.SigningKey() == nil {
-		log.Warn().Str("peer", peer.String()).Msg("no signing key available")
-		return nil, &protocol.Error{
-			Code:    protocol.NoSigningKey,
-			Message: "please retry transfer after key exchange",
-			Retry:   true,
+	s.remember(peer)
+	s.metrics.transfers.WithLabelValues(peer.String(), "unary").Inc()
+	seq := s.received(peer, in.Id)
+	log.Ctx(ctx).Info().Str("peer", peer.String()).Str("id", in.Id).Uint64("seq", seq).Msg("unary transfer request received")
+	defer s.sent(peer)
+
+	if out, err = s.handleTransaction(ctx, peer, in); err != nil {
+		// Return TRISA coded errors in a secure envelope with the ID of the envelope, as
+		// the transfer stream does, so that the peer can record the rejection against it;
+		// any other error fails the RPC.
+		if trisaErr, ok := err.(*protocol.Error); ok {
+			s.metrics.observeError(peer.String(), "Transfer", trisaErr)
+			return &protocol.SecureEnvelope{Id: in.Id, Error: annotateError(RequestID(ctx), trisaErr)}, nil
 		}
+		return nil, err
 	}
-
-	return s.handleTransaction(ctx, peer, in)
+	return out, nil
 }
 
 func (s *Server) TransferStream(stream protocol.TRISANetwork_TransferStreamServer) (err error) {
 	var peer *peers.Peer
 	ctx := stream.Context()
-	if peer, err = s.peers.FromContext(ctx); err != nil {
-		log.Error().Err(err).Msg("could not verify peer from incoming stream")
+	if peer, err = s.lookupPeer(ctx); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("could not verify peer from incoming stream")
 		return &protocol.Error{
 			Code:    protocol.Unverified,
 			Message: err.Error(),
 		}
 	}
-	log.Info().Str("peer", peer.String()).Msg("transfer stream opened")
+	// Limit the number of open streams before doing any other work for the stream
+	conf := s.config()
+	if err = s.openStream(peer, conf.Streams); err != nil {
+		return err
+	}
+	defer s.closeStream(peer)
+
+	log.Ctx(ctx).Info().Str("peer", peer.String()).Msg("transfer stream opened")
+	s.remember(peer)
+	s.streamOpened(peer)
+	defer s.streamClosed(peer)
+
+	opened := time.Now()
+	defer func() {
+		s.metrics.streamDuration.WithLabelValues(peer.String()).Observe(time.Since(opened).Seconds())
+	}()
 
 	// Ensure peer signing key is available to send a response
-	if peer.SigningKey() == nil {
-		log.Warn().Str("peer", peer.String()).Msg("no signing key available")
+	if s.peerKey(peer) == nil {
+		log.Ctx(ctx).Warn().Str("peer", peer.String()).Msg("no signing key available")
 		return &protocol.Error{
 			Code:    protocol.NoSigningKey,
 			Message: "please retry transfer stream after key exchange",
@@ -195,173 +647,275 @@ func (s *Server) TransferStream(stream protocol.TRISANetwork_TransferStreamServe
 		}
 	}
 
+	// Messages are decrypted and handled by a pool of workers, a single worker unless
+	// concurrent streams are enabled, in which case there is a worker for each of the
+	// maximum number of unanswered messages. Responses are sent in the order of the
+	// messages unless the peer correlates them by envelope ID. The next message is not
+	// received until a message has been answered, so peers that send faster than the
+	// server can respond are paused by gRPC flow control rather than buffering an
+	// unbounded number of envelopes in memory.
+	inflight := 1
+	if s.features.Enabled(features.ConcurrentStreams) && conf.Streams.MaxInFlight > 1 {
+		inflight = conf.Streams.MaxInFlight
+	}
+
+	var (
+		wg      sync.WaitGroup
+		sendmu  sync.Mutex
+		summary streamSummary
+		sem     = make(chan struct{}, inflight)
+		fatal   = make(chan error, 1)
+		msgs    = make(chan received)
+		done    = make(chan struct{})
+	)
+
+	// Report the summary of the stream to the peer once the messages in flight have
+	// been answered or canceled.
+	defer func() {
+		stream.SetTrailer(summary.trailer())
+	}()
+
+	hctx, cancel := context.WithCancel(ctx)
+	send := func(out *protocol.SecureEnvelope) error {
+		sendmu.Lock()
+		defer sendmu.Unlock()
+		return stream.Send(out)
+	}
+
+	// Each worker releases the slot of its message once the message has been answered
+	pool := newStreamPool(hctx, inflight, !streamUnordered(ctx), send, func(job *streamJob, send func(*protocol.SecureEnvelope) error) {
+		if err := s.streamTransfer(hctx, peer, job.in, job.seq, &summary, send); err != nil {
+			select {
+			case fatal <- err:
+				cancel()
+			default:
+			}
+		}
+	}, func() {
+		<-sem
+		wg.Done()
+	})
+
+	// Wait for the messages in flight after they are canceled if the stream is closed
+	defer pool.wait()
+	defer wg.Wait()
+	defer cancel()
+	defer close(done)
+	defer pool.stop()
+
+	// Receive messages in a separate go routine so that the stream can be closed when
+	// the server is shutting down even if the peer is not sending any messages.
+	go func() {
+		for {
+			select {
+			case sem <- struct{}{}:
+			case <-done:
+				return
+			}
+
+			var msg received
+			msg.in, msg.err = stream.Recv()
+			select {
+			case msgs <- msg:
+			case <-done:
+				return
+			}
+
+			if msg.err != nil {
+				return
+			}
+		}
+	}()
+
 	// Handle incoming secure envelopes from client
-	var nmessages uint64
 	for {
+		var msg received
 		select {
+		case msg = <-msgs:
+		case err = <-fatal:
+			return err
+		case <-s.draining:
+			// Answer the messages in flight and ask the peer to reconnect later
+			wg.Wait()
+			log.Ctx(ctx).Info().
+				Str("peer", peer.String()).
+				Uint64("total_messages", summary.received).
+				Uint64("accepted", atomic.LoadUint64(&summary.accepted)).
+				Uint64("rejected", atomic.LoadUint64(&summary.rejected)).
+				Msg("transfer stream closed for shutdown")
+			return &protocol.Error{
+				Code:    protocol.Unavailable,
+				Message: "the server is shutting down, please reconnect and retry",
+				Retry:   true,
+			}
 		case <-ctx.Done():
 			return ctx.Err()
+		}
+
+		select {
+		case err = <-fatal:
+			return err
 		default:
 		}
 
-		var in *protocol.SecureEnvelope
-		if in, err = stream.Recv(); err != nil {
-			if err == io.EOF {
-				log.Info().
+		if msg.err != nil {
+			if msg.err == io.EOF {
+				// Answer the messages in flight before closing the stream
+				wg.Wait()
+				select {
+				case err = <-fatal:
+					return err
+				default:
+				}
+
+				log.Ctx(ctx).Info().
 					Str("peer", peer.String()).
-					Uint64("total_messages", nmessages).
+					Uint64("total_messages", summary.received).
+					Uint64("accepted", atomic.LoadUint64(&summary.accepted)).
+					Uint64("rejected", atomic.LoadUint64(&summary.rejected)).
 					Msg("transfer stream closed")
 				return nil
 			}
-			log.Warn().Err(err).Msg("transfer stream recv error")
-			return protocol.Errorf(protocol.Unavailable, "stream closed prematurely: %s", err)
+			log.Ctx(ctx).Warn().Err(msg.err).Msg("transfer stream recv error")
+			return protocol.Errorf(protocol.Unavailable, "stream closed prematurely: %s", msg.err)
 		}
 
-		// Handle the response
-		nmessages++
-		var out *protocol.SecureEnvelope
-		if out, err = s.handleTransaction(ctx, peer, in); err != nil {
-			// Do not close the stream for TRISA coded errors, send the error in the secure envelope
-			switch trisaErr := err.(type) {
-			case *protocol.Error:
-				out = &protocol.SecureEnvelope{Error: trisaErr}
-			default:
-				return err
-			}
-		}
+		// Hand the message to the next available worker
+		summary.received++
+		s.metrics.transfers.WithLabelValues(peer.String(), "stream").Inc()
 
-		// Send the response
-		if err = stream.Send(out); err != nil {
-			log.Error().Err(err).Msg("transfer stream send error")
-			return protocol.Errorf(protocol.Unavailable, "stream closed prematurely: %s", err)
+		wg.Add(1)
+		select {
+		case pool.jobs <- pool.next(msg.in, summary.received):
+		case err = <-fatal:
+			wg.Done()
+			return err
 		}
-
-		// Log the message
-		log.Info().Str("peer", peer.String()).Str("id", in.Id).Uint64("n_messages", nmessages).Msg("streaming transfer request received")
 	}
 }
 
-// Although the Rotational Server does not do Transfers, it still attempts to decode
-// the message in order to send back correct TRISA errors if the message is incorrect
-// for any reason, then it simply sends a NO_COMPLIANCE error at the end.
-func (s *Server) handleTransaction(ctx context.Context, peer *peers.Peer, in *protocol.SecureEnvelope) (out *protocol.SecureEnvelope, err error) {
-	// Decrypt the encryption key and HMAC secret with private signing keys (asymmetric phase)
-	// Note that the handler.Open function will return a TRISA protocol error.
-	var envelope *handler.Envelope
-	if envelope, err = handler.Open(in, s.signingKey); err != nil {
-		log.Error().Err(err).Msg("could not open secure envelope")
-		return nil, err
-	}
-
-	payload := envelope.Payload
-	if payload.Identity.TypeUrl != "type.googleapis.com/ivms101.IdentityPayload" {
-		log.Warn().Str("type", payload.Identity.TypeUrl).Msg("unsupported identity type")
-		return nil, protocol.Errorf(protocol.UnparseableIdentity, "ivms101.IdentityPayload payload identity type required")
+// ConfirmAddress confirms that the node controls the wallet address on the network in
+// the AddressNetworkKey and AddressKey request metadata by looking it up in the address
+// book, like beneficiary inquiries. Peers that do not send the address in the metadata
+// are asked to send a beneficiary inquiry instead, since the protocol messages do not
+// carry an address yet.
+func (s *Server) ConfirmAddress(ctx context.Context, in *protocol.Address) (out *protocol.AddressConfirmation, err error) {
+	var peer *peers.Peer
+	if peer, err = s.lookupPeer(ctx); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("could not verify peer from incoming request")
+		return nil, &protocol.Error{
+			Code:    protocol.Unverified,
+			Message: err.Error(),
+		}
 	}
+	s.remember(peer)
 
-	if payload.Transaction.TypeUrl != "type.googleapis.com/trisa.data.generic.v1beta1.Transaction" {
-		log.Warn().Str("type", payload.Transaction.TypeUrl).Msg("unsupported transaction type")
-		return nil, protocol.Errorf(protocol.UnparseableTransaction, "trisa.data.generic.v1beta1.Transaction payload transaction type required")
+	network, address := addressFromContext(ctx)
+	if network == "" || address == "" {
+		return nil, protocol.Errorf(protocol.Unimplemented, "send the wallet address in the %s and %s metadata or send a beneficiary inquiry", AddressNetworkKey, AddressKey)
 	}
 
-	identity := &ivms101.IdentityPayload{}
-	transaction := &generic.Transaction{}
-
-	if err = payload.Identity.UnmarshalTo(identity); err != nil {
-		log.Error().Err(err).Msg("could not unmarshal identity")
-		return nil, protocol.Errorf(protocol.UnparseableIdentity, "could not unmarshal identity")
-	}
-	if err = payload.Transaction.UnmarshalTo(transaction); err != nil {
-		log.Error().Err(err).Msg("could not unmarshal transaction")
-		return nil, protocol.Errorf(protocol.UnparseableTransaction, "could not unmarshal transaction")
+	if err = s.controlsAddress(ctx, peer.String(), network, address); err != nil {
+		return nil, err
 	}
 
-	// Here is the point where you would start to handle the incoming request and return
-	// the beneficiary information, loaded up from your database. Rotational Labs is not
-	// a VASP though, so it returns a no compliance error.
-	return nil, &protocol.Error{
-		Code:    protocol.NoCompliance,
-		Message: "Rotational Labs is not a VASP and therefore cannot perform Travel Rule compliance",
-		Retry:   false,
-	}
-}
-
-func (s *Server) ConfirmAddress(ctx context.Context, in *protocol.Address) (out *protocol.AddressConfirmation, err error) {
-	// TODO: return a gRPC error
-	log.Info().Msg("confirm address")
-	return nil, &protocol.Error{
-		Code:    protocol.Unimplemented,
-		Message: "Rotational Labs has not implemented address confirmation yet",
-		Retry:   false,
-	}
+	log.Ctx(ctx).Info().Str("peer", peer.String()).Str("network", network).Msg("wallet address confirmed")
+	return &protocol.AddressConfirmation{}, nil
 }
 
 func (s *Server) KeyExchange(ctx context.Context, in *protocol.SigningKey) (out *protocol.SigningKey, err error) {
 	// Get the peer from the context
 	var peer *peers.Peer
-	if peer, err = s.peers.FromContext(ctx); err != nil {
-		log.Error().Err(err).Msg("could not verify peer from incoming request")
+	if peer, err = s.lookupPeer(ctx); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("could not verify peer from incoming request")
 		return nil, &protocol.Error{
 			Code:    protocol.Unverified,
 			Message: err.Error(),
 		}
 	}
-	log.Info().Str("peer", peer.String()).Msg("key exchange request received")
+	log.Ctx(ctx).Info().Str("peer", peer.String()).Msg("key exchange request received")
+	s.remember(peer)
+	s.metrics.keyExchanges.WithLabelValues(peer.String(), "incoming").Inc()
 
-	// Cache key in the peers mapping
-	// TODO: parse PEM data in addition to PKIX public key data
+	// Keep the key in the keychain; the key may be PEM or DER encoded and may be sent
+	// as a public key or as the full certificate
 	var pub interface{}
-	if pub, err = x509.ParsePKIXPublicKey(in.Data); err != nil {
-		log.Error().Err(err).Int64("version", in.Version).Str("algorithm", in.PublicKeyAlgorithm).Msg("could not parse incoming PKIX public key")
+	if pub, err = client.ParseSigningKey(in.Data); err != nil {
+		log.Ctx(ctx).Error().Err(err).Int64("version", in.Version).Str("algorithm", in.PublicKeyAlgorithm).Msg("could not parse incoming signing key")
 		return nil, protocol.Errorf(protocol.NoSigningKey, "could not parse signing key")
 	}
 
-	if err = peer.UpdateSigningKey(pub); err != nil {
-		log.Error().Err(err).Msg("could not update signing key")
-		return nil, protocol.Errorf(protocol.UnhandledAlgorithm, "unsuported signing algorithm")
-	}
-
-	// Return the public signing-key of the service
-	var key *x509.Certificate
-	if key, err = s.mtlsCerts.GetLeafCertificate(); err != nil {
-		log.Error().Err(err).Msg("could not extract leaf certificate")
-		return nil, protocol.Errorf(protocol.InternalError, "could not return signing keys")
+	// Negotiate the algorithm of the key that envelopes to the peer are sealed with
+	if err = s.acceptKey(in, pub); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Str("algorithm", in.PublicKeyAlgorithm).Msg("signing key not accepted")
+		return nil, protocol.Errorf(protocol.UnhandledAlgorithm, "%s; accepted algorithms: %s", err, s.config().Exchange.Algorithms)
 	}
 
-	out = &protocol.SigningKey{
-		Version:            int64(key.Version),
-		Signature:          key.Signature,
-		SignatureAlgorithm: key.SignatureAlgorithm.String(),
-		PublicKeyAlgorithm: key.PublicKeyAlgorithm.String(),
-		NotBefore:          key.NotBefore.Format(time.RFC3339),
-		NotAfter:           key.NotAfter.Format(time.RFC3339),
+	// Expired keys are rejected so that envelopes are not sealed with them
+	key := advertisedPeerKey(in, pub)
+	if err = checkPeerKey(key, time.Now()); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("signing key not accepted")
+		return nil, protocol.Errorf(protocol.InvalidKey, "%s", err)
 	}
+	s.updatePeerKey(peer, key)
 
-	if out.Data, err = x509.MarshalPKIXPublicKey(key.PublicKey); err != nil {
-		log.Error().Err(err).Msg("could not marshal PKIX public key")
-		return nil, protocol.Errorf(protocol.InternalError, "could not marshal public key")
+	// Return the public signing-key of the identity the peer connected to
+	if out, err = s.advertisedKey(ctx); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("could not return signing key")
+		return nil, protocol.Errorf(protocol.InternalError, "could not return signing keys")
 	}
+	s.recordAdvertisedKey(peer.String(), out)
+
+	s.emit(&eventbus.Event{
+		Type: eventbus.KeyExchanged,
+		Node: s.commonNameFor(ctx),
+		Peer: peer.String(),
+		Data: map[string]string{"direction": "incoming", "algorithm": in.PublicKeyAlgorithm},
+	})
 	return out, nil
 }
 
 func (s *Server) Status(ctx context.Context, in *protocol.HealthCheck) (out *protocol.ServiceState, err error) {
-	log.Info().
+	log.Ctx(ctx).Info().
 		Uint32("attempts", in.Attempts).
 		Str("last_checked_at", in.LastCheckedAt).
 		Msg("status check")
 
-	// Request another health check between 30 minutes and an hour from now.
-	now := time.Now()
-	out = &protocol.ServiceState{
-		Status:    protocol.ServiceState_HEALTHY,
-		NotBefore: now.Add(30 * time.Minute).Format(time.RFC3339),
-		NotAfter:  now.Add(1 * time.Hour).Format(time.RFC3339),
+	// Request another health check within the configured interval, peers that have an
+	// override in the configuration can be asked to check back less frequently.
+	var commonName string
+	if peer, err := s.lookupPeer(ctx); err == nil {
+		commonName = peer.String()
 	}
 
+	conf, schedule := s.maintenanceState()
+	interval := conf.HealthCheckInterval(commonName)
+
+	now := time.Now()
+	notBefore, notAfter := now.Add(interval.Min), now.Add(interval.Max)
+	out = &protocol.ServiceState{Status: protocol.ServiceState_HEALTHY}
+
 	// If we're in maintenance mode, change the service state appropriately
-	if s.conf.Maintenance {
+	if conf.Maintenance {
 		out.Status = protocol.ServiceState_MAINTENANCE
+	} else if window, ok := schedule.Next(now); ok {
+		// Advertise planned maintenance once the notice period before the window has
+		// started and ask the peer not to check back until the window is over. Otherwise
+		// ensure that the peer checks back before the notice period starts.
+		notice := window.Start.Add(-conf.MaintenanceNotice)
+		switch {
+		case !now.Before(notice):
+			out.Status = protocol.ServiceState_MAINTENANCE
+			notBefore, notAfter = window.End, window.End.Add(interval.Max-interval.Min)
+		case notice.Before(notAfter):
+			notAfter = notice
+			if notice.Before(notBefore) {
+				notBefore = now
+			}
+		}
 	}
 
+	out.NotBefore = notBefore.Format(time.RFC3339)
+	out.NotAfter = notAfter.Format(time.RFC3339)
 	return out, nil
 }
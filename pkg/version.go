@@ -2,6 +2,10 @@ package trisarl
 
 import "fmt"
 
+// APIVersion is the major version of the public Go API; see the package documentation
+// for the compatibility guarantees that are made within an API version.
+const APIVersion = "v1"
+
 // Version component constants for the current build.
 const (
 	VersionMajor         = 1
@@ -0,0 +1,108 @@
+package trisarl
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rotationalio/trisa/pkg/secrets"
+	"github.com/rs/zerolog/log"
+)
+
+// CertificateWatchDelay is how long the watcher waits after the last change to the
+// certificate files before reloading them, since renewed certificates and trust pools
+// are usually written one after the other.
+const CertificateWatchDelay = 2 * time.Second
+
+// watchCertificates watches the local certificate files and rotates the certificates
+// when they change. The parent directories are watched rather than the files so that
+// files replaced by a rename (and Kubernetes secret volume updates, which swap the
+// ..data symlink) are detected. Certificates loaded from secret URIs are not watched.
+func (s *Server) watchCertificates() (err error) {
+	conf := s.config()
+	files := make(map[string]struct{})
+	for _, path := range []string{conf.ServerCerts, conf.ServerCertPool} {
+		if secrets.IsURI(path) {
+			log.Debug().Str("path", path).Msg("certificates loaded from a secret store are not watched")
+			continue
+		}
+
+		if path, err = filepath.Abs(path); err != nil {
+			return err
+		}
+		files[path] = struct{}{}
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	var watcher *fsnotify.Watcher
+	if watcher, err = fsnotify.NewWatcher(); err != nil {
+		return err
+	}
+
+	dirs := make(map[string]struct{})
+	for path := range files {
+		dir := filepath.Dir(path)
+		if _, ok := dirs[dir]; ok {
+			continue
+		}
+
+		if err = watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+		dirs[dir] = struct{}{}
+	}
+
+	s.watcher = watcher
+	go s.watch(watcher, files)
+	log.Debug().Int("files", len(files)).Msg("watching certificates for changes")
+	return nil
+}
+
+// watch handles file system events until the watcher is closed, rotating the
+// certificates once the files have stopped changing.
+func (s *Server) watch(watcher *fsnotify.Watcher, files map[string]struct{}) {
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			if _, watched := files[event.Name]; !watched && !strings.HasPrefix(filepath.Base(event.Name), "..") {
+				continue
+			}
+
+			log.Debug().Str("file", event.Name).Str("op", event.Op.String()).Msg("certificate file changed")
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(CertificateWatchDelay, func() {
+				if err := s.RotateCertificates(); err != nil {
+					log.Error().Err(err).Msg("could not rotate certificates, continuing with the current certificates")
+				}
+			})
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warn().Err(err).Msg("certificate watcher error")
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package trisarl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/rotationalio/trisa/pkg/config"
+	"github.com/rotationalio/trisa/pkg/secrets"
+	"github.com/rotationalio/trisa/pkg/store"
+	"github.com/rotationalio/trisa/pkg/webhooks"
+	"github.com/rs/zerolog/log"
+)
+
+// publish posts the event of the transaction with the envelope ID to the webhooks, if
+// they are enabled. Events are dispatched in the background and never affect the Travel
+// Rule exchange; events that cannot be delivered are dead-lettered in the store.
+func (s *Server) publish(eventType, envelopeID, peer string, state store.State, reason string) {
+	s.confmu.RLock()
+	dispatcher := s.webhooks
+	s.confmu.RUnlock()
+
+	if dispatcher == nil {
+		return
+	}
+
+	dispatcher.Dispatch(&webhooks.Event{
+		Type:       eventType,
+		EnvelopeID: envelopeID,
+		Peer:       peer,
+		State:      string(state),
+		Reason:     reason,
+	})
+}
+
+// publishTransition posts the event of the state the transaction has moved to, if the
+// state has one.
+func (s *Server) publishTransition(tx *store.Transaction, reason string) {
+	var eventType string
+	switch tx.State {
+	case store.Approved:
+		eventType = webhooks.TransferApproved
+	case store.Rejected:
+		eventType = webhooks.TransferRejected
+	case store.Expired:
+		eventType = webhooks.TransferExpired
+	case store.Closed:
+		eventType = webhooks.TransferClosed
+	default:
+		return
+	}
+	s.publish(eventType, tx.EnvelopeID, tx.Peer, tx.State, reason)
+}
+
+// deadLetter keeps the webhook event that could not be delivered in the store.
+func (s *Server) deadLetter(url string, event *webhooks.Event, attempts int, err error) {
+	log.Warn().Err(err).Str("url", url).Str("event", event.Type).Str("id", event.EnvelopeID).Int("attempts", attempts).Msg("could not deliver webhook event")
+
+	record := &store.DeadLetter{URL: url, EventID: event.ID, Attempts: attempts}
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	var merr error
+	if record.Event, merr = json.Marshal(event); merr != nil {
+		log.Error().Err(merr).Str("event_id", event.ID).Msg("could not serialize webhook event for the dead-letter log")
+		return
+	}
+
+	if merr = s.db.PutDeadLetter(record); merr != nil {
+		log.Error().Err(merr).Str("event_id", event.ID).Msg("could not persist undelivered webhook event")
+	}
+}
+
+// setupWebhooks starts the webhook dispatcher if webhooks are enabled and it is not
+// running yet, and sets the endpoints that events are posted to. The retries, timeout,
+// workers, and queue size of a running dispatcher are not changed until a restart.
+func (s *Server) setupWebhooks(conf config.WebhooksConfig, endpoints []webhooks.Endpoint) {
+	s.confmu.Lock()
+	defer s.confmu.Unlock()
+
+	if s.webhooks == nil && conf.Enabled {
+		client := &http.Client{Timeout: conf.Timeout}
+		s.webhooks = webhooks.NewDispatcher(client, conf.Workers, conf.QueueSize, conf.Retries, conf.Backoff, s.deadLetter)
+	}
+
+	if s.webhooks != nil {
+		s.webhooks.SetEndpoints(endpoints)
+	}
+}
+
+// newWebhookEndpoints loads the secrets of the webhook endpoints of the configuration,
+// or returns no endpoints if webhooks are not enabled.
+func newWebhookEndpoints(conf config.WebhooksConfig) (endpoints []webhooks.Endpoint, err error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secrets.Timeout)
+	defer cancel()
+
+	for _, webhook := range conf.Endpoints {
+		var secret string
+		if secret, err = secrets.LoadString(ctx, webhook.Secret); err != nil {
+			return nil, fmt.Errorf("could not load secret of webhook %s: %s", webhook.URL, err)
+		}
+		endpoints = append(endpoints, webhooks.Endpoint{URL: webhook.URL, Secret: []byte(secret), Events: webhook.Events})
+	}
+	return endpoints, nil
+}
+
+// shutdownWebhooks stops the webhook dispatcher, dead-lettering the events that have
+// not been delivered yet.
+func (s *Server) shutdownWebhooks() {
+	s.confmu.Lock()
+	dispatcher := s.webhooks
+	s.webhooks = nil
+	s.confmu.Unlock()
+
+	if dispatcher != nil {
+		dispatcher.Close()
+	}
+}
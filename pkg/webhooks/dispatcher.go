@@ -0,0 +1,174 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Errors of deliveries that were not attempted.
+var (
+	ErrQueueFull = errors.New("webhook queue is full")
+	ErrClosed    = errors.New("webhook dispatcher closed before the event was delivered")
+)
+
+// DeadLetterFunc handles a delivery of the event to the endpoint URL that failed after
+// the number of attempts, e.g. by persisting it so that it can be redelivered.
+type DeadLetterFunc func(url string, event *Event, attempts int, err error)
+
+// Dispatcher delivers events to the subscribed endpoints in the background. Events are
+// queued for each subscribed endpoint by Dispatch and delivered by a pool of workers;
+// a delivery is attempted once and then retried up to Retries times, waiting Backoff
+// before the first retry and twice as long before each subsequent retry. Deliveries
+// that fail every attempt, that do not fit in the queue, or that are still queued when
+// the dispatcher is closed are handed to the dead-letter handler.
+type Dispatcher struct {
+	client     *http.Client
+	retries    int
+	backoff    time.Duration
+	deadLetter DeadLetterFunc
+
+	mu        sync.RWMutex
+	endpoints []Endpoint
+	closed    bool
+	queue     chan *delivery
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// delivery is the delivery of an event to one endpoint.
+type delivery struct {
+	endpoint Endpoint
+	event    *Event
+	body     []byte
+}
+
+// NewDispatcher starts a dispatcher with the number of workers and a queue of the size.
+// The client is used for every request and should have a timeout.
+func NewDispatcher(client *http.Client, workers, queueSize, retries int, backoff time.Duration, deadLetter DeadLetterFunc) *Dispatcher {
+	d := &Dispatcher{
+		client:     client,
+		retries:    retries,
+		backoff:    backoff,
+		deadLetter: deadLetter,
+		queue:      make(chan *delivery, queueSize),
+		stop:       make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+// SetEndpoints replaces the endpoints that events are delivered to, e.g. when the
+// configuration is reloaded. Events that are already queued are delivered to the
+// endpoints they were queued for.
+func (d *Dispatcher) SetEndpoints(endpoints []Endpoint) {
+	d.mu.Lock()
+	d.endpoints = endpoints
+	d.mu.Unlock()
+}
+
+// Dispatch queues the event for delivery to every endpoint that subscribes to it,
+// assigning it an ID and time if it does not have them. Dispatch does not block.
+func (d *Dispatcher) Dispatch(event *Event) {
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	body, err := json.Marshal(event)
+	for _, endpoint := range d.endpoints {
+		if !endpoint.Subscribes(event.Type) {
+			continue
+		}
+
+		switch {
+		case err != nil:
+			d.dead(endpoint, event, 0, err)
+		case d.closed:
+			d.dead(endpoint, event, 0, ErrClosed)
+		default:
+			select {
+			case d.queue <- &delivery{endpoint: endpoint, event: event, body: body}:
+			default:
+				d.dead(endpoint, event, 0, ErrQueueFull)
+			}
+		}
+	}
+}
+
+// Close stops the workers once their current delivery attempts are done and hands the
+// deliveries that have not been made to the dead-letter handler.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.stop)
+	d.mu.Unlock()
+
+	d.wg.Wait()
+	for {
+		select {
+		case dl := <-d.queue:
+			d.dead(dl.endpoint, dl.event, 0, ErrClosed)
+		default:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case dl := <-d.queue:
+			d.deliver(dl)
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// deliver attempts the delivery until it succeeds or the retries are exhausted.
+func (d *Dispatcher) deliver(dl *delivery) {
+	var err error
+	wait := d.backoff
+	for attempt := 1; ; attempt++ {
+		if err = post(context.Background(), d.client, dl.endpoint, dl.event, dl.body); err == nil {
+			return
+		}
+
+		if attempt > d.retries {
+			d.dead(dl.endpoint, dl.event, attempt, err)
+			return
+		}
+
+		select {
+		case <-time.After(wait):
+			wait *= 2
+		case <-d.stop:
+			d.dead(dl.endpoint, dl.event, attempt, err)
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) dead(endpoint Endpoint, event *Event, attempts int, err error) {
+	if d.deadLetter != nil {
+		d.deadLetter(endpoint.URL, event, attempts, err)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Package webhooks notifies the internal systems of the VASP about the lifecycle of
+Travel Rule transfers, e.g. when a transfer is received, approved, rejected, or
+expires, so that they can react without polling the node. Each Event is POSTed as JSON
+to the endpoints that subscribe to it by the Dispatcher, which signs the body with the
+HMAC secret of the endpoint, retries failed deliveries with exponential backoff, and
+hands deliveries that could not be made to a dead-letter handler so they are not lost.
+*/
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types of the events of the lifecycle of a transfer.
+const (
+	TransferReceived  = "transfer.received"
+	TransferApproved  = "transfer.approved"
+	TransferRejected  = "transfer.rejected"
+	TransferExpired   = "transfer.expired"
+	TransferClosed    = "transfer.closed"
+	TransferDelivered = "transfer.delivered"
+)
+
+// Events returns the types of the events that endpoints can subscribe to.
+func Events() []string {
+	return []string{TransferReceived, TransferApproved, TransferRejected, TransferExpired, TransferClosed, TransferDelivered}
+}
+
+// Headers of webhook requests. The signature header has the form t=<unix>,v1=<hex>,
+// where the signature is the HMAC-SHA256 of the timestamp, a period, and the body.
+const (
+	HeaderEvent     = "X-Trisarl-Event"
+	HeaderDelivery  = "X-Trisarl-Delivery"
+	HeaderSignature = "X-Trisarl-Signature"
+)
+
+// ErrInvalidSignature is returned by Verify if the signature of a webhook request does
+// not match its body.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Event is the body of a webhook request. The ID identifies the event so that receivers
+// can ignore deliveries of an event they have already processed.
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	EnvelopeID string    `json:"envelope_id"`
+	Peer       string    `json:"peer,omitempty"`
+	State      string    `json:"state,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// Endpoint is a URL that events are POSTed to, signed with the secret of the endpoint.
+// An endpoint without Events subscribes to every event.
+type Endpoint struct {
+	URL    string
+	Secret []byte
+	Events []string
+}
+
+// Subscribes returns true if events of the type are delivered to the endpoint.
+func (e Endpoint) Subscribes(event string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, subscribed := range e.Events {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the value of the signature header of the body sent at the timestamp.
+func Sign(secret []byte, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(signature(secret, ts, body))
+}
+
+// Verify checks the signature header of a webhook request against its body and ensures
+// that it was signed within the tolerance of now, so that requests cannot be replayed
+// later; a zero tolerance does not check the timestamp.
+func Verify(secret []byte, header string, body []byte, tolerance time.Duration) error {
+	var ts string
+	var sig []byte
+	for _, part := range strings.Split(header, ",") {
+		switch {
+		case strings.HasPrefix(part, "t="):
+			ts = strings.TrimPrefix(part, "t=")
+		case strings.HasPrefix(part, "v1="):
+			sig, _ = hex.DecodeString(strings.TrimPrefix(part, "v1="))
+		}
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sig) == 0 {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal(sig, signature(secret, ts, body)) {
+		return ErrInvalidSignature
+	}
+
+	if age := time.Since(time.Unix(unix, 0)); tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return fmt.Errorf("%w: signed %s ago", ErrInvalidSignature, age.Round(time.Second))
+	}
+	return nil
+}
+
+func signature(secret []byte, ts string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// post delivers the event to the endpoint; any response other than 2xx is an error.
+func post(ctx context.Context, client *http.Client, endpoint Endpoint, event *Event, body []byte) (err error) {
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body)); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderDelivery, event.ID)
+	req.Header.Set(HeaderSignature, Sign(endpoint.Secret, time.Now(), body))
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var rep *http.Response
+	if rep, err = client.Do(req); err != nil {
+		return fmt.Errorf("could not reach webhook endpoint: %s", err)
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode < 200 || rep.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(rep.Body, 512))
+		return fmt.Errorf("webhook endpoint returned %s: %s", rep.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// newEventID returns a random ID for an event.
+func newEventID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Errorf("could not generate event id: %s", err))
+	}
+	return hex.EncodeToString(id)
+}
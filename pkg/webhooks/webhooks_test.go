@@ -0,0 +1,101 @@
+package webhooks
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// The signature is the HMAC-SHA256 of "1700000000.{"id":"1"}" with the secret
+	sig := Sign([]byte("whsec"), time.Unix(1700000000, 0), []byte(`{"id":"1"}`))
+	if sig != "t=1700000000,v1=60734808e731b08d45bee887cade715d87211348f1bcb975b46c8d2e7fa5dbcd" {
+		t.Fatalf("unexpected signature %s", sig)
+	}
+
+	body := []byte(`{"id":"2"}`)
+	header := Sign([]byte("whsec"), time.Now(), body)
+	if err := Verify([]byte("whsec"), header, body, time.Minute); err != nil {
+		t.Fatalf("could not verify signature: %s", err)
+	}
+
+	for name, err := range map[string]error{
+		"tampered body":  Verify([]byte("whsec"), header, []byte(`{"id":"3"}`), time.Minute),
+		"wrong secret":   Verify([]byte("other"), header, body, time.Minute),
+		"expired":        Verify([]byte("whsec"), Sign([]byte("whsec"), time.Now().Add(-time.Hour), body), body, time.Minute),
+		"missing header": Verify([]byte("whsec"), "", body, 0),
+	} {
+		if !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected ErrInvalidSignature, got %v", name, err)
+		}
+	}
+
+	// A zero tolerance does not check the timestamp
+	if err := Verify([]byte("whsec"), sig, []byte(`{"id":"1"}`), 0); err != nil {
+		t.Errorf("expected an old signature without tolerance to verify, got %s", err)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	delivered := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := Verify([]byte("secret"), r.Header.Get(HeaderSignature), body, time.Minute); err != nil || r.Header.Get(HeaderEvent) != TransferReceived {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		// The first attempt fails so that the delivery is retried
+		mu.Lock()
+		defer mu.Unlock()
+		if attempts++; attempts == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		close(delivered)
+	}))
+	defer srv.Close()
+
+	dead := make(chan int, 2)
+	d := NewDispatcher(srv.Client(), 1, 4, 1, time.Millisecond, func(url string, event *Event, n int, err error) {
+		dead <- n
+	})
+	d.SetEndpoints([]Endpoint{
+		{URL: srv.URL, Secret: []byte("secret")},
+		{URL: srv.URL + "/expired", Secret: []byte("secret"), Events: []string{TransferExpired}},
+	})
+
+	d.Dispatch(&Event{Type: TransferReceived, EnvelopeID: "1"})
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event was not delivered")
+	}
+	d.Close()
+
+	mu.Lock()
+	if attempts != 2 {
+		t.Errorf("expected the delivery to succeed on the retry, got %d attempts", attempts)
+	}
+	mu.Unlock()
+
+	select {
+	case n := <-dead:
+		t.Fatalf("unexpected dead letter after %d attempts", n)
+	default:
+	}
+
+	// Events dispatched after the dispatcher is closed are dead-lettered
+	d.Dispatch(&Event{Type: TransferReceived, EnvelopeID: "2"})
+	if n := <-dead; n != 0 {
+		t.Errorf("expected the delivery not to be attempted, got %d attempts", n)
+	}
+}